@@ -1,20 +1,28 @@
 package main
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 
 	"os"
+	"os/signal"
+	"syscall"
 
-	"github.com/gorilla/mux"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
 	atlasbroker "github.com/mongodb/mongodb-atlas-service-broker/pkg/broker"
-	"github.com/pivotal-cf/brokerapi"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/server"
 )
 
 // releaseVersion should be set by the linker at compile time.
@@ -24,8 +32,6 @@ var releaseVersion = "development-build"
 const (
 	DefaultLogLevel = "INFO"
 
-	DefaultAtlasBaseURL = "https://cloud.mongodb.com"
-
 	DefaultServerHost = "127.0.0.1"
 	DefaultServerPort = 4000
 )
@@ -55,6 +61,15 @@ func main() {
 		return
 	}
 
+	switch flag.Arg(0) {
+	case "reconcile":
+		runReconcile(flag.Args()[1:])
+		return
+	case "migrate-labels":
+		runMigrateLabels(flag.Args()[1:])
+		return
+	}
+
 	startBrokerServer()
 }
 
@@ -82,26 +97,91 @@ func startBrokerServer() {
 	}
 	defer logger.Sync() // Flushes buffer, if any
 
-	// Administrators can control what providers/plans are available to users
-	pathToWhitelistFile, hasWhitelist := os.LookupEnv("PROVIDERS_WHITELIST_FILE")
-	var broker *atlasbroker.Broker
-	if !hasWhitelist {
-		broker = atlasbroker.NewBroker(logger)
-	} else {
-		whitelist, err := atlasbroker.ReadWhitelistFile(pathToWhitelistFile)
+	opts := brokerOptionsFromEnv()
+
+	// Metrics are collected if they are served.
+	metricsEnabled := getBoolEnvOrDefault("BROKER_METRICS", false)
+	registry := metrics.NewRegistry()
+	if metricsEnabled {
+		opts = append(opts, atlasbroker.WithMetricsRegistry(registry))
+	}
+
+	broker, err := atlasbroker.New(logger, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	// Instances and operations created by older versions are handled by these.
+	logger.Infow("Compatibility shims active", "shims", broker.CompatibilityShims())
+	logger.Infow("Broker features", "features", broker.Features())
+
+	// Endpoint groups can be moved to newer versions of the Atlas API.
+	endpointVersions, err := atlas.ParseEndpointVersions(getEnvOrDefault("ATLAS_ENDPOINT_VERSIONS", ""))
+	if err != nil {
+		panic(err)
+	}
+
+	clientOpts := []atlas.ClientOption{}
+	for group, version := range endpointVersions {
+		clientOpts = append(clientOpts, atlas.WithEndpointVersion(group, version))
+	}
+
+	// Warm pools are filled at startup if the broker has credentials for the
+	// project, otherwise only after the first claim. The credentials are
+	// also used to detect which API versions Atlas serves, all of them are
+	// assumed to be available otherwise.
+	baseURL := strings.TrimRight(getEnvOrDefault("ATLAS_BASE_URL", server.DefaultAtlasBaseURL), "/")
+	if groupID, hasGroupID := os.LookupEnv("ATLAS_GROUP_ID"); hasGroupID {
+		client := atlas.NewClient(baseURL, groupID, getEnvOrPanic("ATLAS_PUBLIC_KEY"), getEnvOrPanic("ATLAS_PRIVATE_KEY"), atlas.WithRateLimitObserver(broker.ObserveRateLimit))
+
+		versions, err := client.DetectAPIVersions()
 		if err != nil {
-			panic(err)
+			logger.Warnw("Failed to detect Atlas API versions, assuming all are served", "error", err)
+		} else {
+			names := []string{}
+			for _, version := range versions {
+				names = append(names, version.Name)
+			}
+			logger.Infow("Detected Atlas API versions", "versions", names)
+
+			clientOpts = append(clientOpts, atlas.WithAPIVersions(versions))
+		}
+
+		for _, opt := range clientOpts {
+			opt(client)
 		}
-		broker = atlasbroker.NewBrokerWithWhitelist(logger, whitelist)
+
+		go func() {
+			if err := broker.ReplenishPool(client); err != nil {
+				logger.Errorw("Failed to fill warm pool", "error", err)
+			}
+		}()
 	}
 
-	router := mux.NewRouter()
-	brokerapi.AttachRoutes(router, broker, NewLagerZapLogger(logger))
+	// SIGUSR2 toggles the maintenance mode without restarting the broker.
+	maintenanceSignals := make(chan os.Signal, 1)
+	signal.Notify(maintenanceSignals, syscall.SIGUSR2)
+	go func() {
+		for range maintenanceSignals {
+			broker.ToggleMaintenance()
+		}
+	}()
+
+	handlerOpts := []server.Option{
+		server.WithVersion(releaseVersion),
+		server.WithAtlasBaseURL(baseURL),
+		server.WithDashboardBaseURL(getEnvOrDefault("ATLAS_DASHBOARD_URL", "")),
+		server.WithLogger(logger),
+		server.WithMaxBodyBytes(int64(getIntEnvOrDefault("BROKER_MAX_BODY_BYTES", server.DefaultMaxBodyBytes))),
+		server.WithAtlasClientOptions(clientOpts...),
+	}
+
+	// Metrics are served without authentication next to the broker API.
+	if metricsEnabled {
+		handlerOpts = append(handlerOpts, server.WithMetrics(registry))
+	}
 
-	// The auth middleware will convert basic auth credentials into an Atlas
-	// client.
-	baseURL := strings.TrimRight(getEnvOrDefault("ATLAS_BASE_URL", DefaultAtlasBaseURL), "/")
-	router.Use(atlasbroker.AuthMiddleware(baseURL))
+	handler := server.NewHandler(broker, handlerOpts...)
 
 	// Configure TLS from environment variables.
 	tlsEnabled, tlsCertPath, tlsKeyPath := getTLSConfig(logger)
@@ -110,6 +190,7 @@ func startBrokerServer() {
 	port := getIntEnvOrDefault("BROKER_PORT", DefaultServerPort)
 
 	// Replace with NONE if not set
+	pathToWhitelistFile, hasWhitelist := os.LookupEnv("PROVIDERS_WHITELIST_FILE")
 	if !hasWhitelist {
 		pathToWhitelistFile = "NONE"
 	}
@@ -120,10 +201,10 @@ func startBrokerServer() {
 
 	var serverErr error
 	if tlsEnabled {
-		serverErr = http.ListenAndServeTLS(address, tlsCertPath, tlsKeyPath, router)
+		serverErr = http.ListenAndServeTLS(address, tlsCertPath, tlsKeyPath, handler)
 	} else {
 		logger.Warn("TLS is disabled")
-		serverErr = http.ListenAndServe(address, router)
+		serverErr = http.ListenAndServe(address, handler)
 	}
 
 	if serverErr != nil {
@@ -131,6 +212,232 @@ func startBrokerServer() {
 	}
 }
 
+// brokerOptionsFromEnv returns the broker options configured through the
+// environment. The subcommands use them as well, so they map resources onto
+// instances and bindings the same way as the server.
+func brokerOptionsFromEnv() []atlasbroker.Option {
+	opts := []atlasbroker.Option{
+		atlasbroker.WithStrictPreviousValues(getBoolEnvOrDefault("BROKER_STRICT_PREVIOUS_VALUES", false)),
+		atlasbroker.WithStrictBindingPlans(getBoolEnvOrDefault("BROKER_STRICT_BINDING_PLANS", true)),
+		atlasbroker.WithDefaultPlatform(getEnvOrDefault("BROKER_DEFAULT_PLATFORM", "")),
+		atlasbroker.WithCredentialStyle(getEnvOrDefault("BROKER_CREDENTIAL_STYLE", "")),
+		atlasbroker.WithLegacyCredentialKeys(getBoolEnvOrDefault("BROKER_LEGACY_CREDENTIAL_KEYS", false)),
+		atlasbroker.WithUserPasswords(getBoolEnvOrDefault("BROKER_ALLOW_USER_PASSWORDS", false)),
+		atlasbroker.WithPasswordPolicy(atlasbroker.PasswordPolicy{
+			Length:       getIntEnvOrDefault("BROKER_PASSWORD_LENGTH", 0),
+			Alphanumeric: getBoolEnvOrDefault("BROKER_PASSWORD_ALPHANUMERIC", false),
+		}),
+		atlasbroker.WithIDPrefix(getEnvOrDefault("BROKER_ID_PREFIX", atlasbroker.DefaultIDPrefix)),
+		atlasbroker.WithDefaultIDCompatibility(getBoolEnvOrDefault("BROKER_ACCEPT_DEFAULT_IDS", false)),
+		atlasbroker.WithMinimumTLSProtocol(getEnvOrDefault("BROKER_MINIMUM_TLS_PROTOCOL", "")),
+		atlasbroker.WithAccessListCleanup(getBoolEnvOrDefault("BROKER_ACCESS_LIST_CLEANUP", false)),
+		atlasbroker.WithEventBufferSize(getIntEnvOrDefault("BROKER_EVENT_BUFFER_SIZE", atlasbroker.DefaultEventBufferSize)),
+	}
+
+	if usernameTemplate := getEnvOrDefault("BROKER_USERNAME_TEMPLATE", ""); usernameTemplate != "" {
+		opts = append(opts, atlasbroker.WithUsernameTemplate(usernameTemplate))
+	}
+
+	if appNameTemplate := getEnvOrDefault("BROKER_APP_NAME_TEMPLATE", ""); appNameTemplate != "" {
+		opts = append(opts, atlasbroker.WithAppNameTemplate(appNameTemplate))
+	}
+
+	if policy := getEnvOrDefault("BROKER_DOWNGRADE_POLICY", ""); policy != "" {
+		opts = append(opts, atlasbroker.WithDowngradePolicy(policy))
+	}
+
+	// Optionally check whether clusters have connections left for new
+	// bindings.
+	if connections := getIntEnvOrDefault("BROKER_BINDING_CONNECTIONS", 0); connections > 0 {
+		opts = append(opts, atlasbroker.WithBindingCapacityCheck(connections, getEnvOrDefault("BROKER_BINDING_CAPACITY_POLICY", atlasbroker.CapacityPolicyWarn)))
+	}
+
+	// Optionally hold provisions until the new cluster is reachable.
+	if getBoolEnvOrDefault("BROKER_CONNECTION_PROBE", false) {
+		opts = append(opts, atlasbroker.WithConnectionProbe(atlasbroker.DefaultConnectionProbeMaxWait))
+	}
+
+	// Optionally hold binds until the new database user can authenticate.
+	if getBoolEnvOrDefault("BROKER_WAIT_FOR_USER", false) {
+		opts = append(opts, atlasbroker.WithWaitForUser(atlasbroker.DefaultUserWaitTimeout))
+	}
+
+	// Optionally keep binding credentials so platforms can fetch them again.
+	if kind := getEnvOrDefault("BROKER_CREDENTIAL_STORE", ""); kind != "" {
+		store, err := createCredentialStore(kind, getEnvOrDefault("BROKER_CREDENTIAL_STORE_URI", ""))
+		if err != nil {
+			panic(err)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(getEnvOrDefault("BROKER_CREDENTIAL_STORE_KEY", ""))
+		if err != nil {
+			panic(fmt.Errorf("BROKER_CREDENTIAL_STORE_KEY: %v", err))
+		}
+		opts = append(opts, atlasbroker.WithCredentialStore(store, key))
+	}
+
+	// Optionally slow down polling when the Atlas rate limit budget of a
+	// project runs low.
+	if threshold := getIntEnvOrDefault("BROKER_ADAPTIVE_POLLING_THRESHOLD", 0); threshold > 0 {
+		opts = append(opts, atlasbroker.WithAdaptivePolling(threshold, atlasbroker.DefaultAdaptivePollingInterval))
+	}
+
+	// Bound the synchronous phase of provisions so platforms don't retry
+	// while the broker is still waiting for Atlas.
+	provisionTimeout := getIntEnvOrDefault("BROKER_PROVISION_TIMEOUT", int(atlasbroker.DefaultProvisionTimeout/time.Second))
+	opts = append(opts, atlasbroker.WithProvisionTimeout(time.Duration(provisionTimeout)*time.Second))
+
+	// Operations still in progress after the timeout fail, clusters stuck in
+	// Atlas would be polled forever otherwise.
+	if operationTimeout := getIntEnvOrDefault("BROKER_OPERATION_TIMEOUT", 0); operationTimeout > 0 {
+		opts = append(opts, atlasbroker.WithOperationTimeout(time.Duration(operationTimeout)*time.Minute))
+	}
+
+	// Limit the instance sizes offered in the catalog and accepted by the
+	// broker.
+	if sizes := getEnvOrDefault("BROKER_ALLOWED_INSTANCE_SIZES", ""); sizes != "" {
+		opts = append(opts, atlasbroker.WithAllowedInstanceSizes(strings.Split(sizes, ",")...))
+	}
+
+	// MongoDB versions Atlas added after the release of the broker can be
+	// allowed without an upgrade.
+	if versions := getEnvOrDefault("BROKER_MONGODB_MAJOR_VERSIONS", ""); versions != "" {
+		opts = append(opts, atlasbroker.WithMongoDBMajorVersions(strings.Split(versions, ",")...))
+	}
+
+	// Administrators can control what providers/plans are available to users
+	if pathToWhitelistFile, hasWhitelist := os.LookupEnv("PROVIDERS_WHITELIST_FILE"); hasWhitelist {
+		whitelist, err := atlasbroker.ReadWhitelistFile(pathToWhitelistFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, atlasbroker.WithWhitelist(whitelist))
+	}
+
+	// Further settings can be provided through a config file.
+	if pathToConfigFile, hasConfig := os.LookupEnv("BROKER_CONFIG_FILE"); hasConfig {
+		config, err := atlasbroker.ReadConfigFile(pathToConfigFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, config.Options()...)
+	}
+
+	// The generated catalog can be customized by operators.
+	if pathToCatalogFile, hasCatalog := os.LookupEnv("BROKER_CATALOG_FILE"); hasCatalog {
+		override, err := atlasbroker.ReadCatalogOverrideFile(pathToCatalogFile)
+		if err != nil {
+			panic(err)
+		}
+		opts = append(opts, atlasbroker.WithCatalogOverride(*override))
+	}
+
+	// Changes can be blocked while the broker keeps serving the catalog and
+	// polls, for example during Atlas organization migrations.
+	opts = append(opts,
+		atlasbroker.WithMaintenance(getBoolEnvOrDefault("BROKER_MAINTENANCE", false)),
+		atlasbroker.WithMaintenanceMessage(
+			getEnvOrDefault("BROKER_MAINTENANCE_MESSAGE", atlasbroker.DefaultMaintenanceMessage),
+			time.Duration(getIntEnvOrDefault("BROKER_MAINTENANCE_RETRY_AFTER", int(atlasbroker.DefaultMaintenanceRetryAfter/time.Second)))*time.Second,
+		),
+	)
+
+	return opts
+}
+
+// runReconcile compares the clusters and users in an Atlas project against the
+// instances and bindings known to the platform and prints a JSON report.
+func runReconcile(args []string) {
+	flags := flag.NewFlagSet("reconcile", flag.ExitOnError)
+	knownFile := flags.String("known", "", "Path to a JSON file listing the known instanceIds and bindingIds.")
+	fix := flags.Bool("fix", false, "Delete orphaned database users carrying the broker's labels. Clusters are never deleted.")
+	fixLegacyUsers := flags.Bool("fix-legacy-users", false, "With --fix, also delete orphaned unlabeled users named after a UUID.")
+	flags.Parse(args)
+
+	logLevel := getEnvOrDefault("BROKER_LOG_LEVEL", DefaultLogLevel)
+	logger, err := createLogger(logLevel)
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync() // Flushes buffer, if any
+
+	opts := atlasbroker.ReconcileOptions{Fix: *fix, FixLegacyUsers: *fixLegacyUsers}
+	if *knownFile != "" {
+		known, err := atlasbroker.ReadKnownResourcesFile(*knownFile)
+		if err != nil {
+			panic(err)
+		}
+		opts.Known = known
+	}
+
+	broker, err := atlasbroker.New(logger, brokerOptionsFromEnv()...)
+	if err != nil {
+		panic(err)
+	}
+
+	report, err := broker.Reconcile(atlasContextFromEnv(), opts)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	printJSON(report)
+}
+
+// runMigrateLabels adds the instance and binding ID labels to the resources
+// created by older broker versions and prints a JSON report of the changes.
+func runMigrateLabels(args []string) {
+	flags := flag.NewFlagSet("migrate-labels", flag.ExitOnError)
+	knownFile := flags.String("known", "", "Path to a JSON file listing the known instanceIds, needed to label clusters.")
+	dryRun := flags.Bool("dry-run", false, "Report the labels which would be added without changing anything.")
+	flags.Parse(args)
+
+	logLevel := getEnvOrDefault("BROKER_LOG_LEVEL", DefaultLogLevel)
+	logger, err := createLogger(logLevel)
+	if err != nil {
+		panic(err)
+	}
+	defer logger.Sync() // Flushes buffer, if any
+
+	opts := atlasbroker.LabelMigrationOptions{DryRun: *dryRun}
+	if *knownFile != "" {
+		known, err := atlasbroker.ReadKnownResourcesFile(*knownFile)
+		if err != nil {
+			panic(err)
+		}
+		opts.Known = known
+	}
+
+	broker, err := atlasbroker.New(logger, brokerOptionsFromEnv()...)
+	if err != nil {
+		panic(err)
+	}
+
+	report, err := broker.MigrateLabels(atlasContextFromEnv(), opts)
+	if err != nil {
+		logger.Fatal(err)
+	}
+
+	printJSON(report)
+}
+
+// atlasContextFromEnv returns a context carrying an Atlas client for the
+// project and API key in the environment, as used by the subcommands.
+func atlasContextFromEnv() context.Context {
+	baseURL := strings.TrimRight(getEnvOrDefault("ATLAS_BASE_URL", server.DefaultAtlasBaseURL), "/")
+	client := atlas.NewClient(baseURL, getEnvOrPanic("ATLAS_GROUP_ID"), getEnvOrPanic("ATLAS_PUBLIC_KEY"), getEnvOrPanic("ATLAS_PRIVATE_KEY"))
+	return context.WithValue(context.Background(), atlasbroker.ContextKeyAtlasClient, client)
+}
+
+// printJSON prints the indented JSON encoding of a report.
+func printJSON(report interface{}) {
+	output, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(string(output))
+}
+
 func getTLSConfig(logger *zap.SugaredLogger) (bool, string, string) {
 	certPath := getEnvOrDefault("BROKER_TLS_CERT_FILE", "")
 	keyPath := getEnvOrDefault("BROKER_TLS_KEY_FILE", "")
@@ -157,6 +464,27 @@ func getEnvOrPanic(name string) string {
 	return value
 }
 
+// createCredentialStore creates the credential store of a kind, either
+// "memory" or "mongodb" which keeps the credentials in the deployment of a
+// MongoDB URI.
+func createCredentialStore(kind string, uri string) (atlasbroker.CredentialStore, error) {
+	switch kind {
+	case "memory":
+		return atlasbroker.NewMemoryCredentialStore(), nil
+	case "mongodb":
+		if uri == "" {
+			return nil, errors.New("BROKER_CREDENTIAL_STORE_URI is required by the mongodb credential store")
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		return atlasbroker.ConnectMongoCredentialStore(ctx, uri)
+	}
+
+	return nil, fmt.Errorf(`unknown credential store "%s", must be memory or mongodb`, kind)
+}
+
 // getEnvOrDefault will try getting an environment variable and return a default
 // value in case it doesn't exist.
 func getEnvOrDefault(name string, def string) string {
@@ -184,6 +512,22 @@ func getIntEnvOrDefault(name string, def int) int {
 	return intValue
 }
 
+// getBoolEnvOrDefault will try getting an environment variable and parse it as
+// a boolean. In case the variable is not set it will return the default value.
+func getBoolEnvOrDefault(name string, def bool) bool {
+	value, exists := os.LookupEnv(name)
+	if !exists {
+		return def
+	}
+
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		panic(fmt.Sprintf(`Environment variable "%s" is not a boolean`, name))
+	}
+
+	return boolValue
+}
+
 // createLogger will create a zap sugared logger with the specified log level.
 func createLogger(levelName string) (*zap.SugaredLogger, error) {
 	levelByName := map[string]zapcore.Level{
@@ -0,0 +1,65 @@
+package atlas
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// AccessListEntry represents an entry of the project IP access list, which
+// was called the whitelist in earlier versions of the Atlas API. Either the
+// CIDR block or the IP address is set.
+type AccessListEntry struct {
+	CIDRBlock string `json:"cidrBlock,omitempty" description:"Range of addresses allowed to connect, for example 10.0.0.0/8."`
+	IPAddress string `json:"ipAddress,omitempty" description:"Single address allowed to connect."`
+	Comment   string `json:"comment,omitempty" description:"Comment describing the entry."`
+}
+
+// Entry returns the CIDR block or IP address of the entry, whichever is set.
+func (e AccessListEntry) Entry() string {
+	if e.CIDRBlock != "" {
+		return e.CIDRBlock
+	}
+
+	return e.IPAddress
+}
+
+// CreateAccessListEntries will add entries to the project IP access list.
+// Entries which already exist are updated. All entries of the access list
+// are returned.
+// POST /accessList
+func (c *HTTPClient) CreateAccessListEntries(entries []AccessListEntry) ([]AccessListEntry, error) {
+	var response struct {
+		Results []AccessListEntry `json:"results"`
+	}
+
+	err := c.requestPublic(http.MethodPost, "accessList", entries, &response)
+	return response.Results, err
+}
+
+// ListAccessListEntries will return all entries of the project IP access
+// list.
+// GET /accessList
+func (c *HTTPClient) ListAccessListEntries() ([]AccessListEntry, error) {
+	entries := []AccessListEntry{}
+	err := c.listPublic("accessList", func(data json.RawMessage) (int, error) {
+		var page []AccessListEntry
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, newDecodeError(err)
+		}
+
+		entries = append(entries, page...)
+		return len(page), nil
+	})
+
+	return entries, err
+}
+
+// DeleteAccessListEntry will remove a CIDR block or IP address from the
+// project IP access list.
+// DELETE /accessList/{ENTRY}
+func (c *HTTPClient) DeleteAccessListEntry(entry string) error {
+	path := fmt.Sprintf("accessList/%s", url.PathEscape(entry))
+	return c.requestPublic(http.MethodDelete, path, nil, nil)
+}
@@ -0,0 +1,56 @@
+package atlas
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCreateAccessListEntries(t *testing.T) {
+	expected := []AccessListEntry{
+		AccessListEntry{CIDRBlock: "10.0.0.0/8", Comment: "platform"},
+		AccessListEntry{IPAddress: "192.0.2.1"},
+	}
+
+	response := map[string]interface{}{
+		"results":    expected,
+		"totalCount": len(expected),
+	}
+
+	atlas, server := setupTest(t, "/accessList", http.MethodPost, 201, response)
+	defer server.Close()
+
+	entries, err := atlas.CreateAccessListEntries(expected)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, entries)
+}
+
+func TestListAccessListEntries(t *testing.T) {
+	expected := []AccessListEntry{
+		AccessListEntry{CIDRBlock: "10.0.0.0/8", Comment: "platform"},
+	}
+
+	response := map[string]interface{}{
+		"results":    expected,
+		"totalCount": len(expected),
+	}
+
+	atlas, server := setupTest(t, "/accessList?pageNum=1&itemsPerPage=500", http.MethodGet, 200, response)
+	defer server.Close()
+
+	entries, err := atlas.ListAccessListEntries()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, entries)
+}
+
+func TestDeleteAccessListEntry(t *testing.T) {
+	atlas, server := setupTest(t, "/accessList/10.0.0.0%2F8", http.MethodDelete, 204, nil)
+	defer server.Close()
+
+	err := atlas.DeleteAccessListEntry("10.0.0.0/8")
+
+	assert.NoError(t, err)
+}
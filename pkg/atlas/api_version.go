@@ -0,0 +1,143 @@
+package atlas
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// APIVersion is a version of the Atlas API: the path its endpoints are served
+// under and the Accept header selecting it.
+type APIVersion struct {
+	Name   string
+	Path   string
+	Accept string
+}
+
+// The API versions known to the client. Endpoints use APIVersion1_0 unless
+// configured otherwise with WithEndpointVersion.
+var (
+	APIVersion1_0 = APIVersion{Name: "v1.0", Path: publicAPIPath, Accept: "application/json"}
+	APIVersion1_5 = APIVersion{Name: "v1.5", Path: "/api/atlas/v1.5", Accept: "application/json"}
+	APIVersion2   = APIVersion{Name: "v2", Path: "/api/atlas/v2", Accept: "application/vnd.atlas.2023-02-01+json"}
+)
+
+// APIVersions lists the known API versions from the oldest to the newest.
+var APIVersions = []APIVersion{APIVersion1_0, APIVersion1_5, APIVersion2}
+
+// ErrAPIVersionUnsupported is returned for requests to an API version the
+// Atlas deployment doesn't serve, for example newer versions on Atlas for
+// Government. The request isn't sent.
+var ErrAPIVersionUnsupported = errors.New("API version not supported by Atlas")
+
+// APIVersionByName returns the known API version with a name such as "v1.5".
+func APIVersionByName(name string) (APIVersion, bool) {
+	for _, version := range APIVersions {
+		if version.Name == name {
+			return version, true
+		}
+	}
+
+	return APIVersion{}, false
+}
+
+// ParseEndpointVersions parses a comma-separated list of endpoint groups and
+// the API version serving them, for example "clusters=v1.5,databaseUsers=v2".
+func ParseEndpointVersions(s string) (map[string]APIVersion, error) {
+	versions := map[string]APIVersion{}
+
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid endpoint version %q, expected <group>=<version>", entry)
+		}
+
+		version, ok := APIVersionByName(parts[1])
+		if !ok {
+			return nil, fmt.Errorf("unknown API version %q for endpoint group %q", parts[1], parts[0])
+		}
+
+		versions[parts[0]] = version
+	}
+
+	return versions, nil
+}
+
+// WithEndpointVersion serves an endpoint group from another API version. The
+// group is the first segment of the endpoints below the project, for example
+// "clusters" or "databaseUsers". The request and response bodies of the
+// group have to be compatible across the versions.
+func WithEndpointVersion(group string, version APIVersion) ClientOption {
+	return func(c *HTTPClient) {
+		if c.endpointVersions == nil {
+			c.endpointVersions = map[string]APIVersion{}
+		}
+
+		c.endpointVersions[group] = version
+	}
+}
+
+// WithAPIVersions limits the client to the API versions the Atlas deployment
+// serves, as found by DetectAPIVersions. Requests to any other version fail
+// with ErrAPIVersionUnsupported. All versions are assumed to be served
+// otherwise.
+func WithAPIVersions(supported []APIVersion) ClientOption {
+	return func(c *HTTPClient) {
+		c.supportedVersions = map[string]bool{}
+		for _, version := range supported {
+			c.supportedVersions[version.Name] = true
+		}
+	}
+}
+
+// SupportsAPIVersion returns whether requests to an API version are sent.
+func (c *HTTPClient) SupportsAPIVersion(version APIVersion) bool {
+	return c.supportedVersions == nil || c.supportedVersions[version.Name]
+}
+
+// endpointVersion returns the API version serving an endpoint below the
+// project.
+func (c *HTTPClient) endpointVersion(endpoint string) APIVersion {
+	group := endpoint
+	if i := strings.IndexAny(group, "/?"); i >= 0 {
+		group = group[:i]
+	}
+
+	if version, ok := c.endpointVersions[group]; ok {
+		return version
+	}
+
+	return APIVersion1_0
+}
+
+// DetectAPIVersions probes which of the known API versions the Atlas
+// deployment serves by fetching the project from each of them. Versions
+// responding with 404 Not Found or 406 Not Acceptable aren't served, any
+// other failure is returned.
+// GET /groups/{GROUP-ID}
+func (c *HTTPClient) DetectAPIVersions() ([]APIVersion, error) {
+	supported := []APIVersion{}
+
+	for _, version := range APIVersions {
+		url := fmt.Sprintf("%s%s/groups/%s", c.BaseURL, version.Path, c.GroupID)
+		err := c.request(http.MethodGet, url, version.Accept, nil, nil)
+
+		if apiErr, ok := err.(*APIError); ok && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusNotAcceptable) {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		supported = append(supported, version)
+	}
+
+	return supported, nil
+}
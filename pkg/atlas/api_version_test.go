@@ -0,0 +1,116 @@
+package atlas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpointVersions(t *testing.T) {
+	versions, err := ParseEndpointVersions("clusters=v1.5, databaseUsers=v2,")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]APIVersion{"clusters": APIVersion1_5, "databaseUsers": APIVersion2}, versions)
+
+	versions, err = ParseEndpointVersions("")
+	assert.NoError(t, err)
+	assert.Empty(t, versions)
+
+	_, err = ParseEndpointVersions("clusters")
+	assert.Error(t, err)
+
+	_, err = ParseEndpointVersions("clusters=v3")
+	assert.Error(t, err)
+}
+
+func TestWithEndpointVersion(t *testing.T) {
+	var path, accept string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.Header["Authorization"]) == 0 {
+			rw.WriteHeader(401)
+			return
+		}
+
+		path = req.URL.Path
+		accept = req.Header.Get("Accept")
+		rw.Write([]byte(`{"name": "Cluster"}`))
+	}))
+	defer s.Close()
+
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey", WithEndpointVersion("clusters", APIVersion2))
+	atlas.HTTP = s.Client()
+
+	_, err := atlas.GetCluster("Cluster")
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/atlas/v2/groups/group/clusters/Cluster", path)
+	assert.Equal(t, APIVersion2.Accept, accept)
+
+	// Other groups stay on v1.0.
+	_, err = atlas.GetUser("user")
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/atlas/v1.0/groups/group/databaseUsers/admin/user", path)
+	assert.Equal(t, "application/json", accept)
+}
+
+func TestWithAPIVersions(t *testing.T) {
+	requests := 0
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		requests++
+		rw.WriteHeader(500)
+	}))
+	defer s.Close()
+
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey", WithAPIVersions([]APIVersion{APIVersion1_0}), WithEndpointVersion("clusters", APIVersion2))
+	atlas.HTTP = s.Client()
+
+	assert.True(t, atlas.SupportsAPIVersion(APIVersion1_0))
+	assert.False(t, atlas.SupportsAPIVersion(APIVersion2))
+
+	_, err := atlas.GetCluster("Cluster")
+	assert.Equal(t, ErrAPIVersionUnsupported, err)
+	assert.Equal(t, 0, requests, "Expected no request to be sent")
+
+	assert.True(t, NewClient(s.URL, "group", "pubkey", "privkey").SupportsAPIVersion(APIVersion2))
+}
+
+func TestDetectAPIVersions(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.Header["Authorization"]) == 0 {
+			rw.WriteHeader(401)
+			return
+		}
+
+		switch {
+		case strings.HasPrefix(req.URL.Path, "/api/atlas/v1.5/"):
+			rw.WriteHeader(404)
+		case req.Header.Get("Accept") == APIVersion2.Accept:
+			rw.WriteHeader(406)
+			rw.Write([]byte(`{"errorCode": "INVALID_VERSION_DATE", "detail": "Unsupported version."}`))
+		default:
+			rw.Write([]byte(`{"id": "group"}`))
+		}
+	}))
+	defer s.Close()
+
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey")
+	atlas.HTTP = s.Client()
+
+	versions, err := atlas.DetectAPIVersions()
+	assert.NoError(t, err)
+	assert.Equal(t, []APIVersion{APIVersion1_0}, versions)
+}
+
+func TestDetectAPIVersionsFailure(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.WriteHeader(500)
+	}))
+	defer s.Close()
+
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey")
+	atlas.HTTP = s.Client()
+
+	_, err := atlas.DetectAPIVersions()
+	assert.Error(t, err)
+}
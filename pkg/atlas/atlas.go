@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strings"
 )
 
 // Client is an interface for interacting with the Atlas API.
@@ -16,13 +18,29 @@ type Client interface {
 	UpdateCluster(cluster Cluster) (*Cluster, error)
 	DeleteCluster(name string) error
 	GetCluster(name string) (*Cluster, error)
+	ListClusters() ([]Cluster, error)
 	GetDashboardURL(clusterName string) string
+	GetProcessArgs(clusterName string) (*ProcessArgs, error)
+	UpdateProcessArgs(clusterName string, args ProcessArgs) (*ProcessArgs, error)
 
 	CreateUser(user User) (*User, error)
 	GetUser(name string) (*User, error)
-	DeleteUser(name string) error
+	ListUsers() ([]User, error)
+	UpdateUser(user User) (*User, error)
+	DeleteUser(databaseName string, name string) error
+	CreateUserCertificate(name string, monthsUntilExpiration int) (string, error)
+
+	CreateAccessListEntries(entries []AccessListEntry) ([]AccessListEntry, error)
+	ListAccessListEntries() ([]AccessListEntry, error)
+	DeleteAccessListEntry(entry string) error
+
+	GetEncryptionAtRest() (*EncryptionAtRest, error)
+	UpdateEncryptionAtRest(encryption EncryptionAtRest) (*EncryptionAtRest, error)
 
 	GetProvider(name string) (*Provider, error)
+	GetProjectStatus() (*ProjectStatus, error)
+
+	ListClusterEvents(clusterName string, limit int) ([]Event, error)
 }
 
 // HTTPClient is the main implementation of the Client interface which
@@ -33,7 +51,31 @@ type HTTPClient struct {
 	PublicKey  string
 	PrivateKey string
 
+	// DashboardBaseURL is the base of the Atlas UI used for dashboard URLs.
+	// BaseURL is used if empty, which is the case for the public Atlas.
+	DashboardBaseURL string
+
 	HTTP *http.Client
+
+	rateLimitObserver func(groupID string, limit RateLimit)
+
+	// endpointVersions maps endpoint groups onto the API version serving
+	// them, supportedVersions holds the names of the versions the
+	// deployment serves. Both are set through client options.
+	endpointVersions  map[string]APIVersion
+	supportedVersions map[string]bool
+}
+
+// ClientOption configures optional behaviour of clients created by NewClient.
+type ClientOption func(*HTTPClient)
+
+// WithDashboardBaseURL sets the base of the dashboard URLs for deployments
+// whose UI isn't served from the API's base URL, for example Atlas for
+// Government.
+func WithDashboardBaseURL(baseURL string) ClientOption {
+	return func(c *HTTPClient) {
+		c.DashboardBaseURL = strings.TrimRight(baseURL, "/")
+	}
 }
 
 // Different errors the api may return.
@@ -49,39 +91,102 @@ var (
 	ErrUserAlreadyExists = errors.New("User already exists")
 )
 
+// APIError is returned for failed requests which don't map onto one of the
+// errors above. StatusCode is the HTTP status of the Atlas response.
+type APIError struct {
+	StatusCode  int
+	Code        string
+	Description string
+}
+
+func (e *APIError) Error() string {
+	if e.Code == "" {
+		return fmt.Sprintf("atlas error: %d %s", e.StatusCode, e.Description)
+	}
+
+	return fmt.Sprintf("atlas error: [%s] %s", e.Code, e.Description)
+}
+
 const (
 	publicAPIPath  = "/api/atlas/v1.0"
 	privateAPIPath = "/api/private/unauth"
 )
 
 // NewClient will create a new HTTPClient with the specified connection details.
-func NewClient(baseURL string, groupID string, publicKey string, privateKey string) *HTTPClient {
-	return &HTTPClient{
+func NewClient(baseURL string, groupID string, publicKey string, privateKey string, opts ...ClientOption) *HTTPClient {
+	c := &HTTPClient{
 		BaseURL:    baseURL,
 		GroupID:    groupID,
 		PublicKey:  publicKey,
 		PrivateKey: privateKey,
 		HTTP:       &http.Client{},
 	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	return c
 }
 
 // requestPublic will make a request to an endpoint in the public API.
 // The URL will be constructed by prepending the group to the specified endpoint.
+// The API version is the one configured for the endpoint's group.
 func (c *HTTPClient) requestPublic(method string, endpoint string, body interface{}, response interface{}) error {
-	url := fmt.Sprintf("%s%s/groups/%s/%s", c.BaseURL, publicAPIPath, c.GroupID, endpoint)
-	return c.request(method, url, body, response)
+	return c.requestVersion(c.endpointVersion(endpoint), method, endpoint, body, response)
+}
+
+// requestVersion will make a request to an endpoint in a specific version of
+// the public API. Methods only available in newer versions use it directly.
+func (c *HTTPClient) requestVersion(version APIVersion, method string, endpoint string, body interface{}, response interface{}) error {
+	if !c.SupportsAPIVersion(version) {
+		return ErrAPIVersionUnsupported
+	}
+
+	url := fmt.Sprintf("%s%s/groups/%s/%s", c.BaseURL, version.Path, c.GroupID, endpoint)
+	return c.request(method, url, version.Accept, body, response)
+}
+
+// listPageSize is the number of items requested per page from list endpoints.
+// This is the maximum allowed by the Atlas API.
+const listPageSize = 500
+
+// listPublic will fetch all pages of a list endpoint in the public API. The
+// results of each page are passed to appendPage which should return the
+// number of items it received.
+func (c *HTTPClient) listPublic(endpoint string, appendPage func(data json.RawMessage) (int, error)) error {
+	for page := 1; ; page++ {
+		var response struct {
+			Results    json.RawMessage `json:"results"`
+			TotalCount int             `json:"totalCount"`
+		}
+
+		path := fmt.Sprintf("%s?pageNum=%d&itemsPerPage=%d", endpoint, page, listPageSize)
+		if err := c.requestPublic(http.MethodGet, path, nil, &response); err != nil {
+			return err
+		}
+
+		count, err := appendPage(response.Results)
+		if err != nil {
+			return err
+		}
+
+		if count == 0 || (page-1)*listPageSize+count >= response.TotalCount {
+			return nil
+		}
+	}
 }
 
 // requestPrivate will make a request to an endpoint in the private API.
 func (c *HTTPClient) requestPrivate(method string, endpoint string, body interface{}, response interface{}) error {
 	url := fmt.Sprintf("%s%s/%s", c.BaseURL, privateAPIPath, endpoint)
-	return c.request(method, url, body, response)
+	return c.request(method, url, "application/json", body, response)
 }
 
-// request makes an HTTP request using the specified method.
+// request makes an HTTP request using the specified method and Accept header.
 // If body is passed it will be JSON encoded and included with the request.
 // If the request was successful the response will be decoded into response.
-func (c *HTTPClient) request(method string, url string, body interface{}, response interface{}) error {
+func (c *HTTPClient) request(method string, url string, accept string, body interface{}, response interface{}) error {
 	var data io.Reader
 
 	// Construct the JSON payload if a body has been passed
@@ -107,7 +212,9 @@ func (c *HTTPClient) request(method string, url string, body interface{}, respon
 	}
 	req.Header.Set("Authorization", auth)
 
-	req.Header.Set("Content-Type", "application/json")
+	// Versions of the API are selected through the media type.
+	req.Header.Set("Content-Type", accept)
+	req.Header.Set("Accept", accept)
 
 	// Perform HTTP request.
 	resp, err := c.HTTP.Do(req)
@@ -116,15 +223,23 @@ func (c *HTTPClient) request(method string, url string, body interface{}, respon
 	}
 	defer resp.Body.Close()
 
+	c.observeRateLimit(resp)
+
 	// Decode response if request was successful.
 	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
 
+		// Some endpoints respond with plain text, which is returned as is.
+		if raw, ok := response.(*[]byte); ok {
+			*raw, err = ioutil.ReadAll(resp.Body)
+			return err
+		}
+
 		if response != nil {
 			err = json.NewDecoder(resp.Body).Decode(response)
 
 			// EOF error means the response body was empty.
-			if err != io.EOF {
-				return err
+			if err != nil && err != io.EOF {
+				return newDecodeError(err)
 			}
 		}
 
@@ -136,17 +251,22 @@ func (c *HTTPClient) request(method string, url string, body interface{}, respon
 		return ErrUnauthorized
 	}
 
-	// Decode error if request was unsuccessful.
+	// Decode error if request was unsuccessful. Proxies in front of Atlas may
+	// respond with something other than JSON, in which case only the status
+	// is known.
 	var errorResponse struct {
 		Code        string `json:"errorCode"`
 		Description string `json:"detail"`
 	}
 	err = json.NewDecoder(resp.Body).Decode(&errorResponse)
 	if err != nil {
-		return err
+		return &APIError{
+			StatusCode:  resp.StatusCode,
+			Description: http.StatusText(resp.StatusCode),
+		}
 	}
 
-	return errorFromErrorCode(errorResponse.Code, errorResponse.Description)
+	return errorFromErrorCode(resp.StatusCode, errorResponse.Code, errorResponse.Description)
 }
 
 // digestAuth performs an unauthenticated request to retrieve a digest nonce.
@@ -180,7 +300,7 @@ func (c *HTTPClient) digestAuth(method string, endpoint string) (string, error)
 }
 
 // errorFromErrorCode converts an Atlas API error code into an error.
-func errorFromErrorCode(code string, description string) error {
+func errorFromErrorCode(status int, code string, description string) error {
 	errorsByCode := map[string]error{
 		"CLUSTER_NOT_FOUND":                  ErrClusterNotFound,
 		"CLUSTER_ALREADY_REQUESTED_DELETION": ErrClusterNotFound,
@@ -189,12 +309,19 @@ func errorFromErrorCode(code string, description string) error {
 
 		"USER_ALREADY_EXISTS": ErrUserAlreadyExists,
 		"USER_NOT_FOUND":      ErrUserNotFound,
+
+		// Database users are looked up by username.
+		"USERNAME_NOT_FOUND": ErrUserNotFound,
 	}
 
 	// Default to an error wrapping the Atlas error description.
 	err := errorsByCode[code]
 	if err == nil {
-		return fmt.Errorf("atlas error: [%s] %s", code, description)
+		return &APIError{
+			StatusCode:  status,
+			Code:        code,
+			Description: description,
+		}
 	}
 
 	return err
@@ -1,8 +1,11 @@
 package atlas
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 )
 
 // All states a cluster can be in.
@@ -19,69 +22,221 @@ var (
 var (
 	ClusterTypeReplicaSet = "REPLICASET"
 	ClusterTypeSharded    = "SHARDED"
+	ClusterTypeGeoSharded = "GEOSHARDED"
 )
 
-// Cluster represents a single cluster in Atlas.
+// Cluster represents a single cluster in Atlas. The description tags document
+// the fields for the parameter schemas published by the broker.
 type Cluster struct {
 	Name string `json:"name"`
 
-	AutoScaling              AutoScalingConfig `json:"autoScaling,omitempty"`
-	BackupEnabled            bool              `json:"backupEnabled,omitempty"`
-	BIConnector              BIConnectorConfig `json:"biConnector,omitempty"`
-	ClusterType              string            `json:"clusterType,omitempty"`
-	DiskSizeGB               float64           `json:"diskSizeGB,omitempty"`
-	EncryptionAtRestProvider string            `json:"encryptionAtRestProvider,omitempty"`
-	MongoDBMajorVersion      string            `json:"mongoDBMajorVersion,omitempty"`
-	NumShards                uint              `json:"numShards,omitempty"`
-	ProviderBackupEnabled    bool              `json:"providerBackupEnabled,omitempty"`
-	ReplicationSpecs         []ReplicationSpec `json:"replicationSpecs,omitempty"`
-	ProviderSettings         *ProviderSettings `json:"providerSettings"`
+	AutoScaling              *AutoScalingConfig `json:"autoScaling,omitempty" description:"Auto-scaling settings of the cluster."`
+	BackupEnabled            bool               `json:"backupEnabled,omitempty" description:"Enables continuous backups."`
+	BIConnector              *BIConnectorConfig `json:"biConnector,omitempty" description:"Settings of the BI Connector for Atlas."`
+	ClusterType              string             `json:"clusterType,omitempty" description:"One of REPLICASET, SHARDED or GEOSHARDED."`
+	DiskSizeGB               float64            `json:"diskSizeGB,omitempty" description:"Capacity of the data volume in GB."`
+	EncryptionAtRestProvider string             `json:"encryptionAtRestProvider,omitempty" description:"Provider of the keys used for encryption at rest, for example AWS or NONE."`
+	MongoDBMajorVersion      string             `json:"mongoDBMajorVersion,omitempty" description:"Major version of MongoDB, for example 4.2."`
+	NumShards                uint               `json:"numShards,omitempty" description:"Number of shards of a sharded cluster."`
+	ProviderBackupEnabled    bool               `json:"providerBackupEnabled,omitempty" description:"Enables cloud provider snapshots."`
+	ReplicationSpecs         []ReplicationSpec  `json:"replicationSpecs,omitempty" description:"Replication settings per zone."`
+	ProviderSettings         *ProviderSettings  `json:"providerSettings,omitempty" description:"Cloud provider settings of the cluster."`
+	Labels                   []Label            `json:"labels,omitempty" description:"Labels attached to the cluster."`
+	VersionReleaseSystem     string             `json:"versionReleaseSystem,omitempty" description:"LTS to stay on a major version, or CONTINUOUS to receive rapid releases. CONTINUOUS clusters can't set mongoDBMajorVersion."`
+	PitEnabled               bool               `json:"pitEnabled,omitempty" description:"Enables point in time restores from continuous cloud backups."`
+	RootCertType             string             `json:"rootCertType,omitempty" description:"Certificate authority of the TLS certificates of the cluster, for example ISRGROOTX1."`
+
+	// Deleting clusters with termination protection fails, so the broker
+	// doesn't offer it.
+	TerminationProtectionEnabled bool `json:"terminationProtectionEnabled,omitempty" schema:"-"`
+
+	// Deprecated attributes which Atlas still returns, replicationSpecs
+	// supersedes both.
+	ReplicationFactor uint                     `json:"replicationFactor,omitempty" schema:"-"`
+	ReplicationSpec   map[string]RegionsConfig `json:"replicationSpec,omitempty" schema:"-"`
 
 	// Read-only attributes
-	StateName  string `json:"stateName,omitempty"`
-	SrvAddress string `json:"srvAddress,omitempty"`
+	ID                      string                   `json:"id,omitempty" schema:"-"`
+	GroupID                 string                   `json:"groupId,omitempty" schema:"-"`
+	CreateDate              string                   `json:"createDate,omitempty" schema:"-"`
+	MongoDBVersion          string                   `json:"mongoDBVersion,omitempty" schema:"-"`
+	Paused                  bool                     `json:"paused,omitempty" schema:"-"`
+	StateName               string                   `json:"stateName,omitempty" schema:"-"`
+	SrvAddress              string                   `json:"srvAddress,omitempty" schema:"-"`
+	MongoURI                string                   `json:"mongoURI,omitempty" schema:"-"`
+	MongoURIWithOptions     string                   `json:"mongoURIWithOptions,omitempty" schema:"-"`
+	MongoURIUpdated         string                   `json:"mongoURIUpdated,omitempty" schema:"-"`
+	ConnectionStrings       *ConnectionStrings       `json:"connectionStrings,omitempty" schema:"-"`
+	ServerlessBackupOptions *ServerlessBackupOptions `json:"serverlessBackupOptions,omitempty" schema:"-"`
+	Links                   []Link                   `json:"links,omitempty" schema:"-"`
+}
+
+// ConnectionStrings holds the connection strings of a cluster. The private
+// ones are only set if the project uses network peering or private
+// endpoints.
+type ConnectionStrings struct {
+	Standard          string                   `json:"standard,omitempty"`
+	StandardSrv       string                   `json:"standardSrv,omitempty"`
+	Private           string                   `json:"private,omitempty"`
+	PrivateSrv        string                   `json:"privateSrv,omitempty"`
+	AWSPrivateLink    map[string]string        `json:"awsPrivateLink,omitempty"`
+	AWSPrivateLinkSrv map[string]string        `json:"awsPrivateLinkSrv,omitempty"`
+	PrivateEndpoint   []PrivateEndpointStrings `json:"privateEndpoint,omitempty"`
+}
+
+// PrivateEndpointStrings holds the connection strings of a cluster through
+// a private endpoint.
+type PrivateEndpointStrings struct {
+	ConnectionString                  string            `json:"connectionString,omitempty"`
+	SRVConnectionString               string            `json:"srvConnectionString,omitempty"`
+	SRVShardOptimizedConnectionString string            `json:"srvShardOptimizedConnectionString,omitempty"`
+	Type                              string            `json:"type,omitempty"`
+	Endpoints                         []PrivateEndpoint `json:"endpoints,omitempty"`
+}
+
+// PrivateEndpoint identifies a private endpoint a cluster can be reached
+// through.
+type PrivateEndpoint struct {
+	EndpointID   string `json:"endpointId,omitempty"`
+	ProviderName string `json:"providerName,omitempty"`
+	Region       string `json:"region,omitempty"`
+}
+
+// ServerlessBackupOptions represents the backup settings of a serverless
+// instance.
+type ServerlessBackupOptions struct {
+	ServerlessContinuousBackupEnabled bool `json:"serverlessContinuousBackupEnabled,omitempty"`
+}
+
+// Link is a reference to a related resource in the Atlas API.
+type Link struct {
+	Href string `json:"href"`
+	Rel  string `json:"rel"`
 }
 
 // AutoScalingConfig represents the autoscaling settings for a cluster.
 type AutoScalingConfig struct {
-	DiskGBEnabled bool `json:"diskGBEnabled,omitempty"`
+	DiskGBEnabled bool                      `json:"diskGBEnabled,omitempty" description:"Grows the disk automatically."`
+	Compute       *ComputeAutoScalingConfig `json:"compute,omitempty" description:"Instance size auto-scaling settings."`
+}
+
+// ComputeAutoScalingConfig represents the instance size autoscaling settings
+// for a cluster. The instance size limits are part of the provider settings.
+type ComputeAutoScalingConfig struct {
+	Enabled          bool `json:"enabled,omitempty" description:"Scales the instance size up automatically."`
+	ScaleDownEnabled bool `json:"scaleDownEnabled,omitempty" description:"Scales the instance size down automatically."`
 }
 
 // BIConnectorConfig represents the BI connector settings for a cluster.
 type BIConnectorConfig struct {
-	Enabled        bool   `json:"enabled,omitempty"`
-	ReadPreference string `json:"readPreference,omitempty"`
+	Enabled        bool   `json:"enabled,omitempty" description:"Enables the BI Connector."`
+	ReadPreference string `json:"readPreference,omitempty" description:"One of primary, secondary or analytics."`
+}
+
+// BIConnectorPort is the port the BI Connector for Atlas listens on.
+const BIConnectorPort = 27015
+
+// BIConnectorHost returns the host name SQL clients connect to when the BI
+// Connector of a cluster is enabled, or an empty string if it isn't or the
+// cluster has no SRV address yet. The API doesn't return it, Atlas names it
+// after the SRV host with -biconnector appended to the cluster's label.
+func (c Cluster) BIConnectorHost() string {
+	if c.BIConnector == nil || !c.BIConnector.Enabled {
+		return ""
+	}
+
+	srv, err := url.Parse(c.SrvAddress)
+	if err != nil {
+		return ""
+	}
+
+	labels := strings.SplitN(srv.Hostname(), ".", 2)
+	if len(labels) != 2 || labels[0] == "" {
+		return ""
+	}
+
+	return labels[0] + "-biconnector." + labels[1]
+}
+
+// Label represents a key-value pair attached to a cluster.
+type Label struct {
+	Key   string `json:"key" description:"Key of the label."`
+	Value string `json:"value" description:"Value of the label."`
 }
 
 // ProviderSettings represents the provider setting for a cluster.
 type ProviderSettings struct {
 	ProviderName        string `json:"providerName"`
 	InstanceSizeName    string `json:"instanceSizeName"`
-	RegionName          string `json:"regionName,omitempty"`
+	RegionName          string `json:"regionName,omitempty" description:"Region in the naming of the provider, for example US_EAST_1."`
 	BackingProviderName string `json:"backingProviderName,omitempty"`
 
-	DiskIOPS         uint   `json:"diskIOPS,omitempty"`
-	DiskTypeName     string `json:"diskTypeName,omitempty"`
-	EncryptEBSVolume bool   `json:"encryptEBSVolume,omitempty"`
-	VolumeType       string `json:"volumeType,omitempty"`
+	AutoScaling      *ProviderAutoScalingConfig `json:"autoScaling,omitempty" description:"Limits of the instance size auto-scaling."`
+	DiskIOPS         uint                       `json:"diskIOPS,omitempty" description:"Maximum IOPS of the data volume (AWS only)."`
+	DiskTypeName     string                     `json:"diskTypeName,omitempty" description:"Disk type of the data volume (Azure only)."`
+	EncryptEBSVolume bool                       `json:"encryptEBSVolume,omitempty" description:"Encrypts the EBS volume (AWS only)."`
+	VolumeType       string                     `json:"volumeType,omitempty" description:"One of STANDARD or PROVISIONED (AWS only)."`
+}
+
+// ProviderAutoScalingConfig represents the provider specific autoscaling
+// settings for a cluster.
+type ProviderAutoScalingConfig struct {
+	Compute *ComputeInstanceSizeLimits `json:"compute,omitempty" description:"Instance sizes compute auto-scaling stays within."`
+}
+
+// ComputeInstanceSizeLimits represents the smallest and largest instance size
+// compute auto-scaling may choose.
+type ComputeInstanceSizeLimits struct {
+	MinInstanceSize string `json:"minInstanceSize,omitempty" description:"Smallest instance size, for example M10."`
+	MaxInstanceSize string `json:"maxInstanceSize,omitempty" description:"Largest instance size, for example M40."`
 }
 
 // ReplicationSpec represents the replication settings for a single region.
 type ReplicationSpec struct {
 	// Unique identifier for a zone's replication document. Required for existing
 	// zones and optional if adding new zones to a Global Cluster.
-	ID            string                   `json:"id,omitempty"`
-	NumShards     uint                     `json:"numShards,omitempty"`
-	RegionsConfig map[string]RegionsConfig `json:"regionsConfig,omitempty"`
-	ZoneName      string                   `json:"zoneName,omitempty"`
+	ID            string                   `json:"id,omitempty" description:"ID of an existing zone."`
+	NumShards     uint                     `json:"numShards,omitempty" description:"Number of shards in the zone."`
+	RegionsConfig map[string]RegionsConfig `json:"regionsConfig,omitempty" description:"Node counts keyed by region name."`
+	ZoneName      string                   `json:"zoneName,omitempty" description:"Name of the zone of a global cluster."`
 }
 
 // RegionsConfig represents a region's config in a replication spec.
 type RegionsConfig struct {
-	ElectableNodes int `json:"electableNodes"`
-	ReadOnlyNodes  int `json:"readOnlyNodes"`
-	AnalyticsNodes int `json:"analyticsNodes,omitempty"`
-	Priority       int `json:"priority,omitempty"`
+	ElectableNodes int `json:"electableNodes" description:"Number of electable nodes in the region."`
+	ReadOnlyNodes  int `json:"readOnlyNodes" description:"Number of read-only nodes in the region."`
+	AnalyticsNodes int `json:"analyticsNodes,omitempty" description:"Number of analytics nodes in the region."`
+	Priority       int `json:"priority,omitempty" description:"Election priority of the region, from 7 down to 1."`
+}
+
+// The TLS protocols which can be set as the minimum of a cluster, from the
+// oldest to the newest.
+var (
+	TLSProtocol1_0 = "TLS1_0"
+	TLSProtocol1_1 = "TLS1_1"
+	TLSProtocol1_2 = "TLS1_2"
+)
+
+// TLSProtocols lists the TLS protocols from the oldest to the newest.
+var TLSProtocols = []string{TLSProtocol1_0, TLSProtocol1_1, TLSProtocol1_2}
+
+// The release systems a cluster can receive MongoDB versions from.
+var (
+	VersionReleaseSystemLTS        = "LTS"
+	VersionReleaseSystemContinuous = "CONTINUOUS"
+)
+
+// MongoDBMajorVersions lists the MongoDB major versions Atlas deploys, from
+// the oldest to the newest.
+var MongoDBMajorVersions = []string{"4.0", "4.2", "4.4", "5.0", "6.0", "7.0"}
+
+// ProcessArgs represents the advanced configuration of the MongoDB processes
+// of a cluster. Unset fields are left unchanged by updates.
+type ProcessArgs struct {
+	MinimumEnabledTLSProtocol string `json:"minimumEnabledTlsProtocol,omitempty" description:"Oldest TLS protocol accepted by the cluster, one of TLS1_0, TLS1_1 or TLS1_2."`
+	JavascriptEnabled         *bool  `json:"javascriptEnabled,omitempty" description:"Allows server-side JavaScript execution."`
+	NoTableScan               *bool  `json:"noTableScan,omitempty" description:"Rejects queries which require a collection scan."`
+	OplogSizeMB               int    `json:"oplogSizeMB,omitempty" description:"Size of the oplog in MB."`
 }
 
 // CreateCluster will create a new cluster asynchronously.
@@ -119,7 +274,49 @@ func (c *HTTPClient) GetCluster(name string) (*Cluster, error) {
 	return &cluster, err
 }
 
+// ListClusters will return all clusters in the project.
+// GET /clusters
+func (c *HTTPClient) ListClusters() ([]Cluster, error) {
+	clusters := []Cluster{}
+	err := c.listPublic("clusters", func(data json.RawMessage) (int, error) {
+		var page []Cluster
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, newDecodeError(err)
+		}
+
+		clusters = append(clusters, page...)
+		return len(page), nil
+	})
+
+	return clusters, err
+}
+
+// GetProcessArgs will return the advanced configuration of a cluster.
+// GET /clusters/{CLUSTER-NAME}/processArgs
+func (c *HTTPClient) GetProcessArgs(clusterName string) (*ProcessArgs, error) {
+	path := fmt.Sprintf("clusters/%s/processArgs", clusterName)
+
+	var args ProcessArgs
+	err := c.requestPublic(http.MethodGet, path, nil, &args)
+	return &args, err
+}
+
+// UpdateProcessArgs will change the advanced configuration of a cluster.
+// PATCH /clusters/{CLUSTER-NAME}/processArgs
+func (c *HTTPClient) UpdateProcessArgs(clusterName string, args ProcessArgs) (*ProcessArgs, error) {
+	path := fmt.Sprintf("clusters/%s/processArgs", clusterName)
+
+	var resultingArgs ProcessArgs
+	err := c.requestPublic(http.MethodPatch, path, args, &resultingArgs)
+	return &resultingArgs, err
+}
+
 // GetDashboardURL prepares the url where the specific cluster can be found in the Dashboard UI
 func (c *HTTPClient) GetDashboardURL(clusterName string) string {
-	return fmt.Sprintf("%s/v2/%s#clusters/detail/%s", c.BaseURL, c.GroupID, clusterName)
+	baseURL := c.DashboardBaseURL
+	if baseURL == "" {
+		baseURL = c.BaseURL
+	}
+
+	return fmt.Sprintf("%s/v2/%s#clusters/detail/%s", baseURL, c.GroupID, clusterName)
 }
@@ -113,3 +113,100 @@ func TestTerminateNonexistentCluster(t *testing.T) {
 
 	assert.Equal(t, ErrClusterNotFound, err)
 }
+
+func TestListClusters(t *testing.T) {
+	expected := []Cluster{
+		Cluster{Name: "Cluster1", StateName: ClusterStateIdle},
+		Cluster{Name: "Cluster2", StateName: ClusterStateCreating},
+	}
+
+	response := map[string]interface{}{
+		"results":    expected,
+		"totalCount": len(expected),
+	}
+
+	atlas, server := setupTest(t, "/clusters?pageNum=1&itemsPerPage=500", http.MethodGet, 200, response)
+	defer server.Close()
+
+	clusters, err := atlas.ListClusters()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, clusters)
+}
+
+func TestGetClusterUnknownError(t *testing.T) {
+	atlas, server := setupTest(t, "/clusters/Cluster", http.MethodGet, 503, errorResponse("SERVICE_UNAVAILABLE"))
+	defer server.Close()
+
+	_, err := atlas.GetCluster("Cluster")
+
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "Expected an API error but got %v", err) {
+		assert.Equal(t, http.StatusServiceUnavailable, apiErr.StatusCode)
+		assert.Equal(t, "SERVICE_UNAVAILABLE", apiErr.Code)
+	}
+}
+
+func TestGetClusterNonJSONError(t *testing.T) {
+	atlas, server := setupTest(t, "/clusters/Cluster", http.MethodGet, 502, nil)
+	defer server.Close()
+
+	_, err := atlas.GetCluster("Cluster")
+
+	apiErr, ok := err.(*APIError)
+	if assert.True(t, ok, "Expected an API error but got %v", err) {
+		assert.Equal(t, http.StatusBadGateway, apiErr.StatusCode)
+		assert.EqualError(t, err, "atlas error: 502 Bad Gateway")
+	}
+}
+
+func TestGetDashboardURL(t *testing.T) {
+	client := NewClient("https://cloud.mongodb.com", "group", "public", "private")
+	assert.Equal(t, "https://cloud.mongodb.com/v2/group#clusters/detail/cluster", client.GetDashboardURL("cluster"))
+
+	client = NewClient("https://cloud.mongodbgov.com", "group", "public", "private", WithDashboardBaseURL("https://console.mongodbgov.com/"))
+	assert.Equal(t, "https://console.mongodbgov.com/v2/group#clusters/detail/cluster", client.GetDashboardURL("cluster"))
+}
+
+func TestGetProcessArgs(t *testing.T) {
+	expected := ProcessArgs{MinimumEnabledTLSProtocol: TLSProtocol1_2}
+
+	atlas, server := setupTest(t, "/clusters/Cluster/processArgs", http.MethodGet, 200, expected)
+	defer server.Close()
+
+	args, err := atlas.GetProcessArgs("Cluster")
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, args)
+}
+
+func TestUpdateProcessArgs(t *testing.T) {
+	expected := ProcessArgs{MinimumEnabledTLSProtocol: TLSProtocol1_2}
+
+	atlas, server := setupTest(t, "/clusters/Cluster/processArgs", http.MethodPatch, 200, expected)
+	defer server.Close()
+
+	args, err := atlas.UpdateProcessArgs("Cluster", expected)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, args)
+}
+
+func TestClusterBIConnectorHost(t *testing.T) {
+	enabled := &BIConnectorConfig{Enabled: true}
+
+	tests := []struct {
+		cluster  Cluster
+		expected string
+	}{
+		{Cluster{SrvAddress: "mongodb+srv://cluster0.abcde.mongodb.net", BIConnector: enabled}, "cluster0-biconnector.abcde.mongodb.net"},
+		{Cluster{SrvAddress: "mongodb+srv://cluster0.abcde.mongodb.net", BIConnector: &BIConnectorConfig{}}, ""},
+		{Cluster{SrvAddress: "mongodb+srv://cluster0.abcde.mongodb.net"}, ""},
+		{Cluster{BIConnector: enabled}, ""},
+		{Cluster{SrvAddress: "mongodb+srv://localhost", BIConnector: enabled}, ""},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, test.cluster.BIConnectorHost(), test.cluster.SrvAddress)
+	}
+}
@@ -0,0 +1,111 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+// DecodeError is returned when a response from Atlas can't be decoded. Field
+// is the JSON path of the offending field, for example
+// "providerSettings.diskIOPS", if it's known.
+type DecodeError struct {
+	Field string
+	Err   error
+}
+
+func (e *DecodeError) Error() string {
+	if e.Field == "" {
+		return fmt.Sprintf("failed to decode Atlas response: %v", e.Err)
+	}
+
+	return fmt.Sprintf("failed to decode field %s of Atlas response: %v", e.Field, e.Err)
+}
+
+// Unwrap returns the underlying JSON error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// newDecodeError wraps a JSON decoding error in a DecodeError.
+func newDecodeError(err error) error {
+	decodeErr := &DecodeError{Err: err}
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		decodeErr.Field = typeErr.Field
+	}
+
+	return decodeErr
+}
+
+// flexibleNumberFields are the numeric cluster fields which Atlas has been
+// seen to return as strings, for example in beta features.
+var flexibleNumberFields = map[string]bool{
+	"diskSizeGB":     true,
+	"numShards":      true,
+	"diskIOPS":       true,
+	"electableNodes": true,
+	"readOnlyNodes":  true,
+	"analyticsNodes": true,
+	"priority":       true,
+}
+
+// UnmarshalJSON decodes a cluster. Numeric fields which have been encoded as
+// strings are accepted as long as the string contains a valid number.
+func (c *Cluster) UnmarshalJSON(data []byte) error {
+	// The alias type doesn't have this method which prevents recursion.
+	type cluster Cluster
+
+	err := json.Unmarshal(data, (*cluster)(c))
+	if _, isTypeErr := err.(*json.UnmarshalTypeError); !isTypeErr {
+		return err
+	}
+
+	// Convert numeric strings into numbers and try again. If the document is
+	// still invalid the error points at the offending field.
+	normalized, normalizeErr := normalizeFlexibleNumbers(data)
+	if normalizeErr != nil {
+		return err
+	}
+
+	return json.Unmarshal(normalized, (*cluster)(c))
+}
+
+// normalizeFlexibleNumbers replaces numeric strings in flexibleNumberFields
+// with numbers. All other values are left as they are.
+func normalizeFlexibleNumbers(data []byte) ([]byte, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(normalizeFlexibleNumbersIn(document))
+}
+
+func normalizeFlexibleNumbersIn(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if s, isString := field.(string); isString && flexibleNumberFields[key] && isNumber(s) {
+				v[key] = json.Number(s)
+				continue
+			}
+
+			v[key] = normalizeFlexibleNumbersIn(field)
+		}
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeFlexibleNumbersIn(item)
+		}
+	}
+
+	return value
+}
+
+// isNumber returns whether a string is a valid JSON number.
+func isNumber(s string) bool {
+	var n json.Number
+	return json.Unmarshal([]byte(s), &n) == nil && n != ""
+}
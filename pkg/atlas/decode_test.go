@@ -0,0 +1,112 @@
+package atlas
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClusterFixturesDecode(t *testing.T) {
+	paths, err := filepath.Glob("testdata/clusters/*.json")
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, paths) {
+		return
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		var cluster Cluster
+		if assert.NoErrorf(t, json.Unmarshal(data, &cluster), "Expected %s to decode", path) {
+			assert.NotEmptyf(t, cluster.Name, "Expected %s to have a name", path)
+			assert.NotEmptyf(t, cluster.StateName, "Expected %s to have a state", path)
+			assert.NotNilf(t, cluster.ProviderSettings, "Expected %s to have provider settings", path)
+		}
+	}
+}
+
+func TestClusterDecodeNumericStrings(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/clusters/beta-numeric-strings.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var cluster Cluster
+	if !assert.NoError(t, json.Unmarshal(data, &cluster)) {
+		return
+	}
+
+	assert.Equal(t, 80.5, cluster.DiskSizeGB)
+	assert.Equal(t, uint(3), cluster.NumShards)
+	assert.Equal(t, uint(3000), cluster.ProviderSettings.DiskIOPS)
+	assert.Equal(t, uint(3), cluster.ReplicationSpecs[0].NumShards)
+	assert.Equal(t, RegionsConfig{
+		ElectableNodes: 3,
+		ReadOnlyNodes:  1,
+		AnalyticsNodes: 0,
+		Priority:       7,
+	}, cluster.ReplicationSpecs[0].RegionsConfig["EU_WEST_1"])
+}
+
+func TestClusterVersionReleaseSystem(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/clusters/aws-m10-continuous.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	var cluster Cluster
+	if assert.NoError(t, json.Unmarshal(data, &cluster)) {
+		assert.Equal(t, VersionReleaseSystemContinuous, cluster.VersionReleaseSystem)
+	}
+
+	encoded, err := json.Marshal(Cluster{Name: "Cluster", VersionReleaseSystem: VersionReleaseSystemLTS})
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, `{"name": "Cluster", "versionReleaseSystem": "LTS"}`, string(encoded))
+	}
+
+	// Clusters which don't choose leave it to Atlas.
+	encoded, err = json.Marshal(Cluster{Name: "Cluster"})
+	if assert.NoError(t, err) {
+		assert.NotContains(t, string(encoded), "versionReleaseSystem")
+	}
+}
+
+func TestClusterDecodeInvalidField(t *testing.T) {
+	invalid := map[string]string{
+		`{"diskSizeGB": "large"}`:                  "diskSizeGB",
+		`{"providerSettings": {"diskIOPS": true}}`: "providerSettings.diskIOPS",
+		`{"replicationSpecs": [{"regionsConfig": {"US_EAST_1": {"electableNodes": "3.5"}}}]}`: "replicationSpecs.0.regionsConfig.US_EAST_1.electableNodes",
+		`{"stateName": 1}`: "stateName",
+	}
+
+	for data, field := range invalid {
+		var cluster Cluster
+		err := json.Unmarshal([]byte(data), &cluster)
+
+		typeErr, ok := err.(*json.UnmarshalTypeError)
+		if assert.Truef(t, ok, "Expected a type error for %s but got %v", data, err) {
+			assert.Equal(t, field, typeErr.Field)
+		}
+	}
+}
+
+func TestGetClusterDecodeError(t *testing.T) {
+	response := json.RawMessage(`{"name": "Cluster", "providerSettings": {"diskIOPS": "fast"}}`)
+
+	atlas, server := setupTest(t, "/clusters/Cluster", http.MethodGet, 200, response)
+	defer server.Close()
+
+	_, err := atlas.GetCluster("Cluster")
+
+	decodeErr, ok := err.(*DecodeError)
+	if assert.True(t, ok, "Expected a decode error but got %v", err) {
+		assert.Equal(t, "providerSettings.diskIOPS", decodeErr.Field)
+		assert.Contains(t, decodeErr.Error(), "providerSettings.diskIOPS")
+	}
+}
@@ -0,0 +1,71 @@
+package atlas
+
+import (
+	"net/http"
+)
+
+// Providers of the keys used for encryption at rest, as used by
+// Cluster.EncryptionAtRestProvider.
+const (
+	EncryptionAtRestProviderNone  = "NONE"
+	EncryptionAtRestProviderAWS   = "AWS"
+	EncryptionAtRestProviderAzure = "AZURE"
+	EncryptionAtRestProviderGCP   = "GCP"
+)
+
+// EncryptionAtRest represents the customer-managed keys of a project. Clusters
+// opt into using them through their encryptionAtRestProvider. Updates only
+// change the providers which are set.
+type EncryptionAtRest struct {
+	AWSKMS         *AWSKMS         `json:"awsKms,omitempty"`
+	AzureKeyVault  *AzureKeyVault  `json:"azureKeyVault,omitempty"`
+	GoogleCloudKMS *GoogleCloudKMS `json:"googleCloudKms,omitempty"`
+}
+
+// AWSKMS is the AWS Key Management Service configuration of a project.
+type AWSKMS struct {
+	Enabled             bool   `json:"enabled"`
+	CustomerMasterKeyID string `json:"customerMasterKeyID,omitempty"`
+	Region              string `json:"region,omitempty"`
+	RoleID              string `json:"roleId,omitempty"`
+}
+
+// AzureKeyVault is the Azure Key Vault configuration of a project. The secret
+// is never returned by Atlas.
+type AzureKeyVault struct {
+	Enabled           bool   `json:"enabled"`
+	ClientID          string `json:"clientID,omitempty"`
+	AzureEnvironment  string `json:"azureEnvironment,omitempty"`
+	SubscriptionID    string `json:"subscriptionID,omitempty"`
+	ResourceGroupName string `json:"resourceGroupName,omitempty"`
+	KeyVaultName      string `json:"keyVaultName,omitempty"`
+	KeyIdentifier     string `json:"keyIdentifier,omitempty"`
+	Secret            string `json:"secret,omitempty"`
+	TenantID          string `json:"tenantID,omitempty"`
+}
+
+// GoogleCloudKMS is the Google Cloud KMS configuration of a project. The
+// service account key is never returned by Atlas.
+type GoogleCloudKMS struct {
+	Enabled              bool   `json:"enabled"`
+	ServiceAccountKey    string `json:"serviceAccountKey,omitempty"`
+	KeyVersionResourceID string `json:"keyVersionResourceID,omitempty"`
+}
+
+// GetEncryptionAtRest will return the encryption at rest configuration of the
+// project.
+// GET /encryptionAtRest
+func (c *HTTPClient) GetEncryptionAtRest() (*EncryptionAtRest, error) {
+	var encryption EncryptionAtRest
+	err := c.requestPublic(http.MethodGet, "encryptionAtRest", nil, &encryption)
+	return &encryption, err
+}
+
+// UpdateEncryptionAtRest will change the configuration of the providers which
+// are set and return the whole configuration.
+// PATCH /encryptionAtRest
+func (c *HTTPClient) UpdateEncryptionAtRest(encryption EncryptionAtRest) (*EncryptionAtRest, error) {
+	var resultingEncryption EncryptionAtRest
+	err := c.requestPublic(http.MethodPatch, "encryptionAtRest", encryption, &resultingEncryption)
+	return &resultingEncryption, err
+}
@@ -0,0 +1,38 @@
+package atlas
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetEncryptionAtRest(t *testing.T) {
+	expected := EncryptionAtRest{
+		AWSKMS:         &AWSKMS{Enabled: true, CustomerMasterKeyID: "key", Region: "US_EAST_1", RoleID: "role"},
+		AzureKeyVault:  &AzureKeyVault{},
+		GoogleCloudKMS: &GoogleCloudKMS{},
+	}
+
+	atlas, server := setupTest(t, "/encryptionAtRest", http.MethodGet, 200, expected)
+	defer server.Close()
+
+	encryption, err := atlas.GetEncryptionAtRest()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, encryption)
+}
+
+func TestUpdateEncryptionAtRest(t *testing.T) {
+	expected := EncryptionAtRest{
+		AWSKMS: &AWSKMS{Enabled: true, CustomerMasterKeyID: "key", Region: "US_EAST_1", RoleID: "role"},
+	}
+
+	atlas, server := setupTest(t, "/encryptionAtRest", http.MethodPatch, 200, expected)
+	defer server.Close()
+
+	encryption, err := atlas.UpdateEncryptionAtRest(expected)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, encryption)
+}
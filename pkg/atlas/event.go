@@ -0,0 +1,31 @@
+package atlas
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// Event represents an entry of the project activity feed.
+type Event struct {
+	ID            string `json:"id"`
+	Created       string `json:"created"`
+	EventTypeName string `json:"eventTypeName"`
+	ClusterName   string `json:"clusterName,omitempty"`
+	Username      string `json:"username,omitempty"`
+	PublicKey     string `json:"publicKey,omitempty"`
+	RemoteAddress string `json:"remoteAddress,omitempty"`
+}
+
+// ListClusterEvents will return the most recent events of the project
+// concerning a cluster, newest first. At most limit events are returned.
+// GET /events?clusterNames={CLUSTER-NAME}
+func (c *HTTPClient) ListClusterEvents(clusterName string, limit int) ([]Event, error) {
+	var response struct {
+		Results []Event `json:"results"`
+	}
+
+	path := fmt.Sprintf("events?clusterNames=%s&itemsPerPage=%d", url.QueryEscape(clusterName), limit)
+	err := c.requestPublic(http.MethodGet, path, nil, &response)
+	return response.Results, err
+}
@@ -0,0 +1,28 @@
+package atlas
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListClusterEvents(t *testing.T) {
+	expected := []Event{
+		Event{ID: "2", Created: "2020-01-02T00:00:00Z", EventTypeName: "CLUSTER_READY", ClusterName: "my cluster"},
+		Event{ID: "1", Created: "2020-01-01T00:00:00Z", EventTypeName: "CLUSTER_CREATED", ClusterName: "my cluster", Username: "user"},
+	}
+
+	response := map[string]interface{}{
+		"results":    expected,
+		"totalCount": 10,
+	}
+
+	atlas, server := setupTest(t, "/events?clusterNames=my+cluster&itemsPerPage=2", http.MethodGet, 200, response)
+	defer server.Close()
+
+	events, err := atlas.ListClusterEvents("my cluster", 2)
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, events)
+}
@@ -0,0 +1,182 @@
+package atlas
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// updateGolden rewrites the golden files from the current encoding, run
+// "go test ./pkg/atlas -update" after changing the structs on purpose.
+var updateGolden = flag.Bool("update", false, "update the golden files")
+
+// goldenClusterFixtures are the recorded cluster documents whose round trip
+// is compared against a golden file.
+var goldenClusterFixtures = []string{
+	"aws-m10-replicaset",
+	"azure-m40-geosharded",
+	"gcp-m30-sharded",
+	"tenant-m2",
+}
+
+func TestClusterGoldenRoundTrip(t *testing.T) {
+	for _, name := range goldenClusterFixtures {
+		t.Run(name, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "clusters", name+".json"))
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			var cluster Cluster
+			if !assert.NoError(t, json.Unmarshal(data, &cluster)) {
+				return
+			}
+
+			encoded, err := json.MarshalIndent(cluster, "", "  ")
+			if !assert.NoError(t, err) {
+				return
+			}
+			encoded = append(encoded, '\n')
+
+			golden := filepath.Join("testdata", "golden", "clusters", name+".json")
+			if *updateGolden {
+				assert.NoError(t, ioutil.WriteFile(golden, encoded, 0644))
+				return
+			}
+
+			expected, err := ioutil.ReadFile(golden)
+			if assert.NoError(t, err) {
+				assert.JSONEq(t, string(expected), string(encoded))
+			}
+
+			// Decoding the encoding again doesn't lose anything either.
+			var decoded Cluster
+			if assert.NoError(t, json.Unmarshal(encoded, &decoded)) {
+				assert.Equal(t, cluster, decoded)
+			}
+		})
+	}
+}
+
+func TestClusterFixturesKnownKeys(t *testing.T) {
+	paths, err := filepath.Glob("testdata/clusters/*.json")
+	if !assert.NoError(t, err) || !assert.NotEmpty(t, paths) {
+		return
+	}
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if !assert.NoError(t, err) {
+			continue
+		}
+
+		unknown, err := unknownKeys(data, reflect.TypeOf(Cluster{}))
+		if assert.NoError(t, err) {
+			assert.Emptyf(t, unknown, "%s has keys atlas.Cluster doesn't carry, add them so they aren't dropped", path)
+		}
+	}
+}
+
+func TestUnknownKeys(t *testing.T) {
+	data := []byte(`{
+		"name": "cluster",
+		"newFeature": true,
+		"providerSettings": {"providerName": "AWS", "newSetting": 1},
+		"replicationSpecs": [{"regionsConfig": {"US_EAST_1": {"electableNodes": 3, "newNodes": 1}}}]
+	}`)
+
+	unknown, err := unknownKeys(data, reflect.TypeOf(Cluster{}))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"newFeature",
+		"providerSettings.newSetting",
+		"replicationSpecs.0.regionsConfig.US_EAST_1.newNodes",
+	}, unknown)
+}
+
+// unknownKeys returns the paths of the keys in a JSON document which don't
+// match a field of t, so additions to the Atlas API are noticed instead of
+// being dropped silently when decoding.
+func unknownKeys(data []byte, t reflect.Type) ([]string, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var document interface{}
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	unknown := []string{}
+	collectUnknownKeys(document, t, "", &unknown)
+	sort.Strings(unknown)
+	return unknown, nil
+}
+
+func collectUnknownKeys(value interface{}, t reflect.Type, path string, unknown *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch value := value.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, element := range value {
+				collectUnknownKeys(element, t.Elem(), joinPath(path, key), unknown)
+			}
+		case reflect.Struct:
+			fields := jsonFields(t)
+			for key, element := range value {
+				field, ok := fields[key]
+				if !ok {
+					*unknown = append(*unknown, joinPath(path, key))
+					continue
+				}
+
+				collectUnknownKeys(element, field, joinPath(path, key), unknown)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, element := range value {
+				collectUnknownKeys(element, t.Elem(), joinPath(path, fmt.Sprint(i)), unknown)
+			}
+		}
+	}
+}
+
+// jsonFields maps the JSON names of the fields of a struct onto their types.
+func jsonFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if field.PkgPath != "" || name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
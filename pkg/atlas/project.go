@@ -0,0 +1,53 @@
+package atlas
+
+import (
+	"net/http"
+)
+
+// ProjectSettings represents the settings of an Atlas project. Fields are
+// pointers so updates only change the settings which are set.
+type ProjectSettings struct {
+	IsCollectDatabaseSpecificsStatisticsEnabled *bool `json:"isCollectDatabaseSpecificsStatisticsEnabled,omitempty"`
+	IsDataExplorerEnabled                       *bool `json:"isDataExplorerEnabled,omitempty"`
+	IsPerformanceAdvisorEnabled                 *bool `json:"isPerformanceAdvisorEnabled,omitempty"`
+	IsRealtimePerformancePanelEnabled           *bool `json:"isRealtimePerformancePanelEnabled,omitempty"`
+	IsSchemaAdvisorEnabled                      *bool `json:"isSchemaAdvisorEnabled,omitempty"`
+}
+
+// GetProjectSettings will return the settings of the project.
+// GET /settings
+func (c *HTTPClient) GetProjectSettings() (*ProjectSettings, error) {
+	var settings ProjectSettings
+	err := c.requestPublic(http.MethodGet, "settings", nil, &settings)
+	return &settings, err
+}
+
+// UpdateProjectSettings will change the settings of the project which are set
+// and return all settings.
+// PATCH /settings
+func (c *HTTPClient) UpdateProjectSettings(settings ProjectSettings) (*ProjectSettings, error) {
+	var resultingSettings ProjectSettings
+	err := c.requestPublic(http.MethodPatch, "settings", settings, &resultingSettings)
+	return &resultingSettings, err
+}
+
+// Change statuses of a project. Changes such as new database users are
+// pending until Atlas has deployed them to all clusters of the project.
+const (
+	ChangeStatusPending = "PENDING"
+	ChangeStatusApplied = "APPLIED"
+)
+
+// ProjectStatus represents the deployment status of the changes to a project.
+type ProjectStatus struct {
+	ChangeStatus string `json:"changeStatus"`
+}
+
+// GetProjectStatus will return whether the latest changes to the project
+// have been deployed.
+// GET /status
+func (c *HTTPClient) GetProjectStatus() (*ProjectStatus, error) {
+	var status ProjectStatus
+	err := c.requestPublic(http.MethodGet, "status", nil, &status)
+	return &status, err
+}
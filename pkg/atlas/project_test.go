@@ -0,0 +1,81 @@
+package atlas
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProjectSettingsRoundTrip(t *testing.T) {
+	// Settings as returned by the Atlas API.
+	data := `{
+		"isCollectDatabaseSpecificsStatisticsEnabled": true,
+		"isDataExplorerEnabled": false,
+		"isPerformanceAdvisorEnabled": true,
+		"isRealtimePerformancePanelEnabled": false,
+		"isSchemaAdvisorEnabled": true
+	}`
+
+	var settings ProjectSettings
+	if !assert.NoError(t, json.Unmarshal([]byte(data), &settings)) {
+		return
+	}
+
+	enabled, disabled := true, false
+	assert.Equal(t, ProjectSettings{
+		IsCollectDatabaseSpecificsStatisticsEnabled: &enabled,
+		IsDataExplorerEnabled:                       &disabled,
+		IsPerformanceAdvisorEnabled:                 &enabled,
+		IsRealtimePerformancePanelEnabled:           &disabled,
+		IsSchemaAdvisorEnabled:                      &enabled,
+	}, settings)
+
+	encoded, err := json.Marshal(settings)
+	assert.NoError(t, err)
+	assert.JSONEq(t, data, string(encoded))
+
+	// Unset settings are left out so updates don't change them.
+	encoded, err = json.Marshal(ProjectSettings{IsDataExplorerEnabled: &disabled})
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"isDataExplorerEnabled": false}`, string(encoded))
+}
+
+func TestGetProjectSettings(t *testing.T) {
+	enabled := true
+	expected := ProjectSettings{IsDataExplorerEnabled: &enabled}
+
+	atlas, server := setupTest(t, "/settings", http.MethodGet, 200, expected)
+	defer server.Close()
+
+	settings, err := atlas.GetProjectSettings()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, settings)
+}
+
+func TestUpdateProjectSettings(t *testing.T) {
+	disabled := false
+	expected := ProjectSettings{IsDataExplorerEnabled: &disabled}
+
+	atlas, server := setupTest(t, "/settings", http.MethodPatch, 200, expected)
+	defer server.Close()
+
+	settings, err := atlas.UpdateProjectSettings(expected)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, settings)
+}
+
+func TestGetProjectStatus(t *testing.T) {
+	expected := ProjectStatus{ChangeStatus: ChangeStatusPending}
+
+	atlas, server := setupTest(t, "/status", http.MethodGet, 200, expected)
+	defer server.Close()
+
+	status, err := atlas.GetProjectStatus()
+
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, status)
+}
@@ -0,0 +1,56 @@
+package atlas
+
+import (
+	"net/http"
+	"strconv"
+)
+
+// Headers Atlas uses to report the request budget of the current window.
+const (
+	HeaderRateLimitLimit     = "X-RateLimit-Limit"
+	HeaderRateLimitRemaining = "X-RateLimit-Remaining"
+)
+
+// RateLimit is the request budget Atlas reported with a response.
+type RateLimit struct {
+	// Limit is the number of requests allowed per window.
+	Limit int
+
+	// Remaining is the number of requests left in the current window.
+	Remaining int
+}
+
+// WithRateLimitObserver calls observe with the group ID of the client and the
+// budget reported by every Atlas response which carries rate limit headers.
+// It's called from the goroutine making the request.
+func WithRateLimitObserver(observe func(groupID string, limit RateLimit)) ClientOption {
+	return func(c *HTTPClient) {
+		c.rateLimitObserver = observe
+	}
+}
+
+// rateLimitFromHeader parses the rate limit headers of a response. It returns
+// false if the remaining budget isn't reported.
+func rateLimitFromHeader(header http.Header) (RateLimit, bool) {
+	remaining, err := strconv.Atoi(header.Get(HeaderRateLimitRemaining))
+	if err != nil {
+		return RateLimit{}, false
+	}
+
+	// The limit is informational, an invalid one doesn't invalidate the
+	// remaining budget.
+	limit, _ := strconv.Atoi(header.Get(HeaderRateLimitLimit))
+
+	return RateLimit{Limit: limit, Remaining: remaining}, true
+}
+
+// observeRateLimit passes the budget reported by a response to the observer.
+func (c *HTTPClient) observeRateLimit(resp *http.Response) {
+	if c.rateLimitObserver == nil {
+		return
+	}
+
+	if limit, ok := rateLimitFromHeader(resp.Header); ok {
+		c.rateLimitObserver(c.GroupID, limit)
+	}
+}
@@ -0,0 +1,57 @@
+package atlas
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimitFromHeader(t *testing.T) {
+	header := http.Header{}
+	_, ok := rateLimitFromHeader(header)
+	assert.False(t, ok)
+
+	header.Set(HeaderRateLimitRemaining, "42")
+	limit, ok := rateLimitFromHeader(header)
+	assert.True(t, ok)
+	assert.Equal(t, RateLimit{Remaining: 42}, limit)
+
+	header.Set(HeaderRateLimitLimit, "100")
+	limit, ok = rateLimitFromHeader(header)
+	assert.True(t, ok)
+	assert.Equal(t, RateLimit{Limit: 100, Remaining: 42}, limit)
+
+	header.Set(HeaderRateLimitRemaining, "many")
+	_, ok = rateLimitFromHeader(header)
+	assert.False(t, ok)
+}
+
+func TestWithRateLimitObserver(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		if len(req.Header["Authorization"]) == 0 {
+			rw.WriteHeader(401)
+			return
+		}
+
+		rw.Header().Set(HeaderRateLimitLimit, "100")
+		rw.Header().Set(HeaderRateLimitRemaining, "7")
+		rw.Write([]byte(`{"name": "Cluster"}`))
+	}))
+	defer s.Close()
+
+	var groupID string
+	var observed RateLimit
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey", WithRateLimitObserver(func(group string, limit RateLimit) {
+		groupID = group
+		observed = limit
+	}))
+	atlas.HTTP = s.Client()
+
+	_, err := atlas.GetCluster("Cluster")
+
+	assert.NoError(t, err)
+	assert.Equal(t, "group", groupID)
+	assert.Equal(t, RateLimit{Limit: 100, Remaining: 7}, observed)
+}
@@ -1,32 +1,74 @@
 package atlas
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 )
 
+// Authentication databases of database users.
+const (
+	AuthDatabaseAdmin    = "admin"
+	AuthDatabaseExternal = "$external"
+)
+
+// X.509 types of database users. Users of type MANAGED authenticate with
+// certificates generated by Atlas.
+const (
+	X509TypeNone    = "NONE"
+	X509TypeManaged = "MANAGED"
+)
+
+// ScopeTypeCluster scopes a database user to a single cluster of the project.
+const ScopeTypeCluster = "CLUSTER"
+
 // User represents a single Atlas database user.
 type User struct {
-	Username     string `json:"username"`
-	Password     string `json:"password"`
-	DatabaseName string `json:"databaseName"`
-	LDAPAuthType string `json:"ldapAuthType,omitempty"`
-	Roles        []Role `json:"roles,omitempty"`
+	Username     string  `json:"username"`
+	Password     string  `json:"password,omitempty"`
+	DatabaseName string  `json:"databaseName" description:"Authentication database of the user."`
+	LDAPAuthType string  `json:"ldapAuthType,omitempty" description:"One of NONE, USER or GROUP."`
+	X509Type     string  `json:"x509Type,omitempty" description:"Set to MANAGED to authenticate with a certificate generated by Atlas instead of a password."`
+	Roles        []Role  `json:"roles,omitempty" description:"Roles granted to the user."`
+	Labels       []Label `json:"labels,omitempty" description:"Labels attached to the user."`
+	Scopes       []Scope `json:"scopes,omitempty" description:"Clusters the user can authenticate against, all clusters of the project if empty."`
+}
+
+// Scope restricts a database user to a resource of the project. Users
+// without scopes can authenticate against every cluster of the project.
+type Scope struct {
+	Name string `json:"name" description:"Name of the cluster."`
+	Type string `json:"type" description:"Type of the resource, CLUSTER."`
 }
 
 // Role represents the role of a database user.
 type Role struct {
-	Name           string `json:"roleName"`
-	DatabaseName   string `json:"databaseName,omitempty"`
-	CollectionName string `json:"collectionName,omitempty"`
+	Name           string `json:"roleName" description:"Name of the role, for example readWrite."`
+	DatabaseName   string `json:"databaseName,omitempty" description:"Database the role applies to."`
+	CollectionName string `json:"collectionName,omitempty" description:"Collection the role applies to."`
+}
+
+// IsX509 returns whether the user authenticates with X.509 certificates.
+func (u User) IsX509() bool {
+	return u.X509Type != "" && u.X509Type != X509TypeNone
+}
+
+// AuthDatabase returns the authentication database of the user. Users
+// authenticating with certificates are kept in $external, all others in
+// admin.
+func (u User) AuthDatabase() string {
+	if u.IsX509() {
+		return AuthDatabaseExternal
+	}
+
+	return AuthDatabaseAdmin
 }
 
 // CreateUser will create a new database user with read/write access to all
 // databases.
 // Endpoint: POST /databaseUsers
 func (c *HTTPClient) CreateUser(user User) (*User, error) {
-	// Atlas always uses "admin" for the authentication database.
-	user.DatabaseName = "admin"
+	user.DatabaseName = user.AuthDatabase()
 
 	var resultingUser User
 	err := c.requestPublic(http.MethodPost, "databaseUsers", user, &resultingUser)
@@ -43,9 +85,52 @@ func (c *HTTPClient) GetUser(name string) (*User, error) {
 	return &user, err
 }
 
-// DeleteUser will delete an existing database user.
-// Endpoint: DELETE /databaseUsers/{USERNAME}
-func (c *HTTPClient) DeleteUser(name string) error {
-	path := fmt.Sprintf("databaseUsers/admin/%s", name)
+// ListUsers will return all database users in the project.
+// GET /databaseUsers
+func (c *HTTPClient) ListUsers() ([]User, error) {
+	users := []User{}
+	err := c.listPublic("databaseUsers", func(data json.RawMessage) (int, error) {
+		var page []User
+		if err := json.Unmarshal(data, &page); err != nil {
+			return 0, newDecodeError(err)
+		}
+
+		users = append(users, page...)
+		return len(page), nil
+	})
+
+	return users, err
+}
+
+// UpdateUser will update an existing database user in its authentication
+// database. Only the fields which are set are changed.
+// Endpoint: PATCH /databaseUsers/{DATABASE}/{USERNAME}
+func (c *HTTPClient) UpdateUser(user User) (*User, error) {
+	user.DatabaseName = user.AuthDatabase()
+	path := fmt.Sprintf("databaseUsers/%s/%s", user.DatabaseName, user.Username)
+
+	var resultingUser User
+	err := c.requestPublic(http.MethodPatch, path, user, &resultingUser)
+	return &resultingUser, err
+}
+
+// DeleteUser will delete an existing database user from its authentication
+// database.
+// Endpoint: DELETE /databaseUsers/{DATABASE}/{USERNAME}
+func (c *HTTPClient) DeleteUser(databaseName string, name string) error {
+	path := fmt.Sprintf("databaseUsers/%s/%s", databaseName, name)
 	return c.requestPublic(http.MethodDelete, path, nil, nil)
 }
+
+// CreateUserCertificate will generate a client certificate for a user of
+// x509Type MANAGED. The certificate and its private key are returned as a PEM
+// bundle, Atlas doesn't keep the private key.
+// Endpoint: POST /databaseUsers/{USERNAME}/certs
+func (c *HTTPClient) CreateUserCertificate(name string, monthsUntilExpiration int) (string, error) {
+	path := fmt.Sprintf("databaseUsers/%s/certs", name)
+	body := map[string]int{"monthsUntilExpiration": monthsUntilExpiration}
+
+	var pem []byte
+	err := c.requestPublic(http.MethodPost, path, body, &pem)
+	return string(pem), err
+}
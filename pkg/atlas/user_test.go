@@ -0,0 +1,108 @@
+package atlas
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListUsers(t *testing.T) {
+	expected := []User{
+		User{Username: "user1", DatabaseName: "admin"},
+		User{Username: "user2", DatabaseName: "admin"},
+	}
+
+	response := map[string]interface{}{
+		"results":    expected,
+		"totalCount": len(expected),
+	}
+
+	atlas, server := setupTest(t, "/databaseUsers?pageNum=1&itemsPerPage=500", http.MethodGet, 200, response)
+	defer server.Close()
+
+	users, err := atlas.ListUsers()
+
+	assert.NoError(t, err)
+	assert.Equal(t, expected, users)
+}
+
+func TestDeleteNonexistentUser(t *testing.T) {
+	for _, code := range []string{"USER_NOT_FOUND", "USERNAME_NOT_FOUND"} {
+		atlas, server := setupTest(t, "/databaseUsers/admin/user", http.MethodDelete, 404, errorResponse(code))
+
+		err := atlas.DeleteUser(AuthDatabaseAdmin, "user")
+		server.Close()
+
+		assert.Equal(t, ErrUserNotFound, err, code)
+	}
+}
+
+func TestDeleteExternalUser(t *testing.T) {
+	atlas, server := setupTest(t, "/databaseUsers/$external/user", http.MethodDelete, 202, nil)
+	defer server.Close()
+
+	assert.NoError(t, atlas.DeleteUser(AuthDatabaseExternal, "user"))
+}
+
+func TestUserAuthDatabase(t *testing.T) {
+	assert.Equal(t, AuthDatabaseAdmin, User{}.AuthDatabase())
+	assert.Equal(t, AuthDatabaseAdmin, User{X509Type: X509TypeNone}.AuthDatabase())
+	assert.Equal(t, AuthDatabaseExternal, User{X509Type: X509TypeManaged}.AuthDatabase())
+}
+
+func TestCreateUserCertificate(t *testing.T) {
+	const pem = "-----BEGIN CERTIFICATE-----\n...\n-----END CERTIFICATE-----\n"
+
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		assert.Equal(t, publicAPIPath+"/groups/group/databaseUsers/user/certs", req.URL.String())
+		assert.Equal(t, http.MethodPost, req.Method)
+
+		if len(req.Header["Authorization"]) == 0 {
+			rw.WriteHeader(401)
+			return
+		}
+
+		var body map[string]int
+		json.NewDecoder(req.Body).Decode(&body)
+		assert.Equal(t, map[string]int{"monthsUntilExpiration": 3}, body)
+
+		rw.Write([]byte(pem))
+	}))
+	defer s.Close()
+
+	atlas := NewClient(s.URL, "group", "pubkey", "privkey")
+	atlas.HTTP = s.Client()
+
+	certificate, err := atlas.CreateUserCertificate("user", 3)
+	assert.NoError(t, err)
+	assert.Equal(t, pem, certificate)
+}
+
+func TestGetUserScopes(t *testing.T) {
+	expected := User{
+		Username:     "user",
+		DatabaseName: "admin",
+		Scopes:       []Scope{{Name: "cluster", Type: ScopeTypeCluster}},
+	}
+
+	atlas, server := setupTest(t, "/databaseUsers/admin/user", http.MethodGet, 200, expected)
+	defer server.Close()
+
+	user, err := atlas.GetUser("user")
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, user)
+}
+
+func TestUpdateExternalUser(t *testing.T) {
+	expected := User{Username: "user", DatabaseName: AuthDatabaseExternal, X509Type: X509TypeManaged}
+
+	atlas, server := setupTest(t, "/databaseUsers/$external/user", http.MethodPatch, 200, expected)
+	defer server.Close()
+
+	user, err := atlas.UpdateUser(User{Username: "user", X509Type: X509TypeManaged})
+	assert.NoError(t, err)
+	assert.Equal(t, &expected, user)
+}
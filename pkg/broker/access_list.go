@@ -0,0 +1,130 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// maxAccessListCommentLength is the longest comment Atlas accepts on an
+// access list entry.
+const maxAccessListCommentLength = 80
+
+// accessListCommentTag returns the marker the broker appends to the comments
+// of access list entries it creates for an instance, so they can be told
+// apart from entries managed elsewhere.
+func accessListCommentTag(instanceID string) string {
+	return fmt.Sprintf("[%s%s]", LabelPrefix, instanceID)
+}
+
+// accessListFromParams returns the project IP access list entries requested
+// by the "ipAccessList" parameter, with their comments tagged with the
+// instance. Entries which aren't a valid CIDR block or IP address result in a
+// *ValidationError.
+func accessListFromParams(instanceID string, rawParams []byte) ([]atlas.AccessListEntry, error) {
+	params := struct {
+		IPAccessList []atlas.AccessListEntry `json:"ipAccessList"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	tag := accessListCommentTag(instanceID)
+	maxComment := maxAccessListCommentLength - len(tag) - 1
+
+	verr := &ValidationError{}
+	entries := make([]atlas.AccessListEntry, len(params.IPAccessList))
+	for i, entry := range params.IPAccessList {
+		field := fmt.Sprintf("ipAccessList[%d]", i)
+
+		switch {
+		case entry.CIDRBlock != "" && entry.IPAddress != "":
+			verr.add(field, "must have either a cidrBlock or an ipAddress, not both")
+		case entry.CIDRBlock != "":
+			ip, network, err := net.ParseCIDR(entry.CIDRBlock)
+			if err != nil {
+				verr.add(field+".cidrBlock", "must be a CIDR block such as 10.0.0.0/8")
+			} else if !ip.Equal(network.IP) {
+				verr.add(field+".cidrBlock", "must not have bits set after the prefix, did you mean %s?", network.String())
+			}
+		case entry.IPAddress != "":
+			if net.ParseIP(entry.IPAddress) == nil {
+				verr.add(field+".ipAddress", "must be an IP address such as 192.0.2.1")
+			}
+		default:
+			verr.add(field, "must have either a cidrBlock or an ipAddress")
+		}
+
+		if len(entry.Comment) > maxComment {
+			verr.add(field+".comment", "must be at most %d characters", maxComment)
+		}
+
+		if entry.Comment == "" {
+			entry.Comment = tag
+		} else {
+			entry.Comment += " " + tag
+		}
+
+		entries[i] = entry
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// applyAccessList adds entries to the project IP access list if there are
+// any to add.
+func (b Broker) applyAccessList(client atlas.Client, entries []atlas.AccessListEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if _, err := client.CreateAccessListEntries(entries); err != nil {
+		b.logger.Errorw("Failed to add IP access list entries", "error", err, "entries", entries)
+		return atlasToAPIError(err)
+	}
+
+	return nil
+}
+
+// removeAccessList removes the project IP access list entries the broker
+// created for an instance. Entries are recognized by the tag in their
+// comment.
+func (b Broker) removeAccessList(client atlas.Client, instanceID string) error {
+	entries, err := client.ListAccessListEntries()
+	if err != nil {
+		b.logger.Errorw("Failed to list IP access list entries", "error", err)
+		return atlasToAPIError(err)
+	}
+
+	tag := accessListCommentTag(instanceID)
+	for _, entry := range entries {
+		if !strings.HasSuffix(entry.Comment, tag) {
+			continue
+		}
+
+		err := client.DeleteAccessListEntry(entry.Entry())
+
+		// Entries removed in the meantime don't need to be removed again.
+		if apiErr, ok := err.(*atlas.APIError); ok && apiErr.StatusCode == http.StatusNotFound {
+			err = nil
+		}
+
+		if err != nil {
+			b.logger.Errorw("Failed to remove IP access list entry", "error", err, "entry", entry)
+			return atlasToAPIError(err)
+		}
+	}
+
+	return nil
+}
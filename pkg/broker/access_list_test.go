@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccessListFromParams(t *testing.T) {
+	entries, err := accessListFromParams("instance", nil)
+	assert.NoError(t, err)
+	assert.Empty(t, entries)
+
+	entries, err = accessListFromParams("instance", []byte(`{"ipAccessList": [{"cidrBlock": "10.0.0.0/8", "comment": "platform"}, {"ipAddress": "2001:db8::1"}]}`))
+	assert.NoError(t, err)
+	assert.Equal(t, []atlas.AccessListEntry{
+		atlas.AccessListEntry{CIDRBlock: "10.0.0.0/8", Comment: "platform [aosb-instance]"},
+		atlas.AccessListEntry{IPAddress: "2001:db8::1", Comment: "[aosb-instance]"},
+	}, entries)
+}
+
+func TestAccessListFromParamsInvalid(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		field  string
+	}{
+		{"invalid CIDR block", `{"ipAccessList": [{"cidrBlock": "10.0.0.0/33"}]}`, "ipAccessList[0].cidrBlock"},
+		{"host bits set", `{"ipAccessList": [{"cidrBlock": "10.1.2.3/8"}]}`, "ipAccessList[0].cidrBlock"},
+		{"invalid IP address", `{"ipAccessList": [{"ipAddress": "10.0.0.256"}]}`, "ipAccessList[0].ipAddress"},
+		{"CIDR block as IP address", `{"ipAccessList": [{"ipAddress": "10.0.0.0/8"}]}`, "ipAccessList[0].ipAddress"},
+		{"both", `{"ipAccessList": [{"cidrBlock": "10.0.0.0/8", "ipAddress": "10.0.0.1"}]}`, "ipAccessList[0]"},
+		{"neither", `{"ipAccessList": [{"ipAddress": "10.0.0.1"}, {"comment": "empty"}]}`, "ipAccessList[1]"},
+		{"long comment", `{"ipAccessList": [{"ipAddress": "10.0.0.1", "comment": "` + strings.Repeat("a", 80) + `"}]}`, "ipAccessList[0].comment"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := accessListFromParams("instance", []byte(test.params))
+
+			verr, ok := err.(*ValidationError)
+			if assert.True(t, ok, "Expected a validation error") && assert.Len(t, verr.Violations, 1) {
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestProvisionAccessList(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"ipAccessList": [{"cidrBlock": "10.0.0.0/8", "comment": "platform"}]}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.AccessListEntry{CIDRBlock: "10.0.0.0/8", Comment: "platform [aosb-instance]"}, client.AccessList["10.0.0.0/8"])
+}
+
+func TestProvisionInvalidAccessList(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"ipAccessList": [{"cidrBlock": "10.0.0.0"}]}`),
+	}, true)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "ipAccessList[0].cidrBlock")
+	}
+
+	assert.Nil(t, client.Clusters["instance"], "Expected no cluster to be created")
+	assert.Empty(t, client.AccessList)
+}
+
+func TestDeprovisionAccessListCleanup(t *testing.T) {
+	for _, cleanup := range []bool{false, true} {
+		broker, client, ctx := setupTest(WithAccessListCleanup(cleanup))
+
+		_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+			PlanID:        testPlanID,
+			ServiceID:     testServiceID,
+			RawParameters: []byte(`{"ipAccessList": [{"cidrBlock": "10.0.0.0/8"}, {"ipAddress": "192.0.2.1"}]}`),
+		}, true)
+		assert.NoError(t, err)
+
+		// Entries of other instances and those added outside of the broker
+		// are left alone.
+		client.AccessList["192.0.2.2"] = &atlas.AccessListEntry{IPAddress: "192.0.2.2", Comment: "[aosb-other]"}
+		client.AccessList["192.0.2.3"] = &atlas.AccessListEntry{IPAddress: "192.0.2.3", Comment: "office"}
+
+		_, err = broker.Deprovision(ctx, "instance", brokerapi.DeprovisionDetails{
+			PlanID:    testPlanID,
+			ServiceID: testServiceID,
+		}, true)
+		assert.NoError(t, err)
+
+		entries, _ := client.ListAccessListEntries()
+		if cleanup {
+			assert.Len(t, entries, 2)
+			assert.Nil(t, client.AccessList["10.0.0.0/8"])
+			assert.Nil(t, client.AccessList["192.0.2.1"])
+		} else {
+			assert.Len(t, entries, 4)
+		}
+	}
+}
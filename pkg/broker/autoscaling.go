@@ -0,0 +1,137 @@
+package broker
+
+import (
+	"strconv"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// computeAutoScalingLimits returns the instance sizes compute auto-scaling
+// stays within. Limits missing from the cluster are taken from the existing
+// cluster, as updates leave them unchanged in Atlas.
+func computeAutoScalingLimits(cluster *atlas.Cluster, existing *atlas.Cluster) *atlas.ComputeInstanceSizeLimits {
+	for _, c := range []*atlas.Cluster{cluster, existing} {
+		if c == nil || c.ProviderSettings == nil || c.ProviderSettings.AutoScaling == nil {
+			continue
+		}
+
+		if limits := c.ProviderSettings.AutoScaling.Compute; limits != nil {
+			return limits
+		}
+	}
+
+	return nil
+}
+
+// computeAutoScalingRequested returns whether compute auto-scaling is enabled
+// for a cluster once it's been created or updated. Clusters which don't
+// mention it keep the setting of the existing cluster.
+func computeAutoScalingRequested(cluster *atlas.Cluster, existing *atlas.Cluster) bool {
+	if cluster.AutoScaling != nil && cluster.AutoScaling.Compute != nil {
+		return cluster.AutoScaling.Compute.Enabled
+	}
+
+	return existing != nil && computeAutoScalingEnabled(existing)
+}
+
+// validateComputeAutoScaling checks the instance size limits of compute
+// auto-scaling. Atlas requires a maximum, and the instance size of the plan
+// has to be within the limits so the plan stays a valid starting point.
+func validateComputeAutoScaling(verr *ValidationError, planCtx PlanContext, cluster *atlas.Cluster) {
+	limits := computeAutoScalingLimits(cluster, planCtx.Existing)
+	if !computeAutoScalingRequested(cluster, planCtx.Existing) {
+		if limits != nil && (limits.MinInstanceSize != "" || limits.MaxInstanceSize != "") && cluster.ProviderSettings != nil && cluster.ProviderSettings.AutoScaling != nil {
+			verr.add("cluster.providerSettings.autoScaling.compute", "requires cluster.autoScaling.compute.enabled")
+		}
+
+		return
+	}
+
+	settings, _ := planCtx.providerSettings()
+	if isSharedInstanceSizeName(settings.InstanceSizeName) {
+		verr.add("cluster.autoScaling.compute.enabled", "is not supported by shared instance sizes")
+		return
+	}
+
+	// Existing clusters whose limits aren't known are left to Atlas unless
+	// the parameters enable auto-scaling themselves.
+	enabledByCluster := cluster.AutoScaling != nil && cluster.AutoScaling.Compute != nil
+	if limits == nil && !enabledByCluster {
+		return
+	}
+
+	if limits == nil || limits.MaxInstanceSize == "" {
+		verr.add("cluster.providerSettings.autoScaling.compute.maxInstanceSize", "is required when compute auto-scaling is enabled")
+		return
+	}
+
+	max, ok := instanceSizeNumber(limits.MaxInstanceSize)
+	if !ok {
+		verr.add("cluster.providerSettings.autoScaling.compute.maxInstanceSize", `"%s" is not an instance size`, limits.MaxInstanceSize)
+		return
+	}
+
+	min := 0
+	if limits.MinInstanceSize != "" {
+		if min, ok = instanceSizeNumber(limits.MinInstanceSize); !ok {
+			verr.add("cluster.providerSettings.autoScaling.compute.minInstanceSize", `"%s" is not an instance size`, limits.MinInstanceSize)
+			return
+		}
+	}
+
+	if min > max {
+		verr.add("cluster.providerSettings.autoScaling.compute.minInstanceSize", `must not be larger than maxInstanceSize "%s"`, limits.MaxInstanceSize)
+		return
+	}
+
+	if planCtx.InstanceSize == nil {
+		return
+	}
+
+	if size, ok := instanceSizeNumber(planCtx.InstanceSize.Name); ok && (size < min || size > max) {
+		verr.add("cluster.providerSettings.autoScaling.compute", `must include the instance size "%s" of the plan`, planCtx.InstanceSize.Name)
+	}
+}
+
+// autoScaledInstanceSize returns the instance size an update keeps when
+// compute auto-scaling manages the instance size of the cluster: the current
+// size, moved into the limits if they have been changed.
+func autoScaledInstanceSize(cluster *atlas.Cluster, existing *atlas.Cluster) string {
+	current := existing.ProviderSettings.InstanceSizeName
+
+	limits := computeAutoScalingLimits(cluster, existing)
+	if limits == nil {
+		return current
+	}
+
+	size, ok := instanceSizeNumber(current)
+	if !ok {
+		return current
+	}
+
+	if min, ok := instanceSizeNumber(limits.MinInstanceSize); ok && size < min {
+		return limits.MinInstanceSize
+	}
+
+	if max, ok := instanceSizeNumber(limits.MaxInstanceSize); ok && size > max {
+		return limits.MaxInstanceSize
+	}
+
+	return current
+}
+
+// instanceSizeNumber returns the number of an instance size name such as
+// "M30", and false if the name isn't of that form.
+func instanceSizeNumber(name string) (int, bool) {
+	match := instanceSizeNumberPattern.FindStringSubmatch(name)
+	if match == nil {
+		return 0, false
+	}
+
+	number, err := strconv.Atoi(match[1])
+	if err != nil {
+		return 0, false
+	}
+
+	return number, true
+}
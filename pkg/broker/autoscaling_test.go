@@ -0,0 +1,143 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+const testComputeAutoScalingParams = `{"cluster": {
+	"autoScaling": {"compute": {"enabled": true, "scaleDownEnabled": true}},
+	"providerSettings": {"autoScaling": {"compute": {"minInstanceSize": "M10", "maxInstanceSize": "M40"}}}
+}}`
+
+func TestClusterFromParamsComputeAutoScaling(t *testing.T) {
+	cluster, err := ClusterFromParams(testPlanContext(), []byte(testComputeAutoScalingParams))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &atlas.ComputeAutoScalingConfig{Enabled: true, ScaleDownEnabled: true}, cluster.AutoScaling.Compute)
+		assert.Equal(t, &atlas.ComputeInstanceSizeLimits{MinInstanceSize: "M10", MaxInstanceSize: "M40"}, cluster.ProviderSettings.AutoScaling.Compute)
+		assert.Equal(t, "M10", cluster.ProviderSettings.InstanceSizeName)
+	}
+
+	shared := testPlanContext()
+	shared.InstanceSize = &atlas.InstanceSize{Name: "M2"}
+
+	tests := []struct {
+		name    string
+		planCtx PlanContext
+		params  string
+		field   string
+	}{
+		{"missing maximum", testPlanContext(), `{"cluster": {"autoScaling": {"compute": {"enabled": true}}}}`, "cluster.providerSettings.autoScaling.compute.maxInstanceSize"},
+		{"unknown maximum", testPlanContext(), `{"cluster": {"autoScaling": {"compute": {"enabled": true}}, "providerSettings": {"autoScaling": {"compute": {"maxInstanceSize": "XL"}}}}}`, "cluster.providerSettings.autoScaling.compute.maxInstanceSize"},
+		{"minimum above maximum", testPlanContext(), `{"cluster": {"autoScaling": {"compute": {"enabled": true}}, "providerSettings": {"autoScaling": {"compute": {"minInstanceSize": "M40", "maxInstanceSize": "M30"}}}}}`, "cluster.providerSettings.autoScaling.compute.minInstanceSize"},
+		{"plan outside limits", testPlanContext(), `{"cluster": {"autoScaling": {"compute": {"enabled": true}}, "providerSettings": {"autoScaling": {"compute": {"minInstanceSize": "M20", "maxInstanceSize": "M40"}}}}}`, "cluster.providerSettings.autoScaling.compute"},
+		{"limits without auto-scaling", testPlanContext(), `{"cluster": {"providerSettings": {"autoScaling": {"compute": {"maxInstanceSize": "M40"}}}}}`, "cluster.providerSettings.autoScaling.compute"},
+		{"shared instance size", shared, `{"cluster": {"autoScaling": {"compute": {"enabled": true}}}}`, "cluster.autoScaling.compute.enabled"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ClusterFromParams(test.planCtx, []byte(test.params))
+
+			verr, ok := err.(*ValidationError)
+			if assert.True(t, ok, "Expected a validation error") && assert.Len(t, verr.Violations, 1) {
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestProvisionComputeAutoScaling(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testComputeAutoScalingParams),
+	}, true)
+
+	assert.NoError(t, err)
+
+	cluster := client.Clusters["instance"]
+	if assert.NotNil(t, cluster) {
+		assert.True(t, computeAutoScalingEnabled(cluster))
+		assert.Equal(t, "M10", cluster.ProviderSettings.InstanceSizeName)
+		assert.Equal(t, "M40", cluster.ProviderSettings.AutoScaling.Compute.MaxInstanceSize)
+	}
+}
+
+func TestUpdateComputeAutoScalingKeepsInstanceSize(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testComputeAutoScalingParams),
+	}, true)
+	client.Clusters["instance"].ProviderSettings.InstanceSizeName = "M30"
+
+	// Platforms may send the unchanged plan with every update.
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "M30", client.Clusters["instance"].ProviderSettings.InstanceSizeName, "Expected the auto-scaled instance size to be kept")
+}
+
+func TestUpdateComputeAutoScalingLimits(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testComputeAutoScalingParams),
+	}, true)
+	client.Clusters["instance"].ProviderSettings.InstanceSizeName = "M30"
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"allowDowngrade": true, "cluster": {"providerSettings": {"autoScaling": {"compute": {"minInstanceSize": "M10", "maxInstanceSize": "M20"}}}}}`),
+	}, true)
+
+	assert.NoError(t, err)
+
+	cluster := client.Clusters["instance"]
+	assert.Equal(t, "M20", cluster.ProviderSettings.InstanceSizeName, "Expected the instance size to be moved into the new limits")
+	assert.Equal(t, &atlas.ComputeInstanceSizeLimits{MinInstanceSize: "M10", MaxInstanceSize: "M20"}, cluster.ProviderSettings.AutoScaling.Compute)
+
+	// Limits which exclude the plan are rejected.
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"providerSettings": {"autoScaling": {"compute": {"minInstanceSize": "M30", "maxInstanceSize": "M60"}}}}}`),
+	}, true)
+
+	assertInvalidParams(t, err, "cluster.providerSettings.autoScaling.compute")
+}
+
+func TestUpdateComputeAutoScalingPlanChange(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testComputeAutoScalingParams),
+	}, true)
+	client.Clusters["instance"].ProviderSettings.InstanceSizeName = "M30"
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        "aosb-cluster-plan-aws-m20",
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"allowDowngrade": true}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "M20", client.Clusters["instance"].ProviderSettings.InstanceSizeName, "Expected the new plan to dictate the instance size")
+}
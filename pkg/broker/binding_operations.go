@@ -2,27 +2,154 @@ package broker
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
 
 	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
 	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 )
 
-// ConnectionDetails will be returned when a new binding is created.
+// ConnectionDetails will be returned when a new binding is created. Its JSON
+// encoding is the contract with apps: keys are snake_case and optional fields
+// are left out when empty, only username and uri are always present. Keys
+// must not be renamed, templated credentials and Kubernetes secrets share
+// them.
 type ConnectionDetails struct {
 	Username string `json:"username"`
-	Password string `json:"password"`
-	URI      string `json:"uri"`
+
+	// Password is empty for users authenticating with X.509.
+	Password string `json:"password,omitempty"`
+
+	URI string `json:"uri"`
+
+	// Database is set if the binding is scoped to a database, which is also
+	// the default database of the URI.
+	Database string `json:"database,omitempty"`
+
+	// Hosts, Port and ReplicaSet describe the cluster for drivers which are
+	// configured with discrete fields rather than a URI. They're taken from
+	// the standard connection string of the cluster, or of its private
+	// endpoint if URI goes through one, also if URI uses SRV.
+	// Hosts include their port if they don't all share Port. ReplicaSet is
+	// empty for sharded clusters.
+	Hosts      []string `json:"hosts,omitempty"`
+	Port       int      `json:"port,omitempty"`
+	ReplicaSet string   `json:"replica_set,omitempty"`
+
+	// AuthSource is the database the user authenticates against.
+	AuthSource string `json:"auth_source,omitempty"`
+
+	// SRV is set if URI uses the DNS seed list format (mongodb+srv://).
+	SRV bool `json:"srv,omitempty"`
+
+	// BIConnector is only set if the cluster had the BI Connector enabled
+	// when the binding was created.
+	BIConnector *BIConnectorDetails `json:"bi_connector,omitempty"`
+
+	// Certificate is the PEM bundle of the client certificate and its private
+	// key for users authenticating with X.509.
+	Certificate string `json:"certificate,omitempty"`
+
+	// Warning is set if the binding exceeds the connection capacity of the
+	// cluster or the project still had changes pending after waiting for the
+	// user.
+	Warning string `json:"warning,omitempty"`
+}
+
+// BIConnectorDetails is the address of the BI Connector for Atlas, which
+// SQL clients connect to over the MySQL protocol with the same credentials.
+type BIConnectorDetails struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+}
+
+// connectionFields are the keys of the discrete connection fields of
+// ConnectionDetails, which credential templates may replace.
+var connectionFields = []string{"hosts", "port", "replica_set", "auth_source", "srv", "bi_connector"}
+
+// isConnectionField returns whether a key is one of the connectionFields.
+func isConnectionField(key string) bool {
+	for _, field := range connectionFields {
+		if key == field {
+			return true
+		}
+	}
+
+	return false
+}
+
+// fields returns the credentials keyed like their JSON encoding.
+func (d ConnectionDetails) fields() map[string]interface{} {
+	fields := map[string]interface{}{
+		"username": d.Username,
+		"uri":      d.URI,
+	}
+
+	optional := map[string]string{
+		"password":    d.Password,
+		"database":    d.Database,
+		"replica_set": d.ReplicaSet,
+		"auth_source": d.AuthSource,
+		"certificate": d.Certificate,
+		"warning":     d.Warning,
+	}
+	for key, value := range optional {
+		if value != "" {
+			fields[key] = value
+		}
+	}
+
+	if len(d.Hosts) > 0 {
+		fields["hosts"] = d.Hosts
+	}
+
+	if d.Port != 0 {
+		fields["port"] = d.Port
+	}
+
+	if d.SRV {
+		fields["srv"] = d.SRV
+	}
+
+	if d.BIConnector != nil {
+		fields["bi_connector"] = d.BIConnector
+	}
+
+	return fields
 }
 
 // Bind will create a new database user with a username matching the binding ID
 // and a randomly generated password. The user credentials will be returned back.
 func (b Broker) Bind(ctx context.Context, instanceID string, bindingID string, details brokerapi.BindDetails, asyncAllowed bool) (spec brokerapi.Binding, err error) {
-	b.logger.Infow("Creating binding", "instance_id", instanceID, "binding_id", bindingID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID, "binding_id", bindingID)
+	defer b.observeOperation("bind", &err)
+
+	b.logger.Infow("Creating binding", "details", details)
+
+	// Changes are rejected during maintenance.
+	if err = b.checkMaintenance(); err != nil {
+		return
+	}
+
+	details.ServiceID = b.currentID(details.ServiceID)
+	details.PlanID = b.currentID(details.PlanID)
+
+	defer func() {
+		b.notifyHooks(LifecycleEvent{
+			Operation:   OperationBind,
+			GroupID:     groupIDFromContext(ctx),
+			InstanceID:  instanceID,
+			BindingID:   bindingID,
+			ServiceID:   details.ServiceID,
+			PlanID:      details.PlanID,
+			ClusterName: b.namer.ClusterName(instanceID),
+		}, spec.IsAsync, err)
+	}()
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
@@ -31,126 +158,879 @@ func (b Broker) Bind(ctx context.Context, instanceID string, bindingID string, d
 
 	// The service_id and plan_id are required to be valid per the specification, despite
 	// not being used for bindings. We look them up to ensure they can be found in the catalog.
-	provider, err := findProviderByServiceID(client, details.ServiceID)
+	serviceName, planName, err := resolvePlanNames(client, b.idPrefix, details.ServiceID, details.PlanID, nil)
 	if err != nil {
+		b.logger.Errorw("Failed to resolve service and plan", "error", err, "details", details)
 		return
 	}
+	b.logger = b.logger.With("service_name", serviceName, "plan_name", planName)
 
-	_, err = findInstanceSizeByPlanID(provider, details.PlanID)
+	// Fetch the cluster from Atlas to ensure it exists.
+	cluster, err := b.instanceCluster(client, instanceID)
 	if err != nil {
+		b.logger.Errorw("Failed to get existing cluster", "error", err)
+		err = atlasToAPIError(err)
 		return
 	}
 
-	// Fetch the cluster from Atlas to ensure it exists.
-	cluster, err := client.GetCluster(NormalizeClusterName(instanceID))
+	// Make sure the service and plan actually belong to the instance.
+	err = b.verifyBindingPlan(instanceID, cluster, details.ServiceID, details.PlanID)
 	if err != nil {
-		b.logger.Errorw("Failed to get existing cluster", "error", err, "instance_id", instanceID)
-		err = atlasToAPIError(err)
 		return
 	}
 
-	// Generate a cryptographically secure random password.
-	password, err := generatePassword()
+	// Warn about or reject bindings the cluster likely can't serve.
+	warning, err := b.checkBindingCapacity(client, instanceID, bindingID, cluster)
 	if err != nil {
-		b.logger.Errorw("Failed to generate password", "error", err, "instance_id", instanceID, "binding_id", bindingID)
-		err = errors.New("Failed to generate binding password")
 		return
 	}
 
-	// Construct a cluster definition from the instance ID, service, plan, and params.
-	user, err := userFromParams(bindingID, password, details.RawParameters)
+	username, err := b.usernameForBinding(instanceID, bindingID, details.RawContext)
 	if err != nil {
-		b.logger.Errorw("Couldn't create user from the passed parameters", "error", err, "instance_id", instanceID, "binding_id", bindingID, "details", details)
+		b.logger.Errorw("Failed to derive the username", "error", err)
 		return
 	}
 
-	// Create a new Atlas database user from the generated definition.
-	_, err = client.CreateUser(*user)
+	// Validate the connection string params before creating the user.
+	csParams, err := connectionStringParamsFromParams(details.RawParameters)
 	if err != nil {
-		b.logger.Errorw("Failed to create Atlas database user", "error", err, "instance_id", instanceID, "binding_id", bindingID)
-		err = atlasToAPIError(err)
+		b.logger.Errorw("Couldn't parse the connection string parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	credentialStyle, err := b.credentialStyleFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the credential style", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	waitForUser, err := b.waitForUserFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the waitForUser parameter", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	database, err := databaseFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the database parameter", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// Construct a user definition from the binding ID and params.
+	user, err := userFromParams(username, database, cluster.Name, details.RawParameters, b.defaultUserRoles, b.rolePolicyFor(ctx))
+	if err != nil {
+		b.logger.Errorw("Couldn't create user from the passed parameters", "error", err, "details", details)
+		return
+	}
+
+	if user.IsX509() {
+		if err = b.checkFeature(FeatureX509Bindings); err != nil {
+			return
+		}
+	}
+
+	certificateMonths, err := certificateMonthsFromParams(details.RawParameters, user)
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the certificate parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	rotate, rotationID, err := b.rotateFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the rotate parameter", "error", err, "details", details)
+		err = paramsToAPIError(err)
 		return
 	}
 
-	b.logger.Infow("Successfully created Atlas database user", "instance_id", instanceID, "binding_id", bindingID)
+	// Rotating keeps the existing user of the binding, whatever it's named.
+	var existingUser *atlas.User
+	if rotate {
+		existingUser, err = rotationUser(client, instanceID, bindingID, user)
+		if err != nil {
+			return
+		}
+
+		// Retried rotations return the credentials of the first attempt
+		// instead of invalidating them.
+		if existingUser != nil && rotationID != "" {
+			var rotated json.RawMessage
+			rotated, err = b.rotatedCredentials(ctx, instanceID, bindingID, rotationID)
+			if err != nil {
+				b.logger.Errorw("Failed to read the credentials of the rotation", "error", err, "rotation_id", rotationID)
+				return
+			}
+
+			if rotated != nil {
+				b.logger.Infow("Returning the credentials of a retried rotation", "rotation_id", rotationID)
+				spec = brokerapi.Binding{Credentials: rotated}
+				return
+			}
+		}
+
+		if existingUser != nil {
+			username = existingUser.Username
+			user.Username = existingUser.Username
+		}
+	}
+
+	// Callers may pass their own password if the broker allows it.
+	password, err := b.userPasswordFromParams(details.RawParameters, user.IsX509())
+	if err != nil {
+		b.logger.Errorw("Couldn't parse the user password", "error", err)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// Otherwise generate a cryptographically secure random password, users
+	// authenticating with certificates don't have one.
+	if password == "" && !user.IsX509() {
+		password, err = b.passwordPolicy.generate()
+		if err != nil {
+			b.logger.Errorw("Failed to generate password", "error", err)
+			err = errors.New("Failed to generate binding password")
+			return
+		}
+	}
+	user.Password = password
+
+	// Connection strings of X.509 users don't carry a username, it's taken
+	// from the certificate.
+	uriUsername := username
+	if user.IsX509() {
+		uriUsername = ""
+	}
+
+	// Bindings connecting through a private endpoint get its addresses in
+	// all credentials.
+	verr := &ValidationError{}
+	addressed := endpointCluster(cluster, csParams, verr)
+	if err = verr.errorOrNil(); err != nil {
+		b.logger.Errorw("Failed to select the endpoint of the connection string", "error", err)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// Without connection string params the plain SRV address is returned
+	// for backwards compatibility, only the appName is added to it. The
+	// connection string is built before creating the user so invalid
+	// options don't leave a user behind.
+	uri := addressed.SrvAddress
+	if csParams == nil && b.defaultAppName && uri != "" {
+		var appName string
+		appName, err = b.appNameForBinding(instanceID, bindingID, details.RawContext)
+		if err != nil {
+			b.logger.Errorw("Failed to derive the appName of the connection string", "error", err)
+			return
+		}
+
+		uri, err = connectionStringWithAppName(uri, appName)
+		if err != nil {
+			b.logger.Errorw("Failed to add the appName to the connection string", "error", err)
+			return
+		}
+	}
+	if csParams != nil {
+		var defaultOptions map[string]string
+		defaultOptions, err = b.defaultConnectionStringOptions(instanceID, bindingID, details.RawContext, csParams, user)
+		if err != nil {
+			b.logger.Errorw("Failed to derive the default connection string options", "error", err)
+			return
+		}
+
+		uri, err = buildConnectionString(addressed, uriUsername, password, csParams, defaultOptions, b.allowedConnectionStringOptions)
+		if err != nil {
+			b.logger.Errorw("Failed to build connection string", "error", err)
+			err = paramsToAPIError(err)
+			return
+		}
+	}
+
+	// Bindings scoped to a database connect to it by default while the user
+	// still authenticates against the database Atlas keeps it in, unless the
+	// credentials are left out. Clusters without an address yet get no
+	// connection string at all.
+	authUser := user
+	if csParams != nil && csParams.SkipCredentials {
+		authUser = nil
+	}
+	if (database != "" || user.IsX509()) && uri != "" {
+		uri, err = connectionStringWithAuth(uri, database, authUser)
+		if err != nil {
+			b.logger.Errorw("Failed to add the authentication options to the connection string", "error", err)
+			return
+		}
+	}
+
+	// Render the additional credentials of the plan, if any. Templates have
+	// been validated when the broker was created.
+	extraCredentials, err := b.renderCredentials(details.PlanID, addressed, username, password, uri)
+	if err != nil {
+		b.logger.Errorw("Failed to render credential templates", "error", err)
+		return
+	}
+
+	// Record which instance and binding the user belongs to so it can be
+	// traced back by Reconcile.
+	user.Labels = mergeLabels(user.Labels, []atlas.Label{
+		atlas.Label{Key: LabelInstanceID, Value: instanceID},
+		atlas.Label{Key: LabelBindingID, Value: bindingID},
+	})
+
+	// Create a new Atlas database user from the generated definition, or
+	// set the new password of the existing one.
+	if existingUser != nil {
+		if err = b.rotateUser(client, *existingUser, password); err != nil {
+			return
+		}
+	} else {
+		_, err = client.CreateUser(*user)
+
+		// Retries of binds whose response got lost find the user of the
+		// earlier attempt. The platform never received its credentials, so
+		// unless they are stored the user gets the new password.
+		if err == atlas.ErrUserAlreadyExists {
+			var stored json.RawMessage
+			existingUser, stored, err = b.retriedBind(ctx, client, instanceID, bindingID, *user)
+			if err == nil && stored != nil {
+				b.logger.Infow("Returning the stored credentials of a retried bind")
+				spec = brokerapi.Binding{Credentials: stored}
+				return
+			}
+
+			if err == nil {
+				if err = b.rotateUser(client, *existingUser, password); err != nil {
+					return
+				}
+			}
+		}
+
+		if err != nil {
+			b.logger.Errorw("Failed to create Atlas database user", "error", err)
+			err = atlasToAPIError(err)
+			return
+		}
+
+		b.logger.Infow("Successfully created Atlas database user")
+	}
+
+	// The platform retries failed binds with a new binding ID, a user left
+	// behind would keep valid credentials forever. Users whose credentials
+	// were rotated belong to an existing binding and are kept.
+	rotatedPassword := existingUser != nil && !existingUser.IsX509()
+	defer func() {
+		if r := recover(); r != nil {
+			if existingUser == nil {
+				b.removeOrphanedUser(client, *user)
+			}
+			panic(r)
+		}
+
+		// The credentials never reach the platform if the request was
+		// cancelled in the meantime. A rotated password is in effect
+		// already though, so its credentials are still stored.
+		if err == nil && ctx.Err() != nil && !rotatedPassword {
+			err = ctx.Err()
+		}
+
+		// Bindings whose credentials can't be kept for GetBinding fail, so
+		// the platform doesn't rely on fetching them later.
+		if err == nil {
+			err = b.storeCredentials(ctx, instanceID, bindingID, spec.Credentials)
+		}
+
+		if err == nil && rotationID != "" {
+			err = b.storeCredentials(ctx, instanceID, rotationRecordID(bindingID), rotationID)
+		}
+
+		if err != nil && existingUser == nil {
+			b.removeOrphanedUser(client, *user)
+		}
+
+		// Failing a rotation once the password has changed would leave the
+		// platform with credentials which stopped working, so the rotation
+		// is reported as done with the new ones. Stored credentials have
+		// the old password and are removed.
+		if err != nil && rotatedPassword && spec.Credentials != nil {
+			b.logger.Warnw("Returning the rotated credentials despite a failure", "error", err)
+			b.deleteStoredCredentials(ctx, instanceID, bindingID)
+			err = nil
+		}
+	}()
+
+	// New users can't authenticate until Atlas has deployed them, apps
+	// starting right after the bind would fail otherwise. The same goes for
+	// new passwords, so rotations always wait. Atlas only reports pending
+	// changes for the whole project, so this is a best effort.
+	if waitForUser || (existingUser != nil && !existingUser.IsX509()) {
+		if userWarning := b.waitForProjectChanges(ctx, client); userWarning != "" {
+			warning = strings.TrimSpace(warning + " " + userWarning)
+		}
+	}
+
+	// Atlas generates the certificate of X.509 users but doesn't keep its
+	// private key, so it's only returned here.
+	certificate := ""
+	if user.IsX509() {
+		certificate, err = client.CreateUserCertificate(username, certificateMonths)
+		if err != nil {
+			b.logger.Errorw("Failed to create the client certificate", "error", err)
+			err = atlasToAPIError(err)
+			return
+		}
+	}
+
+	connectionDetails := ConnectionDetails{
+		Username:    username,
+		Password:    password,
+		URI:         uri,
+		Database:    database,
+		AuthSource:  user.AuthDatabase(),
+		SRV:         strings.HasPrefix(uri, "mongodb+srv://"),
+		Certificate: certificate,
+		Warning:     warning,
+	}
+
+	// The discrete connection fields are a convenience, bindings work
+	// without them.
+	address, addressErr := parseClusterAddress(addressed)
+	if addressErr != nil {
+		b.logger.Warnw("Failed to parse the connection string of the cluster", "error", addressErr)
+	}
+	connectionDetails.Hosts = address.Hosts
+	connectionDetails.Port = address.Port
+	connectionDetails.ReplicaSet = address.ReplicaSet
+
+	if host := cluster.BIConnectorHost(); host != "" {
+		connectionDetails.BIConnector = &BIConnectorDetails{Host: host, Port: atlas.BIConnectorPort}
+	}
 
 	spec = brokerapi.Binding{
-		Credentials: ConnectionDetails{
-			Username: bindingID,
-			Password: password,
-			URI:      cluster.SrvAddress,
-		},
+		Credentials: connectionDetails,
+	}
+
+	// Templated credentials are returned alongside the standard fields.
+	// Templates which existed before the discrete connection fields keep
+	// their values.
+	if extraCredentials != nil {
+		for key, value := range connectionDetails.fields() {
+			if _, templated := extraCredentials[key]; templated && isConnectionField(key) {
+				continue
+			}
+			extraCredentials[key] = value
+		}
+		spec.Credentials = extraCredentials
+	}
+
+	// Earlier releases always returned a password, even an empty one.
+	if b.legacyCredentialKeys && connectionDetails.Password == "" {
+		if extraCredentials == nil {
+			extraCredentials = connectionDetails.fields()
+		}
+		extraCredentials["password"] = ""
+		spec.Credentials = extraCredentials
+	}
+
+	// Service binding credentials are flat regardless of the platform.
+	if credentialStyle == CredentialStyleServiceBinding {
+		var data CredentialTemplateData
+		data, err = credentialTemplateData(addressed, username, password, uri)
+		if err != nil {
+			b.logger.Errorw("Failed to parse the connection string of the cluster", "error", err)
+			return
+		}
+
+		if database != "" {
+			data.Database = database
+		}
+
+		spec.Credentials, err = serviceBindingCredentials(spec.Credentials, data.Database)
+		if err != nil {
+			b.logger.Errorw("Failed to flatten credentials", "error", err)
+		}
+		return
 	}
+
+	// Kubernetes stores credentials in secrets which only hold strings,
+	// Cloud Foundry keeps them as they are.
+	if b.bindingPlatform(cluster, details.RawContext) == PlatformKubernetes {
+		spec.Credentials, err = flattenCredentials(spec.Credentials)
+		if err != nil {
+			b.logger.Errorw("Failed to flatten credentials", "error", err)
+			return
+		}
+	}
+
 	return
 }
 
-// Unbind will delete the database user for a specific binding. The database
-// user should have the binding ID as its username.
+// removeOrphanedUser deletes the user of a bind which failed after the user
+// was created. Failures are only logged, the bind has failed already.
+func (b Broker) removeOrphanedUser(client atlas.Client, user atlas.User) {
+	if err := client.DeleteUser(user.AuthDatabase(), user.Username); err != nil && err != atlas.ErrUserNotFound {
+		b.logger.Errorw("Failed to remove the user of the failed binding", "error", err)
+		return
+	}
+
+	b.logger.Infow("Removed the user of the failed binding")
+}
+
+// verifyBindingPlan checks the service and plan IDs sent with a bind or unbind
+// request against the plan of the instance. Platforms are required to send the
+// IDs of the instance, a mismatch is rejected unless strict binding plans have
+// been disabled, in which case only a warning is logged.
+func (b Broker) verifyBindingPlan(instanceID string, cluster *atlas.Cluster, serviceID string, planID string) error {
+	actualServiceID, actualPlanID := instancePlanIDs(b.idPrefix, cluster)
+	if actualServiceID == "" {
+		return nil
+	}
+
+	var err error
+	switch {
+	case serviceID != "" && serviceID != actualServiceID:
+		err = fmt.Errorf(`service ID "%s" does not match the service of the instance "%s"`, serviceID, actualServiceID)
+	case planID != "" && planID != actualPlanID:
+		// Unlabeled clusters only tell their current instance size which may
+		// have been changed by auto-scaling.
+		if InstanceMetadata(cluster).PlanName == "" && computeAutoScalingEnabled(cluster) {
+			return nil
+		}
+
+		err = fmt.Errorf(`plan ID "%s" does not match the plan of the instance "%s"`, planID, actualPlanID)
+	default:
+		return nil
+	}
+
+	if !b.strictBindingPlans {
+		b.logger.Warnw("Binding plan does not match the instance", "error", err, "service_id", serviceID, "plan_id", planID)
+		return nil
+	}
+
+	b.logger.Errorw("Binding plan does not match the instance", "error", err, "service_id", serviceID, "plan_id", planID)
+	return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "plan-mismatch")
+}
+
+// OperationUnbind is the prefix of the operation data returned by async
+// unbinds. It's followed by the cleanup steps which were started, for example
+// "unbind:user".
+const OperationUnbind = "unbind"
+
+// bindingCleanupStep describes a resource which has to be removed when a
+// binding is released.
+type bindingCleanupStep struct {
+	Name string
+
+	// Required reports whether a binding with the passed users has the
+	// resource. It's checked before the users are deleted.
+	Required func(bindingID string, users []atlas.User) bool
+
+	// Pending reports whether the resource still exists.
+	Pending func(client atlas.Client, instanceID string, bindingID string) (bool, error)
+
+	// Start begins removing the resource.
+	Start func(client atlas.Client, instanceID string, bindingID string) error
+}
+
+// userCleanupStep removes the database users of a binding. Deleting a user
+// is synchronous in Atlas.
+var userCleanupStep = bindingCleanupStep{
+	Name: "user",
+	Pending: func(client atlas.Client, instanceID string, bindingID string) (bool, error) {
+		users, err := bindingUsers(client, instanceID, bindingID)
+		return len(users) > 0, err
+	},
+	Start: deleteBindingUsers,
+}
+
+// bindingUsername returns the default username of the database user created
+// by a bind, which is all older broker versions used.
+func bindingUsername(bindingID string) string {
+	return bindingID
+}
+
+// bindingUsers resolves the database users belonging to a binding: the user
+// named after it, which is all older broker versions created, and any user
+// labeled with the binding ID. Users labeled for another instance are left
+// alone. Only users which currently exist are returned.
+func bindingUsers(client atlas.Client, instanceID string, bindingID string) ([]atlas.User, error) {
+	users, err := client.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	bindingUsers := []atlas.User{}
+	for _, user := range users {
+		owner := labelValue(user.Labels, LabelInstanceID)
+		labeled := labelValue(user.Labels, LabelBindingID) == bindingID && (owner == "" || owner == instanceID)
+
+		if user.Username == bindingUsername(bindingID) || labeled {
+			bindingUsers = append(bindingUsers, user)
+		}
+	}
+
+	return bindingUsers, nil
+}
+
+// retriedBind recognizes binds the platform retries after the response to an
+// earlier attempt got lost, for example because the platform gave up waiting.
+// The user of the earlier attempt is returned if it was created for the same
+// binding with the same roles, along with its stored credentials if there are
+// any. atlas.ErrUserAlreadyExists is returned if the username is taken by
+// anything else.
+func (b Broker) retriedBind(ctx context.Context, client atlas.Client, instanceID string, bindingID string, requested atlas.User) (*atlas.User, json.RawMessage, error) {
+	users, err := bindingUsers(client, instanceID, bindingID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, existing := range users {
+		if existing.Username != requested.Username {
+			continue
+		}
+
+		if labelValue(existing.Labels, LabelInstanceID) != instanceID ||
+			labelValue(existing.Labels, LabelBindingID) != bindingID ||
+			existing.IsX509() != requested.IsX509() ||
+			!reflect.DeepEqual(existing.Roles, requested.Roles) {
+			return nil, nil, atlas.ErrUserAlreadyExists
+		}
+
+		if b.credentialStore == nil {
+			return &existing, nil, nil
+		}
+
+		stored, err := b.storedCredentials(ctx, instanceID, bindingID)
+		if err == ErrCredentialsNotFound {
+			return &existing, nil, nil
+		}
+
+		return &existing, stored, err
+	}
+
+	return nil, nil, atlas.ErrUserAlreadyExists
+}
+
+// deleteBindingUsers deletes all database users of a binding from their
+// authentication database. Users which are gone already, for example because
+// an earlier unbind was interrupted, are skipped. atlas.ErrUserNotFound is
+// returned if the binding doesn't have any users.
+func deleteBindingUsers(client atlas.Client, instanceID string, bindingID string) error {
+	users, err := bindingUsers(client, instanceID, bindingID)
+	if err != nil {
+		return err
+	}
+
+	if len(users) == 0 {
+		return atlas.ErrUserNotFound
+	}
+
+	for _, user := range users {
+		if err := client.DeleteUser(user.AuthDatabase(), user.Username); err != nil && err != atlas.ErrUserNotFound {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// certificateCleanupStep waits until the removal of a binding's X.509 users
+// has been deployed. Atlas can't revoke the certificates it issued, they
+// authenticate until their user is gone from the clusters. Atlas only
+// reports deployments for the whole project, so unrelated changes prolong
+// the wait.
+var certificateCleanupStep = bindingCleanupStep{
+	Name: "certificate",
+	Required: func(bindingID string, users []atlas.User) bool {
+		for _, user := range users {
+			if user.IsX509() {
+				return true
+			}
+		}
+		return false
+	},
+	Pending: func(client atlas.Client, instanceID string, bindingID string) (bool, error) {
+		status, err := client.GetProjectStatus()
+		if err != nil {
+			return false, err
+		}
+		return status.ChangeStatus == atlas.ChangeStatusPending, nil
+	},
+	// The users are deleted by userCleanupStep.
+	Start: func(client atlas.Client, instanceID string, bindingID string) error {
+		return nil
+	},
+}
+
+// associatedCleanupSteps are the cleanup steps for resources the broker
+// creates alongside the database user of a binding. If any of them are
+// required, unbinding is performed asynchronously.
+var associatedCleanupSteps = []bindingCleanupStep{certificateCleanupStep}
+
+// findCleanupStep looks up a cleanup step by name.
+func findCleanupStep(name string) (bindingCleanupStep, bool) {
+	for _, step := range append([]bindingCleanupStep{userCleanupStep}, associatedCleanupSteps...) {
+		if step.Name == name {
+			return step, true
+		}
+	}
+
+	return bindingCleanupStep{}, false
+}
+
+// Unbind will delete the database users of a specific binding, as resolved by
+// bindingUsers. If the binding has associated resources besides the users
+// they are removed asynchronously and the progress is reported by
+// LastBindingOperation. Platforms which don't allow that get a synchronous
+// unbind, the removal is finished by Atlas without them.
 func (b Broker) Unbind(ctx context.Context, instanceID string, bindingID string, details brokerapi.UnbindDetails, asyncAllowed bool) (spec brokerapi.UnbindSpec, err error) {
-	b.logger.Infow("Releasing binding", "instance_id", instanceID, "binding_id", bindingID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID, "binding_id", bindingID)
+	defer b.observeOperation("unbind", &err)
+
+	b.logger.Infow("Releasing binding", "details", details)
+
+	// Changes are rejected during maintenance.
+	if err = b.checkMaintenance(); err != nil {
+		return
+	}
+
+	details.ServiceID = b.currentID(details.ServiceID)
+	details.PlanID = b.currentID(details.PlanID)
+
+	defer func() {
+		b.notifyHooks(LifecycleEvent{
+			Operation:   OperationUnbind,
+			GroupID:     groupIDFromContext(ctx),
+			InstanceID:  instanceID,
+			BindingID:   bindingID,
+			ServiceID:   details.ServiceID,
+			PlanID:      details.PlanID,
+			ClusterName: b.namer.ClusterName(instanceID),
+		}, spec.IsAsync, err)
+	}()
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
 		return
 	}
 
+	// Stored credentials go along with the users, or once the binding turns
+	// out to be gone. If they can't be removed the unbind fails so the
+	// platform retries it.
+	defer func() {
+		if err == nil || err == apiresponses.ErrBindingDoesNotExist || err == apiresponses.ErrInstanceDoesNotExist {
+			if deleteErr := b.deleteStoredCredentials(ctx, instanceID, bindingID); deleteErr != nil {
+				spec = brokerapi.UnbindSpec{}
+				err = deleteErr
+			}
+		}
+	}()
+
 	// Fetch the cluster from Atlas to ensure it exists.
-	_, err = client.GetCluster(NormalizeClusterName(instanceID))
+	cluster, err := b.instanceCluster(client, instanceID)
+	if err != nil {
+		b.logger.Errorw("Failed to get existing cluster", "error", err)
+		err = atlasToAPIError(err)
+		return
+	}
+
+	// Make sure the service and plan actually belong to the instance.
+	err = b.verifyBindingPlan(instanceID, cluster, details.ServiceID, details.PlanID)
+	if err != nil {
+		return
+	}
+
+	// Find the associated resources which need to be removed.
+	users, err := bindingUsers(client, instanceID, bindingID)
 	if err != nil {
-		b.logger.Errorw("Failed to get existing cluster", "error", err, "instance_id", instanceID)
+		b.logger.Errorw("Failed to list Atlas database users", "error", err)
 		err = atlasToAPIError(err)
 		return
 	}
 
-	// Delete database user which has the binding ID as its username.
-	err = client.DeleteUser(bindingID)
+	steps := []bindingCleanupStep{}
+	for _, step := range associatedCleanupSteps {
+		if step.Required(bindingID, users) {
+			steps = append(steps, step)
+		}
+	}
+
+	if len(steps) > 0 && asyncAllowed {
+		return b.unbindAsync(client, instanceID, bindingID, steps)
+	}
+
+	// Delete all database users of the binding.
+	err = deleteBindingUsers(client, instanceID, bindingID)
 	if err != nil {
-		b.logger.Errorw("Failed to delete Atlas database user", "error", err, "instance_id", instanceID, "binding_id", bindingID)
+		b.logger.Errorw("Failed to delete Atlas database users", "error", err)
 		err = atlasToAPIError(err)
 		return
 	}
 
-	b.logger.Infow("Successfully deleted Atlas database user", "instance_id", instanceID, "binding_id", bindingID)
+	b.logger.Infow("Successfully deleted Atlas database users")
+
+	for _, step := range steps {
+		if err = step.Start(client, instanceID, bindingID); err != nil {
+			b.logger.Errorw("Failed to start removing binding resource", "error", err, "step", step.Name)
+			err = atlasToAPIError(err)
+			return
+		}
+	}
 
 	spec = brokerapi.UnbindSpec{}
 	return
 }
 
-// GetBinding is currently not supported as specified by the
+// unbindAsync starts removing the database user and the passed associated
+// resources of a binding. The started steps are encoded in the operation data.
+func (b Broker) unbindAsync(client atlas.Client, instanceID string, bindingID string, steps []bindingCleanupStep) (spec brokerapi.UnbindSpec, err error) {
+	names := []string{}
+	for _, step := range append([]bindingCleanupStep{userCleanupStep}, steps...) {
+		// The user may already be gone if a previous unbind was interrupted.
+		startErr := step.Start(client, instanceID, bindingID)
+		if startErr != nil && startErr != atlas.ErrUserNotFound {
+			b.logger.Errorw("Failed to start removing binding resource", "error", startErr, "step", step.Name)
+			err = atlasToAPIError(startErr)
+			return
+		}
+
+		names = append(names, step.Name)
+	}
+
+	b.logger.Infow("Successfully started binding cleanup", "steps", names)
+
+	spec = brokerapi.UnbindSpec{
+		IsAsync:       true,
+		OperationData: fmt.Sprintf("%s:%s", OperationUnbind, strings.Join(names, ",")),
+	}
+	return
+}
+
+// GetBinding returns the credentials of a binding kept in the credential
+// store. Without a store it's not supported as specified by the
 // BindingsRetrievable setting in the service catalog.
 func (b Broker) GetBinding(ctx context.Context, instanceID string, bindingID string) (spec brokerapi.GetBindingSpec, err error) {
-	b.logger.Infow("Retrieving binding", "instance_id", instanceID, "binding_id", bindingID)
+	b.logger = b.logger.With("instance_id", instanceID, "binding_id", bindingID)
+
+	b.logger.Infow("Retrieving binding")
+
+	if b.credentialStore == nil {
+		err = brokerapi.NewFailureResponse(fmt.Errorf("Unknown binding ID %s", bindingID), 404, "get-binding")
+		return
+	}
+
+	credentials, err := b.storedCredentials(ctx, instanceID, bindingID)
+	if err == ErrCredentialsNotFound {
+		err = apiresponses.ErrBindingNotFound
+		return
+	}
 
-	err = brokerapi.NewFailureResponse(fmt.Errorf("Unknown binding ID %s", bindingID), 404, "get-binding")
+	if err != nil {
+		b.logger.Errorw("Failed to read stored binding credentials", "error", err)
+		return
+	}
+
+	spec.Credentials = credentials
 	return
 }
 
-// LastBindingOperation should fetch the status of the last creation/deletion
-// of a database user.
-func (b Broker) LastBindingOperation(ctx context.Context, instanceID string, bindingID string, details brokerapi.PollDetails) (brokerapi.LastOperation, error) {
-	panic("not implemented")
-}
+// LastBindingOperation reports the progress of an async unbind. The unbind
+// has succeeded once all the resources listed in the operation data are gone.
+// Bindings are always created synchronously.
+func (b Broker) LastBindingOperation(ctx context.Context, instanceID string, bindingID string, details brokerapi.PollDetails) (resp brokerapi.LastOperation, err error) {
+	b.logger = b.logger.With("instance_id", instanceID, "binding_id", bindingID)
 
-// generatePassword will generate a cryptographically secure password.
-// The password will be base64 encoded for easy usage.
-func generatePassword() (string, error) {
-	const numberOfBytes = 32
-	b := make([]byte, numberOfBytes)
+	b.logger.Infow("Fetching state of last binding operation", "details", details)
 
-	_, err := rand.Read(b)
+	client, err := atlasClientFromContext(ctx)
 	if err != nil {
-		return "", err
+		return
+	}
+
+	parts := strings.SplitN(details.OperationData, ":", 2)
+	if parts[0] != OperationUnbind || len(parts) != 2 {
+		err = apiresponses.NewFailureResponse(fmt.Errorf("Unknown operation %q", details.OperationData), http.StatusBadRequest, "unknown-operation")
+		return
+	}
+
+	for _, name := range strings.Split(parts[1], ",") {
+		step, ok := findCleanupStep(name)
+		if !ok {
+			err = apiresponses.NewFailureResponse(fmt.Errorf("Unknown cleanup step %q", name), http.StatusBadRequest, "unknown-operation")
+			return
+		}
+
+		var pending bool
+		pending, err = step.Pending(client, instanceID, bindingID)
+		if err != nil {
+			b.logger.Errorw("Failed to check binding resource", "error", err, "step", step.Name)
+			err = atlasToAPIError(err)
+			return
+		}
+
+		if pending {
+			return brokerapi.LastOperation{
+				State:       brokerapi.InProgress,
+				Description: fmt.Sprintf("Removing %s", step.Name),
+			}, nil
+		}
+	}
+
+	b.notifyHooks(LifecycleEvent{
+		Operation:   OperationUnbind,
+		GroupID:     groupIDFromContext(ctx),
+		InstanceID:  instanceID,
+		BindingID:   bindingID,
+		ClusterName: b.namer.ClusterName(instanceID),
+		Outcome:     brokerapi.Succeeded,
+	}, false, nil)
+
+	return brokerapi.LastOperation{
+		State: brokerapi.Succeeded,
+	}, nil
+}
+
+// databaseFromParams returns the database a binding is scoped to, empty if
+// it isn't scoped to one.
+func databaseFromParams(rawParams []byte) (string, error) {
+	params := struct {
+		Database *string `json:"database"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return "", validationErrorFromJSON(err)
+		}
+	}
+
+	if params.Database == nil {
+		return "", nil
+	}
+
+	verr := &ValidationError{}
+	if message := validateDatabaseName(*params.Database); message != "" {
+		verr.add("database", "%s", message)
 	}
 
-	return base64.URLEncoding.EncodeToString(b), nil
+	return *params.Database, verr.errorOrNil()
 }
 
-func userFromParams(bindingID string, password string, rawParams []byte) (*atlas.User, error) {
+// userFromParams constructs a user from the bind params. Users of bindings
+// scoped to a database get read/write on it unless roles are passed. Users
+// can only authenticate against the bound cluster unless projectScoped is
+// passed. If a role policy is passed, the resulting roles must satisfy it.
+// The password is set by the caller, users authenticating with X.509 don't
+// have one.
+func userFromParams(username string, database string, clusterName string, rawParams []byte, defaultRoles []atlas.Role, policy *RolePolicy) (*atlas.User, error) {
 	// Set up a params object which will be used for deserialiation.
 	params := struct {
-		User *atlas.User `json:"user"`
+		User          *atlas.User `json:"user"`
+		ProjectScoped bool        `json:"projectScoped"`
 	}{
-		&atlas.User{},
+		User: &atlas.User{},
 	}
 
 	// If params were passed we unmarshal them into the params object.
@@ -161,18 +1041,48 @@ func userFromParams(bindingID string, password string, rawParams []byte) (*atlas
 		}
 	}
 
-	// Set binding ID as username and add password.
-	params.User.Username = bindingID
-	params.User.Password = password
+	// The broker's own labels are added to the user later on.
+	verr := &ValidationError{}
+	validateLabels(verr, "user.labels", params.User.Labels)
+	switch params.User.X509Type {
+	case "", atlas.X509TypeNone, atlas.X509TypeManaged:
+	default:
+		verr.add("user.x509Type", "must be %s or %s", atlas.X509TypeNone, atlas.X509TypeManaged)
+	}
+	if err := verr.errorOrNil(); err != nil {
+		return nil, paramsToAPIError(err)
+	}
+
+	// Set binding ID as username, passwords and scopes passed by the caller
+	// are ignored.
+	params.User.Username = username
+	params.User.Password = ""
+	params.User.Scopes = nil
+
+	// Database users are project-wide in Atlas, without a scope the user
+	// could authenticate against every other cluster of the project.
+	if !params.ProjectScoped {
+		params.User.Scopes = []atlas.Scope{
+			atlas.Scope{Name: clusterName, Type: atlas.ScopeTypeCluster},
+		}
+	}
 
-	// If no role is specified we default to read/write on any database.
-	// This is the default role when creating a user through the Atlas UI.
-	if len(params.User.Roles) == 0 {
+	// If no role is specified we fall back on the database of the binding or
+	// the default roles, which unless configured otherwise is read/write on
+	// any database.
+	if len(params.User.Roles) == 0 && database != "" {
 		params.User.Roles = []atlas.Role{
-			atlas.Role{
-				Name:         "readWriteAnyDatabase",
-				DatabaseName: "admin",
-			},
+			atlas.Role{Name: "readWrite", DatabaseName: database},
+		}
+	} else if len(params.User.Roles) == 0 {
+		params.User.Roles = append([]atlas.Role{}, defaultRoles...)
+	}
+
+	// The policy is checked after the defaults have been applied so they
+	// can't bypass it.
+	if policy != nil {
+		if err := policy.check(params.User.Roles); err != nil {
+			return nil, apiresponses.NewFailureResponse(err, http.StatusForbidden, "role-database-forbidden")
 		}
 	}
 
@@ -0,0 +1,123 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// rotateFromParams returns whether a bind asks to rotate the credentials of
+// an existing binding, and the ID of the rotation if one was passed.
+// brokerapi doesn't support binding updates, so binds with the same binding
+// ID and "rotate": true are used instead. Every rotation sets a new password,
+// so retries of one are only answered with the credentials of the first
+// attempt if they pass the same rotationId and credentials are stored.
+func (b Broker) rotateFromParams(rawParams []byte) (bool, string, error) {
+	params := struct {
+		Rotate     bool   `json:"rotate"`
+		RotationID string `json:"rotationId"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return false, "", validationErrorFromJSON(err)
+		}
+	}
+
+	verr := &ValidationError{}
+	if params.RotationID != "" {
+		if !params.Rotate {
+			verr.add("rotationId", "requires rotate")
+		}
+		if b.credentialStore == nil {
+			verr.add("rotationId", "requires a credential store")
+		}
+	}
+
+	return params.Rotate, params.RotationID, verr.errorOrNil()
+}
+
+// rotationRecordID is the binding ID the ID of the last rotation of a binding
+// is stored under. Binding IDs don't contain NUL characters, so it can't
+// collide with another binding.
+func rotationRecordID(bindingID string) string {
+	return bindingID + "\x00rotation"
+}
+
+// rotatedCredentials returns the stored credentials of a binding if its last
+// rotation had the passed ID, nil otherwise.
+func (b Broker) rotatedCredentials(ctx context.Context, instanceID string, bindingID string, rotationID string) (json.RawMessage, error) {
+	lastID, err := b.storedCredentials(ctx, instanceID, rotationRecordID(bindingID))
+	if err == ErrCredentialsNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var id string
+	if err := json.Unmarshal(lastID, &id); err != nil || id != rotationID {
+		return nil, nil
+	}
+
+	credentials, err := b.storedCredentials(ctx, instanceID, bindingID)
+	if err == ErrCredentialsNotFound {
+		return nil, nil
+	}
+
+	return credentials, err
+}
+
+// rotationUser returns the existing user of a binding whose credentials are
+// rotated, or nil if the binding doesn't have one yet so it's created as
+// usual. That way retried rotations and first binds behave the same. The
+// user has to keep authenticating the same way.
+func rotationUser(client atlas.Client, instanceID string, bindingID string, user *atlas.User) (*atlas.User, error) {
+	users, err := bindingUsers(client, instanceID, bindingID)
+	if err != nil {
+		return nil, atlasToAPIError(err)
+	}
+
+	if len(users) == 0 {
+		return nil, nil
+	}
+
+	// Bindings of older broker versions may have a labeled user and one
+	// named after the binding, it's unclear which one to rotate.
+	if len(users) > 1 {
+		err := fmt.Errorf("Binding %s has %d database users, rotate them by unbinding and binding again", bindingID, len(users))
+		return nil, apiresponses.NewFailureResponse(err, http.StatusConflict, "ambiguous-rotation")
+	}
+
+	existing := users[0]
+	if existing.IsX509() != user.IsX509() {
+		verr := &ValidationError{}
+		verr.add("user.x509Type", "can't be changed when rotating the credentials of a binding")
+		return nil, paramsToAPIError(verr)
+	}
+
+	return &existing, nil
+}
+
+// rotateUser sets the new password of an existing user. Atlas keeps
+// accepting the old password until the change has been deployed. Users
+// authenticating with certificates keep their user, they only get a new
+// certificate.
+func (b Broker) rotateUser(client atlas.Client, existing atlas.User, password string) error {
+	if existing.IsX509() {
+		return nil
+	}
+
+	update := atlas.User{Username: existing.Username, X509Type: existing.X509Type, Password: password}
+	if _, err := client.UpdateUser(update); err != nil {
+		b.logger.Errorw("Failed to update the password of the Atlas database user", "error", err, "username", existing.Username)
+		return atlasToAPIError(err)
+	}
+
+	b.logger.Infow("Rotated the password of the Atlas database user", "username", existing.Username)
+	return nil
+}
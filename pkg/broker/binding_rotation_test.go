@@ -0,0 +1,183 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindRotate(t *testing.T) {
+	broker, client, ctx := setupPasswordTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+
+	original, err := bindWithParams(broker, ctx, "binding", `{"connectionString": {"format": "standard"}}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+	labels := client.Users["binding"].Labels
+
+	// Atlas deploys the new password after a few polls, the old one keeps
+	// working until then.
+	*client.PendingStatusPolls = 2
+
+	rotated, err := bindWithParams(broker, ctx, "binding", `{"rotate": true, "connectionString": {"format": "standard"}}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, original.Username, rotated.Username)
+		assert.NotEqual(t, original.Password, rotated.Password)
+		assert.Equal(t, rotated.Password, client.Users["binding"].Password)
+		assert.Equal(t, labels, client.Users["binding"].Labels)
+		assertURIParses(t, rotated.URI, rotated.Password)
+		assert.Empty(t, rotated.Warning)
+	}
+	assert.Zero(t, *client.PendingStatusPolls)
+	assert.Len(t, client.Users, 1)
+
+	// GetBinding returns the new credentials.
+	stored, err := broker.GetBinding(ctx, "instance", "binding")
+	if assert.NoError(t, err) {
+		expected, _ := json.Marshal(rotated)
+		actual, _ := json.Marshal(stored.Credentials)
+		assert.JSONEq(t, string(expected), string(actual))
+	}
+
+	// Retried rotations rotate again.
+	retried, err := bindWithParams(broker, ctx, "binding", `{"rotate": true}`)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, rotated.Password, retried.Password)
+		assert.Equal(t, retried.Password, client.Users["binding"].Password)
+	}
+	assert.Len(t, client.Users, 1)
+}
+
+func TestBindRotateNewBinding(t *testing.T) {
+	broker, client, ctx := setupPasswordTest()
+
+	// Rotating a binding which doesn't exist yet creates it, so platforms
+	// can retry rotations which failed before the user was created.
+	details, err := bindWithParams(broker, ctx, "binding", `{"rotate": true}`)
+	if assert.NoError(t, err) && assert.NotNil(t, client.Users["binding"]) {
+		assert.Equal(t, details.Password, client.Users["binding"].Password)
+	}
+}
+
+func TestBindRotateX509TypeChange(t *testing.T) {
+	broker, client, ctx := setupPasswordTest()
+
+	_, err := bindWithParams(broker, ctx, "binding", `{}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = bindWithParams(broker, ctx, "binding", `{"rotate": true, "user": {"x509Type": "MANAGED"}}`)
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "user.x509Type")
+	}
+	assert.False(t, client.Users["binding"].IsX509())
+}
+
+func TestBindRotateKeepsUser(t *testing.T) {
+	store := failingCredentialStore{NewMemoryCredentialStore()}
+	broker, client, ctx := setupPasswordTest(WithCredentialStore(store, testCredentialStoreKey))
+
+	client.Users["binding"] = &atlas.User{
+		Username: "binding",
+		Password: "old password",
+		Labels:   []atlas.Label{{Key: LabelBindingID, Value: "binding"}},
+	}
+	store.MemoryCredentialStore.Put(ctx, "instance", "binding", []byte("old credentials"))
+
+	// The password has changed by the time the credentials fail to be
+	// stored, so the rotation still returns the new credentials and keeps
+	// the user of the existing binding.
+	details, err := bindWithParams(broker, ctx, "binding", `{"rotate": true}`)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, "old password", details.Password)
+		assert.Equal(t, details.Password, client.Users["binding"].Password)
+	}
+	assert.NotNil(t, client.Users["binding"])
+
+	// The outdated credentials can't be fetched anymore.
+	_, err = store.Get(ctx, "instance", "binding")
+	assert.Equal(t, ErrCredentialsNotFound, err)
+}
+
+func TestBindRotateRetried(t *testing.T) {
+	broker, client, ctx := setupPasswordTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+
+	_, err := bindWithParams(broker, ctx, "binding", `{}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	rotated, err := bindWithParams(broker, ctx, "binding", `{"rotate": true, "rotationId": "first"}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Retries of the same rotation keep the password of the first attempt.
+	retried, err := bindWithParams(broker, ctx, "binding", `{"rotate": true, "rotationId": "first"}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, rotated.Password, retried.Password)
+		assert.Equal(t, rotated.Password, client.Users["binding"].Password)
+	}
+
+	// Another rotation sets a new password.
+	next, err := bindWithParams(broker, ctx, "binding", `{"rotate": true, "rotationId": "second"}`)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, rotated.Password, next.Password)
+		assert.Equal(t, next.Password, client.Users["binding"].Password)
+	}
+
+	// Unbinding forgets the rotation along with the credentials.
+	_, err = broker.Unbind(ctx, "instance", "binding", brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if assert.NoError(t, err) {
+		_, err = broker.credentialStore.Get(ctx, "instance", rotationRecordID("binding"))
+		assert.Equal(t, ErrCredentialsNotFound, err)
+	}
+}
+
+func TestBindRotationIDValidation(t *testing.T) {
+	broker, _, ctx := setupPasswordTest()
+
+	// Without a credential store there's nothing to return on retries.
+	_, err := bindWithParams(broker, ctx, "binding", `{"rotate": true, "rotationId": "first"}`)
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "rotationId")
+	}
+
+	broker, _, ctx = setupPasswordTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+	_, err = bindWithParams(broker, ctx, "binding", `{"rotationId": "first"}`)
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "rotationId")
+	}
+}
+
+func TestBindRotateAmbiguousUsers(t *testing.T) {
+	broker, client, ctx := setupPasswordTest()
+
+	// Bindings of older broker versions may have a user named after the
+	// binding and a labeled one.
+	client.Users["binding"] = &atlas.User{Username: "binding", Password: "old password"}
+	client.Users["labeled"] = &atlas.User{
+		Username: "labeled",
+		Password: "old password",
+		Labels:   []atlas.Label{{Key: LabelBindingID, Value: "binding"}},
+	}
+
+	_, err := bindWithParams(broker, ctx, "binding", `{"rotate": true}`)
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, http.StatusConflict, failure.ValidatedStatusCode(nil))
+	}
+	assert.Equal(t, "old password", client.Users["binding"].Password)
+	assert.Equal(t, "old password", client.Users["labeled"].Password)
+}
@@ -0,0 +1,331 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// maxBootstrapSize bounds the encoded bootstrap parameter. It's carried in
+// the operation data, which platforms only store up to a limited length.
+const maxBootstrapSize = 4096
+
+// bootstrapTimeout bounds connecting to the cluster and creating the
+// collections and indexes.
+const bootstrapTimeout = 30 * time.Second
+
+// bootstrapUserWaitTimeout bounds how long a poll waits for the temporary
+// user to be deployed. The poll has to answer within the platform's request
+// timeout as well, the next one tries again.
+const bootstrapUserWaitTimeout = 20 * time.Second
+
+// bootstrapUsernamePrefix prefixes the temporary users bootstrapping
+// instances.
+const bootstrapUsernamePrefix = "aosb-bootstrap-"
+
+// codeNamespaceExists is returned by MongoDB when creating a collection which
+// exists already.
+const codeNamespaceExists = 48
+
+// Bootstrap describes the collections and indexes created in a database once
+// a newly provisioned cluster is ready.
+type Bootstrap struct {
+	Database    string                `json:"database" description:"Database the collections are created in."`
+	Collections []BootstrapCollection `json:"collections,omitempty" description:"Collections created in the database."`
+}
+
+// BootstrapCollection is a collection created by a Bootstrap.
+type BootstrapCollection struct {
+	Name      string           `json:"name" description:"Name of the collection."`
+	Validator json.RawMessage  `json:"validator,omitempty" description:"Schema validation document of the collection in MongoDB Extended JSON."`
+	Indexes   []BootstrapIndex `json:"indexes,omitempty" description:"Indexes created on the collection."`
+}
+
+// BootstrapIndex is an index created by a Bootstrap. The keys are an ordered
+// MongoDB Extended JSON document such as {"createdAt": -1}.
+type BootstrapIndex struct {
+	Keys               json.RawMessage `json:"keys" description:"Keys of the index in order, with 1 or -1 for the direction or an index type such as \"text\"."`
+	Name               string          `json:"name,omitempty" description:"Name of the index, derived from the keys if empty."`
+	Unique             bool            `json:"unique,omitempty"`
+	Sparse             bool            `json:"sparse,omitempty"`
+	ExpireAfterSeconds *int32          `json:"expireAfterSeconds,omitempty" description:"Turns the index into a TTL index."`
+}
+
+// bootstrapFromParams reads the bootstrap parameter of a provision. Invalid
+// specs result in a *ValidationError. Nil is returned if there's nothing to
+// bootstrap.
+func bootstrapFromParams(rawParams []byte) (*Bootstrap, error) {
+	params := struct {
+		Bootstrap *Bootstrap `json:"bootstrap"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	bootstrap := params.Bootstrap
+	if bootstrap == nil {
+		return nil, nil
+	}
+
+	verr := &ValidationError{}
+	if err := validateDatabaseName(bootstrap.Database); err != "" {
+		verr.add("bootstrap.database", err)
+	}
+
+	names := map[string]bool{}
+	for i, collection := range bootstrap.Collections {
+		field := fmt.Sprintf("bootstrap.collections[%d]", i)
+
+		switch {
+		case collection.Name == "":
+			verr.add(field+".name", "must not be empty")
+		case strings.Contains(collection.Name, "$") || strings.HasPrefix(collection.Name, "system."):
+			verr.add(field+".name", `must not contain "$" or start with "system."`)
+		case names[collection.Name]:
+			verr.add(field+".name", `"%s" is listed more than once`, collection.Name)
+		}
+		names[collection.Name] = true
+
+		if collection.Validator != nil {
+			if _, err := extJSONDocument(collection.Validator); err != nil {
+				verr.add(field+".validator", "must be a document: %v", err)
+			}
+		}
+
+		for j, index := range collection.Indexes {
+			if err := validateIndexKeys(index.Keys); err != "" {
+				verr.add(fmt.Sprintf("%s.indexes[%d].keys", field, j), err)
+			}
+
+			if index.ExpireAfterSeconds != nil && *index.ExpireAfterSeconds < 0 {
+				verr.add(fmt.Sprintf("%s.indexes[%d].expireAfterSeconds", field, j), "must not be negative")
+			}
+		}
+	}
+
+	if data, _ := json.Marshal(bootstrap); len(data) > maxBootstrapSize {
+		verr.add("bootstrap", "must not be larger than %d bytes", maxBootstrapSize)
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	return bootstrap, nil
+}
+
+// validateDatabaseName returns why a database name can't be used, empty if
+// it can.
+func validateDatabaseName(name string) string {
+	switch {
+	case name == "":
+		return "must not be empty"
+	case len(name) >= 64:
+		return "must be shorter than 64 characters"
+	case strings.ContainsAny(name, `/\. "$`):
+		return `must not contain any of /\. "$`
+	case name == "admin" || name == "local" || name == "config":
+		return fmt.Sprintf(`"%s" is reserved by MongoDB`, name)
+	}
+
+	return ""
+}
+
+// validateIndexKeys returns why index keys can't be used, empty if they can.
+func validateIndexKeys(keys json.RawMessage) string {
+	document, err := extJSONDocument(keys)
+	if err != nil {
+		return fmt.Sprintf("must be a document: %v", err)
+	}
+
+	if len(document) == 0 {
+		return "must not be empty"
+	}
+
+	for _, key := range document {
+		switch value := key.Value.(type) {
+		case string:
+		case int32, int64, float64:
+			if direction := fmt.Sprint(value); direction != "1" && direction != "-1" {
+				return fmt.Sprintf(`direction of "%s" must be 1 or -1`, key.Key)
+			}
+		default:
+			return fmt.Sprintf(`"%s" must be 1, -1 or an index type`, key.Key)
+		}
+	}
+
+	return ""
+}
+
+// extJSONDocument decodes a MongoDB Extended JSON document, keeping the
+// order of its keys.
+func extJSONDocument(data json.RawMessage) (bson.D, error) {
+	var document bson.D
+	if len(data) == 0 {
+		return document, nil
+	}
+
+	err := bson.UnmarshalExtJSON(data, false, &document)
+	return document, err
+}
+
+// bootstrapUsername returns the name of the temporary user bootstrapping an
+// instance. It's stable so users left behind by a crashed broker are
+// replaced by the next attempt.
+func bootstrapUsername(instanceID string) string {
+	return bootstrapUsernamePrefix + instanceID
+}
+
+// runBootstrap creates the collections and indexes requested at provision
+// time, which is done on every poll after the cluster became ready until it
+// succeeds. The broker connects with a temporary user limited to the
+// database, which is deleted again whatever the outcome. Errors of the
+// database fail the provision, errors of Atlas are returned so the platform
+// polls again.
+func (b Broker) runBootstrap(ctx context.Context, client atlas.Client, instanceID string, cluster *atlas.Cluster, bootstrap *Bootstrap) (brokerapi.LastOperationState, string, error) {
+	username := bootstrapUsername(instanceID)
+	if err := client.DeleteUser(atlas.AuthDatabaseAdmin, username); err != nil && err != atlas.ErrUserNotFound {
+		b.logger.Errorw("Failed to delete earlier bootstrap user", "error", err, "username", username)
+		return brokerapi.Failed, "", atlasToAPIError(err)
+	}
+
+	password, err := b.passwordPolicy.generate()
+	if err != nil {
+		b.logger.Errorw("Failed to generate password", "error", err)
+		return brokerapi.Failed, "", err
+	}
+
+	user := atlas.User{
+		Username: username,
+		Password: password,
+		Roles: []atlas.Role{
+			{Name: "dbAdmin", DatabaseName: bootstrap.Database},
+			{Name: "readWrite", DatabaseName: bootstrap.Database},
+		},
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: instanceID}},
+	}
+
+	if _, err := client.CreateUser(user); err != nil {
+		b.logger.Errorw("Failed to create bootstrap user", "error", err, "username", username)
+		return brokerapi.Failed, "", atlasToAPIError(err)
+	}
+
+	defer func() {
+		if err := client.DeleteUser(atlas.AuthDatabaseAdmin, username); err != nil && err != atlas.ErrUserNotFound {
+			b.logger.Errorw("Failed to delete bootstrap user", "error", err, "username", username)
+		}
+	}()
+
+	deployed, err := b.projectChangesDeployed(ctx, client, bootstrapUserWaitTimeout)
+	if err != nil {
+		return brokerapi.InProgress, "", err
+	}
+	if !deployed {
+		return brokerapi.InProgress, "Waiting for Atlas to deploy the user bootstrapping the database", nil
+	}
+
+	if err := b.bootstrapDatabase(ctx, cluster.SrvAddress, username, password, bootstrap); err != nil {
+		b.logger.Errorw("Failed to bootstrap database", "error", err, "database", bootstrap.Database)
+		return brokerapi.Failed, fmt.Sprintf("Bootstrapping database %s failed: %v", bootstrap.Database, err), nil
+	}
+
+	b.logger.Infow("Bootstrapped database", "database", bootstrap.Database, "collections", len(bootstrap.Collections))
+	return brokerapi.Succeeded, "", nil
+}
+
+// bootstrapWithDriver connects to a cluster with the MongoDB driver and
+// creates the collections and indexes of a bootstrap. Everything which
+// exists already is left as it is, apart from collection validators which
+// are updated, so it can be applied repeatedly.
+func bootstrapWithDriver(ctx context.Context, srvAddress string, username string, password string, bootstrap *Bootstrap) error {
+	ctx, cancel := context.WithTimeout(ctx, bootstrapTimeout)
+	defer cancel()
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(srvAddress).SetAuth(options.Credential{
+		AuthSource: "admin",
+		Username:   username,
+		Password:   password,
+	}))
+	if err != nil {
+		return err
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		return err
+	}
+	defer client.Disconnect(ctx)
+
+	database := client.Database(bootstrap.Database)
+	for _, collection := range bootstrap.Collections {
+		if err := createCollection(ctx, database, collection); err != nil {
+			return fmt.Errorf("collection %s: %v", collection.Name, err)
+		}
+
+		if len(collection.Indexes) == 0 {
+			continue
+		}
+
+		models := []mongo.IndexModel{}
+		for _, index := range collection.Indexes {
+			keys, err := extJSONDocument(index.Keys)
+			if err != nil {
+				return fmt.Errorf("collection %s: %v", collection.Name, err)
+			}
+
+			opts := options.Index().SetUnique(index.Unique).SetSparse(index.Sparse)
+			if index.Name != "" {
+				opts.SetName(index.Name)
+			}
+			if index.ExpireAfterSeconds != nil {
+				opts.SetExpireAfterSeconds(*index.ExpireAfterSeconds)
+			}
+
+			models = append(models, mongo.IndexModel{Keys: keys, Options: opts})
+		}
+
+		if _, err := database.Collection(collection.Name).Indexes().CreateMany(ctx, models); err != nil {
+			return fmt.Errorf("indexes of collection %s: %v", collection.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// createCollection creates a collection with its validator. The validator of
+// an existing collection is replaced.
+func createCollection(ctx context.Context, database *mongo.Database, collection BootstrapCollection) error {
+	validator, err := extJSONDocument(collection.Validator)
+	if err != nil {
+		return err
+	}
+
+	command := bson.D{{Key: "create", Value: collection.Name}}
+	if validator != nil {
+		command = append(command, bson.E{Key: "validator", Value: validator})
+	}
+
+	err = database.RunCommand(ctx, command).Err()
+	if commandErr, ok := err.(mongo.CommandError); ok && commandErr.Code == codeNamespaceExists {
+		if validator == nil {
+			return nil
+		}
+
+		return database.RunCommand(ctx, bson.D{
+			{Key: "collMod", Value: collection.Name},
+			{Key: "validator", Value: validator},
+		}).Err()
+	}
+
+	return err
+}
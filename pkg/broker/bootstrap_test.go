@@ -0,0 +1,161 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeBootstrap records the bootstraps of a broker instead of connecting to
+// the cluster.
+type fakeBootstrap struct {
+	client atlas.Client
+	err    error
+
+	calls     int
+	username  string
+	userRoles []atlas.Role
+	bootstrap *Bootstrap
+}
+
+func (f *fakeBootstrap) bootstrapDatabase(ctx context.Context, srvAddress string, username string, password string, bootstrap *Bootstrap) error {
+	f.calls++
+	f.username = username
+	f.bootstrap = bootstrap
+
+	if user, err := f.client.GetUser(username); err == nil && user.Password == password {
+		f.userRoles = user.Roles
+	}
+
+	return f.err
+}
+
+// setupBootstrapTest provisions an instance with a bootstrap parameter and
+// lets the cluster become idle.
+func setupBootstrapTest(t *testing.T, err error) (*Broker, MockAtlasClient, context.Context, *fakeBootstrap, string) {
+	broker, client, ctx := setupTest()
+	fake := &fakeBootstrap{client: client, err: err}
+	broker.bootstrapDatabase = fake.bootstrapDatabase
+
+	spec, provisionErr := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"bootstrap": {"database": "app", "collections": [{"name": "events", "indexes": [{"keys": {"createdAt": -1, "type": 1}, "unique": true}]}]}}`),
+	}, true)
+	if !assert.NoError(t, provisionErr) {
+		t.FailNow()
+	}
+
+	client.SetClusterState("instance", atlas.ClusterStateIdle)
+	return broker, client, ctx, fake, spec.OperationData
+}
+
+func TestProvisionBootstrap(t *testing.T) {
+	broker, client, ctx, fake, operationData := setupBootstrapTest(t, nil)
+
+	bootstrap := operationFromData(operationData, nil).Bootstrap
+	if assert.NotNil(t, bootstrap) {
+		assert.Equal(t, "app", bootstrap.Database)
+		assert.Equal(t, "events", bootstrap.Collections[0].Name)
+	}
+
+	resp, err := broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: operationData})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), resp.State)
+
+	assert.Equal(t, 1, fake.calls)
+	assert.Equal(t, bootstrapUsername("instance"), fake.username)
+	assert.Equal(t, []atlas.Role{{Name: "dbAdmin", DatabaseName: "app"}, {Name: "readWrite", DatabaseName: "app"}}, fake.userRoles)
+	assert.Equal(t, bootstrap, fake.bootstrap)
+	assert.Nil(t, client.Users[bootstrapUsername("instance")])
+}
+
+func TestProvisionBootstrapFails(t *testing.T) {
+	broker, client, ctx, fake, operationData := setupBootstrapTest(t, errors.New("E11000 duplicate key error"))
+
+	resp, err := broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: operationData})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Failed), resp.State)
+	assert.Contains(t, resp.Description, "E11000 duplicate key error")
+
+	assert.Equal(t, 1, fake.calls)
+	assert.Nil(t, client.Users[bootstrapUsername("instance")])
+}
+
+func TestProvisionBootstrapUserPending(t *testing.T) {
+	broker, client, ctx, fake, operationData := setupBootstrapTest(t, nil)
+
+	// The user isn't deployed within a single poll.
+	*client.PendingStatusPolls = 100
+
+	resp, err := broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: operationData})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.InProgress), resp.State)
+	assert.Equal(t, 0, fake.calls)
+	assert.Nil(t, client.Users[bootstrapUsername("instance")])
+
+	*client.PendingStatusPolls = 0
+
+	resp, err = broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: operationData})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), resp.State)
+	assert.Equal(t, 1, fake.calls)
+	assert.Nil(t, client.Users[bootstrapUsername("instance")])
+}
+
+func TestProvisionBootstrapReplacesLeftoverUser(t *testing.T) {
+	broker, client, ctx, fake, operationData := setupBootstrapTest(t, nil)
+
+	// A crashed broker may leave the user of an earlier attempt behind.
+	client.CreateUser(atlas.User{Username: bootstrapUsername("instance"), Password: "old"})
+
+	_, err := broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: operationData})
+	assert.NoError(t, err)
+	assert.NotEmpty(t, fake.userRoles)
+	assert.Nil(t, client.Users[bootstrapUsername("instance")])
+}
+
+func TestBootstrapFromParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		params string
+		errors []string
+	}{
+		{"no bootstrap", `{}`, nil},
+		{"valid", `{"bootstrap": {"database": "app", "collections": [{"name": "events", "validator": {"$jsonSchema": {"required": ["type"]}}, "indexes": [{"keys": {"createdAt": 1}, "expireAfterSeconds": 3600}, {"keys": {"text": "text"}}]}]}}`, nil},
+		{"reserved database", `{"bootstrap": {"database": "admin"}}`, []string{"bootstrap.database"}},
+		{"invalid database", `{"bootstrap": {"database": "my.app"}}`, []string{"bootstrap.database"}},
+		{"system collection", `{"bootstrap": {"database": "app", "collections": [{"name": "system.views"}]}}`, []string{"bootstrap.collections[0].name"}},
+		{"duplicate collection", `{"bootstrap": {"database": "app", "collections": [{"name": "events"}, {"name": "events"}]}}`, []string{"bootstrap.collections[1].name"}},
+		{"empty keys", `{"bootstrap": {"database": "app", "collections": [{"name": "events", "indexes": [{"keys": {}}]}]}}`, []string{"bootstrap.collections[0].indexes[0].keys"}},
+		{"invalid direction", `{"bootstrap": {"database": "app", "collections": [{"name": "events", "indexes": [{"keys": {"a": 2}}]}]}}`, []string{"bootstrap.collections[0].indexes[0].keys"}},
+		{"negative TTL", `{"bootstrap": {"database": "app", "collections": [{"name": "events", "indexes": [{"keys": {"a": 1}, "expireAfterSeconds": -1}]}]}}`, []string{"bootstrap.collections[0].indexes[0].expireAfterSeconds"}},
+		{"too large", `{"bootstrap": {"database": "app", "collections": [{"name": "` + strings.Repeat("a", maxBootstrapSize) + `"}]}}`, []string{"bootstrap"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := bootstrapFromParams([]byte(test.params))
+			if len(test.errors) == 0 {
+				assert.NoError(t, err)
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if !assert.True(t, ok, "expected a validation error, got %v", err) {
+				return
+			}
+
+			fields := []string{}
+			for _, violation := range verr.Violations {
+				fields = append(fields, violation.Field)
+			}
+			assert.Equal(t, test.errors, fields)
+		})
+	}
+}
@@ -3,11 +3,16 @@ package broker
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 	"go.uber.org/zap"
@@ -22,22 +27,173 @@ var _ brokerapi.ServiceBroker = Broker{}
 type Broker struct {
 	logger    *zap.SugaredLogger
 	whitelist Whitelist
+
+	allowedInstanceSizes []string
+
+	defaultUserRoles     []atlas.Role
+	rolePolicy           *RolePolicy
+	namer                Namer
+	usernameTemplate     *template.Template
+	strictPreviousValues bool
+	strictBindingPlans   bool
+
+	clusterDefaults         *atlas.Cluster
+	enforcedClusterSettings map[string]interface{}
+	minimumTLSProtocol      string
+	accessListCleanup       bool
+	mongoDBMajorVersions    []string
+
+	allowedConnectionStringOptions []string
+	defaultAppName                 bool
+	appNameTemplate                *template.Template
+	credentialTemplates            map[string]credentialTemplates
+	credentialAliases              credentialTemplates
+	defaultPlatform                string
+	credentialStyle                string
+	legacyCredentialKeys           bool
+	allowUserPasswords             bool
+	passwordPolicy                 PasswordPolicy
+	disabledFeatures               map[string]bool
+	capacityCheck                  *capacityCheck
+	downgradePolicy                string
+	waitForUser                    bool
+	userWaitTimeout                time.Duration
+	credentialStore                CredentialStore
+	credentialCipher               *credentialCipher
+
+	quotas []QuotaRule
+
+	idPrefix         string
+	acceptDefaultIDs bool
+	planCosts        map[string][]brokerapi.ServicePlanCost
+	maxPlanCost      *MaxPlanCost
+	catalogOverride  *CatalogOverride
+
+	provisionTimeout time.Duration
+	operationTimeout time.Duration
+
+	durationEstimator *durationEstimator
+
+	connectionProbe *connectionProbe
+	pool            *pool
+	hooks           Hooks
+	events          *eventLog
+
+	// bootstrapDatabase creates the collections and indexes requested at
+	// provision time, it's replaced in tests.
+	bootstrapDatabase func(ctx context.Context, srvAddress string, username string, password string, bootstrap *Bootstrap) error
+
+	operations  *metrics.CounterVec
+	rateLimits  *rateLimits
+	maintenance *maintenance
+
+	// clock is used for timeouts, polling and delays so tests don't have to
+	// wait.
+	clock clock.Clock
 }
 
-// NewBroker creates a new Broker with a logger.
-func NewBroker(logger *zap.SugaredLogger) *Broker {
-	return &Broker{
-		logger: logger,
+// New creates a new Broker with a logger and optional configuration. An error
+// is returned if any of the options are invalid.
+func New(logger *zap.SugaredLogger, opts ...Option) (*Broker, error) {
+	b := &Broker{
+		logger:   logger,
+		idPrefix: DefaultIDPrefix,
+
+		// This is the default role when creating a user through the Atlas UI.
+		defaultUserRoles: []atlas.Role{
+			atlas.Role{
+				Name:         "readWriteAnyDatabase",
+				DatabaseName: "admin",
+			},
+		},
+
+		allowedConnectionStringOptions: DefaultAllowedConnectionStringOptions,
+		defaultAppName:                 true,
+		strictBindingPlans:             true,
+		provisionTimeout:               DefaultProvisionTimeout,
+		downgradePolicy:                DowngradePolicyConfirm,
+		userWaitTimeout:                DefaultUserWaitTimeout,
+
+		operations:  newOperationsCounter(),
+		rateLimits:  newRateLimits(),
+		maintenance: newMaintenance(),
+
+		durationEstimator: newDurationEstimator(DefaultDurationEstimates),
+		events:            newEventLog(DefaultEventBufferSize),
+		bootstrapDatabase: bootstrapWithDriver,
+
+		clock: clock.Real,
+	}
+
+	for _, opt := range opts {
+		if err := opt(b); err != nil {
+			return nil, err
+		}
+	}
+
+	// Default roles violating the policy would make every bind without roles
+	// fail, so they have to be replaced or explicitly allowed.
+	if b.rolePolicy != nil {
+		if err := b.rolePolicy.check(b.defaultUserRoles); err != nil {
+			return nil, fmt.Errorf("default user roles: %v", err)
+		}
 	}
+
+	// Pools claim clusters transparently, so they can't be turned off per
+	// request.
+	if b.pool != nil && b.disabledFeatures[FeatureWarmPools] {
+		return nil, fmt.Errorf(`warm pools are configured but the feature "%s" is disabled`, FeatureWarmPools)
+	}
+
+	if err := checkCatalogIDs(staticCatalog(b.idPrefix)); err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+
+	// The overridden services and plans are only known once the ID prefix is.
+	if b.catalogOverride != nil {
+		if err := b.catalogOverride.validate(b.idPrefix); err != nil {
+			return nil, err
+		}
+	}
+
+	// Platforms cache the catalog, so its binding flags have to match what
+	// the binding operations do from the start.
+	catalog := staticCatalog(b.idPrefix)
+	for i := range catalog {
+		catalog[i] = b.applyBindingFlags(catalog[i])
+		if b.catalogOverride != nil {
+			catalog[i] = b.catalogOverride.apply(catalog[i])
+		}
+	}
+	if err := checkCatalogConsistency(catalog, b.bindingCapabilities()); err != nil {
+		return nil, fmt.Errorf("catalog: %v", err)
+	}
+
+	return b, nil
+}
+
+// NewBroker creates a new Broker with a logger and optional configuration.
+// It panics if any of the options are invalid, use New to handle the error.
+func NewBroker(logger *zap.SugaredLogger, opts ...Option) *Broker {
+	b, err := New(logger, opts...)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
 }
 
 // NewBrokerWithWhitelist creates a new Broker with a given logger and a
 // whitelist for allowed providers and their plans.
-func NewBrokerWithWhitelist(logger *zap.SugaredLogger, whitelist Whitelist) *Broker {
-	return &Broker{
-		logger:    logger,
-		whitelist: whitelist,
-	}
+//
+// Deprecated: use NewBroker with the WithWhitelist option instead.
+func NewBrokerWithWhitelist(logger *zap.SugaredLogger, whitelist Whitelist, opts ...Option) *Broker {
+	return NewBroker(logger, append(opts, WithWhitelist(whitelist))...)
+}
+
+// Namer returns the Namer used to map instances onto clusters.
+func (b Broker) Namer() Namer {
+	return b.namer
 }
 
 // ContextKey represents the key for a value saved in a context. Linter
@@ -48,11 +204,19 @@ type ContextKey string
 // request context.
 var ContextKeyAtlasClient = ContextKey("atlas-client")
 
+// ContextKeyAtlasPublicKey is the key used to store the Atlas public key of
+// the caller in the request context.
+var ContextKeyAtlasPublicKey = ContextKey("atlas-public-key")
+
+// ContextKeyAtlasGroupID is the key used to store the Atlas project of the
+// caller in the request context.
+var ContextKeyAtlasGroupID = ContextKey("atlas-group-id")
+
 // AuthMiddleware is used to validate and parse Atlas API credentials passed
 // using basic auth. The credentials parsed into an Atlas client which is
 // attached to the request context. This client can later be retrieved by the
-// broker from the context.
-func AuthMiddleware(baseURL string) mux.MiddlewareFunc {
+// broker from the context. The options are applied to every client.
+func AuthMiddleware(baseURL string, opts ...atlas.ClientOption) mux.MiddlewareFunc {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			username, password, ok := r.BasicAuth()
@@ -73,8 +237,10 @@ func AuthMiddleware(baseURL string) mux.MiddlewareFunc {
 
 			// Create a new client with the extracted API credentials and
 			// attach it to the request context.
-			client := atlas.NewClient(baseURL, splitUsername[1], splitUsername[0], password)
+			client := atlas.NewClient(baseURL, splitUsername[1], splitUsername[0], password, opts...)
 			ctx := context.WithValue(r.Context(), ContextKeyAtlasClient, client)
+			ctx = context.WithValue(ctx, ContextKeyAtlasPublicKey, splitUsername[0])
+			ctx = context.WithValue(ctx, ContextKeyAtlasGroupID, splitUsername[1])
 
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
@@ -105,9 +271,27 @@ func atlasToAPIError(err error) error {
 		return apiresponses.ErrBindingDoesNotExist
 	case atlas.ErrUnauthorized:
 		return apiresponses.NewFailureResponse(err, http.StatusUnauthorized, "")
+	case atlas.ErrAPIVersionUnsupported:
+		return apiresponses.NewFailureResponse(err, http.StatusUnprocessableEntity, "api-version-unsupported")
 	}
 
 	// Fall back on returning the error again if no others match.
 	// Will result in a 500 Internal Server Error.
 	return err
 }
+
+// paramsToAPIError converts invalid parameters to a 400 Bad Request response.
+func paramsToAPIError(err error) error {
+	if _, ok := err.(*ValidationError); ok {
+		return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "invalid-parameters")
+	}
+
+	return err
+}
+
+// publicKeyFromContext returns the Atlas public key of the caller, or an
+// empty string if there is none.
+func publicKeyFromContext(ctx context.Context) string {
+	publicKey, _ := ctx.Value(ContextKeyAtlasPublicKey).(string)
+	return publicKey
+}
@@ -2,23 +2,46 @@ package broker
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/pivotal-cf/brokerapi"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 var (
 	testServiceID = "aosb-cluster-service-aws"
 	testPlanID    = "aosb-cluster-plan-aws-m10"
+
+	// testTime is the current time of brokers created by setupTest.
+	testTime      = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	testCreatedAt = "2020-01-02T03:04:05Z"
 )
 
 type MockAtlasClient struct {
-	Clusters map[string]*atlas.Cluster
-	Users    map[string]*atlas.User
+	Clusters    map[string]*atlas.Cluster
+	ProcessArgs map[string]*atlas.ProcessArgs
+	Users       map[string]*atlas.User
+	AccessList  map[string]*atlas.AccessListEntry
+	Events      map[string][]atlas.Event
+
+	// EncryptionAtRest is the configuration of the project, it's shared by
+	// all copies of the mock.
+	EncryptionAtRest *atlas.EncryptionAtRest
+
+	// PendingStatusPolls is the number of project status polls which report
+	// pending changes before they are applied.
+	PendingStatusPolls *int
 }
 
 func (m MockAtlasClient) CreateCluster(cluster atlas.Cluster) (*atlas.Cluster, error) {
@@ -62,6 +85,48 @@ func (m MockAtlasClient) GetCluster(name string) (*atlas.Cluster, error) {
 	return cluster, nil
 }
 
+func (m MockAtlasClient) ListClusters() ([]atlas.Cluster, error) {
+	clusters := []atlas.Cluster{}
+	for _, name := range sortedMapKeys(m.Clusters) {
+		if cluster := m.Clusters[name]; cluster != nil {
+			clusters = append(clusters, *cluster)
+		}
+	}
+
+	return clusters, nil
+}
+
+func (m MockAtlasClient) GetProcessArgs(clusterName string) (*atlas.ProcessArgs, error) {
+	if m.Clusters[clusterName] == nil {
+		return nil, atlas.ErrClusterNotFound
+	}
+
+	args := m.ProcessArgs[clusterName]
+	if args == nil {
+		return &atlas.ProcessArgs{}, nil
+	}
+
+	return args, nil
+}
+
+func (m MockAtlasClient) UpdateProcessArgs(clusterName string, args atlas.ProcessArgs) (*atlas.ProcessArgs, error) {
+	if m.Clusters[clusterName] == nil {
+		return nil, atlas.ErrClusterNotFound
+	}
+
+	// Only the fields which are set are changed, like a PATCH in Atlas.
+	merged := atlas.ProcessArgs{}
+	if existing := m.ProcessArgs[clusterName]; existing != nil {
+		merged = *existing
+	}
+
+	data, _ := json.Marshal(args)
+	json.Unmarshal(data, &merged)
+
+	m.ProcessArgs[clusterName] = &merged
+	return &merged, nil
+}
+
 func (m MockAtlasClient) SetClusterState(name string, state string) {
 	cluster := m.Clusters[name]
 	if cluster == nil {
@@ -76,6 +141,7 @@ func (m MockAtlasClient) CreateUser(user atlas.User) (*atlas.User, error) {
 		return nil, atlas.ErrUserAlreadyExists
 	}
 
+	user.DatabaseName = user.AuthDatabase()
 	m.Users[user.Username] = &user
 	return &user, nil
 }
@@ -89,8 +155,19 @@ func (m MockAtlasClient) GetUser(name string) (*atlas.User, error) {
 	return user, nil
 }
 
-func (m MockAtlasClient) DeleteUser(name string) error {
-	if m.Users[name] == nil {
+func (m MockAtlasClient) ListUsers() ([]atlas.User, error) {
+	users := []atlas.User{}
+	for _, name := range sortedMapKeys(m.Users) {
+		if user := m.Users[name]; user != nil {
+			users = append(users, *user)
+		}
+	}
+
+	return users, nil
+}
+
+func (m MockAtlasClient) DeleteUser(databaseName string, name string) error {
+	if m.Users[name] == nil || m.Users[name].AuthDatabase() != databaseName {
 		return atlas.ErrUserNotFound
 	}
 
@@ -99,9 +176,88 @@ func (m MockAtlasClient) DeleteUser(name string) error {
 	return nil
 }
 
+func (m MockAtlasClient) UpdateUser(user atlas.User) (*atlas.User, error) {
+	existing := m.Users[user.Username]
+	if existing == nil || existing.AuthDatabase() != user.AuthDatabase() {
+		return nil, atlas.ErrUserNotFound
+	}
+
+	if user.Labels != nil {
+		existing.Labels = user.Labels
+	}
+
+	if user.Password != "" {
+		existing.Password = user.Password
+	}
+
+	return existing, nil
+}
+
+func (m MockAtlasClient) CreateUserCertificate(name string, monthsUntilExpiration int) (string, error) {
+	user := m.Users[name]
+	if user == nil {
+		return "", atlas.ErrUserNotFound
+	}
+
+	if !user.IsX509() {
+		return "", errors.New("user doesn't authenticate with X.509 certificates")
+	}
+
+	return fmt.Sprintf("-----BEGIN CERTIFICATE-----\n%s %d\n-----END CERTIFICATE-----\n", name, monthsUntilExpiration), nil
+}
+
+func (m MockAtlasClient) CreateAccessListEntries(entries []atlas.AccessListEntry) ([]atlas.AccessListEntry, error) {
+	for i := range entries {
+		m.AccessList[entries[i].Entry()] = &entries[i]
+	}
+
+	return m.ListAccessListEntries()
+}
+
+func (m MockAtlasClient) ListAccessListEntries() ([]atlas.AccessListEntry, error) {
+	entries := []atlas.AccessListEntry{}
+	for _, key := range sortedMapKeys(m.AccessList) {
+		if entry := m.AccessList[key]; entry != nil {
+			entries = append(entries, *entry)
+		}
+	}
+
+	return entries, nil
+}
+
+func (m MockAtlasClient) DeleteAccessListEntry(entry string) error {
+	if m.AccessList[entry] == nil {
+		return &atlas.APIError{StatusCode: http.StatusNotFound, Code: "ATLAS_WHITELIST_NOT_FOUND"}
+	}
+
+	m.AccessList[entry] = nil
+
+	return nil
+}
+
+func (m MockAtlasClient) GetEncryptionAtRest() (*atlas.EncryptionAtRest, error) {
+	encryption := *m.EncryptionAtRest
+	return &encryption, nil
+}
+
+func (m MockAtlasClient) UpdateEncryptionAtRest(encryption atlas.EncryptionAtRest) (*atlas.EncryptionAtRest, error) {
+	// Only the providers which are set are changed, like a PATCH in Atlas.
+	if encryption.AWSKMS != nil {
+		m.EncryptionAtRest.AWSKMS = encryption.AWSKMS
+	}
+	if encryption.AzureKeyVault != nil {
+		m.EncryptionAtRest.AzureKeyVault = encryption.AzureKeyVault
+	}
+	if encryption.GoogleCloudKMS != nil {
+		m.EncryptionAtRest.GoogleCloudKMS = encryption.GoogleCloudKMS
+	}
+
+	return m.GetEncryptionAtRest()
+}
+
 func (m MockAtlasClient) GetProvider(name string) (*atlas.Provider, error) {
 	return &atlas.Provider{
-		Name: "AWS",
+		Name: name,
 		InstanceSizes: map[string]atlas.InstanceSize{
 			"M10": atlas.InstanceSize{
 				Name: "M10",
@@ -109,25 +265,80 @@ func (m MockAtlasClient) GetProvider(name string) (*atlas.Provider, error) {
 			"M20": atlas.InstanceSize{
 				Name: "M20",
 			},
+			"M30": atlas.InstanceSize{
+				Name: "M30",
+			},
 		},
 	}, nil
 }
 
+func (m MockAtlasClient) ListClusterEvents(clusterName string, limit int) ([]atlas.Event, error) {
+	events := m.Events[clusterName]
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+func (m MockAtlasClient) GetProjectStatus() (*atlas.ProjectStatus, error) {
+	if *m.PendingStatusPolls > 0 {
+		*m.PendingStatusPolls--
+		return &atlas.ProjectStatus{ChangeStatus: atlas.ChangeStatusPending}, nil
+	}
+
+	return &atlas.ProjectStatus{ChangeStatus: atlas.ChangeStatusApplied}, nil
+}
+
 func (m MockAtlasClient) GetDashboardURL(clusterName string) string {
 	return "http://dashboard"
 }
 
-func setupTest() (*Broker, MockAtlasClient, context.Context) {
+// sortedMapKeys returns the keys of a map of clusters, users or access list
+// entries in order.
+func sortedMapKeys(m interface{}) []string {
+	keys := []string{}
+	switch m := m.(type) {
+	case map[string]*atlas.Cluster:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*atlas.User:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	case map[string]*atlas.AccessListEntry:
+		for key := range m {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+func setupTest(opts ...Option) (*Broker, MockAtlasClient, context.Context) {
 	client := MockAtlasClient{
-		Clusters: make(map[string]*atlas.Cluster),
-		Users:    make(map[string]*atlas.User),
+		Clusters:    make(map[string]*atlas.Cluster),
+		ProcessArgs: make(map[string]*atlas.ProcessArgs),
+		Users:       make(map[string]*atlas.User),
+		AccessList:  make(map[string]*atlas.AccessListEntry),
+		Events:      make(map[string][]atlas.Event),
+
+		EncryptionAtRest:   &atlas.EncryptionAtRest{},
+		PendingStatusPolls: new(int),
 	}
 	ctx := context.WithValue(context.Background(), ContextKeyAtlasClient, client)
 
-	broker := NewBroker(zap.NewNop().Sugar())
+	broker := NewBroker(zap.NewNop().Sugar(), append([]Option{WithClock(clock.NewFake(testTime))}, opts...)...)
 	return broker, client, ctx
 }
 
+// testClock returns the fake clock of a broker created by setupTest.
+func testClock(broker *Broker) *clock.Fake {
+	return broker.clock.(*clock.Fake)
+}
+
 func TestAuthMiddleware(t *testing.T) {
 	baseURL := "http://baseURL"
 	groupID := "group-id"
@@ -148,6 +359,7 @@ func TestAuthMiddleware(t *testing.T) {
 		assert.Equal(t, groupID, client.GroupID)
 		assert.Equal(t, publicKey, client.PublicKey)
 		assert.Equal(t, privateKey, client.PrivateKey)
+		assert.Equal(t, publicKey, publicKeyFromContext(r.Context()))
 	})
 
 	// Fake HTTP request which will be sent to middleware. Response is captured
@@ -179,3 +391,63 @@ func TestAuthMiddleware(t *testing.T) {
 	req.SetBasicAuth(publicKey+"@"+groupID, privateKey)
 	middleware(testHandler).ServeHTTP(w, req)
 }
+
+func TestWithLogger(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	broker, client, ctx := setupTest(WithLogger(zap.New(core).Sugar().With("component", "broker")))
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	// A failing operation logs its error with the same fields.
+	_, err = broker.Bind(ctx, "missing", "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.Error(t, err)
+
+	for _, message := range []string{"Provisioning instance", "Creating binding", "Successfully created Atlas database user", "Failed to get existing cluster"} {
+		entries := logs.FilterMessage(message).AllUntimed()
+		if !assert.NotEmpty(t, entries, "Expected log line %q", message) {
+			continue
+		}
+
+		fields := entries[0].ContextMap()
+		assert.Equal(t, "broker", fields["component"], message)
+		assert.Contains(t, fields, "instance_id", message)
+	}
+
+	for _, entry := range logs.FilterField(zap.String("binding_id", "binding")).AllUntimed() {
+		assert.Contains(t, []string{instanceID, "missing"}, entry.ContextMap()["instance_id"])
+	}
+	assert.NotEmpty(t, logs.FilterField(zap.String("instance_id", "missing")).FilterField(zap.String("binding_id", "binding")).AllUntimed())
+
+	// The instance ID is only added once.
+	for _, entry := range logs.AllUntimed() {
+		count := 0
+		for _, field := range entry.Context {
+			if field.Key == "instance_id" {
+				count++
+			}
+		}
+		assert.True(t, count <= 1, "Duplicate instance_id in %q", entry.Message)
+	}
+}
+
+func TestWithLoggerNil(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithLogger(nil))
+	assert.EqualError(t, err, "logger must not be nil")
+}
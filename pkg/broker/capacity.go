@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// Policies for binds exceeding the connection capacity of their cluster.
+const (
+	// CapacityPolicyWarn creates the binding anyway and returns a warning
+	// in its credentials.
+	CapacityPolicyWarn = "warn"
+
+	// CapacityPolicyReject rejects the bind with 422 Unprocessable Entity.
+	CapacityPolicyReject = "reject"
+)
+
+// capacityCheck estimates whether a cluster has connections left for another
+// binding, assuming every binding uses the same number of connections.
+type capacityCheck struct {
+	connectionsPerBinding int
+	policy                string
+}
+
+// connectionLimit returns the connection limit of an instance size, and false
+// if it's unknown.
+func connectionLimit(instanceSizeName string) (int, bool) {
+	spec, ok := instanceSizeSpecFor(instanceSizeName)
+	if !ok || spec.Connections == 0 {
+		return 0, false
+	}
+
+	return spec.Connections, true
+}
+
+// checkBindingCapacity counts the bindings of an instance, which are the
+// database users labeled with its ID and a binding ID, and compares them with
+// the number of bindings the cluster has connections for. Users of other
+// purposes such as monitoring don't take up a binding. The user of the bind
+// itself isn't counted either, so rotating the credentials of an existing
+// binding is never rejected. A warning is returned if the new binding exceeds
+// it, or an error if the policy rejects such binds. Clusters of unknown
+// instance sizes aren't checked.
+func (b Broker) checkBindingCapacity(client atlas.Client, instanceID string, bindingID string, cluster *atlas.Cluster) (string, error) {
+	if b.capacityCheck == nil || cluster.ProviderSettings == nil {
+		return "", nil
+	}
+
+	instanceSizeName := cluster.ProviderSettings.InstanceSizeName
+	limit, ok := connectionLimit(instanceSizeName)
+	if !ok {
+		return "", nil
+	}
+
+	users, err := client.ListUsers()
+	if err != nil {
+		b.logger.Errorw("Failed to list database users", "error", err)
+		return "", atlasToAPIError(err)
+	}
+
+	bindings := 0
+	for _, user := range users {
+		userBindingID := labelValue(user.Labels, LabelBindingID)
+		if labelValue(user.Labels, LabelInstanceID) == instanceID && userBindingID != "" && userBindingID != bindingID {
+			bindings++
+		}
+	}
+
+	connections := (bindings + 1) * b.capacityCheck.connectionsPerBinding
+	if connections <= limit {
+		return "", nil
+	}
+
+	message := fmt.Sprintf("%d bindings of %d connections each exceed the limit of %d connections of the %s cluster", bindings+1, b.capacityCheck.connectionsPerBinding, limit, instanceSizeName)
+	b.logger.Warnw("Binding exceeds the connection capacity of the cluster", "bindings", bindings, "connection_limit", limit, "instance_size", instanceSizeName, "policy", b.capacityCheck.policy)
+
+	if b.capacityCheck.policy == CapacityPolicyReject {
+		return "", apiresponses.NewFailureResponse(fmt.Errorf("%s, upgrade the plan of the instance", message), http.StatusUnprocessableEntity, "binding-capacity-exceeded")
+	}
+
+	return message, nil
+}
@@ -0,0 +1,175 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestConnectionLimit(t *testing.T) {
+	tests := []struct {
+		instanceSizeName string
+		limit            int
+		ok               bool
+	}{
+		{"M10", 1500, true},
+		{"M2", 500, true},
+		{"R40", 6000, true},
+		{"M40_NVME", 6000, true},
+		{"M1000", 0, false},
+	}
+
+	for _, test := range tests {
+		limit, ok := connectionLimit(test.instanceSizeName)
+		assert.Equal(t, test.ok, ok, test.instanceSizeName)
+		assert.Equal(t, test.limit, limit, test.instanceSizeName)
+	}
+
+	// Every size with a known limit keeps it.
+	limits := map[string]int{
+		"M0":   500,
+		"M2":   500,
+		"M5":   500,
+		"M10":  1500,
+		"M20":  3000,
+		"M30":  3000,
+		"M40":  6000,
+		"M50":  16000,
+		"M60":  32000,
+		"M80":  96000,
+		"M140": 96000,
+		"M200": 128000,
+		"M300": 128000,
+		"M400": 128000,
+		"M700": 128000,
+	}
+	for instanceSizeName, expected := range limits {
+		limit, ok := connectionLimit(instanceSizeName)
+		assert.True(t, ok, instanceSizeName)
+		assert.Equal(t, expected, limit, instanceSizeName)
+	}
+}
+
+func TestWithBindingCapacityCheck(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithBindingCapacityCheck(0, CapacityPolicyWarn))
+	assert.Error(t, err)
+
+	_, err = New(zap.NewNop().Sugar(), WithBindingCapacityCheck(100, "ignore"))
+	assert.Error(t, err)
+}
+
+func TestBindCapacityWarning(t *testing.T) {
+	// M10 clusters allow 1500 connections, enough for a single binding.
+	broker, client, ctx := setupTest(WithBindingCapacityCheck(1000, CapacityPolicyWarn))
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	spec, err := broker.Bind(ctx, "instance", "first", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if assert.NoError(t, err) {
+		assert.Empty(t, spec.Credentials.(ConnectionDetails).Warning)
+	}
+
+	spec, err = broker.Bind(ctx, "instance", "second", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if assert.NoError(t, err) {
+		assert.Contains(t, spec.Credentials.(ConnectionDetails).Warning, "1500 connections")
+	}
+
+	assert.NotNil(t, client.Users["second"], "Expected the user to be created")
+}
+
+func TestBindCapacityReject(t *testing.T) {
+	broker, client, ctx := setupTest(WithBindingCapacityCheck(1000, CapacityPolicyReject))
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	_, err := broker.Bind(ctx, "instance", "first", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Bind(ctx, "instance", "second", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusUnprocessableEntity, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "binding-capacity-exceeded", failure.LoggerAction())
+	}
+
+	assert.Nil(t, client.Users["second"], "Expected no user to be created")
+}
+
+func TestBindCapacityOtherInstances(t *testing.T) {
+	broker, _, ctx := setupTest(WithBindingCapacityCheck(1000, CapacityPolicyReject))
+
+	for _, instanceID := range []string{"instance", "other"} {
+		broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			PlanID:    testPlanID,
+			ServiceID: testServiceID,
+		}, true)
+
+		// Bindings of other instances use other clusters.
+		_, err := broker.Bind(ctx, instanceID, instanceID+"-binding", brokerapi.BindDetails{
+			PlanID:    testPlanID,
+			ServiceID: testServiceID,
+		}, true)
+		assert.NoError(t, err)
+	}
+}
+
+func TestBindCapacityIgnoresOtherUsers(t *testing.T) {
+	broker, client, ctx := setupTest(WithBindingCapacityCheck(1000, CapacityPolicyReject))
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	// Users without a binding ID, such as the monitoring user, don't take up
+	// a binding.
+	client.Users["monitoring"] = &atlas.User{
+		Username: "monitoring",
+		Labels:   []atlas.Label{{Key: LabelInstanceID, Value: "instance"}},
+	}
+
+	_, err := broker.Bind(ctx, "instance", "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	// The instance is at capacity, but rotating replaces the user of the
+	// binding rather than adding one.
+	_, err = broker.Bind(ctx, "instance", "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"rotate": true}`),
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Bind(ctx, "instance", "other", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.Error(t, err)
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
@@ -12,39 +14,67 @@ import (
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 )
 
-// idPrefix will be prepended to service and plan IDs to ensure their uniqueness.
-const idPrefix = "aosb-cluster"
+// DefaultIDPrefix is prepended to service and plan IDs to ensure their
+// uniqueness unless WithIDPrefix is passed.
+const DefaultIDPrefix = "aosb-cluster"
+
+// idPrefixPattern restricts ID prefixes to characters platforms accept in IDs.
+var idPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// The names of the cloud providers as used by Atlas.
+const (
+	providerNameAWS   = "AWS"
+	providerNameGCP   = "GCP"
+	providerNameAzure = "AZURE"
+
+	// providerNameTenant is the provider of shared clusters, which run on
+	// one of the other providers given as their backing provider.
+	providerNameTenant = "TENANT"
+)
+
+// sharedInstanceSizes are the instance sizes of shared clusters. They're
+// offered as plans of every provider which can back them.
+var sharedInstanceSizes = []string{InstanceSizeNameM0, InstanceSizeNameM2, InstanceSizeNameM5}
 
 // providerNames contains all the available cloud providers on which clusters
 // may be provisioned. The available instance sizes for each provider are
 // fetched dynamically from the Atlas API.
 var (
-	providerNames = []string{"AWS", "GCP", "AZURE", "TENANT"}
+	providerNames = []string{providerNameAWS, providerNameGCP, providerNameAzure, providerNameTenant}
+
+	// Shared clusters are configured like any other cluster apart from the
+	// AWS-only settings.
+	sharedSchemas = planSchemas(&atlas.Provider{Name: providerNameTenant})
+)
+
+// sharedService returns the service of the tenant provider. Its instance
+// sizes are hardcoded.
+func sharedService(idPrefix string) brokerapi.Service {
+	provider := &atlas.Provider{Name: providerNameTenant}
+
+	plans := []brokerapi.ServicePlan{}
+	for _, name := range []string{InstanceSizeNameM2, InstanceSizeNameM5} {
+		plans = append(plans, brokerapi.ServicePlan{
+			ID:          planIDForInstanceSize(idPrefix, provider, atlas.InstanceSize{Name: name}),
+			Name:        name,
+			Description: fmt.Sprintf("Instance size \"%s\"", name),
+			Metadata:    planMetadata(name),
+			Schemas:     sharedSchemas,
+		})
+	}
 
-	// Hardcode the instance sizes for shared instances
-	sharedService = brokerapi.Service{
-		ID:                   "aosb-cluster-service-tenant",
-		Name:                 "mongodb-atlas-tenant",
+	return brokerapi.Service{
+		ID:                   serviceIDForProvider(idPrefix, provider),
+		Name:                 serviceNameForProvider(provider),
 		Description:          "Atlas cluster hosted on \"TENANT\"",
 		Bindable:             true,
-		InstancesRetrievable: false,
+		InstancesRetrievable: true,
 		BindingsRetrievable:  false,
 		Metadata:             nil,
 		PlanUpdatable:        true,
-		Plans: []brokerapi.ServicePlan{
-			brokerapi.ServicePlan{
-				ID:          "aosb-cluster-plan-tenant-m2",
-				Name:        "M2",
-				Description: "Instance size \"M2\"",
-			},
-			brokerapi.ServicePlan{
-				ID:          "aosb-cluster-plan-tenant-m5",
-				Name:        "M5",
-				Description: "Instance size \"M5\"",
-			},
-		},
+		Plans:                plans,
 	}
-)
+}
 
 // applyWhitelist filters a given service, returning the service with only the
 // whitelisted plans.
@@ -64,6 +94,36 @@ func applyWhitelist(svc brokerapi.Service, whitelistedPlans []string) brokerapi.
 	return whitelistedSvc
 }
 
+// instanceSizeAllowed returns whether an instance size is in the allowed
+// list. An empty list allows all instance sizes.
+func instanceSizeAllowed(name string, allowedSizes []string) bool {
+	if len(allowedSizes) == 0 {
+		return true
+	}
+
+	for _, allowed := range allowedSizes {
+		if strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// applyAllowedInstanceSizes removes the plans of a service whose instance
+// size isn't allowed.
+func applyAllowedInstanceSizes(svc brokerapi.Service, allowedSizes []string) brokerapi.Service {
+	plans := []brokerapi.ServicePlan{}
+	for _, plan := range svc.Plans {
+		if instanceSizeAllowed(plan.Name, allowedSizes) {
+			plans = append(plans, plan)
+		}
+	}
+
+	svc.Plans = plans
+	return svc
+}
+
 // Services generates the service catalog which will be presented to consumers of the API.
 func (b Broker) Services(ctx context.Context) ([]brokerapi.Service, error) {
 	b.logger.Info("Retrieving service catalog")
@@ -76,8 +136,8 @@ func (b Broker) Services(ctx context.Context) ([]brokerapi.Service, error) {
 
 	for _, providerName := range providerNames {
 		var svc brokerapi.Service
-		if providerName == "TENANT" {
-			svc = sharedService
+		if providerName == providerNameTenant {
+			svc = sharedService(b.idPrefix)
 		} else {
 
 			provider, err := client.GetProvider(providerName)
@@ -85,7 +145,18 @@ func (b Broker) Services(ctx context.Context) ([]brokerapi.Service, error) {
 				return services, err
 			}
 
-			svc = service(provider)
+			svc = service(b.idPrefix, provider)
+		}
+
+		svc = applyAllowedInstanceSizes(svc, b.allowedInstanceSizes)
+		svc = b.applyPlanCosts(svc)
+
+		// Bindings can only be fetched if their credentials are kept.
+		svc = b.applyBindingFlags(svc)
+
+		// Services need at least one plan.
+		if len(svc.Plans) == 0 {
+			continue
 		}
 
 		whitelistedPlans, isWhitelisted := b.whitelist[providerName]
@@ -93,41 +164,266 @@ func (b Broker) Services(ctx context.Context) ([]brokerapi.Service, error) {
 			if isWhitelisted {
 				svc = applyWhitelist(svc, whitelistedPlans)
 			}
+
+			// The override is applied last as the filters above match
+			// the generated plan names. Services it hides all plans of
+			// are left out.
+			if b.catalogOverride != nil {
+				svc = b.catalogOverride.apply(svc)
+				if len(svc.Plans) == 0 {
+					continue
+				}
+			}
+
+			// Plans are compared with the ceiling using the costs
+			// shown in the catalog.
+			svc = b.applyMaxPlanCost(svc)
+			if len(svc.Plans) == 0 {
+				continue
+			}
+
 			services = append(services, svc)
 		}
 	}
 
+	// Atlas could list instance sizes which only differ in case and end up
+	// with the same plan ID.
+	if err := checkCatalogIDs(services); err != nil {
+		b.logger.Errorw("Generated catalog is invalid", "error", err)
+		return []brokerapi.Service{}, err
+	}
+
+	if err := checkCatalogConsistency(services, b.bindingCapabilities()); err != nil {
+		b.logger.Errorw("Generated catalog contradicts the broker", "error", err)
+		return []brokerapi.Service{}, err
+	}
+
+	// Plans share schemas and metadata with each other and with the broker
+	// settings. Consumers get their own copy so changing it can't affect
+	// later catalogs.
+	for i, svc := range services {
+		services[i] = copyService(svc)
+	}
+
 	return services, nil
 }
 
-func service(provider *atlas.Provider) (service brokerapi.Service) {
-	// Create a CLI-friendly and user-friendly name. Will be displayed in the
-	// marketplace generated by the service catalog.
-	catalogName := fmt.Sprintf("mongodb-atlas-%s", strings.ToLower(provider.Name))
+// copyService returns a deep copy of a service.
+func copyService(svc brokerapi.Service) brokerapi.Service {
+	svc.Tags = copyStrings(svc.Tags)
+
+	if svc.Requires != nil {
+		svc.Requires = append([]brokerapi.RequiredPermission{}, svc.Requires...)
+	}
+
+	if svc.Metadata != nil {
+		metadata := *svc.Metadata
+		metadata.Shareable = copyBool(metadata.Shareable)
+		metadata.AdditionalMetadata = copyJSONObject(metadata.AdditionalMetadata)
+		svc.Metadata = &metadata
+	}
+
+	if svc.DashboardClient != nil {
+		dashboardClient := *svc.DashboardClient
+		svc.DashboardClient = &dashboardClient
+	}
+
+	if svc.Plans != nil {
+		plans := make([]brokerapi.ServicePlan, len(svc.Plans))
+		for i, plan := range svc.Plans {
+			plans[i] = copyPlan(plan)
+		}
+		svc.Plans = plans
+	}
+
+	return svc
+}
+
+// copyPlan returns a deep copy of a plan.
+func copyPlan(plan brokerapi.ServicePlan) brokerapi.ServicePlan {
+	plan.Free = copyBool(plan.Free)
+	plan.Bindable = copyBool(plan.Bindable)
+
+	if plan.Metadata != nil {
+		metadata := *plan.Metadata
+		metadata.Bullets = copyStrings(metadata.Bullets)
+		metadata.AdditionalMetadata = copyJSONObject(metadata.AdditionalMetadata)
+
+		if metadata.Costs != nil {
+			costs := make([]brokerapi.ServicePlanCost, len(metadata.Costs))
+			for i, cost := range metadata.Costs {
+				costs[i] = brokerapi.ServicePlanCost{Unit: cost.Unit}
+				if cost.Amount != nil {
+					costs[i].Amount = map[string]float64{}
+					for currency, amount := range cost.Amount {
+						costs[i].Amount[currency] = amount
+					}
+				}
+			}
+			metadata.Costs = costs
+		}
+
+		plan.Metadata = &metadata
+	}
+
+	if plan.Schemas != nil {
+		schemas := *plan.Schemas
+		schemas.Instance.Create.Parameters = copyJSONObject(schemas.Instance.Create.Parameters)
+		schemas.Instance.Update.Parameters = copyJSONObject(schemas.Instance.Update.Parameters)
+		schemas.Binding.Create.Parameters = copyJSONObject(schemas.Binding.Create.Parameters)
+		plan.Schemas = &schemas
+	}
+
+	if plan.MaintenanceInfo != nil {
+		maintenanceInfo := *plan.MaintenanceInfo
+		if maintenanceInfo.Public != nil {
+			maintenanceInfo.Public = map[string]string{}
+			for key, value := range plan.MaintenanceInfo.Public {
+				maintenanceInfo.Public[key] = value
+			}
+		}
+		plan.MaintenanceInfo = &maintenanceInfo
+	}
+
+	return plan
+}
+
+// copyJSONObject returns a deep copy of a decoded JSON object such as a
+// schema. Values other than objects and arrays are immutable and shared.
+func copyJSONObject(object map[string]interface{}) map[string]interface{} {
+	if object == nil {
+		return nil
+	}
+
+	return copyJSONValue(object).(map[string]interface{})
+}
+
+func copyJSONValue(value interface{}) interface{} {
+	switch value := value.(type) {
+	case map[string]interface{}:
+		copied := make(map[string]interface{}, len(value))
+		for key, v := range value {
+			copied[key] = copyJSONValue(v)
+		}
+		return copied
+	case []interface{}:
+		copied := make([]interface{}, len(value))
+		for i, v := range value {
+			copied[i] = copyJSONValue(v)
+		}
+		return copied
+	case []string:
+		return copyStrings(value)
+	default:
+		return value
+	}
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+
+	return append([]string{}, values...)
+}
+
+func copyBool(value *bool) *bool {
+	if value == nil {
+		return nil
+	}
+
+	copied := *value
+	return &copied
+}
+
+// staticCatalog returns the services and plans the broker can offer without
+// asking Atlas. The dedicated plans are taken from instanceSizeSpecs.
+func staticCatalog(idPrefix string) []brokerapi.Service {
+	sizeNames := make([]string, 0, len(instanceSizeSpecs))
+	for name := range instanceSizeSpecs {
+		sizeNames = append(sizeNames, name)
+	}
+	sort.Strings(sizeNames)
+
+	services := []brokerapi.Service{}
+	for _, providerName := range providerNames {
+		if providerName == providerNameTenant {
+			services = append(services, sharedService(idPrefix))
+			continue
+		}
+
+		provider := &atlas.Provider{Name: providerName}
+		svc := brokerapi.Service{
+			ID:       serviceIDForProvider(idPrefix, provider),
+			Name:     serviceNameForProvider(provider),
+			Bindable: true,
+		}
+
+		for _, name := range sizeNames {
+			svc.Plans = append(svc.Plans, brokerapi.ServicePlan{
+				ID:   planIDForInstanceSize(idPrefix, provider, atlas.InstanceSize{Name: name}),
+				Name: name,
+			})
+		}
+
+		services = append(services, svc)
+	}
+
+	return services
+}
+
+// checkCatalogIDs makes sure the IDs of a catalog are unique. Plans are
+// resolved within their service, but platforms and the plan-keyed settings
+// assume plan IDs are unique across services.
+func checkCatalogIDs(services []brokerapi.Service) error {
+	serviceIDs := map[string]bool{}
+	planServices := map[string]string{}
 
+	for _, svc := range services {
+		if serviceIDs[svc.ID] {
+			return fmt.Errorf(`service ID "%s" is used more than once`, svc.ID)
+		}
+		serviceIDs[svc.ID] = true
+
+		for _, plan := range svc.Plans {
+			if other, isDuplicate := planServices[plan.ID]; isDuplicate {
+				if other == svc.ID {
+					return fmt.Errorf(`plan ID "%s" is used more than once by service "%s"`, plan.ID, svc.ID)
+				}
+
+				return fmt.Errorf(`plan ID "%s" is used by both service "%s" and "%s"`, plan.ID, other, svc.ID)
+			}
+			planServices[plan.ID] = svc.ID
+		}
+	}
+
+	return nil
+}
+
+func service(idPrefix string, provider *atlas.Provider) (service brokerapi.Service) {
 	service = brokerapi.Service{
-		ID:                   serviceIDForProvider(provider),
-		Name:                 catalogName,
+		ID:                   serviceIDForProvider(idPrefix, provider),
+		Name:                 serviceNameForProvider(provider),
 		Description:          fmt.Sprintf(`Atlas cluster hosted on "%s"`, provider.Name),
 		Bindable:             true,
-		InstancesRetrievable: false,
+		InstancesRetrievable: true,
 		BindingsRetrievable:  false,
 		Metadata:             nil,
 		PlanUpdatable:        true,
-		Plans:                plansForProvider(provider),
+		Plans:                plansForProvider(idPrefix, provider),
 	}
 
 	return service
 }
 
-func findProviderByServiceID(client atlas.Client, serviceID string) (*atlas.Provider, error) {
+func findProviderByServiceID(client atlas.Client, idPrefix string, serviceID string) (*atlas.Provider, error) {
 	for _, providerName := range providerNames {
 		provider, err := client.GetProvider(providerName)
 		if err != nil {
 			return nil, err
 		}
 
-		if serviceIDForProvider(provider) == serviceID {
+		if serviceIDForProvider(idPrefix, provider) == serviceID {
 			return provider, nil
 		}
 	}
@@ -135,9 +431,39 @@ func findProviderByServiceID(client atlas.Client, serviceID string) (*atlas.Prov
 	return nil, apiresponses.NewFailureResponse(errors.New("Invalid service ID"), http.StatusBadRequest, "invalid-service-id")
 }
 
-func findInstanceSizeByPlanID(provider *atlas.Provider, planID string) (*atlas.InstanceSize, error) {
-	for _, instanceSize := range provider.InstanceSizes {
-		if planIDForInstanceSize(provider, instanceSize) == planID {
+// findInstanceSizeByPlanID resolves the instance size of a plan. If
+// allowedSizes is set, plans for other instance sizes are rejected even if
+// they exist in Atlas, as platforms can pass plan IDs which aren't in the
+// catalog.
+func findInstanceSizeByPlanID(idPrefix string, provider *atlas.Provider, planID string, allowedSizes []string) (*atlas.InstanceSize, error) {
+	// Sizes are visited in order so the result doesn't depend on the
+	// iteration order of the map.
+	names := make([]string, 0, len(provider.InstanceSizes))
+	for name := range provider.InstanceSizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		instanceSize := provider.InstanceSizes[name]
+		if planIDForInstanceSize(idPrefix, provider, instanceSize) == planID {
+			if !instanceSizeAllowed(instanceSize.Name, allowedSizes) {
+				err := fmt.Errorf(`plan "%s" is not allowed by the broker`, instanceSize.Name)
+				return nil, apiresponses.NewFailureResponse(err, http.StatusBadRequest, "plan-not-allowed")
+			}
+
+			return &instanceSize, nil
+		}
+	}
+
+	for _, name := range backedSharedInstanceSizes(provider) {
+		instanceSize := atlas.InstanceSize{Name: name}
+		if planIDForInstanceSize(idPrefix, provider, instanceSize) == planID {
+			if !instanceSizeAllowed(name, allowedSizes) {
+				err := fmt.Errorf(`plan "%s" is not allowed by the broker`, name)
+				return nil, apiresponses.NewFailureResponse(err, http.StatusBadRequest, "plan-not-allowed")
+			}
+
 			return &instanceSize, nil
 		}
 	}
@@ -145,31 +471,144 @@ func findInstanceSizeByPlanID(provider *atlas.Provider, planID string) (*atlas.I
 	return nil, apiresponses.NewFailureResponse(errors.New("Invalid plan ID"), http.StatusBadRequest, "invalid-plan-id")
 }
 
+// backedSharedInstanceSizes returns the shared instance sizes a provider can
+// back, leaving out those Atlas already lists for it. The tenant provider
+// itself can't back any.
+func backedSharedInstanceSizes(provider *atlas.Provider) []string {
+	if provider.Name == providerNameTenant {
+		return nil
+	}
+
+	names := []string{}
+	for _, name := range sharedInstanceSizes {
+		listed := false
+		for _, instanceSize := range provider.InstanceSizes {
+			listed = listed || instanceSize.Name == name
+		}
+
+		if !listed {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// clusterProviderName returns the provider name of a cluster of the passed
+// plan. Shared instance sizes always use the tenant provider.
+func clusterProviderName(providerName string, instanceSizeName string) string {
+	if isSharedInstanceSizeName(instanceSizeName) {
+		return providerNameTenant
+	}
+
+	return providerName
+}
+
+// isSharedInstanceSizeName returns whether an instance size is shared.
+func isSharedInstanceSizeName(name string) bool {
+	for _, shared := range sharedInstanceSizes {
+		if name == shared {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolvePlanNames resolves the catalog names of a service and plan, which
+// are easier to read in logs than their IDs. The plan name is empty if no plan
+// ID is passed. Plans outside allowedSizes are rejected, see
+// findInstanceSizeByPlanID.
+func resolvePlanNames(client atlas.Client, idPrefix string, serviceID string, planID string, allowedSizes []string) (serviceName string, planName string, err error) {
+	provider, err := findProviderByServiceID(client, idPrefix, serviceID)
+	if err != nil {
+		return
+	}
+
+	serviceName = serviceNameForProvider(provider)
+
+	if planID != "" {
+		var instanceSize *atlas.InstanceSize
+		instanceSize, err = findInstanceSizeByPlanID(idPrefix, provider, planID, allowedSizes)
+		if err != nil {
+			return
+		}
+
+		planName = instanceSize.Name
+	}
+
+	return
+}
+
 // plansForProvider will convert the available instance sizes for a provider
 // to service plans for the broker.
-func plansForProvider(provider *atlas.Provider) []brokerapi.ServicePlan {
+func plansForProvider(idPrefix string, provider *atlas.Provider) []brokerapi.ServicePlan {
 	var plans []brokerapi.ServicePlan
 
-	for _, instanceSize := range provider.InstanceSizes {
+	// All plans of a provider accept the same parameters.
+	schemas := planSchemas(provider)
+
+	// Sizes are listed in order so consecutive catalogs are equal.
+	names := make([]string, 0, len(provider.InstanceSizes))
+	for name := range provider.InstanceSizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		instanceSize := provider.InstanceSizes[name]
 		plan := brokerapi.ServicePlan{
-			ID:          planIDForInstanceSize(provider, instanceSize),
+			ID:          planIDForInstanceSize(idPrefix, provider, instanceSize),
 			Name:        instanceSize.Name,
 			Description: fmt.Sprintf("Instance size \"%s\"", instanceSize.Name),
+			Metadata:    planMetadata(instanceSize.Name),
+			Schemas:     schemas,
 		}
 
 		plans = append(plans, plan)
 	}
 
+	// Shared clusters backed by the provider. Only M0 clusters are free.
+	for _, name := range backedSharedInstanceSizes(provider) {
+		free := name == InstanceSizeNameM0
+		plans = append(plans, brokerapi.ServicePlan{
+			ID:          planIDForInstanceSize(idPrefix, provider, atlas.InstanceSize{Name: name}),
+			Name:        name,
+			Description: fmt.Sprintf("Shared instance size \"%s\"", name),
+			Free:        &free,
+			Metadata:    planMetadata(name),
+			Schemas:     schemas,
+		})
+	}
+
 	return plans
 }
 
+// serviceNameForProvider will generate a CLI-friendly and user-friendly name
+// for a provider. It will be displayed in the marketplace generated by the
+// service catalog.
+func serviceNameForProvider(provider *atlas.Provider) string {
+	return fmt.Sprintf("mongodb-atlas-%s", strings.ToLower(provider.Name))
+}
+
 // serviceIDForProvider will generate a globally unique ID for a provider.
-func serviceIDForProvider(provider *atlas.Provider) string {
+func serviceIDForProvider(idPrefix string, provider *atlas.Provider) string {
 	return fmt.Sprintf("%s-service-%s", idPrefix, strings.ToLower(provider.Name))
 }
 
 // planIDForInstanceSize will generate a globally unique ID for an instance size
 // on a specific provider.
-func planIDForInstanceSize(provider *atlas.Provider, instanceSize atlas.InstanceSize) string {
+func planIDForInstanceSize(idPrefix string, provider *atlas.Provider, instanceSize atlas.InstanceSize) string {
 	return fmt.Sprintf("%s-plan-%s-%s", idPrefix, strings.ToLower(provider.Name), strings.ToLower(instanceSize.Name))
 }
+
+// currentID translates a service or plan ID using DefaultIDPrefix into the
+// configured prefix if the broker accepts the default IDs. Other IDs are
+// returned as is.
+func (b Broker) currentID(id string) string {
+	if !b.acceptDefaultIDs || b.idPrefix == DefaultIDPrefix || !strings.HasPrefix(id, DefaultIDPrefix+"-") {
+		return id
+	}
+
+	return b.idPrefix + strings.TrimPrefix(id, DefaultIDPrefix)
+}
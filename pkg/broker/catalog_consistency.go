@@ -0,0 +1,72 @@
+package broker
+
+import (
+	"fmt"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// bindingCapabilities describe what the binding operations of a broker
+// support, which the binding flags of its catalog have to agree with.
+// Platforms cache the catalog and don't expect it to be contradicted.
+type bindingCapabilities struct {
+	// Bind creates bindings for bindable plans.
+	Bind bool
+
+	// AsyncBind means binds may respond with 202 Accepted.
+	AsyncBind bool
+
+	// GetBinding returns the credentials of existing bindings.
+	GetBinding bool
+}
+
+// bindingCapabilities returns the capabilities of the binding operations as
+// configured. Bindings are always created synchronously, their credentials
+// can only be fetched again if they're kept. Asynchronous unbinds are polled
+// with LastBindingOperation, which the catalog has no say in.
+func (b Broker) bindingCapabilities() bindingCapabilities {
+	return bindingCapabilities{
+		Bind:       true,
+		AsyncBind:  false,
+		GetBinding: b.credentialStore != nil,
+	}
+}
+
+// applyBindingFlags sets the binding flags of a service which depend on the
+// broker's configuration.
+func (b Broker) applyBindingFlags(svc brokerapi.Service) brokerapi.Service {
+	svc.BindingsRetrievable = b.bindingCapabilities().GetBinding
+	return svc
+}
+
+// checkCatalogConsistency cross-references the binding flags of a catalog
+// with the capabilities of the broker and returns the first contradiction.
+func checkCatalogConsistency(services []brokerapi.Service, capabilities bindingCapabilities) error {
+	for _, svc := range services {
+		bindable := false
+		for _, plan := range svc.Plans {
+			planBindable := svc.Bindable
+			if plan.Bindable != nil {
+				planBindable = *plan.Bindable
+			}
+
+			if planBindable && !capabilities.Bind {
+				return fmt.Errorf(`plan "%s" of service "%s" is bindable but the broker can't create bindings`, plan.ID, svc.ID)
+			}
+			bindable = bindable || planBindable
+		}
+
+		switch {
+		case svc.BindingsRetrievable && !bindable:
+			return fmt.Errorf(`service "%s" has bindings_retrievable set but none of its plans are bindable`, svc.ID)
+		case svc.BindingsRetrievable && !capabilities.GetBinding:
+			return fmt.Errorf(`service "%s" has bindings_retrievable set but bindings can't be fetched without a credential store`, svc.ID)
+		case bindable && capabilities.AsyncBind && !svc.BindingsRetrievable:
+			// Platforms fetch the credentials of asynchronous bindings
+			// once they've been created.
+			return fmt.Errorf(`service "%s" can be bound asynchronously but doesn't have bindings_retrievable set`, svc.ID)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCatalogConsistency(t *testing.T) {
+	notBindable := false
+	bindable := true
+
+	tests := []struct {
+		name         string
+		service      brokerapi.Service
+		capabilities bindingCapabilities
+		err          string
+	}{
+		{
+			name:         "bindable",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, Plans: []brokerapi.ServicePlan{{ID: "plan"}}},
+			capabilities: bindingCapabilities{Bind: true},
+		},
+		{
+			name:         "retrievable",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, BindingsRetrievable: true, Plans: []brokerapi.ServicePlan{{ID: "plan"}}},
+			capabilities: bindingCapabilities{Bind: true, GetBinding: true},
+		},
+		{
+			name:         "bindable plan of a service which isn't",
+			service:      brokerapi.Service{ID: "svc", Plans: []brokerapi.ServicePlan{{ID: "plan", Bindable: &bindable}}},
+			capabilities: bindingCapabilities{Bind: true},
+		},
+		{
+			name:         "retrievable without a credential store",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, BindingsRetrievable: true, Plans: []brokerapi.ServicePlan{{ID: "plan"}}},
+			capabilities: bindingCapabilities{Bind: true},
+			err:          `service "svc" has bindings_retrievable set but bindings can't be fetched without a credential store`,
+		},
+		{
+			name:         "retrievable but not bindable",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, BindingsRetrievable: true, Plans: []brokerapi.ServicePlan{{ID: "plan", Bindable: &notBindable}}},
+			capabilities: bindingCapabilities{Bind: true, GetBinding: true},
+			err:          `service "svc" has bindings_retrievable set but none of its plans are bindable`,
+		},
+		{
+			name:         "bindable without binds",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, Plans: []brokerapi.ServicePlan{{ID: "plan"}}},
+			capabilities: bindingCapabilities{},
+			err:          `plan "plan" of service "svc" is bindable but the broker can't create bindings`,
+		},
+		{
+			name:         "async binds without retrievable bindings",
+			service:      brokerapi.Service{ID: "svc", Bindable: true, Plans: []brokerapi.ServicePlan{{ID: "plan"}}},
+			capabilities: bindingCapabilities{Bind: true, AsyncBind: true, GetBinding: true},
+			err:          `service "svc" can be bound asynchronously but doesn't have bindings_retrievable set`,
+		},
+	}
+
+	for _, test := range tests {
+		err := checkCatalogConsistency([]brokerapi.Service{test.service}, test.capabilities)
+		if test.err == "" {
+			assert.NoError(t, err, test.name)
+		} else {
+			assert.EqualError(t, err, test.err, test.name)
+		}
+	}
+}
+
+func TestGeneratedCatalogConsistency(t *testing.T) {
+	configurations := [][]Option{
+		{},
+		{WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey)},
+	}
+
+	for _, opts := range configurations {
+		broker, _, ctx := setupTest(opts...)
+
+		services, err := broker.Services(ctx)
+		if assert.NoError(t, err) && assert.NotEmpty(t, services) {
+			assert.NoError(t, checkCatalogConsistency(services, broker.bindingCapabilities()))
+			assert.Equal(t, broker.credentialStore != nil, services[0].BindingsRetrievable)
+		}
+
+		// The static catalog checked at startup agrees with the generated one.
+		_, err = New(zap.NewNop().Sugar(), opts...)
+		assert.NoError(t, err)
+	}
+}
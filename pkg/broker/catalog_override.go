@@ -0,0 +1,306 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"gopkg.in/yaml.v2"
+)
+
+// CatalogOverride customizes the generated catalog. Services and plans are
+// referenced by their IDs, which can't be changed as they identify the
+// provider and instance size of clusters.
+type CatalogOverride struct {
+	Services map[string]ServiceOverride `json:"services"`
+}
+
+// ServiceOverride replaces the name, description or metadata of a service
+// and customizes its plans.
+type ServiceOverride struct {
+	Name        string                     `json:"name,omitempty"`
+	Description string                     `json:"description,omitempty"`
+	Metadata    *brokerapi.ServiceMetadata `json:"metadata,omitempty"`
+	Plans       map[string]PlanOverride    `json:"plans,omitempty"`
+}
+
+// PlanOverride replaces the name or description of a plan, or hides it.
+// Metadata fields which are set replace the generated ones.
+type PlanOverride struct {
+	Name        string                         `json:"name,omitempty"`
+	Description string                         `json:"description,omitempty"`
+	Metadata    *brokerapi.ServicePlanMetadata `json:"metadata,omitempty"`
+
+	// Hidden plans are left out of the catalog and can't be provisioned or
+	// changed to. Existing instances keep working.
+	Hidden bool `json:"hidden,omitempty"`
+}
+
+// ReadCatalogOverrideFile reads a catalog override. Files ending in ".yaml"
+// or ".yml" are read as YAML, anything else as JSON. Unknown settings are
+// rejected to catch typos early, the service and plan IDs are checked by New
+// as they depend on the ID prefix.
+func ReadCatalogOverrideFile(path string) (*CatalogOverride, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		data, err = yamlToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid catalog file %s: %v", path, err)
+		}
+	}
+
+	override := &CatalogOverride{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(override); err != nil {
+		return nil, fmt.Errorf("invalid catalog file %s: %v", path, err)
+	}
+
+	return override, nil
+}
+
+// yamlToJSON converts a YAML document into JSON so it can be decoded using
+// the JSON field names.
+func yamlToJSON(data []byte) ([]byte, error) {
+	var value interface{}
+	if err := yaml.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+
+	value, err := stringKeys(value)
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(value)
+}
+
+// stringKeys converts the maps decoded from YAML, which can have keys of any
+// type, into maps with string keys.
+func stringKeys(value interface{}) (interface{}, error) {
+	switch value := value.(type) {
+	case map[interface{}]interface{}:
+		converted := map[string]interface{}{}
+		for key, v := range value {
+			name, ok := key.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v must be a string", key)
+			}
+
+			var err error
+			if converted[name], err = stringKeys(v); err != nil {
+				return nil, err
+			}
+		}
+
+		return converted, nil
+	case []interface{}:
+		for i, v := range value {
+			var err error
+			if value[i], err = stringKeys(v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return value, nil
+}
+
+// knownPlanNames returns the default names of the plans a service can have,
+// keyed by plan ID. Dedicated instance sizes are taken from
+// instanceSizeSpecs, so plans of sizes missing from it can't be overridden.
+func knownPlanNames(idPrefix string, serviceID string) (map[string]string, bool) {
+	for _, svc := range staticCatalog(idPrefix) {
+		if svc.ID != serviceID {
+			continue
+		}
+
+		names := map[string]string{}
+		for _, plan := range svc.Plans {
+			names[plan.ID] = plan.Name
+		}
+
+		return names, true
+	}
+
+	return nil, false
+}
+
+// validate makes sure every overridden service and plan exists and that
+// names stay unique.
+func (o *CatalogOverride) validate(idPrefix string) error {
+	serviceIDs := make([]string, 0, len(o.Services))
+	for serviceID := range o.Services {
+		serviceIDs = append(serviceIDs, serviceID)
+	}
+	sort.Strings(serviceIDs)
+
+	for _, serviceID := range serviceIDs {
+		service := o.Services[serviceID]
+
+		knownPlans, ok := knownPlanNames(idPrefix, serviceID)
+		if !ok {
+			return fmt.Errorf(`unknown service ID "%s"`, serviceID)
+		}
+
+		planNames := map[string]string{}
+		for planID, name := range knownPlans {
+			if _, isOverridden := service.Plans[planID]; !isOverridden {
+				planNames[name] = planID
+			}
+		}
+
+		planIDs := make([]string, 0, len(service.Plans))
+		for planID := range service.Plans {
+			planIDs = append(planIDs, planID)
+		}
+		sort.Strings(planIDs)
+
+		for _, planID := range planIDs {
+			plan := service.Plans[planID]
+
+			name, ok := knownPlans[planID]
+			if !ok {
+				return fmt.Errorf(`unknown plan ID "%s" for service "%s"`, planID, serviceID)
+			}
+
+			if plan.Hidden {
+				continue
+			}
+
+			if plan.Name != "" {
+				name = plan.Name
+			}
+
+			if other, isDuplicate := planNames[name]; isDuplicate {
+				return fmt.Errorf(`plans "%s" and "%s" of service "%s" both have the name "%s"`, other, planID, serviceID, name)
+			}
+			planNames[name] = planID
+		}
+	}
+
+	serviceNames := map[string]string{}
+	for _, providerName := range providerNames {
+		provider := &atlas.Provider{Name: providerName}
+		serviceID := serviceIDForProvider(idPrefix, provider)
+
+		name := serviceNameForProvider(provider)
+		if override, ok := o.Services[serviceID]; ok && override.Name != "" {
+			name = override.Name
+		}
+
+		if other, isDuplicate := serviceNames[name]; isDuplicate {
+			return fmt.Errorf(`services "%s" and "%s" both have the name "%s"`, other, serviceID, name)
+		}
+		serviceNames[name] = serviceID
+	}
+
+	return nil
+}
+
+// apply merges the override of a service into it. The plans are copied as
+// they may be shared between catalogs.
+func (o *CatalogOverride) apply(svc brokerapi.Service) brokerapi.Service {
+	override, ok := o.Services[svc.ID]
+	if !ok {
+		return svc
+	}
+
+	if override.Name != "" {
+		svc.Name = override.Name
+	}
+
+	if override.Description != "" {
+		svc.Description = override.Description
+	}
+
+	if override.Metadata != nil {
+		svc.Metadata = override.Metadata
+	}
+
+	plans := []brokerapi.ServicePlan{}
+	for _, plan := range svc.Plans {
+		planOverride, ok := override.Plans[plan.ID]
+		if !ok {
+			plans = append(plans, plan)
+			continue
+		}
+
+		if planOverride.Hidden {
+			continue
+		}
+
+		if planOverride.Name != "" {
+			plan.Name = planOverride.Name
+		}
+
+		if planOverride.Description != "" {
+			plan.Description = planOverride.Description
+		}
+
+		if planOverride.Metadata != nil {
+			plan.Metadata = mergePlanMetadata(plan.Metadata, planOverride.Metadata)
+		}
+
+		plans = append(plans, plan)
+	}
+
+	svc.Plans = plans
+	return svc
+}
+
+// mergePlanMetadata returns a copy of generated metadata with the fields set
+// by the override replaced.
+func mergePlanMetadata(generated *brokerapi.ServicePlanMetadata, override *brokerapi.ServicePlanMetadata) *brokerapi.ServicePlanMetadata {
+	merged := brokerapi.ServicePlanMetadata{}
+	if generated != nil {
+		merged = *generated
+	}
+
+	if override.DisplayName != "" {
+		merged.DisplayName = override.DisplayName
+	}
+
+	if override.Bullets != nil {
+		merged.Bullets = override.Bullets
+	}
+
+	if override.Costs != nil {
+		merged.Costs = override.Costs
+	}
+
+	if override.AdditionalMetadata != nil {
+		merged.AdditionalMetadata = override.AdditionalMetadata
+	}
+
+	return &merged
+}
+
+// checkPlanNotHidden rejects plans hidden by the catalog override, as
+// platforms can pass plan IDs which aren't in the catalog.
+func (b Broker) checkPlanNotHidden(serviceID string, planID string) error {
+	if b.catalogOverride == nil {
+		return nil
+	}
+
+	if b.catalogOverride.Services[serviceID].Plans[planID].Hidden {
+		err := fmt.Errorf(`plan "%s" is not allowed by the broker`, planID)
+		return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "plan-not-allowed")
+	}
+
+	return nil
+}
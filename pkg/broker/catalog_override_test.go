@@ -0,0 +1,207 @@
+package broker
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// writeCatalogFile writes a temporary catalog override with the passed file
+// name and returns its path.
+func writeCatalogFile(t *testing.T, name string, contents string) string {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestReadCatalogOverrideFile(t *testing.T) {
+	yamlPath := writeCatalogFile(t, "catalog.yaml", `
+services:
+  aosb-cluster-service-aws:
+    name: mongodb
+    description: MongoDB on AWS
+    metadata:
+      displayName: MongoDB
+    plans:
+      aosb-cluster-plan-aws-m10:
+        name: small
+        description: For development
+        metadata:
+          displayName: Small
+      aosb-cluster-plan-aws-m20:
+        hidden: true
+`)
+	defer os.RemoveAll(filepath.Dir(yamlPath))
+
+	jsonPath := writeCatalogFile(t, "catalog.json", `{
+		"services": {
+			"aosb-cluster-service-aws": {
+				"name": "mongodb",
+				"description": "MongoDB on AWS",
+				"metadata": {"displayName": "MongoDB"},
+				"plans": {
+					"aosb-cluster-plan-aws-m10": {
+						"name": "small",
+						"description": "For development",
+						"metadata": {"displayName": "Small"}
+					},
+					"aosb-cluster-plan-aws-m20": {"hidden": true}
+				}
+			}
+		}
+	}`)
+	defer os.RemoveAll(filepath.Dir(jsonPath))
+
+	yamlOverride, err := ReadCatalogOverrideFile(yamlPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	jsonOverride, err := ReadCatalogOverrideFile(jsonPath)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, jsonOverride, yamlOverride)
+}
+
+func TestCatalogOverride(t *testing.T) {
+	override := CatalogOverride{
+		Services: map[string]ServiceOverride{
+			testServiceID: {
+				Name:        "mongodb",
+				Description: "MongoDB on AWS",
+				Metadata:    &brokerapi.ServiceMetadata{DisplayName: "MongoDB"},
+				Plans: map[string]PlanOverride{
+					testPlanID: {
+						Name:        "small",
+						Description: "For development",
+						Metadata:    &brokerapi.ServicePlanMetadata{DisplayName: "Small"},
+					},
+					"aosb-cluster-plan-aws-m20": {Hidden: true},
+				},
+			},
+			sharedService(DefaultIDPrefix).ID: {
+				Plans: map[string]PlanOverride{
+					"aosb-cluster-plan-tenant-m2": {Hidden: true},
+					"aosb-cluster-plan-tenant-m5": {Hidden: true},
+				},
+			},
+		},
+	}
+	broker, client, ctx := setupTest(WithCatalogOverride(override))
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, service := range services {
+		assert.NotEqual(t, sharedService(DefaultIDPrefix).ID, service.ID, "Expected services without plans to be left out")
+
+		if service.ID != testServiceID {
+			continue
+		}
+
+		assert.Equal(t, "mongodb", service.Name)
+		assert.Equal(t, "MongoDB on AWS", service.Description)
+		assert.Equal(t, "MongoDB", service.Metadata.DisplayName)
+	}
+
+	assert.Nil(t, planByID(services, "aosb-cluster-plan-aws-m20"))
+
+	plan := planByID(services, testPlanID)
+	if assert.NotNil(t, plan) {
+		assert.Equal(t, "small", plan.Name)
+		assert.Equal(t, "For development", plan.Description)
+		assert.Equal(t, "Small", plan.Metadata.DisplayName)
+		assert.Equal(t, planMetadata("M10").Bullets, plan.Metadata.Bullets, "Expected generated metadata to be kept")
+		assert.NotNil(t, plan.Schemas)
+	}
+
+	// The shared catalog isn't modified.
+	assert.Len(t, sharedService(DefaultIDPrefix).Plans, 2)
+
+	// Hidden plans can't be provisioned or changed to.
+	_, err = broker.Provision(ctx, "hidden", brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+	assertPlanNotAllowed(t, err)
+	assert.Nil(t, client.Clusters["hidden"])
+
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:         "aosb-cluster-plan-aws-m20",
+		ServiceID:      testServiceID,
+		PreviousValues: brokerapi.PreviousValues{PlanID: testPlanID},
+	}, true)
+	assertPlanNotAllowed(t, err)
+}
+
+func TestCatalogOverrideInvalid(t *testing.T) {
+	invalidOverrides := map[string]CatalogOverride{
+		`unknown service ID "unknown"`: {
+			Services: map[string]ServiceOverride{"unknown": {}},
+		},
+		`unknown plan ID "aosb-cluster-plan-gcp-m10" for service "aosb-cluster-service-aws"`: {
+			Services: map[string]ServiceOverride{
+				testServiceID: {Plans: map[string]PlanOverride{"aosb-cluster-plan-gcp-m10": {}}},
+			},
+		},
+		`plans "aosb-cluster-plan-aws-m20" and "aosb-cluster-plan-aws-m10" of service "aosb-cluster-service-aws" both have the name "M20"`: {
+			Services: map[string]ServiceOverride{
+				testServiceID: {Plans: map[string]PlanOverride{testPlanID: {Name: "M20"}}},
+			},
+		},
+		`services "aosb-cluster-service-aws" and "aosb-cluster-service-gcp" both have the name "mongodb"`: {
+			Services: map[string]ServiceOverride{
+				testServiceID:              {Name: "mongodb"},
+				"aosb-cluster-service-gcp": {Name: "mongodb"},
+			},
+		},
+	}
+
+	for expected, override := range invalidOverrides {
+		_, err := New(zap.NewNop().Sugar(), WithCatalogOverride(override))
+		assert.EqualError(t, err, expected)
+	}
+
+	// Names of hidden plans can be reused.
+	_, err := New(zap.NewNop().Sugar(), WithCatalogOverride(CatalogOverride{
+		Services: map[string]ServiceOverride{
+			testServiceID: {Plans: map[string]PlanOverride{
+				testPlanID:                  {Name: "M20"},
+				"aosb-cluster-plan-aws-m20": {Hidden: true},
+			}},
+		},
+	}))
+	assert.NoError(t, err)
+
+	path := writeCatalogFile(t, "catalog.yml", "services:\n  aosb-cluster-service-aws:\n    plan: {}\n")
+	defer os.RemoveAll(filepath.Dir(path))
+
+	_, err = ReadCatalogOverrideFile(path)
+	assert.Error(t, err, "Expected unknown fields to be rejected")
+}
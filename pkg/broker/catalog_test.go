@@ -1,8 +1,14 @@
 package broker
 
 import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
 	"testing"
 
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 	"github.com/stretchr/testify/assert"
 	"go.uber.org/zap"
 )
@@ -33,3 +39,377 @@ func TestWhitelist(t *testing.T) {
 	assert.Len(t, services[0].Plans, 1)
 	assert.NoError(t, err)
 }
+
+func TestAllowedInstanceSizes(t *testing.T) {
+	broker, client, ctx := setupTest(WithAllowedInstanceSizes(" M10"))
+
+	// The catalog only contains the allowed plans, services without any are
+	// left out.
+	services, err := broker.Services(ctx)
+	assert.NoError(t, err)
+	for _, service := range services {
+		if assert.Len(t, service.Plans, 1) {
+			assert.Equal(t, "M10", service.Plans[0].Name)
+		}
+		assert.NotEqual(t, sharedService(DefaultIDPrefix).ID, service.ID)
+	}
+
+	// Plans outside the list are rejected even though Atlas offers them.
+	_, err = broker.Provision(ctx, "rejected", brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+	assertPlanNotAllowed(t, err)
+	assert.Nil(t, client.Clusters["rejected"])
+
+	// Parameters can't bypass the plan.
+	_, err = broker.Provision(ctx, "shared", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"providerSettings": {"providerName": "TENANT", "backingProviderName": "AWS", "instanceSizeName": "M2"}}}`),
+	}, true)
+	assertInvalidParams(t, err, "cluster.providerSettings.instanceSizeName")
+	assert.Nil(t, client.Clusters["shared"])
+
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:         "aosb-cluster-plan-aws-m20",
+		ServiceID:      testServiceID,
+		PreviousValues: brokerapi.PreviousValues{PlanID: testPlanID},
+	}, true)
+	assertPlanNotAllowed(t, err)
+	assert.Equal(t, "M10", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+}
+
+func TestAllowedInstanceSizesExistingPlan(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+
+	// Instances on plans which have been disallowed since can still be
+	// updated as long as they keep their plan.
+	restricted := NewBroker(zap.NewNop().Sugar(), WithAllowedInstanceSizes("M10"))
+	_, err := restricted.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:         "aosb-cluster-plan-aws-m20",
+		ServiceID:      testServiceID,
+		PreviousValues: brokerapi.PreviousValues{PlanID: "aosb-cluster-plan-aws-m20"},
+		RawParameters:  []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "M20", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+}
+
+func assertPlanNotAllowed(t *testing.T, err error) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response but got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "plan-not-allowed", failure.LoggerAction())
+	}
+}
+
+func TestSharedPlans(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	plans := map[string]brokerapi.ServicePlan{}
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			plans[plan.ID] = plan
+		}
+	}
+
+	for _, id := range []string{"aosb-cluster-plan-aws-m0", "aosb-cluster-plan-gcp-m2", "aosb-cluster-plan-azure-m5"} {
+		assert.Contains(t, plans, id)
+	}
+
+	if assert.NotNil(t, plans["aosb-cluster-plan-aws-m0"].Free) {
+		assert.True(t, *plans["aosb-cluster-plan-aws-m0"].Free)
+	}
+	if assert.NotNil(t, plans["aosb-cluster-plan-aws-m2"].Free) {
+		assert.False(t, *plans["aosb-cluster-plan-aws-m2"].Free)
+	}
+	assert.Nil(t, plans[testPlanID].Free)
+}
+
+func TestIDPrefix(t *testing.T) {
+	broker, client, ctx := setupTest(WithIDPrefix("mongodb-dev"))
+
+	services, err := broker.Services(ctx)
+	assert.NoError(t, err)
+	for _, service := range services {
+		assert.Contains(t, service.ID, "mongodb-dev-service-")
+		for _, plan := range service.Plans {
+			assert.Contains(t, plan.ID, "mongodb-dev-plan-")
+		}
+	}
+
+	serviceID := "mongodb-dev-service-aws"
+	planID := "mongodb-dev-plan-aws-m10"
+
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		ServiceID: serviceID,
+		PlanID:    planID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "M10", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+
+	instance, err := broker.GetInstance(ctx, instanceID)
+	assert.NoError(t, err)
+	assert.Equal(t, serviceID, instance.ServiceID)
+	assert.Equal(t, planID, instance.PlanID)
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		ServiceID: serviceID,
+		PlanID:    planID,
+	}, false)
+	assert.NoError(t, err)
+
+	// The default IDs aren't known to a broker with another prefix.
+	_, err = broker.Provision(ctx, "default", brokerapi.ProvisionDetails{
+		ServiceID: testServiceID,
+		PlanID:    testPlanID,
+	}, true)
+	assert.Error(t, err)
+	assert.Nil(t, client.Clusters["default"])
+
+	_, err = broker.Bind(ctx, instanceID, "default", brokerapi.BindDetails{
+		ServiceID: testServiceID,
+		PlanID:    testPlanID,
+	}, false)
+	assert.Error(t, err)
+
+	// Catalog overrides reference the prefixed IDs.
+	override := CatalogOverride{Services: map[string]ServiceOverride{testServiceID: {Name: "mongodb"}}}
+	_, err = New(zap.NewNop().Sugar(), WithIDPrefix("mongodb-dev"), WithCatalogOverride(override))
+	assert.Error(t, err)
+
+	override = CatalogOverride{Services: map[string]ServiceOverride{serviceID: {Name: "mongodb"}}}
+	_, err = New(zap.NewNop().Sugar(), WithIDPrefix("mongodb-dev"), WithCatalogOverride(override))
+	assert.NoError(t, err)
+
+	_, err = New(zap.NewNop().Sugar(), WithIDPrefix("mongodb dev"))
+	assert.Error(t, err, "Expected prefixes with spaces to be rejected")
+
+	_, err = New(zap.NewNop().Sugar(), WithIDPrefix(""))
+	assert.Error(t, err, "Expected empty prefixes to be rejected")
+}
+
+func TestDefaultIDCompatibility(t *testing.T) {
+	broker, client, ctx := setupTest(WithIDPrefix("mongodb-dev"), WithDefaultIDCompatibility(true))
+
+	services, err := broker.Services(ctx)
+	assert.NoError(t, err)
+	for _, service := range services {
+		assert.NotContains(t, service.ID, DefaultIDPrefix, "Expected only the new IDs in the catalog")
+	}
+
+	// Instances created with the default IDs keep working.
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		ServiceID: testServiceID,
+		PlanID:    testPlanID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		PreviousValues: brokerapi.PreviousValues{
+			ServiceID: testServiceID,
+			PlanID:    testPlanID,
+		},
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "M20", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+	}, false)
+	assert.NoError(t, err)
+
+	_, err = broker.Unbind(ctx, instanceID, "binding", brokerapi.UnbindDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+	}, false)
+	assert.NoError(t, err)
+
+	// Fetched instances use the new IDs.
+	instance, err := broker.GetInstance(ctx, instanceID)
+	assert.NoError(t, err)
+	assert.Equal(t, "mongodb-dev-plan-aws-m20", instance.PlanID)
+}
+
+func TestCatalogIDsConflict(t *testing.T) {
+	// Instance sizes only differing in case result in the same plan ID.
+	instanceSizeSpecs["m10"] = instanceSizeSpecs["M10"]
+	defer delete(instanceSizeSpecs, "m10")
+
+	_, err := New(zap.NewNop().Sugar())
+	assert.EqualError(t, err, `catalog: plan ID "aosb-cluster-plan-aws-m10" is used more than once by service "aosb-cluster-service-aws"`)
+}
+
+func TestCheckCatalogIDs(t *testing.T) {
+	assert.NoError(t, checkCatalogIDs(staticCatalog(DefaultIDPrefix)))
+
+	services := []brokerapi.Service{
+		{ID: "aws", Plans: []brokerapi.ServicePlan{{ID: "m10"}, {ID: "m20"}}},
+		{ID: "gcp", Plans: []brokerapi.ServicePlan{{ID: "m10"}}},
+	}
+	assert.EqualError(t, checkCatalogIDs(services), `plan ID "m10" is used by both service "aws" and "gcp"`)
+
+	services = []brokerapi.Service{{ID: "aws"}, {ID: "aws"}}
+	assert.EqualError(t, checkCatalogIDs(services), `service ID "aws" is used more than once`)
+}
+
+func TestFindInstanceSizeByPlanIDWithinService(t *testing.T) {
+	_, client, _ := setupTest()
+
+	aws, _ := client.GetProvider(providerNameAWS)
+	gcp, _ := client.GetProvider(providerNameGCP)
+
+	instanceSize, err := findInstanceSizeByPlanID(DefaultIDPrefix, aws, testPlanID, nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "M10", instanceSize.Name)
+	}
+
+	// Plans of other services aren't resolved.
+	_, err = findInstanceSizeByPlanID(DefaultIDPrefix, gcp, testPlanID, nil)
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, "invalid-plan-id", failure.LoggerAction())
+	}
+}
+
+func TestServicesReturnsCopies(t *testing.T) {
+	shareable := true
+	broker, _, ctx := setupTest(
+		WithPlanCosts(map[string][]brokerapi.ServicePlanCost{
+			testPlanID: {{Amount: map[string]float64{"usd": 0.08}, Unit: "HOURLY"}},
+		}),
+		WithCatalogOverride(CatalogOverride{
+			Services: map[string]ServiceOverride{
+				testServiceID: {
+					Metadata: &brokerapi.ServiceMetadata{DisplayName: "AWS", Shareable: &shareable},
+					Plans: map[string]PlanOverride{
+						testPlanID: {Metadata: &brokerapi.ServicePlanMetadata{Bullets: []string{"Fast"}}},
+					},
+				},
+			},
+		}),
+	)
+
+	// The catalog is compared as JSON as the first one would be changed
+	// along with the others if they shared data.
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected, err := json.Marshal(services)
+	assert.NoError(t, err)
+
+	// Change everything a consumer could reach.
+	for i := range services {
+		svc := &services[i]
+		if svc.Metadata != nil {
+			svc.Metadata.DisplayName = "changed"
+			*svc.Metadata.Shareable = false
+		}
+
+		sort.Slice(svc.Plans, func(i, j int) bool { return svc.Plans[i].ID > svc.Plans[j].ID })
+
+		for j := range svc.Plans {
+			plan := &svc.Plans[j]
+			plan.Name = "changed"
+
+			if plan.Metadata != nil {
+				plan.Metadata.DisplayName = "changed"
+				for k := range plan.Metadata.Bullets {
+					plan.Metadata.Bullets[k] = "changed"
+				}
+				for _, cost := range plan.Metadata.Costs {
+					cost.Amount["usd"] = 100
+				}
+			}
+
+			if plan.Schemas != nil {
+				parameters := plan.Schemas.Instance.Create.Parameters
+				parameters["properties"].(map[string]interface{})["cluster"] = "changed"
+				plan.Schemas.Binding.Create.Parameters["type"] = "changed"
+			}
+		}
+	}
+
+	services, err = broker.Services(ctx)
+	assert.NoError(t, err)
+
+	actual, err := json.Marshal(services)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual), "Expected changes to a catalog not to affect later ones")
+}
+
+func TestServicesConcurrent(t *testing.T) {
+	broker, _, ctx := setupTest(WithPlanCosts(map[string][]brokerapi.ServicePlanCost{
+		testPlanID: {{Amount: map[string]float64{"usd": 0.08}, Unit: "HOURLY"}},
+	}))
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected, err := json.Marshal(services)
+	assert.NoError(t, err)
+
+	// Run with -race to catch callers sharing catalog data.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			services, err := broker.Services(ctx)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			for _, svc := range services {
+				for _, plan := range svc.Plans {
+					plan.Schemas.Instance.Create.Parameters["type"] = "changed"
+					if plan.Metadata != nil && plan.Metadata.Costs != nil {
+						plan.Metadata.Costs[0].Amount["usd"] = 100
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	services, err = broker.Services(ctx)
+	assert.NoError(t, err)
+
+	actual, err := json.Marshal(services)
+	assert.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(actual))
+}
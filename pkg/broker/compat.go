@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// The compatibility shims which let the broker handle instances and
+// operations started by older versions. They're listed at startup.
+const (
+	// ShimPlainOperationData accepts the plain operation names used as
+	// operation data before OperationData, regardless of case and
+	// surrounding whitespace.
+	ShimPlainOperationData = "plain-operation-data"
+
+	// ShimInferredOperation derives the operation from the cluster state for
+	// polls without operation data, which platforms send for operations
+	// started before the broker returned any.
+	ShimInferredOperation = "inferred-operation"
+
+	// ShimLegacyClusterNames looks up clusters using the truncated instance
+	// ID when a cluster name template is configured, as clusters created
+	// before the template was introduced are named that way.
+	ShimLegacyClusterNames = "legacy-cluster-names"
+
+	// ShimPoolLabelLookup finds clusters claimed from a warm pool through
+	// their instance ID label.
+	ShimPoolLabelLookup = "pool-label-lookup"
+)
+
+// CompatibilityShims returns the compatibility shims active for the broker's
+// configuration.
+func (b Broker) CompatibilityShims() []string {
+	shims := []string{ShimPlainOperationData, ShimInferredOperation}
+
+	if b.namer.template != nil {
+		shims = append(shims, ShimLegacyClusterNames)
+	}
+
+	if b.pool != nil {
+		shims = append(shims, ShimPoolLabelLookup)
+	}
+
+	return shims
+}
+
+// instanceCluster fetches the cluster backing an instance. If it isn't found
+// under the name derived by the namer the compatibility lookups are tried:
+// clusters created before a cluster name template was configured use the
+// truncated instance ID, and clusters claimed from a warm pool keep their
+// pool name and are found through their instance ID label.
+func (b Broker) instanceCluster(client atlas.Client, instanceID string) (*atlas.Cluster, error) {
+	cluster, err := client.GetCluster(b.namer.ClusterName(instanceID))
+	if err != atlas.ErrClusterNotFound {
+		return cluster, err
+	}
+
+	if b.namer.template != nil {
+		legacy, err := client.GetCluster(Namer{}.ClusterName(instanceID))
+		if err != nil && err != atlas.ErrClusterNotFound {
+			return nil, err
+		}
+
+		// Labeled clusters belong to whichever instance they name.
+		if legacy != nil && (Namer{}).Matches(legacy, instanceID) {
+			return legacy, nil
+		}
+	}
+
+	if b.pool == nil {
+		return nil, atlas.ErrClusterNotFound
+	}
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range clusters {
+		if labelValue(clusters[i].Labels, LabelInstanceID) == instanceID {
+			return &clusters[i], nil
+		}
+	}
+
+	return nil, atlas.ErrClusterNotFound
+}
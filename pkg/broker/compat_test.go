@@ -0,0 +1,83 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestCompatibilityShims(t *testing.T) {
+	broker, _, _ := setupTest()
+	assert.Equal(t, []string{ShimPlainOperationData, ShimInferredOperation}, broker.CompatibilityShims())
+
+	broker, _, _ = setupTest(WithClusterNameTemplate("prod-{{.InstanceID}}"), WithPools(testPool))
+	assert.Equal(t, []string{ShimPlainOperationData, ShimInferredOperation, ShimLegacyClusterNames, ShimPoolLabelLookup}, broker.CompatibilityShims())
+}
+
+// TestUpgradeSimulation starts operations with a broker configured like an
+// older version and polls them with a newer configuration.
+func TestUpgradeSimulation(t *testing.T) {
+	oldBroker, client, ctx := setupTest()
+
+	instanceID := "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+	_, err := oldBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Clusters created by older versions didn't have any labels.
+	legacyName := "aaaaaaaa-bbbb-cccc-dddd"
+	client.Clusters[legacyName].Labels = nil
+
+	newBroker := NewBroker(zap.NewNop().Sugar(), WithClusterNameTemplate("prod-{{.InstanceID}}"))
+
+	// The provision is still in progress, the platform may not have any
+	// operation data.
+	for _, data := range []string{OperationProvision, ""} {
+		resp, err := newBroker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: data})
+		assert.NoError(t, err)
+		assert.Equal(t, brokerapi.InProgress, resp.State, "Operation data %q", data)
+	}
+
+	client.SetClusterState(legacyName, atlas.ClusterStateIdle)
+	resp, err := newBroker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: OperationProvision})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.Succeeded, resp.State)
+
+	// Further operations find the legacy cluster.
+	_, err = newBroker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = newBroker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, client.Clusters[legacyName])
+
+	resp, err = newBroker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: OperationDeprovision})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.Succeeded, resp.State)
+}
+
+func TestLegacyClusterNameOtherInstance(t *testing.T) {
+	broker, client, _ := setupTest(WithClusterNameTemplate("prod-{{.InstanceID}}"))
+
+	// A labeled cluster under the legacy name belongs to another instance.
+	client.Clusters["aaaaaaaa-bbbb-cccc-dddd"] = &atlas.Cluster{
+		Name:   "aaaaaaaa-bbbb-cccc-dddd",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "aaaaaaaa-bbbb-cccc-dddd-ffffffffffff"}},
+	}
+
+	_, err := broker.instanceCluster(client, "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee")
+	assert.Equal(t, atlas.ErrClusterNotFound, err)
+}
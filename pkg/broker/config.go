@@ -0,0 +1,191 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// Config holds the broker settings which can be read from a JSON
+// configuration file. Every setting maps onto one of the Options.
+type Config struct {
+	// ClusterDefaults are applied to every new cluster, see
+	// WithClusterDefaults.
+	ClusterDefaults *atlas.Cluster `json:"clusterDefaults,omitempty"`
+
+	// EnforcedClusterSettings are applied to every cluster and can't be
+	// changed by users, see WithEnforcedClusterSettings.
+	EnforcedClusterSettings map[string]interface{} `json:"enforcedClusterSettings,omitempty"`
+
+	// AllowedConnectionStringOptions limits the connection string options
+	// users can set, see WithAllowedConnectionStringOptions.
+	AllowedConnectionStringOptions []string `json:"allowedConnectionStringOptions,omitempty"`
+
+	// DefaultAppName controls the appName of generated connection strings,
+	// see WithDefaultAppName.
+	DefaultAppName *bool `json:"defaultAppName,omitempty"`
+
+	// AppNameTemplate derives the appName of generated connection strings,
+	// see WithAppNameTemplate.
+	AppNameTemplate string `json:"appNameTemplate,omitempty"`
+
+	// CredentialTemplates render additional binding credentials per plan,
+	// see WithCredentialTemplates.
+	CredentialTemplates map[string]map[string]string `json:"credentialTemplates,omitempty"`
+
+	// CredentialAliases render additional binding credentials for every
+	// plan, see WithCredentialAliases.
+	CredentialAliases map[string]string `json:"credentialAliases,omitempty"`
+
+	// DefaultUserRoles are granted to binding users which don't specify any
+	// roles, see WithDefaultUserRoles.
+	DefaultUserRoles []atlas.Role `json:"defaultUserRoles,omitempty"`
+
+	// RolePolicy restricts the databases binding users can be granted roles
+	// on, see WithRolePolicy.
+	RolePolicy *RolePolicy `json:"rolePolicy,omitempty"`
+
+	// Quotas limit the instances of platform organizations and spaces, see
+	// WithQuotas.
+	Quotas []QuotaRule `json:"quotas,omitempty"`
+
+	// PlanCosts are shown in the catalog metadata of plans, see
+	// WithPlanCosts.
+	PlanCosts map[string][]brokerapi.ServicePlanCost `json:"planCosts,omitempty"`
+
+	// MaxPlanCost is the highest monthly cost of the plans offered, see
+	// WithMaxPlanCost.
+	MaxPlanCost *MaxPlanCost `json:"maxPlanCost,omitempty"`
+
+	// Pools keep pre-created clusters for instant provisioning, see
+	// WithPools.
+	Pools []PoolConfig `json:"pools,omitempty"`
+
+	// Features enables or disables features by name, see WithFeatures.
+	Features map[string]bool `json:"features,omitempty"`
+
+	// DurationEstimates are shown while instance operations are in
+	// progress, see WithDurationEstimates.
+	DurationEstimates DurationEstimates `json:"durationEstimates,omitempty"`
+}
+
+// ReadConfigFile reads and validates a configuration file. Unknown settings
+// are rejected to catch typos early.
+func ReadConfigFile(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	// Unknown cluster fields aren't caught above as atlas.Cluster has its own
+	// lenient decoder.
+	var raw struct {
+		ClusterDefaults json.RawMessage `json:"clusterDefaults"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	if len(raw.ClusterDefaults) > 0 {
+		if err := decodeClusterStrict(raw.ClusterDefaults, &atlas.Cluster{}); err != nil {
+			return nil, fmt.Errorf("invalid config file %s: clusterDefaults: %v", path, err)
+		}
+	}
+
+	// Validate the settings by applying them to an empty broker.
+	if err := config.apply(&Broker{}); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// Options returns the broker options corresponding to the configuration.
+func (c *Config) Options() []Option {
+	opts := []Option{}
+
+	if c.ClusterDefaults != nil {
+		opts = append(opts, WithClusterDefaults(*c.ClusterDefaults))
+	}
+
+	if c.EnforcedClusterSettings != nil {
+		opts = append(opts, WithEnforcedClusterSettings(c.EnforcedClusterSettings))
+	}
+
+	if c.AllowedConnectionStringOptions != nil {
+		opts = append(opts, WithAllowedConnectionStringOptions(c.AllowedConnectionStringOptions...))
+	}
+
+	if c.DefaultAppName != nil {
+		opts = append(opts, WithDefaultAppName(*c.DefaultAppName))
+	}
+
+	if c.AppNameTemplate != "" {
+		opts = append(opts, WithAppNameTemplate(c.AppNameTemplate))
+	}
+
+	if c.CredentialTemplates != nil {
+		opts = append(opts, WithCredentialTemplates(c.CredentialTemplates))
+	}
+
+	if c.CredentialAliases != nil {
+		opts = append(opts, WithCredentialAliases(c.CredentialAliases))
+	}
+
+	if c.DefaultUserRoles != nil {
+		opts = append(opts, WithDefaultUserRoles(c.DefaultUserRoles...))
+	}
+
+	if c.RolePolicy != nil {
+		opts = append(opts, WithRolePolicy(*c.RolePolicy))
+	}
+
+	if c.Quotas != nil {
+		opts = append(opts, WithQuotas(c.Quotas...))
+	}
+
+	if c.PlanCosts != nil {
+		opts = append(opts, WithPlanCosts(c.PlanCosts))
+	}
+
+	if c.MaxPlanCost != nil {
+		opts = append(opts, WithMaxPlanCost(*c.MaxPlanCost))
+	}
+
+	if c.Pools != nil {
+		opts = append(opts, WithPools(c.Pools...))
+	}
+
+	if c.DurationEstimates != nil {
+		opts = append(opts, WithDurationEstimates(c.DurationEstimates))
+	}
+
+	if c.Features != nil {
+		opts = append(opts, WithFeatures(c.Features))
+	}
+
+	return opts
+}
+
+// apply applies all options of the configuration to a broker.
+func (c *Config) apply(b *Broker) error {
+	for _, opt := range c.Options() {
+		if err := opt(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,74 @@
+package broker
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// writeConfigFile writes a temporary config file and returns its path.
+func writeConfigFile(t *testing.T, contents string) string {
+	file, err := ioutil.TempFile("", "config-*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(contents); err != nil {
+		t.Fatal(err)
+	}
+
+	return file.Name()
+}
+
+func TestReadConfigFile(t *testing.T) {
+	path := writeConfigFile(t, `{
+		"clusterDefaults": {
+			"mongoDBMajorVersion": "4.2"
+		},
+		"enforcedClusterSettings": {
+			"providerBackupEnabled": true
+		}
+	}`)
+	defer os.Remove(path)
+
+	config, err := ReadConfigFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, &atlas.Cluster{MongoDBMajorVersion: "4.2"}, config.ClusterDefaults)
+	assert.Equal(t, map[string]interface{}{"providerBackupEnabled": true}, config.EnforcedClusterSettings)
+
+	broker, err := New(zap.NewNop().Sugar(), config.Options()...)
+	assert.NoError(t, err)
+	assert.Equal(t, config.ClusterDefaults, broker.clusterDefaults)
+	assert.Equal(t, config.EnforcedClusterSettings, broker.enforcedClusterSettings)
+}
+
+func TestReadConfigFileInvalid(t *testing.T) {
+	invalidConfigs := []string{
+		`{"unknown": true}`,
+		`{"clusterDefaults": {"minimumEnabledTlsProtocol": "TLS1_2"}}`,
+		`{"enforcedClusterSettings": {"providerSettings": {"providerName": "GCP"}}}`,
+		`{"credentialTemplates": {"aosb-cluster-plan-aws-m10": {"jdbcUrl": "{{.Unknown}}"}}}`,
+		`{"credentialAliases": {"password": "uri"}}`,
+		`{"rolePolicy": {"deniedDatabases": [""]}}`,
+		`not json`,
+	}
+
+	for _, contents := range invalidConfigs {
+		path := writeConfigFile(t, contents)
+		defer os.Remove(path)
+
+		_, err := ReadConfigFile(path)
+		assert.Errorf(t, err, "Expected config %s to be rejected", contents)
+	}
+
+	_, err := ReadConfigFile("/nonexistent/config.json")
+	assert.Error(t, err)
+}
@@ -0,0 +1,914 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode/utf8"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// The connection string formats which can be requested for a binding.
+const (
+	// ConnectionStringFormatSRV uses the DNS seed list format
+	// (mongodb+srv://) based on the cluster's SRV address.
+	ConnectionStringFormatSRV = "srv"
+
+	// ConnectionStringFormatStandard lists all hosts of the cluster
+	// (mongodb://) and works with drivers which don't support SRV.
+	ConnectionStringFormatStandard = "standard"
+)
+
+// The endpoints a connection string can go through.
+const (
+	// ConnectionStringEndpointPublic uses the public addresses of the
+	// cluster.
+	ConnectionStringEndpointPublic = "public"
+
+	// ConnectionStringEndpointPrivate uses the addresses of a private
+	// endpoint of the cluster, for example AWS PrivateLink.
+	ConnectionStringEndpointPrivate = "private"
+)
+
+// ConnectionStringTargetAnalytics can be passed as connectionString.target to
+// read from the analytics nodes of a cluster.
+const ConnectionStringTargetAnalytics = "analytics"
+
+// analyticsOptions route reads to the analytics nodes, which are secondaries
+// tagged with nodeType:ANALYTICS.
+var analyticsOptions = map[string]string{
+	"readPreference":     "secondary",
+	"readPreferenceTags": "nodeType:ANALYTICS",
+}
+
+// connectionStringOption describes how an option of the connection string
+// takes its values.
+type connectionStringOption struct {
+	// integer options reject numbers with a fraction. Numeric values of w
+	// are a number of nodes, string values name a write concern.
+	integer bool
+
+	// repeatable options may appear more than once in a connection string,
+	// their values can be passed as arrays.
+	repeatable bool
+
+	// tagSets options also take tag sets, which can be passed as objects.
+	// Drivers try each readPreferenceTags tag set in order.
+	tagSets bool
+}
+
+// connectionStringOptions are the options of the MongoDB connection string
+// specification, keyed by their canonical spelling. Drivers either reject
+// other options or ignore them, which hides typos until apps connect.
+var connectionStringOptions = map[string]connectionStringOption{
+	"appName":                              {},
+	"authMechanism":                        {},
+	"authMechanismProperties":              {},
+	"authSource":                           {},
+	"compressors":                          {},
+	"connectTimeoutMS":                     {integer: true},
+	"directConnection":                     {},
+	"gssapiServiceName":                    {},
+	"heartbeatFrequencyMS":                 {integer: true},
+	"journal":                              {},
+	"loadBalanced":                         {},
+	"localThresholdMS":                     {integer: true},
+	"maxConnecting":                        {integer: true},
+	"maxIdleTimeMS":                        {integer: true},
+	"maxPoolSize":                          {integer: true},
+	"maxStalenessSeconds":                  {integer: true},
+	"minPoolSize":                          {integer: true},
+	"proxyHost":                            {},
+	"proxyPassword":                        {},
+	"proxyPort":                            {integer: true},
+	"proxyUsername":                        {},
+	"readConcernLevel":                     {},
+	"readPreference":                       {},
+	"readPreferenceTags":                   {repeatable: true, tagSets: true},
+	"replicaSet":                           {},
+	"retryReads":                           {},
+	"retryWrites":                          {},
+	"serverSelectionTimeoutMS":             {integer: true},
+	"serverSelectionTryOnce":               {},
+	"socketTimeoutMS":                      {integer: true},
+	"srvMaxHosts":                          {integer: true},
+	"srvServiceName":                       {},
+	"ssl":                                  {},
+	"timeoutMS":                            {integer: true},
+	"tls":                                  {},
+	"tlsAllowInvalidCertificates":          {},
+	"tlsAllowInvalidHostnames":             {},
+	"tlsCAFile":                            {},
+	"tlsCertificateKeyFile":                {},
+	"tlsCertificateKeyFilePassword":        {},
+	"tlsDisableCertificateRevocationCheck": {},
+	"tlsDisableOCSPEndpointCheck":          {},
+	"tlsInsecure":                          {},
+	"uuidRepresentation":                   {},
+	"w":                                    {integer: true},
+	"waitQueueMultiple":                    {integer: true},
+	"waitQueueTimeoutMS":                   {integer: true},
+	"wTimeoutMS":                           {integer: true},
+	"zlibCompressionLevel":                 {integer: true},
+}
+
+// lookupConnectionStringOption returns the canonical spelling of an option
+// and how it takes its values, ignoring case. The last value is false for
+// options which aren't in the specification.
+func lookupConnectionStringOption(key string) (string, connectionStringOption, bool) {
+	if option, ok := connectionStringOptions[key]; ok {
+		return key, option, true
+	}
+
+	for name, option := range connectionStringOptions {
+		if strings.EqualFold(name, key) {
+			return name, option, true
+		}
+	}
+
+	return key, connectionStringOption{}, false
+}
+
+// replicaSetOption names the replica set to connect to. The hosts of sharded
+// clusters are mongos routers, which don't belong to a replica set.
+const replicaSetOption = "replicaSet"
+
+// defaultMongoDBPort is the port of hosts listed without one.
+const defaultMongoDBPort = "27017"
+
+// connectionStringFormatAliases maps every accepted value of
+// connectionString.format onto the format it stands for.
+var connectionStringFormatAliases = map[string]string{
+	"srv":         ConnectionStringFormatSRV,
+	"standardSrv": ConnectionStringFormatSRV,
+	"mongodb+srv": ConnectionStringFormatSRV,
+	"standard":    ConnectionStringFormatStandard,
+	"mongodb":     ConnectionStringFormatStandard,
+}
+
+// ConnectionStringParams are the binding parameters passed as
+// "connectionString" which control the generated connection string.
+type ConnectionStringParams struct {
+	// Format is one of the ConnectionStringFormat constants. Aliases are
+	// normalized during parsing.
+	Format string `json:"format,omitempty" description:"One of standardSrv, srv or mongodb+srv for the SRV format, or standard or mongodb for the standard format."`
+
+	// EndpointType is one of the ConnectionStringEndpoint constants. If
+	// empty, private endpoints are only used by clusters without public
+	// addresses.
+	EndpointType string `json:"endpointType,omitempty" description:"One of public or private. Private connects through a private endpoint of the cluster such as AWS PrivateLink."`
+
+	// EndpointID selects the private endpoint of clusters with several, the
+	// first one is used if it's empty.
+	EndpointID string `json:"endpointId,omitempty" description:"ID of the private endpoint to connect through if the cluster has several. Requires endpointType private."`
+
+	// Target selects the nodes the connection string reads from, the
+	// primary if empty. Options set by the target are overridden by Options.
+	Target string `json:"target,omitempty" description:"Set to analytics to read from the analytics nodes of the cluster."`
+
+	// Options are added to the query string of the connection string,
+	// overriding the options provided by Atlas. Parsing normalizes the
+	// keys to their canonical spelling.
+	Options map[string]interface{} `json:"options,omitempty" description:"Options added to the query string of the connection string, overriding the defaults appName, authSource and, for the standardSrv format, retryWrites=true. Set an option to an empty string to remove it. readPreferenceTags takes an array of tag sets."`
+
+	// AllowUnknownOptions accepts options which aren't part of the
+	// connection string specification, for drivers which add their own.
+	AllowUnknownOptions bool `json:"allowUnknownOptions,omitempty" description:"Set to true to pass options which aren't part of the MongoDB connection string specification."`
+
+	// SkipCredentials leaves the username, password and authSource out of
+	// the connection string for apps which configure them separately.
+	SkipCredentials bool `json:"skipCredentials,omitempty" description:"Set to true to leave the username, password and default authSource out of the connection string, for apps which pass the credentials to the driver separately."`
+}
+
+// connectionStringParamsFromParams extracts the connection string params from
+// raw bind parameters. Nil is returned if none were passed in which case the
+// plain SRV address of the cluster is used.
+func connectionStringParamsFromParams(rawParams []byte) (*ConnectionStringParams, error) {
+	params := struct {
+		ConnectionString *ConnectionStringParams `json:"connectionString"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	csParams := params.ConnectionString
+	if csParams == nil {
+		return nil, nil
+	}
+
+	verr := &ValidationError{}
+
+	if csParams.Format == "" {
+		csParams.Format = ConnectionStringFormatSRV
+	} else if format, ok := connectionStringFormatAliases[csParams.Format]; ok {
+		csParams.Format = format
+	} else {
+		verr.add("connectionString.format", "must be one of %s", quotedList(connectionStringFormatNames()))
+	}
+
+	switch csParams.EndpointType {
+	case "", ConnectionStringEndpointPublic, ConnectionStringEndpointPrivate:
+	default:
+		verr.add("connectionString.endpointType", "must be %s", quotedList([]string{ConnectionStringEndpointPublic, ConnectionStringEndpointPrivate}))
+	}
+
+	if csParams.EndpointID != "" && csParams.EndpointType != ConnectionStringEndpointPrivate {
+		verr.add("connectionString.endpointId", `requires endpointType "%s"`, ConnectionStringEndpointPrivate)
+	}
+
+	if csParams.Target != "" && csParams.Target != ConnectionStringTargetAnalytics {
+		verr.add("connectionString.target", "must be %s", quotedList([]string{ConnectionStringTargetAnalytics}))
+	}
+
+	if csParams.Options != nil {
+		csParams.Options = normalizeOptions(csParams.Options, csParams.AllowUnknownOptions, verr)
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	return csParams, nil
+}
+
+// normalizeOptions returns connection string options keyed by their canonical
+// spelling and adds a violation for each option whose value is invalid, which
+// is unknown unless allowUnknown is set, or which is set more than once.
+func normalizeOptions(options map[string]interface{}, allowUnknown bool, verr *ValidationError) map[string]interface{} {
+	normalized := make(map[string]interface{}, len(options))
+	keys := map[string]string{}
+
+	for _, key := range sortedKeys(options) {
+		field := "connectionString.options." + key
+
+		name, _, known := lookupConnectionStringOption(key)
+		if !known && !allowUnknown {
+			verr.add(field, "is not a known connection string option")
+			continue
+		}
+
+		if other, ok := keys[name]; ok {
+			verr.add(field, `is the same option as "%s"`, other)
+			continue
+		}
+		keys[name] = key
+
+		if _, err := formatOptionValues(name, options[key]); err != nil {
+			verr.add(field, "%s", err.Error())
+		}
+		normalized[name] = options[key]
+	}
+
+	return normalized
+}
+
+// connectionStringFormatNames returns all accepted format values in
+// alphabetical order.
+func connectionStringFormatNames() []string {
+	names := make([]string, 0, len(connectionStringFormatAliases))
+	for name := range connectionStringFormatAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// DefaultAllowedConnectionStringOptions are the options users may set through
+// connectionString.options unless configured otherwise. They can't weaken the
+// security of the connection.
+var DefaultAllowedConnectionStringOptions = []string{
+	"appName",
+	"authSource",
+	"maxPoolSize",
+	"readPreference",
+	"retryWrites",
+	"w",
+}
+
+// AllowAllConnectionStringOptions can be used as the allow-list to accept any
+// connection string option.
+const AllowAllConnectionStringOptions = "*"
+
+// privateEndpointStrings returns the connection strings of the private
+// endpoint of a cluster with an endpoint ID, or of the first one if it's
+// empty. It's nil if the cluster has no such private endpoint.
+func privateEndpointStrings(cluster *atlas.Cluster, endpointID string) *atlas.PrivateEndpointStrings {
+	if cluster.ConnectionStrings == nil {
+		return nil
+	}
+
+	for i, endpointStrings := range cluster.ConnectionStrings.PrivateEndpoint {
+		if endpointID == "" {
+			return &cluster.ConnectionStrings.PrivateEndpoint[i]
+		}
+
+		for _, endpoint := range endpointStrings.Endpoints {
+			if endpoint.EndpointID == endpointID {
+				return &cluster.ConnectionStrings.PrivateEndpoint[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// endpointCluster returns a cluster with the addresses of the endpoint
+// requested by params, so connection strings and the fields derived from
+// them agree. Private endpoints replace the public addresses in a copy of the
+// cluster. Without an endpoint type they're only used if the cluster has no
+// public addresses. Requesting a private endpoint the cluster doesn't have
+// adds a violation to verr.
+func endpointCluster(cluster *atlas.Cluster, params *ConnectionStringParams, verr *ValidationError) *atlas.Cluster {
+	endpointType, endpointID := "", ""
+	if params != nil {
+		endpointType, endpointID = params.EndpointType, params.EndpointID
+	}
+
+	if endpointType == ConnectionStringEndpointPublic {
+		return cluster
+	}
+
+	private := privateEndpointStrings(cluster, endpointID)
+	switch {
+	case endpointType == "" && (private == nil || cluster.SrvAddress != "" || cluster.MongoURIWithOptions != "" || cluster.MongoURI != ""):
+		return cluster
+	case private == nil && endpointID != "":
+		verr.add("connectionString.endpointId", "doesn't match a private endpoint of the cluster")
+		return cluster
+	case private == nil:
+		verr.add("connectionString.endpointType", "requires a private endpoint, none is configured for the cluster")
+		return cluster
+	}
+
+	addressed := *cluster
+	addressed.SrvAddress = private.SRVConnectionString
+	addressed.MongoURIWithOptions = private.ConnectionString
+	addressed.MongoURI = ""
+
+	return &addressed
+}
+
+// buildConnectionString constructs a connection string for a cluster which
+// includes the passed credentials and options. The addresses of the cluster
+// are used as they are, see endpointCluster for private endpoints. Default options and the
+// options of the target are applied before the options passed by the user,
+// which can remove them by setting them to an empty string. Options which
+// aren't in the allow-list or don't apply to the type of the cluster and
+// targeting analytics nodes of a cluster without any result in a
+// *ValidationError.
+func buildConnectionString(cluster *atlas.Cluster, username string, password string, params *ConnectionStringParams, defaultOptions map[string]string, allowedOptions []string) (string, error) {
+	verr := &ValidationError{}
+	for _, key := range sortedKeys(params.Options) {
+		if !connectionStringOptionAllowed(key, allowedOptions) {
+			verr.add("connectionString.options."+key, "is not allowed, allowed options are %s", quotedList(allowedOptions))
+		}
+	}
+
+	if isShardedClusterType(cluster.ClusterType) {
+		for _, key := range sortedKeys(params.Options) {
+			if strings.EqualFold(key, replicaSetOption) {
+				verr.add("connectionString.options."+key, `is not supported by clusters of type "%s"`, cluster.ClusterType)
+			}
+		}
+	}
+
+	if params.Target == ConnectionStringTargetAnalytics && !hasAnalyticsNodes(cluster) {
+		verr.add("connectionString.target", "requires a cluster with analytics nodes")
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return "", err
+	}
+
+	base := cluster.SrvAddress
+	if params.Format == ConnectionStringFormatStandard {
+		base = cluster.MongoURIWithOptions
+	}
+
+	if base == "" {
+		return "", fmt.Errorf("cluster %s has no %s connection string", cluster.Name, params.Format)
+	}
+
+	uri, err := parseMongoURI(base)
+	if err != nil {
+		return "", err
+	}
+	removeClusterTypeOptions(cluster, uri.Options)
+
+	for key, value := range defaultOptions {
+		uri.Options.Set(key, value)
+	}
+
+	if params.Target == ConnectionStringTargetAnalytics {
+		for key, value := range analyticsOptions {
+			uri.Options.Set(key, value)
+		}
+	}
+
+	for _, key := range sortedKeys(params.Options) {
+		values, err := formatOptionValues(key, params.Options[key])
+		if err != nil {
+			return "", err
+		}
+
+		// Options are case-insensitive, so the user's spelling replaces
+		// any other.
+		deleteOption(uri.Options, key)
+
+		if len(values) > 0 {
+			uri.Options[key] = values
+		}
+	}
+
+	// Credentials in the connection string of the cluster are always
+	// replaced, or removed if the user asked for none.
+	if params.SkipCredentials {
+		username, password = "", ""
+	}
+	uri.Username = username
+	uri.Password = password
+
+	return uri.String(), nil
+}
+
+// connectionStringWithAuth sets the authentication options of a user on a
+// connection string and makes database its default database unless empty.
+// Atlas keeps users in admin, or $external if they authenticate with X.509
+// certificates. A nil user leaves the authentication options as they are.
+func connectionStringWithAuth(s string, database string, user *atlas.User) (string, error) {
+	uri, err := parseMongoURI(s)
+	if err != nil {
+		return "", err
+	}
+
+	if database != "" {
+		uri.Database = database
+	}
+
+	if user == nil {
+		return uri.String(), nil
+	}
+
+	deleteOption(uri.Options, "authSource")
+	uri.Options.Set("authSource", user.AuthDatabase())
+
+	if user.IsX509() {
+		deleteOption(uri.Options, "authMechanism")
+		uri.Options.Set("authMechanism", x509AuthMechanism)
+	}
+
+	return uri.String(), nil
+}
+
+// connectionStringWithAppName sets the appName option of a connection string.
+func connectionStringWithAppName(s string, appName string) (string, error) {
+	uri, err := parseMongoURI(s)
+	if err != nil {
+		return "", err
+	}
+
+	deleteOption(uri.Options, "appName")
+	uri.Options.Set("appName", appName)
+
+	return uri.String(), nil
+}
+
+// clusterAddress holds the parts of the standard connection string of a
+// cluster needed by drivers which are configured with discrete fields rather
+// than a URI.
+type clusterAddress struct {
+	Hosts      []string
+	Port       int
+	ReplicaSet string
+}
+
+// parseClusterAddress returns the address of a cluster taken from its
+// standard connection string. Hosts only keep their port if they don't all
+// listen on the same one. Clusters which don't have a connection string yet
+// have an empty address.
+func parseClusterAddress(cluster *atlas.Cluster) (clusterAddress, error) {
+	address := clusterAddress{}
+
+	standard := cluster.MongoURIWithOptions
+	if standard == "" {
+		standard = cluster.MongoURI
+	}
+
+	if standard == "" {
+		return address, nil
+	}
+
+	parsed, err := parseMongoURI(standard)
+	if err != nil {
+		return address, err
+	}
+	removeClusterTypeOptions(cluster, parsed.Options)
+
+	names := make([]string, len(parsed.Hosts))
+	ports := map[string]bool{}
+	port := ""
+	for i, host := range parsed.Hosts {
+		names[i], port, err = net.SplitHostPort(host)
+		if err != nil {
+			names[i], port = host, defaultMongoDBPort
+		}
+		ports[port] = true
+	}
+
+	address.Hosts = names
+	if len(ports) == 1 {
+		address.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return address, fmt.Errorf("invalid port in connection string: %v", err)
+		}
+	} else {
+		address.Hosts = parsed.Hosts
+	}
+
+	for key := range parsed.Options {
+		if strings.EqualFold(key, replicaSetOption) {
+			address.ReplicaSet = parsed.Options.Get(key)
+		}
+	}
+
+	return address, nil
+}
+
+// removeClusterTypeOptions drops the options which don't apply to the type of
+// a cluster. Standard connection strings of sharded clusters list their mongos
+// routers, so a replicaSet option would keep drivers from connecting.
+func removeClusterTypeOptions(cluster *atlas.Cluster, options url.Values) {
+	if isShardedClusterType(cluster.ClusterType) {
+		deleteOption(options, replicaSetOption)
+	}
+}
+
+// deleteOption removes an option regardless of its case.
+func deleteOption(options url.Values, key string) {
+	for existing := range options {
+		if strings.EqualFold(existing, key) {
+			options.Del(existing)
+		}
+	}
+}
+
+// hasAnalyticsNodes returns whether any region of a cluster has analytics
+// nodes.
+func hasAnalyticsNodes(cluster *atlas.Cluster) bool {
+	for _, spec := range cluster.ReplicationSpecs {
+		for _, region := range spec.RegionsConfig {
+			if region.AnalyticsNodes > 0 {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// defaultAppName derives the appName option for a binding from the first
+// segment of the instance and binding IDs, so connections can be attributed
+// to bindings in Atlas.
+func defaultAppName(instanceID string, bindingID string) string {
+	return shortID(instanceID) + "-" + shortID(bindingID)
+}
+
+// maximumAppNameLength is the longest appName drivers accept, in bytes.
+const maximumAppNameLength = 128
+
+// parseAppNameTemplate parses a text/template used to derive the appName of
+// generated connection strings. It's rendered for a sample binding to reject
+// templates which fail.
+func parseAppNameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("appName").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid appName template: %v", err)
+	}
+
+	if _, err := executeAppNameTemplate(tmpl, sampleTemplateData()); err != nil {
+		return nil, fmt.Errorf("invalid appName template: %v", err)
+	}
+
+	return tmpl, nil
+}
+
+// executeAppNameTemplate renders an appName template and truncates the result
+// to the length accepted by drivers.
+func executeAppNameTemplate(tmpl *template.Template, data UsernameTemplateData) (string, error) {
+	var appName bytes.Buffer
+	if err := tmpl.Execute(&appName, data); err != nil {
+		return "", err
+	}
+
+	name := appName.String()
+	if len(name) > maximumAppNameLength {
+		name = name[:maximumAppNameLength]
+		for !utf8.ValidString(name) {
+			name = name[:len(name)-1]
+		}
+	}
+
+	return name, nil
+}
+
+// appNameForBinding returns the appName added to the connection strings of a
+// binding. Without a template it's derived from the instance and binding IDs.
+func (b Broker) appNameForBinding(instanceID string, bindingID string, rawContext json.RawMessage) (string, error) {
+	if b.appNameTemplate == nil {
+		return defaultAppName(instanceID, bindingID), nil
+	}
+
+	appName, err := executeAppNameTemplate(b.appNameTemplate, bindingTemplateData(instanceID, bindingID, rawContext))
+	if err != nil {
+		return "", fmt.Errorf("failed to render the appName template: %v", err)
+	}
+
+	return appName, nil
+}
+
+// defaultConnectionStringOptions returns the options of a binding's
+// connection string which users can override. Atlas keeps users with
+// passwords in admin, which SRV records point drivers to as well, but
+// connection strings are often rewritten or parsed by other tools. Apps
+// skipping the credentials pass the authSource along with them. Atlas
+// recommends retryable writes, which the SRV format is meant for.
+func (b Broker) defaultConnectionStringOptions(instanceID string, bindingID string, rawContext json.RawMessage, params *ConnectionStringParams, user *atlas.User) (map[string]string, error) {
+	defaultOptions := map[string]string{}
+
+	if b.defaultAppName {
+		appName, err := b.appNameForBinding(instanceID, bindingID, rawContext)
+		if err != nil {
+			return nil, err
+		}
+		defaultOptions["appName"] = appName
+	}
+
+	if includesCredentials(params, user) {
+		defaultOptions["authSource"] = user.AuthDatabase()
+	}
+
+	if params.Format == ConnectionStringFormatSRV {
+		defaultOptions["retryWrites"] = "true"
+	}
+
+	return defaultOptions, nil
+}
+
+// includesCredentials reports whether the connection string of a binding
+// carries the username and password of its user. Users authenticating with
+// certificates have neither.
+func includesCredentials(params *ConnectionStringParams, user *atlas.User) bool {
+	return !params.SkipCredentials && !user.IsX509() && user.Username != ""
+}
+
+// shortID shortens an ID to its first segment, which for UUIDs is unique
+// enough for diagnostics.
+func shortID(id string) string {
+	const maximumLength = 8
+
+	if i := strings.Index(id, "-"); i > 0 {
+		id = id[:i]
+	}
+
+	if len(id) > maximumLength {
+		id = id[:maximumLength]
+	}
+
+	return id
+}
+
+// connectionStringOptionAllowed checks an option against an allow-list.
+// Options are case-insensitive.
+func connectionStringOptionAllowed(key string, allowedOptions []string) bool {
+	for _, allowed := range allowedOptions {
+		if allowed == AllowAllConnectionStringOptions || strings.EqualFold(allowed, key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// mongoURI is a parsed MongoDB connection string. Unlike url.URL it supports
+// multiple comma-separated hosts.
+type mongoURI struct {
+	Scheme   string
+	Username string
+	Password string
+	Hosts    []string
+	Database string
+	Options  url.Values
+}
+
+// parseMongoURI parses a connection string of the form
+// scheme://[username:password@]host1[:port1][,...hostN[:portN]][/[database][?options]].
+func parseMongoURI(s string) (*mongoURI, error) {
+	parts := strings.SplitN(s, "://", 2)
+	if len(parts) != 2 || (parts[0] != "mongodb" && parts[0] != "mongodb+srv") {
+		return nil, fmt.Errorf("invalid connection string %q", s)
+	}
+
+	uri := &mongoURI{Scheme: parts[0]}
+	rest := parts[1]
+
+	query := ""
+	if i := strings.Index(rest, "?"); i >= 0 {
+		rest, query = rest[:i], rest[i+1:]
+	}
+
+	if i := strings.Index(rest, "/"); i >= 0 {
+		rest, uri.Database = rest[:i], rest[i+1:]
+	}
+
+	if i := strings.LastIndex(rest, "@"); i >= 0 {
+		userInfo := rest[:i]
+		rest = rest[i+1:]
+
+		credentials := strings.SplitN(userInfo, ":", 2)
+		username, err := url.PathUnescape(credentials[0])
+		if err != nil {
+			return nil, err
+		}
+		uri.Username = username
+
+		if len(credentials) == 2 {
+			password, err := url.PathUnescape(credentials[1])
+			if err != nil {
+				return nil, err
+			}
+			uri.Password = password
+		}
+	}
+
+	if rest == "" {
+		return nil, fmt.Errorf("connection string %q has no hosts", s)
+	}
+	uri.Hosts = strings.Split(rest, ",")
+	for _, host := range uri.Hosts {
+		if host == "" {
+			return nil, fmt.Errorf("connection string %q has an empty host", s)
+		}
+	}
+
+	options, err := url.ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	uri.Options = options
+
+	return uri, nil
+}
+
+// String assembles the connection string. Credentials are percent-encoded and
+// options are sorted by key.
+func (u *mongoURI) String() string {
+	var b strings.Builder
+
+	b.WriteString(u.Scheme)
+	b.WriteString("://")
+
+	if u.Username != "" {
+		b.WriteString(escapeCredential(u.Username))
+		if u.Password != "" {
+			b.WriteString(":")
+			b.WriteString(escapeCredential(u.Password))
+		}
+		b.WriteString("@")
+	}
+
+	b.WriteString(strings.Join(u.Hosts, ","))
+	b.WriteString("/")
+	b.WriteString(u.Database)
+
+	// Not all drivers decode "+" as a space so it's percent-encoded instead.
+	if len(u.Options) > 0 {
+		b.WriteString("?")
+		b.WriteString(strings.Replace(u.Options.Encode(), "+", "%20", -1))
+	}
+
+	return b.String()
+}
+
+// escapeCredential percent-encodes a username or password so it can be
+// embedded in a connection string.
+func escapeCredential(s string) string {
+	// QueryEscape encodes spaces as "+" which is only valid in query strings.
+	return strings.Replace(url.QueryEscape(s), "+", "%20", -1)
+}
+
+// formatOptionValues converts the JSON value of an option into its
+// connection string values. Arrays are only accepted by repeatable options
+// and set the option once per item. Empty strings and arrays remove the
+// option, which is returned as nil.
+func formatOptionValues(key string, value interface{}) ([]string, error) {
+	items, ok := value.([]interface{})
+	if !ok {
+		formatted, err := formatOptionValue(key, value)
+		if err != nil || formatted == "" {
+			return nil, err
+		}
+
+		return []string{formatted}, nil
+	}
+
+	if _, option, _ := lookupConnectionStringOption(key); !option.repeatable {
+		return nil, errors.New("must be a string, number or boolean")
+	}
+
+	if len(items) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, len(items))
+	for i, item := range items {
+		if _, nested := item.([]interface{}); nested {
+			return nil, fmt.Errorf("item %d must not be an array", i)
+		}
+
+		formatted, err := formatOptionValue(key, item)
+		if err != nil {
+			return nil, fmt.Errorf("item %d %s", i, err)
+		}
+		values[i] = formatted
+	}
+
+	return values, nil
+}
+
+// formatOptionValue converts a single JSON option value into its connection
+// string representation. Numbers keep their fraction unless the option only
+// accepts integers. Tag sets are formatted as "name:value" pairs sorted by
+// name, an empty one matches any node.
+func formatOptionValue(key string, value interface{}) (string, error) {
+	_, option, _ := lookupConnectionStringOption(key)
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case float64:
+		if option.integer && v != math.Trunc(v) {
+			return "", errors.New("must be an integer")
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case map[string]interface{}:
+		if !option.tagSets {
+			break
+		}
+
+		tags := make([]string, 0, len(v))
+		for _, name := range sortedKeys(v) {
+			tag, ok := v[name].(string)
+			if !ok {
+				return "", fmt.Errorf("tag %s must be a string", name)
+			}
+			tags = append(tags, name+":"+tag)
+		}
+		return strings.Join(tags, ","), nil
+	}
+
+	if option.tagSets {
+		return "", errors.New("must be a string, tag set or an array of them")
+	}
+
+	return "", errors.New("must be a string, number or boolean")
+}
+
+// sortedKeys returns the keys of a map in alphabetical order.
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
+
+// quotedList formats a list of values as `"a", "b" or "c"`.
+func quotedList(values []string) string {
+	quoted := make([]string, len(values))
+	for i, value := range values {
+		quoted[i] = strconv.Quote(value)
+	}
+
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+
+	return strings.Join(quoted[:len(quoted)-1], ", ") + " or " + quoted[len(quoted)-1]
+}
@@ -0,0 +1,275 @@
+package broker
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/network/connstring"
+)
+
+// CredentialStoreKeySize is the size of the key encrypting stored
+// credentials, which selects AES-256.
+const CredentialStoreKeySize = 32
+
+// Defaults of the MongoDB credential store used if the URI doesn't name a
+// database.
+const (
+	DefaultCredentialStoreDatabase   = "atlas-service-broker"
+	DefaultCredentialStoreCollection = "bindings"
+)
+
+// ErrCredentialsNotFound is returned by CredentialStores which don't have a
+// record of a binding.
+var ErrCredentialsNotFound = errors.New("credentials not found")
+
+// CredentialStore keeps the credentials of bindings so GetBinding can return
+// them, Atlas never returns the password of a database user again. Records
+// are encrypted by the broker before they are passed to the store.
+type CredentialStore interface {
+	// Put stores the record of a binding, replacing an existing one.
+	Put(ctx context.Context, instanceID string, bindingID string, record []byte) error
+
+	// Get returns the record of a binding or ErrCredentialsNotFound.
+	Get(ctx context.Context, instanceID string, bindingID string) ([]byte, error)
+
+	// Delete removes the record of a binding. Deleting a record which
+	// doesn't exist isn't an error.
+	Delete(ctx context.Context, instanceID string, bindingID string) error
+}
+
+// MemoryCredentialStore keeps credentials in memory. They are lost when the
+// broker restarts, so it's meant for tests and single short-lived brokers.
+type MemoryCredentialStore struct {
+	mu      sync.Mutex
+	records map[string][]byte
+}
+
+// NewMemoryCredentialStore creates an empty in-memory credential store.
+func NewMemoryCredentialStore() *MemoryCredentialStore {
+	return &MemoryCredentialStore{records: map[string][]byte{}}
+}
+
+// Put stores the record of a binding.
+func (s *MemoryCredentialStore) Put(ctx context.Context, instanceID string, bindingID string, record []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.records[credentialRecordID(instanceID, bindingID)] = append([]byte(nil), record...)
+	return nil
+}
+
+// Get returns the record of a binding.
+func (s *MemoryCredentialStore) Get(ctx context.Context, instanceID string, bindingID string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[credentialRecordID(instanceID, bindingID)]
+	if !ok {
+		return nil, ErrCredentialsNotFound
+	}
+
+	return append([]byte(nil), record...), nil
+}
+
+// Delete removes the record of a binding.
+func (s *MemoryCredentialStore) Delete(ctx context.Context, instanceID string, bindingID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.records, credentialRecordID(instanceID, bindingID))
+	return nil
+}
+
+// MongoCredentialStore keeps credentials in a MongoDB collection, one
+// document per binding.
+type MongoCredentialStore struct {
+	collection *mongo.Collection
+}
+
+// credentialDocument is the document of a binding in a MongoCredentialStore.
+type credentialDocument struct {
+	ID         string `bson:"_id"`
+	InstanceID string `bson:"instanceId"`
+	BindingID  string `bson:"bindingId"`
+	Record     []byte `bson:"record"`
+}
+
+// NewMongoCredentialStore creates a credential store keeping its records in
+// a collection.
+func NewMongoCredentialStore(collection *mongo.Collection) *MongoCredentialStore {
+	return &MongoCredentialStore{collection: collection}
+}
+
+// ConnectMongoCredentialStore connects to the deployment of a MongoDB URI and
+// keeps the records in the database named by the URI, or
+// DefaultCredentialStoreDatabase if there is none.
+func ConnectMongoCredentialStore(ctx context.Context, uri string) (*MongoCredentialStore, error) {
+	parsed, err := connstring.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+
+	database := parsed.Database
+	if database == "" {
+		database = DefaultCredentialStoreDatabase
+	}
+
+	client, err := mongo.NewClient(options.Client().ApplyURI(uri))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := client.Connect(ctx); err != nil {
+		return nil, err
+	}
+
+	return NewMongoCredentialStore(client.Database(database).Collection(DefaultCredentialStoreCollection)), nil
+}
+
+// Put stores the record of a binding.
+func (s *MongoCredentialStore) Put(ctx context.Context, instanceID string, bindingID string, record []byte) error {
+	id := credentialRecordID(instanceID, bindingID)
+	_, err := s.collection.ReplaceOne(ctx, bson.D{{Key: "_id", Value: id}}, credentialDocument{
+		ID:         id,
+		InstanceID: instanceID,
+		BindingID:  bindingID,
+		Record:     record,
+	}, options.Replace().SetUpsert(true))
+	return err
+}
+
+// Get returns the record of a binding.
+func (s *MongoCredentialStore) Get(ctx context.Context, instanceID string, bindingID string) ([]byte, error) {
+	var document credentialDocument
+	err := s.collection.FindOne(ctx, bson.D{{Key: "_id", Value: credentialRecordID(instanceID, bindingID)}}).Decode(&document)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrCredentialsNotFound
+	}
+
+	return document.Record, err
+}
+
+// Delete removes the record of a binding.
+func (s *MongoCredentialStore) Delete(ctx context.Context, instanceID string, bindingID string) error {
+	_, err := s.collection.DeleteOne(ctx, bson.D{{Key: "_id", Value: credentialRecordID(instanceID, bindingID)}})
+	return err
+}
+
+// credentialRecordID identifies the record of a binding.
+func credentialRecordID(instanceID string, bindingID string) string {
+	return instanceID + "/" + bindingID
+}
+
+// credentialCipher encrypts the credentials of bindings with AES-GCM. The
+// binding is authenticated along with the credentials so records can't be
+// moved to another binding.
+type credentialCipher struct {
+	aead cipher.AEAD
+}
+
+func newCredentialCipher(key []byte) (*credentialCipher, error) {
+	if len(key) != CredentialStoreKeySize {
+		return nil, fmt.Errorf("the credential store key must be %d bytes long", CredentialStoreKeySize)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credentialCipher{aead: aead}, nil
+}
+
+// seal encrypts a record, the random nonce is prepended.
+func (c *credentialCipher) seal(instanceID string, bindingID string, plaintext []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, []byte(credentialRecordID(instanceID, bindingID))), nil
+}
+
+// open decrypts a record encrypted by seal.
+func (c *credentialCipher) open(instanceID string, bindingID string, record []byte) ([]byte, error) {
+	if len(record) < c.aead.NonceSize() {
+		return nil, errors.New("stored credentials are truncated")
+	}
+
+	nonce, ciphertext := record[:c.aead.NonceSize()], record[c.aead.NonceSize():]
+	return c.aead.Open(nil, nonce, ciphertext, []byte(credentialRecordID(instanceID, bindingID)))
+}
+
+// storeCredentials keeps the credentials returned by a bind if a credential
+// store is configured.
+func (b Broker) storeCredentials(ctx context.Context, instanceID string, bindingID string, credentials interface{}) error {
+	if b.credentialStore == nil {
+		return nil
+	}
+
+	plaintext, err := json.Marshal(credentials)
+	if err != nil {
+		return err
+	}
+
+	record, err := b.credentialCipher.seal(instanceID, bindingID, plaintext)
+	if err != nil {
+		return err
+	}
+
+	if err := b.credentialStore.Put(ctx, instanceID, bindingID, record); err != nil {
+		b.logger.Errorw("Failed to store binding credentials", "error", err)
+		return err
+	}
+
+	return nil
+}
+
+// storedCredentials returns the credentials kept for a binding.
+// ErrCredentialsNotFound is returned if there are none.
+func (b Broker) storedCredentials(ctx context.Context, instanceID string, bindingID string) (json.RawMessage, error) {
+	record, err := b.credentialStore.Get(ctx, instanceID, bindingID)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := b.credentialCipher.open(instanceID, bindingID, record)
+	if err != nil {
+		return nil, fmt.Errorf("stored credentials can't be decrypted: %v", err)
+	}
+
+	return json.RawMessage(plaintext), nil
+}
+
+// deleteStoredCredentials removes the credentials kept for a binding if a
+// credential store is configured.
+func (b Broker) deleteStoredCredentials(ctx context.Context, instanceID string, bindingID string) error {
+	if b.credentialStore == nil {
+		return nil
+	}
+
+	// The ID of the last rotation goes along with the credentials, retries
+	// mustn't return credentials which are gone.
+	for _, id := range []string{rotationRecordID(bindingID), bindingID} {
+		if err := b.credentialStore.Delete(ctx, instanceID, id); err != nil {
+			b.logger.Errorw("Failed to delete stored binding credentials", "error", err)
+			return err
+		}
+	}
+
+	return nil
+}
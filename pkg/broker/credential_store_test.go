@@ -0,0 +1,185 @@
+package broker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testCredentialStoreKey = bytes.Repeat([]byte{7}, CredentialStoreKeySize)
+
+// failingCredentialStore fails to store any record.
+type failingCredentialStore struct {
+	*MemoryCredentialStore
+}
+
+func (failingCredentialStore) Put(ctx context.Context, instanceID string, bindingID string, record []byte) error {
+	return errors.New("store unavailable")
+}
+
+func TestCredentialCipher(t *testing.T) {
+	c, err := newCredentialCipher(testCredentialStoreKey)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	record, err := c.seal("instance", "binding", []byte(`{"password": "secret"}`))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(record), "secret")
+
+	plaintext, err := c.open("instance", "binding", record)
+	assert.NoError(t, err)
+	assert.Equal(t, `{"password": "secret"}`, string(plaintext))
+
+	// Records are bound to their binding and key.
+	_, err = c.open("instance", "other", record)
+	assert.Error(t, err)
+
+	other, _ := newCredentialCipher(bytes.Repeat([]byte{8}, CredentialStoreKeySize))
+	_, err = other.open("instance", "binding", record)
+	assert.Error(t, err)
+
+	_, err = c.open("instance", "binding", record[:4])
+	assert.Error(t, err)
+}
+
+func TestWithCredentialStoreKeySize(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithCredentialStore(NewMemoryCredentialStore(), []byte("short")))
+	assert.EqualError(t, err, "the credential store key must be 32 bytes long")
+}
+
+func TestMemoryCredentialStore(t *testing.T) {
+	store := NewMemoryCredentialStore()
+	ctx := context.Background()
+
+	_, err := store.Get(ctx, "instance", "binding")
+	assert.Equal(t, ErrCredentialsNotFound, err)
+
+	assert.NoError(t, store.Put(ctx, "instance", "binding", []byte("record")))
+	record, err := store.Get(ctx, "instance", "binding")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("record"), record)
+
+	assert.NoError(t, store.Delete(ctx, "instance", "binding"))
+	assert.NoError(t, store.Delete(ctx, "instance", "binding"))
+	_, err = store.Get(ctx, "instance", "binding")
+	assert.Equal(t, ErrCredentialsNotFound, err)
+}
+
+func TestGetBinding(t *testing.T) {
+	store := NewMemoryCredentialStore()
+	broker, _, ctx := setupTest(WithCredentialStore(store, testCredentialStoreKey))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	bindingID := "binding"
+	binding, err := broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	spec, err := broker.GetBinding(ctx, instanceID, bindingID)
+	assert.NoError(t, err)
+
+	expected, _ := json.Marshal(binding.Credentials)
+	actual, _ := json.Marshal(spec.Credentials)
+	assert.JSONEq(t, string(expected), string(actual))
+
+	// The password is never stored in plain text.
+	record, _ := store.Get(ctx, instanceID, bindingID)
+	assert.NotContains(t, string(record), binding.Credentials.(ConnectionDetails).Password)
+
+	_, err = broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.GetBinding(ctx, instanceID, bindingID)
+	assert.Equal(t, apiresponses.ErrBindingNotFound, err)
+}
+
+func TestGetBindingWithoutStore(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	_, err := broker.GetBinding(ctx, "instance", "binding")
+	assert.Error(t, err)
+}
+
+func TestUnbindGoneBindingDeletesStoredCredentials(t *testing.T) {
+	store := NewMemoryCredentialStore()
+	broker, client, ctx := setupTest(WithCredentialStore(store, testCredentialStoreKey))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	bindingID := "binding"
+	broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	// The user was removed outside of the broker.
+	client.DeleteUser(atlas.AuthDatabaseAdmin, bindingUsername(bindingID))
+
+	_, err := broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.Equal(t, apiresponses.ErrBindingDoesNotExist, err)
+
+	_, err = store.Get(ctx, instanceID, bindingID)
+	assert.Equal(t, ErrCredentialsNotFound, err)
+}
+
+func TestBindFailsWithoutStoredCredentials(t *testing.T) {
+	store := failingCredentialStore{NewMemoryCredentialStore()}
+	broker, client, ctx := setupTest(WithCredentialStore(store, testCredentialStoreKey))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	_, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.EqualError(t, err, "store unavailable")
+	assert.Nil(t, client.Users[bindingUsername("binding")])
+}
+
+func TestCatalogBindingsRetrievable(t *testing.T) {
+	broker, _, ctx := setupTest()
+	services, err := broker.Services(ctx)
+	if assert.NoError(t, err) {
+		assert.False(t, services[0].BindingsRetrievable)
+	}
+
+	broker, _, ctx = setupTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+	services, err = broker.Services(ctx)
+	if assert.NoError(t, err) {
+		for _, service := range services {
+			assert.True(t, service.BindingsRetrievable, service.Name)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"strings"
+	"text/template"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// CredentialTemplateData is passed to credential templates when rendering the
+// credentials of a binding.
+type CredentialTemplateData struct {
+	// Username and Password are the credentials of the database user.
+	Username string
+	Password string
+
+	// URI is the connection string returned as "uri".
+	URI string
+
+	// Hosts are the members of the cluster in the standard connection string
+	// of the cluster.
+	Hosts []CredentialHost
+
+	// HostList joins all hosts as "host1:port1,host2:port2".
+	HostList string
+
+	// Database is the database included in the standard connection string,
+	// it's usually empty.
+	Database string
+
+	// Options are the options of the standard connection string, for example
+	// "replicaSet" and "authSource".
+	Options map[string]string
+}
+
+// CredentialHost is a single host of a cluster.
+type CredentialHost struct {
+	Name string
+	Port string
+}
+
+func (h CredentialHost) String() string {
+	if h.Port == "" {
+		return h.Name
+	}
+
+	return net.JoinHostPort(h.Name, h.Port)
+}
+
+// sampleCredentialTemplateData is used to validate credential templates when
+// the broker is created.
+var sampleCredentialTemplateData = CredentialTemplateData{
+	Username: "2a8a9ac7-5b2e-4b0a-9c37-4f1bb2bd6e8c",
+	Password: "password",
+	URI:      "mongodb+srv://cluster.abcde.mongodb.net",
+	Hosts: []CredentialHost{
+		CredentialHost{Name: "cluster-shard-00-00.abcde.mongodb.net", Port: "27017"},
+	},
+	HostList: "cluster-shard-00-00.abcde.mongodb.net:27017",
+	Options: map[string]string{
+		"authSource": "admin",
+		"replicaSet": "cluster-shard-0",
+		"ssl":        "true",
+	},
+}
+
+// reservedCredentialFields are the fields of ConnectionDetails which can't be
+// replaced by templates.
+var reservedCredentialFields = []string{"username", "password", "uri"}
+
+// credentialTemplates holds the parsed credential templates of a plan keyed
+// by the name of the credential field they render.
+type credentialTemplates map[string]*template.Template
+
+// credentialAliasSources are the source fields credential aliases can refer
+// to by name instead of using a template.
+var credentialAliasSources = map[string]string{
+	"username": "{{.Username}}",
+	"password": "{{.Password}}",
+	"uri":      "{{.URI}}",
+	"hostList": "{{.HostList}}",
+	"database": "{{.Database}}",
+}
+
+// parseCredentialTemplates parses and validates the credential templates of a
+// plan by rendering them with sample data.
+func parseCredentialTemplates(planID string, fields map[string]string) (credentialTemplates, error) {
+	return parseTemplates(fmt.Sprintf(`plan "%s"`, planID), fields)
+}
+
+// parseCredentialAliases parses the credential aliases applied to every
+// binding. Aliases either name one of the credentialAliasSources or are
+// templates like the per-plan credential templates.
+func parseCredentialAliases(aliases map[string]string) (credentialTemplates, error) {
+	fields := map[string]string{}
+
+	for key, source := range aliases {
+		for _, reserved := range reservedCredentialFields {
+			if key == reserved {
+				return nil, fmt.Errorf(`credential alias "%s" collides with a standard credential field`, key)
+			}
+		}
+
+		if text, ok := credentialAliasSources[source]; ok {
+			source = text
+		}
+
+		fields[key] = source
+	}
+
+	return parseTemplates("the credential aliases", fields)
+}
+
+// parseTemplates parses and validates credential templates by rendering them
+// with sample data. owner describes where the templates are configured for
+// error messages.
+func parseTemplates(owner string, fields map[string]string) (credentialTemplates, error) {
+	templates := credentialTemplates{}
+
+	for field, text := range fields {
+		for _, reserved := range reservedCredentialFields {
+			if field == reserved {
+				return nil, fmt.Errorf(`credential template for %s can't replace the "%s" field`, owner, field)
+			}
+		}
+
+		tmpl, err := template.New(field).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf(`invalid credential template "%s" for %s: %v`, field, owner, err)
+		}
+
+		if err := tmpl.Execute(&bytes.Buffer{}, sampleCredentialTemplateData); err != nil {
+			return nil, fmt.Errorf(`invalid credential template "%s" for %s: %v`, field, owner, err)
+		}
+
+		templates[field] = tmpl
+	}
+
+	return templates, nil
+}
+
+// render renders all templates into a credentials map.
+func (c credentialTemplates) render(data CredentialTemplateData) (map[string]interface{}, error) {
+	credentials := map[string]interface{}{}
+
+	for field, tmpl := range c {
+		var value bytes.Buffer
+		if err := tmpl.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf(`failed to render credential "%s": %v`, field, err)
+		}
+
+		credentials[field] = value.String()
+	}
+
+	return credentials, nil
+}
+
+// renderCredentials renders the credential aliases and the credential
+// templates of a plan for a new binding. Templates of the plan take precedence
+// over aliases with the same name. Nil is returned if there are neither.
+func (b Broker) renderCredentials(planID string, cluster *atlas.Cluster, username string, password string, uri string) (map[string]interface{}, error) {
+	templates, ok := b.credentialTemplates[planID]
+	if !ok && len(b.credentialAliases) == 0 {
+		return nil, nil
+	}
+
+	data, err := credentialTemplateData(cluster, username, password, uri)
+	if err != nil {
+		return nil, err
+	}
+
+	credentials, err := b.credentialAliases.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	planCredentials, err := templates.render(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for field, value := range planCredentials {
+		credentials[field] = value
+	}
+
+	return credentials, nil
+}
+
+// credentialTemplateData assembles the template data for a binding. The hosts
+// are taken from the standard connection string of the cluster.
+func credentialTemplateData(cluster *atlas.Cluster, username string, password string, uri string) (CredentialTemplateData, error) {
+	data := CredentialTemplateData{
+		Username: username,
+		Password: password,
+		URI:      uri,
+		Hosts:    []CredentialHost{},
+		Options:  map[string]string{},
+	}
+
+	standard := cluster.MongoURIWithOptions
+	if standard == "" {
+		standard = cluster.MongoURI
+	}
+
+	if standard == "" {
+		return data, nil
+	}
+
+	parsed, err := parseMongoURI(standard)
+	if err != nil {
+		return data, err
+	}
+	removeClusterTypeOptions(cluster, parsed.Options)
+
+	for _, host := range parsed.Hosts {
+		name, port, err := net.SplitHostPort(host)
+		if err != nil {
+			name, port = host, ""
+		}
+
+		data.Hosts = append(data.Hosts, CredentialHost{Name: name, Port: port})
+	}
+
+	data.HostList = strings.Join(parsed.Hosts, ",")
+	data.Database = parsed.Database
+
+	for key := range parsed.Options {
+		data.Options[key] = parsed.Options.Get(key)
+	}
+
+	return data, nil
+}
@@ -0,0 +1,172 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+const jdbcURLTemplate = `jdbc:mongodb://{{.Username}}:{{.Password}}@{{.HostList}}/{{.Database}}?replicaSet={{index .Options "replicaSet"}}`
+
+func TestCredentialTemplateData(t *testing.T) {
+	data, err := credentialTemplateData(testConnectionStringCluster, "user", "pass", "mongodb+srv://cluster.abcde.mongodb.net")
+
+	assert.NoError(t, err)
+	assert.Equal(t, CredentialTemplateData{
+		Username: "user",
+		Password: "pass",
+		URI:      "mongodb+srv://cluster.abcde.mongodb.net",
+		Hosts: []CredentialHost{
+			CredentialHost{Name: "cluster-shard-00-00.abcde.mongodb.net", Port: "27017"},
+			CredentialHost{Name: "cluster-shard-00-01.abcde.mongodb.net", Port: "27017"},
+			CredentialHost{Name: "cluster-shard-00-02.abcde.mongodb.net", Port: "27017"},
+		},
+		HostList: "cluster-shard-00-00.abcde.mongodb.net:27017,cluster-shard-00-01.abcde.mongodb.net:27017,cluster-shard-00-02.abcde.mongodb.net:27017",
+		Options: map[string]string{
+			"ssl":        "true",
+			"authSource": "admin",
+			"replicaSet": "cluster-shard-0",
+		},
+	}, data)
+}
+
+func TestCredentialTemplateDataSharded(t *testing.T) {
+	data, err := credentialTemplateData(testShardedConnectionStringCluster, "user", "pass", "mongodb+srv://sharded.abcde.mongodb.net")
+
+	assert.NoError(t, err)
+	assert.Len(t, data.Hosts, 3)
+	assert.Equal(t, map[string]string{"ssl": "true", "authSource": "admin"}, data.Options)
+}
+
+func TestParseCredentialTemplatesInvalid(t *testing.T) {
+	invalidTemplates := []map[string]string{
+		{"jdbcUrl": "{{.Username"},
+		{"jdbcUrl": "{{.Unknown}}"},
+		{"host": "{{(index .Hosts 3).Name}}"},
+		{"uri": "{{.HostList}}"},
+	}
+
+	for _, fields := range invalidTemplates {
+		_, err := parseCredentialTemplates(testPlanID, fields)
+		assert.Error(t, err, "Expected templates %v to be rejected", fields)
+	}
+}
+
+func TestBindCredentialTemplates(t *testing.T) {
+	broker, client, ctx := setupTest(WithCredentialTemplates(map[string]map[string]string{
+		testPlanID: {
+			"jdbcUrl": jdbcURLTemplate,
+			"host":    `{{(index .Hosts 0).Name}}`,
+			"port":    `{{(index .Hosts 0).Port}}`,
+		},
+	}))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = testConnectionStringCluster.SrvAddress
+	client.Clusters[instanceID].MongoURIWithOptions = testConnectionStringCluster.MongoURIWithOptions
+
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	credentials := spec.Credentials.(map[string]interface{})
+	password := credentials["password"].(string)
+
+	assert.Equal(t, map[string]interface{}{
+		"username": "binding",
+		"password": password,
+		"uri":      testConnectionStringCluster.SrvAddress + "/?appName=instance-binding",
+		"jdbcUrl":  "jdbc:mongodb://binding:" + password + "@cluster-shard-00-00.abcde.mongodb.net:27017,cluster-shard-00-01.abcde.mongodb.net:27017,cluster-shard-00-02.abcde.mongodb.net:27017/?replicaSet=cluster-shard-0",
+		"host":     "cluster-shard-00-00.abcde.mongodb.net",
+
+		// Templates replace the discrete connection fields.
+		"port":        "27017",
+		"hosts":       []string{"cluster-shard-00-00.abcde.mongodb.net", "cluster-shard-00-01.abcde.mongodb.net", "cluster-shard-00-02.abcde.mongodb.net"},
+		"replica_set": "cluster-shard-0",
+		"auth_source": "admin",
+		"srv":         true,
+	}, credentials)
+}
+
+func TestBindWithoutCredentialTemplates(t *testing.T) {
+	broker, _, ctx := setupTest(WithCredentialTemplates(map[string]map[string]string{
+		"aosb-cluster-plan-aws-m20": {"jdbcUrl": jdbcURLTemplate},
+	}))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.IsType(t, ConnectionDetails{}, spec.Credentials, "Expected plans without templates to return the standard credentials")
+}
+
+func TestParseCredentialAliasesInvalid(t *testing.T) {
+	_, err := parseCredentialAliases(map[string]string{"uri": "username"})
+	assert.EqualError(t, err, `credential alias "uri" collides with a standard credential field`)
+
+	_, err = parseCredentialAliases(map[string]string{"db_name": "{{.Unknown}}"})
+	assert.Error(t, err)
+}
+
+func TestBindCredentialAliases(t *testing.T) {
+	broker, client, ctx := setupTest(
+		WithCredentialAliases(map[string]string{
+			"user":             "username",
+			"db_name":          "database",
+			"replica_set_name": `{{index .Options "replicaSet"}}`,
+			"host":             "overridden by the plan",
+		}),
+		WithCredentialTemplates(map[string]map[string]string{
+			testPlanID: {"host": `{{(index .Hosts 0).Name}}`},
+		}),
+	)
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = testConnectionStringCluster.SrvAddress
+	client.Clusters[instanceID].MongoURIWithOptions = testConnectionStringCluster.MongoURIWithOptions
+
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	credentials := spec.Credentials.(map[string]interface{})
+	assert.Equal(t, map[string]interface{}{
+		"username":         "binding",
+		"password":         credentials["password"],
+		"uri":              testConnectionStringCluster.SrvAddress + "/?appName=instance-binding",
+		"user":             "binding",
+		"db_name":          "",
+		"replica_set_name": "cluster-shard-0",
+		"host":             "cluster-shard-00-00.abcde.mongodb.net",
+		"hosts":            []string{"cluster-shard-00-00.abcde.mongodb.net", "cluster-shard-00-01.abcde.mongodb.net", "cluster-shard-00-02.abcde.mongodb.net"},
+		"port":             27017,
+		"replica_set":      "cluster-shard-0",
+		"auth_source":      "admin",
+		"srv":              true,
+	}, credentials)
+}
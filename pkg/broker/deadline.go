@@ -0,0 +1,305 @@
+package broker
+
+import (
+	"context"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// DefaultProvisionTimeout bounds the synchronous phase of a provision. It
+// stays below the 60 seconds platforms usually wait for a response so they
+// don't give up and retry while the broker is still working.
+const DefaultProvisionTimeout = 50 * time.Second
+
+// deadlineClient wraps an Atlas client so calls return once the context is
+// done. The Atlas client doesn't accept contexts, so a call which is cut short
+// keeps running in the background and its result is discarded, so it may
+// still create a resource. Operations using it have to be safe to retry and
+// treat resources which already exist as created by an earlier attempt if
+// they belong to the same instance, see retriedProvision.
+type deadlineClient struct {
+	ctx    context.Context
+	client atlas.Client
+}
+
+func newDeadlineClient(ctx context.Context, client atlas.Client) deadlineClient {
+	return deadlineClient{ctx: ctx, client: client}
+}
+
+// run calls f and waits for it to return or the context to be done,
+// whichever happens first. Results set by f may only be read if run returns
+// nil.
+func (c deadlineClient) run(f func() error) error {
+	if err := c.ctx.Err(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c deadlineClient) CreateCluster(cluster atlas.Cluster) (*atlas.Cluster, error) {
+	var result *atlas.Cluster
+	err := c.run(func() (err error) {
+		result, err = c.client.CreateCluster(cluster)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) UpdateCluster(cluster atlas.Cluster) (*atlas.Cluster, error) {
+	var result *atlas.Cluster
+	err := c.run(func() (err error) {
+		result, err = c.client.UpdateCluster(cluster)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) DeleteCluster(name string) error {
+	return c.run(func() error {
+		return c.client.DeleteCluster(name)
+	})
+}
+
+func (c deadlineClient) GetCluster(name string) (*atlas.Cluster, error) {
+	var result *atlas.Cluster
+	err := c.run(func() (err error) {
+		result, err = c.client.GetCluster(name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) ListClusters() ([]atlas.Cluster, error) {
+	var result []atlas.Cluster
+	err := c.run(func() (err error) {
+		result, err = c.client.ListClusters()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) GetDashboardURL(clusterName string) string {
+	return c.client.GetDashboardURL(clusterName)
+}
+
+func (c deadlineClient) GetProcessArgs(clusterName string) (*atlas.ProcessArgs, error) {
+	var result *atlas.ProcessArgs
+	err := c.run(func() (err error) {
+		result, err = c.client.GetProcessArgs(clusterName)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) UpdateProcessArgs(clusterName string, args atlas.ProcessArgs) (*atlas.ProcessArgs, error) {
+	var result *atlas.ProcessArgs
+	err := c.run(func() (err error) {
+		result, err = c.client.UpdateProcessArgs(clusterName, args)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) CreateUser(user atlas.User) (*atlas.User, error) {
+	var result *atlas.User
+	err := c.run(func() (err error) {
+		result, err = c.client.CreateUser(user)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) GetUser(name string) (*atlas.User, error) {
+	var result *atlas.User
+	err := c.run(func() (err error) {
+		result, err = c.client.GetUser(name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) ListUsers() ([]atlas.User, error) {
+	var result []atlas.User
+	err := c.run(func() (err error) {
+		result, err = c.client.ListUsers()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) DeleteUser(databaseName string, name string) error {
+	return c.run(func() error {
+		return c.client.DeleteUser(databaseName, name)
+	})
+}
+
+func (c deadlineClient) UpdateUser(user atlas.User) (*atlas.User, error) {
+	var result *atlas.User
+	err := c.run(func() (err error) {
+		result, err = c.client.UpdateUser(user)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) CreateUserCertificate(name string, monthsUntilExpiration int) (string, error) {
+	var result string
+	err := c.run(func() (err error) {
+		result, err = c.client.CreateUserCertificate(name, monthsUntilExpiration)
+		return
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) CreateAccessListEntries(entries []atlas.AccessListEntry) ([]atlas.AccessListEntry, error) {
+	var result []atlas.AccessListEntry
+	err := c.run(func() (err error) {
+		result, err = c.client.CreateAccessListEntries(entries)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) ListAccessListEntries() ([]atlas.AccessListEntry, error) {
+	var result []atlas.AccessListEntry
+	err := c.run(func() (err error) {
+		result, err = c.client.ListAccessListEntries()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) DeleteAccessListEntry(entry string) error {
+	return c.run(func() error {
+		return c.client.DeleteAccessListEntry(entry)
+	})
+}
+
+func (c deadlineClient) GetEncryptionAtRest() (*atlas.EncryptionAtRest, error) {
+	var result *atlas.EncryptionAtRest
+	err := c.run(func() (err error) {
+		result, err = c.client.GetEncryptionAtRest()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) UpdateEncryptionAtRest(encryption atlas.EncryptionAtRest) (*atlas.EncryptionAtRest, error) {
+	var result *atlas.EncryptionAtRest
+	err := c.run(func() (err error) {
+		result, err = c.client.UpdateEncryptionAtRest(encryption)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) GetProvider(name string) (*atlas.Provider, error) {
+	var result *atlas.Provider
+	err := c.run(func() (err error) {
+		result, err = c.client.GetProvider(name)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) ListClusterEvents(clusterName string, limit int) ([]atlas.Event, error) {
+	var result []atlas.Event
+	err := c.run(func() (err error) {
+		result, err = c.client.ListClusterEvents(clusterName, limit)
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func (c deadlineClient) GetProjectStatus() (*atlas.ProjectStatus, error) {
+	var result *atlas.ProjectStatus
+	err := c.run(func() (err error) {
+		result, err = c.client.GetProjectStatus()
+		return
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,270 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+// slowAtlasClient blocks lookups of providers or creations of clusters until
+// released, simulating an Atlas which takes longer than the platform waits.
+// Cluster creations complete before blocking, like a response which gets
+// lost on the way back, created is signalled once that happened. Blocked
+// provider lookups are signalled on blocked.
+type slowAtlasClient struct {
+	MockAtlasClient
+
+	slowProviders bool
+	release       chan struct{}
+	created       chan struct{}
+	blocked       chan struct{}
+}
+
+func (c slowAtlasClient) GetProvider(name string) (*atlas.Provider, error) {
+	if c.slowProviders {
+		select {
+		case c.blocked <- struct{}{}:
+		default:
+		}
+
+		<-c.release
+	}
+
+	return c.MockAtlasClient.GetProvider(name)
+}
+
+func (c slowAtlasClient) CreateCluster(cluster atlas.Cluster) (*atlas.Cluster, error) {
+	result, err := c.MockAtlasClient.CreateCluster(cluster)
+	close(c.created)
+	<-c.release
+	return result, err
+}
+
+func TestDeadlineClient(t *testing.T) {
+	_, client, _ := setupTest()
+	slow := slowAtlasClient{MockAtlasClient: client, slowProviders: true, release: make(chan struct{})}
+	defer close(slow.release)
+
+	fake := clock.NewFake(testTime)
+	ctx, cancel := clock.WithTimeout(context.Background(), fake, time.Minute)
+	defer cancel()
+
+	slow.blocked = make(chan struct{}, 1)
+	go func() {
+		<-slow.blocked
+		fake.Advance(time.Minute)
+	}()
+
+	_, err := newDeadlineClient(ctx, slow).GetProvider("AWS")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	// Calls aren't started once the context is done.
+	_, err = newDeadlineClient(ctx, client).GetCluster("instance")
+	assert.Equal(t, context.DeadlineExceeded, err)
+
+	provider, err := newDeadlineClient(context.Background(), client).GetProvider("AWS")
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS", provider.Name)
+}
+
+// provisionAsync starts a provision and returns a channel receiving its error.
+func provisionAsync(ctx context.Context, broker *Broker, instanceID string, details brokerapi.ProvisionDetails) <-chan error {
+	done := make(chan error, 1)
+	go func() {
+		_, err := broker.Provision(ctx, instanceID, details, true)
+		done <- err
+	}()
+
+	return done
+}
+
+func TestProvisionTimeout(t *testing.T) {
+	broker, client, _ := setupTest(WithProvisionTimeout(time.Minute))
+
+	slow := slowAtlasClient{MockAtlasClient: client, slowProviders: true, release: make(chan struct{}), blocked: make(chan struct{}, 1)}
+	defer close(slow.release)
+	ctx := context.WithValue(context.Background(), ContextKeyAtlasClient, slow)
+
+	done := provisionAsync(ctx, broker, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	})
+
+	// The provision returns at the deadline while Atlas is still busy.
+	<-slow.blocked
+	testClock(broker).Advance(time.Minute)
+
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+	assert.Empty(t, client.Clusters)
+}
+
+func TestProvisionCanceled(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestProvisionRetries(t *testing.T) {
+	broker, client, _ := setupTest(WithProvisionTimeout(time.Minute))
+
+	// The first attempt creates the cluster but times out before Atlas
+	// responds.
+	slow := slowAtlasClient{MockAtlasClient: client, release: make(chan struct{}), created: make(chan struct{})}
+	defer close(slow.release)
+	slowCtx := context.WithValue(context.Background(), ContextKeyAtlasClient, slow)
+
+	instanceID := "instance"
+	details := brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}
+
+	done := provisionAsync(slowCtx, broker, instanceID, details)
+
+	<-slow.created
+	testClock(broker).Advance(time.Minute)
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+
+	if !assert.NotNil(t, client.Clusters[instanceID]) {
+		return
+	}
+
+	// The retry picks up the cluster which is still being created.
+	ctx := context.WithValue(context.Background(), ContextKeyAtlasClient, client)
+	spec, err := broker.Provision(ctx, instanceID, details, true)
+	assert.NoError(t, err)
+	assert.True(t, spec.IsAsync)
+	assert.Equal(t, OperationProvision, operationFromData(spec.OperationData, nil).Operation)
+	assert.Len(t, client.Clusters, 1)
+
+	// Retries after the cluster is ready complete synchronously.
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	spec, err = broker.Provision(ctx, instanceID, details, true)
+	assert.NoError(t, err)
+	assert.False(t, spec.IsAsync)
+
+	// Different parameters conflict with the existing instance.
+	details.RawParameters = []byte(`{"cluster": {"diskSizeGB": 40}}`)
+	_, err = broker.Provision(ctx, instanceID, details, true)
+	assert.Equal(t, apiresponses.ErrInstanceAlreadyExists, err)
+}
+
+func TestProvisionRetryAppliesFollowUps(t *testing.T) {
+	broker, client, _ := setupTest(WithProvisionTimeout(time.Minute), WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+
+	// The first attempt times out right after creating the cluster, before
+	// the access list entries and the monitoring user are added.
+	slow := slowAtlasClient{MockAtlasClient: client, release: make(chan struct{}), created: make(chan struct{})}
+	defer close(slow.release)
+	slowCtx := context.WithValue(context.Background(), ContextKeyAtlasClient, slow)
+
+	instanceID := "instance"
+	details := brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"ipAccessList": [{"cidrBlock": "10.0.0.0/8"}], "monitoringUser": true}`),
+	}
+
+	done := provisionAsync(slowCtx, broker, instanceID, details)
+
+	<-slow.created
+	testClock(broker).Advance(time.Minute)
+	assert.Equal(t, context.DeadlineExceeded, <-done)
+	assert.Empty(t, client.AccessList)
+	assert.Empty(t, client.Users)
+
+	ctx := context.WithValue(context.Background(), ContextKeyAtlasClient, client)
+	_, err := broker.Provision(ctx, instanceID, details, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, client.AccessList, 1)
+
+	credentials, err := broker.MonitoringCredentials(ctx, instanceID)
+	if assert.NoError(t, err) && assert.NotNil(t, client.Users[credentials.Username]) {
+		assert.Equal(t, client.Users[credentials.Username].Password, credentials.Password)
+	}
+
+	// Further retries keep the password of the monitoring user.
+	_, err = broker.Provision(ctx, instanceID, details, true)
+	if assert.NoError(t, err) {
+		retried, err := broker.MonitoringCredentials(ctx, instanceID)
+		if assert.NoError(t, err) {
+			assert.Equal(t, credentials.Password, retried.Password)
+		}
+	}
+	assert.Len(t, client.AccessList, 1)
+}
+
+func TestBindRetries(t *testing.T) {
+	broker, client, ctx := setupPasswordTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey))
+
+	details, err := bindWithParams(broker, ctx, "binding", `{}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// A retry whose earlier response got lost gets the same credentials.
+	retried, err := bindWithParams(broker, ctx, "binding", `{}`)
+	if assert.NoError(t, err) {
+		assert.Equal(t, details.Password, retried.Password)
+		assert.Equal(t, details.Password, client.Users["binding"].Password)
+	}
+	assert.Len(t, client.Users, 1)
+
+	// Without stored credentials the user of the earlier attempt gets the
+	// new password.
+	broker, client, ctx = setupPasswordTest()
+
+	details, err = bindWithParams(broker, ctx, "binding", `{}`)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	retried, err = bindWithParams(broker, ctx, "binding", `{}`)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, details.Password, retried.Password)
+		assert.Equal(t, retried.Password, client.Users["binding"].Password)
+	}
+	assert.Len(t, client.Users, 1)
+}
+
+func TestProvisionRetryFromPool(t *testing.T) {
+	broker, client, ctx := setupPoolTest(t)
+
+	details := brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}
+
+	_, err := broker.Provision(ctx, "instance", details, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+	clusters := len(client.Clusters)
+
+	// The retry finds the claimed cluster instead of claiming another one.
+	spec, err := broker.Provision(ctx, "instance", details, false)
+	assert.NoError(t, err)
+	assert.False(t, spec.IsAsync)
+	assert.Len(t, client.Clusters, clusters)
+	assert.Len(t, poolMembers(client), 2)
+}
+
+func TestWithProvisionTimeoutInvalid(t *testing.T) {
+	assert.Error(t, WithProvisionTimeout(0)(&Broker{}))
+}
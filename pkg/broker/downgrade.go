@@ -0,0 +1,81 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// Policies for updates moving a cluster to a smaller instance size.
+const (
+	// DowngradePolicyConfirm only allows downgrades passing the
+	// allowDowngrade parameter. It's the default.
+	DowngradePolicyConfirm = "confirm"
+
+	// DowngradePolicyAllow allows downgrades without confirmation.
+	DowngradePolicyAllow = "allow"
+
+	// DowngradePolicyDeny rejects all downgrades.
+	DowngradePolicyDeny = "deny"
+)
+
+// allowDowngradeFromParams reads the allowDowngrade parameter which confirms
+// that an update may move the cluster to a smaller instance size.
+func allowDowngradeFromParams(rawParams []byte) (bool, error) {
+	if len(rawParams) == 0 {
+		return false, nil
+	}
+
+	params := struct {
+		AllowDowngrade bool `json:"allowDowngrade"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return false, validationErrorFromJSON(err)
+	}
+
+	return params.AllowDowngrade, nil
+}
+
+// checkDowngrade guards against plan transitions shrinking a cluster by
+// accident. Downgrades are handled according to the downgrade policy, and are
+// always rejected if the disk of the cluster is larger than the new instance
+// size supports. Instance sizes which aren't named like the known ones are
+// left to Atlas.
+func (b Broker) checkDowngrade(transition *planTransition, existing *atlas.Cluster, updated *atlas.Cluster, allowDowngrade bool) error {
+	current := transition.From.InstanceSizeName
+	target := transition.To.InstanceSizeName
+
+	currentNumber, ok := instanceSizeNumber(current)
+	if !ok {
+		return nil
+	}
+
+	targetNumber, ok := instanceSizeNumber(target)
+	if !ok || targetNumber >= currentNumber {
+		return nil
+	}
+
+	diskSizeGB := updated.DiskSizeGB
+	if diskSizeGB == 0 {
+		diskSizeGB = existing.DiskSizeGB
+	}
+
+	var err error
+	spec, ok := instanceSizeSpecFor(target)
+	switch {
+	case ok && spec.MaxStorageGB > 0 && diskSizeGB > spec.MaxStorageGB:
+		err = fmt.Errorf(`can't downgrade the instance size from "%s" to "%s" as the disk of %g GB exceeds the maximum of %g GB of "%s"`, current, target, diskSizeGB, spec.MaxStorageGB, target)
+	case b.downgradePolicy == DowngradePolicyDeny:
+		err = fmt.Errorf(`downgrading the instance size from "%s" to "%s" isn't allowed, create a new instance and migrate the data instead`, current, target)
+	case b.downgradePolicy == DowngradePolicyAllow || allowDowngrade:
+		b.logger.Warnw("Downgrading cluster instance size", "current_instance_size", current, "target_instance_size", target)
+		return nil
+	default:
+		err = fmt.Errorf(`changing the instance size from "%s" to "%s" is a downgrade, pass "allowDowngrade": true to confirm it`, current, target)
+	}
+
+	return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "instance-size-downgrade")
+}
@@ -0,0 +1,146 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// provisionM30 provisions an M30 instance with a disk of the given size.
+func provisionM30(ctx context.Context, t *testing.T, broker *Broker, client MockAtlasClient, diskSizeGB float64) {
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m30",
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	client.Clusters["instance"].DiskSizeGB = diskSizeGB
+}
+
+func assertDowngradeRefused(t *testing.T, err error, message string) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "instance-size-downgrade", failure.LoggerAction())
+		assert.Contains(t, failure.Error(), message)
+	}
+}
+
+func TestUpdateDowngrade(t *testing.T) {
+	broker, client, ctx := setupTest()
+	provisionM30(ctx, t, broker, client, 100)
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assertDowngradeRefused(t, err, `from "M30" to "M10"`)
+	assert.Equal(t, "M30", client.Clusters["instance"].ProviderSettings.InstanceSizeName)
+
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"allowDowngrade": true}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "M10", client.Clusters["instance"].ProviderSettings.InstanceSizeName)
+}
+
+func TestUpdateDowngradeDiskTooLarge(t *testing.T) {
+	broker, client, ctx := setupTest(WithDowngradePolicy(DowngradePolicyAllow))
+	provisionM30(ctx, t, broker, client, 200)
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"allowDowngrade": true}`),
+	}, true)
+
+	assertDowngradeRefused(t, err, "200 GB exceeds the maximum of 128 GB")
+
+	// Shrinking the disk as part of the update makes it fit.
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 100}}`),
+	}, true)
+
+	assert.NoError(t, err)
+}
+
+func TestUpdateDowngradeDenied(t *testing.T) {
+	broker, client, ctx := setupTest(WithDowngradePolicy(DowngradePolicyDeny))
+	provisionM30(ctx, t, broker, client, 100)
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"allowDowngrade": true}`),
+	}, true)
+
+	assertDowngradeRefused(t, err, "isn't allowed")
+}
+
+func TestUpdateUpgradeNeedsNoConfirmation(t *testing.T) {
+	broker, client, ctx := setupTest(WithDowngradePolicy(DowngradePolicyDeny))
+
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		PlanID:    "aosb-cluster-plan-aws-m30",
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "M30", client.Clusters["instance"].ProviderSettings.InstanceSizeName)
+}
+
+func TestCheckDowngradeTransition(t *testing.T) {
+	broker, _, _ := setupTest()
+
+	// The check follows the plan transition of the update, variant sizes
+	// count as the size they're based on.
+	existing := &atlas.Cluster{DiskSizeGB: 40}
+	updated := &atlas.Cluster{}
+	tests := []struct {
+		from    string
+		to      string
+		refused bool
+	}{
+		{"M40", "M30", true},
+		{"M40_NVME", "M30", true},
+		{"M30", "M40_NVME", false},
+		{"M30", "M30", false},
+		{"", "M10", false},
+	}
+
+	for _, test := range tests {
+		transition := &planTransition{
+			From: planRef{ProviderName: "AWS", InstanceSizeName: test.from},
+			To:   planRef{ProviderName: "AWS", InstanceSizeName: test.to},
+		}
+
+		err := broker.checkDowngrade(transition, existing, updated, false)
+		if test.refused {
+			assertDowngradeRefused(t, err, "is a downgrade")
+		} else {
+			assert.NoError(t, err, test.from+" to "+test.to)
+		}
+	}
+}
+
+func TestWithDowngradePolicy(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithDowngradePolicy("sometimes"))
+	assert.Error(t, err)
+}
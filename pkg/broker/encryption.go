@@ -0,0 +1,214 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// EncryptionAtRestParams is the "encryptionAtRest" provision parameter. It
+// configures the customer-managed keys of the project, which the cluster is
+// then encrypted with.
+type EncryptionAtRestParams struct {
+	Provider string `json:"provider" description:"Provider of the keys, one of AWS, AZURE or GCP."`
+	KMSKeyID string `json:"kmsKeyID,omitempty" description:"ID of the AWS customer master key, the Azure key identifier or the GCP key version resource ID."`
+
+	RoleID string `json:"roleID,omitempty" description:"ID of the Atlas AWS IAM role with access to the key (AWS only)."`
+	Region string `json:"region,omitempty" description:"Atlas name of the region of the key, for example US_EAST_1 (AWS only)."`
+
+	ClientID          string `json:"clientID,omitempty" description:"Client ID of the Azure application with access to the key vault (Azure only)."`
+	Secret            string `json:"secret,omitempty" description:"Secret of the Azure application (Azure only)."`
+	TenantID          string `json:"tenantID,omitempty" description:"Azure tenant of the application (Azure only)."`
+	SubscriptionID    string `json:"subscriptionID,omitempty" description:"Azure subscription of the key vault (Azure only)."`
+	ResourceGroupName string `json:"resourceGroupName,omitempty" description:"Azure resource group of the key vault (Azure only)."`
+	KeyVaultName      string `json:"keyVaultName,omitempty" description:"Name of the Azure key vault (Azure only)."`
+	AzureEnvironment  string `json:"azureEnvironment,omitempty" description:"Azure environment of the key vault, defaults to AZURE (Azure only)."`
+
+	ServiceAccountKey string `json:"serviceAccountKey,omitempty" description:"JSON key of the GCP service account with access to the key (GCP only)."`
+}
+
+// defaultAzureEnvironment is the Azure environment Atlas assumes.
+const defaultAzureEnvironment = "AZURE"
+
+// encryptionAtRestFromParams returns the customer-managed keys requested by
+// the "encryptionAtRest" parameter, or nil if there are none. The cluster
+// must not ask for keys of another provider. Invalid parameters result in a
+// *ValidationError.
+func encryptionAtRestFromParams(rawParams []byte, cluster *atlas.Cluster) (*EncryptionAtRestParams, error) {
+	params := struct {
+		EncryptionAtRest *EncryptionAtRestParams `json:"encryptionAtRest"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	encryption := params.EncryptionAtRest
+	if encryption == nil {
+		return nil, nil
+	}
+
+	verr := &ValidationError{}
+	switch encryption.Provider {
+	case atlas.EncryptionAtRestProviderAWS, atlas.EncryptionAtRestProviderAzure, atlas.EncryptionAtRestProviderGCP:
+		encryption.validateFields(verr)
+	default:
+		verr.add("encryptionAtRest.provider", "must be one of %s, %s or %s", atlas.EncryptionAtRestProviderAWS, atlas.EncryptionAtRestProviderAzure, atlas.EncryptionAtRestProviderGCP)
+	}
+
+	if provider := cluster.EncryptionAtRestProvider; provider != "" && provider != encryption.Provider {
+		verr.add("cluster.encryptionAtRestProvider", `is "%s" but encryptionAtRest.provider is "%s"`, provider, encryption.Provider)
+	}
+
+	if cluster.ProviderSettings != nil && isSharedInstanceSizeName(cluster.ProviderSettings.InstanceSizeName) {
+		verr.add("encryptionAtRest", "is not supported by shared instance sizes")
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	return encryption, nil
+}
+
+// validateFields makes sure the fields of the provider are set and those of
+// other providers aren't.
+func (p EncryptionAtRestParams) validateFields(verr *ValidationError) {
+	fields := []struct {
+		name     string
+		value    string
+		provider string
+		required bool
+	}{
+		{"kmsKeyID", p.KMSKeyID, "", true},
+		{"roleID", p.RoleID, atlas.EncryptionAtRestProviderAWS, true},
+		{"region", p.Region, atlas.EncryptionAtRestProviderAWS, true},
+		{"clientID", p.ClientID, atlas.EncryptionAtRestProviderAzure, true},
+		{"secret", p.Secret, atlas.EncryptionAtRestProviderAzure, true},
+		{"tenantID", p.TenantID, atlas.EncryptionAtRestProviderAzure, true},
+		{"subscriptionID", p.SubscriptionID, atlas.EncryptionAtRestProviderAzure, true},
+		{"resourceGroupName", p.ResourceGroupName, atlas.EncryptionAtRestProviderAzure, true},
+		{"keyVaultName", p.KeyVaultName, atlas.EncryptionAtRestProviderAzure, true},
+		{"azureEnvironment", p.AzureEnvironment, atlas.EncryptionAtRestProviderAzure, false},
+		{"serviceAccountKey", p.ServiceAccountKey, atlas.EncryptionAtRestProviderGCP, true},
+	}
+
+	for _, field := range fields {
+		applies := field.provider == "" || field.provider == p.Provider
+
+		switch {
+		case !applies && field.value != "":
+			verr.add("encryptionAtRest."+field.name, `is only supported by provider "%s"`, field.provider)
+		case applies && field.required && field.value == "":
+			verr.add("encryptionAtRest."+field.name, `is required for provider "%s"`, p.Provider)
+		}
+	}
+}
+
+// atlasConfig returns the project configuration enabling the keys.
+func (p EncryptionAtRestParams) atlasConfig() atlas.EncryptionAtRest {
+	switch p.Provider {
+	case atlas.EncryptionAtRestProviderAWS:
+		return atlas.EncryptionAtRest{AWSKMS: &atlas.AWSKMS{
+			Enabled:             true,
+			CustomerMasterKeyID: p.KMSKeyID,
+			Region:              p.Region,
+			RoleID:              p.RoleID,
+		}}
+	case atlas.EncryptionAtRestProviderAzure:
+		environment := p.AzureEnvironment
+		if environment == "" {
+			environment = defaultAzureEnvironment
+		}
+
+		return atlas.EncryptionAtRest{AzureKeyVault: &atlas.AzureKeyVault{
+			Enabled:           true,
+			ClientID:          p.ClientID,
+			AzureEnvironment:  environment,
+			SubscriptionID:    p.SubscriptionID,
+			ResourceGroupName: p.ResourceGroupName,
+			KeyVaultName:      p.KeyVaultName,
+			KeyIdentifier:     p.KMSKeyID,
+			Secret:            p.Secret,
+			TenantID:          p.TenantID,
+		}}
+	default:
+		return atlas.EncryptionAtRest{GoogleCloudKMS: &atlas.GoogleCloudKMS{
+			Enabled:              true,
+			ServiceAccountKey:    p.ServiceAccountKey,
+			KeyVersionResourceID: p.KMSKeyID,
+		}}
+	}
+}
+
+// encryptionConfigured compares the requested keys with the configuration of
+// the project. It returns true if the keys are already enabled and an error
+// if the project uses other keys of the same provider. Secrets aren't
+// returned by Atlas so they aren't compared.
+func encryptionConfigured(current *atlas.EncryptionAtRest, requested atlas.EncryptionAtRest) (bool, error) {
+	var enabled, matches bool
+
+	switch {
+	case requested.AWSKMS != nil && current.AWSKMS != nil:
+		existing := *current.AWSKMS
+		enabled, matches = existing.Enabled, existing == *requested.AWSKMS
+	case requested.AzureKeyVault != nil && current.AzureKeyVault != nil:
+		existing, wanted := *current.AzureKeyVault, *requested.AzureKeyVault
+		existing.Secret, wanted.Secret = "", ""
+		enabled, matches = existing.Enabled, existing == wanted
+	case requested.GoogleCloudKMS != nil && current.GoogleCloudKMS != nil:
+		existing, wanted := *current.GoogleCloudKMS, *requested.GoogleCloudKMS
+		existing.ServiceAccountKey, wanted.ServiceAccountKey = "", ""
+		enabled, matches = existing.Enabled, existing == wanted
+	}
+
+	if enabled && !matches {
+		return false, errEncryptionAtRestConflict
+	}
+
+	return enabled, nil
+}
+
+// errEncryptionAtRestConflict is returned if the project already uses other
+// keys of the requested provider.
+var errEncryptionAtRestConflict = apiresponses.NewFailureResponse(
+	fmt.Errorf("the project already uses other keys of this provider for encryption at rest, they are shared by all clusters of the project and won't be changed by the broker"),
+	http.StatusBadRequest,
+	"encryption-at-rest-conflict",
+)
+
+// configureEncryptionAtRest enables the requested keys in the project unless
+// they are already enabled. Projects using other keys of the same provider
+// are left alone and the provision fails.
+func (b Broker) configureEncryptionAtRest(client atlas.Client, params *EncryptionAtRestParams) error {
+	current, err := client.GetEncryptionAtRest()
+	if err != nil {
+		b.logger.Errorw("Failed to get encryption at rest configuration", "error", err)
+		return atlasToAPIError(err)
+	}
+
+	requested := params.atlasConfig()
+	configured, err := encryptionConfigured(current, requested)
+	if err != nil {
+		b.logger.Errorw("Project has a conflicting encryption at rest configuration", "error", err, "provider", params.Provider)
+		return err
+	}
+
+	if configured {
+		b.logger.Infow("Encryption at rest keys are already configured", "provider", params.Provider)
+		return nil
+	}
+
+	if _, err := client.UpdateEncryptionAtRest(requested); err != nil {
+		b.logger.Errorw("Failed to configure encryption at rest", "error", err, "provider", params.Provider)
+		return atlasToAPIError(err)
+	}
+
+	b.logger.Infow("Configured encryption at rest keys", "provider", params.Provider)
+	return nil
+}
@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+const testAWSEncryptionParams = `{"encryptionAtRest": {"provider": "AWS", "kmsKeyID": "key", "roleID": "role", "region": "US_EAST_1"}}`
+
+func TestEncryptionAtRestFromParams(t *testing.T) {
+	cluster := &atlas.Cluster{ProviderSettings: &atlas.ProviderSettings{InstanceSizeName: "M10"}}
+
+	encryption, err := encryptionAtRestFromParams(nil, cluster)
+	assert.NoError(t, err)
+	assert.Nil(t, encryption)
+
+	encryption, err = encryptionAtRestFromParams([]byte(testAWSEncryptionParams), cluster)
+	if assert.NoError(t, err) {
+		assert.Equal(t, &EncryptionAtRestParams{Provider: "AWS", KMSKeyID: "key", RoleID: "role", Region: "US_EAST_1"}, encryption)
+	}
+
+	tests := []struct {
+		name    string
+		params  string
+		cluster *atlas.Cluster
+		field   string
+	}{
+		{"unknown provider", `{"encryptionAtRest": {"provider": "NONE"}}`, cluster, "encryptionAtRest.provider"},
+		{"missing key", `{"encryptionAtRest": {"provider": "GCP", "serviceAccountKey": "{}"}}`, cluster, "encryptionAtRest.kmsKeyID"},
+		{"missing provider field", `{"encryptionAtRest": {"provider": "AWS", "kmsKeyID": "key", "region": "US_EAST_1"}}`, cluster, "encryptionAtRest.roleID"},
+		{"field of other provider", `{"encryptionAtRest": {"provider": "GCP", "kmsKeyID": "key", "serviceAccountKey": "{}", "roleID": "role"}}`, cluster, "encryptionAtRest.roleID"},
+		{"conflicting cluster provider", testAWSEncryptionParams, &atlas.Cluster{EncryptionAtRestProvider: "NONE"}, "cluster.encryptionAtRestProvider"},
+		{"shared instance size", testAWSEncryptionParams, &atlas.Cluster{ProviderSettings: &atlas.ProviderSettings{InstanceSizeName: "M2"}}, "encryptionAtRest"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := encryptionAtRestFromParams([]byte(test.params), test.cluster)
+
+			verr, ok := err.(*ValidationError)
+			if assert.True(t, ok, "Expected a validation error") && assert.Len(t, verr.Violations, 1) {
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestProvisionEncryptionAtRest(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testAWSEncryptionParams),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.AWSKMS{Enabled: true, CustomerMasterKeyID: "key", Region: "US_EAST_1", RoleID: "role"}, client.EncryptionAtRest.AWSKMS)
+	if assert.NotNil(t, client.Clusters["instance"]) {
+		assert.Equal(t, "AWS", client.Clusters["instance"].EncryptionAtRestProvider)
+	}
+}
+
+func TestProvisionEncryptionAtRestAlreadyConfigured(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	// Atlas doesn't return secrets, so an update would be noticed by the
+	// secret being set.
+	existing := &atlas.AzureKeyVault{
+		Enabled:           true,
+		ClientID:          "client",
+		AzureEnvironment:  "AZURE",
+		SubscriptionID:    "subscription",
+		ResourceGroupName: "group",
+		KeyVaultName:      "vault",
+		KeyIdentifier:     "key",
+		TenantID:          "tenant",
+	}
+	client.EncryptionAtRest.AzureKeyVault = existing
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"encryptionAtRest": {"provider": "AZURE", "kmsKeyID": "key", "clientID": "client", "secret": "secret", "tenantID": "tenant", "subscriptionID": "subscription", "resourceGroupName": "group", "keyVaultName": "vault"}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, existing, client.EncryptionAtRest.AzureKeyVault)
+	if assert.NotNil(t, client.Clusters["instance"]) {
+		assert.Equal(t, "AZURE", client.Clusters["instance"].EncryptionAtRestProvider)
+	}
+}
+
+func TestProvisionEncryptionAtRestConflict(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	existing := &atlas.AWSKMS{Enabled: true, CustomerMasterKeyID: "other", Region: "US_EAST_1", RoleID: "role"}
+	client.EncryptionAtRest.AWSKMS = existing
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testAWSEncryptionParams),
+	}, true)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "encryption-at-rest-conflict", failure.LoggerAction())
+	}
+
+	assert.Equal(t, existing, client.EncryptionAtRest.AWSKMS)
+	assert.Nil(t, client.Clusters["instance"], "Expected no cluster to be created")
+}
+
+func TestProvisionEncryptionAtRestOtherProviderEnabled(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	// Keys of other providers don't get in the way.
+	client.EncryptionAtRest.GoogleCloudKMS = &atlas.GoogleCloudKMS{Enabled: true, KeyVersionResourceID: "key"}
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(testAWSEncryptionParams),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.True(t, client.EncryptionAtRest.AWSKMS.Enabled)
+	assert.True(t, client.EncryptionAtRest.GoogleCloudKMS.Enabled)
+}
@@ -0,0 +1,182 @@
+package broker
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// AnyInstanceSize is the instance size of DurationEstimates applying to all
+// sizes without an estimate of their own.
+const AnyInstanceSize = "*"
+
+// estimateSamples is how many completed operations of an instance size and
+// region are averaged before the average replaces the configured estimate.
+const estimateSamples = 3
+
+// estimateSmoothing is the weight of the latest completed operation in the
+// moving average.
+const estimateSmoothing = 0.3
+
+// DurationEstimate is the usual duration of an operation in minutes.
+type DurationEstimate struct {
+	MinMinutes int `json:"minMinutes"`
+	MaxMinutes int `json:"maxMinutes"`
+}
+
+// DurationEstimates holds the estimates of operations, such as "provision",
+// by instance size. AnyInstanceSize applies to the sizes not listed.
+type DurationEstimates map[string]map[string]DurationEstimate
+
+// DefaultDurationEstimates are rough durations of Atlas operations. Shared
+// clusters are created within minutes while dedicated ones take longer.
+var DefaultDurationEstimates = DurationEstimates{
+	OperationProvision: {
+		InstanceSizeNameM0: {MinMinutes: 1, MaxMinutes: 5},
+		InstanceSizeNameM2: {MinMinutes: 1, MaxMinutes: 5},
+		InstanceSizeNameM5: {MinMinutes: 1, MaxMinutes: 5},
+		AnyInstanceSize:    {MinMinutes: 7, MaxMinutes: 15},
+	},
+	OperationDeprovision: {
+		AnyInstanceSize: {MinMinutes: 5, MaxMinutes: 10},
+	},
+}
+
+// validate checks that all estimates are for instance operations and have a
+// sensible range.
+func (e DurationEstimates) validate() error {
+	for operation, sizes := range e {
+		if operation != OperationProvision && operation != OperationUpdate && operation != OperationDeprovision {
+			return fmt.Errorf(`duration estimates: unknown operation "%s"`, operation)
+		}
+
+		for size, estimate := range sizes {
+			if estimate.MinMinutes <= 0 || estimate.MaxMinutes < estimate.MinMinutes {
+				return fmt.Errorf("duration estimates: %s of %s must have positive minutes with minMinutes not above maxMinutes", operation, size)
+			}
+		}
+	}
+
+	return nil
+}
+
+// estimateKey identifies the operations whose durations are averaged.
+type estimateKey struct {
+	operation    string
+	instanceSize string
+	region       string
+}
+
+// durationEstimator describes how long operations in progress usually take.
+// Its moving averages are kept in memory and start over when the broker
+// restarts. Estimates are only shown to users and never affect timeouts.
+type durationEstimator struct {
+	estimates DurationEstimates
+
+	mu       sync.Mutex
+	averages map[estimateKey]*movingAverage
+
+	// pending remembers the cluster of operations in progress, deprovisions
+	// complete once it's gone.
+	pending map[string]estimateKey
+}
+
+type movingAverage struct {
+	samples int
+	minutes float64
+}
+
+func newDurationEstimator(estimates DurationEstimates) *durationEstimator {
+	return &durationEstimator{
+		estimates: estimates,
+		averages:  map[estimateKey]*movingAverage{},
+		pending:   map[string]estimateKey{},
+	}
+}
+
+// clusterEstimateKey returns the key of an operation on a cluster.
+func clusterEstimateKey(operation string, cluster *atlas.Cluster) estimateKey {
+	summary := summarizeCluster(cluster, "")
+	return estimateKey{
+		operation:    operation,
+		instanceSize: summary.InstanceSize,
+		region:       summary.RegionName,
+	}
+}
+
+// describe returns the estimate of an operation on a cluster, empty if there
+// is none.
+func (e *durationEstimator) describe(operation string, cluster *atlas.Cluster) string {
+	if cluster == nil {
+		return ""
+	}
+
+	key := clusterEstimateKey(operation, cluster)
+	target := key.instanceSize
+	if target != "" && key.region != "" {
+		target += " in " + key.region
+	}
+
+	e.mu.Lock()
+	average := e.averages[key]
+	e.mu.Unlock()
+
+	if average != nil && average.samples >= estimateSamples {
+		return fmt.Sprintf("Estimate: typically about %d minutes for %s, based on recent %ss", int(math.Ceil(average.minutes)), target, operation)
+	}
+
+	estimate, ok := e.estimates[operation][key.instanceSize]
+	if !ok {
+		estimate, ok = e.estimates[operation][AnyInstanceSize]
+	}
+
+	if !ok {
+		return ""
+	}
+
+	if target == "" {
+		return fmt.Sprintf("Estimate: typically %d–%d minutes", estimate.MinMinutes, estimate.MaxMinutes)
+	}
+
+	return fmt.Sprintf("Estimate: typically %d–%d minutes for %s", estimate.MinMinutes, estimate.MaxMinutes, target)
+}
+
+// observe follows the polls of an operation and adds its duration to the
+// moving average once it succeeded.
+func (e *durationEstimator) observe(instanceID string, operation OperationData, cluster *atlas.Cluster, resp brokerapi.LastOperation, now time.Time) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	key, known := e.pending[instanceID]
+	if cluster != nil {
+		key, known = clusterEstimateKey(operation.Operation, cluster), true
+	}
+
+	if resp.State == brokerapi.InProgress {
+		if known {
+			e.pending[instanceID] = key
+		}
+		return
+	}
+
+	delete(e.pending, instanceID)
+
+	startedAt, ok := operation.startedAt()
+	if !known || !ok || resp.State != brokerapi.Succeeded || key.operation != operation.Operation {
+		return
+	}
+
+	minutes := now.Sub(startedAt).Minutes()
+	average := e.averages[key]
+	if average == nil {
+		average = &movingAverage{minutes: minutes}
+		e.averages[key] = average
+	}
+
+	average.samples++
+	average.minutes += estimateSmoothing * (minutes - average.minutes)
+}
@@ -0,0 +1,92 @@
+package broker
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestDurationEstimatorDescribe(t *testing.T) {
+	estimator := newDurationEstimator(DefaultDurationEstimates)
+	cluster := func(size string, region string) *atlas.Cluster {
+		return &atlas.Cluster{ProviderSettings: &atlas.ProviderSettings{InstanceSizeName: size, RegionName: region}}
+	}
+
+	assert.Equal(t, "Estimate: typically 7–15 minutes for M10 in EU_WEST_1", estimator.describe(OperationProvision, cluster("M10", "EU_WEST_1")))
+	assert.Equal(t, "Estimate: typically 1–5 minutes for M2", estimator.describe(OperationProvision, cluster("M2", "")))
+	assert.Equal(t, "Estimate: typically 5–10 minutes for M30 in US_EAST_1", estimator.describe(OperationDeprovision, cluster("M30", "US_EAST_1")))
+	assert.Empty(t, estimator.describe(OperationUpdate, cluster("M10", "EU_WEST_1")))
+	assert.Empty(t, estimator.describe(OperationProvision, nil))
+}
+
+func TestDurationEstimatorAverage(t *testing.T) {
+	estimator := newDurationEstimator(DefaultDurationEstimates)
+	now := testTime
+	cluster := &atlas.Cluster{ProviderSettings: &atlas.ProviderSettings{InstanceSizeName: "M10", RegionName: "EU_WEST_1"}}
+
+	for i := 0; i < estimateSamples; i++ {
+		instanceID := fmt.Sprintf("instance-%d", i)
+		operation := OperationData{Operation: OperationDeprovision, StartedAt: now.Format(time.RFC3339)}
+		now = now.Add(20 * time.Minute)
+
+		// The cluster is gone once the deprovision succeeded.
+		estimator.observe(instanceID, operation, cluster, brokerapi.LastOperation{State: brokerapi.InProgress}, now)
+		estimator.observe(instanceID, operation, nil, brokerapi.LastOperation{State: brokerapi.Succeeded}, now)
+	}
+
+	assert.Equal(t, "Estimate: typically about 20 minutes for M10 in EU_WEST_1, based on recent deprovisions", estimator.describe(OperationDeprovision, cluster))
+	assert.Equal(t, "Estimate: typically 7–15 minutes for M10 in EU_WEST_1", estimator.describe(OperationProvision, cluster))
+	assert.Empty(t, estimator.pending)
+}
+
+func TestLastOperationEstimate(t *testing.T) {
+	broker, client, ctx := setupTest(WithOperationTimeout(time.Hour))
+
+	provision := func(instanceID string) brokerapi.PollDetails {
+		spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			PlanID:    testPlanID,
+			ServiceID: testServiceID,
+		}, true)
+		assert.NoError(t, err)
+		return brokerapi.PollDetails{OperationData: spec.OperationData}
+	}
+
+	// Completed provisions replace the configured estimate.
+	for i := 0; i < estimateSamples; i++ {
+		instanceID := fmt.Sprintf("instance-%d", i)
+		poll := provision(instanceID)
+
+		testClock(broker).Advance(9 * time.Minute)
+		resp, _ := broker.LastOperation(ctx, instanceID, poll)
+		assert.Contains(t, resp.Description, "Estimate: typically 7–15 minutes for M10")
+
+		testClock(broker).Advance(time.Minute)
+		client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+		resp, _ = broker.LastOperation(ctx, instanceID, poll)
+		assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), resp.State)
+	}
+
+	poll := provision("instance")
+	testClock(broker).Advance(time.Minute)
+	resp, err := broker.LastOperation(ctx, "instance", poll)
+	assert.NoError(t, err)
+	assert.Equal(t, "The provision has been running for 1m0s. Estimate: typically about 10 minutes for M10, based on recent provisions", resp.Description)
+
+	// Estimates don't affect the timeout.
+	testClock(broker).Advance(59 * time.Minute)
+	resp, _ = broker.LastOperation(ctx, "instance", poll)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.InProgress), resp.State)
+}
+
+func TestWithDurationEstimatesInvalid(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithDurationEstimates(DurationEstimates{"bind": {AnyInstanceSize: {MinMinutes: 1, MaxMinutes: 2}}}))
+	assert.EqualError(t, err, `duration estimates: unknown operation "bind"`)
+
+	_, err = New(zap.NewNop().Sugar(), WithDurationEstimates(DurationEstimates{OperationProvision: {"M10": {MinMinutes: 5, MaxMinutes: 2}}}))
+	assert.Error(t, err)
+}
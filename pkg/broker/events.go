@@ -0,0 +1,301 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// DefaultEventBufferSize is the number of events kept for Events unless
+// WithEventBufferSize is passed.
+const DefaultEventBufferSize = 1000
+
+// DefaultEventsPageSize is the number of events returned per page unless
+// EventOptions.Limit is set, MaxEventsPageSize is the most allowed.
+const (
+	DefaultEventsPageSize = 100
+	MaxEventsPageSize     = 500
+)
+
+// MaxEventsWait is the longest Events waits for new events.
+const MaxEventsWait = 60 * time.Second
+
+// The types of Events.
+const (
+	EventStarted   = "started"
+	EventSucceeded = "succeeded"
+	EventFailed    = "failed"
+)
+
+// Event is a state transition of an instance or binding observed by the
+// broker. Synchronous operations only have a succeeded or failed event.
+// Asynchronous operations have a started event first and finish once a poll
+// of the platform sees them complete.
+type Event struct {
+	// ID increases by one with every event of the broker process, across
+	// all projects.
+	ID uint64 `json:"id"`
+
+	// Type is EventStarted, EventSucceeded or EventFailed.
+	Type string `json:"type"`
+
+	// Operation is "provision", "update", "deprovision", "bind" or
+	// "unbind". BindingID is only set for the latter two.
+	Operation  string `json:"operation"`
+	InstanceID string `json:"instanceId"`
+	BindingID  string `json:"bindingId,omitempty"`
+
+	ServiceID   string `json:"serviceId,omitempty"`
+	PlanID      string `json:"planId,omitempty"`
+	ClusterName string `json:"clusterName,omitempty"`
+
+	Time time.Time `json:"time"`
+
+	// Description holds the error of failed operations.
+	Description string `json:"description,omitempty"`
+
+	// groupID is the Atlas project the event belongs to, only requests
+	// for that project see it.
+	groupID string
+}
+
+// EventOptions control the result of Events.
+type EventOptions struct {
+	// Cursor returns the events following a previous page. All events
+	// still kept are returned if it's empty.
+	Cursor string
+
+	// Limit is the largest number of events returned,
+	// DefaultEventsPageSize if zero.
+	Limit int
+
+	// Wait keeps the request open for up to this long until an event
+	// follows the cursor, at most MaxEventsWait. Events returns right away
+	// if it's zero.
+	Wait time.Duration
+}
+
+// EventPage is the result of Events.
+type EventPage struct {
+	// Events are sorted by ID.
+	Events []Event `json:"events"`
+
+	// Cursor is passed to the next call to continue after the events of
+	// this page, even if it's empty. Cursors are opaque and only valid
+	// for the broker process which returned them.
+	Cursor string `json:"cursor"`
+
+	// Truncated is set if events following the passed cursor were dropped
+	// from the buffer, or the broker restarted since it was returned.
+	Truncated bool `json:"truncated"`
+}
+
+// eventLog keeps the latest events in a ring buffer. Cursors start with the
+// epoch of the process, event IDs start over when it restarts.
+type eventLog struct {
+	mu sync.Mutex
+
+	epoch  string
+	events []Event
+	start  int
+	count  int
+	nextID uint64
+
+	// changed is closed and replaced whenever an event is added, so
+	// readers can wait for new events.
+	changed chan struct{}
+}
+
+// newEventLog returns an empty log keeping up to size events.
+func newEventLog(size int) *eventLog {
+	return &eventLog{
+		epoch:   strconv.FormatInt(time.Now().UnixNano(), 36),
+		events:  make([]Event, size),
+		nextID:  1,
+		changed: make(chan struct{}),
+	}
+}
+
+// add assigns the next ID to an event and keeps it, replacing the oldest
+// event if the buffer is full.
+func (l *eventLog) add(event Event) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	event.ID = l.nextID
+	l.nextID++
+
+	if l.count < len(l.events) {
+		l.events[(l.start+l.count)%len(l.events)] = event
+		l.count++
+	} else {
+		l.events[l.start] = event
+		l.start = (l.start + 1) % len(l.events)
+	}
+
+	close(l.changed)
+	l.changed = make(chan struct{})
+}
+
+// read returns up to limit events of a project with IDs above after. last is
+// the ID to continue after, which skips the events of other projects as
+// well. truncated is set if events following after have been dropped. The
+// returned channel is closed once another event is added.
+func (l *eventLog) read(groupID string, after uint64, limit int) (events []Event, last uint64, truncated bool, changed <-chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	oldest := l.nextID - uint64(l.count)
+	truncated = after+1 < oldest
+
+	last = after
+	if last < oldest-1 {
+		last = oldest - 1
+	}
+
+	events = []Event{}
+	for i := 0; i < l.count; i++ {
+		event := l.events[(l.start+i)%len(l.events)]
+		if event.ID <= after {
+			continue
+		}
+
+		if len(events) == limit {
+			break
+		}
+
+		last = event.ID
+		if event.groupID == groupID {
+			events = append(events, event)
+		}
+	}
+
+	return events, last, truncated, l.changed
+}
+
+// cursor returns the cursor continuing after an event ID.
+func (l *eventLog) cursor(after uint64) string {
+	return fmt.Sprintf("%s-%d", l.epoch, after)
+}
+
+// parseCursor returns the event ID a cursor continues after. Cursors of an
+// earlier process start over at the oldest event, which is reported as
+// restarted.
+func (l *eventLog) parseCursor(cursor string) (after uint64, restarted bool, err error) {
+	if cursor == "" {
+		return 0, false, nil
+	}
+
+	i := strings.LastIndex(cursor, "-")
+	if i < 0 {
+		return 0, false, errors.New("invalid cursor")
+	}
+
+	after, err = strconv.ParseUint(cursor[i+1:], 10, 64)
+	if err != nil {
+		return 0, false, errors.New("invalid cursor")
+	}
+
+	if cursor[:i] != l.epoch {
+		return 0, true, nil
+	}
+
+	return after, false, nil
+}
+
+// recordEvent adds the event of an operation to the log served by Events.
+func (b Broker) recordEvent(event LifecycleEvent) {
+	if b.events == nil {
+		return
+	}
+
+	eventType := EventSucceeded
+	switch event.Outcome {
+	case brokerapi.InProgress:
+		eventType = EventStarted
+	case brokerapi.Failed:
+		eventType = EventFailed
+	}
+
+	b.events.add(Event{
+		Type:        eventType,
+		Operation:   event.Operation,
+		InstanceID:  event.InstanceID,
+		BindingID:   event.BindingID,
+		ServiceID:   event.ServiceID,
+		PlanID:      event.PlanID,
+		ClusterName: event.ClusterName,
+		Time:        event.Time,
+		Description: event.Description,
+		groupID:     event.GroupID,
+	})
+}
+
+// Events returns the state transitions of the instances and bindings in the
+// project of the request which the broker observed, so platforms can notify
+// users without polling every operation themselves. Only the latest events
+// are kept in memory, they're lost when the broker restarts.
+func (b Broker) Events(ctx context.Context, opts EventOptions) (*EventPage, error) {
+	if opts.Limit == 0 {
+		opts.Limit = DefaultEventsPageSize
+	}
+
+	if opts.Limit < 1 || opts.Limit > MaxEventsPageSize {
+		return nil, apiresponses.NewFailureResponse(fmt.Errorf("limit must be between 1 and %d", MaxEventsPageSize), http.StatusBadRequest, "invalid-parameters")
+	}
+
+	if opts.Wait < 0 || opts.Wait > MaxEventsWait {
+		return nil, apiresponses.NewFailureResponse(fmt.Errorf("wait must be between 0 and %d seconds", MaxEventsWait/time.Second), http.StatusBadRequest, "invalid-parameters")
+	}
+
+	if b.events == nil {
+		return nil, apiresponses.NewFailureResponse(errors.New("events are not recorded"), http.StatusNotFound, "events-disabled")
+	}
+
+	after, restarted, err := b.events.parseCursor(opts.Cursor)
+	if err != nil {
+		return nil, apiresponses.NewFailureResponse(err, http.StatusBadRequest, "invalid-parameters")
+	}
+
+	groupID := groupIDFromContext(ctx)
+	page := &EventPage{Truncated: restarted}
+
+	var expired <-chan struct{}
+	if opts.Wait > 0 {
+		waitCtx, cancel := clock.WithTimeout(ctx, b.clock, opts.Wait)
+		defer cancel()
+		expired = waitCtx.Done()
+	}
+
+	// Long polls read again whenever an event is added, and once more when
+	// the wait is over.
+	waiting := expired != nil
+	for {
+		events, last, truncated, changed := b.events.read(groupID, after, opts.Limit)
+		page.Events = events
+		page.Truncated = page.Truncated || truncated
+		after = last
+
+		if len(events) > 0 || !waiting {
+			break
+		}
+
+		select {
+		case <-changed:
+		case <-expired:
+			waiting = false
+		}
+	}
+
+	page.Cursor = b.events.cursor(after)
+	return page, nil
+}
@@ -0,0 +1,270 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// eventIDs returns the IDs of a page of events.
+func eventIDs(page *EventPage) []uint64 {
+	ids := []uint64{}
+	for _, event := range page.Events {
+		ids = append(ids, event.ID)
+	}
+
+	return ids
+}
+
+func TestEventsLifecycle(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	page, err := broker.Events(ctx, EventOptions{})
+	if assert.NoError(t, err) && assert.Len(t, page.Events, 1) {
+		assert.Equal(t, Event{
+			ID:          1,
+			Type:        EventStarted,
+			Operation:   OperationProvision,
+			InstanceID:  instanceID,
+			ServiceID:   testServiceID,
+			PlanID:      testPlanID,
+			ClusterName: instanceID,
+			Time:        testTime,
+		}, page.Events[0])
+		assert.False(t, page.Truncated)
+	}
+
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	_, err = broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: spec.OperationData})
+	assert.NoError(t, err)
+
+	_, bindErr := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: "unknown-service",
+	}, true)
+	assert.Error(t, bindErr)
+
+	page, err = broker.Events(ctx, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) && assert.Len(t, page.Events, 2) {
+		assert.Equal(t, EventSucceeded, page.Events[0].Type)
+		assert.Equal(t, OperationProvision, page.Events[0].Operation)
+
+		assert.Equal(t, EventFailed, page.Events[1].Type)
+		assert.Equal(t, OperationBind, page.Events[1].Operation)
+		assert.Equal(t, "binding", page.Events[1].BindingID)
+		assert.Equal(t, bindErr.Error(), page.Events[1].Description)
+	}
+
+	// Nothing happened since.
+	page, err = broker.Events(ctx, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Events)
+		assert.NotEmpty(t, page.Cursor)
+	}
+}
+
+func TestEventsWraparound(t *testing.T) {
+	broker, _, ctx := setupTest(WithEventBufferSize(4))
+
+	add := func(n int) {
+		for i := 0; i < n; i++ {
+			broker.recordEvent(LifecycleEvent{Operation: OperationBind, Outcome: brokerapi.Succeeded})
+		}
+	}
+
+	add(3)
+	page, err := broker.Events(ctx, EventOptions{Limit: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []uint64{1, 2}, eventIDs(page))
+
+	// The buffer wraps around without dropping events following the
+	// cursor.
+	add(3)
+	page, err = broker.Events(ctx, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{3, 4, 5, 6}, eventIDs(page))
+		assert.False(t, page.Truncated)
+	}
+	cursor := page.Cursor
+
+	// Events 7 to 12 fill the buffer twice over, 7 and 8 are dropped.
+	add(6)
+	page, err = broker.Events(ctx, EventOptions{Cursor: cursor})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{9, 10, 11, 12}, eventIDs(page))
+		assert.True(t, page.Truncated)
+	}
+
+	// The cursor stays valid while its events are dropped.
+	page, err = broker.Events(ctx, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Events)
+		assert.False(t, page.Truncated)
+	}
+
+	add(1)
+	page, err = broker.Events(ctx, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{13}, eventIDs(page))
+		assert.False(t, page.Truncated)
+	}
+
+	// Without a cursor the events still kept are returned.
+	page, err = broker.Events(ctx, EventOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{10, 11, 12, 13}, eventIDs(page))
+	}
+}
+
+func TestEventsProjects(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	for _, groupID := range []string{"group-a", "group-b", "group-a", "group-b", "group-b"} {
+		broker.recordEvent(LifecycleEvent{Operation: OperationBind, GroupID: groupID})
+	}
+
+	ctxA := context.WithValue(ctx, ContextKeyAtlasGroupID, "group-a")
+	ctxB := context.WithValue(ctx, ContextKeyAtlasGroupID, "group-b")
+
+	page, err := broker.Events(ctxA, EventOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{1, 3}, eventIDs(page))
+	}
+
+	// Pages hold up to limit events of the project, the cursor skips the
+	// events of other projects.
+	page, err = broker.Events(ctxB, EventOptions{Limit: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{2}, eventIDs(page))
+	}
+
+	page, err = broker.Events(ctxB, EventOptions{Cursor: page.Cursor, Limit: 1})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{4}, eventIDs(page))
+	}
+
+	page, err = broker.Events(ctxA, EventOptions{Cursor: page.Cursor})
+	if assert.NoError(t, err) {
+		assert.Empty(t, page.Events)
+	}
+}
+
+func TestEventsRestart(t *testing.T) {
+	broker, _, ctx := setupTest()
+	broker.recordEvent(LifecycleEvent{Operation: OperationBind})
+
+	// Cursors of another broker process start over with a note.
+	page, err := broker.Events(ctx, EventOptions{Cursor: "previous-5"})
+	if assert.NoError(t, err) {
+		assert.Equal(t, []uint64{1}, eventIDs(page))
+		assert.True(t, page.Truncated)
+	}
+}
+
+func TestEventsWait(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	page, err := broker.Events(ctx, EventOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	done := make(chan *EventPage)
+	go func() {
+		waited, err := broker.Events(ctx, EventOptions{Cursor: page.Cursor, Wait: 30 * time.Second})
+		assert.NoError(t, err)
+		done <- waited
+	}()
+
+	// The request returns as soon as there's an event.
+	for testClock(broker).Waiters() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	broker.recordEvent(LifecycleEvent{Operation: OperationBind})
+
+	select {
+	case waited := <-done:
+		assert.Equal(t, []uint64{1}, eventIDs(waited))
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the waiting request to return")
+	}
+
+	// Otherwise once the wait is over.
+	go func() {
+		waited, err := broker.Events(ctx, EventOptions{Cursor: "", Wait: 30 * time.Second, Limit: 1})
+		assert.NoError(t, err)
+		done <- waited
+	}()
+	assert.Equal(t, []uint64{1}, eventIDs(<-done))
+
+	// Timers of earlier requests are still registered with the fake clock.
+	waiters := testClock(broker).Waiters()
+	go func() {
+		cursor := broker.events.cursor(1)
+		waited, err := broker.Events(ctx, EventOptions{Cursor: cursor, Wait: 30 * time.Second})
+		assert.NoError(t, err)
+		done <- waited
+	}()
+
+	for testClock(broker).Waiters() == waiters {
+		time.Sleep(time.Millisecond)
+	}
+	testClock(broker).Advance(30 * time.Second)
+
+	select {
+	case waited := <-done:
+		assert.Empty(t, waited.Events)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the waiting request to time out")
+	}
+}
+
+func TestEventsInvalidOptions(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	invalid := []EventOptions{
+		{Limit: -1},
+		{Limit: MaxEventsPageSize + 1},
+		{Wait: -time.Second},
+		{Wait: MaxEventsWait + time.Second},
+		{Cursor: "cursor"},
+		{Cursor: "epoch-x"},
+	}
+
+	for _, opts := range invalid {
+		_, err := broker.Events(ctx, opts)
+		if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok, "%+v", opts) {
+			assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil), "%+v", opts)
+		}
+	}
+
+	// Events can be turned off.
+	broker, _, ctx = setupTest(WithEventBufferSize(0))
+	broker.recordEvent(LifecycleEvent{Operation: OperationBind})
+
+	_, err := broker.Events(ctx, EventOptions{})
+	if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok) {
+		assert.Equal(t, http.StatusNotFound, failure.ValidatedStatusCode(nil))
+	}
+
+	_, err = New(zap.NewNop().Sugar(), WithEventBufferSize(-1))
+	assert.Error(t, err)
+}
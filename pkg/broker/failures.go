@@ -0,0 +1,109 @@
+package broker
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// ErrorClass describes who is responsible for a failed operation.
+type ErrorClass string
+
+// The classes failed operations are divided into.
+const (
+	// ErrorClassUser covers requests the broker rejects, for example because
+	// of invalid parameters or unknown instances. They result in 4xx
+	// responses.
+	ErrorClassUser ErrorClass = "user"
+
+	// ErrorClassDependency covers failures of Atlas, such as 5xx and 429
+	// responses, timeouts and unexpected response bodies.
+	ErrorClassDependency ErrorClass = "dependency"
+
+	// ErrorClassInternal covers everything else, including panics. These
+	// point at bugs in the broker.
+	ErrorClassInternal ErrorClass = "internal"
+)
+
+// operationResultSuccess is the result recorded for operations which didn't
+// fail.
+const operationResultSuccess = "success"
+
+// newOperationsCounter creates the counter of broker operations by their
+// result, which is either "success" or the class of the error.
+func newOperationsCounter() *metrics.CounterVec {
+	return metrics.NewCounterVec("aosb_operations_total", "Number of broker operations by operation and result.", "operation", "result")
+}
+
+// ClassifyError determines the class of an error returned by one of the
+// broker operations.
+func ClassifyError(err error) ErrorClass {
+	switch err := err.(type) {
+	case *apiresponses.FailureResponse:
+		status := err.ValidatedStatusCode(nil)
+		if status >= 400 && status < 500 {
+			return ErrorClassUser
+		}
+
+		// Operations rejected during maintenance are intended.
+		if err.LoggerAction() == maintenanceAction {
+			return ErrorClassUser
+		}
+
+		return ErrorClassInternal
+	case *atlas.APIError:
+		if err.StatusCode == http.StatusTooManyRequests || err.StatusCode >= 500 {
+			return ErrorClassDependency
+		}
+
+		// Atlas rejects cluster settings the broker passes through from the
+		// user parameters.
+		return ErrorClassUser
+	case *atlas.DecodeError:
+		return ErrorClassDependency
+	case net.Error:
+		return ErrorClassDependency
+	}
+
+	switch err {
+	case context.DeadlineExceeded:
+		return ErrorClassDependency
+	case atlas.ErrUnauthorized, atlas.ErrPlanIDNotFound,
+		atlas.ErrClusterNotFound, atlas.ErrClusterAlreadyExists,
+		atlas.ErrUserNotFound, atlas.ErrUserAlreadyExists:
+		return ErrorClassUser
+	}
+
+	return ErrorClassInternal
+}
+
+// observeOperation logs the outcome of an operation with its error class and
+// counts it. It has to be deferred at the start of the operation so panics
+// can be recorded, they are re-raised afterwards.
+func (b Broker) observeOperation(operation string, err *error) {
+	if r := recover(); r != nil {
+		b.logger.Errorw("Operation panicked", "operation", operation, "error_class", ErrorClassInternal, "panic", r)
+		b.operations.Inc(operation, string(ErrorClassInternal))
+		panic(r)
+	}
+
+	if *err == nil {
+		b.operations.Inc(operation, operationResultSuccess)
+		return
+	}
+
+	class := ClassifyError(*err)
+	b.operations.Inc(operation, string(class))
+
+	// User errors are expected and don't indicate a problem with the broker.
+	if class == ErrorClassUser {
+		b.logger.Infow("Operation rejected", "operation", operation, "error_class", class, "error", *err)
+		return
+	}
+
+	b.logger.Errorw("Operation failed", "operation", operation, "error_class", class, "error", *err)
+}
@@ -0,0 +1,94 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected ErrorClass
+	}{
+		{"invalid parameters", paramsToAPIError(&ValidationError{}), ErrorClassUser},
+		{"missing instance", atlasToAPIError(atlas.ErrClusterNotFound), ErrorClassUser},
+		{"invalid API key", atlasToAPIError(atlas.ErrUnauthorized), ErrorClassUser},
+		{"unsupported API version", atlasToAPIError(atlas.ErrAPIVersionUnsupported), ErrorClassUser},
+		{"async required", apiresponses.ErrAsyncRequired, ErrorClassUser},
+		{"rejected by Atlas", &atlas.APIError{StatusCode: http.StatusBadRequest, Code: "INVALID_ATTRIBUTE"}, ErrorClassUser},
+		{"Atlas unavailable", atlasToAPIError(&atlas.APIError{StatusCode: http.StatusServiceUnavailable}), ErrorClassDependency},
+		{"Atlas rate limit", &atlas.APIError{StatusCode: http.StatusTooManyRequests}, ErrorClassDependency},
+		{"invalid Atlas response", &atlas.DecodeError{Field: "diskSizeGB"}, ErrorClassDependency},
+		{"network timeout", &net.OpError{Op: "dial", Err: errors.New("i/o timeout")}, ErrorClassDependency},
+		{"context timeout", context.DeadlineExceeded, ErrorClassDependency},
+		{"internal failure response", apiresponses.NewFailureResponse(errors.New("bug"), http.StatusInternalServerError, ""), ErrorClassInternal},
+		{"unknown error", errors.New("no Atlas client in context"), ErrorClassInternal},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.expected, ClassifyError(test.err))
+		})
+	}
+}
+
+// unavailableAtlasClient fails every cluster lookup the way an overloaded
+// Atlas would.
+type unavailableAtlasClient struct {
+	MockAtlasClient
+}
+
+func (c unavailableAtlasClient) GetCluster(name string) (*atlas.Cluster, error) {
+	return nil, &atlas.APIError{StatusCode: http.StatusServiceUnavailable}
+}
+
+// panickingAtlasClient panics on every cluster lookup.
+type panickingAtlasClient struct {
+	MockAtlasClient
+}
+
+func (c panickingAtlasClient) GetCluster(name string) (*atlas.Cluster, error) {
+	panic("unexpected")
+}
+
+func TestOperationsCounted(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Provision(ctx, "invalid", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"numShards": "many"}}`),
+	}, true)
+	assert.Error(t, err)
+
+	assert.Equal(t, float64(1), broker.operations.Value("provision", "success"))
+	assert.Equal(t, float64(1), broker.operations.Value("provision", string(ErrorClassUser)))
+
+	// Atlas failures are attributed to the dependency.
+	unavailableCtx := context.WithValue(ctx, ContextKeyAtlasClient, unavailableAtlasClient{})
+	_, err = broker.GetInstance(unavailableCtx, "instance")
+	assert.Error(t, err)
+	assert.Equal(t, float64(1), broker.operations.Value("get_instance", string(ErrorClassDependency)))
+
+	// Panics are counted as internal errors and re-raised.
+	panickingCtx := context.WithValue(ctx, ContextKeyAtlasClient, panickingAtlasClient{})
+	assert.Panics(t, func() {
+		broker.LastOperation(panickingCtx, "instance", brokerapi.PollDetails{OperationData: OperationProvision})
+	})
+	assert.Equal(t, float64(1), broker.operations.Value("last_operation", string(ErrorClassInternal)))
+}
@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// The features operators can disable at deploy time. All of them are enabled
+// unless disabled with WithFeatures. Requests using a disabled feature are
+// rejected with 403 Forbidden instead of being handled differently.
+const (
+	// FeatureWarmPools allows pools of pre-created clusters. Brokers with
+	// pools configured fail to start if it's disabled.
+	FeatureWarmPools = "warmPools"
+
+	// FeatureBootstrap allows the bootstrap provision parameter.
+	FeatureBootstrap = "bootstrap"
+
+	// FeatureIPAccessList allows the ipAccessList provision parameter.
+	FeatureIPAccessList = "ipAccessList"
+
+	// FeatureMonitoringUsers allows creating and rotating monitoring users.
+	// Existing ones can still be removed.
+	FeatureMonitoringUsers = "monitoringUsers"
+
+	// FeatureX509Bindings allows bindings authenticating with certificates.
+	FeatureX509Bindings = "x509Bindings"
+
+	// FeatureSupportBundles allows collecting support bundles.
+	FeatureSupportBundles = "supportBundles"
+)
+
+// knownFeatures lists all features WithFeatures accepts.
+var knownFeatures = []string{
+	FeatureWarmPools,
+	FeatureBootstrap,
+	FeatureIPAccessList,
+	FeatureMonitoringUsers,
+	FeatureX509Bindings,
+	FeatureSupportBundles,
+}
+
+// Features returns whether each feature is enabled, it's logged at startup
+// and served by the info endpoint.
+func (b Broker) Features() map[string]bool {
+	features := map[string]bool{}
+	for _, feature := range knownFeatures {
+		features[feature] = !b.disabledFeatures[feature]
+	}
+
+	return features
+}
+
+// checkFeature returns a failure response if a feature is disabled.
+func (b Broker) checkFeature(feature string) error {
+	if !b.disabledFeatures[feature] {
+		return nil
+	}
+
+	b.logger.Infow("Rejected request using a disabled feature", "feature", feature)
+	return apiresponses.NewFailureResponse(fmt.Errorf(`feature "%s" disabled by operator`, feature), http.StatusForbidden, "feature-disabled")
+}
+
+// featureKnown returns whether a feature can be configured.
+func featureKnown(feature string) bool {
+	for _, known := range knownFeatures {
+		if feature == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedFeatures returns the names of features in alphabetical order.
+func sortedFeatures(features map[string]bool) []string {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// assertFeatureDisabled checks that a request was rejected because a feature
+// is disabled.
+func assertFeatureDisabled(t *testing.T, err error, feature string) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response for %s, got %v", feature, err) {
+		assert.Equal(t, http.StatusForbidden, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, `feature "`+feature+`" disabled by operator`, failure.Error())
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	broker, _, _ := setupTest()
+	for _, feature := range knownFeatures {
+		assert.True(t, broker.Features()[feature], feature)
+	}
+
+	broker, _, _ = setupTest(WithFeatures(map[string]bool{FeatureBootstrap: false, FeatureX509Bindings: true}))
+	assert.False(t, broker.Features()[FeatureBootstrap])
+	assert.True(t, broker.Features()[FeatureX509Bindings])
+	assert.Len(t, broker.Features(), len(knownFeatures))
+
+	_, err := New(zap.NewNop().Sugar(), WithFeatures(map[string]bool{"adoption": false}))
+	assert.Error(t, err)
+}
+
+func TestFeatureWarmPools(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithPools(testPool), WithFeatures(map[string]bool{FeatureWarmPools: false}))
+	assert.EqualError(t, err, `warm pools are configured but the feature "warmPools" is disabled`)
+
+	_, err = New(zap.NewNop().Sugar(), WithPools(testPool), WithFeatures(map[string]bool{FeatureWarmPools: true}))
+	assert.NoError(t, err)
+}
+
+// provisionWithParams provisions an instance with raw parameters.
+func provisionWithParams(broker *Broker, ctx context.Context, params string) error {
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(params),
+	}, true)
+	return err
+}
+
+func TestFeatureProvisionParams(t *testing.T) {
+	tests := []struct {
+		feature string
+		params  string
+	}{
+		{FeatureBootstrap, `{"bootstrap": {"database": "app"}}`},
+		{FeatureIPAccessList, `{"ipAccessList": [{"cidrBlock": "10.0.0.0/8"}]}`},
+		{FeatureMonitoringUsers, `{"monitoringUser": true}`},
+	}
+
+	for _, test := range tests {
+		store := WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey)
+
+		broker, client, ctx := setupTest(store, WithFeatures(map[string]bool{test.feature: false}))
+		assertFeatureDisabled(t, provisionWithParams(broker, ctx, test.params), test.feature)
+		assert.Empty(t, client.Clusters, test.feature)
+
+		broker, client, ctx = setupTest(store, WithFeatures(map[string]bool{test.feature: true}))
+		assert.NoError(t, provisionWithParams(broker, ctx, test.params), test.feature)
+		assert.NotEmpty(t, client.Clusters, test.feature)
+	}
+}
+
+func TestFeatureMonitoringUsersRemoval(t *testing.T) {
+	broker, _, ctx := setupTest(WithCredentialStore(NewMemoryCredentialStore(), testCredentialStoreKey), WithFeatures(map[string]bool{FeatureMonitoringUsers: false}))
+	provisionWithParams(broker, ctx, `{}`)
+
+	update := func(params string) error {
+		_, err := broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+			PlanID:         testPlanID,
+			ServiceID:      testServiceID,
+			RawParameters:  []byte(params),
+			PreviousValues: brokerapi.PreviousValues{PlanID: testPlanID, ServiceID: testServiceID},
+		}, true)
+		return err
+	}
+
+	// Existing monitoring users can still be removed.
+	assertFeatureDisabled(t, update(`{"rotateMonitoringUser": true}`), FeatureMonitoringUsers)
+	assert.NoError(t, update(`{"monitoringUser": false}`))
+}
+
+func TestFeatureX509Bindings(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		broker, client, ctx := setupTest(WithFeatures(map[string]bool{FeatureX509Bindings: enabled}))
+		provisionWithParams(broker, ctx, `{}`)
+
+		_, err := broker.Bind(ctx, "instance", "binding", brokerapi.BindDetails{
+			PlanID:        testPlanID,
+			ServiceID:     testServiceID,
+			RawParameters: []byte(`{"user": {"x509Type": "MANAGED"}}`),
+		}, true)
+
+		if enabled {
+			assert.NoError(t, err)
+			assert.NotNil(t, client.Users["binding"])
+		} else {
+			assertFeatureDisabled(t, err, FeatureX509Bindings)
+			assert.Nil(t, client.Users["binding"])
+		}
+	}
+}
+
+func TestFeatureSupportBundles(t *testing.T) {
+	broker, _, ctx := setupTest(WithFeatures(map[string]bool{FeatureSupportBundles: false}))
+	_, err := broker.SupportBundle(ctx, "instance")
+	assertFeatureDisabled(t, err, FeatureSupportBundles)
+
+	broker, _, ctx = setupTest(WithFeatures(map[string]bool{FeatureSupportBundles: true}))
+	_, err = broker.SupportBundle(ctx, "instance")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,173 @@
+package broker
+
+import (
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// OperationBind is the operation of LifecycleEvents of binds, which don't
+// have operation data.
+const OperationBind = "bind"
+
+// Hooks are notified about the lifecycle of instances and bindings, for
+// example to keep a CMDB or billing records up to date. Embed NopHooks to
+// only implement some of them.
+//
+// Each operation is reported once its synchronous phase is over. Operations
+// continuing asynchronously are reported as in progress at that point and
+// again once a poll of the platform sees them finish. Hooks run in their own
+// goroutine and can't affect the responses of the broker, errors are logged
+// and panics recovered.
+type Hooks interface {
+	OnProvisioned(event LifecycleEvent) error
+	OnUpdated(event LifecycleEvent) error
+	OnDeprovisioned(event LifecycleEvent) error
+	OnBound(event LifecycleEvent) error
+	OnUnbound(event LifecycleEvent) error
+}
+
+// NopHooks implements Hooks without doing anything.
+type NopHooks struct{}
+
+// OnProvisioned does nothing.
+func (NopHooks) OnProvisioned(event LifecycleEvent) error { return nil }
+
+// OnUpdated does nothing.
+func (NopHooks) OnUpdated(event LifecycleEvent) error { return nil }
+
+// OnDeprovisioned does nothing.
+func (NopHooks) OnDeprovisioned(event LifecycleEvent) error { return nil }
+
+// OnBound does nothing.
+func (NopHooks) OnBound(event LifecycleEvent) error { return nil }
+
+// OnUnbound does nothing.
+func (NopHooks) OnUnbound(event LifecycleEvent) error { return nil }
+
+// LifecycleEvent describes an operation passed to Hooks. Fields which aren't
+// known at the time of the event are empty, for example the organization
+// and space of instances created by older broker versions.
+type LifecycleEvent struct {
+	Operation  string
+	InstanceID string
+	BindingID  string
+
+	// GroupID is the Atlas project of the request.
+	GroupID string
+
+	ServiceID   string
+	PlanID      string
+	ClusterName string
+	OrgGUID     string
+	SpaceGUID   string
+
+	Time time.Time
+
+	// Outcome is "in progress" when the operation continues asynchronously,
+	// another event follows once it succeeded or failed. Description holds
+	// the error of failed operations.
+	Outcome     brokerapi.LastOperationState
+	Description string
+}
+
+// notifyHooks passes the event of an operation to the matching hook. The
+// outcome is derived from the result of the operation unless it's set
+// already.
+func (b Broker) notifyHooks(event LifecycleEvent, async bool, err error) {
+	event.Time = b.clock.Now()
+	if event.Outcome == "" {
+		switch {
+		case err != nil:
+			event.Outcome = brokerapi.Failed
+			event.Description = err.Error()
+		case async:
+			event.Outcome = brokerapi.InProgress
+		default:
+			event.Outcome = brokerapi.Succeeded
+		}
+	}
+
+	// Events are served to operators whether or not there are hooks.
+	b.recordEvent(event)
+
+	if b.hooks == nil {
+		return
+	}
+
+	var hook func(LifecycleEvent) error
+	switch event.Operation {
+	case OperationProvision:
+		hook = b.hooks.OnProvisioned
+	case OperationUpdate:
+		hook = b.hooks.OnUpdated
+	case OperationDeprovision:
+		hook = b.hooks.OnDeprovisioned
+	case OperationBind:
+		hook = b.hooks.OnBound
+	case OperationUnbind:
+		hook = b.hooks.OnUnbound
+	default:
+		return
+	}
+
+	logger := b.logger
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				logger.Errorw("Lifecycle hook panicked", "operation", event.Operation, "panic", r)
+			}
+		}()
+
+		if err := hook(event); err != nil {
+			logger.Errorw("Lifecycle hook failed", "operation", event.Operation, "error", err)
+		}
+	}()
+}
+
+// notifyOperationCompleted reports the end of an asynchronous instance
+// operation seen by LastOperation. The cluster may be nil if it doesn't exist
+// anymore.
+func (b Broker) notifyOperationCompleted(groupID string, instanceID string, operation OperationData, cluster *atlas.Cluster, resp brokerapi.LastOperation) {
+	event := LifecycleEvent{
+		Operation:   operation.Operation,
+		GroupID:     groupID,
+		InstanceID:  instanceID,
+		PlanID:      operation.PlanID,
+		ClusterName: operation.ClusterName,
+		Outcome:     resp.State,
+		Description: resp.Description,
+	}
+
+	if cluster != nil {
+		serviceID, planID := instancePlanIDs(b.idPrefix, cluster)
+		event.ServiceID = serviceID
+		if event.PlanID == "" {
+			event.PlanID = planID
+		}
+		if event.ClusterName == "" {
+			event.ClusterName = cluster.Name
+		}
+
+		metadata := InstanceMetadata(cluster)
+		event.OrgGUID = metadata.OrgGUID
+		event.SpaceGUID = metadata.SpaceGUID
+	}
+
+	if event.ClusterName == "" {
+		event.ClusterName = b.namer.ClusterName(instanceID)
+	}
+
+	b.notifyHooks(event, false, nil)
+}
+
+// operationClusterName returns the cluster an instance operation works on,
+// taken from its operation data if there is any.
+func (b Broker) operationClusterName(instanceID string, operationData string) string {
+	if name := operationFromData(operationData, nil).ClusterName; operationData != "" && name != "" {
+		return name
+	}
+
+	return b.namer.ClusterName(instanceID)
+}
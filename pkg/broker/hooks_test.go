@@ -0,0 +1,165 @@
+package broker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// channelHooks passes all events to a channel.
+type channelHooks chan LifecycleEvent
+
+func (h channelHooks) OnProvisioned(event LifecycleEvent) error   { h <- event; return nil }
+func (h channelHooks) OnUpdated(event LifecycleEvent) error       { h <- event; return nil }
+func (h channelHooks) OnDeprovisioned(event LifecycleEvent) error { h <- event; return nil }
+func (h channelHooks) OnBound(event LifecycleEvent) error         { h <- event; return nil }
+func (h channelHooks) OnUnbound(event LifecycleEvent) error       { h <- event; return nil }
+
+// panickingHooks panics when a binding is created and fails otherwise.
+type panickingHooks struct {
+	NopHooks
+}
+
+func (panickingHooks) OnProvisioned(event LifecycleEvent) error { return errors.New("hook failed") }
+func (panickingHooks) OnBound(event LifecycleEvent) error       { panic("hook panicked") }
+
+// nextEvent waits for the next event passed to the hooks.
+func nextEvent(t *testing.T, hooks channelHooks) LifecycleEvent {
+	select {
+	case event := <-hooks:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected a lifecycle event")
+		return LifecycleEvent{}
+	}
+}
+
+func TestHooksInstanceLifecycle(t *testing.T) {
+	hooks := make(channelHooks, 1)
+	broker, client, ctx := setupTest(WithHooks(hooks))
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+		SpaceGUID:        "space",
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	event := nextEvent(t, hooks)
+	assert.Equal(t, OperationProvision, event.Operation)
+	assert.Equal(t, instanceID, event.InstanceID)
+	assert.Equal(t, testPlanID, event.PlanID)
+	assert.Equal(t, instanceID, event.ClusterName)
+	assert.Equal(t, "org", event.OrgGUID)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.InProgress), event.Outcome)
+	assert.Equal(t, testTime, event.Time)
+
+	// Polls of operations in progress aren't reported.
+	_, err = broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: spec.OperationData})
+	assert.NoError(t, err)
+	assert.Empty(t, hooks)
+
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	_, err = broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: spec.OperationData})
+	assert.NoError(t, err)
+
+	event = nextEvent(t, hooks)
+	assert.Equal(t, OperationProvision, event.Operation)
+	assert.Equal(t, testServiceID, event.ServiceID)
+	assert.Equal(t, testPlanID, event.PlanID)
+	assert.Equal(t, "space", event.SpaceGUID)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), event.Outcome)
+
+	deprovisionSpec, err := broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Equal(t, OperationDeprovision, nextEvent(t, hooks).Operation)
+
+	_, err = broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: deprovisionSpec.OperationData})
+	assert.NoError(t, err)
+
+	event = nextEvent(t, hooks)
+	assert.Equal(t, OperationDeprovision, event.Operation)
+	assert.Equal(t, instanceID, event.ClusterName)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), event.Outcome)
+}
+
+func TestHooksFailedOperation(t *testing.T) {
+	hooks := make(channelHooks, 1)
+	broker, _, ctx := setupTest(WithHooks(hooks))
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    "unknown-plan",
+		ServiceID: testServiceID,
+	}, true)
+	assert.Error(t, err)
+
+	event := nextEvent(t, hooks)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Failed), event.Outcome)
+	assert.Equal(t, err.Error(), event.Description)
+}
+
+func TestHooksBindingLifecycle(t *testing.T) {
+	hooks := make(channelHooks, 1)
+	broker, _, ctx := setupTest(WithHooks(hooks))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	nextEvent(t, hooks)
+
+	bindingID := "binding"
+	_, err := broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	event := nextEvent(t, hooks)
+	assert.Equal(t, OperationBind, event.Operation)
+	assert.Equal(t, bindingID, event.BindingID)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), event.Outcome)
+
+	_, err = broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	event = nextEvent(t, hooks)
+	assert.Equal(t, OperationUnbind, event.Operation)
+	assert.Equal(t, bindingID, event.BindingID)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), event.Outcome)
+}
+
+func TestHooksPanicIsolated(t *testing.T) {
+	broker, _, ctx := setupTest(WithHooks(panickingHooks{}))
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	// Give the hooks a chance to run before the test ends.
+	time.Sleep(10 * time.Millisecond)
+}
@@ -0,0 +1,152 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// ContextKeyProvisionOutcome is the key used to store the outcome of a
+// provision in the request context, see ProvisionStatusMiddleware.
+var ContextKeyProvisionOutcome = ContextKey("provision-outcome")
+
+// provisionOutcome is filled in by Provision for ProvisionStatusMiddleware.
+type provisionOutcome struct {
+	alreadyExists bool
+}
+
+// ProvisionStatusMiddleware responds with 200 OK instead of 201 Created to
+// provisions finding an identical instance which already exists, as the OSB
+// spec requires. brokerapi can't tell the two apart so Provision reports
+// the outcome through the request context.
+func ProvisionStatusMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		outcome := &provisionOutcome{}
+		ctx := context.WithValue(r.Context(), ContextKeyProvisionOutcome, outcome)
+
+		next.ServeHTTP(&provisionStatusWriter{ResponseWriter: w, outcome: outcome}, r.WithContext(ctx))
+	})
+}
+
+// provisionStatusWriter rewrites the status of responses to provisions of
+// existing instances.
+type provisionStatusWriter struct {
+	http.ResponseWriter
+	outcome *provisionOutcome
+}
+
+func (w *provisionStatusWriter) WriteHeader(status int) {
+	if status == http.StatusCreated && w.outcome.alreadyExists {
+		status = http.StatusOK
+	}
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// markAlreadyExists records that the provision found an identical instance.
+// Contexts not passing through ProvisionStatusMiddleware are left alone.
+func markAlreadyExists(ctx context.Context) {
+	if outcome, ok := ctx.Value(ContextKeyProvisionOutcome).(*provisionOutcome); ok {
+		outcome.alreadyExists = true
+	}
+}
+
+// clustersEquivalent compares the cluster a provision requests with an
+// existing cluster of the same name. Fields Atlas populates, such as the
+// state, connection strings and replication spec IDs, are dropped from both
+// and only the fields the request sets are compared, so defaults filled in
+// by Atlas don't count as differences. Broker-owned labels are ignored, the
+// labels of the request are compared otherwise.
+func clustersEquivalent(requested *atlas.Cluster, existing *atlas.Cluster) bool {
+	wanted, err := normalizedCluster(requested)
+	if err != nil {
+		return false
+	}
+
+	actual, err := normalizedCluster(existing)
+	if err != nil {
+		return false
+	}
+
+	return jsonSubset(wanted, actual)
+}
+
+// normalizedCluster returns the JSON document of a cluster without the
+// fields populated by Atlas or the broker.
+func normalizedCluster(cluster *atlas.Cluster) (interface{}, error) {
+	normalized := *cluster
+	normalized.ID = ""
+	normalized.GroupID = ""
+	normalized.CreateDate = ""
+	normalized.MongoDBVersion = ""
+	normalized.Paused = false
+	normalized.StateName = ""
+	normalized.SrvAddress = ""
+	normalized.MongoURI = ""
+	normalized.MongoURIWithOptions = ""
+	normalized.MongoURIUpdated = ""
+	normalized.ConnectionStrings = nil
+	normalized.Links = nil
+
+	normalized.ReplicationSpecs = nil
+	for _, spec := range cluster.ReplicationSpecs {
+		spec.ID = ""
+		normalized.ReplicationSpecs = append(normalized.ReplicationSpecs, spec)
+	}
+
+	normalized.Labels = nil
+	for _, label := range cluster.Labels {
+		if !strings.HasPrefix(label.Key, LabelPrefix) {
+			normalized.Labels = append(normalized.Labels, label)
+		}
+	}
+
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return nil, err
+	}
+
+	var document interface{}
+	err = json.Unmarshal(data, &document)
+	return document, err
+}
+
+// jsonSubset returns whether every field set in the decoded JSON document
+// wanted has the same value in actual. Arrays have to be of the same length
+// and match element by element.
+func jsonSubset(wanted interface{}, actual interface{}) bool {
+	switch wanted := wanted.(type) {
+	case map[string]interface{}:
+		actual, ok := actual.(map[string]interface{})
+		if !ok {
+			return false
+		}
+
+		for key, value := range wanted {
+			if !jsonSubset(value, actual[key]) {
+				return false
+			}
+		}
+
+		return true
+	case []interface{}:
+		actual, ok := actual.([]interface{})
+		if !ok || len(actual) != len(wanted) {
+			return false
+		}
+
+		for i := range wanted {
+			if !jsonSubset(wanted[i], actual[i]) {
+				return false
+			}
+		}
+
+		return true
+	default:
+		return reflect.DeepEqual(wanted, actual)
+	}
+}
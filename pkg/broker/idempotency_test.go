@@ -0,0 +1,182 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClustersEquivalent(t *testing.T) {
+	requested := func() *atlas.Cluster {
+		return &atlas.Cluster{
+			Name:       "instance",
+			DiskSizeGB: 20,
+			ProviderSettings: &atlas.ProviderSettings{
+				ProviderName:     "AWS",
+				InstanceSizeName: "M10",
+			},
+			ReplicationSpecs: []atlas.ReplicationSpec{{
+				NumShards:     1,
+				RegionsConfig: map[string]atlas.RegionsConfig{"US_EAST_1": {ElectableNodes: 3}},
+			}},
+			Labels: []atlas.Label{{Key: "team", Value: "a"}, {Key: LabelCreatedAt, Value: "now"}},
+		}
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(existing *atlas.Cluster)
+		equivalent bool
+	}{
+		{"identical", func(existing *atlas.Cluster) {}, true},
+		{"server-populated fields", func(existing *atlas.Cluster) {
+			existing.StateName = atlas.ClusterStateIdle
+			existing.SrvAddress = "mongodb+srv://instance.mongodb.net"
+			existing.MongoDBVersion = "4.2.8"
+			existing.ReplicationSpecs[0].ID = "5e2211c17a3e5a48f5497de3"
+		}, true},
+		{"defaults filled in by Atlas", func(existing *atlas.Cluster) {
+			existing.ClusterType = atlas.ClusterTypeReplicaSet
+			existing.MongoDBMajorVersion = "4.2"
+			existing.ProviderSettings.RegionName = "US_EAST_1"
+		}, true},
+		{"other broker labels", func(existing *atlas.Cluster) {
+			existing.Labels = []atlas.Label{{Key: "team", Value: "a"}, {Key: LabelCreatedAt, Value: "earlier"}, {Key: LabelInstanceID, Value: "instance"}}
+		}, true},
+		{"different disk size", func(existing *atlas.Cluster) {
+			existing.DiskSizeGB = 40
+		}, false},
+		{"different instance size", func(existing *atlas.Cluster) {
+			existing.ProviderSettings.InstanceSizeName = "M20"
+		}, false},
+		{"different node count", func(existing *atlas.Cluster) {
+			existing.ReplicationSpecs[0].RegionsConfig = map[string]atlas.RegionsConfig{"US_EAST_1": {ElectableNodes: 5}}
+		}, false},
+		{"additional zone", func(existing *atlas.Cluster) {
+			existing.ReplicationSpecs = append(existing.ReplicationSpecs, atlas.ReplicationSpec{NumShards: 1})
+		}, false},
+		{"different user label", func(existing *atlas.Cluster) {
+			existing.Labels = []atlas.Label{{Key: "team", Value: "b"}}
+		}, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			existing := requested()
+			test.modify(existing)
+
+			assert.Equal(t, test.equivalent, clustersEquivalent(requested(), existing))
+		})
+	}
+}
+
+func TestProvisionSameParams(t *testing.T) {
+	broker, client, _ := setupTest()
+
+	instanceID := "instance"
+	details := brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20, "backupEnabled": true}}`),
+	}
+
+	outcome := &provisionOutcome{}
+	ctx := context.WithValue(context.Background(), ContextKeyAtlasClient, client)
+	ctx = context.WithValue(ctx, ContextKeyProvisionOutcome, outcome)
+
+	_, err := broker.Provision(ctx, instanceID, details, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.False(t, outcome.alreadyExists)
+
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	// The same parameters formatted differently don't match the fingerprint
+	// but result in the same cluster.
+	details.RawParameters = []byte(`{"cluster": {"backupEnabled": true, "diskSizeGB": 20}}`)
+	spec, err := broker.Provision(ctx, instanceID, details, true)
+	assert.NoError(t, err)
+	assert.False(t, spec.IsAsync)
+	assert.True(t, outcome.alreadyExists)
+	assert.Len(t, client.Clusters, 1)
+}
+
+func TestProvisionSameParamsUnlabeled(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	details := brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}
+
+	_, err := broker.Provision(ctx, instanceID, details, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Clusters of older broker versions don't have labels.
+	client.Clusters[instanceID].Labels = nil
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	spec, err := broker.Provision(ctx, instanceID, details, true)
+	assert.NoError(t, err)
+	assert.False(t, spec.IsAsync)
+}
+
+func TestProvisionDifferentParams(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	details := brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}
+
+	_, err := broker.Provision(ctx, instanceID, details, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	details.RawParameters = []byte(`{"cluster": {"diskSizeGB": 40}}`)
+	_, err = broker.Provision(ctx, instanceID, details, true)
+	assert.Equal(t, apiresponses.ErrInstanceAlreadyExists, err)
+	assert.Equal(t, float64(20), client.Clusters[instanceID].DiskSizeGB)
+
+	// Unlabeled clusters are compared field by field as well.
+	client.Clusters[instanceID].Labels = nil
+	_, err = broker.Provision(ctx, instanceID, details, true)
+	assert.Equal(t, apiresponses.ErrInstanceAlreadyExists, err)
+}
+
+func TestProvisionStatusMiddleware(t *testing.T) {
+	handler := func(alreadyExists bool) http.Handler {
+		return ProvisionStatusMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if alreadyExists {
+				markAlreadyExists(r.Context())
+			}
+
+			w.WriteHeader(http.StatusCreated)
+		}))
+	}
+
+	req := httptest.NewRequest(http.MethodPut, "/v2/service_instances/instance", nil)
+
+	w := httptest.NewRecorder()
+	handler(false).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	w = httptest.NewRecorder()
+	handler(true).ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+}
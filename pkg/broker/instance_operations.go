@@ -4,8 +4,13 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
 
 	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
 	"github.com/pivotal-cf/brokerapi"
 	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
 )
@@ -17,6 +22,7 @@ const (
 	OperationProvision   = "provision"
 	OperationDeprovision = "deprovision"
 	OperationUpdate      = "update"
+	InstanceSizeNameM0   = "M0"
 	InstanceSizeNameM2   = "M2"
 	InstanceSizeNameM5   = "M5"
 )
@@ -24,74 +30,506 @@ const (
 // Provision will create a new Atlas cluster with the instance ID as its name.
 // The process is always async.
 func (b Broker) Provision(ctx context.Context, instanceID string, details brokerapi.ProvisionDetails, asyncAllowed bool) (spec brokerapi.ProvisionedServiceSpec, err error) {
-	b.logger.Infow("Provisioning instance", "instance_id", instanceID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID)
+	defer b.observeOperation("provision", &err)
+
+	b.logger.Infow("Provisioning instance", "details", details)
+
+	// Changes are rejected during maintenance.
+	if err = b.checkMaintenance(); err != nil {
+		return
+	}
+
+	details.ServiceID = b.currentID(details.ServiceID)
+	details.PlanID = b.currentID(details.PlanID)
+
+	// The top-level organization and space are deprecated in favour of the
+	// context, which platforms may send on its own.
+	platformCtx := platformContextFromContext(details.RawContext)
+	if details.OrganizationGUID == "" {
+		details.OrganizationGUID = platformCtx.OrganizationGUID
+	}
+	if details.SpaceGUID == "" {
+		details.SpaceGUID = platformCtx.SpaceGUID
+	}
+
+	defer func() {
+		b.notifyHooks(LifecycleEvent{
+			Operation:   OperationProvision,
+			GroupID:     groupIDFromContext(ctx),
+			InstanceID:  instanceID,
+			ServiceID:   details.ServiceID,
+			PlanID:      details.PlanID,
+			ClusterName: b.operationClusterName(instanceID, spec.OperationData),
+			OrgGUID:     details.OrganizationGUID,
+			SpaceGUID:   details.SpaceGUID,
+		}, spec.IsAsync, err)
+	}()
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
 		return
 	}
 
-	// Async needs to be supported for provisioning to work.
-	if !asyncAllowed {
+	// Bound the synchronous phase so the platform doesn't give up and retry
+	// while the broker is still waiting for Atlas. Retries of provisions cut
+	// short are recognized by retriedProvision.
+	ctx, cancel := clock.WithTimeout(ctx, b.clock, b.provisionTimeout)
+	defer cancel()
+
+	unboundedClient := client
+	client = newDeadlineClient(ctx, client)
+
+	// Async needs to be supported for provisioning to work, unless a cluster
+	// can be claimed from a warm pool.
+	if !asyncAllowed && b.pool == nil {
 		err = apiresponses.ErrAsyncRequired
 		return
 	}
 
+	// Resolve the human readable service and plan names and include them in
+	// all further logs for this operation.
+	serviceName, planName, err := resolvePlanNames(client, b.idPrefix, details.ServiceID, details.PlanID, b.allowedInstanceSizes)
+	if err != nil {
+		b.logger.Errorw("Failed to resolve service and plan", "error", err, "details", details)
+		return
+	}
+
+	if err = b.checkPlanNotHidden(details.ServiceID, details.PlanID); err != nil {
+		b.logger.Errorw("Plan is hidden", "error", err, "details", details)
+		return
+	}
+
+	if err = b.checkPlanCost(details.ServiceID, details.PlanID); err != nil {
+		b.logger.Errorw("Plan exceeds the maximum cost", "error", err, "details", details)
+		return
+	}
+	b.logger = b.logger.With("service_name", serviceName, "plan_name", planName)
+
 	// Construct a cluster definition from the instance ID, service, plan, and params.
-	cluster, err := clusterFromParams(client, instanceID, details.ServiceID, details.PlanID, details.RawParameters)
+	cluster, err := b.clusterFromParams(client, instanceID, details.ServiceID, details.PlanID, details.RawParameters, b.clusterDefaults, nil)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		return
+	}
+
+	// Enforce the quotas of the organization and space.
+	if err = b.checkPlanQuota(details.OrganizationGUID, details.SpaceGUID, planName); err != nil {
+		b.logger.Errorw("Instance exceeds quota", "error", err, "details", details)
+		return
+	}
+
+	if err = b.checkInstanceQuota(client, details.OrganizationGUID, details.SpaceGUID); err != nil {
+		b.logger.Errorw("Instance exceeds quota", "error", err, "details", details)
+		return
+	}
+
+	// Record where the instance came from so it can be traced back to the
+	// platform later on.
+	metadata := ClusterMetadata{
+		InstanceID:        instanceID,
+		OrgGUID:           details.OrganizationGUID,
+		SpaceGUID:         details.SpaceGUID,
+		RequestedBy:       requestedByFromContext(ctx),
+		ParamsFingerprint: paramsFingerprint(details.RawParameters),
+		ServiceName:       serviceName,
+		PlanName:          planName,
+		InstanceName:      instanceNameFromContext(details.RawContext),
+		Platform:          platformFromContext(details.RawContext),
+		Namespace:         platformCtx.Namespace,
+		CreatedAt:         b.clock.Now().UTC().Format(time.RFC3339),
+	}
+	setLabels(cluster, metadata.labels())
+
+	// Instances can opt out of the connection probe, which is recorded on the
+	// cluster so LastOperation can honour it.
+	skipProbe, err := skipConnectionProbeFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	if skipProbe {
+		setLabels(cluster, []atlas.Label{atlas.Label{Key: LabelSkipConnectionProbe, Value: "true"}})
+	}
+
+	// Process arguments are set once the cluster exists, so they are
+	// validated up front.
+	processArgs, err := b.processArgsFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// LastOperation holds the provision until the process arguments are
+	// applied.
+	if processArgs != nil {
+		setLabels(cluster, []atlas.Label{processArgsLabel(processArgs)})
+	}
+
+	// Access list entries are added once the cluster exists as well.
+	accessList, err := accessListFromParams(instanceID, details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	if len(accessList) > 0 {
+		if err = b.checkFeature(FeatureIPAccessList); err != nil {
+			return
+		}
+	}
+
+	// Customer-managed keys are configured on the project right before the
+	// cluster is created.
+	encryption, err := encryptionAtRestFromParams(details.RawParameters, cluster)
 	if err != nil {
-		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "instance_id", instanceID, "details", details)
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// Collections and indexes are created by LastOperation once the cluster
+	// is ready, so the spec travels in the operation data.
+	bootstrap, err := bootstrapFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	if bootstrap != nil {
+		if err = b.checkFeature(FeatureBootstrap); err != nil {
+			return
+		}
+	}
+
+	monitoringUser, err := b.monitoringUserFromParams(details.RawParameters, false)
+	if err != nil {
+		b.logger.Errorw("Couldn't create cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	followUps := provisionFollowUps{processArgs: processArgs, accessList: accessList, monitoringUser: monitoringUser}
+
+	// Clusters claimed from a pool keep their name, so retries can't rely on
+	// the creation conflicting with the cluster of the first attempt.
+	if b.pool != nil {
+		var retried *brokerapi.ProvisionedServiceSpec
+		retried, err = b.retriedProvision(ctx, client, instanceID, metadata, cluster, bootstrap, followUps)
+		if err != nil || retried != nil {
+			return b.retriedProvisionResult(ctx, retried, err)
+		}
+	}
+
+	// Pools only hold clusters with the plan's default configuration so
+	// instances with parameters are always created from scratch.
+	if b.pool != nil && emptyParams(details.RawParameters) {
+		var claimed *atlas.Cluster
+		claimed, err = b.claimPoolCluster(client, details.ServiceID, details.PlanID, cluster.Labels)
+		if err != nil {
+			b.logger.Errorw("Failed to claim warm pool cluster", "error", err)
+			err = atlasToAPIError(err)
+			return
+		}
+
+		if claimed != nil {
+			b.logger.Infow("Claimed warm pool cluster", "cluster", claimed)
+			b.replenishPoolInBackground(unboundedClient, groupIDFromContext(ctx))
+
+			if err = b.applyProcessArgs(client, claimed.Name, processArgs); err != nil {
+				return
+			}
+
+			return brokerapi.ProvisionedServiceSpec{
+				IsAsync:      false,
+				DashboardURL: client.GetDashboardURL(claimed.Name),
+			}, nil
+		}
+	}
+
+	if !asyncAllowed {
+		err = apiresponses.ErrAsyncRequired
 		return
 	}
 
+	// The cluster only uses the keys once the project has them.
+	if encryption != nil {
+		if err = b.configureEncryptionAtRest(client, encryption); err != nil {
+			return
+		}
+
+		cluster.EncryptionAtRestProvider = encryption.Provider
+	}
+
 	// Create a new Atlas cluster from the generated definition
 	resultingCluster, err := client.CreateCluster(*cluster)
+	if err == atlas.ErrClusterAlreadyExists {
+		var retried *brokerapi.ProvisionedServiceSpec
+		retried, err = b.retriedProvision(ctx, client, instanceID, metadata, cluster, bootstrap, followUps)
+		if err != nil || retried != nil {
+			return b.retriedProvisionResult(ctx, retried, err)
+		}
+
+		err = atlas.ErrClusterAlreadyExists
+	}
+
 	if err != nil {
 		b.logger.Errorw("Failed to create Atlas cluster", "error", err, "cluster", cluster)
 		err = atlasToAPIError(err)
 		return
 	}
 
-	b.logger.Infow("Successfully started Atlas creation process", "instance_id", instanceID, "cluster", resultingCluster)
+	if err = b.applyProcessArgs(client, resultingCluster.Name, processArgs); err != nil {
+		return
+	}
+
+	if err = b.applyAccessList(client, accessList); err != nil {
+		return
+	}
+
+	// Users are project-wide, so the monitoring user can be created while
+	// the cluster is still being deployed.
+	if err = b.applyMonitoringUser(ctx, client, instanceID, monitoringUser); err != nil {
+		return
+	}
+
+	b.logger.Infow("Successfully started Atlas creation process", "cluster", resultingCluster)
+
+	operationData := b.newOperationData(OperationProvision, resultingCluster.Name, details.PlanID)
+	operationData.Bootstrap = bootstrap
 
 	return brokerapi.ProvisionedServiceSpec{
 		IsAsync:       true,
-		OperationData: OperationProvision,
+		OperationData: operationData.String(),
 		DashboardURL:  client.GetDashboardURL(resultingCluster.Name),
 	}, nil
 }
 
+// retriedProvision recognizes provisions the platform retries after the
+// response to an earlier attempt got lost, for example because the
+// synchronous phase timed out after the cluster had been created. A retry
+// with the same service, plan and parameters reports the existing cluster,
+// anything else conflicts with the instance. Parameters which differ but
+// result in an equivalent cluster count as the same. Nil is returned if the
+// instance doesn't have a cluster yet. The earlier attempt may have been cut
+// short before the follow-ups, so they are applied again.
+func (b Broker) retriedProvision(ctx context.Context, client atlas.Client, instanceID string, metadata ClusterMetadata, requested *atlas.Cluster, bootstrap *Bootstrap, followUps provisionFollowUps) (*brokerapi.ProvisionedServiceSpec, error) {
+	cluster, err := b.instanceCluster(client, instanceID)
+	if err == atlas.ErrClusterNotFound {
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	existing := InstanceMetadata(cluster)
+	if existing.Labeled() && (existing.InstanceID != instanceID ||
+		existing.ServiceName != metadata.ServiceName ||
+		existing.PlanName != metadata.PlanName) {
+		return nil, atlas.ErrClusterAlreadyExists
+	}
+
+	// The fingerprint only matches parameters passed byte for byte, clusters
+	// of parameters formatted differently and of older broker versions are
+	// compared field by field instead.
+	if (!existing.Labeled() || existing.ParamsFingerprint != metadata.ParamsFingerprint) && !clustersEquivalent(requested, cluster) {
+		return nil, atlas.ErrClusterAlreadyExists
+	}
+
+	if err := b.applyFollowUps(ctx, client, instanceID, cluster.Name, followUps); err != nil {
+		return nil, err
+	}
+
+	spec := &brokerapi.ProvisionedServiceSpec{
+		DashboardURL: client.GetDashboardURL(cluster.Name),
+	}
+
+	// The provision started when the cluster was created by the earlier
+	// attempt.
+	if cluster.StateName == atlas.ClusterStateCreating {
+		spec.IsAsync = true
+		spec.OperationData = OperationData{
+			Operation:   OperationProvision,
+			ClusterName: cluster.Name,
+			StartedAt:   existing.CreatedAt,
+			Bootstrap:   bootstrap,
+		}.String()
+	}
+
+	return spec, nil
+}
+
+// provisionFollowUps are the changes a provision applies once the cluster
+// has been created.
+type provisionFollowUps struct {
+	processArgs    *atlas.ProcessArgs
+	accessList     []atlas.AccessListEntry
+	monitoringUser monitoringUserChange
+}
+
+// applyFollowUps applies the follow-ups of a retried provision. Process
+// arguments and access list entries can be applied any number of times. The
+// monitoring user is only created if the earlier attempt didn't get to store
+// its credentials, creating it again would change its password.
+func (b Broker) applyFollowUps(ctx context.Context, client atlas.Client, instanceID string, clusterName string, followUps provisionFollowUps) error {
+	if err := b.applyProcessArgs(client, clusterName, followUps.processArgs); err != nil {
+		return err
+	}
+
+	if err := b.applyAccessList(client, followUps.accessList); err != nil {
+		return err
+	}
+
+	if followUps.monitoringUser.Enabled == nil || !*followUps.monitoringUser.Enabled {
+		return nil
+	}
+
+	_, err := b.storedCredentials(ctx, instanceID, monitoringCredentialsBindingID)
+	if err == ErrCredentialsNotFound {
+		return b.createMonitoringUser(ctx, client, instanceID)
+	}
+
+	return err
+}
+
+// retriedProvisionResult converts the outcome of retriedProvision into the
+// response of Provision and logs it. Instances which are already provisioned
+// are answered with 200 OK.
+func (b Broker) retriedProvisionResult(ctx context.Context, retried *brokerapi.ProvisionedServiceSpec, err error) (brokerapi.ProvisionedServiceSpec, error) {
+	if err != nil {
+		b.logger.Errorw("Failed to check for an earlier provision", "error", err)
+		return brokerapi.ProvisionedServiceSpec{}, atlasToAPIError(err)
+	}
+
+	if !retried.IsAsync {
+		markAlreadyExists(ctx)
+	}
+
+	b.logger.Infow("Found cluster of an earlier provision", "async", retried.IsAsync)
+	return *retried, nil
+}
+
 // Update will change the configuration of an existing Atlas cluster asynchronously.
+// Only the settings implied by a changed plan and the passed params are sent
+// to Atlas, everything else is left untouched.
 func (b Broker) Update(ctx context.Context, instanceID string, details brokerapi.UpdateDetails, asyncAllowed bool) (spec brokerapi.UpdateServiceSpec, err error) {
-	b.logger.Infow("Updating instance", "instance_id", instanceID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID)
+	defer b.observeOperation("update", &err)
+
+	b.logger.Infow("Updating instance", "details", details)
+
+	// Changes are rejected during maintenance.
+	if err = b.checkMaintenance(); err != nil {
+		return
+	}
+
+	details.ServiceID = b.currentID(details.ServiceID)
+	details.PlanID = b.currentID(details.PlanID)
+	details.PreviousValues.ServiceID = b.currentID(details.PreviousValues.ServiceID)
+	details.PreviousValues.PlanID = b.currentID(details.PreviousValues.PlanID)
+
+	defer func() {
+		planID := details.PlanID
+		if planID == "" {
+			planID = details.PreviousValues.PlanID
+		}
+
+		b.notifyHooks(LifecycleEvent{
+			Operation:   OperationUpdate,
+			GroupID:     groupIDFromContext(ctx),
+			InstanceID:  instanceID,
+			ServiceID:   details.ServiceID,
+			PlanID:      planID,
+			ClusterName: b.operationClusterName(instanceID, spec.OperationData),
+			OrgGUID:     details.PreviousValues.OrgID,
+			SpaceGUID:   details.PreviousValues.SpaceID,
+		}, spec.IsAsync, err)
+	}()
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
 		return
 	}
 
+	// Platforms send updates without a plan or parameters when only the
+	// context changed, for example when the instance was renamed. These don't
+	// touch the cluster configuration and complete synchronously.
+	if isContextOnlyUpdate(details) {
+		return b.updateContext(client, instanceID, details)
+	}
+
 	// Async needs to be supported for provisioning to work.
 	if !asyncAllowed {
 		err = apiresponses.ErrAsyncRequired
 		return
 	}
 
+	// Resolve the human readable service and plan names. The plan is only
+	// included if it changes, otherwise it's taken from the existing cluster
+	// below.
+	serviceName, planName, err := resolvePlanNames(client, b.idPrefix, details.ServiceID, details.PlanID, b.targetPlanAllowedSizes(details))
+	if err != nil {
+		b.logger.Errorw("Failed to resolve service and plan", "error", err, "details", details)
+		return
+	}
+
+	// Instances of hidden plans keep working, but can't change to one.
+	if details.PlanID != details.PreviousValues.PlanID {
+		if err = b.checkPlanNotHidden(details.ServiceID, details.PlanID); err != nil {
+			b.logger.Errorw("Plan is hidden", "error", err, "details", details)
+			return
+		}
+	}
+
 	// Fetch the cluster from Atlas. The Atlas API requires an instance size to
 	// be passed during updates (if there are other update to the provider, such
 	// as region). The plan is not included in the OSB call unless it has changed
 	// hence we need to fetch the current value from Atlas.
-	existingCluster, err := client.GetCluster(NormalizeClusterName(instanceID))
+	existingCluster, err := b.instanceCluster(client, instanceID)
 	if err != nil {
 		err = atlasToAPIError(err)
 		return
 	}
 
+	if planName == "" && existingCluster.ProviderSettings != nil {
+		planName = existingCluster.ProviderSettings.InstanceSizeName
+	}
+	b.logger = b.logger.With("service_name", serviceName, "plan_name", planName)
+
+	// Instances above the maximum cost keep working when the ceiling is
+	// lowered, but can't change to such a plan.
+	if planChangeRequested(existingCluster, details, planName) {
+		if err = b.checkPlanCost(details.ServiceID, details.PlanID); err != nil {
+			b.logger.Errorw("Plan exceeds the maximum cost", "error", err, "details", details)
+			return
+		}
+	}
+
 	// Construct a cluster from the instance ID, service, plan, and params.
-	cluster, err := clusterFromParams(client, instanceID, details.ServiceID, details.PlanID, details.RawParameters)
+	// Defaults only apply to new clusters but enforced settings are applied
+	// again in case they have been changed.
+	cluster, err := b.clusterFromParams(client, instanceID, details.ServiceID, details.PlanID, details.RawParameters, nil, existingCluster)
 	if err != nil {
 		return
 	}
 
+	// Clusters claimed from a warm pool have a different name.
+	cluster.Name = existingCluster.Name
+
+	// The minimum TLS protocol is re-asserted on every update.
+	processArgs, err := b.processArgsFromParams(details.RawParameters)
+	if err != nil {
+		b.logger.Errorw("Couldn't update cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
 	// Make sure the cluster provider has all the neccessary params for the
 	// Atlas API. The Atlas API requires both the provider name and instance
 	// size if the provider object is set. If they are missing we use the
@@ -106,6 +544,88 @@ func (b Broker) Update(ctx context.Context, instanceID string, details brokerapi
 		}
 	}
 
+	// Compute auto-scaling manages the instance size as long as the plan
+	// stays the same, the plan only dictates the size it started from.
+	if cluster.ProviderSettings != nil && computeAutoScalingRequested(cluster, existingCluster) && !planChangeRequested(existingCluster, details, planName) {
+		cluster.ProviderSettings.InstanceSizeName = autoScaledInstanceSize(cluster, existingCluster)
+	}
+
+	// Shrinking a cluster may fail midway if its data doesn't fit, so
+	// downgrades have to be confirmed.
+	allowDowngrade, err := allowDowngradeFromParams(details.RawParameters)
+	if err != nil {
+		err = paramsToAPIError(err)
+		return
+	}
+
+	monitoringUser, err := b.monitoringUserFromParams(details.RawParameters, true)
+	if err != nil {
+		b.logger.Errorw("Couldn't update cluster from the passed parameters", "error", err, "details", details)
+		err = paramsToAPIError(err)
+		return
+	}
+
+	// Determine which plan the instance is moving from and to. This also
+	// verifies the previous plan sent by the platform against Atlas.
+	transition, err := b.planTransition(client, instanceID, existingCluster, cluster, details)
+	if err != nil {
+		return
+	}
+
+	if err = checkSharedTierChange(transition); err != nil {
+		b.logger.Errorw("Unsupported plan change", "error", err, "details", details)
+		return
+	}
+
+	if err = b.checkDowngrade(transition, existingCluster, cluster, allowDowngrade); err != nil {
+		b.logger.Errorw("Refused instance size downgrade", "error", err, "details", details)
+		return
+	}
+
+	// Atlas replaces all labels when they are included in an update. Carry
+	// over the broker-owned labels so users can only change their own, and
+	// keep the plan and instance name labels in sync.
+	planChanged := transition.From != transition.To
+	instanceName, renamed := renamedInstance(existingCluster, details)
+	if cluster.Labels != nil || planChanged || renamed || processArgs != nil {
+		if cluster.Labels == nil {
+			cluster.Labels = append([]atlas.Label{}, existingCluster.Labels...)
+		}
+
+		setLabels(cluster, brokerLabels(existingCluster))
+
+		if planChanged {
+			setLabels(cluster, []atlas.Label{atlas.Label{Key: LabelPlanName, Value: planName}})
+		}
+
+		if renamed {
+			setLabels(cluster, []atlas.Label{atlas.Label{Key: LabelInstanceName, Value: instanceName}})
+		}
+
+		// LastOperation holds the update until the process arguments are
+		// applied.
+		if processArgs != nil {
+			setLabels(cluster, []atlas.Label{processArgsLabel(processArgs)})
+		}
+	}
+
+	b.logger.Infow("Resolved plan transition", "from", transition.From, "to", transition.To)
+
+	// The new plan has to be allowed for the organization and space of the
+	// instance. Unlabeled clusters fall back to the values sent by the
+	// platform.
+	if planChanged {
+		orgGUID, spaceGUID := instanceOrgAndSpace(existingCluster, details.PreviousValues)
+		if err = b.checkPlanQuota(orgGUID, spaceGUID, planName); err != nil {
+			b.logger.Errorw("Plan exceeds quota", "error", err, "details", details)
+			return
+		}
+	}
+
+	if drift := tierDriftForCluster(existingCluster); drift != nil {
+		b.logger.Warnw("Cluster instance size differs from its plan", "plan_tier", drift.PlanTier, "actual_tier", drift.ActualTier, "auto_scaling", drift.AutoScaling)
+	}
+
 	resultingCluster, err := client.UpdateCluster(*cluster)
 	if err != nil {
 		b.logger.Errorw("Failed to update Atlas cluster", "error", err, "cluster", cluster)
@@ -113,18 +633,297 @@ func (b Broker) Update(ctx context.Context, instanceID string, details brokerapi
 		return
 	}
 
-	b.logger.Infow("Successfully started Atlas cluster update process", "instance_id", instanceID, "cluster", resultingCluster)
+	if err = b.applyProcessArgs(client, resultingCluster.Name, processArgs); err != nil {
+		return
+	}
+
+	if err = b.applyMonitoringUser(ctx, client, instanceID, monitoringUser); err != nil {
+		return
+	}
+
+	b.logger.Infow("Successfully started Atlas cluster update process", "cluster", resultingCluster)
+
+	// Only plan changes record the target plan.
+	targetPlanID := ""
+	if planChangeRequested(existingCluster, details, planName) {
+		targetPlanID = details.PlanID
+	}
 
 	return brokerapi.UpdateServiceSpec{
 		IsAsync:       true,
-		OperationData: OperationUpdate,
+		OperationData: b.newOperationData(OperationUpdate, resultingCluster.Name, targetPlanID).String(),
 		DashboardURL:  client.GetDashboardURL(resultingCluster.Name),
 	}, nil
 }
 
+// planChangeRequested returns whether an update moves the instance to another
+// plan. Clusters without a plan label are compared with the previous plan sent
+// by the platform.
+func planChangeRequested(existing *atlas.Cluster, details brokerapi.UpdateDetails, planName string) bool {
+	if details.PlanID == "" {
+		return false
+	}
+
+	if current := InstanceMetadata(existing).PlanName; current != "" {
+		return current != planName
+	}
+
+	return details.PreviousValues.PlanID != "" && details.PreviousValues.PlanID != details.PlanID
+}
+
+// targetPlanAllowedSizes returns the allowed instance sizes an update's plan
+// is checked against. Updates which don't change the plan are accepted even
+// if the plan isn't allowed anymore.
+func (b Broker) targetPlanAllowedSizes(details brokerapi.UpdateDetails) []string {
+	if details.PlanID == details.PreviousValues.PlanID {
+		return nil
+	}
+
+	return b.allowedInstanceSizes
+}
+
+// instanceOrgAndSpace returns the platform organization and space of an
+// instance. They're taken from the labels if possible.
+func instanceOrgAndSpace(cluster *atlas.Cluster, previous brokerapi.PreviousValues) (orgGUID string, spaceGUID string) {
+	metadata := InstanceMetadata(cluster)
+	if metadata.OrgGUID != "" {
+		return metadata.OrgGUID, metadata.SpaceGUID
+	}
+
+	return previous.OrgID, previous.SpaceID
+}
+
+// isContextOnlyUpdate returns whether an update only carries a platform
+// context, without changing the plan or passing parameters.
+func isContextOnlyUpdate(details brokerapi.UpdateDetails) bool {
+	if len(details.RawContext) == 0 || !emptyParams(details.RawParameters) {
+		return false
+	}
+
+	return details.PlanID == "" || details.PlanID == details.PreviousValues.PlanID
+}
+
+// emptyParams returns whether a raw parameter document contains no settings.
+func emptyParams(rawParams json.RawMessage) bool {
+	switch strings.TrimSpace(string(rawParams)) {
+	case "", "{}", "null":
+		return true
+	default:
+		return false
+	}
+}
+
+// renamedInstance returns the instance name sent in the update context and
+// whether it differs from the one recorded on the cluster.
+func renamedInstance(cluster *atlas.Cluster, details brokerapi.UpdateDetails) (string, bool) {
+	instanceName := instanceNameFromContext(details.RawContext)
+	return instanceName, instanceName != "" && instanceName != labelValue(cluster.Labels, LabelInstanceName)
+}
+
+// updateContext handles a context-only update by refreshing the instance name
+// label of the cluster. Only the labels are sent to Atlas so the cluster
+// configuration is left untouched and no async operation is started.
+func (b Broker) updateContext(client atlas.Client, instanceID string, details brokerapi.UpdateDetails) (brokerapi.UpdateServiceSpec, error) {
+	existingCluster, err := b.instanceCluster(client, instanceID)
+	if err != nil {
+		return brokerapi.UpdateServiceSpec{}, atlasToAPIError(err)
+	}
+
+	instanceName, renamed := renamedInstance(existingCluster, details)
+	if renamed {
+		labels := append([]atlas.Label{}, existingCluster.Labels...)
+		cluster := atlas.Cluster{
+			Name:   existingCluster.Name,
+			Labels: mergeLabels(labels, []atlas.Label{atlas.Label{Key: LabelInstanceName, Value: instanceName}}),
+		}
+
+		if _, err := client.UpdateCluster(cluster); err != nil {
+			b.logger.Errorw("Failed to update Atlas cluster labels", "error", err, "cluster", cluster)
+			return brokerapi.UpdateServiceSpec{}, atlasToAPIError(err)
+		}
+
+		b.logger.Infow("Updated instance name", "instance_name", instanceName)
+	} else {
+		b.logger.Infow("Context-only update didn't change the instance")
+	}
+
+	return brokerapi.UpdateServiceSpec{
+		IsAsync:      false,
+		DashboardURL: client.GetDashboardURL(existingCluster.Name),
+	}, nil
+}
+
+// planRef identifies a plan by the provider and instance size it maps to.
+type planRef struct {
+	ProviderName     string
+	InstanceSizeName string
+}
+
+func (p planRef) String() string {
+	return fmt.Sprintf("%s/%s", p.ProviderName, p.InstanceSizeName)
+}
+
+// checkSharedTierChange rejects plan transitions between shared and dedicated
+// instance sizes, which Atlas doesn't support.
+func checkSharedTierChange(transition *planTransition) error {
+	if transition.From.ProviderName == "" || transition.To.ProviderName == "" {
+		return nil
+	}
+
+	wasShared := transition.From.ProviderName == providerNameTenant
+	isShared := transition.To.ProviderName == providerNameTenant
+
+	var err error
+	switch {
+	case wasShared && !isShared:
+		err = fmt.Errorf(`shared clusters can't be changed to the dedicated instance size "%s", create a new instance and migrate the data instead`, transition.To.InstanceSizeName)
+	case !wasShared && isShared:
+		err = fmt.Errorf(`dedicated clusters can't be changed to the shared instance size "%s"`, transition.To.InstanceSizeName)
+	default:
+		return nil
+	}
+
+	return apiresponses.NewFailureResponse(err, http.StatusBadRequest, "plan-change-not-supported")
+}
+
+// planRefForCluster returns the plan a cluster currently corresponds to.
+func planRefForCluster(cluster *atlas.Cluster) planRef {
+	if cluster.ProviderSettings == nil {
+		return planRef{}
+	}
+
+	return planRef{
+		ProviderName:     cluster.ProviderSettings.ProviderName,
+		InstanceSizeName: cluster.ProviderSettings.InstanceSizeName,
+	}
+}
+
+// TierDrift describes a cluster whose instance size differs from the size
+// implied by its plan. This usually happens due to compute auto-scaling.
+type TierDrift struct {
+	PlanTier    string `json:"planTier"`
+	ActualTier  string `json:"actualTier"`
+	AutoScaling bool   `json:"autoScaling"`
+}
+
+// String describes the drift in a form suitable for operation descriptions.
+func (d TierDrift) String() string {
+	if d.AutoScaling {
+		return fmt.Sprintf("Cluster has been auto-scaled from %s to %s", d.PlanTier, d.ActualTier)
+	}
+
+	return fmt.Sprintf("Cluster instance size %s differs from plan %s", d.ActualTier, d.PlanTier)
+}
+
+// tierDriftForCluster compares the plan recorded in the cluster's labels with
+// its actual instance size. Nil is returned if they match or the plan is
+// unknown, for example for clusters created by older versions of the broker.
+func tierDriftForCluster(cluster *atlas.Cluster) *TierDrift {
+	planName := InstanceMetadata(cluster).PlanName
+	if planName == "" || cluster.ProviderSettings == nil || cluster.ProviderSettings.InstanceSizeName == planName {
+		return nil
+	}
+
+	return &TierDrift{
+		PlanTier:    planName,
+		ActualTier:  cluster.ProviderSettings.InstanceSizeName,
+		AutoScaling: computeAutoScalingEnabled(cluster),
+	}
+}
+
+// computeAutoScalingEnabled returns whether Atlas may change the instance
+// size of a cluster on its own.
+func computeAutoScalingEnabled(cluster *atlas.Cluster) bool {
+	return cluster.AutoScaling != nil && cluster.AutoScaling.Compute != nil && cluster.AutoScaling.Compute.Enabled
+}
+
+// planTransition describes the change of plan requested by an update.
+type planTransition struct {
+	From planRef
+	To   planRef
+}
+
+// planTransition computes the plan change of an update from the existing
+// cluster to the cluster which is about to be sent to Atlas. When the platform
+// includes the previous plan it's used as the starting point, but only after
+// making sure it matches the actual cluster. A mismatch means the cluster has
+// been changed outside of the broker which is either logged or, in strict
+// mode, rejected.
+func (b Broker) planTransition(client atlas.Client, instanceID string, existing *atlas.Cluster, updated *atlas.Cluster, details brokerapi.UpdateDetails) (*planTransition, error) {
+	actual := planRefForCluster(existing)
+	transition := &planTransition{From: actual, To: actual}
+
+	if updated.ProviderSettings != nil {
+		transition.To = planRefForCluster(updated)
+	}
+
+	previous := details.PreviousValues
+	if previous.PlanID == "" {
+		return transition, nil
+	}
+
+	// The service can't change during an update so it's often left out.
+	serviceID := previous.ServiceID
+	if serviceID == "" {
+		serviceID = details.ServiceID
+	}
+
+	var previousPlan planRef
+	provider, err := findProviderByServiceID(client, b.idPrefix, serviceID)
+	if err == nil {
+		var instanceSize *atlas.InstanceSize
+		instanceSize, err = findInstanceSizeByPlanID(b.idPrefix, provider, previous.PlanID, nil)
+		if err == nil {
+			previousPlan = planRef{
+				ProviderName:     clusterProviderName(provider.Name, instanceSize.Name),
+				InstanceSizeName: instanceSize.Name,
+			}
+		}
+	}
+
+	// Compute auto-scaling changes the instance size without the platform
+	// knowing about it, this is expected and not treated as a mismatch.
+	if err == nil && previousPlan != actual && previousPlan.ProviderName == actual.ProviderName && computeAutoScalingEnabled(existing) {
+		b.logger.Warnw("Cluster has been auto-scaled away from the previous plan", "previous_plan", previousPlan, "actual_plan", actual)
+		return transition, nil
+	}
+
+	if err != nil || previousPlan != actual {
+		if b.strictPreviousValues {
+			err = fmt.Errorf(`previous plan "%s" does not match the current cluster (%s), it may have been modified outside of the broker`, previous.PlanID, actual)
+			return nil, apiresponses.NewFailureResponse(err, http.StatusUnprocessableEntity, "previous-plan-mismatch")
+		}
+
+		b.logger.Warnw("Previous plan does not match the cluster in Atlas", "previous_plan_id", previous.PlanID, "previous_plan", previousPlan, "actual_plan", actual)
+		return transition, nil
+	}
+
+	transition.From = previousPlan
+	return transition, nil
+}
+
 // Deprovision will destroy an Atlas cluster asynchronously.
 func (b Broker) Deprovision(ctx context.Context, instanceID string, details brokerapi.DeprovisionDetails, asyncAllowed bool) (spec brokerapi.DeprovisionServiceSpec, err error) {
-	b.logger.Infow("Deprovisioning instance", "instance_id", instanceID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID)
+	defer b.observeOperation("deprovision", &err)
+
+	b.logger.Infow("Deprovisioning instance", "details", details)
+
+	// Changes are rejected during maintenance.
+	if err = b.checkMaintenance(); err != nil {
+		return
+	}
+
+	defer func() {
+		b.notifyHooks(LifecycleEvent{
+			Operation:   OperationDeprovision,
+			GroupID:     groupIDFromContext(ctx),
+			InstanceID:  instanceID,
+			ServiceID:   details.ServiceID,
+			PlanID:      details.PlanID,
+			ClusterName: b.operationClusterName(instanceID, spec.OperationData),
+		}, spec.IsAsync, err)
+	}()
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
@@ -137,42 +936,207 @@ func (b Broker) Deprovision(ctx context.Context, instanceID string, details brok
 		return
 	}
 
-	err = client.DeleteCluster(NormalizeClusterName(instanceID))
+	// Clusters claimed from a warm pool are deleted like any other, their
+	// data is never recycled. They, and clusters named before a template
+	// was configured, have to be looked up first.
+	name := b.namer.ClusterName(instanceID)
+	if b.pool != nil || b.namer.template != nil {
+		var cluster *atlas.Cluster
+		cluster, err = b.instanceCluster(client, instanceID)
+		if err == nil && cluster.StateName == atlas.ClusterStateDeleted {
+			err = atlas.ErrClusterNotFound
+		}
+
+		// Clusters which are already gone are reported with 410 Gone so the
+		// platform can clean up the instance.
+		if err != nil {
+			b.logger.Errorw("Failed to get existing cluster", "error", err)
+			err = atlasToAPIError(err)
+			return
+		}
+
+		name = cluster.Name
+	}
+
+	// Entries are removed before the cluster so a failure can be retried.
+	if b.accessListCleanup {
+		if err = b.removeAccessList(client, instanceID); err != nil {
+			return
+		}
+	}
+
+	// The monitoring user goes first for the same reason.
+	if err = b.removeMonitoringUser(ctx, client, instanceID); err != nil {
+		return
+	}
+
+	err = client.DeleteCluster(name)
 	if err != nil {
-		b.logger.Errorw("Failed to delete Atlas cluster", "error", err, "instance_id", instanceID)
+		b.logger.Errorw("Failed to delete Atlas cluster", "error", err)
 		err = atlasToAPIError(err)
 		return
 	}
 
-	b.logger.Infow("Successfully started Atlas cluster deletion process", "instance_id", instanceID)
+	b.logger.Infow("Successfully started Atlas cluster deletion process")
 
 	return brokerapi.DeprovisionServiceSpec{
 		IsAsync:       true,
-		OperationData: OperationDeprovision,
+		OperationData: b.newOperationData(OperationDeprovision, name, "").String(),
 	}, nil
 }
 
-// GetInstance is currently not supported as specified by the
-// InstancesRetrievable setting in the service catalog.
+// InstanceParameters is returned as the parameters of an instance when it's
+// fetched using GetInstance.
+type InstanceParameters struct {
+	// Labels contains all labels of the cluster, both the ones set by users
+	// and the broker-owned ones.
+	Labels []atlas.Label `json:"labels"`
+
+	// Metadata is the decoded form of the broker-owned labels.
+	Metadata ClusterMetadata `json:"metadata"`
+
+	// TierDrift is set if the instance size differs from the plan.
+	TierDrift *TierDrift `json:"tierDrift,omitempty"`
+
+	// Cluster summarizes the cluster for dashboards.
+	Cluster ClusterSummary `json:"cluster"`
+}
+
+// ClusterSummary is the non-secret subset of a cluster shown on dashboards.
+// It must never contain usernames, passwords or connection strings, only
+// the host names needed to build them.
+type ClusterSummary struct {
+	SrvHost        string `json:"srvHost,omitempty"`
+	MongoDBVersion string `json:"mongoDBVersion,omitempty"`
+	ProviderName   string `json:"providerName,omitempty"`
+	RegionName     string `json:"regionName,omitempty"`
+	InstanceSize   string `json:"instanceSize,omitempty"`
+	State          string `json:"state,omitempty"`
+	Paused         bool   `json:"paused"`
+	DashboardURL   string `json:"dashboardUrl,omitempty"`
+}
+
+// summarizeCluster assembles the summary of a cluster. Only the host of the
+// SRV address is kept in case Atlas ever includes anything else.
+func summarizeCluster(cluster *atlas.Cluster, dashboardURL string) ClusterSummary {
+	summary := ClusterSummary{
+		MongoDBVersion: cluster.MongoDBVersion,
+		State:          cluster.StateName,
+		Paused:         cluster.Paused,
+		DashboardURL:   dashboardURL,
+	}
+
+	if srv, err := url.Parse(cluster.SrvAddress); err == nil {
+		summary.SrvHost = srv.Hostname()
+	}
+
+	if cluster.ProviderSettings != nil {
+		// Shared clusters report the provider backing them.
+		summary.ProviderName = cluster.ProviderSettings.ProviderName
+		if cluster.ProviderSettings.BackingProviderName != "" {
+			summary.ProviderName = cluster.ProviderSettings.BackingProviderName
+		}
+
+		summary.RegionName = cluster.ProviderSettings.RegionName
+		summary.InstanceSize = cluster.ProviderSettings.InstanceSizeName
+	}
+
+	return summary
+}
+
+// GetInstance will fetch the cluster backing an instance. The service and
+// plan are derived from the cluster's provider settings.
 func (b Broker) GetInstance(ctx context.Context, instanceID string) (spec brokerapi.GetInstanceDetailsSpec, err error) {
-	b.logger.Infow("Fetching instance", "instance_id", instanceID)
-	err = brokerapi.NewFailureResponse(fmt.Errorf("Unknown instance ID %s", instanceID), 404, "get-instance")
+	b.logger = b.logger.With("instance_id", instanceID)
+	defer b.observeOperation("get_instance", &err)
+
+	b.logger.Infow("Fetching instance")
+
+	client, err := atlasClientFromContext(ctx)
+	if err != nil {
+		return
+	}
+
+	cluster, err := b.instanceCluster(client, instanceID)
+	if err != nil {
+		b.logger.Errorw("Failed to get existing cluster", "error", err)
+		err = atlasToAPIError(err)
+		return
+	}
+
+	// Clusters created by older versions of the broker have no labels.
+	labels := cluster.Labels
+	if labels == nil {
+		labels = []atlas.Label{}
+	}
+
+	metadata := InstanceMetadata(cluster)
+	dashboardURL := client.GetDashboardURL(cluster.Name)
+	spec = brokerapi.GetInstanceDetailsSpec{
+		DashboardURL: dashboardURL,
+		Parameters: InstanceParameters{
+			Labels:    labels,
+			Metadata:  metadata,
+			TierDrift: tierDriftForCluster(cluster),
+			Cluster:   summarizeCluster(cluster, dashboardURL),
+		},
+	}
+
+	spec.ServiceID, spec.PlanID = instancePlanIDs(b.idPrefix, cluster)
+
 	return
 }
 
+// instancePlanIDs returns the service and plan IDs of the plan a cluster
+// belongs to. The plan is taken from the labels if possible as the actual
+// instance size may have been changed by auto-scaling. Both IDs are empty if
+// the cluster has no provider settings.
+func instancePlanIDs(idPrefix string, cluster *atlas.Cluster) (serviceID string, planID string) {
+	if cluster.ProviderSettings == nil {
+		return
+	}
+
+	metadata := InstanceMetadata(cluster)
+	provider := &atlas.Provider{Name: cluster.ProviderSettings.ProviderName}
+	instanceSize := atlas.InstanceSize{Name: cluster.ProviderSettings.InstanceSizeName}
+	if metadata.PlanName != "" {
+		instanceSize.Name = metadata.PlanName
+	}
+
+	// Shared clusters provisioned through a plan of their backing provider
+	// belong to that provider's service.
+	backing := &atlas.Provider{Name: cluster.ProviderSettings.BackingProviderName}
+	if provider.Name == providerNameTenant && backing.Name != "" && metadata.ServiceName == serviceNameForProvider(backing) {
+		provider = backing
+	}
+
+	return serviceIDForProvider(idPrefix, provider), planIDForInstanceSize(idPrefix, provider, instanceSize)
+}
+
 // LastOperation should fetch the state of the provision/deprovision
-// of a cluster.
+// of a cluster. Operations fail if the cluster disappears or ends up in a
+// state the operation doesn't lead to, the description names the state.
 func (b Broker) LastOperation(ctx context.Context, instanceID string, details brokerapi.PollDetails) (resp brokerapi.LastOperation, err error) {
-	b.logger.Infow("Fetching state of last operation", "instance_id", instanceID, "details", details)
+	b.logger = b.logger.With("instance_id", instanceID)
+	defer b.observeOperation("last_operation", &err)
+
+	b.logger.Infow("Fetching state of last operation", "details", details)
 
 	client, err := atlasClientFromContext(ctx)
 	if err != nil {
 		return
 	}
 
-	cluster, err := client.GetCluster(NormalizeClusterName(instanceID))
+	// Polls are spaced out while the Atlas rate limit budget is low.
+	groupID := groupIDFromContext(ctx)
+	if cached, ok := b.rateLimits.cachedPoll(groupID, instanceID, b.clock.Now()); ok {
+		b.logger.Infow("Repeating the previous poll, the Atlas rate limit budget is low", "group_id", groupID, "state", cached.State)
+		return cached, nil
+	}
+
+	cluster, err := b.instanceCluster(client, instanceID)
 	if err != nil && err != atlas.ErrClusterNotFound {
-		b.logger.Errorw("Failed to get existing cluster", "error", err, "instance_id", instanceID)
+		b.logger.Errorw("Failed to get existing cluster", "error", err)
 		err = atlasToAPIError(err)
 		return
 	}
@@ -180,100 +1144,160 @@ func (b Broker) LastOperation(ctx context.Context, instanceID string, details br
 	b.logger.Infow("Found existing cluster", "cluster", cluster)
 
 	state := brokerapi.LastOperationState(brokerapi.Failed)
+	description := ""
+
+	// Operations started by older broker versions may use a different
+	// format or no operation data at all.
+	operationData := operationFromData(details.OperationData, cluster)
+	operation := operationData.Operation
 
-	switch details.OperationData {
-	case OperationProvision:
-		switch cluster.StateName {
-		// Provision has succeeded if the cluster is in state "idle".
-		case atlas.ClusterStateIdle:
+	switch operation {
+	case OperationProvision, OperationUpdate:
+		// Provisions wait for the cluster to be created and updates for it
+		// to be updated, repairs by Atlas are waited out as well. We assume
+		// that the cluster transitions to the "UPDATING" state in a
+		// synchronous manner during the update request.
+		pending := atlas.ClusterStateCreating
+		if operation == OperationUpdate {
+			pending = atlas.ClusterStateUpdating
+		}
+
+		switch {
+		case cluster == nil:
+			description = "The cluster doesn't exist in Atlas anymore"
+		case cluster.StateName == atlas.ClusterStateIdle:
 			state = brokerapi.Succeeded
-		case atlas.ClusterStateCreating:
+		case cluster.StateName == pending, cluster.StateName == atlas.ClusterStateRepairing:
 			state = brokerapi.InProgress
+		default:
+			description = clusterStateDescription(cluster)
 		}
 	case OperationDeprovision:
 		// The Atlas API may return a 404 response if a cluster is deleted or it
 		// will return the cluster with a state of "DELETED". Both of these
 		// scenarios indicate that a cluster has been successfully deleted.
-		if err == atlas.ErrClusterNotFound || cluster.StateName == atlas.ClusterStateDeleted {
+		switch {
+		case err == atlas.ErrClusterNotFound || cluster.StateName == atlas.ClusterStateDeleted:
 			state = brokerapi.Succeeded
-		} else if cluster.StateName == atlas.ClusterStateDeleting {
+		case cluster.StateName == atlas.ClusterStateDeleting:
 			state = brokerapi.InProgress
+		default:
+			description = clusterStateDescription(cluster)
 		}
-	case OperationUpdate:
-		// We assume that the cluster transitions to the "UPDATING" state
-		// in a synchronous manner during the update request.
-		switch cluster.StateName {
-		case atlas.ClusterStateIdle:
-			state = brokerapi.Succeeded
-		case atlas.ClusterStateUpdating:
-			state = brokerapi.InProgress
+	}
+
+	if state == brokerapi.Failed {
+		b.logger.Warnw("Cluster didn't reach the state of the operation", "operation", operation, "description", description)
+	}
+
+	// The process arguments are applied separately from the cluster, hold
+	// the operation until Atlas reports them.
+	if (operation == OperationProvision || operation == OperationUpdate) && state == brokerapi.Succeeded {
+		state, description, err = b.checkProcessArgs(client, cluster)
+		if err != nil {
+			return
 		}
 	}
 
-	return brokerapi.LastOperation{
-		State: state,
-	}, nil
-}
+	// Idle clusters might not be reachable yet, hold the provision until the
+	// connection probe passes.
+	if operation == OperationProvision && state == brokerapi.Succeeded && b.shouldProbeConnection(cluster) {
+		var probeErr error
+		state, description, probeErr = b.connectionProbe.check(ctx, b.clock, instanceID, cluster)
+		if probeErr != nil {
+			b.logger.Warnw("Cluster is not reachable yet", "error", probeErr, "state", state)
+		}
+	}
+
+	// Collections and indexes requested at provision time are created once
+	// the cluster is reachable.
+	if operation == OperationProvision && state == brokerapi.Succeeded && operationData.Bootstrap != nil {
+		state, description, err = b.runBootstrap(ctx, client, instanceID, cluster, operationData.Bootstrap)
+		if err != nil {
+			return
+		}
+	}
+
+	// Let the platform know if the cluster doesn't match its plan anymore.
+	if operation == OperationUpdate && state != brokerapi.Failed {
+		if drift := tierDriftForCluster(cluster); drift != nil {
+			description = drift.String()
+		}
+	}
+
+	resp = brokerapi.LastOperation{
+		State:       state,
+		Description: description,
+	}
 
-// NormalizeClusterName will sanitize a name to make sure it will be accepted
-// by the Atlas API. Atlas has different name length requirements depending on
-// which environment it's running in. A length of 23 is a safe choice and
-// truncates UUIDs nicely.
-func NormalizeClusterName(name string) string {
-	const maximumNameLength = 23
+	// Operations which take too long fail instead of being polled forever.
+	resp = b.checkOperationDuration(operationData, cluster, resp)
+	b.rateLimits.recordPoll(groupID, instanceID, b.clock.Now(), resp)
 
-	if len(name) > maximumNameLength {
-		return string(name[0:maximumNameLength])
+	// Completed operations refine the estimates of later ones.
+	if b.durationEstimator != nil {
+		b.durationEstimator.observe(instanceID, operationData, cluster, resp, b.clock.Now())
 	}
 
-	return name
+	if resp.State != brokerapi.InProgress {
+		b.notifyOperationCompleted(groupID, instanceID, operationData, cluster, resp)
+	}
+
+	return resp, nil
+}
+
+// clusterStateDescription reports the state of a cluster an operation
+// failed on, so operators can tell what happened from the platform.
+func clusterStateDescription(cluster *atlas.Cluster) string {
+	return fmt.Sprintf("The cluster is in state %s in Atlas", cluster.StateName)
 }
 
 // clusterFromParams will construct a cluster object from an instance ID,
 // service, plan, and raw parameters. This way users can pass all the
 // configuration available for clusters in the Atlas API as "cluster" in the params.
-func clusterFromParams(client atlas.Client, instanceID string, serviceID string, planID string, rawParams []byte) (*atlas.Cluster, error) {
-	// Set up a params object which will be used for deserialiation.
-	params := struct {
-		Cluster *atlas.Cluster `json:"cluster"`
-	}{
-		&atlas.Cluster{},
+// The broker's enforced settings are always applied, defaults only if passed.
+// Updates pass the existing cluster so its provider is kept.
+func (b Broker) clusterFromParams(client atlas.Client, instanceID string, serviceID string, planID string, rawParams []byte, defaults *atlas.Cluster, existing *atlas.Cluster) (*atlas.Cluster, error) {
+	planCtx := PlanContext{
+		InstanceID:  instanceID,
+		ClusterName: b.namer.ClusterName(instanceID),
+		Defaults:    defaults,
+		Enforced:    b.enforcedClusterSettings,
+
+		MongoDBMajorVersions: b.mongoDBMajorVersions,
 	}
 
-	// If params were passed we unmarshal them into the params object.
-	if len(rawParams) > 0 {
-		err := json.Unmarshal(rawParams, &params)
-		if err != nil {
-			return nil, err
-		}
+	// Updates stay on the provider of the existing cluster.
+	if existing != nil && existing.ProviderSettings != nil {
+		planCtx.CurrentProvider = existing.ProviderSettings.ProviderName
 	}
+	planCtx.Existing = existing
 
-	// If the plan ID is specified we construct the provider object from the service and plan.
-	// The plan ID is optional during updates but not during creation.
+	// If the plan ID is specified we resolve the provider and instance size
+	// from the service and plan. The plan ID is optional during updates but
+	// not during creation. Parameters can't override either of them, shared
+	// instance sizes have plans of their own.
 	if planID != "" {
-		if params.Cluster.ProviderSettings == nil {
-			params.Cluster.ProviderSettings = &atlas.ProviderSettings{}
+		provider, err := findProviderByServiceID(client, b.idPrefix, serviceID)
+		if err != nil {
+			return nil, err
 		}
 
-		instanceSizeName := params.Cluster.ProviderSettings.InstanceSizeName
-		if instanceSizeName != InstanceSizeNameM2 && instanceSizeName != InstanceSizeNameM5 {
-			provider, err := findProviderByServiceID(client, serviceID)
-			if err != nil {
-				return nil, err
-			}
+		// The plan has already been checked against the allowed instance
+		// sizes by the caller, instances keep working if the list changes.
+		instanceSize, err := findInstanceSizeByPlanID(b.idPrefix, provider, planID, nil)
+		if err != nil {
+			return nil, err
+		}
 
-			instanceSize, err := findInstanceSizeByPlanID(provider, planID)
-			if err != nil {
-				return nil, err
-			}
+		planCtx.Provider = provider
+		planCtx.InstanceSize = instanceSize
+	}
 
-			// Configure provider based on service and plan.
-			params.Cluster.ProviderSettings.ProviderName = provider.Name
-			params.Cluster.ProviderSettings.InstanceSizeName = instanceSize.Name
-		}
+	cluster, err := ClusterFromParams(planCtx, rawParams)
+	if err != nil {
+		return nil, paramsToAPIError(err)
 	}
 
-	// Add the instance ID as the name of the cluster.
-	params.Cluster.Name = NormalizeClusterName(instanceID)
-	return params.Cluster, nil
+	return cluster, nil
 }
@@ -0,0 +1,168 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// DefaultInstancesPageSize is the number of instances listed per page unless
+// InstanceListOptions.PageSize is set, MaxInstancesPageSize is the most
+// allowed.
+const (
+	DefaultInstancesPageSize = 100
+	MaxInstancesPageSize     = 500
+)
+
+// InstanceListOptions control the result of ListInstances.
+type InstanceListOptions struct {
+	// State only lists instances whose cluster is in a state, for example
+	// "IDLE". All instances are listed if empty.
+	State string
+
+	// Page is the page to return starting at 1, PageSize the number of
+	// instances per page. Zero values use the first page and
+	// DefaultInstancesPageSize.
+	Page     int
+	PageSize int
+}
+
+// InstanceList is the result of ListInstances. Instances are sorted by the
+// name of their cluster so pages stay stable.
+type InstanceList struct {
+	Instances []InstanceListEntry `json:"instances"`
+	Page      int                 `json:"page"`
+	PageSize  int                 `json:"pageSize"`
+	Summary   InstanceListSummary `json:"summary"`
+}
+
+// InstanceListEntry describes a single instance in an InstanceList.
+type InstanceListEntry struct {
+	// InstanceID is empty for unlabeled clusters created by older versions
+	// of the broker, which are named after a truncated instance ID.
+	InstanceID   string `json:"instanceId,omitempty"`
+	ClusterName  string `json:"clusterName"`
+	ServiceID    string `json:"serviceId,omitempty"`
+	PlanID       string `json:"planId,omitempty"`
+	ServiceName  string `json:"serviceName,omitempty"`
+	PlanName     string `json:"planName,omitempty"`
+	InstanceName string `json:"instanceName,omitempty"`
+	State        string `json:"state"`
+
+	// CreatedAt is only known for instances provisioned by broker versions
+	// recording it.
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// InstanceListSummary counts the clusters of the project. Total counts the
+// instances matching the state filter across all pages, the other fields
+// aren't affected by the filter.
+type InstanceListSummary struct {
+	Total   int `json:"total"`
+	Managed int `json:"managed"`
+	Pooled  int `json:"pooled"`
+	Foreign int `json:"foreign"`
+}
+
+// ListInstances enumerates the instances whose clusters were created by the
+// broker. Unclaimed warm pool clusters and foreign clusters aren't instances
+// and are only counted.
+func (b Broker) ListInstances(ctx context.Context, opts InstanceListOptions) (*InstanceList, error) {
+	if opts.Page == 0 {
+		opts.Page = 1
+	}
+
+	if opts.PageSize == 0 {
+		opts.PageSize = DefaultInstancesPageSize
+	}
+
+	if opts.Page < 1 {
+		return nil, apiresponses.NewFailureResponse(errors.New("page must be at least 1"), http.StatusBadRequest, "invalid-parameters")
+	}
+
+	if opts.PageSize < 1 || opts.PageSize > MaxInstancesPageSize {
+		return nil, apiresponses.NewFailureResponse(fmt.Errorf("page size must be between 1 and %d", MaxInstancesPageSize), http.StatusBadRequest, "invalid-parameters")
+	}
+
+	client, err := atlasClientFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		b.logger.Errorw("Failed to list clusters", "error", err)
+		return nil, atlasToAPIError(err)
+	}
+
+	list := &InstanceList{
+		Instances: []InstanceListEntry{},
+		Page:      opts.Page,
+		PageSize:  opts.PageSize,
+	}
+
+	matching := []InstanceListEntry{}
+	for i := range clusters {
+		cluster := &clusters[i]
+
+		switch {
+		case labelValue(cluster.Labels, LabelPoolPlan) != "":
+			list.Summary.Pooled++
+			continue
+		case !InstanceMetadata(cluster).Labeled() && !legacyClusterNamePattern.MatchString(cluster.Name):
+			list.Summary.Foreign++
+			continue
+		}
+
+		list.Summary.Managed++
+
+		if opts.State != "" && !strings.EqualFold(cluster.StateName, opts.State) {
+			continue
+		}
+
+		matching = append(matching, b.instanceListEntry(cluster))
+	}
+
+	sort.Slice(matching, func(i, j int) bool {
+		return matching[i].ClusterName < matching[j].ClusterName
+	})
+
+	list.Summary.Total = len(matching)
+
+	start := (opts.Page - 1) * opts.PageSize
+	if start < len(matching) {
+		end := start + opts.PageSize
+		if end > len(matching) {
+			end = len(matching)
+		}
+
+		list.Instances = matching[start:end]
+	}
+
+	return list, nil
+}
+
+// instanceListEntry describes the instance of a managed cluster.
+func (b Broker) instanceListEntry(cluster *atlas.Cluster) InstanceListEntry {
+	metadata := InstanceMetadata(cluster)
+
+	entry := InstanceListEntry{
+		InstanceID:   metadata.InstanceID,
+		ClusterName:  cluster.Name,
+		ServiceName:  metadata.ServiceName,
+		PlanName:     metadata.PlanName,
+		InstanceName: metadata.InstanceName,
+		State:        cluster.StateName,
+		CreatedAt:    metadata.CreatedAt,
+	}
+
+	entry.ServiceID, entry.PlanID = instancePlanIDs(b.idPrefix, cluster)
+
+	return entry
+}
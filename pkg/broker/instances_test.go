@@ -0,0 +1,80 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListInstances(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	for _, instanceID := range []string{"b-instance", "a-instance", "c-instance"} {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			ServiceID: testServiceID,
+			PlanID:    testPlanID,
+		}, true)
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	client.Clusters["c-instance"].StateName = atlas.ClusterStateIdle
+
+	client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"] = &atlas.Cluster{Name: "6b1f7a3e-2c4d-4e5f-8a9b", StateName: atlas.ClusterStateIdle}
+	client.Clusters["pool"] = &atlas.Cluster{
+		Name:   "pool",
+		Labels: []atlas.Label{{Key: LabelPoolPlan, Value: testPlanID}},
+	}
+	client.Clusters["foreign"] = &atlas.Cluster{Name: "foreign"}
+	client.Clusters["other"] = &atlas.Cluster{Name: "other"}
+
+	list, err := broker.ListInstances(ctx, InstanceListOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 1, list.Page)
+	assert.Equal(t, DefaultInstancesPageSize, list.PageSize)
+	assert.Equal(t, InstanceListSummary{Total: 4, Managed: 4, Pooled: 1, Foreign: 2}, list.Summary)
+	if assert.Len(t, list.Instances, 4) {
+		assert.Equal(t, InstanceListEntry{ClusterName: "6b1f7a3e-2c4d-4e5f-8a9b", State: atlas.ClusterStateIdle}, list.Instances[0])
+		assert.Equal(t, InstanceListEntry{
+			InstanceID:  "a-instance",
+			ClusterName: "a-instance",
+			ServiceID:   testServiceID,
+			PlanID:      testPlanID,
+			ServiceName: "mongodb-atlas-aws",
+			PlanName:    "M10",
+			State:       atlas.ClusterStateCreating,
+			CreatedAt:   testCreatedAt,
+		}, list.Instances[1])
+		assert.Equal(t, "b-instance", list.Instances[2].InstanceID)
+		assert.Equal(t, "c-instance", list.Instances[3].InstanceID)
+	}
+
+	// The state filter only affects the total.
+	list, err = broker.ListInstances(ctx, InstanceListOptions{State: "idle", PageSize: 1, Page: 2})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, InstanceListSummary{Total: 2, Managed: 4, Pooled: 1, Foreign: 2}, list.Summary)
+	if assert.Len(t, list.Instances, 1) {
+		assert.Equal(t, "c-instance", list.Instances[0].InstanceID)
+	}
+
+	list, err = broker.ListInstances(ctx, InstanceListOptions{Page: 3, PageSize: 2})
+	assert.NoError(t, err)
+	assert.Empty(t, list.Instances)
+
+	for _, opts := range []InstanceListOptions{{Page: -1}, {PageSize: -1}, {PageSize: MaxInstancesPageSize + 1}} {
+		_, err = broker.ListInstances(ctx, opts)
+		if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok, "Expected a failure response for %+v", opts) {
+			assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		}
+	}
+}
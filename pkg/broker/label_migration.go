@@ -0,0 +1,151 @@
+package broker
+
+import (
+	"context"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// LabelMigrationOptions control the behaviour of MigrateLabels.
+type LabelMigrationOptions struct {
+	// Known are the resources known to the platform. Legacy cluster names
+	// are truncated instance IDs, so clusters can only be labeled if their
+	// instance is listed.
+	Known *KnownResources
+
+	// DryRun reports the labels which would be added without changing
+	// anything.
+	DryRun bool
+}
+
+// LabelMigrationReport is the machine-readable result of MigrateLabels.
+type LabelMigrationReport struct {
+	DryRun   bool             `json:"dryRun"`
+	Clusters []LabelMigration `json:"clusters"`
+	Users    []LabelMigration `json:"users"`
+}
+
+// LabelMigration describes a single resource created by an older broker
+// version in a LabelMigrationReport. Resources which are skipped have a
+// reason and no labels.
+type LabelMigration struct {
+	Name    string        `json:"name"`
+	Labels  []atlas.Label `json:"labels,omitempty"`
+	Applied bool          `json:"applied,omitempty"`
+	Reason  string        `json:"reason,omitempty"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// MigrateLabels adds the instance and binding ID labels to the clusters and
+// users created by broker versions which didn't label resources. They're
+// identified by their legacy names, see legacyClusterNamePattern and
+// legacyUsernamePattern, everything else is left untouched. Resources which
+// are labeled already are skipped, so it can be run repeatedly.
+//
+// Legacy usernames are binding IDs which don't tell the instance, so users
+// only get the binding ID label.
+func (b Broker) MigrateLabels(ctx context.Context, opts LabelMigrationOptions) (*LabelMigrationReport, error) {
+	b.logger.Infow("Migrating labels of Atlas resources", "dry_run", opts.DryRun)
+
+	client, err := atlasClientFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := client.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &LabelMigrationReport{
+		DryRun:   opts.DryRun,
+		Clusters: []LabelMigration{},
+		Users:    []LabelMigration{},
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+		if !legacyClusterNamePattern.MatchString(cluster.Name) || labelValue(cluster.Labels, LabelInstanceID) != "" || labelValue(cluster.Labels, LabelPoolPlan) != "" {
+			continue
+		}
+
+		migration := LabelMigration{Name: cluster.Name}
+
+		instanceID := legacyInstanceID(cluster, opts.Known)
+		if instanceID == "" {
+			migration.Reason = "instance ID can't be determined from the truncated name, the instance is not known to the platform"
+			report.Clusters = append(report.Clusters, migration)
+			continue
+		}
+
+		migration.Labels = []atlas.Label{{Key: LabelInstanceID, Value: instanceID}}
+		if !opts.DryRun {
+			b.pace(groupIDFromContext(ctx))
+
+			// Only the labels are sent so the cluster configuration is
+			// left untouched.
+			labels := append([]atlas.Label{}, cluster.Labels...)
+			if _, err := client.UpdateCluster(atlas.Cluster{Name: cluster.Name, Labels: mergeLabels(labels, migration.Labels)}); err != nil {
+				b.logger.Errorw("Failed to label cluster", "error", err, "cluster_name", cluster.Name)
+				migration.Error = err.Error()
+			} else {
+				b.logger.Infow("Labeled cluster", "cluster_name", cluster.Name, "instance_id", instanceID)
+				migration.Applied = true
+			}
+		}
+
+		report.Clusters = append(report.Clusters, migration)
+	}
+
+	for _, user := range users {
+		if !legacyUsernamePattern.MatchString(user.Username) || labelValue(user.Labels, LabelBindingID) != "" {
+			continue
+		}
+
+		migration := LabelMigration{
+			Name:   user.Username,
+			Labels: []atlas.Label{{Key: LabelBindingID, Value: user.Username}},
+		}
+
+		if !opts.DryRun {
+			b.pace(groupIDFromContext(ctx))
+
+			labels := append([]atlas.Label{}, user.Labels...)
+			update := atlas.User{Username: user.Username, X509Type: user.X509Type, Labels: mergeLabels(labels, migration.Labels)}
+			if _, err := client.UpdateUser(update); err != nil {
+				b.logger.Errorw("Failed to label user", "error", err, "username", user.Username)
+				migration.Error = err.Error()
+			} else {
+				b.logger.Infow("Labeled user", "username", user.Username)
+				migration.Applied = true
+			}
+		}
+
+		report.Users = append(report.Users, migration)
+	}
+
+	return report, nil
+}
+
+// legacyInstanceID returns the ID of the instance an unlabeled cluster was
+// created for, or an empty string if it isn't known. Older broker versions
+// always named clusters using NormalizeClusterName, regardless of the cluster
+// name template configured now.
+func legacyInstanceID(cluster *atlas.Cluster, known *KnownResources) string {
+	if known == nil {
+		return ""
+	}
+
+	for _, instanceID := range known.InstanceIDs {
+		if NormalizeClusterName(instanceID) == cluster.Name {
+			return instanceID
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,114 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupLegacyResources adds the resources of an older broker version along
+// with resources which must not be touched.
+func setupLegacyResources(client MockAtlasClient) {
+	client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"] = &atlas.Cluster{
+		Name:   "6b1f7a3e-2c4d-4e5f-8a9b",
+		Labels: []atlas.Label{{Key: "team", Value: "orders"}},
+	}
+	client.Clusters["1a2b3c4d-5e6f-4a7b-8c9d"] = &atlas.Cluster{Name: "1a2b3c4d-5e6f-4a7b-8c9d"}
+	client.Clusters["labeled"] = &atlas.Cluster{
+		Name:   "labeled",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "labeled"}},
+	}
+	client.Clusters["foreign"] = &atlas.Cluster{Name: "foreign"}
+
+	client.Users[reconcileBindingID] = &atlas.User{Username: reconcileBindingID, DatabaseName: atlas.AuthDatabaseAdmin}
+	client.Users["binding"] = &atlas.User{
+		Username:     "binding",
+		DatabaseName: atlas.AuthDatabaseAdmin,
+		Labels:       []atlas.Label{{Key: LabelBindingID, Value: "binding"}},
+	}
+	client.Users["admin"] = &atlas.User{Username: "admin", DatabaseName: atlas.AuthDatabaseAdmin}
+}
+
+func TestMigrateLabels(t *testing.T) {
+	broker, client, ctx := setupTest()
+	setupLegacyResources(client)
+
+	opts := LabelMigrationOptions{Known: &KnownResources{InstanceIDs: []string{reconcileInstanceID}}}
+	report, err := broker.MigrateLabels(ctx, opts)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, report.DryRun)
+	assert.Equal(t, []LabelMigration{
+		{Name: "1a2b3c4d-5e6f-4a7b-8c9d", Reason: "instance ID can't be determined from the truncated name, the instance is not known to the platform"},
+		{Name: "6b1f7a3e-2c4d-4e5f-8a9b", Labels: []atlas.Label{{Key: LabelInstanceID, Value: reconcileInstanceID}}, Applied: true},
+	}, report.Clusters)
+	assert.Equal(t, []LabelMigration{
+		{Name: reconcileBindingID, Labels: []atlas.Label{{Key: LabelBindingID, Value: reconcileBindingID}}, Applied: true},
+	}, report.Users)
+
+	// Existing labels are kept and other resources are left untouched.
+	assert.Equal(t, []atlas.Label{{Key: "team", Value: "orders"}, {Key: LabelInstanceID, Value: reconcileInstanceID}}, client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"].Labels)
+	assert.Empty(t, client.Clusters["1a2b3c4d-5e6f-4a7b-8c9d"].Labels)
+	assert.Empty(t, client.Clusters["foreign"].Labels)
+	assert.Equal(t, []atlas.Label{{Key: LabelBindingID, Value: reconcileBindingID}}, client.Users[reconcileBindingID].Labels)
+	assert.Empty(t, client.Users["admin"].Labels)
+
+	// Migrated resources are recognized by their labels from then on.
+	users, err := bindingUsers(client, reconcileInstanceID, reconcileBindingID)
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	// Running it again doesn't change anything.
+	report, err = broker.MigrateLabels(ctx, opts)
+	assert.NoError(t, err)
+	assert.Equal(t, []LabelMigration{
+		{Name: "1a2b3c4d-5e6f-4a7b-8c9d", Reason: "instance ID can't be determined from the truncated name, the instance is not known to the platform"},
+	}, report.Clusters)
+	assert.Empty(t, report.Users)
+}
+
+func TestMigrateLabelsDryRun(t *testing.T) {
+	broker, client, ctx := setupTest()
+	setupLegacyResources(client)
+
+	report, err := broker.MigrateLabels(ctx, LabelMigrationOptions{
+		Known:  &KnownResources{InstanceIDs: []string{reconcileInstanceID}},
+		DryRun: true,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, report.DryRun)
+	if assert.Len(t, report.Clusters, 2) {
+		assert.Equal(t, []atlas.Label{{Key: LabelInstanceID, Value: reconcileInstanceID}}, report.Clusters[1].Labels)
+		assert.False(t, report.Clusters[1].Applied)
+	}
+	if assert.Len(t, report.Users, 1) {
+		assert.False(t, report.Users[0].Applied)
+	}
+
+	assert.Equal(t, []atlas.Label{{Key: "team", Value: "orders"}}, client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"].Labels)
+	assert.Empty(t, client.Users[reconcileBindingID].Labels)
+}
+
+func TestMigrateLabelsWithoutKnownInstances(t *testing.T) {
+	broker, client, ctx := setupTest()
+	setupLegacyResources(client)
+
+	// Users are labeled without knowing the instances, clusters aren't.
+	report, err := broker.MigrateLabels(ctx, LabelMigrationOptions{})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, migration := range report.Clusters {
+		assert.NotEmpty(t, migration.Reason, migration.Name)
+		assert.False(t, migration.Applied, migration.Name)
+	}
+	assert.Len(t, report.Users, 1)
+	assert.Equal(t, []atlas.Label{{Key: "team", Value: "orders"}}, client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"].Labels)
+}
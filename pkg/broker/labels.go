@@ -0,0 +1,320 @@
+package broker
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// Keys of the labels the broker attaches to the clusters it creates. All
+// broker-owned labels share the "aosb-" prefix.
+const (
+	LabelPrefix            = "aosb-"
+	LabelInstanceID        = "aosb-instance-id"
+	LabelOrgGUID           = "aosb-org-guid"
+	LabelSpaceGUID         = "aosb-space-guid"
+	LabelRequestedBy       = "aosb-requested-by"
+	LabelParamsFingerprint = "aosb-params-fingerprint"
+	LabelServiceName       = "aosb-service-name"
+	LabelPlanName          = "aosb-plan-name"
+	LabelBindingID         = "aosb-binding-id"
+	LabelInstanceName      = "aosb-instance-name"
+	LabelPlatform          = "aosb-platform"
+	LabelCreatedAt         = "aosb-created-at"
+	LabelNamespace         = "aosb-namespace"
+
+	// LabelSkipConnectionProbe is set to "true" on clusters whose instance
+	// opted out of the connection probe.
+	LabelSkipConnectionProbe = "aosb-skip-connection-probe"
+
+	// LabelProcessArgs holds the JSON process arguments requested for the
+	// cluster, which LastOperation waits for.
+	LabelProcessArgs = "aosb-process-args"
+)
+
+// ClusterMetadata holds the information the broker records on a cluster
+// using labels.
+type ClusterMetadata struct {
+	InstanceID        string `json:"instanceId,omitempty"`
+	OrgGUID           string `json:"orgGuid,omitempty"`
+	SpaceGUID         string `json:"spaceGuid,omitempty"`
+	RequestedBy       string `json:"requestedBy,omitempty"`
+	ParamsFingerprint string `json:"paramsFingerprint,omitempty"`
+	ServiceName       string `json:"serviceName,omitempty"`
+	PlanName          string `json:"planName,omitempty"`
+	InstanceName      string `json:"instanceName,omitempty"`
+	Platform          string `json:"platform,omitempty"`
+	Namespace         string `json:"namespace,omitempty"`
+
+	// CreatedAt is the time the instance was provisioned in RFC 3339
+	// format, Atlas doesn't report when clusters were created.
+	CreatedAt string `json:"createdAt,omitempty"`
+}
+
+// Labeled returns whether the cluster carried broker-owned labels. Clusters
+// created by older versions of the broker don't have any.
+func (m ClusterMetadata) Labeled() bool {
+	return m.InstanceID != ""
+}
+
+// InstanceMetadata decodes the broker-owned labels of a cluster. Missing
+// labels result in empty fields and unknown labels are ignored.
+func InstanceMetadata(cluster *atlas.Cluster) ClusterMetadata {
+	var metadata ClusterMetadata
+
+	for _, label := range cluster.Labels {
+		switch label.Key {
+		case LabelInstanceID:
+			metadata.InstanceID = label.Value
+		case LabelOrgGUID:
+			metadata.OrgGUID = label.Value
+		case LabelSpaceGUID:
+			metadata.SpaceGUID = label.Value
+		case LabelRequestedBy:
+			metadata.RequestedBy = label.Value
+		case LabelParamsFingerprint:
+			metadata.ParamsFingerprint = label.Value
+		case LabelServiceName:
+			metadata.ServiceName = label.Value
+		case LabelPlanName:
+			metadata.PlanName = label.Value
+		case LabelInstanceName:
+			metadata.InstanceName = label.Value
+		case LabelPlatform:
+			metadata.Platform = label.Value
+		case LabelCreatedAt:
+			metadata.CreatedAt = label.Value
+		case LabelNamespace:
+			metadata.Namespace = label.Value
+		}
+	}
+
+	return metadata
+}
+
+// labels converts the metadata into cluster labels, omitting empty values.
+func (m ClusterMetadata) labels() []atlas.Label {
+	values := []struct {
+		key   string
+		value string
+	}{
+		{LabelInstanceID, m.InstanceID},
+		{LabelOrgGUID, m.OrgGUID},
+		{LabelSpaceGUID, m.SpaceGUID},
+		{LabelRequestedBy, m.RequestedBy},
+		{LabelParamsFingerprint, m.ParamsFingerprint},
+		{LabelServiceName, m.ServiceName},
+		{LabelPlanName, m.PlanName},
+		{LabelInstanceName, m.InstanceName},
+		{LabelPlatform, m.Platform},
+		{LabelCreatedAt, m.CreatedAt},
+		{LabelNamespace, m.Namespace},
+	}
+
+	labels := []atlas.Label{}
+	for _, v := range values {
+		if v.value != "" {
+			labels = append(labels, atlas.Label{Key: v.key, Value: v.value})
+		}
+	}
+
+	return labels
+}
+
+// isBrokerLabel returns whether a label key is reserved for the broker.
+func isBrokerLabel(key string) bool {
+	return strings.HasPrefix(key, LabelPrefix)
+}
+
+// Limits of the labels passed in parameters. Atlas accepts at most 50
+// labels per cluster or user, the broker keeps some of them for its own
+// labels so users can't crowd them out.
+const (
+	MaxLabelKeyLength   = 255
+	MaxLabelValueLength = 255
+
+	maxAtlasLabels = 50
+	reservedLabels = 20
+	MaxParamLabels = maxAtlasLabels - reservedLabels
+)
+
+// labelKeyPattern matches the characters allowed in label keys.
+var labelKeyPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/-]*$`)
+
+// validateLabels checks the labels passed in parameters under field, for
+// example "cluster.labels". Every invalid label is reported on its own.
+func validateLabels(verr *ValidationError, field string, labels []atlas.Label) {
+	if len(labels) > MaxParamLabels {
+		verr.add(field, "must not have more than %d labels", MaxParamLabels)
+	}
+
+	seen := map[string]bool{}
+	for i, label := range labels {
+		keyField := fmt.Sprintf("%s[%d].key", field, i)
+		valueField := fmt.Sprintf("%s[%d].value", field, i)
+
+		switch {
+		case label.Key == "":
+			verr.add(keyField, "must not be empty")
+		case isBrokerLabel(label.Key):
+			verr.add(keyField, `must not start with the reserved prefix "%s"`, LabelPrefix)
+		case len(label.Key) > MaxLabelKeyLength:
+			verr.add(keyField, "must not be longer than %d characters", MaxLabelKeyLength)
+		case !labelKeyPattern.MatchString(label.Key):
+			verr.add(keyField, "must start with a letter or digit and only contain letters, digits and . _ : / -")
+		case seen[label.Key]:
+			verr.add(keyField, `duplicates the label "%s"`, label.Key)
+		}
+		seen[label.Key] = true
+
+		if len(label.Value) > MaxLabelValueLength {
+			verr.add(valueField, "must not be longer than %d characters", MaxLabelValueLength)
+		} else if strings.IndexFunc(label.Value, unicode.IsControl) >= 0 {
+			verr.add(valueField, "must not contain control characters")
+		}
+	}
+}
+
+// brokerLabels returns only the broker-owned labels of a cluster.
+func brokerLabels(cluster *atlas.Cluster) []atlas.Label {
+	labels := []atlas.Label{}
+	for _, label := range cluster.Labels {
+		if isBrokerLabel(label.Key) {
+			labels = append(labels, label)
+		}
+	}
+
+	return labels
+}
+
+// setLabels adds labels to a cluster, replacing any existing labels with the
+// same keys.
+func setLabels(cluster *atlas.Cluster, labels []atlas.Label) {
+	cluster.Labels = mergeLabels(cluster.Labels, labels)
+}
+
+// mergeLabels adds labels to a list of existing labels, replacing the ones
+// with the same keys.
+func mergeLabels(existing []atlas.Label, labels []atlas.Label) []atlas.Label {
+	for _, label := range labels {
+		replaced := false
+		for i := range existing {
+			if existing[i].Key == label.Key {
+				existing[i].Value = label.Value
+				replaced = true
+			}
+		}
+
+		if !replaced {
+			existing = append(existing, label)
+		}
+	}
+
+	return existing
+}
+
+// labelValue returns the value of the label with the passed key.
+func labelValue(labels []atlas.Label, key string) string {
+	for _, label := range labels {
+		if label.Key == key {
+			return label.Value
+		}
+	}
+
+	return ""
+}
+
+// paramsFingerprint returns a short digest of the raw parameters which can be
+// used to detect changes without storing the parameters themselves.
+func paramsFingerprint(rawParams []byte) string {
+	if len(rawParams) == 0 {
+		return ""
+	}
+
+	sum := sha256.Sum256(rawParams)
+	return hex.EncodeToString(sum[:8])
+}
+
+// originatingIdentityKey is the context key used by brokerapi to store the
+// X-Broker-API-Originating-Identity header.
+const originatingIdentityKey = "originatingIdentity"
+
+// requestedByFromContext extracts the user which initiated the request from
+// the originating identity header. The header is formatted as
+// "<platform> <base64 encoded JSON>".
+func requestedByFromContext(ctx context.Context) string {
+	header, _ := ctx.Value(originatingIdentityKey).(string)
+
+	parts := strings.SplitN(header, " ", 2)
+	if len(parts) != 2 {
+		return ""
+	}
+
+	data, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return ""
+	}
+
+	// Cloud Foundry includes a user ID whereas Kubernetes uses a username.
+	var identity struct {
+		UserID   string `json:"user_id"`
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(data, &identity); err != nil {
+		return ""
+	}
+
+	if identity.UserID != "" {
+		return identity.UserID
+	}
+
+	return identity.Username
+}
+
+// platformContext holds the fields of the OSB context object which are
+// recorded on clusters or used in usernames. Cloud Foundry sends the
+// organization and space, Kubernetes the namespace.
+type platformContext struct {
+	OrganizationGUID string `json:"organization_guid"`
+	OrganizationName string `json:"organization_name"`
+	SpaceGUID        string `json:"space_guid"`
+	SpaceName        string `json:"space_name"`
+	Namespace        string `json:"namespace"`
+}
+
+// platformContextFromContext decodes the OSB context object. Invalid or
+// missing contexts result in empty fields.
+func platformContextFromContext(rawContext json.RawMessage) platformContext {
+	var parsed platformContext
+	if len(rawContext) > 0 {
+		json.Unmarshal(rawContext, &parsed)
+	}
+
+	return parsed
+}
+
+// instanceNameFromContext extracts the user-facing name of the instance from
+// the OSB context object. Platforms which don't send one result in an empty
+// name.
+func instanceNameFromContext(rawContext json.RawMessage) string {
+	if len(rawContext) == 0 {
+		return ""
+	}
+
+	var platformContext struct {
+		InstanceName string `json:"instance_name"`
+	}
+	if err := json.Unmarshal(rawContext, &platformContext); err != nil {
+		return ""
+	}
+
+	return platformContext.InstanceName
+}
@@ -0,0 +1,125 @@
+package broker
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInstanceMetadata(t *testing.T) {
+	cluster := &atlas.Cluster{
+		Labels: []atlas.Label{
+			atlas.Label{Key: LabelInstanceID, Value: "instance"},
+			atlas.Label{Key: LabelOrgGUID, Value: "org"},
+			atlas.Label{Key: LabelSpaceGUID, Value: "space"},
+			atlas.Label{Key: LabelRequestedBy, Value: "user"},
+			atlas.Label{Key: LabelParamsFingerprint, Value: "fingerprint"},
+			atlas.Label{Key: LabelCreatedAt, Value: testCreatedAt},
+			atlas.Label{Key: "aosb-unknown", Value: "ignored"},
+			atlas.Label{Key: "team", Value: "payments"},
+		},
+	}
+
+	metadata := InstanceMetadata(cluster)
+
+	assert.True(t, metadata.Labeled())
+	assert.Equal(t, ClusterMetadata{
+		InstanceID:        "instance",
+		OrgGUID:           "org",
+		SpaceGUID:         "space",
+		RequestedBy:       "user",
+		ParamsFingerprint: "fingerprint",
+		CreatedAt:         testCreatedAt,
+	}, metadata)
+}
+
+func TestInstanceMetadataUnlabeled(t *testing.T) {
+	metadata := InstanceMetadata(&atlas.Cluster{})
+
+	assert.False(t, metadata.Labeled())
+	assert.Equal(t, ClusterMetadata{}, metadata)
+}
+
+func TestRequestedByFromContext(t *testing.T) {
+	identity := func(platform string, value string) context.Context {
+		header := platform + " " + base64.StdEncoding.EncodeToString([]byte(value))
+		return context.WithValue(context.Background(), originatingIdentityKey, header)
+	}
+
+	assert.Equal(t, "cf-user", requestedByFromContext(identity("cloudfoundry", `{"user_id": "cf-user"}`)))
+	assert.Equal(t, "k8s-user", requestedByFromContext(identity("kubernetes", `{"username": "k8s-user"}`)))
+	assert.Empty(t, requestedByFromContext(identity("kubernetes", `not json`)))
+	assert.Empty(t, requestedByFromContext(context.Background()))
+}
+
+func TestPlatformContextFromContext(t *testing.T) {
+	assert.Equal(t, platformContext{OrganizationGUID: "org", SpaceGUID: "space"}, platformContextFromContext([]byte(`{"platform": "cloudfoundry", "organization_guid": "org", "space_guid": "space"}`)))
+	assert.Equal(t, platformContext{Namespace: "team-a"}, platformContextFromContext([]byte(`{"platform": "kubernetes", "namespace": "team-a"}`)))
+	assert.Equal(t, platformContext{}, platformContextFromContext([]byte(`not json`)))
+	assert.Equal(t, platformContext{}, platformContextFromContext(nil))
+}
+
+func TestParamsFingerprint(t *testing.T) {
+	assert.Empty(t, paramsFingerprint(nil))
+	assert.Equal(t, paramsFingerprint([]byte(`{}`)), paramsFingerprint([]byte(`{}`)))
+	assert.NotEqual(t, paramsFingerprint([]byte(`{}`)), paramsFingerprint([]byte(`{"cluster": {}}`)))
+}
+
+func TestValidateLabels(t *testing.T) {
+	verr := &ValidationError{}
+	validateLabels(verr, "cluster.labels", []atlas.Label{
+		atlas.Label{Key: "team", Value: "payments"},
+		atlas.Label{Key: "cost-center/eu", Value: ""},
+	})
+	assert.NoError(t, verr.errorOrNil())
+
+	tests := []struct {
+		name   string
+		labels []atlas.Label
+		fields []string
+	}{
+		{"empty key", []atlas.Label{{Key: ""}}, []string{"cluster.labels[0].key"}},
+		{"reserved prefix", []atlas.Label{{Key: LabelInstanceID, Value: "other"}}, []string{"cluster.labels[0].key"}},
+		{"long key", []atlas.Label{{Key: strings.Repeat("k", MaxLabelKeyLength+1)}}, []string{"cluster.labels[0].key"}},
+		{"invalid characters", []atlas.Label{{Key: "team name"}, {Key: "-team"}}, []string{"cluster.labels[0].key", "cluster.labels[1].key"}},
+		{"duplicate key", []atlas.Label{{Key: "team"}, {Key: "team"}}, []string{"cluster.labels[1].key"}},
+		{"long value", []atlas.Label{{Key: "team", Value: strings.Repeat("v", MaxLabelValueLength+1)}}, []string{"cluster.labels[0].value"}},
+		{"control characters", []atlas.Label{{Key: "team", Value: "pay\nments"}}, []string{"cluster.labels[0].value"}},
+		{"every invalid label", []atlas.Label{{Key: "aosb-x"}, {Key: "ok"}, {Key: "a b", Value: "\t"}}, []string{"cluster.labels[0].key", "cluster.labels[2].key", "cluster.labels[2].value"}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			verr := &ValidationError{}
+			validateLabels(verr, "cluster.labels", test.labels)
+
+			fields := []string{}
+			for _, violation := range verr.Violations {
+				fields = append(fields, violation.Field)
+			}
+			assert.Equal(t, test.fields, fields)
+		})
+	}
+
+	// The count leaves room for the broker's own labels.
+	labels := []atlas.Label{}
+	for i := 0; i <= MaxParamLabels; i++ {
+		labels = append(labels, atlas.Label{Key: fmt.Sprintf("label-%d", i)})
+	}
+
+	verr = &ValidationError{}
+	validateLabels(verr, "user.labels", labels)
+	if assert.Len(t, verr.Violations, 1) {
+		assert.Equal(t, "user.labels", verr.Violations[0].Field)
+	}
+
+	// The headroom fits all metadata labels and the connection probe and
+	// process argument labels.
+	metadata := ClusterMetadata{"i", "o", "s", "r", "p", "s", "p", "i", "p", "n", "c"}
+	assert.True(t, len(metadata.labels())+2 <= reservedLabels)
+}
@@ -0,0 +1,139 @@
+package broker
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// DefaultMaintenanceMessage is returned for operations rejected during
+// maintenance unless WithMaintenanceMessage sets another one.
+const DefaultMaintenanceMessage = "The service broker is in maintenance, please try again later"
+
+// DefaultMaintenanceRetryAfter is the Retry-After sent with operations
+// rejected during maintenance.
+const DefaultMaintenanceRetryAfter = 5 * time.Minute
+
+// maintenanceAction is the logger action of failures caused by maintenance.
+const maintenanceAction = "maintenance"
+
+// MaintenanceState describes the maintenance mode of the broker.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Message string `json:"message,omitempty"`
+
+	// RetryAfter is the number of seconds clients are asked to wait before
+	// retrying a rejected operation.
+	RetryAfter int `json:"retryAfter,omitempty"`
+}
+
+// maintenance tracks whether the broker is in maintenance mode. While it is,
+// operations changing instances or bindings are rejected with 503 Service
+// Unavailable, everything else keeps working so platforms can still poll.
+type maintenance struct {
+	enabledGauge *metrics.GaugeVec
+
+	mu         sync.RWMutex
+	enabled    bool
+	message    string
+	retryAfter time.Duration
+}
+
+func newMaintenance() *maintenance {
+	m := &maintenance{
+		enabledGauge: metrics.NewGaugeVec("aosb_maintenance_mode", "Whether the broker rejects changes because it is in maintenance mode."),
+		message:      DefaultMaintenanceMessage,
+		retryAfter:   DefaultMaintenanceRetryAfter,
+	}
+
+	m.set(false)
+	return m
+}
+
+// set enables or disables the maintenance mode and returns whether that
+// changed it.
+func (m *maintenance) set(enabled bool) bool {
+	m.mu.Lock()
+	changed := m.enabled != enabled
+	m.enabled = enabled
+	m.mu.Unlock()
+
+	value := 0.0
+	if enabled {
+		value = 1
+	}
+	m.enabledGauge.Set(value)
+
+	return changed
+}
+
+// SetMaintenance enables or disables the maintenance mode at runtime.
+func (b Broker) SetMaintenance(enabled bool) {
+	if !b.maintenance.set(enabled) {
+		return
+	}
+
+	if enabled {
+		b.logger.Warnw("Maintenance mode enabled, rejecting changes to instances and bindings", "message", b.maintenance.message)
+	} else {
+		b.logger.Infow("Maintenance mode disabled")
+	}
+}
+
+// ToggleMaintenance switches the maintenance mode on or off and returns
+// whether it's enabled now.
+func (b Broker) ToggleMaintenance() bool {
+	enabled := !b.Maintenance().Enabled
+	b.SetMaintenance(enabled)
+	return enabled
+}
+
+// Maintenance returns the current maintenance mode of the broker.
+func (b Broker) Maintenance() MaintenanceState {
+	m := b.maintenance
+
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if !m.enabled {
+		return MaintenanceState{}
+	}
+
+	return MaintenanceState{
+		Enabled:    true,
+		Message:    m.message,
+		RetryAfter: int(m.retryAfter / time.Second),
+	}
+}
+
+// checkMaintenance returns a 503 Service Unavailable failure if the broker
+// is in maintenance mode. Operations changing instances or bindings call it
+// before doing anything else.
+func (b Broker) checkMaintenance() error {
+	state := b.Maintenance()
+	if !state.Enabled {
+		return nil
+	}
+
+	return apiresponses.NewFailureResponse(errors.New(state.Message), http.StatusServiceUnavailable, maintenanceAction)
+}
+
+// MaintenanceMiddleware adds a Retry-After header to requests which may
+// change instances or bindings while the broker is in maintenance mode, as
+// these are rejected.
+func MaintenanceMiddleware(b *Broker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if state := b.Maintenance(); state.Enabled && r.Method != http.MethodGet {
+				w.Header().Set("Retry-After", strconv.Itoa(state.RetryAfter))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
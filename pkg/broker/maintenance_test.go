@@ -0,0 +1,73 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenance(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.True(t, broker.ToggleMaintenance())
+	assert.Equal(t, MaintenanceState{Enabled: true, Message: DefaultMaintenanceMessage, RetryAfter: 300}, broker.Maintenance())
+	assert.Equal(t, float64(1), broker.maintenance.enabledGauge.Value())
+
+	assertUnavailable := func(err error) {
+		if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok, "Expected a failure response") {
+			assert.Equal(t, http.StatusServiceUnavailable, failure.ValidatedStatusCode(nil))
+			assert.Equal(t, DefaultMaintenanceMessage, failure.Error())
+			assert.Equal(t, ErrorClassUser, ClassifyError(failure))
+		}
+	}
+
+	// Changes are rejected.
+	_, err = broker.Provision(ctx, "other-instance", brokerapi.ProvisionDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assertUnavailable(err)
+	assert.Len(t, client.Clusters, 1)
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assertUnavailable(err)
+
+	_, err = broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assertUnavailable(err)
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assertUnavailable(err)
+	assert.Empty(t, client.Users)
+
+	_, err = broker.Unbind(ctx, instanceID, "binding", brokerapi.UnbindDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assertUnavailable(err)
+
+	// Polls and lookups keep working.
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	resp, err := broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: spec.OperationData})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperationState(brokerapi.Succeeded), resp.State)
+
+	_, err = broker.GetInstance(ctx, instanceID)
+	assert.NoError(t, err)
+
+	_, err = broker.Services(ctx)
+	assert.NoError(t, err)
+
+	assert.False(t, broker.ToggleMaintenance())
+	assert.Equal(t, MaintenanceState{}, broker.Maintenance())
+	assert.Equal(t, float64(0), broker.maintenance.enabledGauge.Value())
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{PlanID: testPlanID, ServiceID: testServiceID}, true)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,214 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// monitoringUsernamePrefix is the prefix of the monitoring users of
+// instances, followed by the instance ID.
+const monitoringUsernamePrefix = "aosb-monitoring-"
+
+// monitoringCredentialsBindingID is the binding ID the credentials of
+// monitoring users are stored under. Bindings never have an empty ID, so
+// they can't collide.
+const monitoringCredentialsBindingID = ""
+
+// monitoringRole is the only role of monitoring users, which is what metrics
+// exporters need to read server and replication status.
+var monitoringRole = atlas.Role{Name: "clusterMonitor", DatabaseName: atlas.AuthDatabaseAdmin}
+
+// errNoMonitoringUser is returned for instances without a monitoring user.
+var errNoMonitoringUser = apiresponses.NewFailureResponse(errors.New("the instance has no monitoring user"), http.StatusNotFound, "monitoring-user-not-found")
+
+// MonitoringCredentials are the credentials of the monitoring user of an
+// instance. They are only served to operators through the admin API and
+// never included in bindings.
+type MonitoringCredentials struct {
+	InstanceID string `json:"instanceId"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+
+	// URI is empty until Atlas has assigned the cluster an address.
+	URI string `json:"uri,omitempty"`
+}
+
+// monitoringUsername returns the username of the monitoring user of an
+// instance.
+func monitoringUsername(instanceID string) string {
+	return monitoringUsernamePrefix + instanceID
+}
+
+// monitoringUserChange is what a provision or update requests of the
+// monitoring user. Enabled is nil if the parameter wasn't passed.
+type monitoringUserChange struct {
+	Enabled *bool
+	Rotate  bool
+}
+
+// monitoringUserFromParams reads the monitoringUser parameter and, for
+// updates, rotateMonitoringUser. Atlas never returns passwords again, so
+// monitoring users require a credential store.
+func (b Broker) monitoringUserFromParams(rawParams []byte, update bool) (monitoringUserChange, error) {
+	params := struct {
+		MonitoringUser       *bool `json:"monitoringUser"`
+		RotateMonitoringUser bool  `json:"rotateMonitoringUser"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return monitoringUserChange{}, validationErrorFromJSON(err)
+		}
+	}
+
+	change := monitoringUserChange{Enabled: params.MonitoringUser, Rotate: params.RotateMonitoringUser}
+	enabled := change.Enabled != nil && *change.Enabled
+
+	verr := &ValidationError{}
+	if enabled && b.credentialStore == nil {
+		verr.add("monitoringUser", "requires the broker to be configured with a credential store")
+	}
+
+	switch {
+	case change.Rotate && !update:
+		verr.add("rotateMonitoringUser", "is only supported by updates")
+	case change.Rotate && change.Enabled != nil && !*change.Enabled:
+		verr.add("rotateMonitoringUser", "can't be combined with disabling the monitoring user")
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return change, err
+	}
+
+	if enabled || change.Rotate {
+		return change, b.checkFeature(FeatureMonitoringUsers)
+	}
+
+	return change, nil
+}
+
+// applyMonitoringUser creates, rotates or removes the monitoring user of an
+// instance as requested.
+func (b Broker) applyMonitoringUser(ctx context.Context, client atlas.Client, instanceID string, change monitoringUserChange) error {
+	switch {
+	case change.Enabled != nil && !*change.Enabled:
+		return b.removeMonitoringUser(ctx, client, instanceID)
+	case change.Rotate || (change.Enabled != nil && *change.Enabled):
+		return b.createMonitoringUser(ctx, client, instanceID)
+	}
+
+	return nil
+}
+
+// createMonitoringUser creates the monitoring user of an instance with a new
+// password and stores its credentials. An existing user is replaced, which is
+// how the password is rotated, so connections using the old password fail
+// from then on.
+func (b Broker) createMonitoringUser(ctx context.Context, client atlas.Client, instanceID string) error {
+	username := monitoringUsername(instanceID)
+	if err := client.DeleteUser(atlas.AuthDatabaseAdmin, username); err != nil && err != atlas.ErrUserNotFound {
+		b.logger.Errorw("Failed to delete the previous monitoring user", "error", err, "username", username)
+		return atlasToAPIError(err)
+	}
+
+	password, err := b.passwordPolicy.generate()
+	if err != nil {
+		b.logger.Errorw("Failed to generate password", "error", err)
+		return errors.New("Failed to generate monitoring user password")
+	}
+
+	user := atlas.User{
+		Username: username,
+		Password: password,
+		Roles:    []atlas.Role{monitoringRole},
+		Labels:   []atlas.Label{{Key: LabelInstanceID, Value: instanceID}},
+	}
+
+	// A creation of an earlier attempt which was cut short may still land
+	// after the deletion above, that user is replaced as well.
+	_, err = client.CreateUser(user)
+	if err == atlas.ErrUserAlreadyExists {
+		if err = client.DeleteUser(atlas.AuthDatabaseAdmin, username); err == nil {
+			_, err = client.CreateUser(user)
+		}
+	}
+
+	if err != nil {
+		b.logger.Errorw("Failed to create the monitoring user", "error", err, "username", username)
+		return atlasToAPIError(err)
+	}
+
+	// A user whose password can't be kept is of no use to anyone.
+	credentials := MonitoringCredentials{InstanceID: instanceID, Username: username, Password: password}
+	if err := b.storeCredentials(ctx, instanceID, monitoringCredentialsBindingID, credentials); err != nil {
+		if deleteErr := client.DeleteUser(atlas.AuthDatabaseAdmin, username); deleteErr != nil && deleteErr != atlas.ErrUserNotFound {
+			b.logger.Errorw("Failed to remove the monitoring user without stored credentials", "error", deleteErr, "username", username)
+		}
+		return err
+	}
+
+	b.logger.Infow("Created monitoring user", "username", username)
+	return nil
+}
+
+// removeMonitoringUser deletes the monitoring user of an instance and its
+// stored credentials. Instances without one aren't an error.
+func (b Broker) removeMonitoringUser(ctx context.Context, client atlas.Client, instanceID string) error {
+	username := monitoringUsername(instanceID)
+	if err := client.DeleteUser(atlas.AuthDatabaseAdmin, username); err != nil && err != atlas.ErrUserNotFound {
+		b.logger.Errorw("Failed to delete the monitoring user", "error", err, "username", username)
+		return atlasToAPIError(err)
+	}
+
+	return b.deleteStoredCredentials(ctx, instanceID, monitoringCredentialsBindingID)
+}
+
+// MonitoringCredentials returns the credentials of the monitoring user of an
+// instance along with a connection string for the current address of the
+// cluster.
+func (b Broker) MonitoringCredentials(ctx context.Context, instanceID string) (*MonitoringCredentials, error) {
+	b.logger = b.logger.With("instance_id", instanceID)
+
+	if b.credentialStore == nil {
+		return nil, errNoMonitoringUser
+	}
+
+	client, err := atlasClientFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	cluster, err := b.instanceCluster(client, instanceID)
+	if err != nil {
+		b.logger.Errorw("Failed to get existing cluster", "error", err)
+		return nil, atlasToAPIError(err)
+	}
+
+	record, err := b.storedCredentials(ctx, instanceID, monitoringCredentialsBindingID)
+	if err == ErrCredentialsNotFound {
+		return nil, errNoMonitoringUser
+	}
+	if err != nil {
+		b.logger.Errorw("Failed to read the monitoring user credentials", "error", err)
+		return nil, err
+	}
+
+	var credentials MonitoringCredentials
+	if err := json.Unmarshal(record, &credentials); err != nil {
+		return nil, err
+	}
+
+	if cluster.SrvAddress != "" {
+		params := &ConnectionStringParams{Format: ConnectionStringFormatSRV}
+		if credentials.URI, err = buildConnectionString(cluster, credentials.Username, credentials.Password, params, nil, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	return &credentials, nil
+}
@@ -0,0 +1,118 @@
+package broker
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"text/template"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// maximumClusterNameLength is the length cluster names are truncated to.
+// Atlas has different name length requirements depending on which
+// environment it's running in. A length of 23 is a safe choice and truncates
+// UUIDs nicely.
+const maximumClusterNameLength = 23
+
+// clusterNamePattern matches the cluster names accepted by Atlas.
+var clusterNamePattern = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9-]*$`)
+
+// Namer maps service instances onto the names of their Atlas clusters and
+// back. The zero value uses the instance ID truncated by NormalizeClusterName,
+// which is how all clusters were named before templates were introduced.
+type Namer struct {
+	template *template.Template
+}
+
+// NewNamer creates a Namer which derives cluster names from a text/template.
+// The instance ID is available as {{.InstanceID}}. The template is rendered
+// with a sample ID to reject templates producing invalid names.
+func NewNamer(text string) (Namer, error) {
+	tmpl, err := template.New("cluster-name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return Namer{}, fmt.Errorf("invalid cluster name template: %v", err)
+	}
+
+	namer := Namer{template: tmpl}
+
+	name, err := namer.executeTemplate("2a8a9ac7-5b2e-4b0a-9c37-4f1bb2bd6e8c")
+	if err != nil {
+		return Namer{}, fmt.Errorf("invalid cluster name template: %v", err)
+	}
+
+	if !clusterNamePattern.MatchString(name) {
+		return Namer{}, fmt.Errorf(`cluster name template produces invalid name "%s"`, name)
+	}
+
+	return namer, nil
+}
+
+// ClusterName returns the name of the Atlas cluster backing an instance.
+func (n Namer) ClusterName(instanceID string) string {
+	if n.template == nil {
+		return NormalizeClusterName(instanceID)
+	}
+
+	// The template has been validated when the namer was created and can't
+	// fail for other IDs.
+	name, err := n.executeTemplate(instanceID)
+	if err != nil {
+		return NormalizeClusterName(instanceID)
+	}
+
+	return name
+}
+
+// InstanceID returns the ID of the instance a cluster belongs to. It's taken
+// from the instance ID label, clusters created before labels were introduced
+// fall back to their name. The second return value is false if the ID can't
+// be determined, which is the case for unlabeled clusters whose name has been
+// truncated or derived from a template. Those can still be checked against a
+// known ID using Matches.
+func (n Namer) InstanceID(cluster *atlas.Cluster) (string, bool) {
+	if instanceID := labelValue(cluster.Labels, LabelInstanceID); instanceID != "" {
+		return instanceID, true
+	}
+
+	if n.template == nil && cluster.Name != "" && len(cluster.Name) < maximumClusterNameLength {
+		return cluster.Name, true
+	}
+
+	return "", false
+}
+
+// Matches returns whether a cluster belongs to an instance.
+func (n Namer) Matches(cluster *atlas.Cluster, instanceID string) bool {
+	if labeled := labelValue(cluster.Labels, LabelInstanceID); labeled != "" {
+		return labeled == instanceID
+	}
+
+	return n.ClusterName(instanceID) == cluster.Name
+}
+
+// executeTemplate renders the cluster name template for an instance.
+func (n Namer) executeTemplate(instanceID string) (string, error) {
+	data := struct {
+		InstanceID string
+	}{
+		InstanceID: instanceID,
+	}
+
+	var name bytes.Buffer
+	if err := n.template.Execute(&name, data); err != nil {
+		return "", err
+	}
+
+	return NormalizeClusterName(name.String()), nil
+}
+
+// NormalizeClusterName will sanitize a name to make sure it will be accepted
+// by the Atlas API.
+func NormalizeClusterName(name string) string {
+	if len(name) > maximumClusterNameLength {
+		return string(name[0:maximumClusterNameLength])
+	}
+
+	return name
+}
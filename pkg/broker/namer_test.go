@@ -0,0 +1,56 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/stretchr/testify/assert"
+)
+
+const namerInstanceID = "aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"
+
+func TestNamerClusterName(t *testing.T) {
+	assert.Equal(t, "aaaaaaaa-bbbb-cccc-dddd", Namer{}.ClusterName(namerInstanceID))
+	assert.Equal(t, "instance", Namer{}.ClusterName("instance"))
+
+	namer, err := NewNamer("prod-{{.InstanceID}}")
+	if assert.NoError(t, err) {
+		assert.Equal(t, "prod-aaaaaaaa-bbbb-cccc", namer.ClusterName(namerInstanceID))
+	}
+}
+
+func TestNamerInstanceIDLabeled(t *testing.T) {
+	namer, _ := NewNamer("prod-{{.InstanceID}}")
+
+	cluster := &atlas.Cluster{
+		Name:   "prod-aaaaaaaa-bbbb-cccc",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: namerInstanceID}},
+	}
+
+	for _, n := range []Namer{Namer{}, namer} {
+		instanceID, ok := n.InstanceID(cluster)
+		assert.True(t, ok)
+		assert.Equal(t, namerInstanceID, instanceID)
+		assert.True(t, n.Matches(cluster, namerInstanceID))
+		assert.False(t, n.Matches(cluster, "other"))
+	}
+}
+
+func TestNamerInstanceIDLegacy(t *testing.T) {
+	// Clusters created before labels were introduced are named after the
+	// instance ID, which is truncated if it's too long.
+	instanceID, ok := Namer{}.InstanceID(&atlas.Cluster{Name: "instance"})
+	assert.True(t, ok)
+	assert.Equal(t, "instance", instanceID)
+
+	truncated := &atlas.Cluster{Name: "aaaaaaaa-bbbb-cccc-dddd"}
+	_, ok = Namer{}.InstanceID(truncated)
+	assert.False(t, ok, "Expected truncated names not to be reversible")
+	assert.True(t, Namer{}.Matches(truncated, namerInstanceID))
+	assert.False(t, Namer{}.Matches(truncated, "aaaaaaaa-bbbb-cccc-ffff-eeeeeeeeeeee"))
+
+	namer, _ := NewNamer("prod-{{.InstanceID}}")
+	_, ok = namer.InstanceID(&atlas.Cluster{Name: "prod-instance"})
+	assert.False(t, ok, "Expected templated names not to be reversible")
+	assert.True(t, namer.Matches(&atlas.Cluster{Name: "prod-instance"}, "instance"))
+}
@@ -0,0 +1,121 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// OperationData describes an asynchronous operation. It's encoded as JSON
+// into the operation data the platform passes back when polling, so
+// LastOperation knows when the operation started and what it changes.
+type OperationData struct {
+	Operation   string `json:"operation"`
+	ClusterName string `json:"clusterName,omitempty"`
+
+	// StartedAt is the time the operation started in RFC 3339 format.
+	StartedAt string `json:"startedAt,omitempty"`
+
+	// PlanID is the plan the instance moves to, empty if it keeps its plan.
+	PlanID string `json:"planId,omitempty"`
+
+	// Bootstrap is applied once a provisioned cluster is ready.
+	Bootstrap *Bootstrap `json:"bootstrap,omitempty"`
+}
+
+// newOperationData describes an operation starting now.
+func (b Broker) newOperationData(operation string, clusterName string, planID string) OperationData {
+	return OperationData{
+		Operation:   operation,
+		ClusterName: clusterName,
+		StartedAt:   b.clock.Now().UTC().Format(time.RFC3339),
+		PlanID:      planID,
+	}
+}
+
+// String encodes the operation data for the platform.
+func (d OperationData) String() string {
+	data, _ := json.Marshal(d)
+	return string(data)
+}
+
+// startedAt returns when the operation started, false if that isn't known
+// such as for operations started by older broker versions.
+func (d OperationData) startedAt() (time.Time, bool) {
+	startedAt, err := time.Parse(time.RFC3339, d.StartedAt)
+	return startedAt, err == nil
+}
+
+// operationFromData determines the operation a LastOperation poll refers to.
+// Operation data is normalized so all historical formats are recognized:
+// the JSON of OperationData and plain operation names. If the platform
+// didn't send any the operation is inferred from the state of the cluster,
+// which may be nil if it doesn't exist anymore.
+func operationFromData(data string, cluster *atlas.Cluster) OperationData {
+	data = strings.TrimSpace(data)
+
+	var operation OperationData
+	if strings.HasPrefix(data, "{") && json.Unmarshal([]byte(data), &operation) == nil && operation.Operation != "" {
+		return operation
+	}
+
+	if data != "" {
+		return OperationData{Operation: strings.ToLower(data)}
+	}
+
+	if cluster == nil {
+		return OperationData{Operation: OperationDeprovision}
+	}
+
+	switch cluster.StateName {
+	case atlas.ClusterStateCreating:
+		return OperationData{Operation: OperationProvision}
+	case atlas.ClusterStateUpdating:
+		return OperationData{Operation: OperationUpdate}
+	case atlas.ClusterStateDeleting, atlas.ClusterStateDeleted:
+		return OperationData{Operation: OperationDeprovision}
+	}
+
+	// An idle cluster has finished whatever operation was in progress, and
+	// the provision is the only one which doesn't leave a drift report.
+	return OperationData{Operation: OperationProvision}
+}
+
+// checkOperationDuration reports how long an operation in progress has been
+// running and fails it once it takes longer than the operation timeout.
+// Operations whose start isn't known are left alone.
+func (b Broker) checkOperationDuration(operation OperationData, cluster *atlas.Cluster, resp brokerapi.LastOperation) brokerapi.LastOperation {
+	startedAt, ok := operation.startedAt()
+	if !ok || resp.State != brokerapi.InProgress {
+		return resp
+	}
+
+	elapsed := b.clock.Now().Sub(startedAt).Round(time.Second)
+
+	if b.operationTimeout > 0 && elapsed > b.operationTimeout {
+		description := fmt.Sprintf("The %s didn't complete within %s", operation.Operation, b.operationTimeout)
+		if cluster != nil {
+			description += fmt.Sprintf(", the cluster is in state %s in Atlas", cluster.StateName)
+		}
+
+		b.logger.Warnw("Operation timed out", "operation", operation.Operation, "elapsed", elapsed.String())
+		return brokerapi.LastOperation{State: brokerapi.Failed, Description: description}
+	}
+
+	if resp.Description == "" {
+		resp.Description = fmt.Sprintf("The %s has been running for %s", operation.Operation, elapsed)
+
+		// Users are told how long the operation usually takes.
+		if b.durationEstimator != nil {
+			if estimate := b.durationEstimator.describe(operation.Operation, cluster); estimate != "" {
+				resp.Description += ". " + estimate
+			}
+		}
+	}
+
+	return resp
+}
@@ -0,0 +1,101 @@
+package broker
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationFromData(t *testing.T) {
+	creating := &atlas.Cluster{StateName: atlas.ClusterStateCreating}
+	updating := &atlas.Cluster{StateName: atlas.ClusterStateUpdating}
+	deleting := &atlas.Cluster{StateName: atlas.ClusterStateDeleting}
+	idle := &atlas.Cluster{StateName: atlas.ClusterStateIdle}
+
+	data := OperationData{Operation: OperationUpdate, ClusterName: "instance", StartedAt: testCreatedAt, PlanID: testPlanID}
+	assert.Equal(t, data, operationFromData(data.String(), idle))
+	assert.Equal(t, `{"operation":"update","clusterName":"instance","startedAt":"2020-01-02T03:04:05Z","planId":"aosb-cluster-plan-aws-m10"}`, data.String())
+
+	assert.Equal(t, OperationProvision, operationFromData("provision", idle).Operation)
+	assert.Equal(t, OperationUpdate, operationFromData(" Update\n", idle).Operation)
+	assert.Equal(t, OperationDeprovision, operationFromData("DEPROVISION", nil).Operation)
+
+	assert.Equal(t, OperationProvision, operationFromData("", creating).Operation)
+	assert.Equal(t, OperationUpdate, operationFromData("", updating).Operation)
+	assert.Equal(t, OperationDeprovision, operationFromData("", deleting).Operation)
+	assert.Equal(t, OperationDeprovision, operationFromData("", nil).Operation)
+	assert.Equal(t, OperationProvision, operationFromData("", idle).Operation)
+}
+
+func TestOperationDataOfOperations(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	provision, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, OperationData{Operation: OperationProvision, ClusterName: instanceID, StartedAt: testCreatedAt, PlanID: testPlanID}, operationFromData(provision.OperationData, nil))
+
+	// Updates record the target plan if they change it.
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	update, err := broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		PreviousValues: brokerapi.PreviousValues{
+			PlanID: testPlanID,
+		},
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, OperationData{Operation: OperationUpdate, ClusterName: instanceID, StartedAt: testCreatedAt, PlanID: "aosb-cluster-plan-aws-m20"}, operationFromData(update.OperationData, nil))
+
+	deprovision, err := broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, OperationData{Operation: OperationDeprovision, ClusterName: instanceID, StartedAt: testCreatedAt}, operationFromData(deprovision.OperationData, nil))
+}
+
+func TestLastOperationTimeout(t *testing.T) {
+	broker, _, ctx := setupTest(WithOperationTimeout(time.Hour))
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poll := brokerapi.PollDetails{OperationData: spec.OperationData}
+
+	testClock(broker).Advance(10 * time.Minute)
+	resp, err := broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperation{State: brokerapi.InProgress, Description: "The provision has been running for 10m0s. Estimate: typically 7–15 minutes for M10"}, resp)
+
+	testClock(broker).Advance(time.Hour)
+	resp, err = broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperation{State: brokerapi.Failed, Description: "The provision didn't complete within 1h0m0s, the cluster is in state CREATING in Atlas"}, resp)
+
+	// Plain operation names don't tell when the operation started.
+	resp, err = broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: OperationProvision})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.LastOperation{State: brokerapi.InProgress}, resp)
+}
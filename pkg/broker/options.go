@@ -0,0 +1,745 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi"
+	"go.uber.org/zap"
+)
+
+// Option configures optional behaviour of a Broker. Options validate their
+// input and return an error if it's invalid.
+type Option func(*Broker) error
+
+// WithWhitelist limits the providers and plans exposed by the broker.
+func WithWhitelist(whitelist Whitelist) Option {
+	return func(b *Broker) error {
+		if err := whitelist.validate(); err != nil {
+			return err
+		}
+
+		b.whitelist = whitelist
+		return nil
+	}
+}
+
+// WithAllowedInstanceSizes limits the plans offered and accepted by the
+// broker to the passed instance sizes, such as "M10". Plans of existing
+// instances keep working for everything but changing to another plan.
+func WithAllowedInstanceSizes(sizes ...string) Option {
+	return func(b *Broker) error {
+		trimmed := make([]string, len(sizes))
+		for i, size := range sizes {
+			trimmed[i] = strings.TrimSpace(size)
+			if trimmed[i] == "" {
+				return errors.New("allowed instance sizes must not be empty")
+			}
+		}
+
+		b.allowedInstanceSizes = trimmed
+		return nil
+	}
+}
+
+// WithDefaultUserRoles sets the roles assigned to binding users which don't
+// specify any roles in their params. Defaults to readWriteAnyDatabase.
+func WithDefaultUserRoles(roles ...atlas.Role) Option {
+	return func(b *Broker) error {
+		if len(roles) == 0 {
+			return errors.New("at least one default user role is required")
+		}
+
+		for _, role := range roles {
+			if role.Name == "" || role.DatabaseName == "" {
+				return fmt.Errorf("default user role %+v must have both a name and a database", role)
+			}
+		}
+
+		b.defaultUserRoles = roles
+		return nil
+	}
+}
+
+// WithRolePolicy restricts the databases binding users can be granted roles
+// on. The default user roles have to satisfy the policy as well.
+func WithRolePolicy(policy RolePolicy) Option {
+	return func(b *Broker) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+
+		b.rolePolicy = &policy
+		return nil
+	}
+}
+
+// WithClusterNameTemplate sets a text/template used to derive cluster names
+// from instance IDs, see NewNamer. The result is truncated the same way as
+// NormalizeClusterName.
+func WithClusterNameTemplate(text string) Option {
+	return func(b *Broker) error {
+		namer, err := NewNamer(text)
+		if err != nil {
+			return err
+		}
+
+		b.namer = namer
+		return nil
+	}
+}
+
+// WithUserPasswords controls whether binds may pass their own password in
+// user.password. Otherwise passwords passed by callers are ignored.
+func WithUserPasswords(enabled bool) Option {
+	return func(b *Broker) error {
+		b.allowUserPasswords = enabled
+		return nil
+	}
+}
+
+// WithPasswordPolicy sets the length and characters of generated passwords.
+func WithPasswordPolicy(policy PasswordPolicy) Option {
+	return func(b *Broker) error {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+
+		b.passwordPolicy = policy
+		return nil
+	}
+}
+
+// WithUsernameTemplate sets a text/template used to derive the usernames of
+// binding users, see UsernameTemplateData for the available fields. Usernames
+// longer than Atlas accepts are truncated with a hash suffix.
+func WithUsernameTemplate(text string) Option {
+	return func(b *Broker) error {
+		tmpl, err := parseUsernameTemplate(text)
+		if err != nil {
+			return err
+		}
+
+		b.usernameTemplate = tmpl
+		return nil
+	}
+}
+
+// WithStrictPreviousValues controls what happens when the previous plan sent
+// by the platform during an update doesn't match the cluster in Atlas. By
+// default a warning is logged and the update proceeds, in strict mode the
+// update is rejected.
+func WithStrictPreviousValues(strict bool) Option {
+	return func(b *Broker) error {
+		b.strictPreviousValues = strict
+		return nil
+	}
+}
+
+// WithStrictBindingPlans controls what happens when the service or plan ID
+// sent with a bind or unbind request doesn't match the instance. By default
+// the request is rejected, disabling strict mode only logs a warning for
+// platforms known to send stale IDs.
+func WithStrictBindingPlans(strict bool) Option {
+	return func(b *Broker) error {
+		b.strictBindingPlans = strict
+		return nil
+	}
+}
+
+// WithClusterDefaults sets cluster settings which are applied to every new
+// cluster. They take precedence over the plan but can be overridden by user
+// parameters. The provider and instance size are always dictated by the plan.
+func WithClusterDefaults(defaults atlas.Cluster) Option {
+	return func(b *Broker) error {
+		if defaults.Name != "" {
+			return errors.New("the cluster name can't be set as a default")
+		}
+
+		b.clusterDefaults = &defaults
+		return nil
+	}
+}
+
+// WithEnforcedClusterSettings sets cluster settings which are applied to every
+// cluster during provisioning and updates. User parameters trying to change
+// them are rejected. Settings are keyed by the JSON field names used by the
+// Atlas API.
+func WithEnforcedClusterSettings(settings map[string]interface{}) Option {
+	return func(b *Broker) error {
+		// Round-trip the settings through JSON so they can be compared to
+		// decoded user parameters, and to reject unknown fields.
+		data, err := json.Marshal(settings)
+		if err != nil {
+			return fmt.Errorf("invalid enforced cluster settings: %v", err)
+		}
+
+		cluster := atlas.Cluster{}
+		if err := decodeClusterStrict(data, &cluster); err != nil {
+			return fmt.Errorf("invalid enforced cluster settings: %v", err)
+		}
+
+		if cluster.Name != "" {
+			return errors.New("the cluster name can't be enforced")
+		}
+
+		if cluster.ProviderSettings != nil && (cluster.ProviderSettings.ProviderName != "" || cluster.ProviderSettings.InstanceSizeName != "") {
+			return errors.New("the provider and instance size are dictated by the plan and can't be enforced")
+		}
+
+		normalized := map[string]interface{}{}
+		if err := json.Unmarshal(data, &normalized); err != nil {
+			return err
+		}
+
+		b.enforcedClusterSettings = normalized
+		return nil
+	}
+}
+
+// WithMinimumTLSProtocol sets the oldest TLS protocol clusters accept, for
+// example "TLS1_2". It's applied to the process arguments of every cluster
+// after provisioning and updates, parameters asking for an older protocol are
+// rejected. An empty protocol leaves it to Atlas and the parameters.
+func WithMinimumTLSProtocol(protocol string) Option {
+	return func(b *Broker) error {
+		if protocol != "" && tlsProtocolIndex(protocol) < 0 {
+			return fmt.Errorf(`unknown TLS protocol "%s", expected one of %s`, protocol, strings.Join(atlas.TLSProtocols, ", "))
+		}
+
+		b.minimumTLSProtocol = protocol
+		return nil
+	}
+}
+
+// WithMongoDBMajorVersions sets the MongoDB major versions clusters can
+// request through cluster.mongoDBMajorVersion, for example when Atlas adds a
+// version before the broker knows about it. Defaults to
+// atlas.MongoDBMajorVersions.
+func WithMongoDBMajorVersions(versions ...string) Option {
+	return func(b *Broker) error {
+		for _, version := range versions {
+			if !majorVersionPattern.MatchString(version) {
+				return fmt.Errorf(`invalid MongoDB major version "%s", expected a version such as 4.2`, version)
+			}
+		}
+
+		b.mongoDBMajorVersions = versions
+		return nil
+	}
+}
+
+// WithAccessListCleanup controls whether deprovisioning removes the project
+// IP access list entries the broker added for the instance through the
+// "ipAccessList" parameter. Disabled by default as entries are shared by all
+// clusters of the project.
+func WithAccessListCleanup(enabled bool) Option {
+	return func(b *Broker) error {
+		b.accessListCleanup = enabled
+		return nil
+	}
+}
+
+// WithAllowedConnectionStringOptions sets the options users may pass through
+// connectionString.options when binding. Defaults to
+// DefaultAllowedConnectionStringOptions, "*" allows any option.
+func WithAllowedConnectionStringOptions(options ...string) Option {
+	return func(b *Broker) error {
+		for _, option := range options {
+			if option == "" {
+				return errors.New("allowed connection string options must not be empty")
+			}
+		}
+
+		b.allowedConnectionStringOptions = options
+		return nil
+	}
+}
+
+// WithBindingCapacityCheck estimates whether the cluster of an instance has
+// connections left for another binding when binding, assuming each binding
+// uses connectionsPerBinding connections. Binds exceeding the connection
+// limit of the instance size get a warning in their credentials or are
+// rejected, depending on the policy.
+func WithBindingCapacityCheck(connectionsPerBinding int, policy string) Option {
+	return func(b *Broker) error {
+		if connectionsPerBinding < 1 {
+			return errors.New("connections per binding must be at least 1")
+		}
+
+		if policy != CapacityPolicyWarn && policy != CapacityPolicyReject {
+			return fmt.Errorf(`unknown capacity policy "%s", expected "%s" or "%s"`, policy, CapacityPolicyWarn, CapacityPolicyReject)
+		}
+
+		b.capacityCheck = &capacityCheck{connectionsPerBinding: connectionsPerBinding, policy: policy}
+		return nil
+	}
+}
+
+// WithDowngradePolicy controls updates moving clusters to a smaller instance
+// size: DowngradePolicyConfirm, the default, requires the allowDowngrade
+// parameter, DowngradePolicyAllow allows them and DowngradePolicyDeny rejects
+// them. Downgrades to instance sizes too small for the disk of the cluster are
+// always rejected.
+func WithDowngradePolicy(policy string) Option {
+	return func(b *Broker) error {
+		switch policy {
+		case DowngradePolicyConfirm, DowngradePolicyAllow, DowngradePolicyDeny:
+		default:
+			return fmt.Errorf(`unknown downgrade policy "%s", expected "%s", "%s" or "%s"`, policy, DowngradePolicyConfirm, DowngradePolicyAllow, DowngradePolicyDeny)
+		}
+
+		b.downgradePolicy = policy
+		return nil
+	}
+}
+
+// WithDefaultAppName controls whether generated connection strings include
+// an appName derived from the instance and binding IDs. Enabled by default.
+func WithDefaultAppName(enabled bool) Option {
+	return func(b *Broker) error {
+		b.defaultAppName = enabled
+		return nil
+	}
+}
+
+// WithAppNameTemplate sets a text/template used to derive the appName of
+// generated connection strings instead of the instance and binding IDs, see
+// UsernameTemplateData for the available fields. AppNames longer than drivers
+// accept are truncated.
+func WithAppNameTemplate(text string) Option {
+	return func(b *Broker) error {
+		tmpl, err := parseAppNameTemplate(text)
+		if err != nil {
+			return err
+		}
+
+		b.appNameTemplate = tmpl
+		return nil
+	}
+}
+
+// WithFeatures enables or disables features by name, see the Feature
+// constants. Features which aren't listed stay enabled.
+func WithFeatures(features map[string]bool) Option {
+	return func(b *Broker) error {
+		if b.disabledFeatures == nil {
+			b.disabledFeatures = map[string]bool{}
+		}
+
+		for _, feature := range sortedFeatures(features) {
+			if !featureKnown(feature) {
+				return fmt.Errorf(`unknown feature "%s", known features are %s`, feature, quotedList(knownFeatures))
+			}
+
+			b.disabledFeatures[feature] = !features[feature]
+		}
+
+		return nil
+	}
+}
+
+// WithLegacyCredentialKeys keeps returning the credential keys earlier
+// releases always included, even if they are empty, such as the password of
+// X.509 bindings. It eases the move to the current credentials and will be
+// removed in the next release.
+func WithLegacyCredentialKeys(enabled bool) Option {
+	return func(b *Broker) error {
+		b.legacyCredentialKeys = enabled
+		return nil
+	}
+}
+
+// WithCredentialTemplates sets per-plan templates for additional binding
+// credentials. Templates are keyed by plan ID and then by the name of the
+// credential they produce. They use text/template syntax and are rendered
+// with a CredentialTemplateData.
+func WithCredentialTemplates(templates map[string]map[string]string) Option {
+	return func(b *Broker) error {
+		parsed := map[string]credentialTemplates{}
+
+		for planID, fields := range templates {
+			planTemplates, err := parseCredentialTemplates(planID, fields)
+			if err != nil {
+				return err
+			}
+
+			parsed[planID] = planTemplates
+		}
+
+		b.credentialTemplates = parsed
+		return nil
+	}
+}
+
+// WithCredentialAliases adds credentials to the bindings of every plan, keyed
+// by the name of the credential they produce. Values either name a source
+// field ("username", "password", "uri", "hostList" or "database") or are
+// templates rendered like those of WithCredentialTemplates. Aliases can't
+// replace the standard credentials.
+func WithCredentialAliases(aliases map[string]string) Option {
+	return func(b *Broker) error {
+		parsed, err := parseCredentialAliases(aliases)
+		if err != nil {
+			return err
+		}
+
+		b.credentialAliases = parsed
+		return nil
+	}
+}
+
+// WithQuotas limits the number of instances and the plans available to
+// platform organizations and spaces. All rules matching a request have to be
+// satisfied.
+func WithQuotas(rules ...QuotaRule) Option {
+	return func(b *Broker) error {
+		for _, rule := range rules {
+			if err := rule.validate(); err != nil {
+				return err
+			}
+		}
+
+		b.quotas = rules
+		return nil
+	}
+}
+
+// WithPlanCosts adds costs to the catalog metadata of plans, keyed by plan ID.
+// Atlas pricing depends on the contract so the broker has no costs of its own.
+func WithPlanCosts(costs map[string][]brokerapi.ServicePlanCost) Option {
+	return func(b *Broker) error {
+		if err := validatePlanCosts(costs); err != nil {
+			return err
+		}
+
+		b.planCosts = costs
+		return nil
+	}
+}
+
+// WithMaxPlanCost leaves plans costing more than the ceiling per month out of
+// the catalog and rejects provisions of them and updates to them. Costs are
+// taken from WithPlanCosts and the catalog override, plans without a cost in
+// the currency of the ceiling aren't limited.
+func WithMaxPlanCost(ceiling MaxPlanCost) Option {
+	return func(b *Broker) error {
+		if err := ceiling.validate(); err != nil {
+			return err
+		}
+
+		b.maxPlanCost = &ceiling
+		return nil
+	}
+}
+
+// WithCatalogOverride customizes the names, descriptions and metadata of the
+// generated catalog and hides plans, see ReadCatalogOverrideFile. Services
+// and plans are referenced by the IDs using the configured prefix.
+func WithCatalogOverride(override CatalogOverride) Option {
+	return func(b *Broker) error {
+		b.catalogOverride = &override
+		return nil
+	}
+}
+
+// WithIDPrefix replaces DefaultIDPrefix as the prefix of the generated
+// service and plan IDs, for example to run several brokers in the same
+// marketplace. Existing instances keep the IDs they were created with, see
+// WithDefaultIDCompatibility.
+func WithIDPrefix(prefix string) Option {
+	return func(b *Broker) error {
+		if !idPrefixPattern.MatchString(prefix) {
+			return fmt.Errorf(`invalid ID prefix "%s", it may only contain letters, digits, "-", "_" and "."`, prefix)
+		}
+
+		b.idPrefix = prefix
+		return nil
+	}
+}
+
+// WithDefaultIDCompatibility makes the broker accept the IDs using
+// DefaultIDPrefix in requests after the prefix was changed, so instances
+// created before keep working. The catalog only lists the new IDs.
+func WithDefaultIDCompatibility(enabled bool) Option {
+	return func(b *Broker) error {
+		b.acceptDefaultIDs = enabled
+		return nil
+	}
+}
+
+// WithProvisionTimeout bounds the synchronous phase of provisions, the
+// default is DefaultProvisionTimeout. A shorter deadline of the request is
+// honoured as well.
+func WithProvisionTimeout(timeout time.Duration) Option {
+	return func(b *Broker) error {
+		if timeout <= 0 {
+			return errors.New("provision timeout must be positive")
+		}
+
+		b.provisionTimeout = timeout
+		return nil
+	}
+}
+
+// WithOperationTimeout fails asynchronous operations which are still in
+// progress after a duration, so clusters stuck in Atlas aren't polled
+// forever. Operations aren't limited by default.
+func WithOperationTimeout(timeout time.Duration) Option {
+	return func(b *Broker) error {
+		if timeout <= 0 {
+			return errors.New("operation timeout must be positive")
+		}
+
+		b.operationTimeout = timeout
+		return nil
+	}
+}
+
+// WithDurationEstimates replaces the estimates shown while instance
+// operations are in progress, DefaultDurationEstimates otherwise. Averages of
+// the operations the broker completes take over once there are enough of
+// them. Estimates never affect timeouts.
+func WithDurationEstimates(estimates DurationEstimates) Option {
+	return func(b *Broker) error {
+		if err := estimates.validate(); err != nil {
+			return err
+		}
+
+		b.durationEstimator = newDurationEstimator(estimates)
+		return nil
+	}
+}
+
+// WithHooks notifies hooks about the lifecycle of instances and bindings.
+func WithHooks(hooks Hooks) Option {
+	return func(b *Broker) error {
+		b.hooks = hooks
+		return nil
+	}
+}
+
+// WithEventBufferSize sets the number of events kept for Events. Older events
+// are dropped, zero stops recording events.
+func WithEventBufferSize(size int) Option {
+	return func(b *Broker) error {
+		switch {
+		case size < 0:
+			return errors.New("event buffer size must not be negative")
+		case size == 0:
+			b.events = nil
+		default:
+			b.events = newEventLog(size)
+		}
+
+		return nil
+	}
+}
+
+// WithConnectionProbe makes the broker check that a new cluster resolves and
+// accepts TLS connections before reporting the provision as successful. The
+// provision is kept in progress for up to maxWait after the cluster became
+// idle. Instances can opt out with the skipConnectionProbe parameter.
+func WithConnectionProbe(maxWait time.Duration) Option {
+	return func(b *Broker) error {
+		if maxWait <= 0 {
+			return errors.New("the connection probe wait must be positive")
+		}
+
+		b.connectionProbe = newConnectionProbe(maxWait)
+		return nil
+	}
+}
+
+// WithWaitForUser makes binds wait for up to timeout until Atlas has deployed
+// the changes of the project, so apps can usually use the credentials right
+// away. Atlas only reports whether the project as a whole has pending
+// changes, not whether the new database user has been deployed. Unrelated
+// changes, such as another bind or a scaling cluster, keep binds waiting up
+// to the timeout, and a finished wait doesn't guarantee the user can
+// authenticate yet. Binds can opt out with the waitForUser parameter, and opt
+// in if this option isn't passed.
+func WithWaitForUser(timeout time.Duration) Option {
+	return func(b *Broker) error {
+		if timeout <= 0 {
+			return errors.New("the user wait timeout must be positive")
+		}
+
+		b.waitForUser = true
+		b.userWaitTimeout = timeout
+		return nil
+	}
+}
+
+// WithCredentialStore keeps the credentials of new bindings in a store so
+// they can be fetched again with GetBinding, which the catalog advertises
+// then. The credentials are encrypted with a key of CredentialStoreKeySize
+// bytes.
+func WithCredentialStore(store CredentialStore, key []byte) Option {
+	return func(b *Broker) error {
+		cipher, err := newCredentialCipher(key)
+		if err != nil {
+			return err
+		}
+
+		b.credentialStore = store
+		b.credentialCipher = cipher
+		return nil
+	}
+}
+
+// WithMaintenance starts the broker in maintenance mode, in which changes to
+// instances and bindings are rejected with 503 Service Unavailable. It can be
+// switched at runtime with SetMaintenance.
+func WithMaintenance(enabled bool) Option {
+	return func(b *Broker) error {
+		b.maintenance.set(enabled)
+		return nil
+	}
+}
+
+// WithMaintenanceMessage sets the message of operations rejected during
+// maintenance and how long clients are asked to wait before retrying. Empty
+// messages and durations below one second keep the defaults.
+func WithMaintenanceMessage(message string, retryAfter time.Duration) Option {
+	return func(b *Broker) error {
+		if message != "" {
+			b.maintenance.message = message
+		}
+		if retryAfter >= time.Second {
+			b.maintenance.retryAfter = retryAfter
+		}
+		return nil
+	}
+}
+
+// WithClock replaces the real clock used for timeouts, polling and delays,
+// for example with a clock.Fake in tests.
+func WithClock(c clock.Clock) Option {
+	return func(b *Broker) error {
+		if c == nil {
+			return errors.New("clock must not be nil")
+		}
+
+		b.clock = c
+		return nil
+	}
+}
+
+// WithDefaultPlatform sets the platform assumed for bindings whose request
+// and instance don't specify one. Credentials are flattened into strings for
+// "kubernetes" and returned as they are for "cloudfoundry".
+func WithDefaultPlatform(platform string) Option {
+	return func(b *Broker) error {
+		switch platform {
+		case "", PlatformKubernetes, PlatformCloudFoundry:
+		default:
+			return fmt.Errorf(`unknown platform "%s", expected "%s" or "%s"`, platform, PlatformKubernetes, PlatformCloudFoundry)
+		}
+
+		b.defaultPlatform = platform
+		return nil
+	}
+}
+
+// WithCredentialStyle sets the shape of binding credentials for bindings
+// which don't pass the "credentialStyle" parameter. CredentialStyleDefault
+// keeps the shape of the platform, CredentialStyleServiceBinding returns the
+// flat keys of the Kubernetes Service Binding specification.
+func WithCredentialStyle(style string) Option {
+	return func(b *Broker) error {
+		if validateCredentialStyle(style) != nil {
+			return fmt.Errorf(`unknown credential style "%s", expected "%s" or "%s"`, style, CredentialStyleDefault, CredentialStyleServiceBinding)
+		}
+
+		b.credentialStyle = style
+		return nil
+	}
+}
+
+// WithPools configures warm pools of pre-created clusters. Provisioning a
+// pooled plan without parameters claims one of its clusters and completes
+// immediately. Pools with a size of zero are ignored.
+func WithPools(configs ...PoolConfig) Option {
+	return func(b *Broker) error {
+		enabled := false
+		for _, config := range configs {
+			if err := config.validate(); err != nil {
+				return err
+			}
+
+			enabled = enabled || config.Size > 0
+		}
+
+		if enabled {
+			b.pool = newPool(configs)
+		}
+
+		return nil
+	}
+}
+
+// WithAdaptivePolling slows down polling of projects whose Atlas rate limit
+// budget has dropped below threshold requests. Their LastOperation polls are
+// answered from the previous poll and background work, such as replenishing
+// warm pools, is delayed, each for the passed interval. Disabled by default.
+func WithAdaptivePolling(threshold int, interval time.Duration) Option {
+	return func(b *Broker) error {
+		if threshold <= 0 {
+			return errors.New("the adaptive polling threshold must be positive")
+		}
+
+		if interval <= 0 {
+			return errors.New("the adaptive polling interval must be positive")
+		}
+
+		b.rateLimits.threshold = threshold
+		b.rateLimits.interval = interval
+		return nil
+	}
+}
+
+// WithMetricsRegistry registers the broker's metrics, such as the operation
+// counters, the remaining Atlas rate limit budget and the maintenance mode,
+// with a registry.
+func WithMetricsRegistry(registry *metrics.Registry) Option {
+	return func(b *Broker) error {
+		if registry == nil {
+			return errors.New("metrics registry must not be nil")
+		}
+
+		registry.Register(b.operations, b.rateLimits.remaining, b.maintenance.enabledGauge)
+		return nil
+	}
+}
+
+// WithLogger replaces the logger passed to the constructor. Operations log
+// through child loggers carrying the instance and binding IDs, so fields added
+// to this logger are included in every line.
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(b *Broker) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+
+		b.logger = logger
+		return nil
+	}
+}
+
+// decodeClusterStrict decodes a cluster and rejects unknown fields. The
+// lenient decoder of atlas.Cluster is bypassed as it ignores them.
+func decodeClusterStrict(data []byte, cluster *atlas.Cluster) error {
+	type strictCluster atlas.Cluster
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode((*strictCluster)(cluster))
+}
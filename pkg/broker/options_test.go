@@ -0,0 +1,302 @@
+package broker
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewDefaults(t *testing.T) {
+	broker, err := New(zap.NewNop().Sugar())
+
+	assert.NoError(t, err)
+	assert.Nil(t, broker.whitelist)
+	assert.False(t, broker.strictPreviousValues)
+	assert.Equal(t, "aaaaaaaa-bbbb-cccc-dddd", broker.namer.ClusterName("aaaaaaaa-bbbb-cccc-dddd-eeeeeeeeeeee"))
+	assert.Equal(t, []atlas.Role{
+		atlas.Role{
+			Name:         "readWriteAnyDatabase",
+			DatabaseName: "admin",
+		},
+	}, broker.defaultUserRoles)
+}
+
+func TestNewBrokerInvalidOption(t *testing.T) {
+	assert.Panics(t, func() {
+		NewBroker(zap.NewNop().Sugar(), WithDefaultUserRoles())
+	})
+}
+
+func TestWithWhitelist(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithWhitelist(Whitelist{"UNKNOWN": []string{"M10"}}))
+	assert.Error(t, err)
+
+	broker, err := New(zap.NewNop().Sugar(), WithWhitelist(Whitelist{"AWS": []string{"M10"}}))
+	assert.NoError(t, err)
+	assert.Equal(t, Whitelist{"AWS": []string{"M10"}}, broker.whitelist)
+}
+
+func TestWithDefaultUserRoles(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithDefaultUserRoles())
+	assert.Error(t, err, "Expected an empty list of roles to be rejected")
+
+	_, err = New(zap.NewNop().Sugar(), WithDefaultUserRoles(atlas.Role{Name: "read"}))
+	assert.Error(t, err, "Expected a role without database to be rejected")
+
+	role := atlas.Role{
+		Name:         "readAnyDatabase",
+		DatabaseName: "admin",
+	}
+	broker, client, ctx := setupTest(WithDefaultUserRoles(role))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	bindingID := "binding"
+	_, err = broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, []atlas.Role{role}, client.Users[bindingID].Roles)
+}
+
+func TestWithClusterNameTemplate(t *testing.T) {
+	invalidTemplates := []string{
+		"{{.InstanceID",
+		"{{.Unknown}}",
+		"prod_{{.InstanceID}}",
+		"",
+	}
+
+	for _, text := range invalidTemplates {
+		_, err := New(zap.NewNop().Sugar(), WithClusterNameTemplate(text))
+		assert.Errorf(t, err, "Expected template %q to be rejected", text)
+	}
+
+	broker, client, ctx := setupTest(WithClusterNameTemplate("prod-{{.InstanceID}}"))
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.NotNil(t, client.Clusters["prod-instance"])
+
+	_, err = broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Nil(t, client.Clusters["prod-instance"])
+}
+
+func TestWithStrictPreviousValues(t *testing.T) {
+	broker, err := New(zap.NewNop().Sugar(), WithStrictPreviousValues(true))
+
+	assert.NoError(t, err)
+	assert.True(t, broker.strictPreviousValues)
+}
+
+func TestWithClusterDefaults(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithClusterDefaults(atlas.Cluster{Name: "name"}))
+	assert.Error(t, err, "Expected the name to be rejected as default")
+
+	broker, client, ctx := setupTest(WithClusterDefaults(atlas.Cluster{
+		MongoDBMajorVersion:   "4.0",
+		ProviderBackupEnabled: true,
+	}))
+
+	// Defaults are applied during provisioning and can be overridden.
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"mongoDBMajorVersion": "4.2"}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "4.2", client.Clusters[instanceID].MongoDBMajorVersion)
+	assert.True(t, client.Clusters[instanceID].ProviderBackupEnabled)
+
+	// Defaults are not applied again during updates.
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"providerBackupEnabled": false}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"instance"}`, updatePayload(t, client, instanceID))
+}
+
+func TestWithEnforcedClusterSettings(t *testing.T) {
+	invalidSettings := []map[string]interface{}{
+		{"unknownSetting": true},
+		{"name": "name"},
+		{"providerSettings": map[string]interface{}{"instanceSizeName": "M30"}},
+		{"mongoDBMajorVersion": 4.2},
+	}
+
+	for _, settings := range invalidSettings {
+		_, err := New(zap.NewNop().Sugar(), WithEnforcedClusterSettings(settings))
+		assert.Errorf(t, err, "Expected settings %v to be rejected", settings)
+	}
+
+	broker, client, ctx := setupTest(WithEnforcedClusterSettings(map[string]interface{}{
+		"providerBackupEnabled": true,
+		"providerSettings": map[string]interface{}{
+			"regionName": "EU_WEST_1",
+		},
+	}))
+
+	// Enforced settings are applied during provisioning and requesting the
+	// same value is allowed.
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"providerBackupEnabled": true}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.True(t, client.Clusters[instanceID].ProviderBackupEnabled)
+	assert.Equal(t, "EU_WEST_1", client.Clusters[instanceID].ProviderSettings.RegionName)
+
+	// Changing enforced settings is rejected naming the locked fields.
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"providerBackupEnabled": false, "providerSettings": {"regionName": "US_EAST_1"}}}`),
+	}, true)
+
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(*apiresponses.FailureResponse).ValidatedStatusCode(nil))
+		assert.Contains(t, err.Error(), "cluster.providerBackupEnabled")
+		assert.Contains(t, err.Error(), "cluster.providerSettings.regionName")
+	}
+
+	// Updates re-enforce the settings.
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"name":"instance","diskSizeGB":20,"providerBackupEnabled":true,"providerSettings":{"providerName":"AWS","instanceSizeName":"M10","regionName":"EU_WEST_1"}}`, updatePayload(t, client, instanceID))
+}
+
+func TestWithAllowedConnectionStringOptions(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithAllowedConnectionStringOptions(""))
+	assert.Error(t, err)
+
+	broker, client, ctx := setupTest(WithAllowedConnectionStringOptions("appName"))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"connectionString": {"options": {"w": "majority"}}}`),
+	}, true)
+
+	if assert.Error(t, err) {
+		assert.Equal(t, http.StatusBadRequest, err.(*apiresponses.FailureResponse).ValidatedStatusCode(nil))
+		assert.Contains(t, err.Error(), "connectionString.options.w")
+	}
+	assert.Nil(t, client.Users["binding"], "Expected no user to have been created")
+}
+
+func TestWithDefaultAppName(t *testing.T) {
+	broker, client, ctx := setupTest(WithDefaultAppName(false))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"connectionString": {}}`),
+	}, true)
+
+	if assert.NoError(t, err) {
+		assert.NotContains(t, spec.Credentials.(ConnectionDetails).URI, "appName")
+	}
+}
+
+func TestWithAppNameTemplate(t *testing.T) {
+	broker, client, ctx := setupTest(WithAppNameTemplate("{{.SpaceName}}/{{.BindingID}}"))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawContext:    []byte(`{"platform": "cloudfoundry", "space_guid": "space-guid", "space_name": "orders"}`),
+		RawParameters: []byte(`{"connectionString": {}}`),
+	}, true)
+
+	if assert.NoError(t, err) {
+		assert.Contains(t, spec.Credentials.(ConnectionDetails).URI, "appName=orders%2Fbinding&")
+	}
+
+	// Disabling the default appName disables the template too.
+	broker, client, ctx = setupTest(WithAppNameTemplate("{{.BindingID}}"), WithDefaultAppName(false))
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	spec, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"connectionString": {}}`),
+	}, true)
+
+	if assert.NoError(t, err) {
+		assert.NotContains(t, spec.Credentials.(ConnectionDetails).URI, "appName")
+	}
+
+	for _, text := range []string{"{{.Unknown}}", "{{.BindingID"} {
+		_, err := New(zap.NewNop().Sugar(), WithAppNameTemplate(text))
+		assert.Error(t, err, text)
+	}
+
+	tmpl, err := parseAppNameTemplate("{{.BindingID}}-ü")
+	if assert.NoError(t, err) {
+		appName, err := executeAppNameTemplate(tmpl, UsernameTemplateData{BindingID: strings.Repeat("a", maximumAppNameLength-2)})
+		assert.NoError(t, err)
+		assert.Equal(t, strings.Repeat("a", maximumAppNameLength-2)+"-", appName)
+	}
+}
+
+func TestWithMetricsRegistryNil(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithMetricsRegistry(nil))
+	assert.EqualError(t, err, "metrics registry must not be nil")
+}
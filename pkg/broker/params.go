@@ -0,0 +1,677 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// ParamsVersion is the version of the parameter format understood by
+// ClusterFromParams. It will only be incremented when a previously valid
+// parameter document changes meaning or becomes invalid.
+const ParamsVersion = 1
+
+// PlanContext describes everything apart from the user parameters that goes
+// into constructing a cluster.
+type PlanContext struct {
+	// InstanceID is the ID of the service instance. It is used to derive the
+	// cluster name unless ClusterName is set.
+	InstanceID  string
+	ClusterName string
+
+	// Provider and InstanceSize are resolved from the service and plan IDs.
+	// Both may be nil during updates which don't change the plan.
+	Provider     *atlas.Provider
+	InstanceSize *atlas.InstanceSize
+
+	// CurrentProvider is the provider of the existing cluster during updates
+	// which don't change the plan. It can't be changed by the parameters.
+	CurrentProvider string
+
+	// Existing is the cluster being updated, nil during provisioning. Changes
+	// of the cluster type and shards are validated against it.
+	Existing *atlas.Cluster
+
+	// Defaults are operator supplied cluster settings. They are applied on
+	// top of the plan but can be overridden by user parameters.
+	Defaults *atlas.Cluster
+
+	// Enforced are operator supplied cluster settings which are applied on
+	// top of the user parameters. Parameters trying to change them are
+	// rejected. Keys are the JSON field names of atlas.Cluster, nested objects
+	// lock each of their fields individually.
+	Enforced map[string]interface{}
+
+	// MongoDBMajorVersions are the versions clusters can run, from the
+	// oldest to the newest. Defaults to atlas.MongoDBMajorVersions.
+	MongoDBMajorVersions []string
+}
+
+// providerName returns the provider dictated by the plan or the existing
+// cluster. It's empty if neither is known.
+func (planCtx PlanContext) providerName() string {
+	if planCtx.Provider != nil && planCtx.InstanceSize != nil {
+		return clusterProviderName(planCtx.Provider.Name, planCtx.InstanceSize.Name)
+	}
+
+	return planCtx.CurrentProvider
+}
+
+// FieldViolation describes a single invalid field in a parameter document.
+type FieldViolation struct {
+	// Field is the JSON path to the offending field, for example
+	// "cluster.diskSizeGB". It is empty if the document as a whole is invalid.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (v FieldViolation) String() string {
+	if v.Field == "" {
+		return v.Message
+	}
+
+	return fmt.Sprintf("%s: %s", v.Field, v.Message)
+}
+
+// ValidationError is returned when a parameter document is invalid. It holds
+// one violation per offending field.
+type ValidationError struct {
+	Violations []FieldViolation `json:"violations"`
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Violations))
+	for i, violation := range e.Violations {
+		messages[i] = violation.String()
+	}
+
+	return "invalid parameters: " + strings.Join(messages, "; ")
+}
+
+// add records a new violation for field.
+func (e *ValidationError) add(field string, format string, args ...interface{}) {
+	e.Violations = append(e.Violations, FieldViolation{
+		Field:   field,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// errorOrNil returns the validation error if any violations were recorded.
+func (e *ValidationError) errorOrNil() error {
+	if len(e.Violations) == 0 {
+		return nil
+	}
+
+	return e
+}
+
+// ClusterFromParams constructs and validates a cluster from a plan and a raw
+// parameter document of the form {"cluster": {...}}. Settings are merged in
+// order of increasing precedence: the plan, the operator defaults, the user
+// parameters and finally the enforced operator settings. The provider and
+// instance size of the plan can't be overridden by any of them, parameters
+// trying to are rejected. Only the provider settings in
+// overridableProviderSettings may be passed.
+//
+// Invalid parameters result in a *ValidationError. Provision and Update use
+// this function directly so external tools can rely on it to pre-validate
+// parameters using the exact same rules as the broker.
+func ClusterFromParams(planCtx PlanContext, rawParams []byte) (*atlas.Cluster, error) {
+	cluster := &atlas.Cluster{}
+
+	// The plan dictates the provider settings.
+	if planCtx.Provider != nil && planCtx.InstanceSize != nil {
+		cluster.ProviderSettings = &atlas.ProviderSettings{
+			ProviderName:     planCtx.Provider.Name,
+			InstanceSizeName: planCtx.InstanceSize.Name,
+		}
+	}
+
+	// Operator defaults are layered on top by round-tripping them through
+	// JSON, this way only the fields which have been set are merged.
+	if planCtx.Defaults != nil {
+		defaults, err := json.Marshal(planCtx.Defaults)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := json.Unmarshal(defaults, cluster); err != nil {
+			return nil, err
+		}
+	}
+
+	// Set up a params object which will be used for deserialiation. The
+	// cluster is decoded separately as atlas.Cluster has its own decoder which
+	// reports fields relative to the cluster.
+	params := struct {
+		Cluster json.RawMessage `json:"cluster"`
+	}{}
+
+	// If params were passed we unmarshal them into the params object.
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	if len(params.Cluster) > 0 {
+		if err := json.Unmarshal(params.Cluster, cluster); err != nil {
+			if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+				typeErr.Field = "cluster." + typeErr.Field
+			}
+
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	if err := checkProviderSettings(planCtx, params.Cluster); err != nil {
+		return nil, err
+	}
+
+	// Enforced settings are applied last, after making sure the parameters
+	// don't try to change them.
+	if len(planCtx.Enforced) > 0 {
+		if err := applyEnforcedSettings(cluster, planCtx.Enforced, rawParams); err != nil {
+			return nil, err
+		}
+	}
+
+	// Re-apply the plan in case defaults or parameters tried to override it.
+	if planCtx.Provider != nil && planCtx.InstanceSize != nil {
+		if cluster.ProviderSettings == nil {
+			cluster.ProviderSettings = &atlas.ProviderSettings{}
+		}
+
+		cluster.ProviderSettings.InstanceSizeName = planCtx.InstanceSize.Name
+
+		// Shared plans of a provider run on the tenant provider backed by it.
+		if isSharedInstanceSizeName(planCtx.InstanceSize.Name) && planCtx.Provider.Name != providerNameTenant {
+			cluster.ProviderSettings.BackingProviderName = planCtx.Provider.Name
+		}
+	}
+
+	// Keep the provider stable, operator settings apply to all providers so
+	// the ones it doesn't support are dropped.
+	if providerName := planCtx.providerName(); providerName != "" && cluster.ProviderSettings != nil {
+		cluster.ProviderSettings.ProviderName = providerName
+
+		if providerName != providerNameAWS {
+			clearAWSOnlySettings(cluster.ProviderSettings)
+		}
+	}
+
+	if planCtx.Existing != nil {
+		carryOverZoneIDs(cluster, planCtx.Existing)
+	}
+
+	// Add the instance ID as the name of the cluster.
+	cluster.Name = planCtx.ClusterName
+	if cluster.Name == "" {
+		cluster.Name = Namer{}.ClusterName(planCtx.InstanceID)
+	}
+
+	if err := validateCluster(planCtx, cluster, params.Cluster); err != nil {
+		return nil, err
+	}
+
+	return cluster, nil
+}
+
+// awsOnlyProviderSettings are the JSON names of the provider settings which
+// only exist for AWS clusters.
+var awsOnlyProviderSettings = []string{"diskIOPS", "encryptEBSVolume", "volumeType"}
+
+// overridableProviderSettings are the JSON names of the provider settings
+// users may pass. The provider and instance size are dictated by the plan.
+var overridableProviderSettings = []string{"regionName", "autoScaling", "diskIOPS", "diskTypeName", "encryptEBSVolume", "volumeType"}
+
+// checkProviderSettings rejects user parameters which try to override the
+// provider settings dictated by the plan, set provider settings which aren't
+// on the allow-list, or set AWS-only provider settings for a cluster on
+// another provider. Without a plan the existing cluster dictates the provider
+// settings. The provider is taken from the parameters if neither is known.
+func checkProviderSettings(planCtx PlanContext, rawCluster json.RawMessage) error {
+	params := struct {
+		ProviderSettings map[string]json.RawMessage `json:"providerSettings"`
+	}{}
+
+	if len(rawCluster) > 0 {
+		if err := json.Unmarshal(rawCluster, &params); err != nil {
+			return validationErrorFromJSON(err)
+		}
+	}
+
+	// Field names are matched like the JSON decoder does, ignoring case.
+	settings := map[string]string{}
+	verr := &ValidationError{}
+	for key, raw := range params.ProviderSettings {
+		name := providerSettingName(key)
+		if name == "" {
+			verr.add("cluster.providerSettings."+key, "is not a supported provider setting, expected one of %s", strings.Join(overridableProviderSettings, ", "))
+			continue
+		}
+
+		var value string
+		json.Unmarshal(raw, &value)
+		settings[name] = value
+	}
+
+	// Parameters may repeat the dictated values, for example when they were
+	// copied from the cluster.
+	dictated, backingDictated := planCtx.providerSettings()
+	if value, isSet := settings["instanceSizeName"]; isSet && dictated.InstanceSizeName != "" && value != dictated.InstanceSizeName {
+		verr.add("cluster.providerSettings.instanceSizeName", `can't be set to "%s" as the plan dictates "%s", change the plan instead`, value, dictated.InstanceSizeName)
+	}
+
+	if value, isSet := settings["providerName"]; isSet && dictated.ProviderName != "" && value != dictated.ProviderName {
+		verr.add("cluster.providerSettings.providerName", `can't be set to "%s" as the plan dictates "%s"`, value, dictated.ProviderName)
+	}
+
+	if value, isSet := settings["backingProviderName"]; isSet && backingDictated && value != dictated.BackingProviderName {
+		verr.add("cluster.providerSettings.backingProviderName", `can't be set to "%s" as the plan dictates "%s"`, value, dictated.BackingProviderName)
+	}
+
+	providerName := dictated.ProviderName
+	if providerName == "" {
+		providerName = settings["providerName"]
+	}
+
+	if providerName != "" && providerName != providerNameAWS {
+		for _, field := range awsOnlyProviderSettings {
+			if _, isSet := settings[field]; isSet {
+				verr.add("cluster.providerSettings."+field, `is only supported by provider "%s", not "%s"`, providerNameAWS, providerName)
+			}
+		}
+	}
+
+	return verr.errorOrNil()
+}
+
+// providerSettingName returns the JSON name of a provider setting users may
+// pass, ignoring case. It's empty for unknown settings.
+func providerSettingName(key string) string {
+	names := append([]string{"providerName", "instanceSizeName", "backingProviderName"}, overridableProviderSettings...)
+	for _, name := range names {
+		if strings.EqualFold(key, name) {
+			return name
+		}
+	}
+
+	return ""
+}
+
+// providerSettings returns the provider settings dictated by the plan, or by
+// the existing cluster during updates which don't change the plan. Settings
+// which aren't dictated by either are empty. The backing provider is only
+// dictated if backingDictated is set, dedicated clusters must not have one
+// and the tenant service leaves it to the user.
+func (planCtx PlanContext) providerSettings() (settings atlas.ProviderSettings, backingDictated bool) {
+	settings.ProviderName = planCtx.providerName()
+
+	switch {
+	case planCtx.Provider != nil && planCtx.InstanceSize != nil:
+		settings.InstanceSizeName = planCtx.InstanceSize.Name
+		backingDictated = planCtx.Provider.Name != providerNameTenant
+
+		if isSharedInstanceSizeName(planCtx.InstanceSize.Name) && backingDictated {
+			settings.BackingProviderName = planCtx.Provider.Name
+		}
+	case planCtx.Existing != nil && planCtx.Existing.ProviderSettings != nil:
+		settings.InstanceSizeName = planCtx.Existing.ProviderSettings.InstanceSizeName
+		settings.BackingProviderName = planCtx.Existing.ProviderSettings.BackingProviderName
+		backingDictated = true
+	}
+
+	return settings, backingDictated
+}
+
+// clearAWSOnlySettings removes the AWS-only settings from provider settings.
+func clearAWSOnlySettings(settings *atlas.ProviderSettings) {
+	settings.DiskIOPS = 0
+	settings.EncryptEBSVolume = false
+	settings.VolumeType = ""
+}
+
+// applyEnforcedSettings merges the enforced settings into a cluster. A
+// validation error naming the locked fields is returned if the parameters set
+// any of them to a different value.
+func applyEnforcedSettings(cluster *atlas.Cluster, enforced map[string]interface{}, rawParams []byte) error {
+	params := struct {
+		Cluster map[string]interface{} `json:"cluster"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return validationErrorFromJSON(err)
+		}
+	}
+
+	verr := &ValidationError{}
+	checkEnforcedSettings(verr, "cluster", enforced, params.Cluster)
+	if err := verr.errorOrNil(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(enforced)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, cluster)
+}
+
+// checkEnforcedSettings records a violation for every enforced field which is
+// requested with a different value.
+func checkEnforcedSettings(verr *ValidationError, path string, enforced map[string]interface{}, requested map[string]interface{}) {
+	keys := make([]string, 0, len(enforced))
+	for key := range enforced {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		field := path + "." + key
+
+		value, isSet := requested[key]
+		if !isSet {
+			continue
+		}
+
+		nestedEnforced, isObject := enforced[key].(map[string]interface{})
+		nestedRequested, isRequestedObject := value.(map[string]interface{})
+		if isObject && isRequestedObject {
+			checkEnforcedSettings(verr, field, nestedEnforced, nestedRequested)
+			continue
+		}
+
+		if !reflect.DeepEqual(enforced[key], value) {
+			verr.add(field, "is enforced by the broker and can't be changed")
+		}
+	}
+}
+
+// validateCluster performs basic sanity checks of a cluster definition before
+// it's sent to Atlas. The raw cluster parameters are needed to tell settings
+// which are explicitly zero from missing ones.
+func validateCluster(planCtx PlanContext, cluster *atlas.Cluster, rawCluster json.RawMessage) error {
+	verr := &ValidationError{}
+
+	switch cluster.ClusterType {
+	case "", atlas.ClusterTypeReplicaSet, atlas.ClusterTypeSharded, atlas.ClusterTypeGeoSharded:
+	default:
+		verr.add("cluster.clusterType", `must be one of "%s", "%s" or "%s"`, atlas.ClusterTypeReplicaSet, atlas.ClusterTypeSharded, atlas.ClusterTypeGeoSharded)
+	}
+
+	if cluster.DiskSizeGB < 0 {
+		verr.add("cluster.diskSizeGB", "must not be negative")
+	}
+
+	validateSharding(verr, planCtx, cluster, rawCluster)
+	validateMajorVersion(verr, planCtx, cluster)
+	validateComputeAutoScaling(verr, planCtx, cluster)
+
+	validateLabels(verr, "cluster.labels", cluster.Labels)
+
+	return verr.errorOrNil()
+}
+
+// majorVersionPattern matches MongoDB major versions such as "4.2".
+var majorVersionPattern = regexp.MustCompile(`^\d+\.\d+$`)
+
+// validateMajorVersion checks the MongoDB version of a cluster against the
+// supported versions. Atlas can't downgrade clusters, so updates may only
+// keep or raise the version of the existing cluster. Clusters on continuous
+// releases get their version from Atlas and can't pin one, and existing
+// clusters can only move to continuous releases from the newest major
+// version.
+func validateMajorVersion(verr *ValidationError, planCtx PlanContext, cluster *atlas.Cluster) {
+	supported := planCtx.MongoDBMajorVersions
+	if supported == nil {
+		supported = atlas.MongoDBMajorVersions
+	}
+
+	releaseSystem := cluster.VersionReleaseSystem
+	switch releaseSystem {
+	case "", atlas.VersionReleaseSystemLTS, atlas.VersionReleaseSystemContinuous:
+	default:
+		verr.add("cluster.versionReleaseSystem", `must be "%s" or "%s"`, atlas.VersionReleaseSystemLTS, atlas.VersionReleaseSystemContinuous)
+		return
+	}
+
+	// Updates which don't mention the release system keep the existing one.
+	existing := planCtx.Existing
+	if releaseSystem == "" && existing != nil {
+		releaseSystem = existing.VersionReleaseSystem
+	}
+
+	version := cluster.MongoDBMajorVersion
+	if releaseSystem == atlas.VersionReleaseSystemContinuous {
+		switch {
+		case version != "":
+			verr.add("cluster.mongoDBMajorVersion", `can't be set with versionReleaseSystem "%s", Atlas chooses the version`, atlas.VersionReleaseSystemContinuous)
+		case existing != nil && existing.VersionReleaseSystem != atlas.VersionReleaseSystemContinuous && existing.MongoDBMajorVersion != "" &&
+			compareMajorVersions(existing.MongoDBMajorVersion, supported[len(supported)-1]) < 0:
+			verr.add("cluster.versionReleaseSystem", `can only be changed to "%s" once the cluster runs the newest major version "%s", it runs "%s"`, atlas.VersionReleaseSystemContinuous, supported[len(supported)-1], existing.MongoDBMajorVersion)
+		}
+
+		return
+	}
+
+	if version == "" {
+		return
+	}
+
+	if !containsString(supported, version) {
+		verr.add("cluster.mongoDBMajorVersion", "must be one of %s", quotedList(supported))
+		return
+	}
+
+	if existing == nil || existing.MongoDBMajorVersion == "" {
+		return
+	}
+
+	// Clusters leaving continuous releases run a rapid release such as
+	// "7.3", they can only move on to the next major version.
+	if compareMajorVersions(version, existing.MongoDBMajorVersion) < 0 {
+		verr.add("cluster.mongoDBMajorVersion", `must not be older than "%s" which the cluster runs, downgrades aren't supported`, existing.MongoDBMajorVersion)
+	}
+}
+
+// compareMajorVersions compares two versions of the form "4.2", returning a
+// negative number if a is older than b, zero if they are equal and a positive
+// number if a is newer. Parts which aren't numbers count as zero.
+func compareMajorVersions(a string, b string) int {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+
+	for i := 0; i < len(partsA) || i < len(partsB); i++ {
+		var numberA, numberB int
+		if i < len(partsA) {
+			numberA, _ = strconv.Atoi(partsA[i])
+		}
+		if i < len(partsB) {
+			numberB, _ = strconv.Atoi(partsB[i])
+		}
+
+		if numberA != numberB {
+			return numberA - numberB
+		}
+	}
+
+	return 0
+}
+
+// MaxNumShards is the largest number of shards a cluster can have.
+const MaxNumShards = 50
+
+// minShardedInstanceSize is the smallest instance size, in the numbering of
+// the instance size names, which can be sharded.
+const minShardedInstanceSize = 30
+
+// instanceSizeNumberPattern extracts the number of instance size names such as
+// "M30", "R40" or "M40_NVME".
+var instanceSizeNumberPattern = regexp.MustCompile(`^[MR](\d+)`)
+
+// validateSharding checks the cluster type and number of shards. During
+// updates settings missing from the parameters are taken from the existing
+// cluster. Sharded clusters can't be changed to another type.
+func validateSharding(verr *ValidationError, planCtx PlanContext, cluster *atlas.Cluster, rawCluster json.RawMessage) {
+	params := struct {
+		NumShards *uint `json:"numShards"`
+	}{}
+
+	// The parameters have already been decoded successfully, so errors
+	// can be ignored.
+	if len(rawCluster) > 0 {
+		json.Unmarshal(rawCluster, &params)
+	}
+
+	if (params.NumShards != nil && *params.NumShards < 1) || cluster.NumShards > MaxNumShards {
+		verr.add("cluster.numShards", "must be between 1 and %d", MaxNumShards)
+	}
+
+	existingType, instanceSizeName := "", ""
+	if planCtx.Existing != nil {
+		existingType = planCtx.Existing.ClusterType
+		if planCtx.Existing.ProviderSettings != nil {
+			instanceSizeName = planCtx.Existing.ProviderSettings.InstanceSizeName
+		}
+	}
+
+	if cluster.ProviderSettings != nil && cluster.ProviderSettings.InstanceSizeName != "" {
+		instanceSizeName = cluster.ProviderSettings.InstanceSizeName
+	}
+
+	clusterType := cluster.ClusterType
+	if clusterType == "" {
+		clusterType = existingType
+	}
+	if clusterType == "" {
+		clusterType = atlas.ClusterTypeReplicaSet
+	}
+
+	if isShardedClusterType(existingType) && clusterType != existingType {
+		verr.add("cluster.clusterType", `can't be changed from "%s" to "%s"`, existingType, clusterType)
+		return
+	}
+
+	if !isShardedClusterType(clusterType) {
+		if cluster.NumShards > 1 {
+			verr.add("cluster.numShards", `must be 1 for clusters of type "%s"`, clusterType)
+		}
+		return
+	}
+
+	if instanceSizeName != "" && !isShardableInstanceSize(instanceSizeName) {
+		verr.add("cluster.clusterType", `"%s" requires an instance size of M%d or larger, not "%s"`, clusterType, minShardedInstanceSize, instanceSizeName)
+	}
+
+	if clusterType == atlas.ClusterTypeGeoSharded {
+		validateZones(verr, planCtx, cluster)
+	}
+}
+
+// zonePriority is the election priority of the region holding the primaries
+// of a zone.
+const zonePriority = 7
+
+// validateZones checks the zones of a geo-sharded cluster, which are its
+// replication specs. Every zone needs a unique name and an electable region
+// with the highest priority. Existing zones can't be left out of updates.
+func validateZones(verr *ValidationError, planCtx PlanContext, cluster *atlas.Cluster) {
+	if len(cluster.ReplicationSpecs) == 0 {
+		if planCtx.Existing == nil {
+			verr.add("cluster.replicationSpecs", `must contain at least one zone for clusters of type "%s"`, atlas.ClusterTypeGeoSharded)
+		}
+		return
+	}
+
+	zoneNames := map[string]int{}
+	for i, spec := range cluster.ReplicationSpecs {
+		field := fmt.Sprintf("cluster.replicationSpecs[%d]", i)
+
+		if spec.ZoneName == "" {
+			verr.add(field+".zoneName", "must not be empty")
+		} else if other, isDuplicate := zoneNames[spec.ZoneName]; isDuplicate {
+			verr.add(field+".zoneName", `must be unique, "%s" is also used by cluster.replicationSpecs[%d]`, spec.ZoneName, other)
+		} else {
+			zoneNames[spec.ZoneName] = i
+		}
+
+		hasPrimaryRegion := false
+		for _, region := range spec.RegionsConfig {
+			hasPrimaryRegion = hasPrimaryRegion || (region.ElectableNodes > 0 && region.Priority == zonePriority)
+		}
+
+		if !hasPrimaryRegion {
+			verr.add(field+".regionsConfig", "must contain a region with electable nodes and priority %d", zonePriority)
+		}
+	}
+
+	if planCtx.Existing == nil {
+		return
+	}
+
+	for _, existing := range planCtx.Existing.ReplicationSpecs {
+		if _, isKept := zoneNames[existing.ZoneName]; existing.ZoneName != "" && !isKept {
+			verr.add("cluster.replicationSpecs", `must contain the existing zone "%s", zones can only be added`, existing.ZoneName)
+		}
+	}
+}
+
+// carryOverZoneIDs sets the IDs of the zones an update keeps, which Atlas
+// requires to tell them from new zones. Zones are matched by name so users
+// don't have to look up the IDs.
+func carryOverZoneIDs(cluster *atlas.Cluster, existing *atlas.Cluster) {
+	ids := map[string]string{}
+	for _, spec := range existing.ReplicationSpecs {
+		if spec.ZoneName != "" {
+			ids[spec.ZoneName] = spec.ID
+		}
+	}
+
+	for i := range cluster.ReplicationSpecs {
+		spec := &cluster.ReplicationSpecs[i]
+		if spec.ID == "" {
+			spec.ID = ids[spec.ZoneName]
+		}
+	}
+}
+
+// isShardedClusterType returns whether clusters of a type have shards.
+func isShardedClusterType(clusterType string) bool {
+	return clusterType == atlas.ClusterTypeSharded || clusterType == atlas.ClusterTypeGeoSharded
+}
+
+// isShardableInstanceSize returns whether clusters of an instance size can be
+// sharded. Sizes which aren't named like the known ones are left to Atlas.
+func isShardableInstanceSize(name string) bool {
+	number, ok := instanceSizeNumber(name)
+	if !ok {
+		return true
+	}
+
+	return number >= minShardedInstanceSize
+}
+
+// validationErrorFromJSON converts a JSON decoding error into a validation
+// error pointing at the offending field.
+func validationErrorFromJSON(err error) error {
+	verr := &ValidationError{}
+
+	switch err := err.(type) {
+	case *json.UnmarshalTypeError:
+		verr.add(err.Field, "expected a value of type %s but got %s", err.Type.String(), err.Value)
+	case *json.SyntaxError:
+		verr.add("", "parameters are not valid JSON: %s", err.Error())
+	default:
+		verr.add("", "%s", err.Error())
+	}
+
+	return verr
+}
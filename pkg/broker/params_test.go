@@ -0,0 +1,592 @@
+package broker
+
+import (
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func testPlanContext() PlanContext {
+	return PlanContext{
+		InstanceID:   "instance",
+		Provider:     &atlas.Provider{Name: "AWS"},
+		InstanceSize: &atlas.InstanceSize{Name: "M10"},
+	}
+}
+
+func TestClusterFromParamsPlanOnly(t *testing.T) {
+	cluster, err := ClusterFromParams(testPlanContext(), nil)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.Cluster{
+		Name: "instance",
+		ProviderSettings: &atlas.ProviderSettings{
+			ProviderName:     "AWS",
+			InstanceSizeName: "M10",
+		},
+	}, cluster)
+}
+
+func TestClusterFromParamsPrecedence(t *testing.T) {
+	planCtx := testPlanContext()
+	planCtx.Defaults = &atlas.Cluster{
+		BackupEnabled:       true,
+		MongoDBMajorVersion: "4.0",
+		ProviderSettings: &atlas.ProviderSettings{
+			RegionName:       "EU_WEST_1",
+			InstanceSizeName: "M40",
+		},
+	}
+
+	// Defaults are applied on top of the plan.
+	cluster, err := ClusterFromParams(planCtx, nil)
+	assert.NoError(t, err)
+	assert.True(t, cluster.BackupEnabled)
+	assert.Equal(t, "4.0", cluster.MongoDBMajorVersion)
+	assert.Equal(t, &atlas.ProviderSettings{
+		ProviderName:     "AWS",
+		InstanceSizeName: "M10",
+		RegionName:       "EU_WEST_1",
+	}, cluster.ProviderSettings, "Expected the plan to win over defaults")
+
+	// User params override defaults.
+	params := `{
+		"cluster": {
+			"mongoDBMajorVersion": "4.2",
+			"providerSettings": {
+				"regionName": "US_EAST_1"
+			}
+		}
+	}`
+	cluster, err = ClusterFromParams(planCtx, []byte(params))
+	assert.NoError(t, err)
+	assert.True(t, cluster.BackupEnabled)
+	assert.Equal(t, "4.2", cluster.MongoDBMajorVersion)
+	assert.Equal(t, &atlas.ProviderSettings{
+		ProviderName:     "AWS",
+		InstanceSizeName: "M10",
+		RegionName:       "US_EAST_1",
+	}, cluster.ProviderSettings)
+
+	// But not the plan.
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"providerName": "GCP"}}}`))
+	assert.IsType(t, &ValidationError{}, err)
+
+	// The defaults must not have been modified.
+	assert.Equal(t, "4.0", planCtx.Defaults.MongoDBMajorVersion)
+}
+
+func TestClusterFromParamsWithoutPlan(t *testing.T) {
+	cluster, err := ClusterFromParams(PlanContext{InstanceID: "instance"}, []byte(`{"cluster": {"diskSizeGB": 20}}`))
+
+	assert.NoError(t, err)
+	assert.Nil(t, cluster.ProviderSettings)
+	assert.Equal(t, 20.0, cluster.DiskSizeGB)
+}
+
+func TestClusterFromParamsValidation(t *testing.T) {
+	tests := []struct {
+		name      string
+		params    string
+		violation FieldViolation
+	}{
+		{
+			name:   "wrong type",
+			params: `{"cluster": {"diskSizeGB": "large"}}`,
+			violation: FieldViolation{
+				Field:   "cluster.diskSizeGB",
+				Message: "expected a value of type float64 but got string",
+			},
+		},
+		{
+			name:   "negative disk size",
+			params: `{"cluster": {"diskSizeGB": -10}}`,
+			violation: FieldViolation{
+				Field:   "cluster.diskSizeGB",
+				Message: "must not be negative",
+			},
+		},
+		{
+			name:   "unknown cluster type",
+			params: `{"cluster": {"clusterType": "STANDALONE"}}`,
+			violation: FieldViolation{
+				Field:   "cluster.clusterType",
+				Message: `must be one of "REPLICASET", "SHARDED" or "GEOSHARDED"`,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ClusterFromParams(testPlanContext(), []byte(test.params))
+
+			verr, ok := err.(*ValidationError)
+			if !assert.True(t, ok, "Expected a validation error") {
+				return
+			}
+
+			assert.Equal(t, []FieldViolation{test.violation}, verr.Violations)
+		})
+	}
+}
+
+func TestClusterFromParamsInvalidJSON(t *testing.T) {
+	_, err := ClusterFromParams(testPlanContext(), []byte(`{"cluster": `))
+
+	verr, ok := err.(*ValidationError)
+	if assert.True(t, ok, "Expected a validation error") {
+		assert.Len(t, verr.Violations, 1)
+		assert.Empty(t, verr.Violations[0].Field)
+	}
+}
+
+func TestClusterFromParamsAWSOnlySettings(t *testing.T) {
+	planCtx := testPlanContext()
+	planCtx.Provider = &atlas.Provider{Name: "AZURE"}
+	planCtx.Defaults = &atlas.Cluster{
+		ProviderSettings: &atlas.ProviderSettings{
+			RegionName: "US_EAST_2",
+			VolumeType: "PROVISIONED",
+			DiskIOPS:   1000,
+		},
+	}
+
+	// AWS-only defaults don't apply to other providers.
+	cluster, err := ClusterFromParams(planCtx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.ProviderSettings{
+		ProviderName:     "AZURE",
+		InstanceSizeName: "M10",
+		RegionName:       "US_EAST_2",
+	}, cluster.ProviderSettings)
+
+	// Parameters setting them are rejected.
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"volumeType": "STANDARD", "encryptEBSVolume": true}}}`))
+	verr, ok := err.(*ValidationError)
+	if assert.True(t, ok, "Expected a validation error") {
+		assert.Equal(t, []FieldViolation{
+			{Field: "cluster.providerSettings.encryptEBSVolume", Message: `is only supported by provider "AWS", not "AZURE"`},
+			{Field: "cluster.providerSettings.volumeType", Message: `is only supported by provider "AWS", not "AZURE"`},
+		}, verr.Violations)
+	}
+
+	// AWS plans accept them.
+	cluster, err = ClusterFromParams(testPlanContext(), []byte(`{"cluster": {"providerSettings": {"volumeType": "STANDARD"}}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, "STANDARD", cluster.ProviderSettings.VolumeType)
+}
+
+func TestClusterFromParamsProviderSettings(t *testing.T) {
+	defaults := &atlas.Cluster{
+		ProviderSettings: &atlas.ProviderSettings{
+			EncryptEBSVolume: true,
+			VolumeType:       "PROVISIONED",
+			DiskIOPS:         1000,
+		},
+	}
+
+	tests := []struct {
+		provider string
+		params   string
+		expected *atlas.ProviderSettings
+	}{
+		{
+			provider: "AWS",
+			params:   `{"cluster": {"providerSettings": {"regionName": "US_EAST_1"}}}`,
+			expected: &atlas.ProviderSettings{
+				ProviderName:     "AWS",
+				InstanceSizeName: "M10",
+				RegionName:       "US_EAST_1",
+				EncryptEBSVolume: true,
+				VolumeType:       "PROVISIONED",
+				DiskIOPS:         1000,
+			},
+		},
+		{
+			provider: "GCP",
+			params:   `{"cluster": {"providerSettings": {"regionName": "WESTERN_EUROPE"}}}`,
+			expected: &atlas.ProviderSettings{
+				ProviderName:     "GCP",
+				InstanceSizeName: "M10",
+				RegionName:       "WESTERN_EUROPE",
+			},
+		},
+		{
+			provider: "AZURE",
+			params:   `{"cluster": {"providerSettings": {"regionName": "EUROPE_NORTH", "diskTypeName": "P4"}}}`,
+			expected: &atlas.ProviderSettings{
+				ProviderName:     "AZURE",
+				InstanceSizeName: "M10",
+				RegionName:       "EUROPE_NORTH",
+				DiskTypeName:     "P4",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.provider, func(t *testing.T) {
+			planCtx := testPlanContext()
+			planCtx.Provider = &atlas.Provider{Name: test.provider}
+			planCtx.Defaults = defaults
+
+			cluster, err := ClusterFromParams(planCtx, []byte(test.params))
+			if assert.NoError(t, err) {
+				assert.Equal(t, test.expected, cluster.ProviderSettings)
+			}
+		})
+	}
+}
+
+func TestClusterFromParamsCurrentProvider(t *testing.T) {
+	planCtx := PlanContext{InstanceID: "instance", CurrentProvider: "GCP"}
+
+	// The provider of the existing cluster is kept.
+	cluster, err := ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"regionName": "CENTRAL_US"}}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &atlas.ProviderSettings{
+			ProviderName: "GCP",
+			RegionName:   "CENTRAL_US",
+		}, cluster.ProviderSettings)
+	}
+
+	// And can't be changed.
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"providerName": "AWS", "regionName": "CENTRAL_US"}}}`))
+	assert.IsType(t, &ValidationError{}, err)
+
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"diskIOPS": 100}}}`))
+	assert.IsType(t, &ValidationError{}, err)
+}
+
+func TestClusterFromParamsSharding(t *testing.T) {
+	m30 := &atlas.InstanceSize{Name: "M30"}
+	sharded := &atlas.Cluster{
+		ClusterType:      atlas.ClusterTypeSharded,
+		NumShards:        2,
+		ProviderSettings: &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M30"},
+	}
+
+	tests := []struct {
+		name         string
+		instanceSize *atlas.InstanceSize
+		existing     *atlas.Cluster
+		params       string
+		field        string
+	}{
+		{name: "sharded M30", instanceSize: m30, params: `{"cluster": {"clusterType": "SHARDED", "numShards": 3}}`},
+		{name: "sharded NVMe", instanceSize: &atlas.InstanceSize{Name: "M40_NVME"}, params: `{"cluster": {"clusterType": "SHARDED"}}`},
+		{name: "sharded M10", instanceSize: &atlas.InstanceSize{Name: "M10"}, params: `{"cluster": {"clusterType": "SHARDED", "numShards": 3}}`, field: "cluster.clusterType"},
+		{name: "geosharded M20", instanceSize: &atlas.InstanceSize{Name: "M20"}, params: `{"cluster": {"clusterType": "GEOSHARDED", "replicationSpecs": [{"zoneName": "EU", "regionsConfig": {"EU_WEST_1": {"electableNodes": 3, "priority": 7}}}]}}`, field: "cluster.clusterType"},
+		{name: "no shards", instanceSize: m30, params: `{"cluster": {"clusterType": "SHARDED", "numShards": 0}}`, field: "cluster.numShards"},
+		{name: "too many shards", instanceSize: m30, params: `{"cluster": {"clusterType": "SHARDED", "numShards": 51}}`, field: "cluster.numShards"},
+		{name: "shards of replica set", instanceSize: m30, params: `{"cluster": {"numShards": 3}}`, field: "cluster.numShards"},
+		{name: "grow shards", existing: sharded, params: `{"cluster": {"numShards": 5}}`},
+		{name: "shrink shards", existing: sharded, params: `{"cluster": {"numShards": 0}}`, field: "cluster.numShards"},
+		{name: "unshard", existing: sharded, params: `{"cluster": {"clusterType": "REPLICASET"}}`, field: "cluster.clusterType"},
+		{name: "shard replica set", existing: &atlas.Cluster{ClusterType: atlas.ClusterTypeReplicaSet, ProviderSettings: sharded.ProviderSettings}, params: `{"cluster": {"clusterType": "SHARDED", "numShards": 2}}`},
+		{name: "downsize sharded", instanceSize: &atlas.InstanceSize{Name: "M20"}, existing: sharded, params: ``, field: "cluster.clusterType"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			planCtx := PlanContext{InstanceID: "instance", Existing: test.existing}
+			if test.instanceSize != nil {
+				planCtx.Provider = &atlas.Provider{Name: "AWS"}
+				planCtx.InstanceSize = test.instanceSize
+			}
+
+			_, err := ClusterFromParams(planCtx, []byte(test.params))
+			if test.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			if verr, ok := err.(*ValidationError); assert.True(t, ok, "Expected a validation error") {
+				assert.Len(t, verr.Violations, 1)
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestClusterFromParamsMongoDBMajorVersion(t *testing.T) {
+	existing := &atlas.Cluster{
+		MongoDBMajorVersion: "5.0",
+		ProviderSettings:    &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M10"},
+	}
+
+	tests := []struct {
+		name      string
+		existing  *atlas.Cluster
+		supported []string
+		params    string
+		field     string
+	}{
+		{name: "supported", params: `{"cluster": {"mongoDBMajorVersion": "4.4"}}`},
+		{name: "unknown", params: `{"cluster": {"mongoDBMajorVersion": "4.3"}}`, field: "cluster.mongoDBMajorVersion"},
+		{name: "configured", supported: []string{"8.0"}, params: `{"cluster": {"mongoDBMajorVersion": "8.0"}}`},
+		{name: "not configured", supported: []string{"8.0"}, params: `{"cluster": {"mongoDBMajorVersion": "7.0"}}`, field: "cluster.mongoDBMajorVersion"},
+		{name: "upgrade", existing: existing, params: `{"cluster": {"mongoDBMajorVersion": "6.0"}}`},
+		{name: "same version", existing: existing, params: `{"cluster": {"mongoDBMajorVersion": "5.0"}}`},
+		{name: "downgrade", existing: existing, params: `{"cluster": {"mongoDBMajorVersion": "4.4"}}`, field: "cluster.mongoDBMajorVersion"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			planCtx := PlanContext{InstanceID: "instance", Existing: test.existing, MongoDBMajorVersions: test.supported}
+			if test.existing == nil {
+				planCtx.Provider = &atlas.Provider{Name: "AWS"}
+				planCtx.InstanceSize = &atlas.InstanceSize{Name: "M10"}
+			}
+
+			_, err := ClusterFromParams(planCtx, []byte(test.params))
+			if test.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			if verr, ok := err.(*ValidationError); assert.True(t, ok, "Expected a validation error") {
+				assert.Len(t, verr.Violations, 1)
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestClusterFromParamsVersionReleaseSystem(t *testing.T) {
+	lts := &atlas.Cluster{
+		MongoDBMajorVersion: "6.0",
+		ProviderSettings:    &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M10"},
+	}
+	newest := &atlas.Cluster{
+		MongoDBMajorVersion: "8.0",
+		ProviderSettings:    &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M10"},
+	}
+	continuous := &atlas.Cluster{
+		MongoDBMajorVersion:  "7.3",
+		VersionReleaseSystem: "CONTINUOUS",
+		ProviderSettings:     &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M10"},
+	}
+
+	tests := []struct {
+		name     string
+		existing *atlas.Cluster
+		params   string
+		field    string
+	}{
+		{name: "LTS", params: `{"cluster": {"versionReleaseSystem": "LTS"}}`},
+		{name: "LTS with version", params: `{"cluster": {"versionReleaseSystem": "LTS", "mongoDBMajorVersion": "6.0"}}`},
+		{name: "continuous", params: `{"cluster": {"versionReleaseSystem": "CONTINUOUS"}}`},
+		{name: "continuous with version", params: `{"cluster": {"versionReleaseSystem": "CONTINUOUS", "mongoDBMajorVersion": "7.0"}}`, field: "cluster.mongoDBMajorVersion"},
+		{name: "unknown", params: `{"cluster": {"versionReleaseSystem": "NIGHTLY"}}`, field: "cluster.versionReleaseSystem"},
+		{name: "switch from newest version", existing: newest, params: `{"cluster": {"versionReleaseSystem": "CONTINUOUS"}}`},
+		{name: "switch from older version", existing: lts, params: `{"cluster": {"versionReleaseSystem": "CONTINUOUS"}}`, field: "cluster.versionReleaseSystem"},
+		{name: "stay continuous", existing: continuous, params: `{"cluster": {"diskSizeGB": 20}}`},
+		{name: "pin while continuous", existing: continuous, params: `{"cluster": {"mongoDBMajorVersion": "7.0"}}`, field: "cluster.mongoDBMajorVersion"},
+		{name: "leave for older version", existing: continuous, params: `{"cluster": {"versionReleaseSystem": "LTS", "mongoDBMajorVersion": "7.0"}}`, field: "cluster.mongoDBMajorVersion"},
+		{name: "leave for next version", existing: continuous, params: `{"cluster": {"versionReleaseSystem": "LTS", "mongoDBMajorVersion": "8.0"}}`},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			planCtx := PlanContext{InstanceID: "instance", Existing: test.existing, MongoDBMajorVersions: []string{"6.0", "7.0", "8.0"}}
+			if test.existing == nil {
+				planCtx.Provider = &atlas.Provider{Name: "AWS"}
+				planCtx.InstanceSize = &atlas.InstanceSize{Name: "M10"}
+			}
+
+			_, err := ClusterFromParams(planCtx, []byte(test.params))
+			if test.field == "" {
+				assert.NoError(t, err)
+				return
+			}
+
+			if verr, ok := err.(*ValidationError); assert.True(t, ok, "Expected a validation error") {
+				assert.Len(t, verr.Violations, 1)
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestCompareMajorVersions(t *testing.T) {
+	assert.True(t, compareMajorVersions("4.4", "5.0") < 0)
+	assert.True(t, compareMajorVersions("10.0", "7.0") > 0)
+	assert.Equal(t, 0, compareMajorVersions("6.0", "6.0"))
+}
+
+func TestWithMongoDBMajorVersions(t *testing.T) {
+	broker, err := New(zap.NewNop().Sugar(), WithMongoDBMajorVersions("7.0", "8.0"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []string{"7.0", "8.0"}, broker.mongoDBMajorVersions)
+	}
+
+	_, err = New(zap.NewNop().Sugar(), WithMongoDBMajorVersions("latest"))
+	assert.Error(t, err)
+}
+
+func TestClusterFromParamsZones(t *testing.T) {
+	params, err := ioutil.ReadFile("testdata/params/geosharded-two-zones.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	planCtx := testPlanContext()
+	planCtx.InstanceSize = &atlas.InstanceSize{Name: "M30"}
+
+	cluster, err := ClusterFromParams(planCtx, params)
+	if assert.NoError(t, err) {
+		assert.Equal(t, atlas.ClusterTypeGeoSharded, cluster.ClusterType)
+		if assert.Len(t, cluster.ReplicationSpecs, 2) {
+			assert.Equal(t, "Europe", cluster.ReplicationSpecs[0].ZoneName)
+			assert.Equal(t, "North America", cluster.ReplicationSpecs[1].ZoneName)
+		}
+	}
+
+	// Each broken zone results in a violation of its own field.
+	tests := []struct {
+		field       string
+		original    string
+		replacement string
+	}{
+		{"cluster.replicationSpecs[1].zoneName", `"North America"`, `"Europe"`},
+		{"cluster.replicationSpecs[0].regionsConfig", `"priority": 7`, `"priority": 5`},
+	}
+
+	for _, test := range tests {
+		broken := strings.Replace(string(params), test.original, test.replacement, 1)
+
+		_, err := ClusterFromParams(planCtx, []byte(broken))
+		if verr, ok := err.(*ValidationError); assert.True(t, ok, "Expected a validation error for %s", test.field) && assert.Len(t, verr.Violations, 1) {
+			assert.Equal(t, test.field, verr.Violations[0].Field)
+		}
+	}
+
+	_, err = ClusterFromParams(testPlanContext(), params)
+	assert.IsType(t, &ValidationError{}, err, "Expected geo-sharding to require M30")
+
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"clusterType": "GEOSHARDED"}}`))
+	assert.IsType(t, &ValidationError{}, err, "Expected zones to be required")
+}
+
+func TestClusterFromParamsAddZone(t *testing.T) {
+	existing := &atlas.Cluster{
+		ClusterType:      atlas.ClusterTypeGeoSharded,
+		ProviderSettings: &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M30"},
+		ReplicationSpecs: []atlas.ReplicationSpec{
+			{
+				ID:            "zone-1",
+				ZoneName:      "Europe",
+				NumShards:     1,
+				RegionsConfig: map[string]atlas.RegionsConfig{"EU_WEST_1": {ElectableNodes: 3, Priority: 7}},
+			},
+		},
+	}
+	planCtx := PlanContext{InstanceID: "instance", CurrentProvider: "AWS", Existing: existing}
+
+	params, err := ioutil.ReadFile("testdata/params/geosharded-two-zones.json")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The existing zone keeps its ID so Atlas only adds the new one.
+	cluster, err := ClusterFromParams(planCtx, params)
+	if assert.NoError(t, err) && assert.Len(t, cluster.ReplicationSpecs, 2) {
+		assert.Equal(t, "zone-1", cluster.ReplicationSpecs[0].ID)
+		assert.Equal(t, "", cluster.ReplicationSpecs[1].ID)
+	}
+
+	// Zones can't be removed.
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"replicationSpecs": [{"zoneName": "Asia", "regionsConfig": {"AP_SOUTHEAST_1": {"electableNodes": 3, "priority": 7}}}]}}`))
+	if verr, ok := err.(*ValidationError); assert.True(t, ok, "Expected a validation error") {
+		assert.Equal(t, "cluster.replicationSpecs", verr.Violations[0].Field)
+	}
+}
+
+// TestClusterFromParamsProviderSettingsPrecedence pins which provider
+// settings users may pass. The provider and instance size always come from
+// the plan.
+func TestClusterFromParamsProviderSettingsPrecedence(t *testing.T) {
+	existing := &atlas.Cluster{
+		ProviderSettings: &atlas.ProviderSettings{ProviderName: "AWS", InstanceSizeName: "M20"},
+	}
+
+	tests := []struct {
+		name     string
+		planCtx  PlanContext
+		settings string
+		field    string
+	}{
+		{"instance size of the plan", testPlanContext(), `{"instanceSizeName": "M10"}`, ""},
+		{"other instance size", testPlanContext(), `{"instanceSizeName": "M40"}`, "cluster.providerSettings.instanceSizeName"},
+		{"instance size in another case", testPlanContext(), `{"InstanceSizeName": "M40"}`, "cluster.providerSettings.instanceSizeName"},
+		{"shared instance size", testPlanContext(), `{"instanceSizeName": "M2"}`, "cluster.providerSettings.instanceSizeName"},
+		{"provider of the plan", testPlanContext(), `{"providerName": "AWS"}`, ""},
+		{"other provider", testPlanContext(), `{"providerName": "GCP"}`, "cluster.providerSettings.providerName"},
+		{"backing provider of a dedicated plan", testPlanContext(), `{"backingProviderName": "AWS"}`, "cluster.providerSettings.backingProviderName"},
+		{"region", testPlanContext(), `{"regionName": "EU_WEST_1"}`, ""},
+		{"volume type", testPlanContext(), `{"volumeType": "PROVISIONED"}`, ""},
+		{"disk IOPS", testPlanContext(), `{"diskIOPS": 1000}`, ""},
+		{"EBS encryption", testPlanContext(), `{"encryptEBSVolume": true}`, ""},
+		{"disk type", testPlanContext(), `{"diskTypeName": "P4"}`, ""},
+		{"unknown setting", testPlanContext(), `{"nodeCount": 3}`, "cluster.providerSettings.nodeCount"},
+		{"instance size of the existing cluster", PlanContext{InstanceID: "instance", CurrentProvider: "AWS", Existing: existing}, `{"instanceSizeName": "M20"}`, ""},
+		{"other instance size without a plan", PlanContext{InstanceID: "instance", CurrentProvider: "AWS", Existing: existing}, `{"instanceSizeName": "M40"}`, "cluster.providerSettings.instanceSizeName"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cluster, err := ClusterFromParams(test.planCtx, []byte(`{"cluster": {"providerSettings": `+test.settings+`}}`))
+
+			if test.field == "" {
+				if assert.NoError(t, err) {
+					assert.NotEqual(t, "M40", cluster.ProviderSettings.InstanceSizeName)
+				}
+				return
+			}
+
+			verr, ok := err.(*ValidationError)
+			if assert.True(t, ok, "Expected a validation error but got %v", err) && assert.Len(t, verr.Violations, 1) {
+				assert.Equal(t, test.field, verr.Violations[0].Field)
+			}
+		})
+	}
+}
+
+func TestClusterFromParamsSharedPlanProviderSettings(t *testing.T) {
+	planCtx := testPlanContext()
+	planCtx.InstanceSize = &atlas.InstanceSize{Name: "M2"}
+
+	cluster, err := ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"providerName": "TENANT", "backingProviderName": "AWS", "regionName": "US_EAST_1"}}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, &atlas.ProviderSettings{
+			ProviderName:        "TENANT",
+			BackingProviderName: "AWS",
+			InstanceSizeName:    "M2",
+			RegionName:          "US_EAST_1",
+		}, cluster.ProviderSettings)
+	}
+
+	_, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"backingProviderName": "GCP"}}}`))
+	assert.IsType(t, &ValidationError{}, err)
+
+	// The backing provider of the tenant service is up to the user.
+	planCtx.Provider = &atlas.Provider{Name: "TENANT"}
+	cluster, err = ClusterFromParams(planCtx, []byte(`{"cluster": {"providerSettings": {"backingProviderName": "GCP"}}}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, "GCP", cluster.ProviderSettings.BackingProviderName)
+	}
+}
+
+func assertInvalidParams(t *testing.T, err error, field string) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response but got %v", err) {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), field)
+	}
+}
@@ -0,0 +1,132 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// Lengths of passwords, generated or passed by callers. Atlas rejects
+// passwords shorter than 8 characters.
+const (
+	defaultPasswordLength = 44
+	minPasswordLength     = 12
+	maxPasswordLength     = 128
+	minUserPasswordLength = 8
+)
+
+// Characters of generated passwords. Both sets are unreserved in URIs, so
+// passwords never need to be escaped in connection strings.
+const (
+	alphanumericCharacters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
+	urlSafeCharacters      = alphanumericCharacters + "-_"
+)
+
+// PasswordPolicy controls the passwords generated for database users. The
+// zero value generates 32 random bytes encoded as URL-safe base64, which is
+// what the broker always did.
+type PasswordPolicy struct {
+	// Length is the number of characters, 44 if only Alphanumeric is set.
+	Length int
+
+	// Alphanumeric leaves out "-" and "_" for tools which can't handle
+	// them.
+	Alphanumeric bool
+}
+
+// validate checks the length of the policy.
+func (p PasswordPolicy) validate() error {
+	if p.Length != 0 && (p.Length < minPasswordLength || p.Length > maxPasswordLength) {
+		return fmt.Errorf("password length must be between %d and %d", minPasswordLength, maxPasswordLength)
+	}
+
+	return nil
+}
+
+// generate returns a cryptographically secure password following the
+// policy.
+func (p PasswordPolicy) generate() (string, error) {
+	if p == (PasswordPolicy{}) {
+		return generatePassword()
+	}
+
+	length := p.Length
+	if length == 0 {
+		length = defaultPasswordLength
+	}
+
+	characters := urlSafeCharacters
+	if p.Alphanumeric {
+		characters = alphanumericCharacters
+	}
+
+	max := big.NewInt(int64(len(characters)))
+	password := make([]byte, length)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = characters[n.Int64()]
+	}
+
+	return string(password), nil
+}
+
+// generatePassword will generate a cryptographically secure password.
+// The password will be base64 encoded for easy usage.
+func generatePassword() (string, error) {
+	const numberOfBytes = 32
+	b := make([]byte, numberOfBytes)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.URLEncoding.EncodeToString(b), nil
+}
+
+// userPasswordFromParams returns the user.password passed by the caller, or
+// an empty string if the broker should generate one. Passwords are only
+// honored if the broker allows them, otherwise they're ignored as they
+// always were. Connection strings escape them, so any characters are fine.
+func (b Broker) userPasswordFromParams(rawParams []byte, x509 bool) (string, error) {
+	if !b.allowUserPasswords || len(rawParams) == 0 {
+		return "", nil
+	}
+
+	params := struct {
+		User struct {
+			Password *string `json:"password"`
+		} `json:"user"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return "", validationErrorFromJSON(err)
+	}
+
+	if params.User.Password == nil {
+		return "", nil
+	}
+	password := *params.User.Password
+
+	verr := &ValidationError{}
+	switch {
+	case x509:
+		verr.add("user.password", "can't be combined with x509Type %s", atlas.X509TypeManaged)
+	case len(password) < minUserPasswordLength:
+		verr.add("user.password", "must be at least %d characters long", minUserPasswordLength)
+	case len(password) > maxPasswordLength:
+		verr.add("user.password", "must not be longer than %d characters", maxPasswordLength)
+	case strings.IndexFunc(password, unicode.IsControl) >= 0:
+		verr.add("user.password", "must not contain control characters")
+	}
+
+	return password, verr.errorOrNil()
+}
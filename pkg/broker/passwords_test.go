@@ -0,0 +1,171 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/x/network/connstring"
+	"go.uber.org/zap"
+)
+
+func TestPasswordPolicyGenerate(t *testing.T) {
+	tests := []struct {
+		policy  PasswordPolicy
+		pattern string
+	}{
+		{PasswordPolicy{}, `^[A-Za-z0-9_-]{43}=$`},
+		{PasswordPolicy{Length: 20}, `^[A-Za-z0-9_-]{20}$`},
+		{PasswordPolicy{Alphanumeric: true}, `^[A-Za-z0-9]{44}$`},
+		{PasswordPolicy{Length: 16, Alphanumeric: true}, `^[A-Za-z0-9]{16}$`},
+	}
+
+	for _, test := range tests {
+		password, err := test.policy.generate()
+		assert.NoError(t, err)
+		assert.Regexp(t, test.pattern, password)
+
+		// Passwords must not need escaping in connection strings.
+		assert.Equal(t, password, url.PathEscape(password))
+	}
+
+	for _, length := range []int{4, 200} {
+		_, err := New(zap.NewNop().Sugar(), WithPasswordPolicy(PasswordPolicy{Length: length}))
+		assert.Error(t, err, length)
+	}
+}
+
+// setupPasswordTest provisions an instance whose cluster has a standard
+// connection string.
+func setupPasswordTest(opts ...Option) (*Broker, MockAtlasClient, context.Context) {
+	broker, client, ctx := setupTest(opts...)
+	broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters["instance"].MongoURIWithOptions = "mongodb://instance-00.mongodb.net:27017,instance-01.mongodb.net:27017/?ssl=true&authSource=admin&replicaSet=instance-0"
+
+	return broker, client, ctx
+}
+
+// bindWithParams binds to the instance and returns the credentials. Stored
+// credentials returned to retries are decoded.
+func bindWithParams(broker *Broker, ctx context.Context, bindingID string, params string) (ConnectionDetails, error) {
+	spec, err := broker.Bind(ctx, "instance", bindingID, brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(params),
+	}, true)
+	if err != nil {
+		return ConnectionDetails{}, err
+	}
+
+	if stored, ok := spec.Credentials.(json.RawMessage); ok {
+		var details ConnectionDetails
+		err = json.Unmarshal(stored, &details)
+		return details, err
+	}
+
+	return spec.Credentials.(ConnectionDetails), nil
+}
+
+// assertURIParses checks that a connection string is accepted by both the URL
+// parser and the driver and carries the passed password.
+func assertURIParses(t *testing.T, uri string, password string) {
+	parsed, err := url.Parse(uri)
+	if assert.NoError(t, err) {
+		parsedPassword, _ := parsed.User.Password()
+		assert.Equal(t, password, parsedPassword)
+	}
+
+	cs, err := connstring.Parse(uri)
+	if assert.NoError(t, err) {
+		assert.Equal(t, password, cs.Password)
+	}
+
+	_, err = mongo.NewClient(options.Client().ApplyURI(uri))
+	assert.NoError(t, err)
+}
+
+func TestBindGeneratedPasswordURI(t *testing.T) {
+	broker, _, ctx := setupPasswordTest(WithPasswordPolicy(PasswordPolicy{Length: 24, Alphanumeric: true}))
+
+	details, err := bindWithParams(broker, ctx, "binding", `{"connectionString": {"format": "standard"}}`)
+	if assert.NoError(t, err) {
+		assert.Regexp(t, `^[A-Za-z0-9]{24}$`, details.Password)
+		assertURIParses(t, details.URI, details.Password)
+	}
+}
+
+func TestBindUserPassword(t *testing.T) {
+	const password = "p@ss:w/rd%?#[] secret"
+	params := `{"user": {"password": "p@ss:w/rd%?#[] secret"}, "connectionString": {"format": "standard"}}`
+
+	// Passwords passed by callers are ignored unless the broker allows them.
+	broker, client, ctx := setupPasswordTest()
+	details, err := bindWithParams(broker, ctx, "ignored", params)
+	if assert.NoError(t, err) {
+		assert.NotEqual(t, password, details.Password)
+		assert.Equal(t, details.Password, client.Users["ignored"].Password)
+	}
+
+	broker, client, ctx = setupPasswordTest(WithUserPasswords(true))
+	details, err = bindWithParams(broker, ctx, "supplied", params)
+	if assert.NoError(t, err) {
+		assert.Equal(t, password, details.Password)
+		assert.Equal(t, password, client.Users["supplied"].Password)
+		assertURIParses(t, details.URI, password)
+	}
+
+	// Without a password one is generated as usual.
+	details, err = bindWithParams(broker, ctx, "generated", `{}`)
+	if assert.NoError(t, err) {
+		assert.NotEmpty(t, details.Password)
+	}
+}
+
+func TestBindEscapedCredentials(t *testing.T) {
+	const password = "pa@ss/w%rd"
+	broker, _, ctx := setupPasswordTest(WithUserPasswords(true), WithUsernameTemplate("CN={{.BindingID}},OU=x"))
+
+	// Scoping the binding to a database parses the connection string again.
+	params := `{"user": {"password": "pa@ss/w%rd"}, "database": "orders", "connectionString": {"format": "standard"}}`
+	details, err := bindWithParams(broker, ctx, "binding", params)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "CN=binding,OU=x", details.Username)
+		assert.Contains(t, details.URI, "mongodb://CN%3Dbinding%2COU%3Dx:pa%40ss%2Fw%25rd@")
+		assertURIParses(t, details.URI, password)
+
+		cs, err := connstring.Parse(details.URI)
+		if assert.NoError(t, err) {
+			assert.Equal(t, details.Username, cs.Username)
+			assert.Equal(t, "orders", cs.Database)
+		}
+	}
+}
+
+func TestBindInvalidUserPassword(t *testing.T) {
+	broker, client, ctx := setupPasswordTest(WithUserPasswords(true))
+
+	tests := []string{
+		`{"user": {"password": "short"}}`,
+		`{"user": {"password": "long enough", "x509Type": "MANAGED"}}`,
+		`{"user": {"password": "control\u0000character"}}`,
+	}
+
+	for _, params := range tests {
+		_, err := bindWithParams(broker, ctx, "binding", params)
+		if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok, params) {
+			assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil), params)
+			assert.Contains(t, failure.Error(), "user.password", params)
+		}
+		assert.Nil(t, client.Users["binding"], params)
+	}
+}
@@ -0,0 +1,126 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// hoursPerMonth converts hourly plan costs into monthly ones, Atlas bills
+// clusters by the hour.
+const hoursPerMonth = 730
+
+// MaxPlanCost is the highest monthly cost of the plans offered by the broker,
+// compared with the plan costs in the same currency.
+type MaxPlanCost struct {
+	Amount   float64 `json:"amount"`
+	Currency string  `json:"currency"`
+}
+
+// validate checks that the ceiling can be compared with plan costs.
+func (c MaxPlanCost) validate() error {
+	if c.Currency == "" {
+		return errors.New("maximum plan cost needs a currency")
+	}
+
+	if c.Amount < 0 {
+		return errors.New("maximum plan cost must not be negative")
+	}
+
+	return nil
+}
+
+// String returns the ceiling as shown in errors.
+func (c MaxPlanCost) String() string {
+	return fmt.Sprintf("%g %s per month", c.Amount, strings.ToUpper(c.Currency))
+}
+
+// monthlyCost returns the monthly cost of a plan in a currency. Hourly,
+// monthly and yearly costs are added up, costs in other units such as per GB
+// depend on usage and are left out. The second value is false if the plan has
+// no cost which could be converted.
+func monthlyCost(costs []brokerapi.ServicePlanCost, currency string) (float64, bool) {
+	total := 0.0
+	found := false
+
+	for _, cost := range costs {
+		factor := 0.0
+		switch strings.ToUpper(cost.Unit) {
+		case "HOURLY":
+			factor = hoursPerMonth
+		case "MONTHLY":
+			factor = 1
+		case "YEARLY", "ANNUALLY":
+			factor = 1.0 / 12
+		default:
+			continue
+		}
+
+		for costCurrency, amount := range cost.Amount {
+			if strings.EqualFold(costCurrency, currency) {
+				total += amount * factor
+				found = true
+			}
+		}
+	}
+
+	return total, found
+}
+
+// planCostsFor returns the costs of a plan as shown in the catalog. Costs set
+// by the catalog override replace the configured ones.
+func (b Broker) planCostsFor(serviceID string, planID string) []brokerapi.ServicePlanCost {
+	if b.catalogOverride != nil {
+		override := b.catalogOverride.Services[serviceID].Plans[planID]
+		if override.Metadata != nil && override.Metadata.Costs != nil {
+			return override.Metadata.Costs
+		}
+	}
+
+	return b.planCosts[planID]
+}
+
+// exceedsMaxPlanCost returns whether plan costs are above the ceiling. Plans
+// without a cost in its currency aren't limited.
+func (b Broker) exceedsMaxPlanCost(costs []brokerapi.ServicePlanCost) bool {
+	if b.maxPlanCost == nil {
+		return false
+	}
+
+	cost, ok := monthlyCost(costs, b.maxPlanCost.Currency)
+	return ok && cost > b.maxPlanCost.Amount
+}
+
+// applyMaxPlanCost removes the plans of a service which cost more than the
+// ceiling. It's applied to the final catalog metadata, including the costs
+// of the override.
+func (b Broker) applyMaxPlanCost(svc brokerapi.Service) brokerapi.Service {
+	if b.maxPlanCost == nil {
+		return svc
+	}
+
+	plans := []brokerapi.ServicePlan{}
+	for _, plan := range svc.Plans {
+		if plan.Metadata == nil || !b.exceedsMaxPlanCost(plan.Metadata.Costs) {
+			plans = append(plans, plan)
+		}
+	}
+
+	svc.Plans = plans
+	return svc
+}
+
+// checkPlanCost rejects plans above the ceiling, as platforms can pass plan
+// IDs which they cached before it was lowered.
+func (b Broker) checkPlanCost(serviceID string, planID string) error {
+	if !b.exceedsMaxPlanCost(b.planCostsFor(serviceID, planID)) {
+		return nil
+	}
+
+	err := fmt.Errorf(`plan "%s" costs more than the maximum of %s`, planID, b.maxPlanCost)
+	return apiresponses.NewFailureResponse(err, http.StatusForbidden, "plan-cost-exceeded")
+}
@@ -0,0 +1,176 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// testCeilingCosts make M10 cost about 58 USD and M20 about 146 USD a month.
+var testCeilingCosts = map[string][]brokerapi.ServicePlanCost{
+	testPlanID:                  {{Amount: map[string]float64{"usd": 0.08}, Unit: "HOURLY"}},
+	"aosb-cluster-plan-aws-m20": {{Amount: map[string]float64{"usd": 0.2}, Unit: "HOURLY"}},
+}
+
+func assertPlanCostExceeded(t *testing.T, err error) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response but got %v", err) {
+		assert.Equal(t, http.StatusForbidden, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "plan-cost-exceeded", failure.LoggerAction())
+		assert.Contains(t, failure.Error(), "100 USD per month")
+	}
+}
+
+func TestMonthlyCost(t *testing.T) {
+	tests := []struct {
+		costs    []brokerapi.ServicePlanCost
+		currency string
+		expected float64
+		found    bool
+	}{
+		{[]brokerapi.ServicePlanCost{{Amount: map[string]float64{"usd": 0.1}, Unit: "HOURLY"}}, "USD", 73, true},
+		{[]brokerapi.ServicePlanCost{{Amount: map[string]float64{"usd": 50}, Unit: "Monthly"}}, "usd", 50, true},
+		{[]brokerapi.ServicePlanCost{{Amount: map[string]float64{"eur": 1200}, Unit: "YEARLY"}}, "eur", 100, true},
+		{[]brokerapi.ServicePlanCost{
+			{Amount: map[string]float64{"usd": 10}, Unit: "MONTHLY"},
+			{Amount: map[string]float64{"usd": 0.25}, Unit: "PER GB"},
+			{Amount: map[string]float64{"usd": 120}, Unit: "YEARLY"},
+		}, "usd", 20, true},
+		{[]brokerapi.ServicePlanCost{{Amount: map[string]float64{"eur": 50}, Unit: "MONTHLY"}}, "usd", 0, false},
+		{[]brokerapi.ServicePlanCost{{Amount: map[string]float64{"usd": 0.25}, Unit: "PER GB"}}, "usd", 0, false},
+		{nil, "usd", 0, false},
+	}
+
+	for _, test := range tests {
+		cost, found := monthlyCost(test.costs, test.currency)
+		assert.InDelta(t, test.expected, cost, 0.0001, "%+v", test.costs)
+		assert.Equal(t, test.found, found, "%+v", test.costs)
+	}
+}
+
+func TestWithMaxPlanCost(t *testing.T) {
+	broker, client, ctx := setupTest(
+		WithPlanCosts(testCeilingCosts),
+		WithMaxPlanCost(MaxPlanCost{Amount: 100, Currency: "USD"}),
+	)
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Plans without a cost in the currency aren't limited.
+	assert.NotNil(t, planByID(services, testPlanID))
+	assert.Nil(t, planByID(services, "aosb-cluster-plan-aws-m20"))
+	assert.NotNil(t, planByID(services, "aosb-cluster-plan-aws-m30"))
+
+	// Plan IDs cached by the platform can't bypass the ceiling.
+	_, err = broker.Provision(ctx, "expensive", brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+	assertPlanCostExceeded(t, err)
+	assert.Nil(t, client.Clusters["expensive"])
+
+	instanceID := "instance"
+	_, err = broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:         "aosb-cluster-plan-aws-m20",
+		ServiceID:      testServiceID,
+		PreviousValues: brokerapi.PreviousValues{PlanID: testPlanID},
+	}, true)
+	assertPlanCostExceeded(t, err)
+	assert.Equal(t, "M10", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+}
+
+func TestMaxPlanCostLowered(t *testing.T) {
+	broker, client, ctx := setupTest(WithPlanCosts(testCeilingCosts))
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// The ceiling is lowered below the plan of the existing instance.
+	broker.maxPlanCost = &MaxPlanCost{Amount: 100, Currency: "usd"}
+
+	// Updates which don't change the size keep working, whether or not the
+	// platform passes the current plan.
+	updates := []brokerapi.UpdateDetails{
+		{
+			ServiceID:     testServiceID,
+			RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+		},
+		{
+			PlanID:         "aosb-cluster-plan-aws-m20",
+			ServiceID:      testServiceID,
+			PreviousValues: brokerapi.PreviousValues{PlanID: "aosb-cluster-plan-aws-m20"},
+			RawParameters:  []byte(`{"cluster": {"backupEnabled": true}}`),
+		},
+	}
+
+	for _, details := range updates {
+		_, err = broker.Update(ctx, instanceID, details, true)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, "M20", client.Clusters[instanceID].ProviderSettings.InstanceSizeName)
+
+	// Moving to a cheaper plan is allowed.
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:         testPlanID,
+		ServiceID:      testServiceID,
+		PreviousValues: brokerapi.PreviousValues{PlanID: "aosb-cluster-plan-aws-m20"},
+		RawParameters:  []byte(`{"allowDowngrade": true}`),
+	}, true)
+	assert.NoError(t, err)
+}
+
+func TestMaxPlanCostCatalogOverride(t *testing.T) {
+	// Costs of the override replace the configured ones.
+	broker, _, ctx := setupTest(
+		WithPlanCosts(testCeilingCosts),
+		WithCatalogOverride(CatalogOverride{
+			Services: map[string]ServiceOverride{
+				testServiceID: {Plans: map[string]PlanOverride{
+					testPlanID: {Metadata: &brokerapi.ServicePlanMetadata{
+						Costs: []brokerapi.ServicePlanCost{{Amount: map[string]float64{"usd": 500}, Unit: "MONTHLY"}},
+					}},
+				}},
+			},
+		}),
+		WithMaxPlanCost(MaxPlanCost{Amount: 100, Currency: "usd"}),
+	)
+
+	services, err := broker.Services(ctx)
+	if assert.NoError(t, err) {
+		assert.Nil(t, planByID(services, testPlanID))
+	}
+
+	_, err = broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assertPlanCostExceeded(t, err)
+}
+
+func TestWithMaxPlanCostInvalid(t *testing.T) {
+	for _, ceiling := range []MaxPlanCost{{Amount: 100}, {Amount: -1, Currency: "usd"}} {
+		_, err := New(zap.NewNop().Sugar(), WithMaxPlanCost(ceiling))
+		assert.Error(t, err, "Expected ceiling %+v to be rejected", ceiling)
+	}
+}
@@ -0,0 +1,144 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// instanceSizeSpec describes the hardware of an instance size. Shared
+// instance sizes only have a fixed amount of storage. Connections is the
+// maximum number of connections per node, MaxStorageGB the largest disk the
+// instance size supports.
+type instanceSizeSpec struct {
+	MemoryGB     float64
+	VCPUs        int
+	StorageGB    float64
+	MaxStorageGB float64
+	Connections  int
+}
+
+// instanceSizeSpecs are the hardware specs of the Atlas instance sizes, which
+// are the same on every provider. Storage is the default of new clusters.
+var instanceSizeSpecs = map[string]instanceSizeSpec{
+	"M0":   {StorageGB: 0.5, MaxStorageGB: 0.5, Connections: 500},
+	"M2":   {StorageGB: 2, MaxStorageGB: 2, Connections: 500},
+	"M5":   {StorageGB: 5, MaxStorageGB: 5, Connections: 500},
+	"M10":  {MemoryGB: 2, VCPUs: 2, StorageGB: 10, MaxStorageGB: 128, Connections: 1500},
+	"M20":  {MemoryGB: 4, VCPUs: 2, StorageGB: 20, MaxStorageGB: 256, Connections: 3000},
+	"M30":  {MemoryGB: 8, VCPUs: 2, StorageGB: 40, MaxStorageGB: 512, Connections: 3000},
+	"M40":  {MemoryGB: 16, VCPUs: 4, StorageGB: 80, MaxStorageGB: 1024, Connections: 6000},
+	"M50":  {MemoryGB: 32, VCPUs: 8, StorageGB: 160, MaxStorageGB: 4096, Connections: 16000},
+	"M60":  {MemoryGB: 64, VCPUs: 16, StorageGB: 320, MaxStorageGB: 4096, Connections: 32000},
+	"M80":  {MemoryGB: 128, VCPUs: 32, StorageGB: 750, MaxStorageGB: 4096, Connections: 96000},
+	"M100": {MemoryGB: 160, VCPUs: 40, StorageGB: 1000, MaxStorageGB: 4096, Connections: 96000},
+	"M140": {MemoryGB: 192, VCPUs: 48, StorageGB: 1000, MaxStorageGB: 4096, Connections: 96000},
+	"M200": {MemoryGB: 256, VCPUs: 64, StorageGB: 1500, MaxStorageGB: 4096, Connections: 128000},
+	"M300": {MemoryGB: 384, VCPUs: 96, StorageGB: 2000, MaxStorageGB: 4096, Connections: 128000},
+	"M400": {MemoryGB: 488, VCPUs: 64, StorageGB: 3000, MaxStorageGB: 4096, Connections: 128000},
+	"M700": {MemoryGB: 768, VCPUs: 96, StorageGB: 4000, MaxStorageGB: 4096, Connections: 128000},
+	"R40":  {MemoryGB: 16, VCPUs: 2, StorageGB: 80, MaxStorageGB: 1024, Connections: 6000},
+	"R50":  {MemoryGB: 32, VCPUs: 4, StorageGB: 160, MaxStorageGB: 4096, Connections: 16000},
+	"R60":  {MemoryGB: 64, VCPUs: 8, StorageGB: 320, MaxStorageGB: 4096, Connections: 32000},
+	"R80":  {MemoryGB: 124, VCPUs: 16, StorageGB: 750, MaxStorageGB: 4096, Connections: 96000},
+	"R200": {MemoryGB: 248, VCPUs: 32, StorageGB: 1500, MaxStorageGB: 4096, Connections: 128000},
+	"R300": {MemoryGB: 368, VCPUs: 48, StorageGB: 2000, MaxStorageGB: 4096, Connections: 128000},
+	"R400": {MemoryGB: 496, VCPUs: 64, StorageGB: 3000, MaxStorageGB: 4096, Connections: 128000},
+	"R700": {MemoryGB: 768, VCPUs: 96, StorageGB: 4000, MaxStorageGB: 4096, Connections: 128000},
+}
+
+// instanceSizeSpecFor returns the specs of an instance size. Variants such as
+// M40_NVME have the specs of the size they are based on.
+func instanceSizeSpecFor(instanceSizeName string) (instanceSizeSpec, bool) {
+	name := instanceSizeName
+	if i := strings.Index(name, "_"); i > 0 {
+		name = name[:i]
+	}
+
+	spec, ok := instanceSizeSpecs[name]
+	return spec, ok
+}
+
+// planMetadata returns the catalog metadata of the plan for an instance size.
+// Sizes missing from instanceSizeSpecs only get a display name.
+func planMetadata(instanceSizeName string) *brokerapi.ServicePlanMetadata {
+	metadata := &brokerapi.ServicePlanMetadata{
+		DisplayName: instanceSizeName,
+	}
+
+	if isSharedInstanceSizeName(instanceSizeName) {
+		metadata.DisplayName += " (shared)"
+	}
+
+	spec, ok := instanceSizeSpecs[instanceSizeName]
+	if !ok {
+		return metadata
+	}
+
+	if spec.MemoryGB > 0 {
+		metadata.Bullets = append(metadata.Bullets,
+			fmt.Sprintf("%g GB RAM", spec.MemoryGB),
+			fmt.Sprintf("%d vCPUs", spec.VCPUs),
+			fmt.Sprintf("%g GB default storage", spec.StorageGB),
+		)
+	} else {
+		metadata.Bullets = append(metadata.Bullets,
+			"Shared RAM",
+			"Shared vCPU",
+			fmt.Sprintf("%g GB storage", spec.StorageGB),
+		)
+	}
+
+	return metadata
+}
+
+// validatePlanCosts checks the costs configured for the plans.
+func validatePlanCosts(costs map[string][]brokerapi.ServicePlanCost) error {
+	for planID, planCosts := range costs {
+		if planID == "" {
+			return errors.New("plan costs need a plan ID")
+		}
+
+		for _, cost := range planCosts {
+			if cost.Unit == "" || len(cost.Amount) == 0 {
+				return fmt.Errorf(`costs of plan "%s" need both an amount and a unit`, planID)
+			}
+
+			for currency, amount := range cost.Amount {
+				if amount < 0 {
+					return fmt.Errorf(`cost of plan "%s" in %s must not be negative`, planID, currency)
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyPlanCosts adds the configured costs to the metadata of the plans of a
+// service. The plans are copied as they may be shared between catalogs.
+func (b Broker) applyPlanCosts(svc brokerapi.Service) brokerapi.Service {
+	if len(b.planCosts) == 0 {
+		return svc
+	}
+
+	plans := make([]brokerapi.ServicePlan, len(svc.Plans))
+	for i, plan := range svc.Plans {
+		if costs, ok := b.planCosts[plan.ID]; ok {
+			metadata := brokerapi.ServicePlanMetadata{}
+			if plan.Metadata != nil {
+				metadata = *plan.Metadata
+			}
+
+			metadata.Costs = costs
+			plan.Metadata = &metadata
+		}
+
+		plans[i] = plan
+	}
+
+	svc.Plans = plans
+	return svc
+}
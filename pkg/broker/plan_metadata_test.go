@@ -0,0 +1,87 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPlanMetadata(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			if assert.NotNil(t, plan.Metadata, "Expected plan %s to have metadata", plan.ID) {
+				assert.NotEmpty(t, plan.Metadata.DisplayName, "Expected plan %s to have a display name", plan.ID)
+				assert.NotEmpty(t, plan.Metadata.Bullets, "Expected plan %s to have bullets", plan.ID)
+				assert.Empty(t, plan.Metadata.Costs, "Expected plan %s to have no costs", plan.ID)
+			}
+		}
+	}
+
+	m10 := planByID(services, testPlanID)
+	if assert.NotNil(t, m10) {
+		assert.Equal(t, &brokerapi.ServicePlanMetadata{
+			DisplayName: "M10",
+			Bullets:     []string{"2 GB RAM", "2 vCPUs", "10 GB default storage"},
+		}, m10.Metadata)
+	}
+
+	m0 := planByID(services, "aosb-cluster-plan-aws-m0")
+	if assert.NotNil(t, m0) {
+		assert.Equal(t, &brokerapi.ServicePlanMetadata{
+			DisplayName: "M0 (shared)",
+			Bullets:     []string{"Shared RAM", "Shared vCPU", "0.5 GB storage"},
+		}, m0.Metadata)
+	}
+}
+
+func TestPlanMetadataUnknownSize(t *testing.T) {
+	assert.Equal(t, &brokerapi.ServicePlanMetadata{DisplayName: "M1000"}, planMetadata("M1000"))
+}
+
+func TestWithPlanCosts(t *testing.T) {
+	costs := []brokerapi.ServicePlanCost{
+		{Amount: map[string]float64{"usd": 0.08}, Unit: "HOURLY"},
+	}
+	broker, _, ctx := setupTest(WithPlanCosts(map[string][]brokerapi.ServicePlanCost{
+		testPlanID:                    costs,
+		"aosb-cluster-plan-tenant-m2": costs,
+	}))
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, planID := range []string{testPlanID, "aosb-cluster-plan-tenant-m2"} {
+		plan := planByID(services, planID)
+		if assert.NotNil(t, plan) {
+			assert.Equal(t, costs, plan.Metadata.Costs)
+			assert.NotEmpty(t, plan.Metadata.Bullets)
+		}
+	}
+
+	// Other plans and the shared catalog aren't affected.
+	assert.Empty(t, planByID(services, "aosb-cluster-plan-aws-m20").Metadata.Costs)
+	assert.Empty(t, sharedService(DefaultIDPrefix).Plans[0].Metadata.Costs)
+
+	invalidCosts := []map[string][]brokerapi.ServicePlanCost{
+		{"": costs},
+		{testPlanID: {{Unit: "MONTHLY"}}},
+		{testPlanID: {{Amount: map[string]float64{"usd": 10}}}},
+		{testPlanID: {{Amount: map[string]float64{"usd": -1}, Unit: "MONTHLY"}}},
+	}
+
+	for _, invalid := range invalidCosts {
+		_, err := New(zap.NewNop().Sugar(), WithPlanCosts(invalid))
+		assert.Error(t, err, "Expected costs %+v to be rejected", invalid)
+	}
+}
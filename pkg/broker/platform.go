@@ -0,0 +1,218 @@
+package broker
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// The platforms identified by the "platform" field of the OSB context.
+const (
+	PlatformKubernetes   = "kubernetes"
+	PlatformCloudFoundry = "cloudfoundry"
+)
+
+// The shapes binding credentials can be returned in. The default shape
+// depends on the platform, see bindingPlatform. Service binding credentials
+// follow the Kubernetes Service Binding specification: flat string values
+// including the "type" and "provider" of the service, so they can be
+// projected into workloads as they are.
+const (
+	CredentialStyleDefault        = "default"
+	CredentialStyleServiceBinding = "servicebinding"
+)
+
+// The values of the "type" and "provider" service binding credentials.
+const (
+	serviceBindingType     = "mongodb"
+	serviceBindingProvider = "atlas"
+)
+
+// validateCredentialStyle checks that a credential style is known. Empty
+// styles are valid and use the default.
+func validateCredentialStyle(style string) error {
+	switch style {
+	case "", CredentialStyleDefault, CredentialStyleServiceBinding:
+		return nil
+	default:
+		return fmt.Errorf(`must be "%s" or "%s"`, CredentialStyleDefault, CredentialStyleServiceBinding)
+	}
+}
+
+// credentialStyleFromParams returns the credential style requested by the
+// "credentialStyle" bind parameter, falling back to the configured default.
+func (b Broker) credentialStyleFromParams(rawParams []byte) (string, error) {
+	params := struct {
+		CredentialStyle string `json:"credentialStyle"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return "", validationErrorFromJSON(err)
+		}
+	}
+
+	if err := validateCredentialStyle(params.CredentialStyle); err != nil {
+		verr := &ValidationError{}
+		verr.add("credentialStyle", "%s", err.Error())
+		return "", verr
+	}
+
+	if params.CredentialStyle == "" {
+		return b.credentialStyle, nil
+	}
+
+	return params.CredentialStyle, nil
+}
+
+// serviceBindingCredentials converts credentials into the service binding
+// shape. Additional credentials are flattened like for Kubernetes, the
+// "type", "provider" and "database" keys are set by the broker. The database
+// is left out if the connection string of the cluster doesn't name one.
+func serviceBindingCredentials(credentials interface{}, database string) (map[string]string, error) {
+	flattened, err := flattenCredentials(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	flattened["type"] = serviceBindingType
+	flattened["provider"] = serviceBindingProvider
+
+	if database != "" {
+		flattened["database"] = database
+	}
+
+	return flattened, nil
+}
+
+// platformFromContext extracts the platform from the OSB context object.
+// Platforms which don't send one result in an empty string.
+func platformFromContext(rawContext json.RawMessage) string {
+	if len(rawContext) == 0 {
+		return ""
+	}
+
+	var platformContext struct {
+		Platform string `json:"platform"`
+	}
+	if err := json.Unmarshal(rawContext, &platformContext); err != nil {
+		return ""
+	}
+
+	return platformContext.Platform
+}
+
+// bindingPlatform determines the platform a binding is created for. The bind
+// context takes precedence over the platform recorded when the instance was
+// provisioned, the configured default is used if neither is known.
+func (b Broker) bindingPlatform(cluster *atlas.Cluster, rawContext json.RawMessage) string {
+	if platform := platformFromContext(rawContext); platform != "" {
+		return platform
+	}
+
+	if platform := InstanceMetadata(cluster).Platform; platform != "" {
+		return platform
+	}
+
+	return b.defaultPlatform
+}
+
+// flattenCredentials converts credentials into string-only values as expected
+// by Kubernetes secrets. Nested objects are flattened by joining the keys with
+// an underscore, arrays of scalars are joined with commas and any other arrays
+// are encoded as JSON. Top-level values take precedence over flattened ones
+// with the same key.
+func flattenCredentials(credentials interface{}) (map[string]string, error) {
+	data, err := json.Marshal(credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var values map[string]interface{}
+	if err := decoder.Decode(&values); err != nil {
+		return nil, fmt.Errorf("credentials must be an object: %v", err)
+	}
+
+	flattened := map[string]string{}
+	nested := map[string]string{}
+
+	for _, key := range sortedCredentialKeys(values) {
+		if object, isObject := values[key].(map[string]interface{}); isObject {
+			flattenObject(nested, key, object)
+			continue
+		}
+
+		flattened[key] = credentialString(values[key])
+	}
+
+	for key, value := range nested {
+		if _, exists := flattened[key]; !exists {
+			flattened[key] = value
+		}
+	}
+
+	return flattened, nil
+}
+
+// flattenObject adds the values of a nested object to flattened, prefixing
+// their keys.
+func flattenObject(flattened map[string]string, prefix string, object map[string]interface{}) {
+	for _, key := range sortedCredentialKeys(object) {
+		name := prefix + "_" + key
+
+		if nested, isObject := object[key].(map[string]interface{}); isObject {
+			flattenObject(flattened, name, nested)
+			continue
+		}
+
+		flattened[name] = credentialString(object[key])
+	}
+}
+
+// credentialString converts a scalar or array credential value into a string.
+func credentialString(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case bool:
+		return fmt.Sprintf("%t", v)
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			switch item.(type) {
+			case map[string]interface{}, []interface{}:
+				encoded, _ := json.Marshal(v)
+				return string(encoded)
+			}
+
+			items[i] = credentialString(item)
+		}
+
+		return strings.Join(items, ",")
+	}
+
+	encoded, _ := json.Marshal(value)
+	return string(encoded)
+}
+
+// sortedCredentialKeys returns the keys of a decoded credentials object in order.
+func sortedCredentialKeys(values map[string]interface{}) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	return keys
+}
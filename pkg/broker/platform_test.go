@@ -0,0 +1,270 @@
+package broker
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestPlatformFromContext(t *testing.T) {
+	assert.Equal(t, PlatformKubernetes, platformFromContext(json.RawMessage(`{"platform": "kubernetes", "namespace": "default"}`)))
+	assert.Equal(t, PlatformCloudFoundry, platformFromContext(json.RawMessage(`{"platform": "cloudfoundry"}`)))
+	assert.Equal(t, "", platformFromContext(json.RawMessage(`{}`)))
+	assert.Equal(t, "", platformFromContext(json.RawMessage(`invalid`)))
+	assert.Equal(t, "", platformFromContext(nil))
+}
+
+func TestFlattenCredentials(t *testing.T) {
+	credentials := map[string]interface{}{
+		"username": "user",
+		"port":     27017,
+		"tls":      true,
+		"empty":    nil,
+		"hosts":    []string{"a:27017", "b:27017"},
+		"options": map[string]interface{}{
+			"replicaSet": "rs0",
+			"ssl":        map[string]interface{}{"enabled": true},
+		},
+		"servers":    []interface{}{map[string]interface{}{"host": "a"}},
+		"options_ok": "explicit",
+	}
+
+	flattened, err := flattenCredentials(credentials)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]string{
+		"username":            "user",
+		"port":                "27017",
+		"tls":                 "true",
+		"empty":               "",
+		"hosts":               "a:27017,b:27017",
+		"options_replicaSet":  "rs0",
+		"options_ssl_enabled": "true",
+		"servers":             `[{"host":"a"}]`,
+		"options_ok":          "explicit",
+	}, flattened)
+}
+
+func TestFlattenCredentialsPrecedence(t *testing.T) {
+	flattened, err := flattenCredentials(map[string]interface{}{
+		"a":   map[string]interface{}{"b": "nested"},
+		"a_b": "top-level",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"a_b": "top-level"}, flattened)
+}
+
+func TestFlattenCredentialsConnectionDetails(t *testing.T) {
+	flattened, err := flattenCredentials(ConnectionDetails{
+		Username: "user",
+		Password: "password",
+		URI:      "mongodb+srv://cluster.mongodb.net",
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{
+		"username": "user",
+		"password": "password",
+		"uri":      "mongodb+srv://cluster.mongodb.net",
+	}, flattened)
+}
+
+func TestBindPlatform(t *testing.T) {
+	tests := []struct {
+		name            string
+		opts            []Option
+		provisionCtx    string
+		bindCtx         string
+		expectFlattened bool
+	}{
+		{"kubernetes bind", nil, "", `{"platform": "kubernetes"}`, true},
+		{"cloud foundry bind", nil, "", `{"platform": "cloudfoundry"}`, false},
+		{"no platform", nil, "", "", false},
+		{"kubernetes instance", nil, `{"platform": "kubernetes"}`, "", true},
+		{"bind overrides instance", nil, `{"platform": "kubernetes"}`, `{"platform": "cloudfoundry"}`, false},
+		{"kubernetes default", []Option{WithDefaultPlatform(PlatformKubernetes)}, "", "", true},
+		{"default doesn't override context", []Option{WithDefaultPlatform(PlatformKubernetes)}, "", `{"platform": "cloudfoundry"}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker, client, ctx := setupTest(test.opts...)
+
+			instanceID := "instance"
+			_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:     testPlanID,
+				ServiceID:  testServiceID,
+				RawContext: rawContext(test.provisionCtx),
+			}, true)
+			if !assert.NoError(t, err) {
+				return
+			}
+			client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+			spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+				PlanID:     testPlanID,
+				ServiceID:  testServiceID,
+				RawContext: rawContext(test.bindCtx),
+			}, true)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			if test.expectFlattened {
+				credentials, ok := spec.Credentials.(map[string]string)
+				if assert.True(t, ok, "Expected flattened credentials but got %T", spec.Credentials) {
+					assert.Equal(t, "binding", credentials["username"])
+				}
+			} else {
+				assert.IsType(t, ConnectionDetails{}, spec.Credentials)
+			}
+		})
+	}
+}
+
+func TestWithDefaultPlatformInvalid(t *testing.T) {
+	_, err := New(zap.NewNop().Sugar(), WithDefaultPlatform("openshift"))
+	assert.EqualError(t, err, `unknown platform "openshift", expected "kubernetes" or "cloudfoundry"`)
+}
+
+func rawContext(data string) json.RawMessage {
+	if data == "" {
+		return nil
+	}
+
+	return json.RawMessage(data)
+}
+
+func TestServiceBindingCredentials(t *testing.T) {
+	credentials, err := serviceBindingCredentials(ConnectionDetails{
+		Username: "binding",
+		Password: "secret",
+		URI:      "mongodb+srv://cluster.mongodb.net",
+	}, "orders")
+
+	if assert.NoError(t, err) {
+		encoded, err := json.Marshal(credentials)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{
+			"type": "mongodb",
+			"provider": "atlas",
+			"uri": "mongodb+srv://cluster.mongodb.net",
+			"username": "binding",
+			"password": "secret",
+			"database": "orders"
+		}`, string(encoded))
+	}
+
+	// Additional credentials are flattened, the database is left out if
+	// it isn't known.
+	credentials, err = serviceBindingCredentials(map[string]interface{}{
+		"username": "binding",
+		"password": "secret",
+		"uri":      "mongodb+srv://cluster.mongodb.net",
+		"type":     "other",
+		"options":  map[string]interface{}{"ssl": true},
+	}, "")
+
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{
+			"type":        "mongodb",
+			"provider":    "atlas",
+			"uri":         "mongodb+srv://cluster.mongodb.net",
+			"username":    "binding",
+			"password":    "secret",
+			"options_ssl": "true",
+		}, credentials)
+	}
+}
+
+func TestBindCredentialStyle(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          []Option
+		params        string
+		expectBinding bool
+	}{
+		{"default", nil, "", false},
+		{"service binding param", nil, `{"credentialStyle": "servicebinding"}`, true},
+		{"default param", nil, `{"credentialStyle": "default"}`, false},
+		{"service binding option", []Option{WithCredentialStyle(CredentialStyleServiceBinding)}, "", true},
+		{"param overrides option", []Option{WithCredentialStyle(CredentialStyleServiceBinding)}, `{"credentialStyle": "default"}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			broker, client, ctx := setupTest(test.opts...)
+
+			instanceID := "instance"
+			_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+				PlanID:    testPlanID,
+				ServiceID: testServiceID,
+			}, true)
+			if !assert.NoError(t, err) {
+				return
+			}
+			client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+			details := brokerapi.BindDetails{
+				PlanID:     testPlanID,
+				ServiceID:  testServiceID,
+				RawContext: rawContext(`{"platform": "cloudfoundry"}`),
+			}
+			if test.params != "" {
+				details.RawParameters = json.RawMessage(test.params)
+			}
+
+			spec, err := broker.Bind(ctx, instanceID, "binding", details, true)
+			if !assert.NoError(t, err) {
+				return
+			}
+
+			encoded, err := json.Marshal(spec.Credentials)
+			assert.NoError(t, err)
+
+			if test.expectBinding {
+				assert.JSONEq(t, `{"type": "mongodb", "provider": "atlas", "uri": "", "username": "binding", "password": "`+client.Users["binding"].Password+`", "auth_source": "admin"}`, string(encoded))
+			} else {
+				assert.JSONEq(t, `{"uri": "", "username": "binding", "password": "`+client.Users["binding"].Password+`", "auth_source": "admin"}`, string(encoded))
+			}
+		})
+	}
+}
+
+func TestBindCredentialStyleInvalid(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: json.RawMessage(`{"credentialStyle": "flat"}`),
+	}, true)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "credentialStyle")
+	}
+	assert.Nil(t, client.Users["binding"], "Expected no user to be created")
+
+	_, err = New(zap.NewNop().Sugar(), WithCredentialStyle("flat"))
+	assert.EqualError(t, err, `unknown credential style "flat", expected "default" or "servicebinding"`)
+}
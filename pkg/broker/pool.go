@@ -0,0 +1,198 @@
+package broker
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// LabelPoolPlan marks unclaimed warm pool clusters with the ID of the plan
+// they were created for. It's removed when a cluster is claimed.
+const LabelPoolPlan = "aosb-pool-plan"
+
+// poolClusterNamePrefix is prepended to the random names of pool clusters.
+const poolClusterNamePrefix = "aosb-pool-"
+
+// PoolConfig describes a warm pool of clusters which are created ahead of
+// time so provisioning the plan completes almost immediately.
+type PoolConfig struct {
+	ServiceID string `json:"serviceId"`
+	PlanID    string `json:"planId"`
+	Size      int    `json:"size"`
+}
+
+func (c PoolConfig) validate() error {
+	if c.ServiceID == "" || c.PlanID == "" {
+		return errors.New("pools need both a serviceId and a planId")
+	}
+
+	if c.Size < 0 {
+		return fmt.Errorf(`pool size of plan "%s" must not be negative`, c.PlanID)
+	}
+
+	return nil
+}
+
+// pool keeps track of the warm pool configuration. Claims and replenishment
+// are serialized so a pool cluster is never handed out twice by the same
+// broker process. Multiple broker replicas sharing a project can race and
+// shouldn't use pools.
+type pool struct {
+	configs []PoolConfig
+
+	// background runs replenishment without blocking the caller.
+	background func(task func())
+
+	mu sync.Mutex
+}
+
+func newPool(configs []PoolConfig) *pool {
+	return &pool{
+		configs: configs,
+		background: func(task func()) {
+			go task()
+		},
+	}
+}
+
+// config returns the pool configuration of a plan.
+func (p *pool) config(serviceID string, planID string) (PoolConfig, bool) {
+	for _, c := range p.configs {
+		if c.ServiceID == serviceID && c.PlanID == planID && c.Size > 0 {
+			return c, true
+		}
+	}
+
+	return PoolConfig{}, false
+}
+
+// isPoolMember returns whether a cluster is an unclaimed pool cluster of a
+// plan.
+func isPoolMember(cluster *atlas.Cluster, planID string) bool {
+	return labelValue(cluster.Labels, LabelPoolPlan) == planID && labelValue(cluster.Labels, LabelInstanceID) == ""
+}
+
+// claimPoolCluster hands an idle pool cluster of the plan over to an
+// instance by replacing its pool label with the instance labels. Nil is
+// returned if no cluster is available.
+func (b Broker) claimPoolCluster(client atlas.Client, serviceID string, planID string, labels []atlas.Label) (*atlas.Cluster, error) {
+	if _, ok := b.pool.config(serviceID, planID); !ok {
+		return nil, nil
+	}
+
+	b.pool.mu.Lock()
+	defer b.pool.mu.Unlock()
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range clusters {
+		cluster := &clusters[i]
+		if !isPoolMember(cluster, planID) || cluster.StateName != atlas.ClusterStateIdle {
+			continue
+		}
+
+		claimed := []atlas.Label{}
+		for _, label := range cluster.Labels {
+			if label.Key != LabelPoolPlan {
+				claimed = append(claimed, label)
+			}
+		}
+
+		// Only the labels are sent so the cluster configuration is left
+		// untouched.
+		return client.UpdateCluster(atlas.Cluster{
+			Name:   cluster.Name,
+			Labels: mergeLabels(claimed, labels),
+		})
+	}
+
+	return nil, nil
+}
+
+// ReplenishPool creates clusters until every configured pool has reached its
+// size. Pool clusters which are still being created count towards the size.
+// It does nothing if no pools are configured.
+func (b Broker) ReplenishPool(client atlas.Client) error {
+	if b.pool == nil {
+		return nil
+	}
+
+	b.pool.mu.Lock()
+	defer b.pool.mu.Unlock()
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		return err
+	}
+
+	for _, config := range b.pool.configs {
+		members := 0
+		for i := range clusters {
+			if isPoolMember(&clusters[i], config.PlanID) && clusters[i].StateName != atlas.ClusterStateDeleting && clusters[i].StateName != atlas.ClusterStateDeleted {
+				members++
+			}
+		}
+
+		for ; members < config.Size; members++ {
+			if err := b.createPoolCluster(client, config); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// createPoolCluster creates a single pool cluster using the plan and the
+// operator defaults.
+func (b Broker) createPoolCluster(client atlas.Client, config PoolConfig) error {
+	name, err := poolClusterName()
+	if err != nil {
+		return err
+	}
+
+	cluster, err := b.clusterFromParams(client, name, config.ServiceID, config.PlanID, nil, b.clusterDefaults, nil)
+	if err != nil {
+		return err
+	}
+
+	cluster.Name = name
+	setLabels(cluster, []atlas.Label{atlas.Label{Key: LabelPoolPlan, Value: config.PlanID}})
+
+	if _, err := client.CreateCluster(*cluster); err != nil {
+		return err
+	}
+
+	b.logger.Infow("Created warm pool cluster", "cluster", name, "plan_id", config.PlanID)
+	return nil
+}
+
+// replenishPoolInBackground refills the pools after a claim without delaying
+// the provision. It waits while the rate limit budget of the project is low.
+func (b Broker) replenishPoolInBackground(client atlas.Client, groupID string) {
+	b.pool.background(func() {
+		b.pace(groupID)
+
+		if err := b.ReplenishPool(client); err != nil {
+			b.logger.Errorw("Failed to replenish warm pool", "error", err)
+		}
+	})
+}
+
+// poolClusterName generates a random name for a pool cluster which fits into
+// the maximum cluster name length.
+func poolClusterName() (string, error) {
+	suffix := make([]byte, (maximumClusterNameLength-len(poolClusterNamePrefix))/2)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+
+	return poolClusterNamePrefix + hex.EncodeToString(suffix), nil
+}
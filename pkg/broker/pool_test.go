@@ -0,0 +1,162 @@
+package broker
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+var testPool = PoolConfig{
+	ServiceID: testServiceID,
+	PlanID:    testPlanID,
+	Size:      2,
+}
+
+// setupPoolTest creates a broker with a filled warm pool whose clusters are
+// ready to be claimed. Replenishment runs synchronously.
+func setupPoolTest(t *testing.T) (*Broker, MockAtlasClient, context.Context) {
+	broker, client, ctx := setupTest(WithPools(testPool))
+	broker.pool.background = func(task func()) {
+		task()
+	}
+
+	if !assert.NoError(t, broker.ReplenishPool(client)) {
+		t.FailNow()
+	}
+
+	for name := range client.Clusters {
+		client.SetClusterState(name, atlas.ClusterStateIdle)
+	}
+
+	return broker, client, ctx
+}
+
+func poolMembers(client MockAtlasClient) []*atlas.Cluster {
+	members := []*atlas.Cluster{}
+	for _, cluster := range client.Clusters {
+		if cluster != nil && isPoolMember(cluster, testPlanID) {
+			members = append(members, cluster)
+		}
+	}
+
+	return members
+}
+
+func TestReplenishPool(t *testing.T) {
+	broker, client, _ := setupPoolTest(t)
+
+	members := poolMembers(client)
+	if assert.Len(t, members, 2) {
+		for _, cluster := range members {
+			assert.True(t, strings.HasPrefix(cluster.Name, poolClusterNamePrefix))
+			assert.True(t, len(cluster.Name) <= maximumClusterNameLength)
+			assert.Equal(t, "M10", cluster.ProviderSettings.InstanceSizeName)
+		}
+	}
+
+	// A full pool isn't grown any further.
+	assert.NoError(t, broker.ReplenishPool(client))
+	assert.Len(t, poolMembers(client), 2)
+}
+
+func TestProvisionFromPool(t *testing.T) {
+	broker, client, ctx := setupPoolTest(t)
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, false)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.False(t, spec.IsAsync)
+	assert.Nil(t, client.Clusters[instanceID], "Expected no new cluster to be created")
+
+	// The claimed cluster is replaced with a new pool member.
+	assert.Len(t, poolMembers(client), 2)
+
+	cluster, err := broker.instanceCluster(client, instanceID)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(cluster.Name, poolClusterNamePrefix))
+	assert.Equal(t, "", labelValue(cluster.Labels, LabelPoolPlan))
+
+	// The claimed cluster is used for all further operations.
+	_, err = broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Deprovision(ctx, instanceID, brokerapi.DeprovisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, client.Clusters[cluster.Name], "Expected claimed cluster to be deleted")
+}
+
+func TestProvisionFromPoolWithParams(t *testing.T) {
+	broker, client, ctx := setupPoolTest(t)
+
+	instanceID := "instance"
+	spec, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.True(t, spec.IsAsync)
+	assert.NotNil(t, client.Clusters[instanceID])
+	assert.Len(t, poolMembers(client), 2)
+}
+
+func TestProvisionFromEmptyPool(t *testing.T) {
+	broker, client, ctx := setupPoolTest(t)
+
+	// Pool clusters which are still being created can't be claimed.
+	for _, cluster := range poolMembers(client) {
+		cluster.StateName = atlas.ClusterStateCreating
+	}
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, false)
+	assert.Equal(t, apiresponses.ErrAsyncRequired, err)
+
+	spec, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.True(t, spec.IsAsync)
+}
+
+func TestPoolsInert(t *testing.T) {
+	broker, client, ctx := setupTest(WithPools(PoolConfig{ServiceID: testServiceID, PlanID: testPlanID, Size: 0}))
+	assert.Nil(t, broker.pool)
+
+	assert.NoError(t, broker.ReplenishPool(client))
+	assert.Empty(t, client.Clusters)
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, false)
+	assert.Equal(t, apiresponses.ErrAsyncRequired, err)
+}
+
+func TestWithPoolsInvalid(t *testing.T) {
+	assert.Error(t, WithPools(PoolConfig{PlanID: testPlanID, Size: 1})(&Broker{}))
+	assert.Error(t, WithPools(PoolConfig{ServiceID: testServiceID, PlanID: testPlanID, Size: -1})(&Broker{}))
+}
@@ -0,0 +1,136 @@
+package broker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// DefaultConnectionProbeMaxWait is how long a provision is held in progress
+// after the cluster became idle while waiting for the probe to pass.
+const DefaultConnectionProbeMaxWait = 5 * time.Minute
+
+// connectionProbeTimeout bounds a single probe attempt, including the SRV
+// lookup and the TLS handshake.
+const connectionProbeTimeout = 10 * time.Second
+
+// connectionProbe checks that a newly provisioned cluster can actually be
+// reached before the provision is reported as successful. Atlas may report a
+// cluster as idle before its DNS records have propagated.
+type connectionProbe struct {
+	maxWait time.Duration
+	probe   func(ctx context.Context, srvAddress string) error
+
+	// failingSince records when the probe first failed for each instance.
+	// It's kept in memory so a restarted broker grants another full wait.
+	mu           sync.Mutex
+	failingSince map[string]time.Time
+}
+
+func newConnectionProbe(maxWait time.Duration) *connectionProbe {
+	return &connectionProbe{
+		maxWait:      maxWait,
+		probe:        probeSRVAddress,
+		failingSince: map[string]time.Time{},
+	}
+}
+
+// check probes the cluster of an instance and returns the resulting state of
+// the provision. Failures keep the provision in progress until maxWait has
+// passed since the first failure, after which it's reported as failed. The
+// probe error is returned for logging. The wait is measured on the clock.
+func (p *connectionProbe) check(ctx context.Context, clock clock.Clock, instanceID string, cluster *atlas.Cluster) (brokerapi.LastOperationState, string, error) {
+	err := p.probe(ctx, cluster.SrvAddress)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err == nil {
+		delete(p.failingSince, instanceID)
+		return brokerapi.Succeeded, "", nil
+	}
+
+	since, failed := p.failingSince[instanceID]
+	if !failed {
+		since = clock.Now()
+		p.failingSince[instanceID] = since
+	}
+
+	if clock.Now().Sub(since) >= p.maxWait {
+		delete(p.failingSince, instanceID)
+		return brokerapi.Failed, fmt.Sprintf("cluster is not reachable: %v", err), err
+	}
+
+	return brokerapi.InProgress, "waiting for the cluster to become reachable", err
+}
+
+// shouldProbeConnection returns whether the connection probe is enabled and
+// the instance hasn't opted out.
+func (b Broker) shouldProbeConnection(cluster *atlas.Cluster) bool {
+	return b.connectionProbe != nil && labelValue(cluster.Labels, LabelSkipConnectionProbe) != "true"
+}
+
+// probeSRVAddress resolves the SRV record of a cluster and completes a TLS
+// handshake with the first host. No credentials are needed.
+func probeSRVAddress(ctx context.Context, srvAddress string) error {
+	address, err := url.Parse(srvAddress)
+	if err != nil || address.Host == "" {
+		return fmt.Errorf(`invalid SRV address "%s"`, srvAddress)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, connectionProbeTimeout)
+	defer cancel()
+
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "mongodb", "tcp", address.Hostname())
+	if err != nil {
+		return err
+	}
+
+	if len(records) == 0 {
+		return errors.New("SRV record has no hosts")
+	}
+
+	host := strings.TrimSuffix(records[0].Target, ".")
+	dialer := &net.Dialer{}
+	if deadline, ok := ctx.Deadline(); ok {
+		dialer.Deadline = deadline
+	}
+
+	conn, err := tls.DialWithDialer(dialer, "tcp", net.JoinHostPort(host, strconv.Itoa(int(records[0].Port))), &tls.Config{
+		ServerName: host,
+	})
+	if err != nil {
+		return err
+	}
+
+	return conn.Close()
+}
+
+// skipConnectionProbeFromParams reads the skipConnectionProbe parameter which
+// opts a single instance out of the connection probe.
+func skipConnectionProbeFromParams(rawParams []byte) (bool, error) {
+	if len(rawParams) == 0 {
+		return false, nil
+	}
+
+	params := struct {
+		SkipConnectionProbe bool `json:"skipConnectionProbe"`
+	}{}
+	if err := json.Unmarshal(rawParams, &params); err != nil {
+		return false, validationErrorFromJSON(err)
+	}
+
+	return params.SkipConnectionProbe, nil
+}
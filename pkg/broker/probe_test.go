@@ -0,0 +1,124 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProbe replaces the network probe of a broker and returns a function to
+// advance its clock.
+func fakeProbe(broker *Broker, result *error) func(time.Duration) {
+	broker.connectionProbe.probe = func(ctx context.Context, srvAddress string) error {
+		return *result
+	}
+
+	return testClock(broker).Advance
+}
+
+func TestLastOperationProvisionProbe(t *testing.T) {
+	broker, client, ctx := setupTest(WithConnectionProbe(5 * time.Minute))
+
+	probeErr := errors.New("no such host")
+	advance := fakeProbe(broker, &probeErr)
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	poll := brokerapi.PollDetails{OperationData: OperationProvision}
+
+	// The provision is held while the cluster can't be reached.
+	resp, err := broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.InProgress, resp.State)
+
+	advance(4 * time.Minute)
+	resp, err = broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.InProgress, resp.State)
+
+	// It succeeds as soon as the probe passes.
+	probeErr = nil
+	resp, err = broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.Succeeded, resp.State)
+}
+
+func TestLastOperationProvisionProbeTimeout(t *testing.T) {
+	broker, client, ctx := setupTest(WithConnectionProbe(5 * time.Minute))
+
+	probeErr := errors.New("connection refused")
+	advance := fakeProbe(broker, &probeErr)
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+
+	poll := brokerapi.PollDetails{OperationData: OperationProvision}
+
+	resp, _ := broker.LastOperation(ctx, instanceID, poll)
+	assert.Equal(t, brokerapi.InProgress, resp.State)
+
+	advance(5 * time.Minute)
+	resp, err := broker.LastOperation(ctx, instanceID, poll)
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.Failed, resp.State)
+	assert.Contains(t, resp.Description, "connection refused")
+}
+
+func TestLastOperationProvisionProbeSkipped(t *testing.T) {
+	broker, client, ctx := setupTest(WithConnectionProbe(5 * time.Minute))
+
+	probeErr := errors.New("no such host")
+	fakeProbe(broker, &probeErr)
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"skipConnectionProbe": true}`),
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cluster := client.Clusters[instanceID]
+	assert.Equal(t, "true", labelValue(cluster.Labels, LabelSkipConnectionProbe))
+
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	resp, err := broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{
+		OperationData: OperationProvision,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, brokerapi.Succeeded, resp.State)
+}
+
+func TestProvisionInvalidSkipConnectionProbe(t *testing.T) {
+	broker, _, ctx := setupTest(WithConnectionProbe(5 * time.Minute))
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"skipConnectionProbe": "yes"}`),
+	}, true)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "skipConnectionProbe")
+	}
+}
@@ -0,0 +1,181 @@
+package broker
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// tlsProtocolIndex returns the position of a TLS protocol in
+// atlas.TLSProtocols, which orders them from the oldest to the newest. It's
+// -1 for unknown protocols.
+func tlsProtocolIndex(protocol string) int {
+	for i, known := range atlas.TLSProtocols {
+		if known == protocol {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// processArgsFromParams returns the process arguments requested by the
+// "processArgs" parameter, or its "advancedConfiguration" alias named after
+// the Atlas UI, with the minimum TLS protocol of the broker applied.
+// Parameters asking for an older protocol than the minimum result in a
+// *ValidationError. Nil is returned if there's nothing to apply.
+func (b Broker) processArgsFromParams(rawParams []byte) (*atlas.ProcessArgs, error) {
+	params := struct {
+		ProcessArgs           *atlas.ProcessArgs `json:"processArgs"`
+		AdvancedConfiguration *atlas.ProcessArgs `json:"advancedConfiguration"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return nil, validationErrorFromJSON(err)
+		}
+	}
+
+	verr := &ValidationError{}
+	field := "processArgs"
+	args := params.ProcessArgs
+	if params.AdvancedConfiguration != nil {
+		if args != nil {
+			verr.add("advancedConfiguration", "must not be combined with processArgs")
+			return nil, verr
+		}
+
+		field = "advancedConfiguration"
+		args = params.AdvancedConfiguration
+	}
+
+	if args == nil {
+		if b.minimumTLSProtocol == "" {
+			return nil, nil
+		}
+
+		args = &atlas.ProcessArgs{}
+	}
+
+	if protocol := args.MinimumEnabledTLSProtocol; protocol != "" {
+		index := tlsProtocolIndex(protocol)
+
+		switch {
+		case index < 0:
+			verr.add(field+".minimumEnabledTlsProtocol", "must be one of %s", strings.Join(atlas.TLSProtocols, ", "))
+		case b.minimumTLSProtocol != "" && index < tlsProtocolIndex(b.minimumTLSProtocol):
+			verr.add(field+".minimumEnabledTlsProtocol", `must not be older than "%s" which is enforced by the broker`, b.minimumTLSProtocol)
+		}
+	}
+
+	if args.OplogSizeMB < 0 {
+		verr.add(field+".oplogSizeMB", "must not be negative")
+	}
+
+	if err := verr.errorOrNil(); err != nil {
+		return nil, err
+	}
+
+	// Clusters without a requested protocol are re-asserted to the minimum,
+	// in case it has been lowered outside of the broker.
+	if args.MinimumEnabledTLSProtocol == "" {
+		args.MinimumEnabledTLSProtocol = b.minimumTLSProtocol
+	}
+
+	return args, nil
+}
+
+// applyProcessArgs updates the process arguments of a cluster if there are
+// any to apply. The cluster has already been created or updated at this
+// point, which the error tells the platform.
+func (b Broker) applyProcessArgs(client atlas.Client, clusterName string, args *atlas.ProcessArgs) error {
+	if args == nil {
+		return nil
+	}
+
+	if _, err := client.UpdateProcessArgs(clusterName, *args); err != nil {
+		b.logger.Errorw("Failed to update process arguments", "error", err, "cluster_name", clusterName, "process_args", args)
+		return processArgsError(clusterName, err)
+	}
+
+	return nil
+}
+
+// processArgsError combines the outcome of both steps of an operation into
+// one error: the cluster is in place but its process arguments aren't.
+func processArgsError(clusterName string, err error) error {
+	status := http.StatusInternalServerError
+	if err == atlas.ErrUnauthorized {
+		status = http.StatusUnauthorized
+	}
+
+	return apiresponses.NewFailureResponse(
+		fmt.Errorf("the changes to cluster %s have been started but its process arguments couldn't be applied: %v", clusterName, err),
+		status,
+		"process-args-failed",
+	)
+}
+
+// processArgsLabel records the process arguments requested for a cluster so
+// LastOperation can check they have been applied.
+func processArgsLabel(args *atlas.ProcessArgs) atlas.Label {
+	data, _ := json.Marshal(args)
+	return atlas.Label{Key: LabelProcessArgs, Value: string(data)}
+}
+
+// pendingProcessArgs returns the process arguments recorded on a cluster
+// which Atlas doesn't report yet, or nil if they have all been applied.
+func pendingProcessArgs(cluster *atlas.Cluster, current *atlas.ProcessArgs) *atlas.ProcessArgs {
+	value := labelValue(cluster.Labels, LabelProcessArgs)
+	if value == "" {
+		return nil
+	}
+
+	var requested atlas.ProcessArgs
+	if err := json.Unmarshal([]byte(value), &requested); err != nil {
+		return nil
+	}
+
+	applied := (requested.MinimumEnabledTLSProtocol == "" || requested.MinimumEnabledTLSProtocol == current.MinimumEnabledTLSProtocol) &&
+		(requested.JavascriptEnabled == nil || current.JavascriptEnabled != nil && *requested.JavascriptEnabled == *current.JavascriptEnabled) &&
+		(requested.NoTableScan == nil || current.NoTableScan != nil && *requested.NoTableScan == *current.NoTableScan) &&
+		(requested.OplogSizeMB == 0 || requested.OplogSizeMB == current.OplogSizeMB)
+	if applied {
+		return nil
+	}
+
+	return &requested
+}
+
+// checkProcessArgs holds a finished provision or update until the process
+// arguments recorded on the cluster are applied, applying them again if an
+// earlier attempt failed.
+func (b Broker) checkProcessArgs(client atlas.Client, cluster *atlas.Cluster) (brokerapi.LastOperationState, string, error) {
+	if labelValue(cluster.Labels, LabelProcessArgs) == "" {
+		return brokerapi.Succeeded, "", nil
+	}
+
+	current, err := client.GetProcessArgs(cluster.Name)
+	if err != nil {
+		b.logger.Errorw("Failed to get process arguments", "error", err, "cluster_name", cluster.Name)
+		return brokerapi.Failed, "", atlasToAPIError(err)
+	}
+
+	pending := pendingProcessArgs(cluster, current)
+	if pending == nil {
+		return brokerapi.Succeeded, "", nil
+	}
+
+	if _, err := client.UpdateProcessArgs(cluster.Name, *pending); err != nil {
+		b.logger.Errorw("Failed to update process arguments", "error", err, "cluster_name", cluster.Name, "process_args", pending)
+		return brokerapi.Failed, fmt.Sprintf("Process arguments couldn't be applied: %v", err), nil
+	}
+
+	b.logger.Infow("Applied pending process arguments", "cluster_name", cluster.Name, "process_args", pending)
+	return brokerapi.InProgress, "Applying process arguments", nil
+}
@@ -0,0 +1,219 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestWithMinimumTLSProtocol(t *testing.T) {
+	broker, err := New(zap.NewNop().Sugar(), WithMinimumTLSProtocol(atlas.TLSProtocol1_2))
+	if assert.NoError(t, err) {
+		assert.Equal(t, atlas.TLSProtocol1_2, broker.minimumTLSProtocol)
+	}
+
+	_, err = New(zap.NewNop().Sugar(), WithMinimumTLSProtocol("TLS1_3"))
+	assert.Error(t, err)
+}
+
+func TestProvisionMinimumTLSProtocol(t *testing.T) {
+	broker, client, ctx := setupTest(WithMinimumTLSProtocol(atlas.TLSProtocol1_1))
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.ProcessArgs{MinimumEnabledTLSProtocol: atlas.TLSProtocol1_1}, client.ProcessArgs["instance"])
+
+	// Newer protocols than the minimum can be requested.
+	_, err = broker.Provision(ctx, "newer", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"processArgs": {"minimumEnabledTlsProtocol": "TLS1_2", "noTableScan": true}}`),
+	}, true)
+
+	noTableScan := true
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.ProcessArgs{MinimumEnabledTLSProtocol: atlas.TLSProtocol1_2, NoTableScan: &noTableScan}, client.ProcessArgs["newer"])
+}
+
+func TestProvisionWithoutMinimumTLSProtocol(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Nil(t, client.ProcessArgs["instance"], "Expected process arguments to be left alone")
+}
+
+func TestUpdateMinimumTLSProtocol(t *testing.T) {
+	broker, client, ctx := setupTest(WithMinimumTLSProtocol(atlas.TLSProtocol1_2))
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	// The protocol was lowered outside of the broker.
+	client.ProcessArgs["instance"].MinimumEnabledTLSProtocol = atlas.TLSProtocol1_0
+
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		ServiceID: testServiceID,
+		PlanID:    "aosb-cluster-plan-aws-m20",
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, atlas.TLSProtocol1_2, client.ProcessArgs["instance"].MinimumEnabledTLSProtocol)
+}
+
+func TestProcessArgsBelowMinimumTLSProtocol(t *testing.T) {
+	broker, client, ctx := setupTest(WithMinimumTLSProtocol(atlas.TLSProtocol1_2))
+
+	params := []byte(`{"processArgs": {"minimumEnabledTlsProtocol": "TLS1_0"}}`)
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: params,
+	}, true)
+
+	assertInvalidProcessArgs(t, err)
+	assert.Nil(t, client.Clusters["instance"], "Expected no cluster to be created")
+
+	_, err = broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: params,
+	}, true)
+
+	assertInvalidProcessArgs(t, err)
+	assert.Equal(t, atlas.TLSProtocol1_2, client.ProcessArgs["instance"].MinimumEnabledTLSProtocol)
+}
+
+func TestProcessArgsFromParams(t *testing.T) {
+	broker, _, _ := setupTest()
+
+	args, err := broker.processArgsFromParams(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, args)
+
+	args, err = broker.processArgsFromParams([]byte(`{"processArgs": {"minimumEnabledTlsProtocol": "TLS1_0"}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.ProcessArgs{MinimumEnabledTLSProtocol: atlas.TLSProtocol1_0}, args)
+
+	_, err = broker.processArgsFromParams([]byte(`{"processArgs": {"minimumEnabledTlsProtocol": "SSL3"}}`))
+	assert.IsType(t, &ValidationError{}, err)
+
+	_, err = broker.processArgsFromParams([]byte(`{"processArgs": {"oplogSizeMB": -1}}`))
+	assert.IsType(t, &ValidationError{}, err)
+}
+
+func TestProcessArgsFromParamsAdvancedConfiguration(t *testing.T) {
+	broker, _, _ := setupTest()
+
+	args, err := broker.processArgsFromParams([]byte(`{"advancedConfiguration": {"oplogSizeMB": 2048}}`))
+	assert.NoError(t, err)
+	assert.Equal(t, &atlas.ProcessArgs{OplogSizeMB: 2048}, args)
+
+	_, err = broker.processArgsFromParams([]byte(`{"advancedConfiguration": {"oplogSizeMB": -1}}`))
+	verr, ok := err.(*ValidationError)
+	if assert.True(t, ok, "Expected a validation error") && assert.Len(t, verr.Violations, 1) {
+		assert.Equal(t, "advancedConfiguration.oplogSizeMB", verr.Violations[0].Field)
+	}
+
+	_, err = broker.processArgsFromParams([]byte(`{"processArgs": {}, "advancedConfiguration": {}}`))
+	verr, ok = err.(*ValidationError)
+	if assert.True(t, ok, "Expected a validation error") && assert.Len(t, verr.Violations, 1) {
+		assert.Equal(t, "advancedConfiguration", verr.Violations[0].Field)
+	}
+}
+
+func TestLastOperationWaitsForProcessArgs(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"advancedConfiguration": {"javascriptEnabled": false, "oplogSizeMB": 2048}}`),
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poll := func() brokerapi.LastOperation {
+		resp, err := broker.LastOperation(ctx, "instance", brokerapi.PollDetails{OperationData: OperationProvision})
+		assert.NoError(t, err)
+		return resp
+	}
+
+	client.SetClusterState("instance", atlas.ClusterStateIdle)
+	assert.Equal(t, brokerapi.Succeeded, poll().State)
+
+	// Arguments which didn't make it are applied again before the
+	// operation succeeds.
+	delete(client.ProcessArgs, "instance")
+	resp := poll()
+	assert.Equal(t, brokerapi.InProgress, resp.State)
+	assert.Equal(t, "Applying process arguments", resp.Description)
+
+	javascriptEnabled := false
+	assert.Equal(t, &atlas.ProcessArgs{JavascriptEnabled: &javascriptEnabled, OplogSizeMB: 2048}, client.ProcessArgs["instance"])
+	assert.Equal(t, brokerapi.Succeeded, poll().State)
+}
+
+func TestUpdateRecordsProcessArgs(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Empty(t, labelValue(client.Clusters["instance"].Labels, LabelProcessArgs))
+
+	_, err = broker.Update(ctx, "instance", brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"processArgs": {"noTableScan": true}}`),
+	}, true)
+
+	assert.NoError(t, err)
+	assert.Equal(t, `{"noTableScan":true}`, labelValue(client.Clusters["instance"].Labels, LabelProcessArgs))
+	assert.NotEmpty(t, labelValue(client.Clusters["instance"].Labels, LabelInstanceID), "Expected broker labels to be kept")
+}
+
+func TestProcessArgsError(t *testing.T) {
+	err := processArgsError("my-cluster", atlas.ErrUnauthorized)
+
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusUnauthorized, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "my-cluster")
+	}
+
+	failure = processArgsError("cluster", atlas.ErrClusterNotFound).(*apiresponses.FailureResponse)
+	assert.Equal(t, http.StatusInternalServerError, failure.ValidatedStatusCode(nil))
+}
+
+func assertInvalidProcessArgs(t *testing.T, err error) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil))
+		assert.Contains(t, failure.Error(), "processArgs.minimumEnabledTlsProtocol")
+	}
+}
@@ -0,0 +1,117 @@
+package broker
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// QuotaRule limits the instances of a platform organization, or of a single
+// space within it. A broker without rules is unlimited.
+type QuotaRule struct {
+	// OrgGUID is the organization the rule applies to, "*" matches all
+	// organizations.
+	OrgGUID string `json:"orgGuid"`
+
+	// SpaceGUID optionally narrows the rule to a single space.
+	SpaceGUID string `json:"spaceGuid,omitempty"`
+
+	// MaxInstances is the maximum number of clusters, zero means unlimited.
+	MaxInstances int `json:"maxInstances,omitempty"`
+
+	// AllowedPlans are the names of the plans which may be used, for example
+	// "M10". All plans are allowed if empty.
+	AllowedPlans []string `json:"allowedPlans,omitempty"`
+}
+
+// AllOrgs can be used as the OrgGUID of a QuotaRule to match every
+// organization.
+const AllOrgs = "*"
+
+// matches returns whether the rule applies to an organization and space.
+func (r QuotaRule) matches(orgGUID string, spaceGUID string) bool {
+	if r.OrgGUID != AllOrgs && r.OrgGUID != orgGUID {
+		return false
+	}
+
+	return r.SpaceGUID == "" || r.SpaceGUID == spaceGUID
+}
+
+// scope describes what the rule applies to for error messages.
+func (r QuotaRule) scope(orgGUID string) string {
+	if r.SpaceGUID != "" {
+		return fmt.Sprintf(`space "%s"`, r.SpaceGUID)
+	}
+
+	return fmt.Sprintf(`organization "%s"`, orgGUID)
+}
+
+// validate checks the rule for obvious mistakes.
+func (r QuotaRule) validate() error {
+	if r.OrgGUID == "" {
+		return errors.New(`quota rules require an orgGuid, use "*" to match all organizations`)
+	}
+
+	if r.MaxInstances < 0 {
+		return fmt.Errorf(`quota rule for organization "%s" has a negative maxInstances`, r.OrgGUID)
+	}
+
+	return nil
+}
+
+// checkPlanQuota verifies a plan is allowed by all rules matching the
+// organization and space.
+func (b Broker) checkPlanQuota(orgGUID string, spaceGUID string, planName string) error {
+	for _, rule := range b.quotas {
+		if !rule.matches(orgGUID, spaceGUID) || len(rule.AllowedPlans) == 0 {
+			continue
+		}
+
+		if !containsString(rule.AllowedPlans, planName) {
+			err := fmt.Errorf(`plan "%s" is not allowed for %s, allowed plans are %s`, planName, rule.scope(orgGUID), quotedList(rule.AllowedPlans))
+			return apiresponses.NewFailureResponse(err, http.StatusForbidden, "quota-exceeded")
+		}
+	}
+
+	return nil
+}
+
+// checkInstanceQuota verifies a new instance doesn't exceed the maximum number
+// of instances of any rule matching the organization and space. Existing
+// instances are counted using the labels of the clusters.
+func (b Broker) checkInstanceQuota(client atlas.Client, orgGUID string, spaceGUID string) error {
+	var clusters []atlas.Cluster
+
+	for _, rule := range b.quotas {
+		if !rule.matches(orgGUID, spaceGUID) || rule.MaxInstances == 0 {
+			continue
+		}
+
+		// Clusters are only listed once, and only if a limit applies.
+		if clusters == nil {
+			var err error
+			clusters, err = client.ListClusters()
+			if err != nil {
+				return atlasToAPIError(err)
+			}
+		}
+
+		count := 0
+		for i := range clusters {
+			metadata := InstanceMetadata(&clusters[i])
+			if metadata.OrgGUID == orgGUID && (rule.SpaceGUID == "" || metadata.SpaceGUID == spaceGUID) {
+				count++
+			}
+		}
+
+		if count >= rule.MaxInstances {
+			err := fmt.Errorf(`%s has reached its instance limit of %d`, rule.scope(orgGUID), rule.MaxInstances)
+			return apiresponses.NewFailureResponse(err, http.StatusForbidden, "quota-exceeded")
+		}
+	}
+
+	return nil
+}
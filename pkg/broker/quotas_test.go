@@ -0,0 +1,122 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func assertQuotaExceeded(t *testing.T, err error, message string) {
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response but got %v", err) {
+		assert.Equal(t, http.StatusForbidden, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "quota-exceeded", failure.LoggerAction())
+		assert.EqualError(t, err, message)
+	}
+}
+
+func TestProvisionMaxInstances(t *testing.T) {
+	broker, client, ctx := setupTest(WithQuotas(QuotaRule{OrgGUID: "org", MaxInstances: 2}))
+
+	for _, instanceID := range []string{"instance-1", "instance-2"} {
+		_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+			PlanID:           testPlanID,
+			ServiceID:        testServiceID,
+			OrganizationGUID: "org",
+		}, true)
+		assert.NoError(t, err)
+	}
+
+	_, err := broker.Provision(ctx, "instance-3", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+	}, true)
+
+	assertQuotaExceeded(t, err, `organization "org" has reached its instance limit of 2`)
+	assert.Nil(t, client.Clusters["instance-3"], "Expected no cluster to be created")
+
+	_, err = broker.Provision(ctx, "instance-4", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "other-org",
+	}, true)
+	assert.NoError(t, err, "Expected other organizations to be unlimited")
+}
+
+func TestProvisionMaxInstancesPerSpace(t *testing.T) {
+	broker, _, ctx := setupTest(WithQuotas(QuotaRule{OrgGUID: AllOrgs, SpaceGUID: "space", MaxInstances: 1}))
+
+	_, err := broker.Provision(ctx, "instance-1", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+		SpaceGUID:        "space",
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Provision(ctx, "instance-2", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+		SpaceGUID:        "space",
+	}, true)
+	assertQuotaExceeded(t, err, `space "space" has reached its instance limit of 1`)
+
+	_, err = broker.Provision(ctx, "instance-3", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+		SpaceGUID:        "other-space",
+	}, true)
+	assert.NoError(t, err, "Expected other spaces to be unlimited")
+}
+
+func TestProvisionAllowedPlans(t *testing.T) {
+	broker, _, ctx := setupTest(WithQuotas(QuotaRule{OrgGUID: AllOrgs, AllowedPlans: []string{"M20"}}))
+
+	_, err := broker.Provision(ctx, "instance", brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+	}, true)
+
+	assertQuotaExceeded(t, err, `plan "M10" is not allowed for organization "org", allowed plans are "M20"`)
+}
+
+func TestUpdateAllowedPlans(t *testing.T) {
+	broker, client, ctx := setupTest(WithQuotas(QuotaRule{OrgGUID: "org", AllowedPlans: []string{"M10"}}))
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:           testPlanID,
+		ServiceID:        testServiceID,
+		OrganizationGUID: "org",
+	}, true)
+	assert.NoError(t, err)
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		PlanID:    "aosb-cluster-plan-aws-m20",
+		ServiceID: testServiceID,
+	}, true)
+
+	assertQuotaExceeded(t, err, `plan "M20" is not allowed for organization "org", allowed plans are "M10"`)
+	assert.Equal(t, "M10", client.Clusters[instanceID].ProviderSettings.InstanceSizeName, "Expected the cluster to be left untouched")
+
+	_, err = broker.Update(ctx, instanceID, brokerapi.UpdateDetails{
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"cluster": {"diskSizeGB": 20}}`),
+	}, true)
+	assert.NoError(t, err, "Expected updates without plan changes to be allowed")
+}
+
+func TestQuotaRuleInvalid(t *testing.T) {
+	_, err := New(nil, WithQuotas(QuotaRule{MaxInstances: 1}))
+	assert.Error(t, err, "Expected rules without an organization to be rejected")
+
+	_, err = New(nil, WithQuotas(QuotaRule{OrgGUID: AllOrgs, MaxInstances: -1}))
+	assert.Error(t, err, "Expected negative limits to be rejected")
+}
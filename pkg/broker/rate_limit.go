@@ -0,0 +1,134 @@
+package broker
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// DefaultAdaptivePollingInterval is how far apart the broker spaces out Atlas
+// requests it can delay while the rate limit budget of a project is low.
+const DefaultAdaptivePollingInterval = 30 * time.Second
+
+// rateLimits tracks the rate limit budget Atlas reports for each project.
+// With adaptive polling enabled, polls and background work are spaced out
+// while the remaining budget is below the threshold.
+type rateLimits struct {
+	remaining *metrics.GaugeVec
+
+	// threshold is the remaining budget below which polling slows down,
+	// zero disables adaptive polling.
+	threshold int
+	interval  time.Duration
+
+	mu        sync.Mutex
+	throttled map[string]bool
+	polls     map[string]cachedPoll
+}
+
+// cachedPoll is the result of a LastOperation poll which can be repeated
+// while the budget is low.
+type cachedPoll struct {
+	at       time.Time
+	response brokerapi.LastOperation
+}
+
+func newRateLimits() *rateLimits {
+	return &rateLimits{
+		remaining: metrics.NewGaugeVec("aosb_atlas_rate_limit_remaining", "Requests left in the current Atlas rate limit window by project.", "group_id"),
+		throttled: map[string]bool{},
+		polls:     map[string]cachedPoll{},
+	}
+}
+
+// ObserveRateLimit records the rate limit budget of a project reported by
+// Atlas. Pass it to atlas.WithRateLimitObserver for the clients the broker
+// uses.
+func (b Broker) ObserveRateLimit(groupID string, limit atlas.RateLimit) {
+	r := b.rateLimits
+	r.remaining.Set(float64(limit.Remaining), groupID)
+
+	if r.threshold == 0 {
+		return
+	}
+
+	throttled := limit.Remaining < r.threshold
+
+	r.mu.Lock()
+	changed := r.throttled[groupID] != throttled
+	r.throttled[groupID] = throttled
+	r.mu.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if throttled {
+		b.logger.Warnw("Atlas rate limit budget is low, slowing down polling", "group_id", groupID, "remaining", limit.Remaining, "limit", limit.Limit, "threshold", r.threshold, "interval", r.interval)
+	} else {
+		b.logger.Infow("Atlas rate limit budget recovered, polling at the normal pace", "group_id", groupID, "remaining", limit.Remaining, "limit", limit.Limit)
+	}
+}
+
+// isThrottled returns whether polling of a project is slowed down.
+func (r *rateLimits) isThrottled(groupID string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.throttled[groupID]
+}
+
+// cachedPoll returns the previous result of polling an instance if polling is
+// slowed down and the result is recent enough to be repeated.
+func (r *rateLimits) cachedPoll(groupID string, instanceID string, now time.Time) (brokerapi.LastOperation, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	poll, ok := r.polls[groupID+"/"+instanceID]
+	if !ok || !r.throttled[groupID] || now.Sub(poll.at) >= r.interval {
+		return brokerapi.LastOperation{}, false
+	}
+
+	return poll.response, true
+}
+
+// recordPoll remembers the result of polling an instance. Only operations
+// which are still in progress are remembered, others are polled again.
+func (r *rateLimits) recordPoll(groupID string, instanceID string, now time.Time, response brokerapi.LastOperation) {
+	if r.threshold == 0 {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := groupID + "/" + instanceID
+	if response.State != brokerapi.InProgress {
+		delete(r.polls, key)
+		return
+	}
+
+	r.polls[key] = cachedPoll{at: now, response: response}
+}
+
+// pace delays background work on a project for the adaptive polling interval
+// if its budget is low.
+func (b Broker) pace(groupID string) {
+	if !b.rateLimits.isThrottled(groupID) {
+		return
+	}
+
+	b.logger.Infow("Delaying background work, the Atlas rate limit budget is low", "group_id", groupID, "interval", b.rateLimits.interval)
+	b.clock.Sleep(b.rateLimits.interval)
+}
+
+// groupIDFromContext returns the Atlas project of the caller, or an empty
+// string if there is none.
+func groupIDFromContext(ctx context.Context) string {
+	groupID, _ := ctx.Value(ContextKeyAtlasGroupID).(string)
+	return groupID
+}
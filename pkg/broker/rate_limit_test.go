@@ -0,0 +1,111 @@
+package broker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestWithAdaptivePolling(t *testing.T) {
+	broker, err := New(zap.NewNop().Sugar(), WithAdaptivePolling(10, time.Minute))
+	if assert.NoError(t, err) {
+		assert.Equal(t, 10, broker.rateLimits.threshold)
+		assert.Equal(t, time.Minute, broker.rateLimits.interval)
+	}
+
+	_, err = New(zap.NewNop().Sugar(), WithAdaptivePolling(0, time.Minute))
+	assert.Error(t, err)
+
+	_, err = New(zap.NewNop().Sugar(), WithAdaptivePolling(10, 0))
+	assert.Error(t, err)
+}
+
+func TestObserveRateLimit(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	broker, _, _ := setupTest(WithAdaptivePolling(10, time.Minute), WithLogger(zap.New(core).Sugar()))
+
+	broker.ObserveRateLimit("group", atlas.RateLimit{Limit: 100, Remaining: 50})
+	assert.Equal(t, float64(50), broker.rateLimits.remaining.Value("group"))
+	assert.False(t, broker.rateLimits.isThrottled("group"))
+	assert.Equal(t, 0, logs.Len())
+
+	// Only changes are logged.
+	broker.ObserveRateLimit("group", atlas.RateLimit{Limit: 100, Remaining: 9})
+	broker.ObserveRateLimit("group", atlas.RateLimit{Limit: 100, Remaining: 8})
+	assert.Equal(t, float64(8), broker.rateLimits.remaining.Value("group"))
+	assert.True(t, broker.rateLimits.isThrottled("group"))
+	assert.False(t, broker.rateLimits.isThrottled("other"))
+	assert.Equal(t, 1, logs.FilterMessageSnippet("slowing down").Len())
+
+	broker.ObserveRateLimit("group", atlas.RateLimit{Limit: 100, Remaining: 100})
+	assert.False(t, broker.rateLimits.isThrottled("group"))
+	assert.Equal(t, 1, logs.FilterMessageSnippet("recovered").Len())
+}
+
+func TestObserveRateLimitWithoutAdaptivePolling(t *testing.T) {
+	broker, _, _ := setupTest()
+
+	broker.ObserveRateLimit("group", atlas.RateLimit{Limit: 100, Remaining: 0})
+	assert.Equal(t, float64(0), broker.rateLimits.remaining.Value("group"))
+	assert.False(t, broker.rateLimits.isThrottled("group"))
+}
+
+func TestLastOperationAdaptivePolling(t *testing.T) {
+	broker, client, ctx := setupTest(WithAdaptivePolling(10, time.Minute))
+	ctx = context.WithValue(ctx, ContextKeyAtlasGroupID, "group")
+
+	instanceID := "instance"
+	_, err := broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	poll := func() brokerapi.LastOperationState {
+		resp, err := broker.LastOperation(ctx, instanceID, brokerapi.PollDetails{OperationData: OperationProvision})
+		assert.NoError(t, err)
+		return resp.State
+	}
+
+	assert.Equal(t, brokerapi.InProgress, poll())
+
+	// Polls are answered as usual while the budget is sufficient.
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	broker.ObserveRateLimit("group", atlas.RateLimit{Remaining: 10})
+	assert.Equal(t, brokerapi.Succeeded, poll())
+
+	client.SetClusterState(instanceID, atlas.ClusterStateCreating)
+	assert.Equal(t, brokerapi.InProgress, poll())
+
+	// Once it's low the previous poll is repeated for the interval.
+	client.SetClusterState(instanceID, atlas.ClusterStateIdle)
+	broker.ObserveRateLimit("group", atlas.RateLimit{Remaining: 9})
+	assert.Equal(t, brokerapi.InProgress, poll())
+
+	testClock(broker).Advance(time.Minute)
+	assert.Equal(t, brokerapi.Succeeded, poll())
+
+	// Finished operations are never repeated.
+	client.SetClusterState(instanceID, atlas.ClusterStateCreating)
+	assert.Equal(t, brokerapi.InProgress, poll())
+}
+
+func TestPace(t *testing.T) {
+	broker, _, _ := setupTest(WithAdaptivePolling(10, time.Minute))
+
+	broker.pace("group")
+	assert.Empty(t, testClock(broker).Slept())
+
+	broker.ObserveRateLimit("group", atlas.RateLimit{Remaining: 1})
+	broker.pace("group")
+	broker.pace("other")
+	assert.Equal(t, []time.Duration{time.Minute}, testClock(broker).Slept())
+}
@@ -0,0 +1,256 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// The ways Reconcile classifies resources in Atlas.
+const (
+	// ResourceManaged resources were created by the broker and belong to a
+	// known instance or binding.
+	ResourceManaged = "managed"
+
+	// ResourceOrphaned resources were created by the broker but the instance
+	// or binding they belong to no longer exists.
+	ResourceOrphaned = "orphaned"
+
+	// ResourceForeign resources weren't created by the broker.
+	ResourceForeign = "foreign"
+)
+
+// Older versions of the broker didn't label resources. Their clusters are
+// named after a truncated instance ID and their users after the binding ID,
+// both of which are UUIDs.
+var (
+	legacyClusterNamePattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}$`)
+	legacyUsernamePattern    = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// KnownResources lists the instances and bindings the platform knows about.
+// Without them Reconcile can only detect users whose cluster is gone.
+type KnownResources struct {
+	InstanceIDs []string `json:"instanceIds"`
+	BindingIDs  []string `json:"bindingIds"`
+}
+
+// ReadKnownResourcesFile reads a JSON file of known instance and binding IDs.
+func ReadKnownResourcesFile(path string) (*KnownResources, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	known := &KnownResources{}
+	if err := json.Unmarshal(data, known); err != nil {
+		return nil, err
+	}
+
+	return known, nil
+}
+
+// ReconcileOptions control the behaviour of Reconcile.
+type ReconcileOptions struct {
+	// Known are the resources known to the platform, optional.
+	Known *KnownResources
+
+	// Fix deletes orphaned users carrying the labels of the broker. Clusters
+	// are never deleted.
+	Fix bool
+
+	// FixLegacyUsers also deletes orphaned unlabeled users named after a
+	// UUID if Fix is set. Any user could be named like that, so they're
+	// only deleted on request.
+	FixLegacyUsers bool
+}
+
+// ReconcileReport is the machine-readable result of Reconcile.
+type ReconcileReport struct {
+	Clusters []ClusterReport `json:"clusters"`
+	Users    []UserReport    `json:"users"`
+
+	// LegacyUsers are the unlabeled users which are only recognized by
+	// their UUID username.
+	LegacyUsers []UserReport `json:"legacyUsers"`
+}
+
+// ClusterReport describes a single cluster in a ReconcileReport.
+type ClusterReport struct {
+	Name       string `json:"name"`
+	InstanceID string `json:"instanceId,omitempty"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// UserReport describes a single database user in a ReconcileReport.
+type UserReport struct {
+	Username   string `json:"username"`
+	InstanceID string `json:"instanceId,omitempty"`
+	BindingID  string `json:"bindingId,omitempty"`
+	Status     string `json:"status"`
+	Reason     string `json:"reason,omitempty"`
+	Deleted    bool   `json:"deleted,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Reconcile lists all clusters and database users in Atlas and classifies
+// them as managed, orphaned or foreign based on their labels and naming.
+// Orphaned users are deleted if opts.Fix is set, unlabeled ones only with
+// opts.FixLegacyUsers as well.
+func (b Broker) Reconcile(ctx context.Context, opts ReconcileOptions) (*ReconcileReport, error) {
+	b.logger.Infow("Reconciling Atlas resources", "fix", opts.Fix, "fix_legacy_users", opts.FixLegacyUsers)
+
+	client, err := atlasClientFromContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	clusters, err := client.ListClusters()
+	if err != nil {
+		return nil, err
+	}
+
+	users, err := client.ListUsers()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ReconcileReport{
+		Clusters:    []ClusterReport{},
+		Users:       []UserReport{},
+		LegacyUsers: []UserReport{},
+	}
+
+	clusterNames := map[string]bool{}
+	for i := range clusters {
+		clusterNames[clusters[i].Name] = true
+
+		// Clusters claimed from a warm pool keep their pool name.
+		if instanceID := labelValue(clusters[i].Labels, LabelInstanceID); instanceID != "" {
+			clusterNames[b.namer.ClusterName(instanceID)] = true
+		}
+
+		report.Clusters = append(report.Clusters, b.reconcileCluster(&clusters[i], opts.Known))
+	}
+
+	for _, user := range users {
+		userReport, legacy := b.reconcileUser(user, clusterNames, opts.Known)
+
+		if opts.Fix && (!legacy || opts.FixLegacyUsers) && userReport.Status == ResourceOrphaned {
+			b.pace(groupIDFromContext(ctx))
+
+			if err := client.DeleteUser(user.AuthDatabase(), user.Username); err != nil {
+				b.logger.Errorw("Failed to delete orphaned user", "error", err, "username", user.Username)
+				userReport.Error = err.Error()
+			} else {
+				b.logger.Infow("Deleted orphaned user", "username", user.Username)
+				userReport.Deleted = true
+			}
+		}
+
+		if legacy {
+			report.LegacyUsers = append(report.LegacyUsers, userReport)
+		} else {
+			report.Users = append(report.Users, userReport)
+		}
+	}
+
+	return report, nil
+}
+
+// reconcileCluster classifies a single cluster.
+func (b Broker) reconcileCluster(cluster *atlas.Cluster, known *KnownResources) ClusterReport {
+	report := ClusterReport{Name: cluster.Name}
+
+	metadata := InstanceMetadata(cluster)
+	switch {
+	case metadata.Labeled():
+		report.InstanceID = metadata.InstanceID
+		report.Status = ResourceManaged
+
+		if known != nil && !containsString(known.InstanceIDs, metadata.InstanceID) {
+			report.Status = ResourceOrphaned
+			report.Reason = "instance is not known to the platform"
+		}
+	case labelValue(cluster.Labels, LabelPoolPlan) != "":
+		report.Status = ResourceManaged
+		report.Reason = "unclaimed warm pool cluster"
+	case legacyClusterNamePattern.MatchString(cluster.Name):
+		report.Status = ResourceManaged
+		report.Reason = "unlabeled cluster created by an older broker version"
+
+		if known != nil {
+			report.Status = ResourceOrphaned
+			report.Reason = "instance is not known to the platform"
+
+			for _, instanceID := range known.InstanceIDs {
+				if b.namer.Matches(cluster, instanceID) {
+					report.InstanceID = instanceID
+					report.Status = ResourceManaged
+					report.Reason = ""
+					break
+				}
+			}
+		}
+	default:
+		report.Status = ResourceForeign
+	}
+
+	return report
+}
+
+// reconcileUser classifies a single database user. It also returns whether
+// the user was only recognized by its legacy username.
+func (b Broker) reconcileUser(user atlas.User, clusterNames map[string]bool, known *KnownResources) (UserReport, bool) {
+	report := UserReport{Username: user.Username}
+	legacy := false
+
+	bindingID := labelValue(user.Labels, LabelBindingID)
+	switch {
+	case bindingID != "":
+		report.InstanceID = labelValue(user.Labels, LabelInstanceID)
+		report.BindingID = bindingID
+		report.Status = ResourceManaged
+	case strings.HasPrefix(user.Username, monitoringUsernamePrefix) && labelValue(user.Labels, LabelInstanceID) != "":
+		report.InstanceID = labelValue(user.Labels, LabelInstanceID)
+		report.Status = ResourceManaged
+		report.Reason = "monitoring user of the instance"
+	case legacyUsernamePattern.MatchString(user.Username):
+		report.BindingID = user.Username
+		report.Status = ResourceManaged
+		report.Reason = "unlabeled user created by an older broker version"
+		legacy = true
+	default:
+		report.Status = ResourceForeign
+		return report, false
+	}
+
+	if report.InstanceID != "" && !clusterNames[b.namer.ClusterName(report.InstanceID)] {
+		report.Status = ResourceOrphaned
+		report.Reason = "cluster no longer exists"
+	} else if known != nil && report.BindingID != "" && !containsString(known.BindingIDs, report.BindingID) {
+		report.Status = ResourceOrphaned
+		report.Reason = "binding is not known to the platform"
+	} else if known != nil && report.BindingID == "" && !containsString(known.InstanceIDs, report.InstanceID) {
+		report.Status = ResourceOrphaned
+		report.Reason = "instance is not known to the platform"
+	}
+
+	return report, legacy
+}
+
+// containsString returns whether a list contains a value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,167 @@
+package broker
+
+import (
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	reconcileInstanceID = "6b1f7a3e-2c4d-4e5f-8a9b-0c1d2e3f4a5b"
+	reconcileBindingID  = "9d8c7b6a-5f4e-4d3c-2b1a-0f9e8d7c6b5a"
+)
+
+func TestReconcileClassifiesClusters(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	client.Clusters["managed"] = &atlas.Cluster{
+		Name:   "managed",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "instance"}},
+	}
+	client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"] = &atlas.Cluster{Name: "6b1f7a3e-2c4d-4e5f-8a9b"}
+	client.Clusters["foreign"] = &atlas.Cluster{Name: "foreign"}
+
+	report, err := broker.Reconcile(ctx, ReconcileOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ClusterReport{
+		{Name: "6b1f7a3e-2c4d-4e5f-8a9b", Status: ResourceManaged, Reason: "unlabeled cluster created by an older broker version"},
+		{Name: "foreign", Status: ResourceForeign},
+		{Name: "managed", InstanceID: "instance", Status: ResourceManaged},
+	}, report.Clusters)
+}
+
+func TestReconcileWithKnownInstances(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	client.Clusters["known"] = &atlas.Cluster{
+		Name:   "known",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "known"}},
+	}
+	client.Clusters["unknown"] = &atlas.Cluster{
+		Name:   "unknown",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "unknown"}},
+	}
+	client.Clusters["6b1f7a3e-2c4d-4e5f-8a9b"] = &atlas.Cluster{Name: "6b1f7a3e-2c4d-4e5f-8a9b"}
+
+	report, err := broker.Reconcile(ctx, ReconcileOptions{
+		Known: &KnownResources{InstanceIDs: []string{"known", reconcileInstanceID}},
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []ClusterReport{
+		{Name: "6b1f7a3e-2c4d-4e5f-8a9b", InstanceID: reconcileInstanceID, Status: ResourceManaged},
+		{Name: "known", InstanceID: "known", Status: ResourceManaged},
+		{Name: "unknown", InstanceID: "unknown", Status: ResourceOrphaned, Reason: "instance is not known to the platform"},
+	}, report.Clusters)
+}
+
+func TestReconcileClassifiesUsers(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	client.Clusters["instance"] = &atlas.Cluster{Name: "instance"}
+	client.Users["managed"] = &atlas.User{
+		Username: "managed",
+		Labels: []atlas.Label{
+			{Key: LabelInstanceID, Value: "instance"},
+			{Key: LabelBindingID, Value: "binding"},
+		},
+	}
+	client.Users["orphaned"] = &atlas.User{
+		Username: "orphaned",
+		Labels: []atlas.Label{
+			{Key: LabelInstanceID, Value: "deleted"},
+			{Key: LabelBindingID, Value: "other-binding"},
+		},
+	}
+	client.Users[reconcileBindingID] = &atlas.User{Username: reconcileBindingID}
+	client.Users["admin"] = &atlas.User{Username: "admin"}
+	client.Users[monitoringUsername("instance")] = &atlas.User{
+		Username: monitoringUsername("instance"),
+		Labels:   []atlas.Label{{Key: LabelInstanceID, Value: "instance"}},
+	}
+
+	report, err := broker.Reconcile(ctx, ReconcileOptions{})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []UserReport{
+		{Username: "admin", Status: ResourceForeign},
+		{Username: monitoringUsername("instance"), InstanceID: "instance", Status: ResourceManaged, Reason: "monitoring user of the instance"},
+		{Username: "managed", InstanceID: "instance", BindingID: "binding", Status: ResourceManaged},
+		{Username: "orphaned", InstanceID: "deleted", BindingID: "other-binding", Status: ResourceOrphaned, Reason: "cluster no longer exists"},
+	}, report.Users)
+	assert.Equal(t, []UserReport{
+		{Username: reconcileBindingID, BindingID: reconcileBindingID, Status: ResourceManaged, Reason: "unlabeled user created by an older broker version"},
+	}, report.LegacyUsers)
+
+	assert.Len(t, client.Users, 5, "Expected users to be left untouched without fix")
+}
+
+func TestReconcileFixDeletesOrphanedUsers(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	client.Users[reconcileBindingID] = &atlas.User{Username: reconcileBindingID}
+	client.Users["known"] = &atlas.User{
+		Username: "known",
+		Labels:   []atlas.Label{{Key: LabelBindingID, Value: "known"}},
+	}
+	client.Users["orphaned"] = &atlas.User{
+		Username: "orphaned",
+		Labels:   []atlas.Label{{Key: LabelBindingID, Value: "orphaned"}},
+	}
+	client.Users["admin"] = &atlas.User{Username: "admin"}
+
+	opts := ReconcileOptions{
+		Known: &KnownResources{BindingIDs: []string{"known"}},
+		Fix:   true,
+	}
+	report, err := broker.Reconcile(ctx, opts)
+
+	assert.NoError(t, err)
+	assert.Contains(t, report.Users, UserReport{
+		Username:  "orphaned",
+		BindingID: "orphaned",
+		Status:    ResourceOrphaned,
+		Reason:    "binding is not known to the platform",
+		Deleted:   true,
+	})
+
+	assert.Nil(t, client.Users["orphaned"], "Expected orphaned user to be deleted")
+	assert.NotNil(t, client.Users["known"], "Expected known user to be kept")
+	assert.NotNil(t, client.Users["admin"], "Expected foreign user to be kept")
+
+	// Unlabeled users named after a UUID are only reported, unless legacy
+	// users are fixed as well.
+	assert.Equal(t, []UserReport{{
+		Username:  reconcileBindingID,
+		BindingID: reconcileBindingID,
+		Status:    ResourceOrphaned,
+		Reason:    "binding is not known to the platform",
+	}}, report.LegacyUsers)
+	assert.NotNil(t, client.Users[reconcileBindingID], "Expected legacy user to be kept")
+
+	opts.FixLegacyUsers = true
+	report, err = broker.Reconcile(ctx, opts)
+
+	assert.NoError(t, err)
+	if assert.Len(t, report.LegacyUsers, 1) {
+		assert.True(t, report.LegacyUsers[0].Deleted)
+	}
+	assert.Nil(t, client.Users[reconcileBindingID], "Expected legacy user to be deleted")
+}
+
+func TestReconcileNeverDeletesClusters(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	client.Clusters["orphaned"] = &atlas.Cluster{
+		Name:   "orphaned",
+		Labels: []atlas.Label{{Key: LabelInstanceID, Value: "orphaned"}},
+	}
+
+	report, err := broker.Reconcile(ctx, ReconcileOptions{Known: &KnownResources{}, Fix: true})
+
+	assert.NoError(t, err)
+	assert.Equal(t, ResourceOrphaned, report.Clusters[0].Status)
+	assert.NotNil(t, client.Clusters["orphaned"], "Expected cluster to be kept")
+}
@@ -0,0 +1,96 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// RolePolicy restricts the databases binding users can be granted roles on.
+// It applies to the roles passed in the bind params as well as the default
+// user roles. A broker without a policy allows every database.
+type RolePolicy struct {
+	// DeniedDatabases can't be used by any role, for example "admin",
+	// "local" and "config".
+	DeniedDatabases []string `json:"deniedDatabases,omitempty"`
+
+	// AllowedDatabases are the only databases roles may use. All databases
+	// which aren't denied are allowed if empty.
+	AllowedDatabases []string `json:"allowedDatabases,omitempty"`
+
+	// AllowedRoles are exempt from the database restrictions. Roles such as
+	// readWriteAnyDatabase only exist on "admin" and have to be listed here
+	// to be granted while "admin" is denied.
+	AllowedRoles []atlas.Role `json:"allowedRoles,omitempty"`
+
+	// PrivilegedKeys are the Atlas public keys of callers exempt from the
+	// policy.
+	PrivilegedKeys []string `json:"privilegedKeys,omitempty"`
+}
+
+// validate checks the policy for obvious mistakes.
+func (p RolePolicy) validate() error {
+	for _, entries := range [][]string{p.DeniedDatabases, p.AllowedDatabases, p.PrivilegedKeys} {
+		for _, entry := range entries {
+			if entry == "" {
+				return errors.New("role policy entries must not be empty")
+			}
+		}
+	}
+
+	for _, role := range p.AllowedRoles {
+		if role.Name == "" || role.DatabaseName == "" {
+			return fmt.Errorf("allowed role %+v must have both a name and a database", role)
+		}
+	}
+
+	return nil
+}
+
+// isPrivileged returns whether a caller is exempt from the policy.
+func (p RolePolicy) isPrivileged(publicKey string) bool {
+	return publicKey != "" && containsString(p.PrivilegedKeys, publicKey)
+}
+
+// allowsRole returns whether a role is explicitly exempt from the database
+// restrictions. Collection roles are covered by an exemption of their
+// database.
+func (p RolePolicy) allowsRole(role atlas.Role) bool {
+	for _, allowed := range p.AllowedRoles {
+		if allowed.Name == role.Name && allowed.DatabaseName == role.DatabaseName && (allowed.CollectionName == "" || allowed.CollectionName == role.CollectionName) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// check returns an error naming the first role which uses a forbidden
+// database.
+func (p RolePolicy) check(roles []atlas.Role) error {
+	for _, role := range roles {
+		if p.allowsRole(role) {
+			continue
+		}
+
+		denied := containsString(p.DeniedDatabases, role.DatabaseName)
+		notAllowed := len(p.AllowedDatabases) > 0 && !containsString(p.AllowedDatabases, role.DatabaseName)
+		if denied || notAllowed {
+			return fmt.Errorf(`role "%s" on database "%s" is not allowed by the broker's role policy`, role.Name, role.DatabaseName)
+		}
+	}
+
+	return nil
+}
+
+// rolePolicyFor returns the role policy applying to the caller of a request,
+// or nil if the caller isn't restricted.
+func (b Broker) rolePolicyFor(ctx context.Context) *RolePolicy {
+	if b.rolePolicy == nil || b.rolePolicy.isPrivileged(publicKeyFromContext(ctx)) {
+		return nil
+	}
+
+	return b.rolePolicy
+}
@@ -0,0 +1,99 @@
+package broker
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+var testRolePolicy = RolePolicy{
+	DeniedDatabases: []string{"admin", "local", "config"},
+	AllowedRoles:    []atlas.Role{{Name: "readWriteAnyDatabase", DatabaseName: "admin"}},
+	PrivilegedKeys:  []string{"privileged"},
+}
+
+func TestRolePolicy(t *testing.T) {
+	broker, client, ctx := setupTest(WithRolePolicy(testRolePolicy))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	bind := func(ctx context.Context, bindingID string, params string) error {
+		_, err := broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+			PlanID:        testPlanID,
+			ServiceID:     testServiceID,
+			RawParameters: []byte(params),
+		}, true)
+		return err
+	}
+
+	// The default role is explicitly allowed.
+	assert.NoError(t, bind(ctx, "default", ""))
+	assert.NoError(t, bind(ctx, "app", `{"user": {"roles": [{"roleName": "readWrite", "databaseName": "app"}]}}`))
+
+	err := bind(ctx, "local", `{"user": {"roles": [{"roleName": "readWrite", "databaseName": "app"}, {"roleName": "read", "databaseName": "local"}]}}`)
+	failure, ok := err.(*apiresponses.FailureResponse)
+	if assert.True(t, ok, "Expected a failure response") {
+		assert.Equal(t, http.StatusForbidden, failure.ValidatedStatusCode(nil))
+		assert.Equal(t, "role-database-forbidden", failure.LoggerAction())
+		assert.Contains(t, err.Error(), `database "local"`)
+	}
+	assert.Nil(t, client.Users["local"], "Expected no user to be created")
+
+	// Only the exact allowed role is exempt.
+	err = bind(ctx, "admin", `{"user": {"roles": [{"roleName": "root", "databaseName": "admin"}]}}`)
+	assert.Error(t, err)
+	assert.Nil(t, client.Users["admin"], "Expected no user to be created")
+
+	// Privileged callers aren't restricted.
+	privilegedCtx := context.WithValue(ctx, ContextKeyAtlasPublicKey, "privileged")
+	assert.NoError(t, bind(privilegedCtx, "admin", `{"user": {"roles": [{"roleName": "root", "databaseName": "admin"}]}}`))
+}
+
+func TestRolePolicyAllowedDatabases(t *testing.T) {
+	policy := RolePolicy{AllowedDatabases: []string{"app"}}
+	readApp := atlas.Role{Name: "read", DatabaseName: "app"}
+
+	assert.NoError(t, policy.check([]atlas.Role{readApp}))
+	assert.EqualError(t, policy.check([]atlas.Role{readApp, {Name: "read", DatabaseName: "other"}}), `role "read" on database "other" is not allowed by the broker's role policy`)
+}
+
+func TestRolePolicyDefaultRoles(t *testing.T) {
+	policy := RolePolicy{DeniedDatabases: []string{"admin"}}
+
+	// The built-in default role uses "admin".
+	_, err := New(zap.NewNop().Sugar(), WithRolePolicy(policy))
+	assert.EqualError(t, err, `default user roles: role "readWriteAnyDatabase" on database "admin" is not allowed by the broker's role policy`)
+
+	// The order of the options doesn't matter.
+	readApp := atlas.Role{Name: "read", DatabaseName: "app"}
+	_, err = New(zap.NewNop().Sugar(), WithRolePolicy(policy), WithDefaultUserRoles(readApp))
+	assert.NoError(t, err)
+	_, err = New(zap.NewNop().Sugar(), WithDefaultUserRoles(readApp), WithRolePolicy(policy))
+	assert.NoError(t, err)
+
+	_, err = New(zap.NewNop().Sugar(), WithRolePolicy(testRolePolicy))
+	assert.NoError(t, err)
+}
+
+func TestWithRolePolicyInvalid(t *testing.T) {
+	invalidPolicies := []RolePolicy{
+		{DeniedDatabases: []string{""}},
+		{PrivilegedKeys: []string{""}},
+		{AllowedRoles: []atlas.Role{{Name: "read"}}},
+	}
+
+	for _, policy := range invalidPolicies {
+		_, err := New(zap.NewNop().Sugar(), WithRolePolicy(policy))
+		assert.Error(t, err, "Expected policy %+v to be rejected", policy)
+	}
+}
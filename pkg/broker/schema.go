@@ -0,0 +1,235 @@
+package broker
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+)
+
+// schemaDraft is the JSON Schema version required by the Open Service Broker
+// API.
+const schemaDraft = "http://json-schema.org/draft-04/schema#"
+
+// planControlledClusterFields are the cluster fields dictated by the broker
+// and the plan, so they aren't part of the parameter schemas.
+var planControlledClusterFields = []string{
+	"name",
+	"providerSettings.providerName",
+	"providerSettings.instanceSizeName",
+	"providerSettings.backingProviderName",
+}
+
+// brokerControlledUserFields are the user fields generated by the broker.
+var brokerControlledUserFields = []string{"username", "password", "scopes"}
+
+// planSchemas returns the parameter schemas of the plans of a provider. They
+// are derived from the structs the parameters are decoded into, so they stay
+// in sync with what the broker accepts.
+func planSchemas(provider *atlas.Provider) *brokerapi.ServiceSchemas {
+	clusterExclude := fieldSet(planControlledClusterFields)
+	if provider.Name != providerNameAWS {
+		for _, field := range awsOnlyProviderSettings {
+			clusterExclude["providerSettings."+field] = true
+		}
+	}
+
+	cluster := schemaFor(reflect.TypeOf(atlas.Cluster{}), clusterExclude)
+	cluster["description"] = "Settings of the Atlas cluster, the provider and instance size are given by the plan."
+
+	user := schemaFor(reflect.TypeOf(atlas.User{}), fieldSet(brokerControlledUserFields))
+	user["description"] = "Settings of the database user created for the binding."
+
+	connectionString := schemaFor(reflect.TypeOf(ConnectionStringParams{}), nil)
+	connectionString["description"] = "Controls the connection string returned in the credentials."
+
+	certificate := schemaFor(reflect.TypeOf(CertificateParams{}), nil)
+	certificate["description"] = "Controls the client certificate of users with x509Type MANAGED."
+
+	processArgs := schemaFor(reflect.TypeOf(atlas.ProcessArgs{}), nil)
+	processArgs["description"] = "Advanced configuration of the MongoDB processes of the cluster."
+
+	advancedConfiguration := schemaFor(reflect.TypeOf(atlas.ProcessArgs{}), nil)
+	advancedConfiguration["description"] = "Alias of processArgs named after the Atlas UI, only one of them can be passed."
+
+	ipAccessList := schemaFor(reflect.TypeOf([]atlas.AccessListEntry{}), nil)
+	ipAccessList["description"] = "Entries added to the IP access list of the project, each with either a cidrBlock or an ipAddress."
+
+	encryptionAtRest := schemaFor(reflect.TypeOf(EncryptionAtRestParams{}), nil)
+	encryptionAtRest["description"] = "Customer-managed keys configured on the project and used to encrypt the cluster."
+
+	bootstrap := schemaFor(reflect.TypeOf(Bootstrap{}), nil)
+	bootstrap["description"] = "Collections and indexes created once the cluster is ready."
+
+	return &brokerapi.ServiceSchemas{
+		Instance: brokerapi.ServiceInstanceSchema{
+			Create: brokerapi.Schema{
+				Parameters: parametersSchema(map[string]interface{}{
+					"cluster":               cluster,
+					"processArgs":           processArgs,
+					"advancedConfiguration": advancedConfiguration,
+					"ipAccessList":          ipAccessList,
+					"encryptionAtRest":      encryptionAtRest,
+					"bootstrap":             bootstrap,
+					"monitoringUser": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Creates a database user with only the clusterMonitor role for metrics exporters, served through the admin API.",
+					},
+				}),
+			},
+			Update: brokerapi.Schema{
+				Parameters: parametersSchema(map[string]interface{}{
+					"cluster":               cluster,
+					"processArgs":           processArgs,
+					"advancedConfiguration": advancedConfiguration,
+					"allowDowngrade": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Confirms that the update may move the cluster to a smaller instance size.",
+					},
+					"monitoringUser": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Creates or removes the monitoring user of the instance.",
+					},
+					"rotateMonitoringUser": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Replaces the monitoring user with one using a new password.",
+					},
+				}),
+			},
+		},
+		Binding: brokerapi.ServiceBindingSchema{
+			Create: brokerapi.Schema{
+				Parameters: parametersSchema(map[string]interface{}{
+					"user":             user,
+					"connectionString": connectionString,
+					"certificate":      certificate,
+					"credentialStyle": map[string]interface{}{
+						"type":        "string",
+						"enum":        []interface{}{CredentialStyleDefault, CredentialStyleServiceBinding},
+						"description": "Set to servicebinding to return flat string credentials following the Kubernetes Service Binding specification.",
+					},
+					"waitForUser": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Wait until Atlas has deployed the database user before returning the credentials.",
+					},
+					"database": map[string]interface{}{
+						"type":        "string",
+						"description": "Scopes the binding to a database, granting readWrite on it unless user.roles are passed.",
+					},
+					"rotate": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Replaces the password, or the certificate, of the existing user of the binding and returns new credentials.",
+					},
+					"rotationId": map[string]interface{}{
+						"type":        "string",
+						"description": "Identifies a rotation, so retries of it return the credentials of the first attempt instead of rotating again. Requires a credential store.",
+					},
+					"projectScoped": map[string]interface{}{
+						"type":        "boolean",
+						"description": "Lets the user authenticate against every cluster of the project instead of only the bound one.",
+					},
+				}),
+			},
+		},
+	}
+}
+
+// parametersSchema returns the top-level schema of a parameters object.
+func parametersSchema(properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"$schema":    schemaDraft,
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// schemaFor derives a JSON schema from the JSON encoding of a type. Fields are
+// documented by their "description" tag and left out if they are tagged with
+// `schema:"-"` or their path, such as "providerSettings.diskIOPS", is
+// excluded.
+func schemaFor(t reflect.Type, exclude map[string]bool) map[string]interface{} {
+	return typeSchema(t, "", exclude)
+}
+
+func typeSchema(t reflect.Type, path string, exclude map[string]bool) map[string]interface{} {
+	// Raw JSON is used for documents passed on to MongoDB as they are.
+	if t == reflect.TypeOf(json.RawMessage{}) {
+		return map[string]interface{}{"type": "object"}
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		return typeSchema(t.Elem(), path, exclude)
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "minimum": 0}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return map[string]interface{}{"type": "array", "items": typeSchema(t.Elem(), path, exclude)}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": typeSchema(t.Elem(), path, exclude)}
+	case reflect.Struct:
+		return structSchema(t, path, exclude)
+	}
+
+	// Interfaces accept any value.
+	return map[string]interface{}{}
+}
+
+func structSchema(t reflect.Type, path string, exclude map[string]bool) map[string]interface{} {
+	properties := map[string]interface{}{}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" || field.Tag.Get("schema") == "-" {
+			continue
+		}
+
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+
+		if exclude[fieldPath] {
+			continue
+		}
+
+		schema := typeSchema(field.Type, fieldPath, exclude)
+		if description := field.Tag.Get("description"); description != "" {
+			schema["description"] = description
+		}
+
+		properties[name] = schema
+	}
+
+	return map[string]interface{}{
+		"type":       "object",
+		"properties": properties,
+	}
+}
+
+// fieldSet turns a list of field paths into a set.
+func fieldSet(fields []string) map[string]bool {
+	set := map[string]bool{}
+	for _, field := range fields {
+		set[field] = true
+	}
+
+	return set
+}
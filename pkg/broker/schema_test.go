@@ -0,0 +1,128 @@
+package broker
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+)
+
+// schemaProperty returns the schema of a nested property, or nil if it
+// doesn't exist.
+func schemaProperty(schema map[string]interface{}, path ...string) map[string]interface{} {
+	for _, name := range path {
+		properties, _ := schema["properties"].(map[string]interface{})
+		schema, _ = properties[name].(map[string]interface{})
+		if schema == nil {
+			return nil
+		}
+	}
+
+	return schema
+}
+
+// planByID returns a plan of the catalog.
+func planByID(services []brokerapi.Service, planID string) *brokerapi.ServicePlan {
+	for _, service := range services {
+		for i := range service.Plans {
+			if service.Plans[i].ID == planID {
+				return &service.Plans[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+func TestPlanSchemas(t *testing.T) {
+	broker, _, ctx := setupTest()
+
+	services, err := broker.Services(ctx)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	for _, service := range services {
+		for _, plan := range service.Plans {
+			assert.NotNil(t, plan.Schemas, "Expected plan %s to have schemas", plan.ID)
+		}
+	}
+
+	aws := planByID(services, testPlanID)
+	if !assert.NotNil(t, aws) {
+		return
+	}
+
+	create := aws.Schemas.Instance.Create.Parameters
+	assert.Equal(t, schemaDraft, create["$schema"])
+
+	// The IP access list and encryption keys can only be passed when
+	// provisioning.
+	update := aws.Schemas.Instance.Update.Parameters
+	assert.Equal(t, schemaProperty(create, "cluster"), schemaProperty(update, "cluster"))
+	assert.Equal(t, schemaProperty(create, "processArgs"), schemaProperty(update, "processArgs"))
+	assert.NotNil(t, schemaProperty(update, "advancedConfiguration", "oplogSizeMB"))
+	assert.Equal(t, "array", schemaProperty(create, "ipAccessList")["type"])
+	assert.Nil(t, schemaProperty(update, "ipAccessList"))
+	assert.NotNil(t, schemaProperty(create, "encryptionAtRest", "kmsKeyID"))
+	assert.Nil(t, schemaProperty(update, "encryptionAtRest"))
+
+	assert.Equal(t, map[string]interface{}{"type": "number", "description": "Capacity of the data volume in GB."}, schemaProperty(create, "cluster", "diskSizeGB"))
+	assert.Equal(t, "integer", schemaProperty(create, "cluster", "replicationSpecs")["items"].(map[string]interface{})["properties"].(map[string]interface{})["numShards"].(map[string]interface{})["type"])
+	assert.NotNil(t, schemaProperty(create, "cluster", "providerSettings", "regionName"))
+	assert.NotNil(t, schemaProperty(create, "cluster", "providerSettings", "diskIOPS"))
+
+	// Fields dictated by the plan or set by Atlas aren't parameters.
+	assert.Nil(t, schemaProperty(create, "cluster", "name"))
+	assert.Nil(t, schemaProperty(create, "cluster", "stateName"))
+	assert.Nil(t, schemaProperty(create, "cluster", "providerSettings", "instanceSizeName"))
+	assert.Equal(t, "boolean", schemaProperty(create, "processArgs", "javascriptEnabled")["type"])
+
+	bind := aws.Schemas.Binding.Create.Parameters
+	assert.NotNil(t, schemaProperty(bind, "user", "roles"))
+	assert.Nil(t, schemaProperty(bind, "user", "password"))
+	assert.NotNil(t, schemaProperty(bind, "connectionString", "format"))
+	assert.Equal(t, map[string]interface{}{}, schemaProperty(bind, "connectionString", "options")["additionalProperties"])
+
+	// AWS-only settings aren't offered for other providers.
+	gcp := planByID(services, "aosb-cluster-plan-gcp-m10")
+	if assert.NotNil(t, gcp) {
+		create := gcp.Schemas.Instance.Create.Parameters
+		assert.NotNil(t, schemaProperty(create, "cluster", "providerSettings", "regionName"))
+		assert.Nil(t, schemaProperty(create, "cluster", "providerSettings", "diskIOPS"))
+	}
+
+	// The catalog has to serialize.
+	_, err = json.Marshal(services)
+	assert.NoError(t, err)
+}
+
+// TestSchemaForCoversAllFields makes sure every parameter field is described.
+func TestSchemaForCoversAllFields(t *testing.T) {
+	var check func(path string, schema map[string]interface{})
+	check = func(path string, schema map[string]interface{}) {
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, property := range properties {
+			property := property.(map[string]interface{})
+			assert.NotEmpty(t, property["description"], "Expected %s%s to have a description", path, name)
+			check(path+name+".", property)
+
+			if items, ok := property["items"].(map[string]interface{}); ok {
+				check(path+name+"[].", items)
+			}
+			if values, ok := property["additionalProperties"].(map[string]interface{}); ok {
+				check(path+name+"{}.", values)
+			}
+		}
+	}
+
+	check("cluster.", schemaFor(reflect.TypeOf(atlas.Cluster{}), fieldSet(planControlledClusterFields)))
+	check("processArgs.", schemaFor(reflect.TypeOf(atlas.ProcessArgs{}), nil))
+	check("ipAccessList[].", schemaFor(reflect.TypeOf(atlas.AccessListEntry{}), nil))
+	check("encryptionAtRest.", schemaFor(reflect.TypeOf(EncryptionAtRestParams{}), nil))
+	check("user.", schemaFor(reflect.TypeOf(atlas.User{}), fieldSet(brokerControlledUserFields)))
+	check("connectionString.", schemaFor(reflect.TypeOf(ConnectionStringParams{}), nil))
+}
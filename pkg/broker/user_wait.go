@@ -0,0 +1,86 @@
+package broker
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// DefaultUserWaitTimeout bounds how long binds wait for a new database user
+// to be deployed. Like provisions the bind has to answer before platforms
+// give up on the request, which most do after 60 seconds.
+const DefaultUserWaitTimeout = 50 * time.Second
+
+// userWaitInterval is the delay between polls of the project status.
+const userWaitInterval = 2 * time.Second
+
+// userNotDeployedWarning is added to the credentials of bindings whose
+// project still had changes pending after the wait.
+const userNotDeployedWarning = "Atlas is still deploying changes to the project, connections may fail to authenticate until the database user has been deployed"
+
+// waitForUserFromParams reads the waitForUser bind parameter, falling back to
+// the configured default.
+func (b Broker) waitForUserFromParams(rawParams []byte) (bool, error) {
+	params := struct {
+		WaitForUser *bool `json:"waitForUser"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return false, validationErrorFromJSON(err)
+		}
+	}
+
+	if params.WaitForUser == nil {
+		return b.waitForUser, nil
+	}
+
+	return *params.WaitForUser, nil
+}
+
+// waitForProjectChanges waits for the changes of the project, including a
+// newly created user, to be deployed. Users are created right away but can't
+// authenticate before that. Atlas doesn't report the state of single users,
+// so unrelated changes such as a scaling cluster prolong the wait, and
+// finishing it doesn't guarantee the user can authenticate yet. A warning for
+// the credentials is returned if changes are still pending after the wait
+// timeout. Binds whose request was cancelled while waiting fail anyway.
+func (b Broker) waitForProjectChanges(ctx context.Context, client atlas.Client) string {
+	if deployed, _ := b.projectChangesDeployed(ctx, client, b.userWaitTimeout); !deployed {
+		return userNotDeployedWarning
+	}
+
+	return ""
+}
+
+// projectChangesDeployed polls the project status until Atlas has deployed
+// the latest changes, including newly created users, to the clusters. False is returned
+// if that doesn't happen within the timeout or the status can't be fetched,
+// along with the error of ctx if it's done before.
+func (b Broker) projectChangesDeployed(ctx context.Context, client atlas.Client, timeout time.Duration) (bool, error) {
+	deadline := b.clock.Now().Add(timeout)
+
+	for {
+		status, err := client.GetProjectStatus()
+		if err != nil {
+			b.logger.Warnw("Failed to get the project status", "error", err)
+			return false, nil
+		}
+
+		if status.ChangeStatus != atlas.ChangeStatusPending {
+			return true, nil
+		}
+
+		if !b.clock.Now().Add(userWaitInterval).Before(deadline) {
+			b.logger.Warnw("Project changes weren't deployed in time", "timeout", timeout.String())
+			return false, nil
+		}
+
+		if err := b.clock.SleepContext(ctx, userWaitInterval); err != nil {
+			b.logger.Warnw("Stopped waiting for the project changes", "error", err)
+			return false, err
+		}
+	}
+}
@@ -0,0 +1,129 @@
+package broker
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"text/template"
+)
+
+// maximumUsernameLength is the longest username Atlas accepts. Longer
+// usernames are truncated and suffixed with a hash of the full name so they
+// stay unique.
+const maximumUsernameLength = 100
+
+// usernameHashLength is the number of hex digits of the hash suffix.
+const usernameHashLength = 8
+
+// UsernameTemplateData is available to username templates. The platform
+// fields are taken from the bind context and empty if the platform doesn't
+// send them, Cloud Foundry sends the organization and space, Kubernetes the
+// namespace.
+type UsernameTemplateData struct {
+	InstanceID       string
+	BindingID        string
+	OrganizationGUID string
+	OrganizationName string
+	SpaceGUID        string
+	SpaceName        string
+	Namespace        string
+}
+
+// parseUsernameTemplate parses a text/template used to derive the usernames
+// of binding users. It's rendered for two sample bindings to reject templates
+// which fail or don't tell bindings apart.
+func parseUsernameTemplate(text string) (*template.Template, error) {
+	tmpl, err := template.New("username").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username template: %v", err)
+	}
+
+	sample := sampleTemplateData()
+	first, err := executeUsernameTemplate(tmpl, sample)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username template: %v", err)
+	}
+
+	sample.BindingID = "0f1e2d3c-4b5a-4968-8776-655443322110"
+	second, err := executeUsernameTemplate(tmpl, sample)
+	if err != nil {
+		return nil, fmt.Errorf("invalid username template: %v", err)
+	}
+
+	if first == second {
+		return nil, fmt.Errorf("username template must include {{.BindingID}} to produce a unique username per binding")
+	}
+
+	return tmpl, nil
+}
+
+// sampleTemplateData is used to check templates when they're parsed.
+func sampleTemplateData() UsernameTemplateData {
+	return UsernameTemplateData{
+		InstanceID:       "2a8a9ac7-5b2e-4b0a-9c37-4f1bb2bd6e8c",
+		BindingID:        "7c2d4f0e-9b1a-4c3d-8e5f-6a7b8c9d0e1f",
+		OrganizationGUID: "org-guid",
+		OrganizationName: "org",
+		SpaceGUID:        "space-guid",
+		SpaceName:        "space",
+		Namespace:        "namespace",
+	}
+}
+
+// executeUsernameTemplate renders a username template and truncates the
+// result to the length accepted by Atlas.
+func executeUsernameTemplate(tmpl *template.Template, data UsernameTemplateData) (string, error) {
+	var username bytes.Buffer
+	if err := tmpl.Execute(&username, data); err != nil {
+		return "", err
+	}
+
+	return truncateUsername(username.String()), nil
+}
+
+// truncateUsername shortens usernames exceeding the Atlas limit, replacing
+// the end with a hash of the full name. The result only depends on the
+// name, so the same binding always gets the same username.
+func truncateUsername(username string) string {
+	if len(username) <= maximumUsernameLength {
+		return username
+	}
+
+	sum := sha256.Sum256([]byte(username))
+	suffix := hex.EncodeToString(sum[:])[:usernameHashLength]
+
+	return username[:maximumUsernameLength-usernameHashLength-1] + "-" + suffix
+}
+
+// usernameForBinding returns the username of the database user created for
+// a binding. Without a template it's the binding ID. Users are labeled with
+// the binding ID, which is how unbinds find them regardless of their name.
+func (b Broker) usernameForBinding(instanceID string, bindingID string, rawContext json.RawMessage) (string, error) {
+	if b.usernameTemplate == nil {
+		return bindingUsername(bindingID), nil
+	}
+
+	username, err := executeUsernameTemplate(b.usernameTemplate, bindingTemplateData(instanceID, bindingID, rawContext))
+	if err != nil {
+		return "", fmt.Errorf("failed to render the username template: %v", err)
+	}
+
+	return username, nil
+}
+
+// bindingTemplateData returns the fields of a binding available to username
+// and appName templates.
+func bindingTemplateData(instanceID string, bindingID string, rawContext json.RawMessage) UsernameTemplateData {
+	platformCtx := platformContextFromContext(rawContext)
+	return UsernameTemplateData{
+		InstanceID:       instanceID,
+		BindingID:        bindingID,
+		OrganizationGUID: platformCtx.OrganizationGUID,
+		OrganizationName: platformCtx.OrganizationName,
+		SpaceGUID:        platformCtx.SpaceGUID,
+		SpaceName:        platformCtx.SpaceName,
+		Namespace:        platformCtx.Namespace,
+	}
+}
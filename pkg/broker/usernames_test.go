@@ -0,0 +1,79 @@
+package broker
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestBindUsernameTemplate(t *testing.T) {
+	broker, client, ctx := setupTest(WithUsernameTemplate("{{.SpaceName}}-{{.BindingID}}"))
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	bindingID := "binding"
+	spec, err := broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:     testPlanID,
+		ServiceID:  testServiceID,
+		RawContext: []byte(`{"platform": "cloudfoundry", "space_guid": "space-guid", "space_name": "orders"}`),
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "orders-binding", spec.Credentials.(ConnectionDetails).Username)
+	if user := client.Users["orders-binding"]; assert.NotNil(t, user) {
+		assert.Equal(t, bindingID, labelValue(user.Labels, LabelBindingID))
+	}
+
+	// Unbinds find the user by its label, the context isn't sent again.
+	_, err = broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, client.Users["orders-binding"])
+}
+
+func TestUsernameTemplateTruncation(t *testing.T) {
+	tmpl, err := parseUsernameTemplate("{{.SpaceName}}-{{.BindingID}}")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	data := UsernameTemplateData{SpaceName: strings.Repeat("s", 120), BindingID: "binding"}
+	username, err := executeUsernameTemplate(tmpl, data)
+	assert.NoError(t, err)
+	assert.Len(t, username, maximumUsernameLength)
+	assert.Regexp(t, "^s+-[0-9a-f]{8}$", username)
+
+	// The same binding always gets the same username, other bindings don't.
+	again, _ := executeUsernameTemplate(tmpl, data)
+	assert.Equal(t, username, again)
+
+	data.BindingID = "other"
+	other, _ := executeUsernameTemplate(tmpl, data)
+	assert.NotEqual(t, username, other)
+
+	assert.Equal(t, "short", truncateUsername("short"))
+}
+
+func TestInvalidUsernameTemplates(t *testing.T) {
+	templates := []string{
+		"{{.SpaceName",
+		"{{.Unknown}}-{{.BindingID}}",
+		"{{.SpaceName}}-{{.InstanceID}}",
+	}
+
+	for _, text := range templates {
+		_, err := New(zap.NewNop().Sugar(), WithUsernameTemplate(text))
+		assert.Error(t, err, text)
+	}
+}
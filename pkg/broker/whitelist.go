@@ -19,7 +19,16 @@ func ReadWhitelistFile(path string) (Whitelist, error) {
 		return nil, err
 	}
 
-	for whitelistProviderName, _ := range whitelist {
+	if err := whitelist.validate(); err != nil {
+		return nil, err
+	}
+
+	return whitelist, nil
+}
+
+// validate makes sure the whitelist only references known providers.
+func (whitelist Whitelist) validate() error {
+	for whitelistProviderName := range whitelist {
 		var isValid bool
 		for _, providerName := range providerNames {
 			if whitelistProviderName == providerName {
@@ -27,9 +36,9 @@ func ReadWhitelistFile(path string) (Whitelist, error) {
 			}
 		}
 		if !isValid {
-			return nil, fmt.Errorf("invalid whitelist")
+			return fmt.Errorf("invalid whitelist")
 		}
 	}
 
-	return whitelist, nil
+	return nil
 }
@@ -0,0 +1,57 @@
+package broker
+
+import (
+	"encoding/json"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+)
+
+// Validity of the client certificates of X.509 bindings in months. Atlas
+// doesn't issue certificates valid for more than two years.
+const (
+	defaultCertificateMonths = 3
+	maxCertificateMonths     = 24
+)
+
+// x509AuthMechanism is the authentication mechanism of connection strings
+// for users authenticating with certificates.
+const x509AuthMechanism = "MONGODB-X509"
+
+// CertificateParams are the binding parameters passed as "certificate" which
+// control the client certificate of X.509 bindings.
+type CertificateParams struct {
+	MonthsUntilExpiration int `json:"monthsUntilExpiration,omitempty" description:"Months until the client certificate expires, 3 unless set."`
+}
+
+// certificateMonthsFromParams reads the validity of the client certificate
+// from the bind parameters. Certificates are only issued to users of x509Type
+// MANAGED, passing certificate params for other users is an error.
+func certificateMonthsFromParams(rawParams []byte, user *atlas.User) (int, error) {
+	params := struct {
+		Certificate *CertificateParams `json:"certificate"`
+	}{}
+
+	if len(rawParams) > 0 {
+		if err := json.Unmarshal(rawParams, &params); err != nil {
+			return 0, validationErrorFromJSON(err)
+		}
+	}
+
+	if params.Certificate == nil {
+		return defaultCertificateMonths, nil
+	}
+
+	verr := &ValidationError{}
+	if !user.IsX509() {
+		verr.add("certificate", "requires user.x509Type %s", atlas.X509TypeManaged)
+	}
+
+	months := params.Certificate.MonthsUntilExpiration
+	if months == 0 {
+		months = defaultCertificateMonths
+	} else if months < 1 || months > maxCertificateMonths {
+		verr.add("certificate.monthsUntilExpiration", "must be between 1 and %d", maxCertificateMonths)
+	}
+
+	return months, verr.errorOrNil()
+}
@@ -0,0 +1,108 @@
+package broker
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBindX509(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	bindingID := "binding"
+	spec, err := broker.Bind(ctx, instanceID, bindingID, brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"user": {"x509Type": "MANAGED"}, "certificate": {"monthsUntilExpiration": 6}, "database": "orders"}`),
+	}, true)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	details := spec.Credentials.(ConnectionDetails)
+	assert.Empty(t, details.Password)
+	assert.Equal(t, "-----BEGIN CERTIFICATE-----\nbinding 6\n-----END CERTIFICATE-----\n", details.Certificate)
+	assert.Equal(t, "mongodb+srv://instance.mongodb.net/orders?appName=instance-binding&authMechanism=MONGODB-X509&authSource=%24external", details.URI)
+
+	user := client.Users[bindingID]
+	if assert.NotNil(t, user) {
+		assert.Equal(t, atlas.AuthDatabaseExternal, user.DatabaseName)
+		assert.Empty(t, user.Password)
+	}
+
+	// The user is deleted from $external.
+	_, err = broker.Unbind(ctx, instanceID, bindingID, brokerapi.UnbindDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	assert.NoError(t, err)
+	assert.Nil(t, client.Users[bindingID])
+}
+
+func TestBindX509ConnectionString(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+	client.Clusters[instanceID].SrvAddress = "mongodb+srv://instance.mongodb.net"
+
+	// Connection strings of X.509 users don't include the username.
+	spec, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+		PlanID:        testPlanID,
+		ServiceID:     testServiceID,
+		RawParameters: []byte(`{"user": {"x509Type": "MANAGED"}, "connectionString": {}}`),
+	}, true)
+	if assert.NoError(t, err) {
+		details := spec.Credentials.(ConnectionDetails)
+		assert.Equal(t, "mongodb+srv://instance.mongodb.net/?appName=instance-binding&authMechanism=MONGODB-X509&authSource=%24external&retryWrites=true", details.URI)
+		assert.Contains(t, details.Certificate, "binding 3")
+	}
+}
+
+func TestBindX509InvalidParams(t *testing.T) {
+	broker, client, ctx := setupTest()
+
+	instanceID := "instance"
+	broker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		PlanID:    testPlanID,
+		ServiceID: testServiceID,
+	}, true)
+
+	tests := []struct {
+		name   string
+		params string
+		field  string
+	}{
+		{"unsupported type", `{"user": {"x509Type": "CUSTOMER"}}`, "user.x509Type"},
+		{"certificate without X.509", `{"certificate": {"monthsUntilExpiration": 3}}`, "certificate"},
+		{"expiration too late", `{"user": {"x509Type": "MANAGED"}, "certificate": {"monthsUntilExpiration": 36}}`, "certificate.monthsUntilExpiration"},
+	}
+
+	for _, test := range tests {
+		_, err := broker.Bind(ctx, instanceID, "binding", brokerapi.BindDetails{
+			PlanID:        testPlanID,
+			ServiceID:     testServiceID,
+			RawParameters: []byte(test.params),
+		}, true)
+
+		if failure, ok := err.(*apiresponses.FailureResponse); assert.True(t, ok, test.name) {
+			assert.Equal(t, http.StatusBadRequest, failure.ValidatedStatusCode(nil), test.name)
+			assert.Contains(t, failure.Error(), test.field, test.name)
+		}
+		assert.Nil(t, client.Users["binding"], test.name)
+	}
+}
@@ -0,0 +1,211 @@
+// Package clock abstracts the passing of time so polling, timeouts and
+// backoff can be tested without waiting on the real clock.
+package clock
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits for durations to pass.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+
+	// SleepContext is like Sleep but returns the error of ctx as soon as
+	// it's done.
+	SleepContext(ctx context.Context, d time.Duration) error
+}
+
+// Real is the clock of the system.
+var Real Clock = realClock{}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) Sleep(d time.Duration)                  { time.Sleep(d) }
+
+func (realClock) SleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Fake is a clock which only moves when advanced. Sleeping advances it
+// immediately, so code sleeping on it runs without delay.
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+	slept   []time.Duration
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFake returns a fake clock set to now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the current time of the clock.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.now
+}
+
+// After returns a channel receiving the time once the clock has been
+// advanced by d.
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+
+	f.waiters = append(f.waiters, waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// Sleep records the duration and advances the clock by it.
+func (f *Fake) Sleep(d time.Duration) {
+	f.mu.Lock()
+	f.slept = append(f.slept, d)
+	f.mu.Unlock()
+
+	f.Advance(d)
+}
+
+// SleepContext sleeps like Sleep unless ctx is done already, in which case
+// its error is returned.
+func (f *Fake) SleepContext(ctx context.Context, d time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	f.Sleep(d)
+	return nil
+}
+
+// Advance moves the clock forward and fires the channels of After which are
+// due.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.now = f.now.Add(d)
+
+	pending := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			pending = append(pending, w)
+			continue
+		}
+
+		w.c <- f.now
+	}
+	f.waiters = pending
+}
+
+// Slept returns the durations passed to Sleep so far.
+func (f *Fake) Slept() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]time.Duration(nil), f.slept...)
+}
+
+// Waiters returns the number of channels returned by After which haven't
+// fired yet. Tests use it to wait until code is blocked on the clock.
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.waiters)
+}
+
+// WithTimeout is like context.WithTimeout but measures the timeout on a
+// clock. The real clock uses context.WithTimeout directly.
+func WithTimeout(parent context.Context, c Clock, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if c == Real {
+		return context.WithTimeout(parent, timeout)
+	}
+
+	ctx := &timeoutContext{
+		Context:  parent,
+		deadline: c.Now().Add(timeout),
+		done:     make(chan struct{}),
+	}
+
+	if err := parent.Err(); err != nil {
+		ctx.cancel(err)
+		return ctx, func() {}
+	}
+
+	expired := c.After(timeout)
+	go func() {
+		select {
+		case <-expired:
+			ctx.cancel(context.DeadlineExceeded)
+		case <-parent.Done():
+			ctx.cancel(parent.Err())
+		case <-ctx.done:
+		}
+	}()
+
+	return ctx, func() { ctx.cancel(context.Canceled) }
+}
+
+// timeoutContext is done once its clock passes the deadline or its parent is
+// done. Values are looked up in the parent.
+type timeoutContext struct {
+	context.Context
+	deadline time.Time
+
+	mu   sync.Mutex
+	done chan struct{}
+	err  error
+}
+
+func (c *timeoutContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *timeoutContext) Done() <-chan struct{} {
+	return c.done
+}
+
+func (c *timeoutContext) Err() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.err
+}
+
+func (c *timeoutContext) cancel(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.err != nil {
+		return
+	}
+
+	c.err = err
+	close(c.done)
+}
@@ -0,0 +1,100 @@
+package clock
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestFakeAfter(t *testing.T) {
+	c := NewFake(testTime)
+
+	after := c.After(time.Minute)
+	c.Advance(59 * time.Second)
+	select {
+	case <-after:
+		t.Fatal("Expected the channel not to fire before the duration passed")
+	default:
+	}
+
+	c.Advance(time.Second)
+	select {
+	case now := <-after:
+		assert.Equal(t, testTime.Add(time.Minute), now)
+	default:
+		t.Fatal("Expected the channel to fire once the duration passed")
+	}
+
+	assert.Equal(t, 0, c.Waiters())
+}
+
+func TestFakeSleep(t *testing.T) {
+	c := NewFake(testTime)
+
+	c.Sleep(time.Second)
+	c.Sleep(time.Minute)
+
+	assert.Equal(t, testTime.Add(time.Minute+time.Second), c.Now())
+	assert.Equal(t, []time.Duration{time.Second, time.Minute}, c.Slept())
+}
+
+func TestWithTimeout(t *testing.T) {
+	c := NewFake(testTime)
+
+	ctx, cancel := WithTimeout(context.Background(), c, time.Minute)
+	defer cancel()
+
+	deadline, ok := ctx.Deadline()
+	assert.True(t, ok)
+	assert.Equal(t, testTime.Add(time.Minute), deadline)
+	assert.NoError(t, ctx.Err())
+
+	c.Advance(time.Minute)
+	<-ctx.Done()
+	assert.Equal(t, context.DeadlineExceeded, ctx.Err())
+}
+
+func TestWithTimeoutCanceled(t *testing.T) {
+	parent, cancelParent := context.WithCancel(context.WithValue(context.Background(), "key", "value"))
+
+	ctx, cancel := WithTimeout(parent, NewFake(testTime), time.Minute)
+	defer cancel()
+	assert.Equal(t, "value", ctx.Value("key"))
+
+	cancelParent()
+	<-ctx.Done()
+	assert.Equal(t, context.Canceled, ctx.Err())
+
+	// Contexts of parents which are already done are done immediately.
+	ctx, cancel = WithTimeout(parent, NewFake(testTime), time.Minute)
+	defer cancel()
+	assert.Equal(t, context.Canceled, ctx.Err())
+}
+
+func TestSleepContext(t *testing.T) {
+	c := NewFake(testTime)
+
+	assert.NoError(t, c.SleepContext(context.Background(), time.Second))
+	assert.Equal(t, []time.Duration{time.Second}, c.Slept())
+
+	// Cancelled contexts don't sleep at all.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	assert.Equal(t, context.Canceled, c.SleepContext(ctx, time.Second))
+	assert.Len(t, c.Slept(), 1)
+
+	// Sleeping on the real clock ends when the context is cancelled.
+	ctx, cancel = context.WithCancel(context.Background())
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+
+	start := time.Now()
+	assert.Equal(t, context.Canceled, Real.SleepContext(ctx, time.Hour))
+	assert.True(t, time.Since(start) < time.Minute)
+}
@@ -0,0 +1,208 @@
+// Package metrics provides counters and gauges which are exposed in the
+// Prometheus text format. It only covers what the broker needs and avoids pulling in the full
+// Prometheus client.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// labelSeparator joins label values into map keys. It can't appear in valid
+// UTF-8 strings.
+const labelSeparator = "\xff"
+
+// CounterVec is a counter partitioned by a fixed set of labels.
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewCounterVec creates a counter with the passed name, help text and label
+// names.
+func NewCounterVec(name string, help string, labels ...string) *CounterVec {
+	return &CounterVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+}
+
+// Inc increments the counter for the passed label values, which have to be
+// given in the same order as the label names. It panics if the number of
+// values doesn't match.
+func (c *CounterVec) Inc(values ...string) {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.values[key]++
+}
+
+// Value returns the current count for the passed label values.
+func (c *CounterVec) Value(values ...string) float64 {
+	key := c.key(values)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.values[key]
+}
+
+func (c *CounterVec) key(values []string) string {
+	if len(values) != len(c.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d", c.name, len(c.labels), len(values)))
+	}
+
+	return strings.Join(values, labelSeparator)
+}
+
+// write outputs the counter in the Prometheus text format.
+func (c *CounterVec) write(w io.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	writeSeries(w, "counter", c.name, c.help, c.labels, c.values)
+}
+
+// GaugeVec is a gauge partitioned by a fixed set of labels.
+type GaugeVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// NewGaugeVec creates a gauge with the passed name, help text and label
+// names.
+func NewGaugeVec(name string, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{
+		name:   name,
+		help:   help,
+		labels: labels,
+		values: map[string]float64{},
+	}
+}
+
+// Set sets the gauge for the passed label values, which have to be given in
+// the same order as the label names. It panics if the number of values
+// doesn't match.
+func (g *GaugeVec) Set(value float64, values ...string) {
+	key := g.key(values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.values[key] = value
+}
+
+// Value returns the current value for the passed label values.
+func (g *GaugeVec) Value(values ...string) float64 {
+	key := g.key(values)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	return g.values[key]
+}
+
+func (g *GaugeVec) key(values []string) string {
+	if len(values) != len(g.labels) {
+		panic(fmt.Sprintf("metric %s expects %d label values but got %d", g.name, len(g.labels), len(values)))
+	}
+
+	return strings.Join(values, labelSeparator)
+}
+
+// write outputs the gauge in the Prometheus text format.
+func (g *GaugeVec) write(w io.Writer) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	writeSeries(w, "gauge", g.name, g.help, g.labels, g.values)
+}
+
+// writeSeries outputs a metric of the passed type in the Prometheus text
+// format. Series are sorted to keep the output stable.
+func writeSeries(w io.Writer, metricType string, name string, help string, labels []string, series map[string]float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, metricType)
+
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		// Metrics without labels have a single series.
+		if len(labels) == 0 {
+			fmt.Fprintf(w, "%s %v\n", name, series[key])
+			continue
+		}
+
+		values := strings.Split(key, labelSeparator)
+
+		pairs := make([]string, len(labels))
+		for i, label := range labels {
+			pairs[i] = fmt.Sprintf(`%s="%s"`, label, escapeLabelValue(values[i]))
+		}
+
+		fmt.Fprintf(w, "%s{%s} %v\n", name, strings.Join(pairs, ","), series[key])
+	}
+}
+
+// escapeLabelValue escapes backslashes, quotes and newlines as required by
+// the text format.
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+// Collector is a metric which can be registered, either a *CounterVec or a
+// *GaugeVec.
+type Collector interface {
+	write(w io.Writer)
+}
+
+// Registry collects metrics and serves them over HTTP.
+type Registry struct {
+	mu         sync.Mutex
+	collectors []Collector
+}
+
+// NewRegistry creates an empty registry.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// Register adds metrics to the registry.
+func (r *Registry) Register(collectors ...Collector) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.collectors = append(r.collectors, collectors...)
+}
+
+// ServeHTTP writes all registered metrics in the Prometheus text format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	collectors := append([]Collector{}, r.collectors...)
+	r.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, collector := range collectors {
+		collector.write(w)
+	}
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounterVec(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "operation", "result")
+
+	counter.Inc("bind", "success")
+	counter.Inc("bind", "success")
+	counter.Inc("bind", "user")
+
+	assert.Equal(t, float64(2), counter.Value("bind", "success"))
+	assert.Equal(t, float64(1), counter.Value("bind", "user"))
+	assert.Equal(t, float64(0), counter.Value("unbind", "success"))
+
+	assert.Panics(t, func() { counter.Inc("bind") })
+}
+
+func TestRegistryServeHTTP(t *testing.T) {
+	counter := NewCounterVec("test_total", "Test counter.", "operation", "result")
+	counter.Inc("provision", "success")
+	counter.Inc("bind", `"quoted"`)
+
+	registry := NewRegistry()
+	registry.Register(counter)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.Equal(t, `# HELP test_total Test counter.
+# TYPE test_total counter
+test_total{operation="bind",result="\"quoted\""} 1
+test_total{operation="provision",result="success"} 1
+`, recorder.Body.String())
+}
+
+func TestGaugeVec(t *testing.T) {
+	gauge := NewGaugeVec("test_remaining", "Test gauge.", "group")
+
+	gauge.Set(10, "a")
+	gauge.Set(7, "a")
+	gauge.Set(3, "b")
+
+	assert.Equal(t, float64(7), gauge.Value("a"))
+	assert.Equal(t, float64(3), gauge.Value("b"))
+	assert.Equal(t, float64(0), gauge.Value("c"))
+
+	assert.Panics(t, func() { gauge.Set(1) })
+
+	registry := NewRegistry()
+	registry.Register(gauge)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, `# HELP test_remaining Test gauge.
+# TYPE test_remaining gauge
+test_remaining{group="a"} 7
+test_remaining{group="b"} 3
+`, recorder.Body.String())
+}
+
+func TestGaugeVecWithoutLabels(t *testing.T) {
+	gauge := NewGaugeVec("test_enabled", "Test gauge.")
+	gauge.Set(1)
+
+	assert.Equal(t, float64(1), gauge.Value())
+
+	registry := NewRegistry()
+	registry.Register(gauge)
+
+	recorder := httptest.NewRecorder()
+	registry.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	assert.Equal(t, `# HELP test_enabled Test gauge.
+# TYPE test_enabled gauge
+test_enabled 1
+`, recorder.Body.String())
+}
@@ -0,0 +1,169 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/broker"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// AdminInstancesPath is the path of the instance listing relative to the path
+// prefix. It requires the same authentication as the broker API and lists
+// the instances in the project of the API key, see broker.InstanceList for
+// the response.
+//
+// The "state" query parameter filters by cluster state, "page" and
+// "pageSize" select a page of the instances sorted by cluster name.
+const AdminInstancesPath = "/admin/instances"
+
+// AdminSupportBundlePath is the path of the support bundle of an instance
+// relative to the path prefix, see broker.SupportBundle for the response.
+// It requires the same authentication as the broker API.
+const AdminSupportBundlePath = AdminInstancesPath + "/{instance_id}/support-bundle"
+
+// AdminMonitoringUserPath is the path of the monitoring user credentials of
+// an instance relative to the path prefix, see broker.MonitoringCredentials
+// for the response. It requires the same authentication as the broker API.
+const AdminMonitoringUserPath = AdminInstancesPath + "/{instance_id}/monitoring-user"
+
+// AdminEventsPath is the path of the instance and binding events relative to
+// the path prefix, see broker.EventPage for the response. It requires the
+// same authentication as the broker API and only returns the events of the
+// project of the API key.
+//
+// The "cursor" query parameter continues after a previous page, "limit"
+// caps the number of events and "wait" keeps the request open for up to that
+// many seconds until there are new events.
+const AdminEventsPath = "/admin/events"
+
+// listInstances serves AdminInstancesPath.
+func listInstances(b *broker.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		opts := broker.InstanceListOptions{State: query.Get("state")}
+
+		var err error
+		if opts.Page, err = intQuery(query, "page"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		if opts.PageSize, err = intQuery(query, "pageSize"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		list, err := b.ListInstances(r.Context(), opts)
+		if err != nil {
+			if failure, ok := err.(*apiresponses.FailureResponse); ok {
+				writeError(w, failure.ValidatedStatusCode(nil), failure.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "failed to list instances")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(list)
+	}
+}
+
+// supportBundle serves AdminSupportBundlePath. The bundle is sent as an
+// attachment so it can be saved and attached to tickets as is.
+func supportBundle(b *broker.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		instanceID := mux.Vars(r)["instance_id"]
+
+		bundle, err := b.SupportBundle(r.Context(), instanceID)
+		if err != nil {
+			if failure, ok := err.(*apiresponses.FailureResponse); ok {
+				writeError(w, failure.ValidatedStatusCode(nil), failure.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "failed to collect support bundle")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="support-bundle-%s.json"`, instanceID))
+		json.NewEncoder(w).Encode(bundle)
+	}
+}
+
+// monitoringUser serves AdminMonitoringUserPath. The credentials must not be
+// cached by anything in between.
+func monitoringUser(b *broker.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		credentials, err := b.MonitoringCredentials(r.Context(), mux.Vars(r)["instance_id"])
+		if err != nil {
+			if failure, ok := err.(*apiresponses.FailureResponse); ok {
+				writeError(w, failure.ValidatedStatusCode(nil), failure.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "failed to get the monitoring user")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(credentials)
+	}
+}
+
+// listEvents serves AdminEventsPath.
+func listEvents(b *broker.Broker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+
+		opts := broker.EventOptions{Cursor: query.Get("cursor")}
+
+		var err error
+		if opts.Limit, err = intQuery(query, "limit"); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		wait, err := intQuery(query, "wait")
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		opts.Wait = time.Duration(wait) * time.Second
+
+		page, err := b.Events(r.Context(), opts)
+		if err != nil {
+			if failure, ok := err.(*apiresponses.FailureResponse); ok {
+				writeError(w, failure.ValidatedStatusCode(nil), failure.Error())
+			} else {
+				writeError(w, http.StatusInternalServerError, "failed to list events")
+			}
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(page)
+	}
+}
+
+// intQuery parses an integer query parameter, zero if it's missing.
+func intQuery(query url.Values, name string) (int, error) {
+	raw := query.Get(name)
+	if raw == "" {
+		return 0, nil
+	}
+
+	value, err := strconv.Atoi(raw)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number", name)
+	}
+
+	return value, nil
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi/domain/apiresponses"
+)
+
+// DefaultMaxBodyBytes is the largest request body accepted unless
+// WithMaxBodyBytes is passed. Broker API requests are a few KB at most.
+const DefaultMaxBodyBytes = 1 << 20
+
+// DefaultMaxJSONDepth is the deepest nesting of JSON request bodies accepted
+// unless WithMaxJSONDepth is passed. Cluster parameters need less than ten
+// levels.
+const DefaultMaxJSONDepth = 32
+
+// Reasons requests are rejected for, used as the "reason" label of the
+// rejected requests counter.
+const (
+	RejectReasonBodyTooLarge = "body_too_large"
+	RejectReasonJSONTooDeep  = "json_too_deep"
+)
+
+// newRejectedCounter creates the counter of requests rejected before they
+// reached the broker.
+func newRejectedCounter() *metrics.CounterVec {
+	return metrics.NewCounterVec("aosb_rejected_requests_total", "Number of requests rejected due to request limits by reason.", "reason")
+}
+
+// limitRequests rejects request bodies which are too large or too deeply
+// nested before brokerapi decodes them. Bodies are read into memory, which is
+// bounded by the size limit.
+func limitRequests(maxBodyBytes int64, maxJSONDepth int, rejected *metrics.CounterVec) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			tooLarge := func() {
+				rejected.Inc(RejectReasonBodyTooLarge)
+				writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("request body must not be larger than %d bytes", maxBodyBytes))
+			}
+
+			if r.ContentLength > maxBodyBytes {
+				tooLarge()
+				return
+			}
+
+			body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+			if err != nil {
+				// MaxBytesReader doesn't have a distinct error, anything
+				// read past the limit means the body was too large.
+				if int64(len(body)) >= maxBodyBytes {
+					tooLarge()
+				} else {
+					writeError(w, http.StatusBadRequest, "failed to read request body")
+				}
+				return
+			}
+
+			if exceedsJSONDepth(body, maxJSONDepth) {
+				rejected.Inc(RejectReasonJSONTooDeep)
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("request body must not be nested deeper than %d levels", maxJSONDepth))
+				return
+			}
+
+			r.Body = ioutil.NopCloser(bytes.NewReader(body))
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// exceedsJSONDepth returns whether JSON nests objects and arrays deeper than
+// the limit. It reads tokens instead of decoding so deep input can't exhaust
+// the stack. Invalid JSON is left to the regular decoding to report.
+func exceedsJSONDepth(data []byte, maxDepth int) bool {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+
+	depth := 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return false
+		}
+
+		switch token {
+		case json.Delim('{'), json.Delim('['):
+			depth++
+			if depth > maxDepth {
+				return true
+			}
+		case json.Delim('}'), json.Delim(']'):
+			depth--
+		}
+	}
+}
+
+// writeError writes an error response in the format of the broker API.
+func writeError(w http.ResponseWriter, status int, description string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(apiresponses.ErrorResponse{Description: description})
+}
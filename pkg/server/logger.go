@@ -1,4 +1,4 @@
-package main
+package server
 
 import (
 	"code.cloudfoundry.org/lager"
@@ -0,0 +1,225 @@
+// Package server assembles the HTTP handler serving the broker API, so the
+// broker can run standalone or be mounted into another HTTP server.
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/broker"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/pivotal-cf/brokerapi"
+	"github.com/pivotal-cf/brokerapi/middlewares"
+	"go.uber.org/zap"
+)
+
+// DefaultAtlasBaseURL is the Atlas API used unless WithAtlasBaseURL is
+// passed.
+const DefaultAtlasBaseURL = "https://cloud.mongodb.com"
+
+// HealthPath is the path of the health endpoint relative to the path prefix.
+// It's served without authentication.
+const HealthPath = "/healthz"
+
+// InfoPath is the path of the info endpoint relative to the path prefix. It's
+// served without authentication, see Info for the response.
+const InfoPath = "/info"
+
+// MetricsPath is the path of the metrics endpoint relative to the path
+// prefix. It's served without authentication if WithMetrics is passed.
+const MetricsPath = "/metrics"
+
+// Option configures optional behaviour of the handler returned by
+// NewHandler.
+type Option func(*config)
+
+type config struct {
+	atlasBaseURL     string
+	dashboardBaseURL string
+	pathPrefix       string
+	logger           *zap.SugaredLogger
+	registry         *metrics.Registry
+	maxBodyBytes     int64
+	maxJSONDepth     int
+	clientOpts       []atlas.ClientOption
+	version          string
+}
+
+// Info is served at InfoPath.
+type Info struct {
+	Version     string                  `json:"version,omitempty"`
+	Maintenance broker.MaintenanceState `json:"maintenance"`
+
+	// Features tells whether each feature is enabled, see WithFeatures.
+	Features map[string]bool `json:"features"`
+}
+
+// WithAtlasBaseURL sets the Atlas API the broker talks to.
+func WithAtlasBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.atlasBaseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithDashboardBaseURL sets the base of the Atlas UI used for the dashboard
+// URLs of instances. It defaults to the Atlas base URL, other deployments
+// such as Atlas for Government may serve their UI elsewhere.
+func WithDashboardBaseURL(baseURL string) Option {
+	return func(c *config) {
+		c.dashboardBaseURL = strings.TrimRight(baseURL, "/")
+	}
+}
+
+// WithAtlasClientOptions applies options to the Atlas clients created for
+// requests, for example the API versions detected at startup.
+func WithAtlasClientOptions(opts ...atlas.ClientOption) Option {
+	return func(c *config) {
+		c.clientOpts = append(c.clientOpts, opts...)
+	}
+}
+
+// WithVersion sets the broker version reported at InfoPath.
+func WithVersion(version string) Option {
+	return func(c *config) {
+		c.version = version
+	}
+}
+
+// WithPathPrefix serves all endpoints below a path, for example "/atlas".
+func WithPathPrefix(prefix string) Option {
+	return func(c *config) {
+		prefix = strings.Trim(prefix, "/")
+		if prefix != "" {
+			prefix = "/" + prefix
+		}
+
+		c.pathPrefix = prefix
+	}
+}
+
+// WithLogger sets the logger used for the requests handled by brokerapi.
+// Requests aren't logged by default.
+func WithLogger(logger *zap.SugaredLogger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithMetrics serves the metrics of a registry at MetricsPath. Requests
+// rejected by the handler are counted in the registry as well.
+func WithMetrics(registry *metrics.Registry) Option {
+	return func(c *config) {
+		c.registry = registry
+	}
+}
+
+// WithMaxBodyBytes limits the size of request bodies, larger requests are
+// rejected with 413 Request Entity Too Large. Defaults to
+// DefaultMaxBodyBytes, values below one keep the default.
+func WithMaxBodyBytes(maxBodyBytes int64) Option {
+	return func(c *config) {
+		if maxBodyBytes > 0 {
+			c.maxBodyBytes = maxBodyBytes
+		}
+	}
+}
+
+// WithMaxJSONDepth limits the nesting of JSON request bodies, deeper requests
+// are rejected with 400 Bad Request. Defaults to DefaultMaxJSONDepth, values
+// below one keep the default.
+func WithMaxJSONDepth(maxJSONDepth int) Option {
+	return func(c *config) {
+		if maxJSONDepth > 0 {
+			c.maxJSONDepth = maxJSONDepth
+		}
+	}
+}
+
+// NewHandler returns the HTTP handler serving the Open Service Broker API of
+// a broker. Requests authenticate with Atlas API keys using basic auth, with
+// "<PUBLIC_KEY>@<GROUP_ID>" as the username and the private key as the
+// password. The keys are used to talk to Atlas on behalf of the request, so
+// the broker has no credentials of its own.
+func NewHandler(b *broker.Broker, opts ...Option) http.Handler {
+	c := &config{
+		atlasBaseURL: DefaultAtlasBaseURL,
+		logger:       zap.NewNop().Sugar(),
+		maxBodyBytes: DefaultMaxBodyBytes,
+		maxJSONDepth: DefaultMaxJSONDepth,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	router := mux.NewRouter()
+	api := router
+	if c.pathPrefix != "" {
+		api = router.PathPrefix(c.pathPrefix).Subrouter()
+	}
+
+	brokerapi.AttachRoutes(api, b, NewLagerZapLogger(c.logger))
+
+	// Operators can list the instances of a project, collect support
+	// bundles, fetch monitoring credentials and follow events outside the
+	// broker API.
+	api.HandleFunc(AdminInstancesPath, listInstances(b)).Methods(http.MethodGet)
+	api.HandleFunc(AdminSupportBundlePath, supportBundle(b)).Methods(http.MethodGet)
+	api.HandleFunc(AdminMonitoringUserPath, monitoringUser(b)).Methods(http.MethodGet)
+	api.HandleFunc(AdminEventsPath, listEvents(b)).Methods(http.MethodGet)
+
+	// Oversized and deeply nested bodies are rejected before anything else
+	// looks at the request.
+	rejected := newRejectedCounter()
+	if c.registry != nil {
+		c.registry.Register(rejected)
+	}
+	api.Use(limitRequests(c.maxBodyBytes, c.maxJSONDepth, rejected))
+
+	// The auth middleware will convert basic auth credentials into an Atlas
+	// client. The broker keeps track of the rate limit budget reported to
+	// the clients.
+	clientOpts := []atlas.ClientOption{atlas.WithRateLimitObserver(b.ObserveRateLimit)}
+	if c.dashboardBaseURL != "" {
+		clientOpts = append(clientOpts, atlas.WithDashboardBaseURL(c.dashboardBaseURL))
+	}
+	clientOpts = append(clientOpts, c.clientOpts...)
+	api.Use(broker.AuthMiddleware(c.atlasBaseURL, clientOpts...))
+
+	// The originating identity is recorded on clusters to track who
+	// requested them.
+	api.Use(middlewares.AddOriginatingIdentityToContext)
+
+	// Provisions finding an identical instance are answered with 200 OK
+	// instead of 201 Created.
+	api.Use(broker.ProvisionStatusMiddleware)
+
+	// Changes rejected during maintenance tell clients when to retry.
+	api.Use(broker.MaintenanceMiddleware(b))
+
+	// Health, info and metrics are served without authentication next to
+	// the broker API.
+	serveMux := http.NewServeMux()
+	serveMux.HandleFunc(c.pathPrefix+HealthPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	serveMux.HandleFunc(c.pathPrefix+InfoPath, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(Info{
+			Version:     c.version,
+			Maintenance: b.Maintenance(),
+			Features:    b.Features(),
+		})
+	})
+
+	if c.registry != nil {
+		serveMux.Handle(c.pathPrefix+MetricsPath, c.registry)
+	}
+
+	serveMux.Handle("/", router)
+	return serveMux
+}
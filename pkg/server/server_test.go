@@ -0,0 +1,480 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/atlas"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/broker"
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/metrics"
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+// fakeAtlas simulates the parts of the Atlas API needed to serve the catalog
+// and provision clusters, including the digest authentication handshake.
+func fakeAtlas(t *testing.T) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="MMS Public API", nonce="nonce", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/private/unauth/cloudProviders/"):
+			name := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/private/unauth/cloudProviders/"), "/")[0]
+			json.NewEncoder(w).Encode(atlas.Provider{
+				Name: name,
+				InstanceSizes: map[string]atlas.InstanceSize{
+					"M10": atlas.InstanceSize{Name: "M10"},
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/atlas/v1.0/groups/group/clusters":
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": []atlas.Cluster{}, "totalCount": 0})
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/atlas/v1.0/groups/group/clusters/"):
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(map[string]string{"errorCode": "CLUSTER_NOT_FOUND"})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/atlas/v1.0/groups/group/events":
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": []atlas.Event{{ID: "1", EventTypeName: "CLUSTER_DELETED", ClusterName: r.URL.Query().Get("clusterNames")}}, "totalCount": 1})
+		case r.Method == http.MethodPost && r.URL.Path == "/api/atlas/v1.0/groups/group/clusters":
+			var cluster atlas.Cluster
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&cluster))
+			cluster.StateName = atlas.ClusterStateCreating
+			json.NewEncoder(w).Encode(cluster)
+		default:
+			t.Errorf("Unexpected Atlas request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+func request(t *testing.T, handler http.Handler, method string, path string, body string, auth bool) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("X-Broker-API-Version", "2.14")
+	req.Header.Set("Content-Type", "application/json")
+	if auth {
+		req.SetBasicAuth("pubkey@group", "privkey")
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestNewHandler(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	registry := metrics.NewRegistry()
+	b, err := broker.New(zap.NewNop().Sugar(), broker.WithMetricsRegistry(registry))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	handler := NewHandler(b, WithAtlasBaseURL(atlasServer.URL+"/"), WithPathPrefix("/atlas/"), WithMetrics(registry))
+
+	// The broker API requires credentials.
+	rec := request(t, handler, http.MethodGet, "/atlas/v2/catalog", "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(t, handler, http.MethodGet, "/atlas/v2/catalog", "", true)
+	if assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		assert.Contains(t, rec.Body.String(), `"aosb-cluster-plan-aws-m10"`)
+	}
+
+	rec = request(t, handler, http.MethodPut, "/atlas/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	if assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String()) {
+		assert.Contains(t, rec.Body.String(), `"operation":"{\"operation\":\"provision\"`)
+	}
+
+	// Health and metrics don't.
+	rec = request(t, handler, http.MethodGet, "/atlas/healthz", "", false)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = request(t, handler, http.MethodGet, "/atlas/metrics", "", false)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Contains(t, rec.Body.String(), `aosb_operations_total{operation="provision",result="success"} 1`)
+	}
+
+	// Nothing is served outside the prefix.
+	rec = request(t, handler, http.MethodGet, "/v2/catalog", "", true)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestNewHandlerDefaults(t *testing.T) {
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()))
+
+	rec := request(t, handler, http.MethodGet, "/healthz", "", false)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = request(t, handler, http.MethodGet, "/metrics", "", false)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	rec = request(t, handler, http.MethodGet, "/v2/catalog", "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestNewHandlerDashboardURL(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL), WithDashboardBaseURL("https://console.example.com/"))
+
+	rec := request(t, handler, http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	if assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String()) {
+		assert.Contains(t, rec.Body.String(), `"dashboard_url":"https://console.example.com/v2/group#clusters/detail/instance"`)
+	}
+}
+
+func TestNewHandlerAtlasClientOptions(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	// Clusters are served from an API version Atlas doesn't support.
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL), WithAtlasClientOptions(
+		atlas.WithAPIVersions([]atlas.APIVersion{atlas.APIVersion1_0}),
+		atlas.WithEndpointVersion("clusters", atlas.APIVersion2),
+	))
+
+	rec := request(t, handler, http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, rec.Body.String())
+}
+
+func TestNewHandlerAdminInstances(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL))
+
+	rec := request(t, handler, http.MethodGet, AdminInstancesPath, "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(t, handler, http.MethodGet, AdminInstancesPath+"?state=IDLE", "", true)
+	if assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		assert.JSONEq(t, `{"instances": [], "page": 1, "pageSize": 100, "summary": {"total": 0, "managed": 0, "pooled": 0, "foreign": 0}}`, rec.Body.String())
+	}
+
+	rec = request(t, handler, http.MethodGet, AdminInstancesPath+"?page=next", "", true)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.JSONEq(t, `{"description": "page must be a number"}`, rec.Body.String())
+	}
+
+	rec = request(t, handler, http.MethodGet, AdminInstancesPath+"?pageSize=1000", "", true)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.JSONEq(t, `{"description": "page size must be between 1 and 500"}`, rec.Body.String())
+	}
+}
+
+func TestNewHandlerAdminSupportBundle(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL))
+	path := strings.Replace(AdminSupportBundlePath, "{instance_id}", "instance", 1)
+
+	rec := request(t, handler, http.MethodGet, path, "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Bundles of deleted instances still hold the events of the cluster.
+	rec = request(t, handler, http.MethodGet, path, "", true)
+	if assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		assert.Equal(t, `attachment; filename="support-bundle-instance.json"`, rec.Header().Get("Content-Disposition"))
+		assert.Contains(t, rec.Body.String(), `"eventTypeName":"CLUSTER_DELETED"`)
+		assert.Contains(t, rec.Body.String(), `"errors":["cluster: Cluster not found"]`)
+	}
+}
+
+func TestNewHandlerAdminMonitoringUser(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL))
+	path := strings.Replace(AdminMonitoringUserPath, "{instance_id}", "instance", 1)
+
+	rec := request(t, handler, http.MethodGet, path, "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	// Brokers without a credential store don't have monitoring users.
+	rec = request(t, handler, http.MethodGet, path, "", true)
+	if assert.Equal(t, http.StatusNotFound, rec.Code) {
+		assert.JSONEq(t, `{"description": "the instance has no monitoring user"}`, rec.Body.String())
+	}
+}
+
+func TestNewHandlerAdminEvents(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL))
+
+	rec := request(t, handler, http.MethodGet, AdminEventsPath, "", false)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+
+	rec = request(t, handler, http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	rec = request(t, handler, http.MethodGet, AdminEventsPath+"?limit=10", "", true)
+	if assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+
+		page := broker.EventPage{}
+		assert.NoError(t, json.NewDecoder(rec.Body).Decode(&page))
+		if assert.Len(t, page.Events, 1) {
+			assert.Equal(t, broker.EventStarted, page.Events[0].Type)
+			assert.Equal(t, "instance", page.Events[0].InstanceID)
+		}
+		assert.NotEmpty(t, page.Cursor)
+	}
+
+	// Other projects don't see the event.
+	req := httptest.NewRequest(http.MethodGet, AdminEventsPath, nil)
+	req.SetBasicAuth("pubkey@other", "privkey")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	if assert.Equal(t, http.StatusOK, rec.Code, rec.Body.String()) {
+		assert.Contains(t, rec.Body.String(), `"events":[]`)
+	}
+
+	rec = request(t, handler, http.MethodGet, AdminEventsPath+"?wait=soon", "", true)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.JSONEq(t, `{"description": "wait must be a number"}`, rec.Body.String())
+	}
+
+	rec = request(t, handler, http.MethodGet, AdminEventsPath+"?wait=600", "", true)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.JSONEq(t, `{"description": "wait must be between 0 and 60 seconds"}`, rec.Body.String())
+	}
+}
+
+func TestNewHandlerRequestLimits(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	registry := metrics.NewRegistry()
+	handler := NewHandler(broker.NewBroker(zap.NewNop().Sugar()), WithAtlasBaseURL(atlasServer.URL), WithMetrics(registry), WithMaxBodyBytes(1024), WithMaxJSONDepth(8))
+
+	provisionPath := "/v2/service_instances/instance?accepts_incomplete=true"
+	provision := func(params string) string {
+		return `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10", "parameters": ` + params + `}`
+	}
+
+	// Bodies declaring a large size are rejected right away.
+	oversized := provision(`{"cluster": {"mongoDBMajorVersion": "` + strings.Repeat("4", 2048) + `"}}`)
+	rec := request(t, handler, http.MethodPut, provisionPath, oversized, true)
+	if assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code) {
+		assert.JSONEq(t, `{"description": "request body must not be larger than 1024 bytes"}`, rec.Body.String())
+	}
+
+	// Streamed bodies without a size are cut off at the limit.
+	req := httptest.NewRequest(http.MethodPut, provisionPath, strings.NewReader(oversized))
+	req.ContentLength = -1
+	req.Header.Set("X-Broker-API-Version", "2.14")
+	req.SetBasicAuth("pubkey@group", "privkey")
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+
+	nested := provision(`{"cluster": {"labels": ` + strings.Repeat("[", 10) + strings.Repeat("]", 10) + `}}`)
+	rec = request(t, handler, http.MethodPut, provisionPath, nested, true)
+	if assert.Equal(t, http.StatusBadRequest, rec.Code) {
+		assert.JSONEq(t, `{"description": "request body must not be nested deeper than 8 levels"}`, rec.Body.String())
+	}
+
+	// Requests within the limits reach the broker.
+	rec = request(t, handler, http.MethodPut, provisionPath, provision(`{"cluster": {"mongoDBMajorVersion": "4.2"}}`), true)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+
+	rec = request(t, handler, http.MethodGet, "/metrics", "", false)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.Contains(t, rec.Body.String(), `aosb_rejected_requests_total{reason="body_too_large"} 2`)
+		assert.Contains(t, rec.Body.String(), `aosb_rejected_requests_total{reason="json_too_deep"} 1`)
+	}
+}
+
+func TestExceedsJSONDepth(t *testing.T) {
+	assert.False(t, exceedsJSONDepth([]byte(`{"a": [1, {"b": 2}]}`), 3))
+	assert.True(t, exceedsJSONDepth([]byte(`{"a": [1, {"b": [2]}]}`), 3))
+	assert.False(t, exceedsJSONDepth([]byte(`[[], [], []]`), 2))
+
+	// Depth is checked before the input turns out to be invalid.
+	assert.True(t, exceedsJSONDepth([]byte(strings.Repeat("[", 100000)), DefaultMaxJSONDepth))
+	assert.False(t, exceedsJSONDepth([]byte(`{"a": `), 1))
+}
+
+func TestNewHandlerMaintenance(t *testing.T) {
+	atlasServer := fakeAtlas(t)
+	defer atlasServer.Close()
+
+	registry := metrics.NewRegistry()
+	b, err := broker.New(zap.NewNop().Sugar(),
+		broker.WithMetricsRegistry(registry),
+		broker.WithMaintenance(true),
+		broker.WithMaintenanceMessage("Migrating to a new organization", time.Minute),
+	)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	handler := NewHandler(b, WithAtlasBaseURL(atlasServer.URL), WithMetrics(registry), WithVersion("1.2.3"))
+
+	rec := request(t, handler, http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	if assert.Equal(t, http.StatusServiceUnavailable, rec.Code, rec.Body.String()) {
+		assert.Equal(t, "60", rec.Header().Get("Retry-After"))
+		assert.Contains(t, rec.Body.String(), "Migrating to a new organization")
+	}
+
+	// The catalog is still served.
+	rec = request(t, handler, http.MethodGet, "/v2/catalog", "", true)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+
+	rec = request(t, handler, http.MethodGet, InfoPath, "", false)
+	if assert.Equal(t, http.StatusOK, rec.Code) {
+		assert.JSONEq(t, `{"version": "1.2.3", "maintenance": {"enabled": true, "message": "Migrating to a new organization", "retryAfter": 60}, "features": {"bootstrap": true, "ipAccessList": true, "monitoringUsers": true, "supportBundles": true, "warmPools": true, "x509Bindings": true}}`, rec.Body.String())
+	}
+
+	rec = request(t, handler, http.MethodGet, MetricsPath, "", false)
+	assert.Contains(t, rec.Body.String(), "aosb_maintenance_mode 1")
+
+	b.SetMaintenance(false)
+
+	rec = request(t, handler, http.MethodGet, InfoPath, "", false)
+	assert.JSONEq(t, `{"version": "1.2.3", "maintenance": {"enabled": false}, "features": {"bootstrap": true, "ipAccessList": true, "monitoringUsers": true, "supportBundles": true, "warmPools": true, "x509Bindings": true}}`, rec.Body.String())
+
+	rec = request(t, handler, http.MethodPut, "/v2/service_instances/instance?accepts_incomplete=true", `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`, true)
+	assert.Equal(t, http.StatusAccepted, rec.Code, rec.Body.String())
+	assert.Empty(t, rec.Header().Get("Retry-After"))
+}
+
+// fakeAtlasWithCluster simulates an Atlas project with an idle cluster for
+// the instance "instance" and keeps track of database users, so bindings can
+// be created and released end-to-end.
+func fakeAtlasWithCluster(t *testing.T) *httptest.Server {
+	var mu sync.Mutex
+	users := map[string]atlas.User{}
+
+	cluster := atlas.Cluster{
+		Name:       "instance",
+		StateName:  atlas.ClusterStateIdle,
+		SrvAddress: "mongodb+srv://instance.mongodb.net",
+		ProviderSettings: &atlas.ProviderSettings{
+			ProviderName:     "AWS",
+			InstanceSizeName: "M10",
+		},
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			w.Header().Set("WWW-Authenticate", `Digest realm="MMS Public API", nonce="nonce", qop="auth"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		const usersPath = "/api/atlas/v1.0/groups/group/databaseUsers"
+		switch {
+		case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/private/unauth/cloudProviders/"):
+			name := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/private/unauth/cloudProviders/"), "/")[0]
+			json.NewEncoder(w).Encode(atlas.Provider{
+				Name: name,
+				InstanceSizes: map[string]atlas.InstanceSize{
+					"M10": atlas.InstanceSize{Name: "M10"},
+				},
+			})
+		case r.Method == http.MethodGet && r.URL.Path == "/api/atlas/v1.0/groups/group/clusters/instance":
+			json.NewEncoder(w).Encode(cluster)
+		case r.Method == http.MethodGet && r.URL.Path == usersPath:
+			list := []atlas.User{}
+			for _, user := range users {
+				list = append(list, user)
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"results": list, "totalCount": len(list)})
+		case r.Method == http.MethodPost && r.URL.Path == usersPath:
+			var user atlas.User
+			assert.NoError(t, json.NewDecoder(r.Body).Decode(&user))
+			users[user.Username] = user
+			json.NewEncoder(w).Encode(user)
+		case r.Method == http.MethodDelete && strings.HasPrefix(r.URL.Path, usersPath+"/admin/"):
+			delete(users, strings.TrimPrefix(r.URL.Path, usersPath+"/admin/"))
+			w.WriteHeader(http.StatusAccepted)
+		default:
+			t.Errorf("Unexpected Atlas request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+// TestNewHandlerBindingFlags binds through the HTTP API for every combination
+// of binding flags the catalog can advertise and checks the broker behaves
+// accordingly.
+func TestNewHandlerBindingFlags(t *testing.T) {
+	tests := []struct {
+		name        string
+		opts        []broker.Option
+		retrievable bool
+	}{
+		{"without credential store", nil, false},
+		{"with credential store", []broker.Option{broker.WithCredentialStore(broker.NewMemoryCredentialStore(), make([]byte, 32))}, true},
+	}
+
+	for _, test := range tests {
+		atlasServer := fakeAtlasWithCluster(t)
+
+		b, err := broker.New(zap.NewNop().Sugar(), test.opts...)
+		if !assert.NoError(t, err, test.name) {
+			atlasServer.Close()
+			continue
+		}
+		handler := NewHandler(b, WithAtlasBaseURL(atlasServer.URL))
+
+		rec := request(t, handler, http.MethodGet, "/v2/catalog", "", true)
+		var catalog struct {
+			Services []struct {
+				Bindable            bool `json:"bindable"`
+				BindingsRetrievable bool `json:"bindings_retrievable"`
+			} `json:"services"`
+		}
+		if assert.Equal(t, http.StatusOK, rec.Code, test.name) {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &catalog), test.name)
+		}
+		for _, svc := range catalog.Services {
+			assert.True(t, svc.Bindable, test.name)
+			assert.Equal(t, test.retrievable, svc.BindingsRetrievable, test.name)
+		}
+
+		// Bindings are created synchronously, also when async is allowed.
+		path := "/v2/service_instances/instance/service_bindings/binding"
+		body := `{"service_id": "aosb-cluster-service-aws", "plan_id": "aosb-cluster-plan-aws-m10"}`
+		rec = request(t, handler, http.MethodPut, path+"?accepts_incomplete=true", body, true)
+		if assert.Equal(t, http.StatusCreated, rec.Code, test.name, rec.Body.String()) {
+			assert.Contains(t, rec.Body.String(), `"credentials"`, test.name)
+		}
+
+		// Only retrievable bindings can be fetched.
+		rec = request(t, handler, http.MethodGet, path, "", true)
+		if test.retrievable {
+			if assert.Equal(t, http.StatusOK, rec.Code, test.name, rec.Body.String()) {
+				assert.Contains(t, rec.Body.String(), `"username":"binding"`, test.name)
+			}
+		} else {
+			assert.Equal(t, http.StatusNotFound, rec.Code, test.name)
+		}
+
+		// Without associated resources unbinds complete right away.
+		rec = request(t, handler, http.MethodDelete, path+"?service_id=aosb-cluster-service-aws&plan_id=aosb-cluster-plan-aws-m10&accepts_incomplete=true", "", true)
+		assert.Equal(t, http.StatusOK, rec.Code, test.name, rec.Body.String())
+
+		rec = request(t, handler, http.MethodGet, path, "", true)
+		assert.Equal(t, http.StatusNotFound, rec.Code, test.name)
+
+		atlasServer.Close()
+	}
+}
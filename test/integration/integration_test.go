@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/google/uuid"
@@ -40,7 +41,7 @@ func TestMain(m *testing.M) {
 	}
 
 	// Setup the broker which will be used
-	broker = brokerlib.NewBrokerWithWhitelist(zap.NewNop().Sugar(), whitelist)
+	broker = brokerlib.NewBroker(zap.NewNop().Sugar(), brokerlib.WithWhitelist(whitelist))
 
 	result := m.Run()
 
@@ -69,12 +70,12 @@ func TestProvision(t *testing.T) {
 
 	// Setting up our Expected cluster
 	var expectedCluster = &atlas.Cluster{
-		AutoScaling: atlas.AutoScalingConfig{
+		AutoScaling: &atlas.AutoScalingConfig{
 			DiskGBEnabled: true,
 		},
 		Name:          clusterName,
 		BackupEnabled: true,
-		BIConnector: atlas.BIConnectorConfig{
+		BIConnector: &atlas.BIConnectorConfig{
 			Enabled: false,
 		},
 		ClusterType:              "REPLICASET",
@@ -149,6 +150,56 @@ func TestProvision(t *testing.T) {
 	assert.Equal(t, expectedCluster, cluster)
 }
 
+func TestProvisionAzure(t *testing.T) {
+	t.Parallel()
+
+	instanceID := uuid.New().String()
+	clusterName := brokerlib.NormalizeClusterName(instanceID)
+
+	// Azure isn't part of the whitelist used by the other tests.
+	azureBroker := brokerlib.NewBroker(zap.NewNop().Sugar())
+
+	params := `{
+		"cluster": {
+			"providerSettings": {
+				"regionName": "US_EAST_2"
+			}
+		}
+	}`
+
+	_, err := azureBroker.Provision(ctx, instanceID, brokerapi.ProvisionDetails{
+		ServiceID:     "aosb-cluster-service-azure",
+		PlanID:        "aosb-cluster-plan-azure-m10",
+		RawParameters: []byte(params),
+	}, true)
+
+	defer teardownInstance(instanceID)
+
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// Ensure the cluster is being created.
+	cluster, err := client.GetCluster(clusterName)
+	assert.NoError(t, err)
+	assert.Equal(t, atlas.ClusterStateCreating, cluster.StateName)
+
+	// Wait a maximum of 20 minutes for cluster to reach state idle.
+	err = waitForLastOperation(azureBroker, instanceID, brokerlib.OperationProvision, 20)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	cluster, err = client.GetCluster(clusterName)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "AZURE", cluster.ProviderSettings.ProviderName)
+	assert.Equal(t, "M10", cluster.ProviderSettings.InstanceSizeName)
+	assert.Equal(t, "US_EAST_2", cluster.ProviderSettings.RegionName)
+}
+
 func TestProvisionProvidersConfig(t *testing.T) {
 	t.Parallel()
 
@@ -433,6 +484,7 @@ func TestBind(t *testing.T) {
 		},
 	}
 	assert.Equal(t, expectedRoles, user.Roles)
+	assert.Equal(t, []atlas.Scope{{Name: clusterName, Type: atlas.ScopeTypeCluster}}, user.Scopes)
 
 	credentials, ok := spec.Credentials.(brokerlib.ConnectionDetails)
 	if !assert.True(t, ok, "Expected credentials to have type broker.ConnectionDetails") {
@@ -446,16 +498,18 @@ func TestBind(t *testing.T) {
 	}
 
 	// Ensure the MongoDB username is the binding ID, that the password is not
-	// empty and that the connection URI matches the cluster's.
+	// empty and that the connection URI is the cluster's with the default
+	// appName.
+	appName := strings.Split(instanceID, "-")[0] + "-" + strings.Split(bindingID, "-")[0]
 	assert.Equal(t, bindingID, credentials.Username)
 	assert.NotEmpty(t, credentials.Password, "Expected non-empty password")
-	assert.Equal(t, cluster.SrvAddress, credentials.URI)
+	assert.Equal(t, cluster.SrvAddress+"/?appName="+appName, credentials.URI)
 
 	// Ensure the cluster can be connected to with the generated credentials.
 	// We need to reset the auth source using a parameter otherwise the Go
 	// MongoDB library will fail to parse the connection string.
 	conn := options.Client().
-		ApplyURI(credentials.URI + "/?authSource=").
+		ApplyURI(credentials.URI + "&authSource=").
 		SetAuth(options.Credential{
 			Username:    credentials.Username,
 			Password:    credentials.Password,
@@ -613,5 +667,5 @@ func teardownInstance(instanceID string) {
 }
 
 func teardownBinding(bindingID string) {
-	client.DeleteUser(bindingID)
+	client.DeleteUser(atlas.AuthDatabaseAdmin, bindingID)
 }
@@ -8,14 +8,24 @@ import (
 	"os"
 	"time"
 
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
 	"gopkg.in/yaml.v2"
 )
 
+// pollInterval is the time between two calls of the function passed to Poll.
+const pollInterval = 10 * time.Second
+
 // Poll will run f every 10 seconds until it returns true or the timout is reached.
 func Poll(timeoutMinutes int, f func() (bool, error)) error {
-	pollInterval := 10
+	return PollClock(clock.Real, time.Duration(timeoutMinutes)*time.Minute, pollInterval, f)
+}
+
+// PollClock will run f every interval on the clock until it returns true or
+// the timeout is reached.
+func PollClock(c clock.Clock, timeout time.Duration, interval time.Duration, f func() (bool, error)) error {
+	deadline := c.Now().Add(timeout)
 
-	for i := 0; i < timeoutMinutes*60; i++ {
+	for {
 		res, err := f()
 		if err != nil {
 			return err
@@ -25,11 +35,12 @@ func Poll(timeoutMinutes int, f func() (bool, error)) error {
 			return nil
 		}
 
-		i += pollInterval
-		time.Sleep(time.Duration(pollInterval) * time.Second)
-	}
+		if !c.Now().Add(interval).Before(deadline) {
+			return fmt.Errorf("timeout while polling (waited %s)", timeout)
+		}
 
-	return fmt.Errorf("timeout while polling (waited %d minutes)", timeoutMinutes)
+		c.Sleep(interval)
+	}
 }
 
 // GetEnvOrPanic will get an environment variable, panicking if it does not exist.
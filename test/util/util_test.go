@@ -0,0 +1,50 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mongodb/mongodb-atlas-service-broker/pkg/clock"
+	"github.com/stretchr/testify/assert"
+)
+
+var testTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func TestPollClock(t *testing.T) {
+	c := clock.NewFake(testTime)
+
+	calls := 0
+	err := PollClock(c, time.Minute, 10*time.Second, func() (bool, error) {
+		calls++
+		return calls == 3, nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, []time.Duration{10 * time.Second, 10 * time.Second}, c.Slept())
+}
+
+func TestPollClockTimeout(t *testing.T) {
+	c := clock.NewFake(testTime)
+
+	calls := 0
+	err := PollClock(c, time.Minute, 10*time.Second, func() (bool, error) {
+		calls++
+		return false, nil
+	})
+
+	assert.EqualError(t, err, "timeout while polling (waited 1m0s)")
+	assert.Equal(t, 6, calls)
+	assert.Equal(t, testTime.Add(50*time.Second), c.Now())
+}
+
+func TestPollClockError(t *testing.T) {
+	c := clock.NewFake(testTime)
+
+	err := PollClock(c, time.Minute, 10*time.Second, func() (bool, error) {
+		return false, errors.New("failed")
+	})
+
+	assert.EqualError(t, err, "failed")
+	assert.Empty(t, c.Slept())
+}